@@ -0,0 +1,454 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package adminhttp provides an HTTP handler for inspecting a KVStore: listing shelves, looking up keys and downloading exports.
+package adminhttp
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/dump"
+	"github.com/nuts-foundation/go-stoabs/util"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultLimit is the default number of keys returned when listing the keys of a shelf.
+const defaultLimit = 100
+
+// maxLimit is the maximum number of keys returned when listing the keys of a shelf.
+const maxLimit = 1000
+
+// previewSize is the maximum number of bytes of a value included in a key listing.
+const previewSize = 64
+
+// Authenticator decides whether a request is allowed.
+type Authenticator func(r *http.Request) bool
+
+// Option configures the handler.
+type Option func(h *handler)
+
+// WithAuthenticator specifies the function that authenticates requests.
+func WithAuthenticator(authenticator Authenticator) Option {
+	return func(h *handler) {
+		h.authenticate = authenticator
+	}
+}
+
+// WithBasicAuth authenticates requests using HTTP basic authentication with the given credentials.
+func WithBasicAuth(username, password string) Option {
+	return WithAuthenticator(func(r *http.Request) bool {
+		u, p, ok := r.BasicAuth()
+		return ok && equal(u, username) && equal(p, password)
+	})
+}
+
+// WithBearerToken authenticates requests that specify the given token in the Authorization header.
+// An empty token rejects all requests, since it would match a header without token.
+func WithBearerToken(token string) Option {
+	return WithAuthenticator(func(r *http.Request) bool {
+		return token != "" && equal(r.Header.Get("Authorization"), "Bearer "+token)
+	})
+}
+
+// WithLogger overrides the default logger.
+func WithLogger(log *logrus.Logger) Option {
+	return func(h *handler) {
+		h.log = log
+	}
+}
+
+// Handler returns an HTTP handler exposing the given store for inspection. It serves:
+//
+//	GET /stats                           statistics of the store (requires the store to implement stoabs.StatsReader)
+//	GET /shelves                         names and stats of all shelves (requires the store to implement stoabs.ShelfLister)
+//	GET /shelves/{shelf}                 stats of a shelf
+//	GET /shelves/{shelf}/keys            keys of a shelf, with a preview of their values (query: from, limit)
+//	                                     lists keys not smaller than from in byte order, the Next-From header contains
+//	                                     the (base64 encoded) from of the next page if the limit was reached
+//	GET /shelves/{shelf}/keys/{key}      value of a key (query: raw=true to download the value as is)
+//	GET /export                          export of the store in the format of dump.Export (query: shelf, may be repeated)
+//
+// Keys in paths and queries are interpreted as text, unless the encoding query parameter specifies hex or base64.
+// Keys are read as stoabs.BytesKey, see dump.Export for the implications for Redis.
+// Requests are authenticated using the authenticator specified by WithAuthenticator, WithBasicAuth or WithBearerToken.
+// If none is specified, all requests are rejected. Use http.StripPrefix to mount the handler on a sub path.
+func Handler(store stoabs.KVStore, opts ...Option) http.Handler {
+	h := &handler{
+		store: store,
+		log:   logrus.StandardLogger(),
+		authenticate: func(_ *http.Request) bool {
+			return false
+		},
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /stats", h.getStats)
+	mux.HandleFunc("GET /shelves", h.listShelves)
+	mux.HandleFunc("GET /shelves/{shelf}", h.getShelf)
+	mux.HandleFunc("GET /shelves/{shelf}/keys", h.listKeys)
+	mux.HandleFunc("GET /shelves/{shelf}/keys/{key}", h.getKey)
+	mux.HandleFunc("GET /export", h.export)
+	h.mux = mux
+	return h
+}
+
+// Stats describes the statistics of the store, see stoabs.StoreStats. Timestamps are omitted if unknown.
+type Stats struct {
+	Size             uint64     `json:"size"`
+	FreePages        uint64     `json:"freePages"`
+	MemoryUsage      uint64     `json:"memoryUsage"`
+	MemoryLimit      uint64     `json:"memoryLimit"`
+	EvictionPolicy   string     `json:"evictionPolicy,omitempty"`
+	Shelves          int        `json:"shelves"`
+	OpenTransactions int        `json:"openTransactions"`
+	Conflicts        uint64     `json:"conflicts"`
+	Evictions        uint64     `json:"evictions"`
+	LastCompaction   *time.Time `json:"lastCompaction,omitempty"`
+	LastBackup       *time.Time `json:"lastBackup,omitempty"`
+	// Pages is only present for page-based stores (BBolt).
+	Pages *PageStats `json:"pages,omitempty"`
+	// Disk is only present for stores that report disk statistics (BBolt).
+	Disk *DiskStats `json:"disk,omitempty"`
+}
+
+// PageStats describes the page-level statistics of the store, see stoabs.PageStats. Durations are in seconds.
+type PageStats struct {
+	PageAllocations    uint64  `json:"pageAllocations"`
+	PageAllocatedBytes uint64  `json:"pageAllocatedBytes"`
+	NodeAllocations    uint64  `json:"nodeAllocations"`
+	NodeDereferences   uint64  `json:"nodeDereferences"`
+	Rebalances         uint64  `json:"rebalances"`
+	RebalanceSeconds   float64 `json:"rebalanceSeconds"`
+	Splits             uint64  `json:"splits"`
+	Spills             uint64  `json:"spills"`
+	SpillSeconds       float64 `json:"spillSeconds"`
+	Writes             uint64  `json:"writes"`
+	WriteSeconds       float64 `json:"writeSeconds"`
+	FreeBytes          uint64  `json:"freeBytes"`
+	FreelistBytes      uint64  `json:"freelistBytes"`
+	ReadTransactions   uint64  `json:"readTransactions"`
+}
+
+// DiskStats describes the statistics about writing committed transactions to disk, see stoabs.DiskStats. Durations are in seconds.
+type DiskStats struct {
+	Commits            uint64  `json:"commits"`
+	BytesPut           uint64  `json:"bytesPut"`
+	BytesWritten       uint64  `json:"bytesWritten"`
+	Syncs              uint64  `json:"syncs"`
+	SyncSeconds        float64 `json:"syncSeconds"`
+	WriteAmplification float64 `json:"writeAmplification"`
+}
+
+// Shelf describes a shelf.
+type Shelf struct {
+	Name    string `json:"name"`
+	Entries uint   `json:"entries"`
+	Size    uint   `json:"size"`
+}
+
+// Entry describes a key and its value. Value is base64 encoded in JSON, Text contains the value if it's printable text.
+type Entry struct {
+	Key     []byte `json:"key"`
+	KeyText string `json:"keyText,omitempty"`
+	Size    int    `json:"size"`
+	Value   []byte `json:"value"`
+	Text    string `json:"text,omitempty"`
+	// Truncated indicates Value and Text only contain the start of the value.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+type handler struct {
+	store        stoabs.KVStore
+	log          *logrus.Logger
+	authenticate Authenticator
+	mux          *http.ServeMux
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authenticate(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="stoabs"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *handler) getStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := stoabs.Stats(r.Context(), h.store)
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+	result := Stats{
+		Size:             stats.Size,
+		FreePages:        stats.FreePages,
+		MemoryUsage:      stats.MemoryUsage,
+		MemoryLimit:      stats.MemoryLimit,
+		EvictionPolicy:   stats.EvictionPolicy,
+		Shelves:          stats.Shelves,
+		OpenTransactions: stats.OpenTransactions,
+		Conflicts:        stats.Conflicts,
+		Evictions:        stats.Evictions,
+		LastCompaction:   timestamp(stats.LastCompaction),
+		LastBackup:       timestamp(stats.LastBackup),
+	}
+	if pages := stats.Pages; pages != nil {
+		result.Pages = &PageStats{
+			PageAllocations:    pages.PageAllocations,
+			PageAllocatedBytes: pages.PageAllocatedBytes,
+			NodeAllocations:    pages.NodeAllocations,
+			NodeDereferences:   pages.NodeDereferences,
+			Rebalances:         pages.Rebalances,
+			RebalanceSeconds:   pages.RebalanceDuration.Seconds(),
+			Splits:             pages.Splits,
+			Spills:             pages.Spills,
+			SpillSeconds:       pages.SpillDuration.Seconds(),
+			Writes:             pages.Writes,
+			WriteSeconds:       pages.WriteDuration.Seconds(),
+			FreeBytes:          pages.FreeBytes,
+			FreelistBytes:      pages.FreelistBytes,
+			ReadTransactions:   pages.ReadTransactions,
+		}
+	}
+	if disk := stats.Disk; disk != nil {
+		result.Disk = &DiskStats{
+			Commits:            disk.Commits,
+			BytesPut:           disk.BytesPut,
+			BytesWritten:       disk.BytesWritten,
+			Syncs:              disk.Syncs,
+			SyncSeconds:        disk.SyncDuration.Seconds(),
+			WriteAmplification: disk.WriteAmplification(),
+		}
+	}
+	h.json(w, result)
+}
+
+// timestamp returns a pointer to the given time, or nil if it's zero.
+func timestamp(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func (h *handler) listShelves(w http.ResponseWriter, r *http.Request) {
+	names, err := stoabs.ShelfNames(r.Context(), h.store)
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+	shelves := make([]Shelf, 0, len(names))
+	err = h.store.Read(r.Context(), func(tx stoabs.ReadTx) error {
+		for _, name := range names {
+			shelves = append(shelves, shelf(name, tx.GetShelfReader(name)))
+		}
+		return nil
+	})
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+	h.json(w, shelves)
+}
+
+func (h *handler) getShelf(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("shelf")
+	var result Shelf
+	err := h.store.ReadShelf(r.Context(), name, func(reader stoabs.Reader) error {
+		result = shelf(name, reader)
+		return nil
+	})
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+	h.json(w, result)
+}
+
+func (h *handler) listKeys(w http.ResponseWriter, r *http.Request) {
+	limit := defaultLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(limit, maxLimit)
+	}
+	var from []byte
+	if value := r.URL.Query().Get("from"); value != "" {
+		var err error
+		if from, err = decodeKey(value, r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	entries := make([]Entry, 0)
+	err := h.store.ReadShelf(r.Context(), r.PathValue("shelf"), func(reader stoabs.Reader) error {
+		// Range can't be used, since it requires an upper bound and visits every possible key on some stores (e.g. Redis).
+		// Iterate visits the keys in an order that depends on the store, so the smallest keys are collected instead.
+		return reader.Iterate(func(key stoabs.Key, value []byte) error {
+			if bytes.Compare(key.Bytes(), from) < 0 {
+				return nil
+			}
+			result := entry(bytes.Clone(key.Bytes()), value, previewSize)
+			// the value may only be valid during the transaction
+			result.Value = bytes.Clone(result.Value)
+			entries = append(entries, result)
+			if len(entries) >= 2*limit {
+				entries = smallest(entries, limit)
+			}
+			return nil
+		}, stoabs.BytesKey{})
+	})
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+	entries = smallest(entries, limit)
+	if len(entries) == limit {
+		next := append(bytes.Clone(entries[limit-1].Key), 0)
+		w.Header().Set("Next-From", base64.URLEncoding.EncodeToString(next))
+	}
+	h.json(w, entries)
+}
+
+// smallest returns at most limit entries with the smallest keys, sorted by key.
+func smallest(entries []Entry, limit int) []Entry {
+	slices.SortFunc(entries, func(a, b Entry) int {
+		return bytes.Compare(a.Key, b.Key)
+	})
+	if len(entries) > limit {
+		return entries[:limit]
+	}
+	return entries
+}
+
+func (h *handler) getKey(w http.ResponseWriter, r *http.Request) {
+	key, err := decodeKey(r.PathValue("key"), r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var value []byte
+	err = h.store.ReadShelf(r.Context(), r.PathValue("shelf"), func(reader stoabs.Reader) error {
+		value, err = reader.Get(stoabs.BytesKey(key))
+		return err
+	})
+	if err != nil {
+		h.error(w, r, err)
+		return
+	}
+	if r.URL.Query().Get("raw") == "true" {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = w.Write(value)
+		return
+	}
+	h.json(w, entry(key, value, len(value)))
+}
+
+func (h *handler) export(w http.ResponseWriter, r *http.Request) {
+	shelves := r.URL.Query()["shelf"]
+	h.log.WithField("shelves", shelves).Info("Exporting store over HTTP")
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="export.ndjson"`)
+	if err := dump.Export(r.Context(), h.store, w, shelves...); err != nil {
+		// the status has already been sent, so the export is truncated
+		h.log.WithError(err).Error("HTTP export failed")
+	}
+}
+
+func (h *handler) json(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		h.log.WithError(err).Warn("Unable to write HTTP response")
+	}
+}
+
+func (h *handler) error(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, stoabs.ErrKeyNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errors.ErrUnsupported):
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+	default:
+		h.log.WithError(err).Errorf("HTTP request failed: %s", r.URL.Path)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+	}
+}
+
+func shelf(name string, reader stoabs.Reader) Shelf {
+	stats := reader.Stats()
+	return Shelf{Name: name, Entries: stats.NumEntries, Size: stats.ShelfSize}
+}
+
+func entry(key []byte, value []byte, maxSize int) Entry {
+	result := Entry{Key: key, KeyText: text(key, false), Size: len(value), Value: value}
+	if len(value) > maxSize {
+		result.Value = value[:maxSize]
+		result.Truncated = true
+	}
+	result.Text = text(result.Value, true)
+	return result
+}
+
+// text returns the data as string if it's printable UTF-8 (see util.IsPrintable), otherwise an empty string.
+func text(data []byte, multiline bool) string {
+	if !util.IsPrintable(data, multiline) {
+		return ""
+	}
+	return string(data)
+}
+
+func decodeKey(value string, r *http.Request) ([]byte, error) {
+	switch encoding := r.URL.Query().Get("encoding"); encoding {
+	case "", "text":
+		return []byte(value), nil
+	case "hex":
+		key, err := hex.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex key: %w", err)
+		}
+		return key, nil
+	case "base64":
+		key, err := base64.URLEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 key: %w", err)
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported key encoding: %s", encoding)
+	}
+}
+
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,253 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package adminhttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/dump"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+const token = "secret"
+
+func TestHandler(t *testing.T) {
+	store := stores.BBolt(t)
+	err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+		writer := tx.GetShelfWriter("users")
+		for i := 0; i < 5; i++ {
+			if err := writer.Put(stoabs.BytesKey(fmt.Sprintf("user-%d", i)), []byte(fmt.Sprintf("name-%d", i))); err != nil {
+				return err
+			}
+		}
+		return tx.GetShelfWriter("blobs").Put(stoabs.BytesKey{0xff, 0x01}, bytes.Repeat([]byte{0x00}, 100))
+	})
+	require.NoError(t, err)
+	handler := Handler(store, WithBearerToken(token))
+
+	t.Run("stats", func(t *testing.T) {
+		var stats Stats
+
+		response := get(t, handler, "/stats", &stats)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, 2, stats.Shelves)
+		assert.Less(t, uint64(0), stats.Size)
+		assert.Nil(t, stats.LastBackup)
+		assert.NotContains(t, response.Body.String(), "lastBackup")
+		require.NotNil(t, stats.Pages)
+		assert.Less(t, uint64(0), stats.Pages.Writes)
+		require.NotNil(t, stats.Disk)
+		assert.Less(t, uint64(0), stats.Disk.Commits)
+	})
+	t.Run("list shelves", func(t *testing.T) {
+		var shelves []Shelf
+
+		response := get(t, handler, "/shelves", &shelves)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		require.Len(t, shelves, 2)
+		assert.Equal(t, "blobs", shelves[0].Name)
+		assert.Equal(t, Shelf{Name: "users", Entries: 5, Size: shelves[1].Size}, shelves[1])
+	})
+	t.Run("shelf stats", func(t *testing.T) {
+		var shelf Shelf
+
+		get(t, handler, "/shelves/users", &shelf)
+
+		assert.Equal(t, "users", shelf.Name)
+		assert.Equal(t, uint(5), shelf.Entries)
+	})
+	t.Run("list keys", func(t *testing.T) {
+		var entries []Entry
+
+		get(t, handler, "/shelves/users/keys?from=user-1&limit=2", &entries)
+
+		require.Len(t, entries, 2)
+		assert.Equal(t, "user-1", entries[0].KeyText)
+		assert.Equal(t, "name-1", entries[0].Text)
+		assert.Equal(t, "user-2", entries[1].KeyText)
+	})
+	t.Run("list keys in pages", func(t *testing.T) {
+		var keys []string
+		target := "/shelves/users/keys?limit=2"
+		for target != "" {
+			var entries []Entry
+			response := get(t, handler, target, &entries)
+			for _, entry := range entries {
+				keys = append(keys, entry.KeyText)
+			}
+			target = ""
+			if next := response.Header().Get("Next-From"); next != "" {
+				target = "/shelves/users/keys?limit=2&encoding=base64&from=" + next
+			}
+		}
+
+		assert.Equal(t, []string{"user-0", "user-1", "user-2", "user-3", "user-4"}, keys)
+	})
+	t.Run("list keys truncates values", func(t *testing.T) {
+		var entries []Entry
+
+		get(t, handler, "/shelves/blobs/keys", &entries)
+
+		require.Len(t, entries, 1)
+		assert.Equal(t, []byte{0xff, 0x01}, entries[0].Key)
+		assert.Empty(t, entries[0].KeyText)
+		assert.Equal(t, 100, entries[0].Size)
+		assert.Len(t, entries[0].Value, previewSize)
+		assert.True(t, entries[0].Truncated)
+	})
+	t.Run("list keys of unknown shelf", func(t *testing.T) {
+		var entries []Entry
+
+		response := get(t, handler, "/shelves/unknown/keys", &entries)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Empty(t, entries)
+	})
+	t.Run("invalid limit", func(t *testing.T) {
+		response := get(t, handler, "/shelves/users/keys?limit=0", nil)
+
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+	t.Run("get key", func(t *testing.T) {
+		var entry Entry
+
+		get(t, handler, "/shelves/users/keys/user-3", &entry)
+
+		assert.Equal(t, "name-3", entry.Text)
+		assert.False(t, entry.Truncated)
+	})
+	t.Run("get key using hex encoding, raw", func(t *testing.T) {
+		response := get(t, handler, "/shelves/blobs/keys/ff01?encoding=hex&raw=true", nil)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "application/octet-stream", response.Header().Get("Content-Type"))
+		assert.Equal(t, bytes.Repeat([]byte{0x00}, 100), response.Body.Bytes())
+	})
+	t.Run("get unknown key", func(t *testing.T) {
+		response := get(t, handler, "/shelves/users/keys/unknown", nil)
+
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+	t.Run("invalid key encoding", func(t *testing.T) {
+		response := get(t, handler, "/shelves/users/keys/zz?encoding=hex", nil)
+
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+	t.Run("export", func(t *testing.T) {
+		response := get(t, handler, "/export?shelf=users", nil)
+
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Contains(t, response.Header().Get("Content-Disposition"), "attachment")
+		target := stores.BBolt(t)
+		require.NoError(t, dump.Import(ctx, target, response.Body))
+		names, _ := stoabs.ShelfNames(ctx, target)
+		assert.Equal(t, []string{"users"}, names)
+	})
+}
+
+func TestHandler_authentication(t *testing.T) {
+	store := stores.BBolt(t)
+
+	t.Run("rejects all requests by default", func(t *testing.T) {
+		response := serve(Handler(store), "/shelves", nil)
+
+		assert.Equal(t, http.StatusUnauthorized, response.Code)
+	})
+	t.Run("bearer token", func(t *testing.T) {
+		handler := Handler(store, WithBearerToken(token))
+
+		assert.Equal(t, http.StatusUnauthorized, serve(handler, "/shelves", func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer wrong")
+		}).Code)
+		assert.Equal(t, http.StatusOK, serve(handler, "/shelves", func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}).Code)
+	})
+	t.Run("empty bearer token rejects all requests", func(t *testing.T) {
+		handler := Handler(store, WithBearerToken(""))
+
+		assert.Equal(t, http.StatusUnauthorized, serve(handler, "/shelves", func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer ")
+		}).Code)
+	})
+	t.Run("basic auth", func(t *testing.T) {
+		handler := Handler(store, WithBasicAuth("admin", "password"))
+
+		assert.Equal(t, http.StatusUnauthorized, serve(handler, "/shelves", func(r *http.Request) {
+			r.SetBasicAuth("admin", "wrong")
+		}).Code)
+		assert.Equal(t, http.StatusOK, serve(handler, "/shelves", func(r *http.Request) {
+			r.SetBasicAuth("admin", "password")
+		}).Code)
+	})
+}
+
+func Test_smallest(t *testing.T) {
+	entries := []Entry{{Key: []byte("c")}, {Key: []byte("a")}, {Key: []byte("d")}, {Key: []byte("b")}}
+
+	actual := smallest(entries, 2)
+
+	assert.Equal(t, []Entry{{Key: []byte("a")}, {Key: []byte("b")}}, actual)
+}
+
+func TestHandler_unsupported(t *testing.T) {
+	handler := Handler(struct{ stoabs.KVStore }{stores.BBolt(t)}, WithBearerToken(token))
+
+	for _, target := range []string{"/shelves", "/stats"} {
+		response := get(t, handler, target, nil)
+
+		assert.Equal(t, http.StatusNotImplemented, response.Code, target)
+	}
+}
+
+func get(t *testing.T, handler http.Handler, target string, result interface{}) *httptest.ResponseRecorder {
+	response := serve(handler, target, func(r *http.Request) {
+		r.Header.Set("Authorization", "Bearer "+token)
+	})
+	if result != nil {
+		require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+		require.True(t, strings.HasPrefix(response.Header().Get("Content-Type"), "application/json"))
+		require.NoError(t, json.Unmarshal(response.Body.Bytes(), result))
+	}
+	return response
+}
+
+func serve(handler http.Handler, target string, modifier func(r *http.Request)) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodGet, target, nil)
+	if modifier != nil {
+		modifier(request)
+	}
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	return response
+}
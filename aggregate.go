@@ -0,0 +1,118 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Aggregator folds the key/value pairs of a range into an aggregate, see Aggregate.
+type Aggregator interface {
+	// Add folds the key/value pair into the aggregate.
+	Add(key Key, value []byte) error
+}
+
+// AggregatorFn is an Aggregator implemented by a function.
+type AggregatorFn func(key Key, value []byte) error
+
+// Add calls the function.
+func (fn AggregatorFn) Add(key Key, value []byte) error {
+	return fn(key, value)
+}
+
+// RangeAggregator is implemented by Readers that can compute aggregates without passing every value to the client,
+// e.g. server-side.
+type RangeAggregator interface {
+	// Aggregate computes the aggregate over the pairs from (inclusive) and to (exclusive) given keys.
+	// It returns errors.ErrUnsupported if it can't compute the given aggregator, in which case the caller falls back to Range.
+	Aggregate(from Key, to Key, aggregator Aggregator) error
+}
+
+// Aggregate folds the key/value pairs from (inclusive) and to (exclusive) given keys into the aggregator.
+// If the reader implements RangeAggregator and supports the aggregator (typically the built-in Count, SumUint64, MinKey and MaxKey),
+// the aggregate is computed by the reader. Otherwise, the pairs are passed to the aggregator using Reader.Range.
+func Aggregate(reader Reader, from Key, to Key, aggregator Aggregator) error {
+	if rangeAggregator, ok := reader.(RangeAggregator); ok {
+		err := rangeAggregator.Aggregate(from, to, aggregator)
+		if !errors.Is(err, errors.ErrUnsupported) {
+			return err
+		}
+	}
+	return reader.Range(from, to, aggregator.Add, false)
+}
+
+// Count counts the pairs.
+type Count struct {
+	N int
+}
+
+// Add counts the pair.
+func (c *Count) Add(_ Key, _ []byte) error {
+	c.N++
+	return nil
+}
+
+// SumUint64 sums the values, which must be 8-byte big-endian unsigned integers. The sum wraps around on overflow.
+type SumUint64 struct {
+	Sum uint64
+}
+
+// Add adds the value to the sum, or returns an error if it's not 8 bytes long.
+func (s *SumUint64) Add(key Key, value []byte) error {
+	if len(value) != 8 {
+		return InvalidUint64Error(key)
+	}
+	s.Sum += binary.BigEndian.Uint64(value)
+	return nil
+}
+
+// InvalidUint64Error returns the error SumUint64 returns for a value that isn't an 8-byte unsigned integer.
+// It's exported for RangeAggregator implementations.
+func InvalidUint64Error(key Key) error {
+	return fmt.Errorf("value of key %s is not an uint64", key)
+}
+
+// MinKey determines the lowest key. Key is nil if there are no pairs.
+type MinKey struct {
+	Key Key
+}
+
+// Add records the key if it's lower than the current lowest key.
+func (m *MinKey) Add(key Key, _ []byte) error {
+	if m.Key == nil || bytes.Compare(key.Bytes(), m.Key.Bytes()) < 0 {
+		m.Key = key
+	}
+	return nil
+}
+
+// MaxKey determines the highest key. Key is nil if there are no pairs.
+type MaxKey struct {
+	Key Key
+}
+
+// Add records the key if it's higher than the current highest key.
+func (m *MaxKey) Add(key Key, _ []byte) error {
+	if m.Key == nil || bytes.Compare(key.Bytes(), m.Key.Bytes()) > 0 {
+		m.Key = key
+	}
+	return nil
+}
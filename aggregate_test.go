@@ -0,0 +1,89 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// unsupportedAggregator is a Reader that doesn't support any aggregator.
+type unsupportedAggregator struct {
+	stoabs.Reader
+	calls int
+}
+
+func (u *unsupportedAggregator) Aggregate(_ stoabs.Key, _ stoabs.Key, _ stoabs.Aggregator) error {
+	u.calls++
+	return errors.ErrUnsupported
+}
+
+func TestAggregate(t *testing.T) {
+	ctx := context.Background()
+	store := stores.BBolt(t)
+	require.NoError(t, store.WriteShelf(ctx, "numbers", func(writer stoabs.Writer) error {
+		for i := 1; i <= 10; i++ {
+			if err := writer.Put(stoabs.Uint32Key(i), []byte{0, 0, 0, 0, 0, 0, 0, byte(i)}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	t.Run("falls back to Range if unsupported", func(t *testing.T) {
+		var sum stoabs.SumUint64
+		err := store.ReadShelf(ctx, "numbers", func(reader stoabs.Reader) error {
+			unsupported := &unsupportedAggregator{Reader: reader}
+			defer func() {
+				assert.Equal(t, 1, unsupported.calls)
+			}()
+			return stoabs.Aggregate(unsupported, stoabs.Uint32Key(1), stoabs.Uint32Key(5), &sum)
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, uint64(10), sum.Sum)
+	})
+	t.Run("errors of the aggregator are returned", func(t *testing.T) {
+		err := store.ReadShelf(ctx, "numbers", func(reader stoabs.Reader) error {
+			return stoabs.Aggregate(reader, stoabs.Uint32Key(1), stoabs.Uint32Key(5), stoabs.AggregatorFn(func(_ stoabs.Key, _ []byte) error {
+				return errors.New("failed")
+			}))
+		})
+
+		assert.EqualError(t, err, "failed")
+	})
+}
+
+func TestMinKey_Add(t *testing.T) {
+	var minKey stoabs.MinKey
+	var maxKey stoabs.MaxKey
+	for _, key := range []stoabs.Key{stoabs.BytesKey("b"), stoabs.BytesKey("a"), stoabs.BytesKey("c")} {
+		_ = minKey.Add(key, nil)
+		_ = maxKey.Add(key, nil)
+	}
+
+	assert.Equal(t, stoabs.BytesKey("a"), minKey.Key)
+	assert.Equal(t, stoabs.BytesKey("c"), maxKey.Key)
+}
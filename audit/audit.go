@@ -0,0 +1,251 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package audit provides an interceptor that emits a structured audit record for every committed mutation,
+// to find out who changed a record.
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/rand/v2"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/sirupsen/logrus"
+)
+
+var _ stoabs.Interceptor = (*Interceptor)(nil)
+
+// modulePath is the import path of the stoabs module, whose frames are skipped when determining the caller.
+const modulePath = "github.com/nuts-foundation/go-stoabs"
+
+// Operation is the kind of mutation recorded in a Record.
+type Operation string
+
+const (
+	// OperationPut is recorded for Writer.Put.
+	OperationPut Operation = "put"
+	// OperationDelete is recorded for Writer.Delete.
+	OperationDelete Operation = "delete"
+)
+
+// Record describes a committed mutation.
+type Record struct {
+	Operation Operation
+	Shelf     string
+	// KeyHash is the hex encoded SHA-256 hash of the key, so records can be correlated without revealing the key.
+	KeyHash string
+	// Key is the key as string (see stoabs.Key), only set for the shelves specified with WithPlainKeys.
+	Key string
+	// Size is the size of the written value in bytes, 0 for deletions.
+	Size int
+	// Label is the label of the transaction (see stoabs.WithLabel).
+	Label string
+	// Caller describes who performed the transaction, see WithCaller.
+	Caller string
+}
+
+// Option configures the audit interceptor.
+type Option func(i *Interceptor)
+
+// WithLogger overrides the logger the records are logged to (at info level), which defaults to the standard logger.
+func WithLogger(log *logrus.Logger) Option {
+	return func(i *Interceptor) {
+		i.emit = logRecord(log)
+	}
+}
+
+// WithEmitter specifies a function that is called for every record instead of logging it, e.g. to store it elsewhere.
+// It's called after the transaction was committed, in the goroutine that performed the transaction.
+func WithEmitter(emit func(record Record)) Option {
+	return func(i *Interceptor) {
+		i.emit = emit
+	}
+}
+
+// WithCaller specifies a function that describes who performed a transaction, given its context (e.g. the authenticated user of the request).
+// It defaults to the function (and source location) that started the transaction.
+func WithCaller(caller func(ctx context.Context) string) Option {
+	return func(i *Interceptor) {
+		i.caller = caller
+	}
+}
+
+// WithSampleRate specifies the fraction (between 0 and 1) of the records that is emitted, to limit the volume of high-traffic stores.
+// Records are sampled independently. It defaults to 1 (all records).
+func WithSampleRate(rate float64) Option {
+	return func(i *Interceptor) {
+		i.sampleRate = rate
+	}
+}
+
+// WithPlainKeys specifies shelves of which the keys don't contain sensitive data, so records contain the key itself besides its hash.
+func WithPlainKeys(shelfNames ...string) Option {
+	return func(i *Interceptor) {
+		for _, shelfName := range shelfNames {
+			i.plainKeys[shelfName] = true
+		}
+	}
+}
+
+// WithRedactor specifies a function that is applied to every record before it's emitted, e.g. to remove or mask fields.
+// If it returns false, the record isn't emitted.
+func WithRedactor(redact func(record Record) (Record, bool)) Option {
+	return func(i *Interceptor) {
+		i.redact = redact
+	}
+}
+
+// New creates an interceptor (see stoabs.Chain) that emits a Record for every Put and Delete of a write transaction,
+// after the transaction was committed. Mutations of transactions that are rolled back aren't recorded.
+// Records are logged with their fields at info level, unless an emitter is specified (see WithEmitter).
+func New(opts ...Option) *Interceptor {
+	result := &Interceptor{
+		emit:       logRecord(logrus.StandardLogger()),
+		caller:     callerLocation,
+		sampleRate: 1,
+		plainKeys:  map[string]bool{},
+	}
+	for _, opt := range opts {
+		opt(result)
+	}
+	return result
+}
+
+// Interceptor emits audit records for committed mutations, see New.
+type Interceptor struct {
+	stoabs.NoopInterceptor
+	emit       func(record Record)
+	caller     func(ctx context.Context) string
+	sampleRate float64
+	plainKeys  map[string]bool
+	redact     func(record Record) (Record, bool)
+}
+
+type recordsKey struct{}
+
+// records holds the records of a transaction until it's committed.
+type records struct {
+	mux     sync.Mutex
+	records []Record
+}
+
+func (i *Interceptor) Transaction(ctx context.Context, tx stoabs.TxInfo, next func(ctx context.Context) error) error {
+	if !tx.Writable {
+		return next(ctx)
+	}
+	pending := &records{}
+	caller := i.caller(ctx)
+	label := (stoabs.LabelOption{}).Label(tx.Options)
+	err := next(context.WithValue(ctx, recordsKey{}, pending))
+	if err != nil {
+		return err
+	}
+	for _, record := range pending.records {
+		record.Label = label
+		record.Caller = caller
+		if i.redact != nil {
+			var ok bool
+			if record, ok = i.redact(record); !ok {
+				continue
+			}
+		}
+		i.emit(record)
+	}
+	return nil
+}
+
+func (i *Interceptor) Put(call stoabs.Call, key stoabs.Key, value []byte, next func(key stoabs.Key, value []byte) error) error {
+	if err := next(key, value); err != nil {
+		return err
+	}
+	i.record(call, OperationPut, key, len(value))
+	return nil
+}
+
+func (i *Interceptor) Delete(call stoabs.Call, key stoabs.Key, next func(key stoabs.Key) error) error {
+	if err := next(key); err != nil {
+		return err
+	}
+	i.record(call, OperationDelete, key, 0)
+	return nil
+}
+
+// record adds a record for the given mutation to the transaction, if it's sampled.
+func (i *Interceptor) record(call stoabs.Call, operation Operation, key stoabs.Key, size int) {
+	pending, ok := call.Context.Value(recordsKey{}).(*records)
+	if !ok || (i.sampleRate < 1 && rand.Float64() >= i.sampleRate) {
+		return
+	}
+	hash := sha256.Sum256(key.Bytes())
+	record := Record{
+		Operation: operation,
+		Shelf:     call.Shelf,
+		KeyHash:   hex.EncodeToString(hash[:]),
+		Size:      size,
+	}
+	if i.plainKeys[call.Shelf] {
+		record.Key = key.String()
+	}
+	pending.mux.Lock()
+	defer pending.mux.Unlock()
+	pending.records = append(pending.records, record)
+}
+
+func logRecord(log *logrus.Logger) func(record Record) {
+	return func(record Record) {
+		fields := logrus.Fields{
+			"operation": record.Operation,
+			"shelf":     record.Shelf,
+			"keyHash":   record.KeyHash,
+			"size":      record.Size,
+		}
+		if record.Key != "" {
+			fields["key"] = record.Key
+		}
+		if record.Label != "" {
+			fields["label"] = record.Label
+		}
+		if record.Caller != "" {
+			fields["caller"] = record.Caller
+		}
+		log.WithFields(fields).Info("Audit: committed mutation")
+	}
+}
+
+// callerLocation returns the function (and source location) that started the transaction:
+// the first caller outside the stoabs module (except for its tests).
+func callerLocation(_ context.Context) string {
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, modulePath+".") && !strings.HasPrefix(frame.Function, modulePath+"/") ||
+			strings.HasSuffix(frame.File, "_test.go") {
+			return fmt.Sprintf("%s (%s:%d)", frame.Function, frame.File, frame.Line)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
@@ -0,0 +1,222 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+var key = stoabs.BytesKey("key")
+
+const shelfName = "test"
+
+func TestInterceptor(t *testing.T) {
+	provider := func(t *testing.T) (stoabs.KVStore, error) {
+		return stoabs.Chain(stores.BBolt(t), New(WithEmitter(func(Record) {}))), nil
+	}
+
+	kvtests.TestReadingAndWriting(t, provider)
+	kvtests.TestIterate(t, provider)
+	kvtests.TestDelete(t, provider)
+	kvtests.TestWriteTransactions(t, provider)
+}
+
+func TestInterceptor_Records(t *testing.T) {
+	hash := sha256.Sum256(key)
+	keyHash := hex.EncodeToString(hash[:])
+
+	t.Run("committed mutations", func(t *testing.T) {
+		var records []Record
+		store := stoabs.Chain(stores.BBolt(t), New(WithEmitter(func(record Record) {
+			records = append(records, record)
+		})))
+
+		err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+			writer := tx.GetShelfWriter(shelfName)
+			_ = writer.Put(key, []byte("value"))
+			return writer.Delete(key)
+		}, stoabs.WithLabel("import"))
+
+		require.NoError(t, err)
+		require.Len(t, records, 2)
+		assert.Equal(t, OperationPut, records[0].Operation)
+		assert.Equal(t, shelfName, records[0].Shelf)
+		assert.Equal(t, keyHash, records[0].KeyHash)
+		assert.Empty(t, records[0].Key)
+		assert.Equal(t, 5, records[0].Size)
+		assert.Equal(t, "import", records[0].Label)
+		assert.Contains(t, records[0].Caller, "TestInterceptor_Records")
+		assert.Contains(t, records[0].Caller, "audit_test.go")
+		assert.Equal(t, OperationDelete, records[1].Operation)
+		assert.Equal(t, 0, records[1].Size)
+	})
+	t.Run("rolled back mutations aren't recorded", func(t *testing.T) {
+		var records []Record
+		store := stoabs.Chain(stores.BBolt(t), New(WithEmitter(func(record Record) {
+			records = append(records, record)
+		})))
+
+		err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+			_ = tx.GetShelfWriter(shelfName).Put(key, []byte("value"))
+			return errors.New("failed")
+		})
+
+		assert.Error(t, err)
+		assert.Empty(t, records)
+	})
+	t.Run("WriteShelf", func(t *testing.T) {
+		var records []Record
+		store := stoabs.Chain(stores.BBolt(t), New(WithEmitter(func(record Record) {
+			records = append(records, record)
+		})))
+
+		err := store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			return writer.Put(key, []byte("value"))
+		})
+
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		assert.Equal(t, shelfName, records[0].Shelf)
+	})
+	t.Run("custom caller", func(t *testing.T) {
+		type userKey struct{}
+		var records []Record
+		store := stoabs.Chain(stores.BBolt(t), New(WithEmitter(func(record Record) {
+			records = append(records, record)
+		}), WithCaller(func(ctx context.Context) string {
+			return ctx.Value(userKey{}).(string)
+		})))
+
+		err := store.WriteShelf(context.WithValue(ctx, userKey{}, "alice"), shelfName, func(writer stoabs.Writer) error {
+			return writer.Put(key, []byte("value"))
+		})
+
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		assert.Equal(t, "alice", records[0].Caller)
+	})
+	t.Run("plain keys", func(t *testing.T) {
+		var records []Record
+		store := stoabs.Chain(stores.BBolt(t), New(WithEmitter(func(record Record) {
+			records = append(records, record)
+		}), WithPlainKeys(shelfName)))
+
+		err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+			_ = tx.GetShelfWriter("other").Put(key, []byte("value"))
+			return tx.GetShelfWriter(shelfName).Put(key, []byte("value"))
+		})
+
+		require.NoError(t, err)
+		require.Len(t, records, 2)
+		assert.Empty(t, records[0].Key)
+		assert.Equal(t, key.String(), records[1].Key)
+	})
+	t.Run("redaction", func(t *testing.T) {
+		var records []Record
+		store := stoabs.Chain(stores.BBolt(t), New(WithEmitter(func(record Record) {
+			records = append(records, record)
+		}), WithRedactor(func(record Record) (Record, bool) {
+			record.Caller = ""
+			return record, record.Shelf != "secret"
+		})))
+
+		err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+			_ = tx.GetShelfWriter("secret").Put(key, []byte("value"))
+			return tx.GetShelfWriter(shelfName).Put(key, []byte("value"))
+		})
+
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		assert.Equal(t, shelfName, records[0].Shelf)
+		assert.Empty(t, records[0].Caller)
+	})
+	t.Run("sampling", func(t *testing.T) {
+		count := 0
+		write := func(rate float64) {
+			count = 0
+			store := stoabs.Chain(stores.BBolt(t), New(WithEmitter(func(Record) {
+				count++
+			}), WithSampleRate(rate)))
+			err := store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+				for i := 0; i < 1000; i++ {
+					if err := writer.Put(stoabs.Uint32Key(i), []byte("value")); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			require.NoError(t, err)
+		}
+
+		write(0)
+		assert.Equal(t, 0, count)
+		write(0.5)
+		assert.Greater(t, count, 300)
+		assert.Less(t, count, 700)
+	})
+	t.Run("logs records by default", func(t *testing.T) {
+		logger, hook := test.NewNullLogger()
+		store := stoabs.Chain(stores.BBolt(t), New(WithLogger(logger)))
+
+		err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+			return tx.GetShelfWriter(shelfName).Put(key, []byte("value"))
+		}, stoabs.WithLabel("import"))
+
+		require.NoError(t, err)
+		require.Len(t, hook.Entries, 1)
+		entry := hook.LastEntry()
+		assert.Equal(t, logrus.InfoLevel, entry.Level)
+		assert.Equal(t, OperationPut, entry.Data["operation"])
+		assert.Equal(t, shelfName, entry.Data["shelf"])
+		assert.Equal(t, keyHash, entry.Data["keyHash"])
+		assert.Equal(t, 5, entry.Data["size"])
+		assert.Equal(t, "import", entry.Data["label"])
+		assert.True(t, strings.Contains(entry.Data["caller"].(string), "audit_test.go"))
+		assert.NotContains(t, entry.Data, "key")
+	})
+	t.Run("reads aren't recorded", func(t *testing.T) {
+		var records []Record
+		store := stoabs.Chain(stores.BBolt(t), New(WithEmitter(func(record Record) {
+			records = append(records, record)
+		})))
+
+		err := store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			_, err := reader.Get(key)
+			return err
+		})
+
+		assert.ErrorIs(t, err, stoabs.ErrKeyNotFound)
+		assert.Empty(t, records)
+	})
+}
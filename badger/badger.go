@@ -22,19 +22,32 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"github.com/dgraph-io/badger/v4"
 	"github.com/nuts-foundation/go-stoabs"
 	"github.com/nuts-foundation/go-stoabs/util"
 	"github.com/sirupsen/logrus"
+	"iter"
 	"os"
 	"path"
 	"sync"
+	"sync/atomic"
 )
 
+var _ stoabs.StateReporter = (*store)(nil)
+var _ stoabs.StatsReader = (*store)(nil)
+var _ stoabs.CapabilityReporter = (*store)(nil)
 var _ stoabs.ReadTx = (*tx)(nil)
 var _ stoabs.WriteTx = (*tx)(nil)
 var _ stoabs.Reader = (*badgerShelf)(nil)
 var _ stoabs.Writer = (*badgerShelf)(nil)
+var _ stoabs.LazyRanger = (*badgerShelf)(nil)
+var _ stoabs.EntryIterator = (*badgerShelf)(nil)
+var _ stoabs.KeyHistogrammer = (*badgerShelf)(nil)
+var _ stoabs.Sampler = (*badgerShelf)(nil)
+
+// errStopped stops a scan of Entries when the loop broke.
+var errStopped = errors.New("iteration stopped")
 
 // CreateBadgerStore creates a new Badger-backed KV store.
 func CreateBadgerStore(filePath string, opts ...stoabs.Option) (stoabs.KVStore, error) {
@@ -75,20 +88,54 @@ func createBadgerStore(filePath string, options badger.Options, cfg stoabs.Confi
 // Wrap creates a KVStore using an existing badger.db
 func Wrap(db *badger.DB, cfg stoabs.Config) stoabs.KVStore {
 	return &store{
-		db:  db,
-		log: cfg.Log,
+		db:        db,
+		log:       cfg.Log,
+		cfg:       cfg,
+		writeLock: &util.ContextRWLocker{},
+		drainer:   &util.Drainer{},
 	}
 }
 
 type store struct {
 	db  *badger.DB
 	log *logrus.Logger
+	cfg stoabs.Config
+	// writeLock is held by write transactions that specify stoabs.WithWriteLock.
+	writeLock *util.ContextRWLocker
+	// drainer tracks in-flight transactions, so Close can wait for them
+	drainer *util.Drainer
+	// conflicts counts the write transactions that failed with stoabs.ErrConflict
+	conflicts atomic.Uint64
 }
 
 func (b *store) Close(ctx context.Context) error {
-	return util.CallWithTimeout(ctx, b.db.Close, func() {
-		b.log.Error("Closing of Badger store timed out, store may not shut down correctly.")
-	})
+	err := b.drainer.Close(ctx, b.db.Close)
+	if err != nil && ctx.Err() != nil {
+		b.log.Error("Closing of Badger store timed out, in-flight transactions were aborted.")
+	}
+	return err
+}
+
+func (b *store) Capabilities() stoabs.Capabilities {
+	return stoabs.CapabilityState | stoabs.CapabilityStats | stoabs.CapabilityFreeze | stoabs.CapabilitySnapshot |
+		stoabs.CapabilityOrderedIteration | stoabs.CapabilityLazyRange | stoabs.CapabilityEntries | stoabs.CapabilityKeyHistogram |
+		stoabs.CapabilitySample
+}
+
+func (b *store) State() stoabs.State {
+	return b.drainer.State()
+}
+
+func (b *store) Freeze(ctx context.Context) error {
+	return b.drainer.Freeze(ctx)
+}
+
+func (b *store) Unfreeze() {
+	b.drainer.Unfreeze()
+}
+
+func (b *store) Frozen() bool {
+	return b.drainer.Frozen()
 }
 
 func (b *store) Write(ctx context.Context, fn func(stoabs.WriteTx) error, opts ...stoabs.TxOption) error {
@@ -117,12 +164,52 @@ func (b *store) ReadShelf(ctx context.Context, shelfName string, fn func(reader
 	}, false, nil)
 }
 
+func (b *store) Stats(ctx context.Context) (stoabs.StoreStats, error) {
+	var result stoabs.StoreStats
+	err := b.doTX(ctx, func(tx *tx) error {
+		lsm, vlog := b.db.Size()
+		result.Size = uint64(lsm + vlog)
+		result.OpenTransactions = b.drainer.InFlight() - 1
+		result.Conflicts = b.conflicts.Load()
+		return nil
+	}, false, nil)
+	return result, err
+}
+
 func (b *store) doTX(ctx context.Context, fn func(tx *tx) error, writable bool, opts []stoabs.TxOption) error {
+	if b.db.IsClosed() {
+		return stoabs.ErrStoreIsClosed
+	}
+	begin := b.drainer.Begin
+	if writable {
+		begin = b.drainer.BeginWrite
+	}
+	ctx, done, err := begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	unlock := func() {}
+	if writable && (stoabs.WriteLockOption{}).Enabled(opts) {
+		lockCtx, lockCtxCancel := stoabs.ContextWithTimeout(ctx, b.cfg.Clock, b.cfg.LockAcquireTimeout)
+		err := b.writeLock.LockContextHolder(lockCtx, (stoabs.LabelOption{}).Label(opts))
+		lockCtxCancel()
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = stoabs.ErrLockTimeout{Lock: "Badger write lock", Holder: b.writeLock.Holder()}
+		}
+		if err != nil {
+			return fmt.Errorf("unable to obtain Badger write lock: %w", stoabs.DatabaseError(err))
+		}
+		unlock = b.writeLock.Unlock
+	}
+
 	// Start transaction, retrieve/create shelf to operate on
 	tx := &tx{
-		badgerTx: b.db.NewTransaction(writable),
-		ctx:      ctx,
-		store:    b,
+		badgerTx:   b.db.NewTransaction(writable),
+		ctx:        ctx,
+		store:      b,
+		trackReads: writable,
 	}
 	defer tx.rollback()
 
@@ -135,7 +222,6 @@ func (b *store) doTX(ctx context.Context, fn func(tx *tx) error, writable bool,
 		return appError
 	}
 	// Observe result, commit/rollback
-	var err error
 	if appError == nil {
 		b.log.Trace("Committing Badger transaction")
 		// Check context cancellation, if not cancelled/expired; commit.
@@ -145,6 +231,13 @@ func (b *store) doTX(ctx context.Context, fn func(tx *tx) error, writable bool,
 		} else if writable {
 			err = tx.commit()
 		}
+		unlock()
+		if errors.Is(err, badger.ErrConflict) {
+			b.conflicts.Add(1)
+			stoabs.OnRollbackOption{}.Invoke(opts)
+			shelfName, key := tx.conflictingRead()
+			return stoabs.NewConflictError(shelfName, key, err)
+		}
 		if err != nil {
 			stoabs.OnRollbackOption{}.Invoke(opts)
 			return util.WrapError(stoabs.ErrCommitFailed, err)
@@ -154,6 +247,7 @@ func (b *store) doTX(ctx context.Context, fn func(tx *tx) error, writable bool,
 	} else {
 		b.log.WithError(appError).Warn("Rolling back transaction application due to error")
 		tx.rollback()
+		unlock()
 		stoabs.OnRollbackOption{}.Invoke(opts)
 		return appError
 	}
@@ -169,6 +263,10 @@ type tx struct {
 	mutex    sync.RWMutex
 	store    *store
 	badgerTx *badger.Txn
+	// trackReads specifies whether the keys read using Get are recorded, to report conflicts (see conflictingRead).
+	trackReads bool
+	readsMux   sync.Mutex
+	reads      []readKey
 }
 
 func (b *tx) Unwrap() interface{} {
@@ -180,11 +278,17 @@ func (b *tx) GetShelfReader(shelfName string) stoabs.Reader {
 }
 
 func (b *tx) GetShelfWriter(shelfName string) stoabs.Writer {
-	return &badgerShelf{name: shelfName, tx: b, ctx: b.ctx}
+	if invalid := stoabs.InvalidShelf(shelfName); invalid != nil {
+		return invalid
+	}
+	return &badgerShelf{name: b.store.cfg.ShelfName(shelfName), tx: b, ctx: b.ctx, clone: b.store.cfg.CloneValues(false), validate: b.store.cfg.Validator(shelfName), immutable: b.store.cfg.Immutable(shelfName)}
 }
 
 func (b *tx) getBucket(shelfName string) stoabs.Reader {
-	return &badgerShelf{name: shelfName, tx: b, ctx: b.ctx}
+	if invalid := stoabs.InvalidShelf(shelfName); invalid != nil {
+		return invalid
+	}
+	return &badgerShelf{name: b.store.cfg.ShelfName(shelfName), tx: b, ctx: b.ctx, clone: b.store.cfg.CloneValues(false)}
 }
 
 func (b *tx) Store() stoabs.KVStore {
@@ -193,15 +297,37 @@ func (b *tx) Store() stoabs.KVStore {
 
 // newIterator creates a new Iterator and stores it within the tx so any rollback or commit operation can close it.
 func (b *tx) newIterator() *badger.Iterator {
+	return b.newIteratorWithOptions(badger.DefaultIteratorOptions)
+}
+
+// newKeyIterator is like newIterator, but doesn't prefetch values.
+func (b *tx) newKeyIterator() *badger.Iterator {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	return b.newIteratorWithOptions(opts)
+}
+
+func (b *tx) newIteratorWithOptions(opts badger.IteratorOptions) *badger.Iterator {
 	b.mutex.Lock()
 	defer b.mutex.Unlock()
 
-	iterator := b.badgerTx.NewIterator(badger.DefaultIteratorOptions)
+	iterator := b.badgerTx.NewIterator(opts)
 	b.iterators = append(b.iterators, iterator)
 
 	return iterator
 }
 
+// closeIterators closes all open iterators, without ending the Badger transaction.
+func (b *tx) closeIterators() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for _, it := range b.iterators {
+		it.Close()
+	}
+	b.iterators = nil
+}
+
 // rollback closes all open iterators before Discarding the Badger transaction.
 // An open iterator causes a panic on Discard
 func (b *tx) rollback() {
@@ -231,6 +357,12 @@ type badgerShelf struct {
 	ctx  context.Context
 	name string
 	tx   *tx
+	// clone specifies whether values passed to Iterate and Range callbacks are copied, see stoabs.WithValueCloning.
+	clone bool
+	// validate runs the validators of the shelf (see stoabs.WithValidator), nil if it has none.
+	validate func(key stoabs.Key, value []byte) error
+	// immutable returns stoabs.ErrImmutable for a key if the shelf is write-once (see stoabs.WithWriteOnce), nil otherwise.
+	immutable func(key stoabs.Key) error
 }
 
 func (t badgerShelf) key(key stoabs.Key) stoabs.Key {
@@ -253,6 +385,7 @@ func (t badgerShelf) Empty() (bool, error) {
 }
 
 func (t badgerShelf) Get(key stoabs.Key) ([]byte, error) {
+	t.tx.recordRead(t.name, key)
 	item, err := t.tx.badgerTx.Get(t.key(key).Bytes())
 	if err != nil {
 		if errors.Is(err, badger.ErrKeyNotFound) {
@@ -265,10 +398,28 @@ func (t badgerShelf) Get(key stoabs.Key) ([]byte, error) {
 }
 
 func (t badgerShelf) Put(key stoabs.Key, value []byte) error {
+	if t.validate != nil {
+		if err := t.validate(key, value); err != nil {
+			return err
+		}
+	}
+	if t.immutable != nil {
+		// reading the key makes the transaction conflict with concurrent transactions writing it
+		_, err := t.tx.badgerTx.Get(t.key(key).Bytes())
+		if err == nil {
+			return t.immutable(key)
+		}
+		if !errors.Is(err, badger.ErrKeyNotFound) {
+			return stoabs.DatabaseError(err)
+		}
+	}
 	return t.tx.badgerTx.Set(t.key(key).Bytes(), value)
 }
 
 func (t badgerShelf) Delete(key stoabs.Key) error {
+	if t.immutable != nil {
+		return t.immutable(key)
+	}
 	return t.tx.badgerTx.Delete(t.key(key).Bytes())
 }
 
@@ -299,7 +450,7 @@ func (t badgerShelf) Iterate(callback stoabs.CallerFn, keyType stoabs.Key) error
 	for it.Seek(prefix); it.ValidForPrefix(prefix) && t.ctx.Err() == nil; it.Next() {
 		item := it.Item()
 		k := item.Key()
-		if err := item.Value(func(v []byte) error {
+		if err := t.value(item, func(v []byte) error {
 			kt, err := keyType.FromBytes(k[len(prefix):])
 			if err != nil {
 				return err
@@ -318,6 +469,124 @@ func (t badgerShelf) Iterate(callback stoabs.CallerFn, keyType stoabs.Key) error
 func (t badgerShelf) Range(from stoabs.Key, to stoabs.Key, callback stoabs.CallerFn, stopAtNil bool) error {
 	// closed by commit or rollback
 	it := t.tx.newIterator()
+	return t.rangeItems(it, from, to, func(key stoabs.Key, item *badger.Item) error {
+		return t.value(item, func(v []byte) error {
+			return callback(key, v)
+		})
+	}, stopAtNil)
+}
+
+// value calls fn with the value of the given item, copied if value cloning is enabled.
+// Otherwise, the value is owned by Badger and only valid within fn.
+func (t badgerShelf) value(item *badger.Item, fn func(v []byte) error) error {
+	if !t.clone {
+		return item.Value(fn)
+	}
+	value, err := item.ValueCopy(nil)
+	if err != nil {
+		return err
+	}
+	return fn(value)
+}
+
+// RangeLazy iterates without prefetching values, and only reads the value of an entry when its loader is called.
+func (t badgerShelf) RangeLazy(from stoabs.Key, to stoabs.Key, callback stoabs.LazyCallerFn, stopAtNil bool) error {
+	// closed by commit or rollback
+	it := t.tx.newKeyIterator()
+	return t.rangeItems(it, from, to, func(key stoabs.Key, item *badger.Item) error {
+		return callback(key, func() ([]byte, error) {
+			return item.ValueCopy(nil)
+		})
+	}, stopAtNil)
+}
+
+// Entries iterates over the items as the loop advances.
+func (t badgerShelf) Entries(from stoabs.Key, to stoabs.Key) iter.Seq2[stoabs.KeyValue, error] {
+	return func(yield func(stoabs.KeyValue, error) bool) {
+		// closed by commit or rollback
+		it := t.tx.newIterator()
+		stopped := false
+		err := t.rangeItems(it, from, to, func(key stoabs.Key, item *badger.Item) error {
+			return t.value(item, func(v []byte) error {
+				if !yield(stoabs.KeyValue{Key: key, Value: v}, nil) {
+					stopped = true
+					return errStopped
+				}
+				return nil
+			})
+		}, false)
+		if err != nil && !stopped {
+			yield(stoabs.KeyValue{}, err)
+		}
+	}
+}
+
+// KeyHistogram iterates over the keys of the shelf twice (to find the largest key, and to count them), without reading values.
+func (t badgerShelf) KeyHistogram(buckets int, keyType stoabs.Key) (stoabs.Histogram, error) {
+	var first, last []byte
+	if err := t.keys(func(key []byte) {
+		if first == nil {
+			first = bytes.Clone(key)
+		}
+		last = append(last[:0], key...)
+	}); err != nil || first == nil {
+		return stoabs.Histogram{}, err
+	}
+	builder, err := stoabs.NewHistogramBuilder(buckets, keyType, first, last)
+	if err != nil {
+		return stoabs.Histogram{}, err
+	}
+	if err := t.keys(builder.Add); err != nil {
+		return stoabs.Histogram{}, err
+	}
+	return builder.Histogram()
+}
+
+// Sample selects the keys with reservoir sampling while iterating over the keys, and only reads the values of the selected keys.
+func (t badgerShelf) Sample(n int, keyType stoabs.Key) ([]stoabs.KeyValue, error) {
+	reservoir := stoabs.NewReservoir[[]byte](n)
+	if err := t.keys(func(key []byte) {
+		reservoir.AddFunc(func() []byte {
+			return bytes.Clone(key)
+		})
+	}); err != nil {
+		return nil, err
+	}
+	keys := reservoir.Items()
+	result := make([]stoabs.KeyValue, 0, len(keys))
+	for _, k := range keys {
+		key, err := keyType.FromBytes(k)
+		if err != nil {
+			return nil, err
+		}
+		value, err := t.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, stoabs.KeyValue{Key: key, Value: value})
+	}
+	return result, nil
+}
+
+// keys calls fn for each key of the shelf (without the shelf prefix) in byte order. The key is only valid within fn.
+func (t badgerShelf) keys(fn func(key []byte)) error {
+	// closed by commit or rollback
+	it := t.tx.newKeyIterator()
+	t.tx.mutex.RLock()
+	defer t.tx.mutex.RUnlock()
+
+	prefix := []byte(t.name)
+	for it.Seek(prefix); it.ValidForPrefix(prefix) && t.ctx.Err() == nil; it.Next() {
+		fn(it.Item().Key()[len(prefix):])
+	}
+	if t.ctx.Err() != nil {
+		return stoabs.DatabaseError(t.ctx.Err())
+	}
+	return nil
+}
+
+// rangeItems calls fn for each item from..to, using the given iterator.
+func (t badgerShelf) rangeItems(it *badger.Iterator, from stoabs.Key, to stoabs.Key, fn func(key stoabs.Key, item *badger.Item) error, stopAtNil bool) error {
 	t.tx.mutex.RLock()
 	defer t.tx.mutex.RUnlock()
 
@@ -332,20 +601,16 @@ func (t badgerShelf) Range(from stoabs.Key, to stoabs.Key, callback stoabs.Calle
 
 	for it.Seek(start); it.ValidForPrefix(prefix) && bytes.Compare(it.Item().Key(), end) < 0 && t.tx.ctx.Err() == nil; it.Next() {
 		item := it.Item()
-		k := item.Key()
-		key, _ := from.FromBytes(k)
+		// the key is kept as prevKey, but Item.Key() is only valid until Next() is called
+		key, err := from.FromBytes(item.KeyCopy(nil)[len(prefix):])
+		if err != nil {
+			return err
+		}
 		if stopAtNil && prevKey != nil && !prevKey.Next().Equals(key) {
 			// gap found, stop here
 			return nil
 		}
-		err := item.Value(func(v []byte) error {
-			kt, err := from.FromBytes(k[len(prefix):])
-			if err != nil {
-				return err
-			}
-			return callback(kt, v)
-		})
-		if err != nil {
+		if err := fn(key, item); err != nil {
 			return err
 		}
 		prevKey = key
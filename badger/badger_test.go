@@ -47,16 +47,50 @@ func TestBadger(t *testing.T) {
 
 	kvtests.TestReadingAndWriting(t, provider)
 	kvtests.TestRange(t, provider)
+	kvtests.TestAggregate(t, provider)
 	kvtests.TestEmpty(t, provider)
 	kvtests.TestIterate(t, provider)
+	kvtests.TestOrderedIteration(t, provider)
 	kvtests.TestClose(t, provider)
 	kvtests.TestDelete(t, provider)
 	//kvtests.TestStats(t, provider) //not yet completed
 	kvtests.TestWriteTransactions(t, provider)
+	kvtests.TestByteTransparency(t, provider)
+	kvtests.TestLinearizability(t, provider)
+	kvtests.TestErrors(t, provider)
+	kvtests.TestCapabilities(t, provider, kvtests.CapabilityLazyRange, kvtests.CapabilityState, kvtests.CapabilityStats, kvtests.CapabilityEntries, kvtests.CapabilityFreeze, kvtests.CapabilityKeyHistogram, kvtests.CapabilitySample, kvtests.CapabilitySnapshot)
+	kvtests.TestValidator(t, func(t *testing.T, opts ...stoabs.Option) (stoabs.KVStore, error) {
+		return CreateBadgerStore(path.Join(util.TestDirectory(t), "badger.db"), append(opts, stoabs.WithNoSync())...)
+	})
+	kvtests.TestWriteOnce(t, func(t *testing.T, opts ...stoabs.Option) (stoabs.KVStore, error) {
+		return CreateBadgerStore(path.Join(util.TestDirectory(t), "badger.db"), append(opts, stoabs.WithNoSync())...)
+	})
+	kvtests.TestKeyPrefix(t, func(t *testing.T, prefixes ...string) ([]stoabs.KVStore, error) {
+		db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+		if err != nil {
+			return nil, err
+		}
+		t.Cleanup(func() {
+			_ = db.Close()
+		})
+		var stores []stoabs.KVStore
+		for _, prefix := range prefixes {
+			cfg := stoabs.DefaultConfig()
+			stoabs.WithKeyPrefix(prefix)(&cfg)
+			stores = append(stores, Wrap(db, cfg))
+		}
+		return stores, nil
+	}, false)
 	// Badger supports parallel transactions
 	//kvtests.TestTransactionWriteLock(t, provider)
 }
 
+func FuzzBadger_Range(f *testing.F) {
+	kvtests.FuzzRange(f, func(t *testing.T) (stoabs.KVStore, error) {
+		return CreateBadgerStore(path.Join(util.TestDirectory(t), "badger.db"), stoabs.WithNoSync())
+	})
+}
+
 func TestBadger_Unwrap(t *testing.T) {
 	store, _ := createStore(t)
 
@@ -124,6 +158,45 @@ func TestBadger_IteratorClose(t *testing.T) {
 	}
 }
 
+func TestBadger_ValueCloning(t *testing.T) {
+	ctx := context.Background()
+	store, err := CreateBadgerStore("", stoabs.WithNoSync(), stoabs.WithValueCloning(true))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer store.Close(ctx)
+	_ = store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+		return writer.Put(stoabs.BytesKey(key), value)
+	})
+
+	// values owned by the caller can be modified without affecting the database
+	var iterated, ranged []byte
+	err = store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+		if err := reader.Iterate(func(_ stoabs.Key, value []byte) error {
+			iterated = value
+			value[0] = 0
+			return nil
+		}, stoabs.BytesKey{}); err != nil {
+			return err
+		}
+		return reader.Range(stoabs.BytesKey(key), stoabs.BytesKey(key).Next(), func(_ stoabs.Key, value []byte) error {
+			ranged = value
+			value[1] = 0
+			return nil
+		}, false)
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []byte{0, 5, 6}, iterated)
+	assert.Equal(t, []byte{4, 0, 6}, ranged)
+	_ = store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+		actual, err := reader.Get(stoabs.BytesKey(key))
+		assert.NoError(t, err)
+		assert.Equal(t, value, actual)
+		return nil
+	})
+}
+
 func TestBadger_CreateBadgerStore(t *testing.T) {
 	t.Run("opening locked file logs warning", func(t *testing.T) {
 		filename := filepath.Join(util.TestDirectory(t), "test-store")
@@ -154,7 +227,7 @@ func TestBadger_Close(t *testing.T) {
 			err := store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
 				return writer.Put(bytesKey, bytesValue)
 			})
-			assert.Contains(t, err.Error(), "Writes are blocked, possibly due to DropAll or Close")
+			assert.ErrorIs(t, err, stoabs.ErrStoreIsClosed)
 		})
 	})
 }
@@ -0,0 +1,73 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package badger
+
+import (
+	"bytes"
+	"github.com/dgraph-io/badger/v4"
+	"github.com/nuts-foundation/go-stoabs"
+)
+
+// readKey is a key read by a write transaction.
+type readKey struct {
+	// shelf is the name of the shelf as stored (including the key prefix).
+	shelf string
+	key   stoabs.Key
+}
+
+// recordRead records the given key was read, if the transaction tracks reads.
+func (b *tx) recordRead(shelf string, key stoabs.Key) {
+	if !b.trackReads {
+		return
+	}
+	b.readsMux.Lock()
+	defer b.readsMux.Unlock()
+	b.reads = append(b.reads, readKey{shelf: shelf, key: key})
+}
+
+// conflictingRead returns the shelf and key of a key read by the transaction (using Get) that was modified
+// by a transaction that committed after this transaction started. It returns nil if there is none,
+// e.g. because the conflict was caused by a key that was read when iterating.
+func (b *tx) conflictingRead() (string, stoabs.Key) {
+	b.readsMux.Lock()
+	defer b.readsMux.Unlock()
+	if len(b.reads) == 0 {
+		return "", nil
+	}
+	readTs := b.badgerTx.ReadTs()
+	current := b.store.db.NewTransaction(false)
+	defer current.Discard()
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.AllVersions = true
+	for _, read := range b.reads {
+		key := append([]byte(read.shelf), read.key.Bytes()...)
+		opts.Prefix = key
+		it := current.NewIterator(opts)
+		// the latest version (which may be a deletion) comes first
+		it.Seek(key)
+		modified := it.Valid() && bytes.Equal(it.Item().Key(), key) && it.Item().Version() > readTs
+		it.Close()
+		if modified {
+			shelfName, _ := b.store.cfg.TrimShelfName(read.shelf)
+			return shelfName, read.key
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package badger
+
+import (
+	"context"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path"
+	"testing"
+)
+
+func TestBadger_Conflicts(t *testing.T) {
+	ctx := context.Background()
+	store, err := CreateBadgerStore(path.Join(util.TestDirectory(t), "badger.db"), stoabs.WithNoSync(), stoabs.WithKeyPrefix("prefix/"))
+	require.NoError(t, err)
+	defer store.Close(ctx)
+	counter := stoabs.Uint32Key(1)
+	otherKey := stoabs.Uint32Key(2)
+	require.NoError(t, store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+		_ = writer.Put(otherKey, value)
+		return writer.Put(counter, value)
+	}))
+	// conflict reads both keys, while a concurrent transaction modifies the counter
+	conflict := func(tx stoabs.WriteTx) error {
+		writer := tx.GetShelfWriter(shelf)
+		if _, err := writer.Get(otherKey); err != nil {
+			return err
+		}
+		if _, err := writer.Get(counter); err != nil {
+			return err
+		}
+		if err := store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+			return writer.Put(counter, []byte("concurrent"))
+		}); err != nil {
+			return err
+		}
+		return writer.Put(counter, []byte("conflicting"))
+	}
+
+	t.Run("reports conflicting key", func(t *testing.T) {
+		err := store.Write(ctx, conflict)
+
+		var conflictErr *stoabs.ConflictError
+		require.ErrorAs(t, err, &conflictErr)
+		assert.ErrorIs(t, err, stoabs.ErrConflict)
+		assert.Equal(t, shelf, conflictErr.Shelf)
+		assert.Equal(t, counter, conflictErr.Key)
+		assert.Equal(t, 1, conflictErr.Attempts)
+	})
+	t.Run("counts conflicts", func(t *testing.T) {
+		before, err := stoabs.Stats(ctx, store)
+		require.NoError(t, err)
+
+		_ = store.Write(ctx, conflict)
+
+		after, err := stoabs.Stats(ctx, store)
+		require.NoError(t, err)
+		assert.Equal(t, before.Conflicts+1, after.Conflicts)
+	})
+	t.Run("retry", func(t *testing.T) {
+		err := stoabs.RetryConflicts(ctx, store, 3, conflict)
+
+		var conflictErr *stoabs.ConflictError
+		require.ErrorAs(t, err, &conflictErr)
+		assert.Equal(t, 3, conflictErr.Attempts)
+		assert.ErrorContains(t, err, "after 3 attempts")
+	})
+}
@@ -0,0 +1,126 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package badger
+
+import (
+	"context"
+	"github.com/dgraph-io/badger/v4"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/util"
+	"sync"
+)
+
+var _ stoabs.Snapshotter = (*store)(nil)
+
+// SnapshotStore holds a read-only Badger transaction for the lifetime of the snapshot, which reads the versions of the keys
+// at the time it was started. While it's open, Badger can't discard older versions of keys (e.g. during value log GC).
+// The snapshot is registered as in-flight transaction of the store, so closing the store waits for it to be closed,
+// or closes it when in-flight transactions are aborted.
+func (b *store) SnapshotStore(_ context.Context) (stoabs.KVStore, error) {
+	if b.db.IsClosed() {
+		return nil, stoabs.ErrStoreIsClosed
+	}
+	storeCtx, done, err := b.drainer.Begin(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	result := &snapshot{
+		store:    b,
+		badgerTx: b.db.NewTransaction(false),
+		drainer:  &util.Drainer{},
+		done:     done,
+	}
+	go func() {
+		// cancelled when the snapshot is closed, or aborted by closing the store
+		<-storeCtx.Done()
+		_ = result.Close(storeCtx)
+	}()
+	return result, nil
+}
+
+// snapshot is a read-only KVStore that reads from a Badger transaction, see SnapshotStore.
+type snapshot struct {
+	store    *store
+	badgerTx *badger.Txn
+	// mux serializes the transactions on the snapshot, since a Badger transaction can't be used concurrently.
+	mux sync.Mutex
+	// drainer tracks the in-flight transactions on the snapshot, so Close can wait for them
+	drainer *util.Drainer
+	// done deregisters the snapshot from the drainer of the store
+	done func()
+}
+
+func (s *snapshot) Close(ctx context.Context) error {
+	return s.drainer.Close(ctx, func() error {
+		s.badgerTx.Discard()
+		s.done()
+		return nil
+	})
+}
+
+func (s *snapshot) Write(_ context.Context, _ func(stoabs.WriteTx) error, _ ...stoabs.TxOption) error {
+	return stoabs.ErrReadOnly
+}
+
+func (s *snapshot) Read(ctx context.Context, fn func(stoabs.ReadTx) error) error {
+	ctx, done, err := s.drainer.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	tx := &tx{
+		badgerTx: s.badgerTx,
+		ctx:      ctx,
+		store:    s.store,
+	}
+	// the Badger transaction outlives this transaction, so only its iterators are closed
+	defer tx.closeIterators()
+	return fn(snapshotTx{tx: tx, snapshot: s})
+}
+
+func (s *snapshot) WriteShelf(_ context.Context, _ string, _ func(stoabs.Writer) error) error {
+	return stoabs.ErrReadOnly
+}
+
+func (s *snapshot) ReadShelf(ctx context.Context, shelfName string, fn func(stoabs.Reader) error) error {
+	return s.Read(ctx, func(tx stoabs.ReadTx) error {
+		return fn(tx.GetShelfReader(shelfName))
+	})
+}
+
+// snapshotTx is a transaction on a snapshot, which returns the snapshot as its store.
+type snapshotTx struct {
+	tx       *tx
+	snapshot *snapshot
+}
+
+func (t snapshotTx) GetShelfReader(shelfName string) stoabs.Reader {
+	return t.tx.GetShelfReader(shelfName)
+}
+
+func (t snapshotTx) Store() stoabs.KVStore {
+	return t.snapshot
+}
+
+func (t snapshotTx) Unwrap() interface{} {
+	return t.tx.badgerTx
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package badger
+
+import (
+	"context"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestBadger_SnapshotStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("iterators are closed after every transaction", func(t *testing.T) {
+		store, _ := createStore(t)
+		snapshot, err := stoabs.SnapshotStore(ctx, store)
+		require.NoError(t, err)
+		defer snapshot.Close(ctx)
+
+		for i := 0; i < 2; i++ {
+			err := snapshot.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+				empty, err := reader.Empty()
+				assert.True(t, empty)
+				require.Len(t, reader.(*badgerShelf).tx.iterators, 1)
+				return err
+			})
+			require.NoError(t, err)
+		}
+	})
+	t.Run("closing the store waits for the snapshot", func(t *testing.T) {
+		store, _ := createStore(t)
+		snapshot, err := stoabs.SnapshotStore(ctx, store)
+		require.NoError(t, err)
+		closed := make(chan error, 1)
+
+		go func() {
+			closed <- store.Close(ctx)
+		}()
+
+		select {
+		case <-closed:
+			t.Fatal("store closed while the snapshot is open")
+		case <-time.After(50 * time.Millisecond):
+		}
+		require.NoError(t, snapshot.Close(ctx))
+		assert.NoError(t, <-closed)
+	})
+	t.Run("closing the store aborts the snapshot", func(t *testing.T) {
+		store, _ := createStore(t)
+		snapshot, err := stoabs.SnapshotStore(ctx, store)
+		require.NoError(t, err)
+		closeCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+
+		err = store.Close(closeCtx)
+
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Eventually(t, func() bool {
+			return snapshot.Read(ctx, func(_ stoabs.ReadTx) error {
+				return nil
+			}) != nil
+		}, time.Second, 10*time.Millisecond)
+		assert.Eventually(t, func() bool {
+			state, _ := stoabs.StateOf(store)
+			return state == stoabs.StateClosed
+		}, time.Second, 10*time.Millisecond)
+	})
+	t.Run("closed store", func(t *testing.T) {
+		store, _ := createStore(t)
+		require.NoError(t, store.Close(ctx))
+
+		_, err := stoabs.SnapshotStore(ctx, store)
+
+		assert.ErrorIs(t, err, stoabs.ErrStoreIsClosed)
+	})
+}
@@ -0,0 +1,229 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package batch
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+)
+
+// ErrAsyncWriterClosed is passed to the callback of writes submitted after the AsyncWriter was closed.
+var ErrAsyncWriterClosed = errors.New("async writer is closed")
+
+// AsyncOption configures an AsyncWriter.
+type AsyncOption func(cfg *asyncConfig)
+
+type asyncConfig struct {
+	maxBatchSize int
+	maxDelay     time.Duration
+}
+
+// WithMaxBatchSize specifies the maximum number of writes committed in a single transaction, which defaults to 1000.
+// It's also the number of writes that can be queued before PutAsync blocks.
+func WithMaxBatchSize(size int) AsyncOption {
+	return func(cfg *asyncConfig) {
+		cfg.maxBatchSize = size
+	}
+}
+
+// WithMaxDelay specifies how long the AsyncWriter waits for more writes after receiving the first write of a batch,
+// which defaults to 0: a batch then consists of the writes that were queued while the previous batch was being committed.
+// A longer delay results in larger batches (fewer, larger transactions), at the cost of latency.
+func WithMaxDelay(delay time.Duration) AsyncOption {
+	return func(cfg *asyncConfig) {
+		cfg.maxDelay = delay
+	}
+}
+
+// AsyncWriter batches writes to a KVStore, which it commits in groups in the background, so callers don't wait for
+// every write to be committed (and flushed to disk) on its own. Writes are committed in the order they were submitted,
+// so the value written last for a key is the one that's stored.
+// Callbacks are invoked from the goroutine that commits the batches, so they should return quickly.
+type AsyncWriter struct {
+	store stoabs.KVStore
+	cfg   asyncConfig
+	queue chan asyncWrite
+	// mux guards closed, and is read-locked while sending to queue so Close doesn't close it during a send.
+	mux    sync.RWMutex
+	closed bool
+	done   chan struct{}
+}
+
+// asyncWrite is a write submitted to the AsyncWriter, or a barrier (see Flush).
+type asyncWrite struct {
+	// barrier indicates the write only signals that the writes before it are committed, it doesn't write anything.
+	barrier   bool
+	shelfName string
+	key       stoabs.Key
+	value     []byte
+	onDurable func(error)
+}
+
+// NewAsyncWriter creates an AsyncWriter for the given store, and starts committing the writes submitted to it.
+// It must be closed using Close, which doesn't close the store.
+func NewAsyncWriter(store stoabs.KVStore, opts ...AsyncOption) *AsyncWriter {
+	cfg := asyncConfig{maxBatchSize: 1000}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.maxBatchSize <= 0 {
+		cfg.maxBatchSize = 1
+	}
+	w := &AsyncWriter{
+		store: store,
+		cfg:   cfg,
+		queue: make(chan asyncWrite, cfg.maxBatchSize),
+		done:  make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// PutAsync queues writing the value to the key of the given shelf, and returns without waiting for it to be committed.
+// The value is copied, so the caller may reuse it. onDurable (if not nil) is called once the transaction containing the
+// write is committed, with nil or the error that failed the transaction. Since the writes of a batch are committed in
+// a single transaction, a write that fails (e.g. because of a validator) fails all writes of its batch.
+// Note that committed writes are only flushed to disk if the store wasn't created with stoabs.WithNoSync.
+// PutAsync blocks if the queue is full, until the current batch is committed.
+func (w *AsyncWriter) PutAsync(shelfName string, key stoabs.Key, value []byte, onDurable func(error)) {
+	w.submit(asyncWrite{shelfName: shelfName, key: key, value: append(value[:0:0], value...), onDurable: onDurable})
+}
+
+// Flush waits until all writes submitted before it are committed, or the context is done.
+// Failed writes are reported to their callbacks, not by Flush. It returns ErrAsyncWriterClosed if the AsyncWriter is closed.
+func (w *AsyncWriter) Flush(ctx context.Context) error {
+	result := make(chan error, 1)
+	w.submit(asyncWrite{barrier: true, onDurable: func(err error) {
+		result <- err
+	}})
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close commits the queued writes, and stops the AsyncWriter. Writes submitted afterwards fail with ErrAsyncWriterClosed.
+// If the context is done before the queued writes are committed, Close returns its error, while the remaining writes
+// are still committed in the background.
+func (w *AsyncWriter) Close(ctx context.Context) error {
+	w.mux.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mux.Unlock()
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *AsyncWriter) submit(write asyncWrite) {
+	w.mux.RLock()
+	defer w.mux.RUnlock()
+	if w.closed {
+		if write.onDurable != nil {
+			write.onDurable(ErrAsyncWriterClosed)
+		}
+		return
+	}
+	w.queue <- write
+}
+
+func (w *AsyncWriter) run() {
+	defer close(w.done)
+	for write := range w.queue {
+		w.commit(w.collect([]asyncWrite{write}))
+	}
+}
+
+// collect adds queued writes to the batch until it's full, a barrier is found, or the maximum delay elapsed.
+func (w *AsyncWriter) collect(batch []asyncWrite) []asyncWrite {
+	var timeout <-chan time.Time
+	if w.cfg.maxDelay > 0 {
+		timer := time.NewTimer(w.cfg.maxDelay)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for len(batch) < w.cfg.maxBatchSize && !batch[len(batch)-1].barrier {
+		if timeout == nil {
+			select {
+			case write, ok := <-w.queue:
+				if !ok {
+					return batch
+				}
+				batch = append(batch, write)
+			default:
+				return batch
+			}
+			continue
+		}
+		select {
+		case write, ok := <-w.queue:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, write)
+		case <-timeout:
+			return batch
+		}
+	}
+	return batch
+}
+
+// commit writes the batch in a single transaction, and calls the callbacks of its writes in order.
+func (w *AsyncWriter) commit(batch []asyncWrite) {
+	var err error
+	if len(batch) > 1 || !batch[0].barrier {
+		err = w.store.Write(context.Background(), func(tx stoabs.WriteTx) error {
+			writers := map[string]stoabs.Writer{}
+			for _, write := range batch {
+				if write.barrier {
+					continue
+				}
+				writer, ok := writers[write.shelfName]
+				if !ok {
+					writer = tx.GetShelfWriter(write.shelfName)
+					writers[write.shelfName] = writer
+				}
+				if err := writer.Put(write.key, write.value); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	for _, write := range batch {
+		switch {
+		case write.onDurable == nil:
+		case write.barrier:
+			write.onDurable(nil)
+		default:
+			write.onDurable(err)
+		}
+	}
+}
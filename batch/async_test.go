@@ -0,0 +1,197 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package batch
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAsyncWriter(t *testing.T) {
+	t.Run("commits writes and calls callbacks in order", func(t *testing.T) {
+		store := stores.BBolt(t)
+		writer := NewAsyncWriter(store)
+		var results []error
+		var order []int
+
+		for i := 0; i < 100; i++ {
+			writer.PutAsync(shelfName, stoabs.Uint32Key(i), []byte("value"), func(err error) {
+				results = append(results, err)
+				order = append(order, i)
+			})
+		}
+		require.NoError(t, writer.Close(ctx))
+
+		assert.Len(t, results, 100)
+		for i, err := range results {
+			assert.NoError(t, err)
+			assert.Equal(t, i, order[i])
+		}
+		assert.Equal(t, 100, count(t, store))
+	})
+	t.Run("groups writes in batches", func(t *testing.T) {
+		store := &countingStore{KVStore: stores.BBolt(t)}
+		writer := NewAsyncWriter(store, WithMaxBatchSize(10), WithMaxDelay(time.Hour))
+
+		for i := 0; i < 25; i++ {
+			writer.PutAsync(shelfName, stoabs.Uint32Key(i), []byte("value"), nil)
+		}
+		require.NoError(t, writer.Flush(ctx))
+
+		assert.Equal(t, 25, count(t, store))
+		// 2 full batches, and the partial batch that's committed when flushing
+		assert.Equal(t, 3, store.writes())
+		assert.NoError(t, writer.Close(ctx))
+	})
+	t.Run("last write of a key wins", func(t *testing.T) {
+		store := stores.BBolt(t)
+		writer := NewAsyncWriter(store, WithMaxBatchSize(3))
+		key := stoabs.BytesKey("key")
+
+		for i := 0; i < 10; i++ {
+			writer.PutAsync(shelfName, key, []byte{byte(i)}, nil)
+		}
+		require.NoError(t, writer.Close(ctx))
+
+		_ = store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			value, err := reader.Get(key)
+			assert.NoError(t, err)
+			assert.Equal(t, []byte{9}, value)
+			return nil
+		})
+	})
+	t.Run("copies values", func(t *testing.T) {
+		store := stores.BBolt(t)
+		writer := NewAsyncWriter(store, WithMaxDelay(time.Hour))
+		value := []byte("value")
+
+		writer.PutAsync(shelfName, stoabs.BytesKey("key"), value, nil)
+		value[0] = 'V'
+		require.NoError(t, writer.Close(ctx))
+
+		_ = store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			actual, err := reader.Get(stoabs.BytesKey("key"))
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("value"), actual)
+			return nil
+		})
+	})
+	t.Run("commit failure is reported to all writes of the batch", func(t *testing.T) {
+		store := stores.BBolt(t)
+		require.NoError(t, store.Close(ctx))
+		writer := NewAsyncWriter(store)
+		errs := make(chan error, 2)
+
+		writer.PutAsync(shelfName, stoabs.BytesKey("a"), []byte("a"), func(err error) {
+			errs <- err
+		})
+		writer.PutAsync(shelfName, stoabs.BytesKey("b"), []byte("b"), func(err error) {
+			errs <- err
+		})
+		require.NoError(t, writer.Close(ctx))
+
+		assert.ErrorIs(t, <-errs, stoabs.ErrStoreIsClosed)
+		assert.ErrorIs(t, <-errs, stoabs.ErrStoreIsClosed)
+	})
+	t.Run("closed", func(t *testing.T) {
+		writer := NewAsyncWriter(stores.BBolt(t))
+		require.NoError(t, writer.Close(ctx))
+		var actual error
+
+		writer.PutAsync(shelfName, stoabs.BytesKey("key"), []byte("value"), func(err error) {
+			actual = err
+		})
+
+		assert.ErrorIs(t, actual, ErrAsyncWriterClosed)
+		assert.ErrorIs(t, writer.Flush(ctx), ErrAsyncWriterClosed)
+		assert.NoError(t, writer.Close(ctx), "closing twice")
+	})
+	t.Run("context done while closing", func(t *testing.T) {
+		store := &countingStore{KVStore: stores.BBolt(t), block: make(chan struct{})}
+		writer := NewAsyncWriter(store)
+		writer.PutAsync(shelfName, stoabs.Uint32Key(1), []byte("value"), nil)
+		closeCtx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		err := writer.Close(closeCtx)
+
+		assert.ErrorIs(t, err, context.Canceled)
+		close(store.block)
+		assert.NoError(t, writer.Close(ctx))
+		assert.Equal(t, 1, count(t, store))
+	})
+	t.Run("concurrent writers", func(t *testing.T) {
+		store := stores.BBolt(t)
+		writer := NewAsyncWriter(store, WithMaxBatchSize(7))
+		wg := sync.WaitGroup{}
+		var failed error
+		var mux sync.Mutex
+
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 10; j++ {
+					writer.PutAsync(shelfName, stoabs.Uint32Key(i*10+j), []byte("value"), func(err error) {
+						mux.Lock()
+						defer mux.Unlock()
+						failed = errors.Join(failed, err)
+					})
+				}
+			}(i)
+		}
+		wg.Wait()
+		require.NoError(t, writer.Close(ctx))
+
+		assert.NoError(t, failed)
+		assert.Equal(t, 100, count(t, store))
+	})
+}
+
+// countingStore counts the write transactions, which block until block (if set) is closed.
+type countingStore struct {
+	stoabs.KVStore
+	mux   sync.Mutex
+	count int
+	block chan struct{}
+}
+
+func (c *countingStore) Write(ctx context.Context, fn func(stoabs.WriteTx) error, opts ...stoabs.TxOption) error {
+	if c.block != nil {
+		<-c.block
+	}
+	c.mux.Lock()
+	c.count++
+	c.mux.Unlock()
+	return c.KVStore.Write(ctx, fn, opts...)
+}
+
+func (c *countingStore) writes() int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.count
+}
@@ -0,0 +1,276 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package batch writes large numbers of entries to a KVStore in multiple transactions.
+package batch
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/nuts-foundation/go-stoabs"
+)
+
+// checkpointShelf is the shelf that holds the number of committed operations of resumable writes, keyed by checkpoint name.
+const checkpointShelf = stoabs.ReservedShelfPrefix + "batch"
+
+// BatchWriter buffers the writes to a shelf, which are committed in chunks.
+type BatchWriter interface {
+	// Put adds the entry to the chunk. The value is copied, so the caller may reuse it.
+	// Returns an error if the chunk is full and committing it fails.
+	Put(key stoabs.Key, value []byte) error
+	// Delete adds the deletion of the key to the chunk.
+	// Returns an error if the chunk is full and committing it fails.
+	Delete(key stoabs.Key) error
+}
+
+// Progress describes the progress of WriteLarge and DeleteWhere.
+type Progress struct {
+	// Operations is the number of committed Put and Delete operations, including the ones skipped because of a checkpoint.
+	Operations uint64
+	// Chunks is the number of transactions committed by this invocation.
+	Chunks int
+}
+
+// Option configures WriteLarge and DeleteWhere.
+type Option func(cfg *config)
+
+type config struct {
+	progress   func(Progress)
+	checkpoint string
+}
+
+// WithProgress registers a callback that is invoked after every committed chunk.
+func WithProgress(fn func(Progress)) Option {
+	return func(cfg *config) {
+		cfg.progress = fn
+	}
+}
+
+// WithCheckpoint makes the write resumable: the number of committed operations is recorded under the given name in the
+// same transaction as every chunk. When WriteLarge is invoked again with the same checkpoint after it was interrupted,
+// the operations that were committed before are skipped. This requires fn to perform the same operations in the same order.
+// The checkpoint is removed when the write completes successfully.
+func WithCheckpoint(name string) Option {
+	return func(cfg *config) {
+		cfg.checkpoint = name
+	}
+}
+
+// WriteLarge calls fn with a BatchWriter that commits the writes to the given shelf in transactions of (at most)
+// chunkSize operations, so writing huge numbers of entries doesn't require a single large transaction that consumes a lot
+// of memory and blocks other writers. The final, partial chunk is committed when fn returns.
+// Chunks are committed independently: if fn or committing a chunk fails, the previously committed chunks remain.
+// Use WithCheckpoint to resume an interrupted write.
+func WriteLarge(ctx context.Context, store stoabs.KVStore, shelfName string, fn func(BatchWriter) error, chunkSize int, opts ...Option) error {
+	cfg := config{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if chunkSize <= 0 {
+		return errors.New("chunk size must be greater than 0")
+	}
+	w := &batchWriter{ctx: ctx, store: store, shelfName: shelfName, chunkSize: chunkSize, cfg: cfg}
+	if cfg.checkpoint != "" {
+		var err error
+		if w.skip, err = readCheckpoint(ctx, store, cfg.checkpoint); err != nil {
+			return err
+		}
+		w.progress.Operations = w.skip
+	}
+	if err := fn(w); err != nil {
+		return err
+	}
+	if err := w.flush(); err != nil {
+		return err
+	}
+	if cfg.checkpoint == "" {
+		return nil
+	}
+	err := store.WriteShelf(ctx, checkpointShelf, func(writer stoabs.Writer) error {
+		return writer.Delete(stoabs.BytesKey(cfg.checkpoint))
+	})
+	if err != nil {
+		return fmt.Errorf("unable to remove checkpoint: %w", err)
+	}
+	return nil
+}
+
+type operation struct {
+	key   stoabs.Key
+	value []byte
+	// delete indicates the key is deleted, instead of value being written.
+	delete bool
+}
+
+type batchWriter struct {
+	ctx       context.Context
+	store     stoabs.KVStore
+	shelfName string
+	chunkSize int
+	cfg       config
+	chunk     []operation
+	// skip is the number of operations committed by a previous invocation with the same checkpoint, which are skipped.
+	skip     uint64
+	seen     uint64
+	progress Progress
+}
+
+func (w *batchWriter) Put(key stoabs.Key, value []byte) error {
+	return w.add(operation{key: key, value: append(value[:0:0], value...)})
+}
+
+func (w *batchWriter) Delete(key stoabs.Key) error {
+	return w.add(operation{key: key, delete: true})
+}
+
+func (w *batchWriter) add(op operation) error {
+	w.seen++
+	if w.seen <= w.skip {
+		return nil
+	}
+	w.chunk = append(w.chunk, op)
+	if len(w.chunk) < w.chunkSize {
+		return nil
+	}
+	return w.flush()
+}
+
+// flush commits the current chunk, together with the checkpoint if enabled.
+func (w *batchWriter) flush() error {
+	if len(w.chunk) == 0 {
+		return nil
+	}
+	committed := w.progress.Operations + uint64(len(w.chunk))
+	err := w.store.Write(w.ctx, func(tx stoabs.WriteTx) error {
+		writer := tx.GetShelfWriter(w.shelfName)
+		for _, op := range w.chunk {
+			var err error
+			if op.delete {
+				err = writer.Delete(op.key)
+			} else {
+				err = writer.Put(op.key, op.value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if w.cfg.checkpoint == "" {
+			return nil
+		}
+		return tx.GetShelfWriter(checkpointShelf).Put(stoabs.BytesKey(w.cfg.checkpoint), binary.BigEndian.AppendUint64(nil, committed))
+	})
+	if err != nil {
+		return fmt.Errorf("unable to commit chunk (operations %d-%d): %w", w.progress.Operations+1, committed, err)
+	}
+	w.chunk = w.chunk[:0]
+	w.progress.Operations = committed
+	w.progress.Chunks++
+	if w.cfg.progress != nil {
+		w.cfg.progress(w.progress)
+	}
+	return nil
+}
+
+// DeleteWhere deletes all entries of the given shelf for which the predicate returns true, in transactions of (at most)
+// chunkSize deletions. The matching keys are collected in a single read transaction first, and every chunk evaluates the
+// predicate again with the current value before deleting a key, so entries changed in the meantime aren't deleted by mistake.
+// It returns the number of deleted entries, including the ones of chunks committed before an error occurred.
+// Progress reports the number of deleted entries as Operations; WithCheckpoint is not supported.
+func DeleteWhere(ctx context.Context, store stoabs.KVStore, shelfName string, keyType stoabs.Key, predicate func(stoabs.Key, []byte) bool, chunkSize int, opts ...Option) (int, error) {
+	cfg := config{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if chunkSize <= 0 {
+		return 0, errors.New("chunk size must be greater than 0")
+	}
+	if cfg.checkpoint != "" {
+		return 0, errors.New("checkpoints are not supported when deleting")
+	}
+	var keys []stoabs.Key
+	err := store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+		return reader.Iterate(func(key stoabs.Key, value []byte) error {
+			if predicate(key, value) {
+				keys = append(keys, key)
+			}
+			return nil
+		}, keyType)
+	})
+	if err != nil {
+		return 0, err
+	}
+	progress := Progress{}
+	for start := 0; start < len(keys); start += chunkSize {
+		chunk := keys[start:min(start+chunkSize, len(keys))]
+		deleted := 0
+		err := store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			deleted = 0
+			for _, key := range chunk {
+				value, err := writer.Get(key)
+				if errors.Is(err, stoabs.ErrKeyNotFound) {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				if !predicate(key, value) {
+					continue
+				}
+				if err := writer.Delete(key); err != nil {
+					return err
+				}
+				deleted++
+			}
+			return nil
+		})
+		if err != nil {
+			return int(progress.Operations), fmt.Errorf("unable to commit chunk (keys %d-%d): %w", start+1, start+len(chunk), err)
+		}
+		progress.Operations += uint64(deleted)
+		progress.Chunks++
+		if cfg.progress != nil {
+			cfg.progress(progress)
+		}
+	}
+	return int(progress.Operations), nil
+}
+
+func readCheckpoint(ctx context.Context, store stoabs.KVStore, name string) (uint64, error) {
+	var result uint64
+	err := store.ReadShelf(ctx, checkpointShelf, func(reader stoabs.Reader) error {
+		data, err := reader.Get(stoabs.BytesKey(name))
+		if errors.Is(err, stoabs.ErrKeyNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(data) != 8 {
+			return fmt.Errorf("invalid checkpoint: %x", data)
+		}
+		result = binary.BigEndian.Uint64(data)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("unable to read checkpoint: %w", err)
+	}
+	return result, nil
+}
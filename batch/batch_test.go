@@ -0,0 +1,222 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package batch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+const shelfName = "test"
+
+func TestWriteLarge(t *testing.T) {
+	t.Run("writes in chunks", func(t *testing.T) {
+		store := stores.BBolt(t)
+		var progress []Progress
+
+		err := WriteLarge(ctx, store, shelfName, func(writer BatchWriter) error {
+			return writeEntries(writer, 25)
+		}, 10, WithProgress(func(p Progress) {
+			progress = append(progress, p)
+		}))
+
+		require.NoError(t, err)
+		assert.Equal(t, 25, count(t, store))
+		assert.Equal(t, []Progress{{Operations: 10, Chunks: 1}, {Operations: 20, Chunks: 2}, {Operations: 25, Chunks: 3}}, progress)
+	})
+	t.Run("deletes", func(t *testing.T) {
+		store := stores.BBolt(t)
+
+		err := WriteLarge(ctx, store, shelfName, func(writer BatchWriter) error {
+			if err := writeEntries(writer, 5); err != nil {
+				return err
+			}
+			return writer.Delete(stoabs.Uint32Key(0))
+		}, 2)
+
+		require.NoError(t, err)
+		assert.Equal(t, 4, count(t, store))
+	})
+	t.Run("values are copied", func(t *testing.T) {
+		store := stores.BBolt(t)
+
+		err := WriteLarge(ctx, store, shelfName, func(writer BatchWriter) error {
+			value := []byte("a")
+			if err := writer.Put(stoabs.Uint32Key(1), value); err != nil {
+				return err
+			}
+			value[0] = 'b'
+			return nil
+		}, 10)
+
+		require.NoError(t, err)
+		err = store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			value, err := reader.Get(stoabs.Uint32Key(1))
+			assert.Equal(t, []byte("a"), value)
+			return err
+		})
+		assert.NoError(t, err)
+	})
+	t.Run("error keeps committed chunks", func(t *testing.T) {
+		store := stores.BBolt(t)
+
+		err := WriteLarge(ctx, store, shelfName, func(writer BatchWriter) error {
+			if err := writeEntries(writer, 15); err != nil {
+				return err
+			}
+			return errors.New("failed")
+		}, 10)
+
+		assert.EqualError(t, err, "failed")
+		assert.Equal(t, 10, count(t, store))
+	})
+	t.Run("invalid chunk size", func(t *testing.T) {
+		err := WriteLarge(ctx, stores.BBolt(t), shelfName, func(writer BatchWriter) error {
+			return nil
+		}, 0)
+
+		assert.EqualError(t, err, "chunk size must be greater than 0")
+	})
+}
+
+func TestWithCheckpoint(t *testing.T) {
+	store := stores.BBolt(t)
+	interrupted := errors.New("interrupted")
+	err := WriteLarge(ctx, store, shelfName, func(writer BatchWriter) error {
+		if err := writeEntries(writer, 15); err != nil {
+			return err
+		}
+		return interrupted
+	}, 10, WithCheckpoint("import"))
+	require.ErrorIs(t, err, interrupted)
+
+	var progress []Progress
+	err = WriteLarge(ctx, store, shelfName, func(writer BatchWriter) error {
+		return writeEntries(writer, 25)
+	}, 10, WithCheckpoint("import"), WithProgress(func(p Progress) {
+		progress = append(progress, p)
+	}))
+
+	require.NoError(t, err)
+	assert.Equal(t, 25, count(t, store))
+	assert.Equal(t, []Progress{{Operations: 20, Chunks: 1}, {Operations: 25, Chunks: 2}}, progress)
+	checkpoint, err := readCheckpoint(ctx, store, "import")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), checkpoint, "checkpoint should be removed")
+}
+
+func TestDeleteWhere(t *testing.T) {
+	even := func(key stoabs.Key, _ []byte) bool {
+		return key.(stoabs.Uint32Key)%2 == 0
+	}
+	write := func(t *testing.T, store stoabs.KVStore, entries int) {
+		require.NoError(t, WriteLarge(ctx, store, shelfName, func(writer BatchWriter) error {
+			return writeEntries(writer, entries)
+		}, 100))
+	}
+
+	t.Run("deletes in chunks", func(t *testing.T) {
+		store := stores.BBolt(t)
+		write(t, store, 25)
+		var progress []Progress
+
+		deleted, err := DeleteWhere(ctx, store, shelfName, stoabs.Uint32Key(0), even, 5, WithProgress(func(p Progress) {
+			progress = append(progress, p)
+		}))
+
+		require.NoError(t, err)
+		assert.Equal(t, 13, deleted)
+		assert.Equal(t, 12, count(t, store))
+		assert.Equal(t, []Progress{{Operations: 5, Chunks: 1}, {Operations: 10, Chunks: 2}, {Operations: 13, Chunks: 3}}, progress)
+	})
+	t.Run("predicate is evaluated again before deleting", func(t *testing.T) {
+		store := stores.BBolt(t)
+		write(t, store, 4)
+		calls := 0
+
+		deleted, err := DeleteWhere(ctx, store, shelfName, stoabs.Uint32Key(0), func(key stoabs.Key, value []byte) bool {
+			calls++
+			// matches when scanning, not when deleting
+			return calls <= 4
+		}, 10)
+
+		require.NoError(t, err)
+		assert.Equal(t, 0, deleted)
+		assert.Equal(t, 4, count(t, store))
+	})
+	t.Run("nothing matches", func(t *testing.T) {
+		store := stores.BBolt(t)
+		write(t, store, 4)
+
+		deleted, err := DeleteWhere(ctx, store, shelfName, stoabs.Uint32Key(0), func(stoabs.Key, []byte) bool {
+			return false
+		}, 10)
+
+		require.NoError(t, err)
+		assert.Equal(t, 0, deleted)
+	})
+	t.Run("chunk fails", func(t *testing.T) {
+		store := stores.BBolt(t)
+		write(t, store, 4)
+
+		deleted, err := DeleteWhere(ctx, stoabs.ReadOnly(store), shelfName, stoabs.Uint32Key(0), even, 1)
+
+		assert.ErrorIs(t, err, stoabs.ErrReadOnly)
+		assert.ErrorContains(t, err, "unable to commit chunk (keys 1-1)")
+		assert.Equal(t, 0, deleted)
+	})
+	t.Run("invalid arguments", func(t *testing.T) {
+		store := stores.BBolt(t)
+
+		_, err := DeleteWhere(ctx, store, shelfName, stoabs.Uint32Key(0), even, 0)
+		assert.EqualError(t, err, "chunk size must be greater than 0")
+		_, err = DeleteWhere(ctx, store, shelfName, stoabs.Uint32Key(0), even, 1, WithCheckpoint("delete"))
+		assert.EqualError(t, err, "checkpoints are not supported when deleting")
+	})
+}
+
+func writeEntries(writer BatchWriter, count int) error {
+	for i := 0; i < count; i++ {
+		if err := writer.Put(stoabs.Uint32Key(i), []byte(fmt.Sprintf("value-%d", i))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func count(t *testing.T, store stoabs.KVStore) int {
+	var result int
+	err := store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+		return reader.Iterate(func(_ stoabs.Key, _ []byte) error {
+			result++
+			return nil
+		}, stoabs.Uint32Key(0))
+	})
+	require.NoError(t, err)
+	return result
+}
@@ -21,9 +21,12 @@ package bbolt
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"iter"
 	"os"
 	"path"
+	"strings"
 	"time"
 
 	"github.com/nuts-foundation/go-stoabs"
@@ -32,13 +35,30 @@ import (
 	"go.etcd.io/bbolt"
 )
 
+var _ stoabs.ShelfLister = (*store)(nil)
+var _ stoabs.Locker = (*store)(nil)
+var _ stoabs.LockInspector = (*store)(nil)
+var _ stoabs.Leaser = (*store)(nil)
+var _ stoabs.StateReporter = (*store)(nil)
+var _ stoabs.StatsReader = (*store)(nil)
+var _ stoabs.CapabilityReporter = (*store)(nil)
 var _ stoabs.ReadTx = (*bboltTx)(nil)
 var _ stoabs.WriteTx = (*bboltTx)(nil)
 var _ stoabs.Reader = (*bboltShelf)(nil)
 var _ stoabs.Writer = (*bboltShelf)(nil)
+var _ stoabs.BulkDeleter = (*bboltShelf)(nil)
+var _ stoabs.LazyRanger = (*bboltShelf)(nil)
+var _ stoabs.KeyChecker = (*bboltShelf)(nil)
+var _ stoabs.RangeAggregator = (*bboltShelf)(nil)
+var _ stoabs.EntryIterator = (*bboltShelf)(nil)
+var _ stoabs.KeyHistogrammer = (*bboltShelf)(nil)
 
 const defaultFileTimeout = 5 * time.Second
 
+// appendFillPercent is the fill percent of the buckets of append-optimized shelves (see stoabs.WithAppendOptimized):
+// when appending, the left page of a split is never written to again, so it's filled completely.
+const appendFillPercent = 1.0
+
 var fileTimeout = defaultFileTimeout
 
 // CreateBBoltStore creates a new BBolt-backed KV store.
@@ -57,6 +77,20 @@ func CreateBBoltStore(filePath string, opts ...stoabs.Option) (stoabs.KVStore, e
 	return createBBoltStore(filePath, &bboltOpts, cfg)
 }
 
+// CreateReadOnlyBBoltStore opens an existing BBolt database file for reading only. Multiple processes can open the file
+// this way at the same time, while a process that opens it for writing (see CreateBBoltStore) has to wait until all of them closed it.
+// Write transactions return stoabs.ErrReadOnly.
+func CreateReadOnlyBBoltStore(filePath string, opts ...stoabs.Option) (stoabs.KVStore, error) {
+	cfg := stoabs.DefaultConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	bboltOpts := *bbolt.DefaultOptions
+	bboltOpts.ReadOnly = true
+	return createBBoltStore(filePath, &bboltOpts, cfg)
+}
+
 func createBBoltStore(filePath string, options *bbolt.Options, cfg stoabs.Config) (stoabs.KVStore, error) {
 	err := os.MkdirAll(path.Dir(filePath), os.ModePerm) // TODO: Right permissions?
 	if err != nil {
@@ -77,82 +111,282 @@ func createBBoltStore(filePath string, options *bbolt.Options, cfg stoabs.Config
 		}
 	}()
 
-	db, err := bbolt.Open(filePath, os.FileMode(0640), options) // TODO: Right permissions?
+	var db *bbolt.DB
+	if cfg.StaleLockRecovery > 0 && !options.ReadOnly {
+		db, err = openWithRecovery(filePath, options, cfg)
+	} else {
+		db, err = bbolt.Open(filePath, os.FileMode(0640), options) // TODO: Right permissions?
+	}
 	done <- true
 	if err != nil {
 		return nil, stoabs.DatabaseError(err)
 	}
 
-	return Wrap(db, cfg), nil
+	result := Wrap(db, cfg).(*store)
+	if cfg.StaleLockRecovery > 0 && !options.ReadOnly {
+		result.closeDB = func() error {
+			return closeWithRecovery(db)
+		}
+	}
+	return result, nil
 }
 
 // Wrap creates a KVStore using an existing bbolt.db
 func Wrap(db *bbolt.DB, cfg stoabs.Config) stoabs.KVStore {
-	return &store{
-		db:   db,
-		cfg:  cfg,
-		log:  cfg.Log,
-		lock: &util.ContextRWLocker{},
+	result := &store{
+		db:      db,
+		cfg:     cfg,
+		log:     cfg.Log,
+		lock:    &util.ContextRWLocker{},
+		drainer: &util.Drainer{},
+		closeDB: db.Close,
+		disk:    &diskStats{},
+	}
+	if cfg.KeyIndexBudget > 0 {
+		result.index = buildKeyIndex(db, cfg.KeyIndexBudget, cfg.Log)
 	}
+	return result
 }
 
 type store struct {
 	db   *bbolt.DB
 	log  *logrus.Logger
 	lock *util.ContextRWLocker
-	cfg  stoabs.Config
+	// keyLocks holds the in-process locks acquired through LockKeys
+	keyLocks util.KeyLocker
+	// drainer tracks in-flight transactions, so Close can wait for them
+	drainer *util.Drainer
+	// index holds the keys of all buckets if enabled (see stoabs.WithKeyIndex), nil otherwise
+	index *keyIndex
+	cfg   stoabs.Config
+	// snapshotFile is the temporary database file if the store is a snapshot (see SnapshotStore), which is removed on Close.
+	snapshotFile string
+	// closeDB closes the database, removing the owner file if it's opened with stale lock recovery (see stoabs.WithStaleLockRecovery).
+	closeDB func() error
+	// disk accumulates the statistics about writing committed transactions to disk.
+	disk *diskStats
 }
 
 func (b *store) Close(ctx context.Context) error {
-	err := util.CallWithTimeout(ctx, b.db.Close, func() {
-		b.log.Error("Closing of BBolt store timed out, store may not shut down correctly.")
+	err := b.drainer.Close(ctx, func() error {
+		if err := b.closeDB(); err != nil {
+			return err
+		}
+		if b.snapshotFile != "" {
+			return os.Remove(b.snapshotFile)
+		}
+		return nil
 	})
 	if err != nil {
+		if ctx.Err() != nil {
+			b.log.Error("Closing of BBolt store timed out, in-flight transactions were aborted.")
+		}
 		return stoabs.DatabaseError(err)
 	}
 	return nil
 }
 
+func (b *store) Capabilities() stoabs.Capabilities {
+	return stoabs.CapabilityShelfLister | stoabs.CapabilityLocker | stoabs.CapabilityLockInspector | stoabs.CapabilityLeaser |
+		stoabs.CapabilityState | stoabs.CapabilityStats | stoabs.CapabilityFreeze | stoabs.CapabilitySnapshot |
+		stoabs.CapabilityOrderedIteration | stoabs.CapabilityBulkDelete | stoabs.CapabilityLazyRange | stoabs.CapabilityEntries |
+		stoabs.CapabilityKeyHistogram | stoabs.CapabilityKeyChecker | stoabs.CapabilityRangeAggregator
+}
+
+func (b *store) State() stoabs.State {
+	return b.drainer.State()
+}
+
+func (b *store) Freeze(ctx context.Context) error {
+	return b.drainer.Freeze(ctx)
+}
+
+func (b *store) Unfreeze() {
+	b.drainer.Unfreeze()
+}
+
+func (b *store) Frozen() bool {
+	return b.drainer.Frozen()
+}
+
+func (b *store) LockKeys(ctx context.Context, shelfName string, keys ...stoabs.Key) (func(), error) {
+	names := make([]string, len(keys))
+	for i, key := range keys {
+		// the NUL byte separates the shelf name from the key
+		names[i] = b.cfg.ShelfName(shelfName) + "\x00" + string(key.Bytes())
+	}
+	return b.keyLocks.Lock(ctx, names...)
+}
+
+func (b *store) Locks() []stoabs.LockInfo {
+	var result []stoabs.LockInfo
+	for _, lock := range b.keyLocks.Locks() {
+		shelfName, key, _ := strings.Cut(lock.Name, "\x00")
+		shelfName, ok := b.cfg.TrimShelfName(shelfName)
+		if !ok {
+			continue
+		}
+		result = append(result, stoabs.LockInfo{
+			Shelf:   shelfName,
+			Key:     stoabs.BytesKey(key),
+			Holder:  lock.Holder,
+			Waiters: lock.Waiters,
+		})
+	}
+	return result
+}
+
+// AcquireLease acquires a lease that is stored in a shelf of the database (see util.AcquireShelfLease),
+// so a lease held when the process stops remains held until it expires.
+func (b *store) AcquireLease(ctx context.Context, name string, ttl time.Duration) (stoabs.Lease, error) {
+	return util.AcquireShelfLease(ctx, b, name, ttl)
+}
+
 func (b *store) Write(ctx context.Context, fn func(stoabs.WriteTx) error, opts ...stoabs.TxOption) error {
-	return b.doTX(ctx, func(tx *bbolt.Tx) error {
+	return b.doTX(ctx, func(ctx context.Context, tx *bbolt.Tx) error {
 		return fn(&bboltTx{tx: tx, store: b, ctx: ctx})
 	}, true, opts)
 }
 
 func (b *store) Read(ctx context.Context, fn func(stoabs.ReadTx) error) error {
-	return b.doTX(ctx, func(tx *bbolt.Tx) error {
+	return b.doTX(ctx, func(ctx context.Context, tx *bbolt.Tx) error {
 		return fn(&bboltTx{tx: tx, store: b, ctx: ctx})
 	}, false, nil)
 }
 
 func (b *store) WriteShelf(ctx context.Context, shelfName string, fn func(writer stoabs.Writer) error) error {
-	return b.doTX(ctx, func(tx *bbolt.Tx) error {
+	return b.doTX(ctx, func(ctx context.Context, tx *bbolt.Tx) error {
 		shelf := bboltTx{tx: tx, store: b, ctx: ctx}.GetShelfWriter(shelfName)
 		return fn(shelf)
 	}, true, nil)
 }
 
 func (b *store) ReadShelf(ctx context.Context, shelfName string, fn func(reader stoabs.Reader) error) error {
-	return b.doTX(ctx, func(tx *bbolt.Tx) error {
+	return b.doTX(ctx, func(ctx context.Context, tx *bbolt.Tx) error {
 		shelf := bboltTx{tx: tx, store: b, ctx: ctx}.GetShelfReader(shelfName)
 		return fn(shelf)
 	}, false, nil)
 }
 
-func (b *store) doTX(ctx context.Context, fn func(tx *bbolt.Tx) error, writable bool, opts []stoabs.TxOption) error {
+func (b *store) ShelfNames(ctx context.Context) ([]string, error) {
+	var result []string
+	err := b.doTX(ctx, func(ctx context.Context, tx *bbolt.Tx) error {
+		// bbolt iterates buckets in byte order, so the names are already sorted (also after trimming the prefix)
+		return tx.ForEach(func(name []byte, _ *bbolt.Bucket) error {
+			if shelfName, ok := b.cfg.TrimShelfName(string(name)); ok {
+				result = append(result, shelfName)
+			}
+			return nil
+		})
+	}, false, nil)
+	if err != nil {
+		return nil, stoabs.DatabaseError(err)
+	}
+	return stoabs.FilterReservedShelves(ctx, result), nil
+}
+
+// maintenanceBucket is the bucket in which maintenance operations on the database file record when they were last performed.
+// It isn't prefixed with the key prefix, since maintenance applies to the file as a whole.
+const maintenanceBucket = stoabs.ReservedShelfPrefix + "maintenance"
+
+// lastCompactionKey is the key in maintenanceBucket that holds the time of the last compaction.
+var lastCompactionKey = []byte("lastCompaction")
+
+// RecordCompaction records that the given database has been compacted at the given time, which is reported by the
+// LastCompaction statistic (see stoabs.Stats). It should be called by tools that compact the database file.
+func RecordCompaction(db *bbolt.DB, at time.Time) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(maintenanceBucket))
+		if err != nil {
+			return err
+		}
+		value, err := at.UTC().MarshalBinary()
+		if err != nil {
+			return err
+		}
+		return bucket.Put(lastCompactionKey, value)
+	})
+}
+
+func (b *store) Stats(ctx context.Context) (stoabs.StoreStats, error) {
+	var result stoabs.StoreStats
+	err := b.doTX(ctx, func(ctx context.Context, tx *bbolt.Tx) error {
+		result.Size = uint64(tx.Size())
+		// all statistics are read within the same transaction, except the free pages which bbolt only reports for the database.
+		// Pending pages are released by committed transactions, and become free once no transaction reads them anymore.
+		dbStats := b.db.Stats()
+		result.FreePages = uint64(dbStats.FreePageN + dbStats.PendingPageN)
+		result.Pages = pageStats(dbStats)
+		result.Disk = b.disk.stats()
+		result.OpenTransactions = b.drainer.InFlight() - 1
+		if bucket := tx.Bucket([]byte(maintenanceBucket)); bucket != nil {
+			if value := bucket.Get(lastCompactionKey); value != nil {
+				if err := result.LastCompaction.UnmarshalBinary(value); err != nil {
+					return fmt.Errorf("invalid last compaction time: %w", err)
+				}
+			}
+		}
+		return tx.ForEach(func(name []byte, _ *bbolt.Bucket) error {
+			if _, ok := b.cfg.TrimShelfName(string(name)); ok {
+				result.Shelves++
+			}
+			return nil
+		})
+	}, false, nil)
+	if err != nil {
+		return stoabs.StoreStats{}, stoabs.DatabaseError(err)
+	}
+	return result, nil
+}
+
+// pageStats converts the statistics of the database to stoabs.PageStats.
+func pageStats(stats bbolt.Stats) *stoabs.PageStats {
+	txStats := stats.TxStats
+	return &stoabs.PageStats{
+		PageAllocations:    uint64(txStats.GetPageCount()),
+		PageAllocatedBytes: uint64(txStats.GetPageAlloc()),
+		NodeAllocations:    uint64(txStats.GetNodeCount()),
+		NodeDereferences:   uint64(txStats.GetNodeDeref()),
+		Rebalances:         uint64(txStats.GetRebalance()),
+		RebalanceDuration:  txStats.GetRebalanceTime(),
+		Splits:             uint64(txStats.GetSplit()),
+		Spills:             uint64(txStats.GetSpill()),
+		SpillDuration:      txStats.GetSpillTime(),
+		Writes:             uint64(txStats.GetWrite()),
+		WriteDuration:      txStats.GetWriteTime(),
+		FreeBytes:          uint64(stats.FreeAlloc),
+		FreelistBytes:      uint64(stats.FreelistInuse),
+		ReadTransactions:   uint64(stats.TxN),
+	}
+}
+
+func (b *store) doTX(ctx context.Context, fn func(ctx context.Context, tx *bbolt.Tx) error, writable bool, opts []stoabs.TxOption) error {
+	begin := b.drainer.Begin
+	if writable {
+		begin = b.drainer.BeginWrite
+	}
+	ctx, done, err := begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
 	var unlock func()
-	lockCtx, lockCtxCancel := context.WithTimeout(ctx, b.cfg.LockAcquireTimeout)
+	lockCtx, lockCtxCancel := stoabs.ContextWithTimeout(ctx, b.cfg.Clock, b.cfg.LockAcquireTimeout)
 	defer lockCtxCancel()
 	if writable {
-		err := b.lock.LockContext(lockCtx)
+		err := b.lock.LockContextHolder(lockCtx, (stoabs.LabelOption{}).Label(opts))
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = stoabs.ErrLockTimeout{Lock: "BBolt write lock", Holder: b.lock.Holder()}
+		}
 		if err != nil {
-			return fmt.Errorf("unable to obtain BBolt write lock: %w", err)
+			return fmt.Errorf("unable to obtain BBolt write lock: %w", stoabs.DatabaseError(err))
 		}
 		unlock = b.lock.Unlock
 	} else {
 		err := b.lock.RLockContext(lockCtx)
 		if err != nil {
-			return fmt.Errorf("unable to obtain BBolt read lock: %w", err)
+			return fmt.Errorf("unable to obtain BBolt read lock: %w", stoabs.DatabaseError(err))
 		}
 		unlock = b.lock.RUnlock
 	}
@@ -164,11 +398,14 @@ func (b *store) doTX(ctx context.Context, fn func(tx *bbolt.Tx) error, writable
 		if err == bbolt.ErrDatabaseNotOpen {
 			return stoabs.ErrStoreIsClosed
 		}
+		if err == bbolt.ErrDatabaseReadOnly {
+			return stoabs.ErrReadOnly
+		}
 		return stoabs.DatabaseError(err)
 	}
 
 	// Perform TX action(s)
-	appError := fn(dbTX)
+	appError := fn(ctx, dbTX)
 
 	// Writable TXs should be committed, non-writable TXs rolled back
 	if !writable {
@@ -180,6 +417,8 @@ func (b *store) doTX(ctx context.Context, fn func(tx *bbolt.Tx) error, writable
 	if appError != nil {
 		b.log.WithError(appError).Warn("Rolling back transaction application due to error")
 		rollbackTX(dbTX, b.log)
+		b.index.discard()
+		b.disk.discard()
 		unlock()
 		stoabs.OnRollbackOption{}.Invoke(opts)
 		return appError
@@ -187,6 +426,7 @@ func (b *store) doTX(ctx context.Context, fn func(tx *bbolt.Tx) error, writable
 
 	b.log.Trace("Committing BBolt transaction")
 	// Check context cancellation, if not cancelled/expired; commit.
+	txID := dbTX.ID()
 	if ctx.Err() != nil {
 		err = ctx.Err()
 		rollbackTX(dbTX, b.log)
@@ -194,11 +434,15 @@ func (b *store) doTX(ctx context.Context, fn func(tx *bbolt.Tx) error, writable
 		err = dbTX.Commit()
 	}
 	if err != nil {
+		b.index.discard()
+		b.disk.discard()
 		unlock()
 		stoabs.OnRollbackOption{}.Invoke(opts)
 		return util.WrapError(stoabs.ErrCommitFailed, err)
 	}
 
+	b.index.commit(txID)
+	b.disk.commit(b.db, dbTX)
 	unlock()
 	stoabs.AfterCommitOption{}.Invoke(opts)
 	return nil
@@ -226,19 +470,31 @@ func (b bboltTx) GetShelfReader(shelfName string) stoabs.Reader {
 }
 
 func (b bboltTx) GetShelfWriter(shelfName string) stoabs.Writer {
-	bucket, err := b.tx.CreateBucketIfNotExists([]byte(shelfName))
+	if invalid := stoabs.InvalidShelf(shelfName); invalid != nil {
+		return invalid
+	}
+	name := b.store.cfg.ShelfName(shelfName)
+	bucket, err := b.tx.CreateBucketIfNotExists([]byte(name))
 	if err != nil {
 		return stoabs.NewErrorWriter(err)
 	}
-	return &bboltShelf{bucket: bucket, ctx: b.ctx}
+	if b.store.cfg.IsAppendOptimized(shelfName) {
+		// not persisted, so it's set for every transaction
+		bucket.FillPercent = appendFillPercent
+	}
+	return &bboltShelf{bucket: bucket, name: name, index: b.store.index, disk: b.store.disk, ctx: b.ctx, zeroCopy: !b.store.cfg.CloneValues(true), validate: b.store.cfg.Validator(shelfName), immutable: b.store.cfg.Immutable(shelfName)}
 }
 
 func (b bboltTx) getBucket(shelfName string) stoabs.Reader {
-	bucket := b.tx.Bucket([]byte(shelfName))
+	if invalid := stoabs.InvalidShelf(shelfName); invalid != nil {
+		return invalid
+	}
+	name := b.store.cfg.ShelfName(shelfName)
+	bucket := b.tx.Bucket([]byte(name))
 	if bucket == nil {
 		return stoabs.NilReader{}
 	}
-	return &bboltShelf{bucket: bucket, ctx: b.ctx}
+	return &bboltShelf{bucket: bucket, name: name, index: b.store.index, ctx: b.ctx, zeroCopy: !b.store.cfg.CloneValues(true)}
 }
 
 func (b bboltTx) Store() stoabs.KVStore {
@@ -247,7 +503,19 @@ func (b bboltTx) Store() stoabs.KVStore {
 
 type bboltShelf struct {
 	bucket *bbolt.Bucket
-	ctx    context.Context
+	// name is the name of the bucket, including the key prefix.
+	name string
+	// index is the key index of the store, nil if it isn't enabled (see stoabs.WithKeyIndex).
+	index *keyIndex
+	// disk accumulates the statistics about writing committed transactions to disk, nil for readers.
+	disk *diskStats
+	ctx  context.Context
+	// zeroCopy specifies whether values are returned without copying them, see stoabs.WithValueCloning.
+	zeroCopy bool
+	// validate runs the validators of the shelf (see stoabs.WithValidator), nil if it has none.
+	validate func(key stoabs.Key, value []byte) error
+	// immutable returns stoabs.ErrImmutable for a key if the shelf is write-once (see stoabs.WithWriteOnce), nil otherwise.
+	immutable func(key stoabs.Key) error
 }
 
 func (t bboltShelf) Empty() (bool, error) {
@@ -261,34 +529,116 @@ func (t bboltShelf) Get(key stoabs.Key) ([]byte, error) {
 	if value == nil {
 		return nil, stoabs.ErrKeyNotFound
 	}
+	return t.value(value), nil
+}
 
+// value returns the given value read from BBolt, copied unless value cloning is disabled.
+func (t bboltShelf) value(value []byte) []byte {
+	if t.zeroCopy {
+		return value
+	}
 	// Because things will go terribly wrong when you use a []byte returned by BBolt outside its transaction,
 	// we want to make sure to work with a copy.
 	//
 	// This seems to be the best (and shortest) way to copy a byte slice:
 	// https://github.com/go101/go101/wiki/How-to-perfectly-clone-a-slice%3F
-	return append(value[:0:0], value...), nil
+	return append(value[:0:0], value...)
 }
 
 func (t bboltShelf) Put(key stoabs.Key, value []byte) error {
+	if t.validate != nil {
+		if err := t.validate(key, value); err != nil {
+			return err
+		}
+	}
+	if t.immutable != nil && t.bucket.Get(key.Bytes()) != nil {
+		return t.immutable(key)
+	}
 	if err := t.bucket.Put(key.Bytes(), value); err != nil {
 		return stoabs.DatabaseError(err)
 	}
+	t.index.record(t.name, key.Bytes(), false)
+	t.disk.put(key.Bytes(), value)
 	return nil
 }
 
 func (t bboltShelf) Delete(key stoabs.Key) error {
+	if t.immutable != nil {
+		return t.immutable(key)
+	}
 	if err := t.bucket.Delete(key.Bytes()); err != nil {
 		return stoabs.DatabaseError(err)
 	}
+	t.index.record(t.name, key.Bytes(), true)
+	return nil
+}
+
+// Exists uses the key index if enabled, and otherwise looks up the key without copying its value.
+func (t bboltShelf) Exists(key stoabs.Key) (bool, error) {
+	var result bool
+	if t.index.read(t.bucket.Tx(), t.name, func(keys []string) {
+		result = contains(keys, key.Bytes())
+	}) {
+		return result, nil
+	}
+	return t.bucket.Get(key.Bytes()) != nil, nil
+}
+
+// Aggregate counts the keys using the key index if enabled, other aggregates aren't supported.
+func (t bboltShelf) Aggregate(from stoabs.Key, to stoabs.Key, aggregator stoabs.Aggregator) error {
+	count, ok := aggregator.(*stoabs.Count)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	if !t.index.read(t.bucket.Tx(), t.name, func(keys []string) {
+		count.N += countRange(keys, from.Bytes(), to.Bytes())
+	}) {
+		return errors.ErrUnsupported
+	}
 	return nil
 }
 
+// DeleteWhere deletes the matching entries while walking the bucket with a single cursor.
+func (t bboltShelf) DeleteWhere(keyType stoabs.Key, predicate func(key stoabs.Key, value []byte) bool) (int, error) {
+	if t.immutable != nil {
+		return 0, t.immutable(nil)
+	}
+	deleted := 0
+	cursor := t.bucket.Cursor()
+	for k, v := cursor.First(); k != nil; {
+		// Potentially long-running operation, check context for cancellation
+		if t.ctx.Err() != nil {
+			return deleted, stoabs.DatabaseError(t.ctx.Err())
+		}
+		key, err := keyType.FromBytes(k)
+		if err != nil {
+			// should never happen
+			return deleted, err
+		}
+		if !predicate(key, t.value(v)) {
+			k, v = cursor.Next()
+			continue
+		}
+		// k is invalid after deleting it, and Next() skips an entry after Delete(), so seek the successor instead
+		kCopy := bytes.Clone(k)
+		if err := cursor.Delete(); err != nil {
+			return deleted, stoabs.DatabaseError(err)
+		}
+		t.index.record(t.name, kCopy, true)
+		deleted++
+		k, v = cursor.Seek(kCopy)
+	}
+	return deleted, nil
+}
+
 func (t bboltShelf) Stats() stoabs.ShelfStats {
-	return stoabs.ShelfStats{
-		NumEntries: uint(t.bucket.Stats().KeyN),
-		ShelfSize:  uint(t.bucket.Tx().Size()),
+	result := stoabs.ShelfStats{ShelfSize: uint(t.bucket.Tx().Size())}
+	if !t.index.read(t.bucket.Tx(), t.name, func(keys []string) {
+		result.NumEntries = uint(len(keys))
+	}) {
+		result.NumEntries = uint(t.bucket.Stats().KeyN)
 	}
+	return result
 }
 
 func (t bboltShelf) Iterate(callback stoabs.CallerFn, keyType stoabs.Key) error {
@@ -298,8 +648,8 @@ func (t bboltShelf) Iterate(callback stoabs.CallerFn, keyType stoabs.Key) error
 		if t.ctx.Err() != nil {
 			return stoabs.DatabaseError(t.ctx.Err())
 		}
-		// return a copy to avoid data manipulation
-		vCopy := append(v[:0:0], v...)
+		// return a copy to avoid data manipulation, unless zero-copy reads are enabled
+		vCopy := t.value(v)
 		key, err := keyType.FromBytes(k)
 		if err != nil {
 			// should never happen
@@ -313,6 +663,68 @@ func (t bboltShelf) Iterate(callback stoabs.CallerFn, keyType stoabs.Key) error
 }
 
 func (t bboltShelf) Range(from stoabs.Key, to stoabs.Key, callback stoabs.CallerFn, stopAtNil bool) error {
+	return t.rangeEntries(from, to, func(key stoabs.Key, value []byte) error {
+		// return a copy to avoid data manipulation, unless zero-copy reads are enabled
+		return callback(key, t.value(value))
+	}, stopAtNil)
+}
+
+// RangeLazy only copies the value of an entry when its loader is called.
+func (t bboltShelf) RangeLazy(from stoabs.Key, to stoabs.Key, callback stoabs.LazyCallerFn, stopAtNil bool) error {
+	return t.rangeEntries(from, to, func(key stoabs.Key, value []byte) error {
+		return callback(key, func() ([]byte, error) {
+			return t.value(value), nil
+		})
+	}, stopAtNil)
+}
+
+// Entries reads the entries with a cursor as the loop advances.
+func (t bboltShelf) Entries(from stoabs.Key, to stoabs.Key) iter.Seq2[stoabs.KeyValue, error] {
+	return func(yield func(stoabs.KeyValue, error) bool) {
+		cursor := t.bucket.Cursor()
+		for k, v := cursor.Seek(from.Bytes()); k != nil && bytes.Compare(k, to.Bytes()) < 0; k, v = cursor.Next() {
+			// Potentially long-running operation, check context for cancellation
+			if t.ctx.Err() != nil {
+				yield(stoabs.KeyValue{}, stoabs.DatabaseError(t.ctx.Err()))
+				return
+			}
+			key, err := from.FromBytes(k)
+			if err != nil {
+				yield(stoabs.KeyValue{}, err)
+				return
+			}
+			// return a copy to avoid data manipulation, unless zero-copy reads are enabled
+			if !yield(stoabs.KeyValue{Key: key, Value: t.value(v)}, nil) {
+				return
+			}
+		}
+	}
+}
+
+// KeyHistogram only visits the keys with a cursor, the smallest and largest key are read directly.
+func (t bboltShelf) KeyHistogram(buckets int, keyType stoabs.Key) (stoabs.Histogram, error) {
+	cursor := t.bucket.Cursor()
+	first, _ := cursor.First()
+	if first == nil {
+		return stoabs.Histogram{}, nil
+	}
+	last, _ := cursor.Last()
+	builder, err := stoabs.NewHistogramBuilder(buckets, keyType, first, last)
+	if err != nil {
+		return stoabs.Histogram{}, err
+	}
+	for k, _ := cursor.First(); k != nil; k, _ = cursor.Next() {
+		// Potentially long-running operation, check context for cancellation
+		if t.ctx.Err() != nil {
+			return stoabs.Histogram{}, stoabs.DatabaseError(t.ctx.Err())
+		}
+		builder.Add(k)
+	}
+	return builder.Histogram()
+}
+
+// rangeEntries calls fn with the key and the value as returned by BBolt (only valid during the transaction) for each entry from..to.
+func (t bboltShelf) rangeEntries(from stoabs.Key, to stoabs.Key, fn func(key stoabs.Key, value []byte) error, stopAtNil bool) error {
 	cursor := t.bucket.Cursor()
 	var prevKey stoabs.Key
 	for k, v := cursor.Seek(from.Bytes()); k != nil && bytes.Compare(k, to.Bytes()) < 0; k, v = cursor.Next() {
@@ -328,9 +740,7 @@ func (t bboltShelf) Range(from stoabs.Key, to stoabs.Key, callback stoabs.Caller
 			// gap found, stop here
 			return nil
 		}
-		// return a copy to avoid data manipulation
-		vCopy := append(v[:0:0], v...)
-		if err := callback(key, vCopy); err != nil {
+		if err := fn(key, v); err != nil {
 			return err
 		}
 		prevKey = key
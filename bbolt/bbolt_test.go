@@ -23,9 +23,11 @@ import (
 	"errors"
 	"fmt"
 	"github.com/nuts-foundation/go-stoabs/kvtests"
+	"github.com/nuts-foundation/go-stoabs/mocks"
 	"github.com/sirupsen/logrus"
 	"github.com/sirupsen/logrus/hooks/test"
 	"go.etcd.io/bbolt"
+	"os"
 	"path"
 	"path/filepath"
 	"testing"
@@ -34,6 +36,7 @@ import (
 	"github.com/nuts-foundation/go-stoabs"
 	"github.com/nuts-foundation/go-stoabs/util"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var key = []byte{1, 2, 3}
@@ -41,6 +44,31 @@ var value = []byte{4, 5, 6}
 
 const shelf = "test"
 
+func TestMain(m *testing.M) {
+	util.RunHelpers(map[string]util.Helper{
+		// open opens the store at the given path (read-only if the second argument is "read-only"), until told to close it
+		"open": func(args []string) error {
+			util.HelperSignal("opening")
+			var store stoabs.KVStore
+			var err error
+			if len(args) > 1 && args[1] == "read-only" {
+				store, err = CreateReadOnlyBBoltStore(args[0])
+			} else {
+				store, err = CreateBBoltStore(args[0])
+			}
+			if err != nil {
+				return err
+			}
+			util.HelperSignal("opened")
+			if err := util.HelperAwait("close"); err != nil {
+				return err
+			}
+			return store.Close(context.Background())
+		},
+	})
+	os.Exit(m.Run())
+}
+
 func TestBBolt(t *testing.T) {
 	provider := func(t *testing.T) (stoabs.KVStore, error) {
 		return CreateBBoltStore(path.Join(util.TestDirectory(t), "bbolt.db"), stoabs.WithNoSync())
@@ -48,13 +76,47 @@ func TestBBolt(t *testing.T) {
 
 	kvtests.TestReadingAndWriting(t, provider)
 	kvtests.TestRange(t, provider)
+	kvtests.TestAggregate(t, provider)
 	kvtests.TestIterate(t, provider)
+	kvtests.TestOrderedIteration(t, provider)
 	kvtests.TestEmpty(t, provider)
 	kvtests.TestClose(t, provider)
 	kvtests.TestDelete(t, provider)
 	kvtests.TestStats(t, provider)
 	kvtests.TestWriteTransactions(t, provider)
 	kvtests.TestTransactionWriteLock(t, provider)
+	kvtests.TestByteTransparency(t, provider)
+	kvtests.TestLinearizability(t, provider)
+	kvtests.TestErrors(t, provider)
+	kvtests.TestCapabilities(t, provider, kvtests.CapabilityShelfLister, kvtests.CapabilityLocker, kvtests.CapabilityLeaser, kvtests.CapabilityBulkDelete, kvtests.CapabilityLazyRange, kvtests.CapabilityState, kvtests.CapabilityStats, kvtests.CapabilityEntries, kvtests.CapabilityFreeze, kvtests.CapabilityKeyHistogram, kvtests.CapabilitySnapshot)
+	kvtests.TestValidator(t, func(t *testing.T, opts ...stoabs.Option) (stoabs.KVStore, error) {
+		return CreateBBoltStore(path.Join(util.TestDirectory(t), "bbolt.db"), opts...)
+	})
+	kvtests.TestWriteOnce(t, func(t *testing.T, opts ...stoabs.Option) (stoabs.KVStore, error) {
+		return CreateBBoltStore(path.Join(util.TestDirectory(t), "bbolt.db"), opts...)
+	})
+	kvtests.TestKeyPrefix(t, func(t *testing.T, prefixes ...string) ([]stoabs.KVStore, error) {
+		db, err := bbolt.Open(path.Join(util.TestDirectory(t), "bbolt.db"), 0600, nil)
+		if err != nil {
+			return nil, err
+		}
+		t.Cleanup(func() {
+			_ = db.Close()
+		})
+		var stores []stoabs.KVStore
+		for _, prefix := range prefixes {
+			cfg := stoabs.DefaultConfig()
+			stoabs.WithKeyPrefix(prefix)(&cfg)
+			stores = append(stores, Wrap(db, cfg))
+		}
+		return stores, nil
+	}, true)
+}
+
+func FuzzBBolt_Range(f *testing.F) {
+	kvtests.FuzzRange(f, func(t *testing.T) (stoabs.KVStore, error) {
+		return CreateBBoltStore(path.Join(util.TestDirectory(t), "bbolt.db"), stoabs.WithNoSync())
+	})
 }
 
 func TestBBolt_Unwrap(t *testing.T) {
@@ -137,6 +199,280 @@ func TestBBolt_CreateBBoltStore(t *testing.T) {
 	})
 }
 
+func TestBBolt_MultiProcess(t *testing.T) {
+	ctx := context.Background()
+	fileTimeout = 10 * time.Millisecond
+	defer func() {
+		fileTimeout = defaultFileTimeout
+	}()
+	const timeout = 5 * time.Second
+	createFile := func(t *testing.T) string {
+		filename := filepath.Join(util.TestDirectory(t), "bbolt.db")
+		store, err := CreateBBoltStore(filename, stoabs.WithNoSync())
+		require.NoError(t, err)
+		require.NoError(t, store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+			return writer.Put(stoabs.BytesKey(key), value)
+		}))
+		require.NoError(t, store.Close(ctx))
+		return filename
+	}
+	// open opens the store in a goroutine, logging to the returned hook
+	open := func(create func(string, ...stoabs.Option) (stoabs.KVStore, error), filename string) (chan stoabs.KVStore, *test.Hook) {
+		logger, hook := test.NewNullLogger()
+		opened := make(chan stoabs.KVStore, 1)
+		go func() {
+			store, err := create(filename, stoabs.WithLogger(logger))
+			if err == nil {
+				opened <- store
+			}
+		}()
+		return opened, hook
+	}
+	waitForLockWarning := func(t *testing.T, hook *test.Hook, filename string) {
+		util.WaitFor(t, func() (bool, error) {
+			return hook.LastEntry() != nil, nil
+		}, timeout, "time-out while waiting for log message")
+		assert.Equal(t, fmt.Sprintf("Trying to open %s, but file appears to be locked", filename), hook.LastEntry().Message)
+	}
+
+	t.Run("file opened by other process logs warning", func(t *testing.T) {
+		filename := createFile(t)
+		helper := util.StartHelper(t, "open", filename)
+		require.NoError(t, helper.Expect("opened", timeout))
+
+		opened, hook := open(CreateBBoltStore, filename)
+
+		waitForLockWarning(t, hook, filename)
+		assert.Len(t, opened, 0)
+		// store can be opened after the other process closed it
+		require.NoError(t, helper.Send("close"))
+		require.NoError(t, helper.Wait(timeout))
+		select {
+		case store := <-opened:
+			_ = store.Close(ctx)
+		case <-time.After(timeout):
+			t.Fatal("store wasn't opened after the other process closed it")
+		}
+	})
+	t.Run("other process waits until file is closed", func(t *testing.T) {
+		filename := createFile(t)
+		store, err := CreateBBoltStore(filename)
+		require.NoError(t, err)
+		helper := util.StartHelper(t, "open", filename)
+		require.NoError(t, helper.Expect("opening", timeout))
+
+		assert.Error(t, helper.Expect("opened", 100*time.Millisecond))
+
+		require.NoError(t, store.Close(ctx))
+		require.NoError(t, helper.Expect("opened", timeout))
+		require.NoError(t, helper.Send("close"))
+		assert.NoError(t, helper.Wait(timeout))
+	})
+	t.Run("read-only opens don't block each other", func(t *testing.T) {
+		filename := createFile(t)
+		helper := util.StartHelper(t, "open", filename, "read-only")
+		require.NoError(t, helper.Expect("opened", timeout))
+
+		opened, _ := open(CreateReadOnlyBBoltStore, filename)
+
+		var store stoabs.KVStore
+		select {
+		case store = <-opened:
+			defer store.Close(ctx)
+		case <-time.After(timeout):
+			t.Fatal("read-only store wasn't opened")
+		}
+		err := store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+			actual, err := reader.Get(stoabs.BytesKey(key))
+			assert.Equal(t, value, actual)
+			return err
+		})
+		assert.NoError(t, err)
+		err = store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+			return writer.Put(stoabs.BytesKey(key), value)
+		})
+		assert.ErrorIs(t, err, stoabs.ErrReadOnly)
+	})
+	t.Run("read-only open by other process blocks writer", func(t *testing.T) {
+		filename := createFile(t)
+		helper := util.StartHelper(t, "open", filename, "read-only")
+		require.NoError(t, helper.Expect("opened", timeout))
+
+		opened, hook := open(CreateBBoltStore, filename)
+
+		waitForLockWarning(t, hook, filename)
+		require.NoError(t, helper.Send("close"))
+		select {
+		case store := <-opened:
+			_ = store.Close(ctx)
+		case <-time.After(timeout):
+			t.Fatal("store wasn't opened after the other process closed it")
+		}
+	})
+}
+
+func TestBBolt_AcquireLease(t *testing.T) {
+	ctx := context.Background()
+	dbPath := path.Join(util.TestDirectory(t), "bbolt.db")
+	store, err := CreateBBoltStore(dbPath, stoabs.WithNoSync())
+	require.NoError(t, err)
+
+	t.Run("lease is persisted", func(t *testing.T) {
+		_, err := stoabs.AcquireLease(ctx, store, "resource", time.Minute)
+		require.NoError(t, err)
+		_ = store.Close(ctx)
+		store, err = CreateBBoltStore(dbPath, stoabs.WithNoSync())
+		require.NoError(t, err)
+
+		_, err = stoabs.AcquireLease(ctx, store, "resource", time.Minute)
+
+		assert.ErrorIs(t, err, stoabs.ErrLeaseHeld)
+	})
+	t.Run("expired lease can be acquired", func(t *testing.T) {
+		_, err := stoabs.AcquireLease(ctx, store, "expires", time.Millisecond)
+		require.NoError(t, err)
+		time.Sleep(2 * time.Millisecond)
+
+		lease, err := stoabs.AcquireLease(ctx, store, "expires", time.Minute)
+
+		require.NoError(t, err)
+		_ = lease.Release(ctx)
+	})
+	_ = store.Close(ctx)
+}
+
+func TestBBolt_LockKeys(t *testing.T) {
+	ctx := context.Background()
+	store, err := CreateBBoltStore(path.Join(util.TestDirectory(t), "bbolt.db"), stoabs.WithNoSync(), stoabs.WithKeyPrefix("prefix/"))
+	require.NoError(t, err)
+	defer store.Close(ctx)
+
+	t.Run("deadlock", func(t *testing.T) {
+		ctx1 := stoabs.ContextWithLockOwner(ctx, "owner-1")
+		ctx2 := stoabs.ContextWithLockOwner(ctx, "owner-2")
+		release1, err := stoabs.LockKeys(ctx1, store, "accounts", stoabs.BytesKey("alice"))
+		require.NoError(t, err)
+		release2, err := stoabs.LockKeys(ctx2, store, "accounts", stoabs.BytesKey("bob"))
+		require.NoError(t, err)
+		waiting := make(chan error, 1)
+		go func() {
+			release, err := stoabs.LockKeys(ctx1, store, "accounts", stoabs.BytesKey("bob"))
+			if err == nil {
+				release()
+			}
+			waiting <- err
+		}()
+		require.Eventually(t, func() bool {
+			locks, _ := stoabs.Locks(store)
+			return len(locks) == 2 && len(locks[1].Waiters) == 1
+		}, time.Second, time.Millisecond)
+
+		_, err = stoabs.LockKeys(ctx2, store, "accounts", stoabs.BytesKey("alice"))
+
+		assert.ErrorIs(t, err, stoabs.ErrDeadlock)
+		release2()
+		assert.NoError(t, <-waiting)
+		release1()
+	})
+	t.Run("locks", func(t *testing.T) {
+		release, err := stoabs.LockKeys(stoabs.ContextWithLockOwner(ctx, "job"), store, "accounts", stoabs.BytesKey("alice"))
+		require.NoError(t, err)
+
+		locks, err := stoabs.Locks(store)
+
+		require.NoError(t, err)
+		assert.Equal(t, []stoabs.LockInfo{{Shelf: "accounts", Key: stoabs.BytesKey("alice"), Holder: "job"}}, locks)
+		release()
+		locks, _ = stoabs.Locks(store)
+		assert.Empty(t, locks)
+	})
+}
+
+func TestBBolt_Stats(t *testing.T) {
+	ctx := context.Background()
+	filePath := path.Join(util.TestDirectory(t), "bbolt.db")
+	store, err := CreateBBoltStore(filePath, stoabs.WithNoSync())
+	require.NoError(t, err)
+	require.NoError(t, store.WriteShelf(ctx, "shelf", func(writer stoabs.Writer) error {
+		return writer.Put(stoabs.BytesKey("key"), []byte("value"))
+	}))
+
+	t.Run("file size and free pages", func(t *testing.T) {
+		stats, err := stoabs.Stats(ctx, store)
+
+		require.NoError(t, err)
+		info, err := os.Stat(filePath)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(info.Size()), stats.Size)
+		// bbolt has free (pending) pages after a commit, since the pages of the previous version are released
+		assert.Less(t, uint64(0), stats.FreePages)
+		assert.Equal(t, 1, stats.Shelves)
+		assert.True(t, stats.LastCompaction.IsZero())
+	})
+	t.Run("page statistics", func(t *testing.T) {
+		stats, err := stoabs.Stats(ctx, store)
+
+		require.NoError(t, err)
+		require.NotNil(t, stats.Pages)
+		assert.Less(t, uint64(0), stats.Pages.PageAllocations)
+		assert.Less(t, uint64(0), stats.Pages.Spills)
+		assert.Less(t, uint64(0), stats.Pages.Writes)
+		assert.Less(t, uint64(0), stats.Pages.ReadTransactions)
+	})
+	t.Run("disk statistics", func(t *testing.T) {
+		stats, err := stoabs.Stats(ctx, store)
+
+		require.NoError(t, err)
+		require.NotNil(t, stats.Disk)
+		assert.Equal(t, uint64(1), stats.Disk.Commits)
+		assert.Equal(t, uint64(len("key")+len("value")), stats.Disk.BytesPut)
+		// the small shelf is inlined in the page of the root bucket, which is written along with the meta page
+		assert.Equal(t, uint64(2*os.Getpagesize()), stats.Disk.BytesWritten)
+		assert.Less(t, 1.0, stats.Disk.WriteAmplification())
+		// syncing is disabled
+		assert.Equal(t, uint64(0), stats.Disk.Syncs)
+	})
+	t.Run("disk statistics, with syncing", func(t *testing.T) {
+		store, err := CreateBBoltStore(path.Join(util.TestDirectory(t), "bbolt.db"))
+		require.NoError(t, err)
+		defer store.Close(ctx)
+		require.NoError(t, store.WriteShelf(ctx, "shelf", func(writer stoabs.Writer) error {
+			return writer.Put(stoabs.BytesKey("key"), []byte("value"))
+		}))
+		// rolled back transactions aren't counted
+		_ = store.WriteShelf(ctx, "shelf", func(writer stoabs.Writer) error {
+			_ = writer.Put(stoabs.BytesKey("other"), []byte("value"))
+			return errors.New("failed")
+		})
+
+		stats, err := stoabs.Stats(ctx, store)
+
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), stats.Disk.Commits)
+		assert.Equal(t, uint64(len("key")+len("value")), stats.Disk.BytesPut)
+		assert.Equal(t, uint64(2), stats.Disk.Syncs)
+		assert.Less(t, time.Duration(0), stats.Disk.SyncDuration)
+	})
+	t.Run("last compaction", func(t *testing.T) {
+		require.NoError(t, store.Close(ctx))
+		db, err := bbolt.Open(filePath, 0600, nil)
+		require.NoError(t, err)
+		compactedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		require.NoError(t, RecordCompaction(db, compactedAt))
+		require.NoError(t, db.Close())
+		store, err = CreateBBoltStore(filePath, stoabs.WithNoSync())
+		require.NoError(t, err)
+		defer store.Close(ctx)
+
+		stats, err := stoabs.Stats(ctx, store)
+
+		require.NoError(t, err)
+		assert.Equal(t, compactedAt, stats.LastCompaction)
+		// the maintenance bucket is an internal shelf
+		assert.Equal(t, 2, stats.Shelves)
+	})
+}
+
 func TestBBolt_Close(t *testing.T) {
 	ctx := context.Background()
 	var bytesKey = stoabs.BytesKey([]byte{1, 2, 3})
@@ -153,3 +489,155 @@ func TestBBolt_Close(t *testing.T) {
 		})
 	})
 }
+
+func TestBBolt_LockAcquireTimeout(t *testing.T) {
+	ctx := context.Background()
+	clock := mocks.NewClock(time.Now())
+	store, err := CreateBBoltStore(path.Join(util.TestDirectory(t), "bbolt.db"), stoabs.WithNoSync(),
+		stoabs.WithClock(clock), stoabs.WithLockAcquireTimeout(time.Minute))
+	require.NoError(t, err)
+	defer store.Close(ctx)
+	locked := make(chan struct{})
+	release := make(chan struct{})
+	go func() {
+		_ = store.Write(ctx, func(tx stoabs.WriteTx) error {
+			close(locked)
+			<-release
+			return nil
+		})
+	}()
+	<-locked
+	errs := make(chan error, 1)
+	go func() {
+		errs <- store.Write(ctx, func(tx stoabs.WriteTx) error {
+			return nil
+		})
+	}()
+	// both transactions started their lock acquisition timeout
+	util.WaitFor(t, func() (bool, error) {
+		return clock.Waiters() == 2, nil
+	}, 5*time.Second, "second transaction isn't waiting for the lock")
+	assert.Len(t, errs, 0)
+
+	clock.Advance(time.Minute)
+
+	assert.ErrorIs(t, <-errs, context.DeadlineExceeded)
+	close(release)
+}
+
+func TestBBolt_ValueCloning(t *testing.T) {
+	ctx := context.Background()
+	// large enough for the bucket not to be inlined, since BBolt may copy inlined buckets when opening them
+	value := make([]byte, 4096)
+	// isMapped returns whether the value references the memory-mapped data of the transaction
+	isMapped := func(tx stoabs.ReadTx, value []byte) bool {
+		mapped := tx.Unwrap().(*bbolt.Tx).Bucket([]byte(shelf)).Get(key)
+		return &mapped[0] == &value[0]
+	}
+	read := func(t *testing.T, store stoabs.KVStore) (bool, bool, bool) {
+		require.NoError(t, store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+			return writer.Put(stoabs.BytesKey(key), value)
+		}))
+		var get, iterate, rangeValue bool
+		err := store.Read(ctx, func(tx stoabs.ReadTx) error {
+			reader := tx.GetShelfReader(shelf)
+			actual, err := reader.Get(stoabs.BytesKey(key))
+			if err != nil {
+				return err
+			}
+			get = isMapped(tx, actual)
+			_ = reader.Iterate(func(_ stoabs.Key, actual []byte) error {
+				iterate = isMapped(tx, actual)
+				return nil
+			}, stoabs.BytesKey{})
+			return reader.Range(stoabs.BytesKey(key), stoabs.BytesKey(key).Next(), func(_ stoabs.Key, actual []byte) error {
+				rangeValue = isMapped(tx, actual)
+				return nil
+			}, false)
+		})
+		require.NoError(t, err)
+		return get, iterate, rangeValue
+	}
+
+	assertMapped := func(t *testing.T, store stoabs.KVStore, expected bool) {
+		get, iterate, rangeValue := read(t, store)
+
+		assert.Equal(t, expected, get)
+		assert.Equal(t, expected, iterate)
+		assert.Equal(t, expected, rangeValue)
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		store, err := CreateBBoltStore(path.Join(util.TestDirectory(t), "bbolt.db"), stoabs.WithNoSync(), stoabs.WithValueCloning(false))
+		require.NoError(t, err)
+		defer store.Close(ctx)
+
+		assertMapped(t, store, true)
+	})
+	t.Run("disabled using WithZeroCopyReads", func(t *testing.T) {
+		store, err := CreateBBoltStore(path.Join(util.TestDirectory(t), "bbolt.db"), stoabs.WithNoSync(), stoabs.WithZeroCopyReads())
+		require.NoError(t, err)
+		defer store.Close(ctx)
+
+		assertMapped(t, store, true)
+	})
+	t.Run("enabled", func(t *testing.T) {
+		store, err := CreateBBoltStore(path.Join(util.TestDirectory(t), "bbolt.db"), stoabs.WithNoSync(), stoabs.WithValueCloning(true))
+		require.NoError(t, err)
+		defer store.Close(ctx)
+
+		assertMapped(t, store, false)
+	})
+	t.Run("enabled by default", func(t *testing.T) {
+		store, err := createStore(t)
+		require.NoError(t, err)
+
+		assertMapped(t, store, false)
+	})
+}
+
+func TestBBolt_AppendOptimized(t *testing.T) {
+	ctx := context.Background()
+	// leafPages appends sequential keys to the shelf and returns the number of leaf pages of its bucket
+	leafPages := func(t *testing.T, store stoabs.KVStore) int {
+		for batch := 0; batch < 10; batch++ {
+			require.NoError(t, store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+				for i := 0; i < 1000; i++ {
+					if err := writer.Put(stoabs.Uint64Key(batch*1000+i), make([]byte, 100)); err != nil {
+						return err
+					}
+				}
+				return nil
+			}))
+		}
+		var result int
+		require.NoError(t, store.Read(ctx, func(tx stoabs.ReadTx) error {
+			result = tx.Unwrap().(*bbolt.Tx).Bucket([]byte(shelf)).Stats().LeafPageN
+			return nil
+		}))
+		return result
+	}
+	store, err := createStore(t)
+	require.NoError(t, err)
+	defaultPages := leafPages(t, store)
+
+	t.Run("all shelves", func(t *testing.T) {
+		store, err := CreateBBoltStore(path.Join(util.TestDirectory(t), "bbolt.db"), stoabs.WithNoSync(), stoabs.WithAppendOptimized())
+		require.NoError(t, err)
+		defer store.Close(ctx)
+
+		pages := leafPages(t, store)
+
+		// pages are filled completely instead of half
+		assert.Less(t, pages, defaultPages*6/10)
+	})
+	t.Run("other shelf", func(t *testing.T) {
+		store, err := CreateBBoltStore(path.Join(util.TestDirectory(t), "bbolt.db"), stoabs.WithNoSync(), stoabs.WithAppendOptimized("other"))
+		require.NoError(t, err)
+		defer store.Close(ctx)
+
+		pages := leafPages(t, store)
+
+		assert.Equal(t, defaultPages, pages)
+	})
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package bbolt
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"go.etcd.io/bbolt"
+)
+
+// syncsPerCommit is the number of times BBolt syncs the database file when committing a write transaction:
+// once after writing the dirty pages, and once after writing the meta page.
+const syncsPerCommit = 2
+
+// diskStats accumulates the statistics about writing committed transactions to disk, see stoabs.DiskStats.
+type diskStats struct {
+	// pendingBytesPut holds the bytes put by the current write transaction, write transactions are serialized by the write lock.
+	pendingBytesPut atomic.Uint64
+
+	commits      atomic.Uint64
+	bytesPut     atomic.Uint64
+	bytesWritten atomic.Uint64
+	syncs        atomic.Uint64
+	syncDuration atomic.Int64
+}
+
+// put records the logical size of a put in the current write transaction.
+func (d *diskStats) put(key []byte, value []byte) {
+	if d == nil {
+		return
+	}
+	d.pendingBytesPut.Add(uint64(len(key) + len(value)))
+}
+
+// discard forgets the puts of the current write transaction, when it's rolled back.
+func (d *diskStats) discard() {
+	if d == nil {
+		return
+	}
+	d.pendingBytesPut.Store(0)
+}
+
+// commit records the writes of the given committed transaction. BBolt doesn't report the bytes it writes,
+// but every dirty page (including the freelist) is allocated when committing, so they're the allocated pages plus the meta page.
+func (d *diskStats) commit(db *bbolt.DB, tx *bbolt.Tx) {
+	if d == nil {
+		return
+	}
+	txStats := tx.Stats()
+	d.commits.Add(1)
+	d.bytesPut.Add(d.pendingBytesPut.Swap(0))
+	d.bytesWritten.Add(uint64(txStats.GetPageAlloc()) + uint64(db.Info().PageSize))
+	if !db.NoSync || bbolt.IgnoreNoSync {
+		d.syncs.Add(syncsPerCommit)
+	}
+	d.syncDuration.Add(int64(txStats.GetWriteTime()))
+}
+
+func (d *diskStats) stats() *stoabs.DiskStats {
+	return &stoabs.DiskStats{
+		Commits:      d.commits.Load(),
+		BytesPut:     d.bytesPut.Load(),
+		BytesWritten: d.bytesWritten.Load(),
+		Syncs:        d.syncs.Load(),
+		SyncDuration: time.Duration(d.syncDuration.Load()),
+	}
+}
@@ -0,0 +1,167 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package bbolt
+
+import (
+	"errors"
+	"slices"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/bbolt"
+)
+
+// keyOverhead is the memory used by a key in the index besides its bytes (the string header).
+const keyOverhead = 16
+
+// keyIndex holds the sorted keys of all buckets in memory, see stoabs.WithKeyIndex.
+// Changes of the write transaction are recorded while it runs, and applied when it commits.
+// Since write transactions hold the store's write lock, only one of them records changes at a time.
+type keyIndex struct {
+	log    *logrus.Logger
+	budget uint64
+	mux    sync.RWMutex
+	// size is the memory used by the keys in the index.
+	size uint64
+	// txID is the ID of the transaction the index reflects, read transactions with another ID don't use it.
+	txID    int
+	buckets map[string][]string
+	// disabled indicates the index exceeded its budget.
+	disabled bool
+	// pending holds the changes of the current write transaction per bucket, in order.
+	pending map[string][]keyChange
+}
+
+type keyChange struct {
+	key     string
+	deleted bool
+}
+
+// buildKeyIndex creates the index of all buckets in the database, using at most budget bytes.
+func buildKeyIndex(db *bbolt.DB, budget uint64, log *logrus.Logger) *keyIndex {
+	index := &keyIndex{log: log, budget: budget, buckets: map[string][]string{}, pending: map[string][]keyChange{}}
+	err := db.View(func(tx *bbolt.Tx) error {
+		index.txID = tx.ID()
+		return tx.ForEach(func(name []byte, bucket *bbolt.Bucket) error {
+			var keys []string
+			err := bucket.ForEach(func(k, _ []byte) error {
+				keys = append(keys, string(k))
+				index.size += uint64(len(k)) + keyOverhead
+				if index.size > budget {
+					return errBudgetExceeded
+				}
+				return nil
+			})
+			index.buckets[string(name)] = keys
+			return err
+		})
+	})
+	if err != nil {
+		index.disable(err)
+	}
+	return index
+}
+
+// errBudgetExceeded stops building the index when it doesn't fit its budget.
+var errBudgetExceeded = errors.New("key index exceeds its memory budget")
+
+// read calls fn with the sorted keys of the bucket (nil if it has none) if the index reflects the given read transaction,
+// and returns whether it did. The keys must not be retained or modified.
+func (i *keyIndex) read(tx *bbolt.Tx, bucket string, fn func(keys []string)) bool {
+	if i == nil || tx.Writable() {
+		return false
+	}
+	i.mux.RLock()
+	defer i.mux.RUnlock()
+	if i.disabled || i.txID != tx.ID() {
+		return false
+	}
+	fn(i.buckets[bucket])
+	return true
+}
+
+// record records a change of the current write transaction.
+func (i *keyIndex) record(bucket string, key []byte, deleted bool) {
+	if i == nil {
+		return
+	}
+	i.pending[bucket] = append(i.pending[bucket], keyChange{key: string(key), deleted: deleted})
+}
+
+// discard discards the changes of the current write transaction, which is rolled back.
+func (i *keyIndex) discard() {
+	if i == nil {
+		return
+	}
+	clear(i.pending)
+}
+
+// commit applies the changes of the current write transaction, which has been committed with the given ID.
+func (i *keyIndex) commit(txID int) {
+	if i == nil {
+		return
+	}
+	i.mux.Lock()
+	defer i.mux.Unlock()
+	defer clear(i.pending)
+	if i.disabled {
+		return
+	}
+	for bucket, changes := range i.pending {
+		keys := i.buckets[bucket]
+		for _, change := range changes {
+			pos, found := slices.BinarySearch(keys, change.key)
+			switch {
+			case change.deleted && found:
+				keys = slices.Delete(keys, pos, pos+1)
+				i.size -= uint64(len(change.key)) + keyOverhead
+			case !change.deleted && !found:
+				keys = slices.Insert(keys, pos, change.key)
+				i.size += uint64(len(change.key)) + keyOverhead
+			}
+		}
+		i.buckets[bucket] = keys
+		if i.size > i.budget {
+			i.disable(errBudgetExceeded)
+			return
+		}
+	}
+	i.txID = txID
+}
+
+// disable drops the index, after which the database is read instead.
+func (i *keyIndex) disable(err error) {
+	i.log.WithError(err).Warn("Key index disabled, reading keys from the database")
+	i.disabled = true
+	i.buckets = nil
+	i.size = 0
+}
+
+// contains returns whether the sorted keys contain the given key.
+func contains(keys []string, key []byte) bool {
+	_, found := slices.BinarySearch(keys, string(key))
+	return found
+}
+
+// countRange returns the number of sorted keys from (inclusive) and to (exclusive) the given keys.
+func countRange(keys []string, from []byte, to []byte) int {
+	start, _ := slices.BinarySearch(keys, string(from))
+	end, _ := slices.BinarySearch(keys, string(to))
+	return max(end-start, 0)
+}
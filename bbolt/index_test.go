@@ -0,0 +1,226 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package bbolt
+
+import (
+	"context"
+	"errors"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests"
+	"github.com/nuts-foundation/go-stoabs/util"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBBolt_KeyIndex(t *testing.T) {
+	ctx := context.Background()
+	provider := func(t *testing.T) (stoabs.KVStore, error) {
+		return CreateBBoltStore(path.Join(util.TestDirectory(t), "bbolt.db"), stoabs.WithNoSync(), stoabs.WithKeyIndex(1<<20))
+	}
+	// indexed reports the number of keys, whether they exist and their count in [from, to) as read from a read transaction,
+	// and fails if the key index wasn't used.
+	indexed := func(t *testing.T, store stoabs.KVStore, keys ...string) (uint, []bool, int) {
+		var entries uint
+		var exists []bool
+		var count stoabs.Count
+		err := store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+			shelf, ok := reader.(*bboltShelf)
+			if !ok {
+				return errors.New("shelf doesn't exist")
+			}
+			require.True(t, shelf.index.read(shelf.bucket.Tx(), shelf.name, func([]string) {}), "index not used")
+			entries = reader.Stats().NumEntries
+			for _, key := range keys {
+				found, err := stoabs.Exists(reader, stoabs.BytesKey(key))
+				if err != nil {
+					return err
+				}
+				exists = append(exists, found)
+			}
+			return stoabs.Aggregate(reader, stoabs.BytesKey("b"), stoabs.BytesKey("d"), &count)
+		})
+		require.NoError(t, err)
+		return entries, exists, count.N
+	}
+	put := func(writer stoabs.Writer, keys ...string) error {
+		for _, key := range keys {
+			if err := writer.Put(stoabs.BytesKey(key), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	t.Run("conformance", func(t *testing.T) {
+		kvtests.TestReadingAndWriting(t, provider)
+		kvtests.TestAggregate(t, provider)
+		kvtests.TestEmpty(t, provider)
+		kvtests.TestDelete(t, provider)
+		kvtests.TestStats(t, provider)
+		kvtests.TestWriteTransactions(t, provider)
+	})
+	t.Run("built when opening", func(t *testing.T) {
+		filePath := path.Join(util.TestDirectory(t), "bbolt.db")
+		store, err := CreateBBoltStore(filePath, stoabs.WithNoSync())
+		require.NoError(t, err)
+		require.NoError(t, store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+			return put(writer, "a", "b", "c", "d")
+		}))
+		require.NoError(t, store.Close(ctx))
+
+		store, err = CreateBBoltStore(filePath, stoabs.WithNoSync(), stoabs.WithKeyIndex(1<<20))
+		require.NoError(t, err)
+		defer store.Close(ctx)
+		entries, exists, count := indexed(t, store, "a", "e")
+
+		assert.Equal(t, uint(4), entries)
+		assert.Equal(t, []bool{true, false}, exists)
+		assert.Equal(t, 2, count)
+	})
+	t.Run("maintained when committing", func(t *testing.T) {
+		store, err := provider(t)
+		require.NoError(t, err)
+		defer store.Close(ctx)
+		require.NoError(t, store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+			return put(writer, "a", "b", "c", "d", "e")
+		}))
+		require.NoError(t, store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+			if err := writer.Delete(stoabs.BytesKey("c")); err != nil {
+				return err
+			}
+			if err := put(writer, "b", "bb"); err != nil {
+				return err
+			}
+			_, err := stoabs.DeleteWhere(writer, stoabs.BytesKey{}, func(key stoabs.Key, _ []byte) bool {
+				return key.Equals(stoabs.BytesKey("e"))
+			})
+			return err
+		}))
+
+		entries, exists, count := indexed(t, store, "a", "c", "bb", "e")
+
+		assert.Equal(t, uint(4), entries)
+		assert.Equal(t, []bool{true, false, true, false}, exists)
+		assert.Equal(t, 2, count)
+	})
+	t.Run("rolled back changes are discarded", func(t *testing.T) {
+		store, err := provider(t)
+		require.NoError(t, err)
+		defer store.Close(ctx)
+		require.NoError(t, store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+			return put(writer, "a")
+		}))
+		err = store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+			if err := writer.Delete(stoabs.BytesKey("a")); err != nil {
+				return err
+			}
+			if err := put(writer, "b"); err != nil {
+				return err
+			}
+			return errors.New("failed")
+		})
+		require.EqualError(t, err, "failed")
+
+		entries, exists, _ := indexed(t, store, "a", "b")
+
+		assert.Equal(t, uint(1), entries)
+		assert.Equal(t, []bool{true, false}, exists)
+	})
+	t.Run("not used by write transactions", func(t *testing.T) {
+		store, err := provider(t)
+		require.NoError(t, err)
+		defer store.Close(ctx)
+
+		err = store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+			if err := put(writer, "a"); err != nil {
+				return err
+			}
+			exists, err := stoabs.Exists(writer, stoabs.BytesKey("a"))
+			assert.True(t, exists)
+			return err
+		})
+
+		assert.NoError(t, err)
+	})
+	t.Run("not used by other transactions", func(t *testing.T) {
+		kvStore, err := provider(t)
+		require.NoError(t, err)
+		defer kvStore.Close(ctx)
+		store := kvStore.(*store)
+		require.NoError(t, store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+			return put(writer, "a")
+		}))
+		// a write that bypasses the store (and thus the index)
+		require.NoError(t, RecordCompaction(store.db, time.Now()))
+
+		err = store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+			shelf := reader.(*bboltShelf)
+			assert.False(t, shelf.index.read(shelf.bucket.Tx(), shelf.name, func([]string) {}))
+			exists, err := stoabs.Exists(reader, stoabs.BytesKey("a"))
+			assert.True(t, exists)
+			assert.Equal(t, uint(1), reader.Stats().NumEntries)
+			return err
+		})
+
+		assert.NoError(t, err)
+	})
+	t.Run("exceeding the budget disables the index", func(t *testing.T) {
+		logger, hook := test.NewNullLogger()
+		kvStore, err := CreateBBoltStore(path.Join(util.TestDirectory(t), "bbolt.db"), stoabs.WithNoSync(), stoabs.WithLogger(logger),
+			stoabs.WithKeyIndex(2*(keyOverhead+1)))
+		require.NoError(t, err)
+		defer kvStore.Close(ctx)
+		store := kvStore.(*store)
+
+		require.NoError(t, store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+			return put(writer, "a", "b", "c")
+		}))
+
+		assert.True(t, store.index.disabled)
+		assert.Equal(t, "Key index disabled, reading keys from the database", hook.LastEntry().Message)
+		err = store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+			exists, err := stoabs.Exists(reader, stoabs.BytesKey("c"))
+			assert.True(t, exists)
+			assert.Equal(t, uint(3), reader.Stats().NumEntries)
+			return err
+		})
+		assert.NoError(t, err)
+	})
+	t.Run("exceeding the budget when opening", func(t *testing.T) {
+		filePath := path.Join(util.TestDirectory(t), "bbolt.db")
+		created, err := CreateBBoltStore(filePath, stoabs.WithNoSync())
+		require.NoError(t, err)
+		require.NoError(t, created.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+			return put(writer, "a", "b", "c")
+		}))
+		require.NoError(t, created.Close(ctx))
+
+		logger, _ := test.NewNullLogger()
+		reopened, err := CreateBBoltStore(filePath, stoabs.WithNoSync(), stoabs.WithLogger(logger), stoabs.WithKeyIndex(keyOverhead+1))
+		require.NoError(t, err)
+		defer reopened.Close(ctx)
+
+		assert.True(t, reopened.(*store).index.disabled)
+	})
+}
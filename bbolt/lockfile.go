@@ -0,0 +1,211 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package bbolt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"go.etcd.io/bbolt"
+)
+
+// owner identifies the process that holds the file lock of a database, see stoabs.WithStaleLockRecovery.
+type owner struct {
+	Host string `json:"host"`
+	PID  int    `json:"pid"`
+}
+
+func (o owner) String() string {
+	return fmt.Sprintf("process %d on %s", o.PID, o.Host)
+}
+
+// ownerFile returns the path of the owner file of the given database file.
+func ownerFile(filePath string) string {
+	return filePath + ".owner"
+}
+
+// openFiles counts the opens of database files (by absolute path) by this process with stale lock recovery, including opens in flight,
+// since a lock held by this process (through another file descriptor) isn't stale.
+var openFiles = struct {
+	mux   sync.Mutex
+	paths map[string]int
+}{paths: map[string]int{}}
+
+// registerOpen registers an open of the given database file, which must be unregistered when the open fails or the file is closed.
+func registerOpen(absPath string) {
+	openFiles.mux.Lock()
+	defer openFiles.mux.Unlock()
+	openFiles.paths[absPath]++
+}
+
+// unregisterOpen unregisters an open registered by registerOpen. It returns whether the file is still open(ing) by this process.
+func unregisterOpen(absPath string) bool {
+	openFiles.mux.Lock()
+	defer openFiles.mux.Unlock()
+	openFiles.paths[absPath]--
+	if openFiles.paths[absPath] > 0 {
+		return true
+	}
+	delete(openFiles.paths, absPath)
+	return false
+}
+
+// openWithRecovery opens the database file, recovering a stale lock if it can't be locked within cfg.StaleLockRecovery.
+// When opened, it records this process as owner of the lock.
+// The open is registered before locking the file, so concurrent opens of the same file by this process don't consider its lock stale.
+func openWithRecovery(filePath string, options *bbolt.Options, cfg stoabs.Config) (*bbolt.DB, error) {
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return nil, err
+	}
+	registerOpen(absPath)
+	db, err := openAndRecover(filePath, absPath, options, cfg)
+	if err != nil {
+		unregisterOpen(absPath)
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	data, _ := json.Marshal(owner{Host: host, PID: os.Getpid()})
+	if err := os.WriteFile(ownerFile(filePath), data, 0640); err != nil {
+		_ = db.Close()
+		unregisterOpen(absPath)
+		return nil, err
+	}
+	return db, nil
+}
+
+// openAndRecover opens the database file for openWithRecovery, recovering a stale lock.
+func openAndRecover(filePath string, absPath string, options *bbolt.Options, cfg stoabs.Config) (*bbolt.DB, error) {
+	timeoutOptions := *options
+	timeoutOptions.Timeout = cfg.StaleLockRecovery
+	db, err := bbolt.Open(filePath, os.FileMode(0640), &timeoutOptions)
+	if errors.Is(err, bbolt.ErrTimeout) {
+		holder, stale := staleOwner(absPath, true)
+		if stale {
+			cfg.Log.Warnf("File lock of %s is held by %s, which no longer runs: recovering the stale lock", filePath, holder)
+			if err := replaceFile(filePath); err != nil {
+				return nil, fmt.Errorf("unable to recover stale lock of %s: %w", filePath, err)
+			}
+			db, err = bbolt.Open(filePath, os.FileMode(0640), &timeoutOptions)
+		} else {
+			cfg.Log.Warnf("File lock of %s is held by %s, waiting for it to be released", filePath, holder)
+			db, err = bbolt.Open(filePath, os.FileMode(0640), options)
+		}
+	}
+	return db, err
+}
+
+// closeWithRecovery removes the owner file after closing the database opened by openWithRecovery.
+func closeWithRecovery(db *bbolt.DB) error {
+	filePath := db.Path()
+	if err := db.Close(); err != nil {
+		return err
+	}
+	absPath, _ := filepath.Abs(filePath)
+	if unregisterOpen(absPath) {
+		// another open of this process takes over the lock, and records itself as owner
+		return nil
+	}
+	if err := os.Remove(ownerFile(filePath)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// staleOwner returns the owner of the lock of the given database file, and whether the lock is stale:
+// the owner is a process on this host that no longer runs. A lock without (readable) owner file isn't considered stale.
+// The lock of this process is only stale if the file isn't opened by this process; opening tells whether the caller
+// is opening the file itself, which is registered (see registerOpen) but doesn't hold the lock.
+func staleOwner(absPath string, opening bool) (string, bool) {
+	data, err := os.ReadFile(ownerFile(absPath))
+	if err != nil {
+		return "an unknown process", false
+	}
+	var holder owner
+	if err := json.Unmarshal(data, &holder); err != nil {
+		return "an unknown process", false
+	}
+	host, _ := os.Hostname()
+	if holder.Host != host {
+		return holder.String(), false
+	}
+	if holder.PID == os.Getpid() {
+		// this process, or a previous process with the same PID (e.g. in a restarted container)
+		openFiles.mux.Lock()
+		defer openFiles.mux.Unlock()
+		opens := openFiles.paths[absPath]
+		if opening {
+			opens--
+		}
+		return holder.String(), opens <= 0
+	}
+	return holder.String(), !processRuns(holder.PID)
+}
+
+// processRuns returns whether a process with the given PID runs. If that can't be determined, it assumes it does.
+func processRuns(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		// only fails on Windows, if the process doesn't exist
+		return false
+	}
+	err = process.Signal(syscall.Signal(0))
+	return !errors.Is(err, os.ErrProcessDone)
+}
+
+// replaceFile replaces the database file with a copy, which is a new file that isn't locked.
+// The stale lock remains on the replaced file.
+func replaceFile(filePath string) error {
+	source, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	info, err := source.Stat()
+	if err != nil {
+		return err
+	}
+	target, err := os.CreateTemp(path.Dir(filePath), path.Base(filePath)+".recover-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(target.Name())
+	_, err = io.Copy(target, source)
+	if err == nil {
+		err = target.Sync()
+	}
+	if closeErr := target.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(target.Name(), info.Mode())
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(target.Name(), filePath)
+}
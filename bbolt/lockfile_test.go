@@ -0,0 +1,194 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package bbolt
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+func TestBBolt_StaleLockRecovery(t *testing.T) {
+	ctx := context.Background()
+	host, _ := os.Hostname()
+	// holdLock opens the database file with BBolt directly, which locks it without recording an owner
+	holdLock := func(t *testing.T, filePath string, holder owner) *bbolt.DB {
+		db, err := bbolt.Open(filePath, 0640, nil)
+		require.NoError(t, err)
+		require.NoError(t, db.Update(func(tx *bbolt.Tx) error {
+			bucket, err := tx.CreateBucketIfNotExists([]byte(shelf))
+			if err != nil {
+				return err
+			}
+			return bucket.Put([]byte("key"), []byte("value"))
+		}))
+		t.Cleanup(func() {
+			_ = db.Close()
+		})
+		data, _ := json.Marshal(holder)
+		require.NoError(t, os.WriteFile(ownerFile(filePath), data, 0640))
+		return db
+	}
+	open := func(filePath string) <-chan stoabs.KVStore {
+		result := make(chan stoabs.KVStore, 1)
+		go func() {
+			store, err := CreateBBoltStore(filePath, stoabs.WithNoSync(), stoabs.WithStaleLockRecovery(10*time.Millisecond))
+			assert.NoError(t, err)
+			result <- store
+		}()
+		return result
+	}
+	assertWaits := func(t *testing.T, opened <-chan stoabs.KVStore) {
+		select {
+		case <-opened:
+			t.Fatal("opened while the lock is held")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	t.Run("lock of a process that no longer runs is recovered", func(t *testing.T) {
+		filePath := path.Join(util.TestDirectory(t), "bbolt.db")
+		holdLock(t, filePath, owner{Host: host, PID: deadPID(t)})
+
+		store := <-open(filePath)
+
+		var value []byte
+		require.NoError(t, store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+			var err error
+			value, err = reader.Get(stoabs.BytesKey("key"))
+			return err
+		}))
+		assert.Equal(t, []byte("value"), value)
+		holder, stale := staleOwner(absolute(t, filePath), false)
+		assert.Equal(t, owner{Host: host, PID: os.Getpid()}.String(), holder)
+		assert.False(t, stale)
+		require.NoError(t, store.Close(ctx))
+		assert.NoFileExists(t, ownerFile(filePath))
+	})
+	t.Run("waits for a process that runs", func(t *testing.T) {
+		filePath := path.Join(util.TestDirectory(t), "bbolt.db")
+		db := holdLock(t, filePath, owner{Host: host, PID: os.Getppid()})
+		opened := open(filePath)
+
+		assertWaits(t, opened)
+		require.NoError(t, db.Close())
+
+		store := <-opened
+		assert.NoError(t, store.Close(ctx))
+	})
+	t.Run("waits for this process", func(t *testing.T) {
+		filePath := path.Join(util.TestDirectory(t), "bbolt.db")
+		first := <-open(filePath)
+		opened := open(filePath)
+
+		assertWaits(t, opened)
+		require.NoError(t, first.Close(ctx))
+
+		store := <-opened
+		assert.NoError(t, store.Close(ctx))
+	})
+	t.Run("concurrent opens by this process", func(t *testing.T) {
+		filePath := path.Join(util.TestDirectory(t), "bbolt.db")
+		// left by a previous process with the PID of this process
+		data, _ := json.Marshal(owner{Host: host, PID: os.Getpid()})
+		require.NoError(t, os.WriteFile(ownerFile(filePath), data, 0640))
+		first := open(filePath)
+		second := open(filePath)
+
+		// one of them opens the file, the other waits for it instead of recovering the lock
+		var store stoabs.KVStore
+		var opened <-chan stoabs.KVStore
+		select {
+		case store = <-first:
+			opened = second
+		case store = <-second:
+			opened = first
+		}
+		assertWaits(t, opened)
+		require.NoError(t, store.Close(ctx))
+
+		store = <-opened
+		assert.NoError(t, store.Close(ctx))
+		assert.NoFileExists(t, ownerFile(filePath))
+	})
+}
+
+func TestStaleOwner(t *testing.T) {
+	host, _ := os.Hostname()
+	stale := func(t *testing.T, holder *owner) bool {
+		filePath := absolute(t, path.Join(util.TestDirectory(t), "bbolt.db"))
+		if holder != nil {
+			data, _ := json.Marshal(holder)
+			require.NoError(t, os.WriteFile(ownerFile(filePath), data, 0640))
+		}
+		_, result := staleOwner(filePath, false)
+		return result
+	}
+
+	t.Run("no owner file", func(t *testing.T) {
+		assert.False(t, stale(t, nil))
+	})
+	t.Run("process on another host", func(t *testing.T) {
+		assert.False(t, stale(t, &owner{Host: host + "-other", PID: deadPID(t)}))
+	})
+	t.Run("process that no longer runs", func(t *testing.T) {
+		assert.True(t, stale(t, &owner{Host: host, PID: deadPID(t)}))
+	})
+	t.Run("previous process with the PID of this process", func(t *testing.T) {
+		assert.True(t, stale(t, &owner{Host: host, PID: os.Getpid()}))
+	})
+	t.Run("file being opened concurrently by this process", func(t *testing.T) {
+		filePath := absolute(t, path.Join(util.TestDirectory(t), "bbolt.db"))
+		data, _ := json.Marshal(owner{Host: host, PID: os.Getpid()})
+		require.NoError(t, os.WriteFile(ownerFile(filePath), data, 0640))
+		// the concurrent open, and the open of the caller
+		registerOpen(filePath)
+		registerOpen(filePath)
+		defer unregisterOpen(filePath)
+		defer unregisterOpen(filePath)
+
+		_, result := staleOwner(filePath, true)
+
+		assert.False(t, result)
+	})
+}
+
+// deadPID returns the PID of a process that no longer runs.
+func deadPID(t *testing.T) int {
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	require.NoError(t, cmd.Run())
+	return cmd.Process.Pid
+}
+
+func absolute(t *testing.T, filePath string) string {
+	result, err := filepath.Abs(filePath)
+	require.NoError(t, err)
+	return result
+}
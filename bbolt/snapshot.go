@@ -0,0 +1,61 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package bbolt
+
+import (
+	"context"
+	"os"
+	"path"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"go.etcd.io/bbolt"
+)
+
+var _ stoabs.Snapshotter = (*store)(nil)
+
+// SnapshotStore copies the database in a read transaction to a temporary file next to the database file,
+// which is opened as read-only store and removed when the snapshot is closed.
+// Holding the read transaction for the lifetime of the snapshot instead would block writes that grow the database file,
+// since BBolt can't remap the file while read transactions are open.
+func (b *store) SnapshotStore(ctx context.Context) (stoabs.KVStore, error) {
+	file, err := os.CreateTemp(path.Dir(b.db.Path()), path.Base(b.db.Path())+".snapshot-*")
+	if err != nil {
+		return nil, stoabs.DatabaseError(err)
+	}
+	err = b.doTX(ctx, func(_ context.Context, tx *bbolt.Tx) error {
+		_, err := tx.WriteTo(file)
+		return err
+	}, false, nil)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return nil, stoabs.DatabaseError(err)
+	}
+	options := *bbolt.DefaultOptions
+	options.ReadOnly = true
+	result, err := createBBoltStore(file.Name(), &options, b.cfg)
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return nil, err
+	}
+	result.(*store).snapshotFile = file.Name()
+	return result, nil
+}
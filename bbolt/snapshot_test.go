@@ -0,0 +1,86 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package bbolt
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBBolt_SnapshotStore(t *testing.T) {
+	ctx := context.Background()
+	dir := util.TestDirectory(t)
+	store, err := CreateBBoltStore(path.Join(dir, "bbolt.db"), stoabs.WithNoSync())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = store.Close(ctx)
+	})
+	files := func() []string {
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		var result []string
+		for _, entry := range entries {
+			result = append(result, entry.Name())
+		}
+		return result
+	}
+
+	t.Run("writes growing the database aren't blocked", func(t *testing.T) {
+		snapshot, err := stoabs.SnapshotStore(ctx, store)
+		require.NoError(t, err)
+		defer snapshot.Close(ctx)
+
+		// 16 MiB, beyond the initial size of the memory map
+		err = store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+			for i := uint32(0); i < 16; i++ {
+				if err := writer.Put(stoabs.Uint32Key(i), make([]byte, 1<<20)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+
+		assert.NoError(t, err)
+	})
+	t.Run("temporary file is removed on close", func(t *testing.T) {
+		snapshot, err := stoabs.SnapshotStore(ctx, store)
+		require.NoError(t, err)
+		require.Len(t, files(), 2)
+
+		require.NoError(t, snapshot.Close(ctx))
+
+		assert.Equal(t, []string{"bbolt.db"}, files())
+	})
+	t.Run("closed store", func(t *testing.T) {
+		store, err := CreateBBoltStore(path.Join(util.TestDirectory(t), "bbolt.db"), stoabs.WithNoSync())
+		require.NoError(t, err)
+		require.NoError(t, store.Close(ctx))
+
+		_, err = stoabs.SnapshotStore(ctx, store)
+
+		assert.ErrorIs(t, err, stoabs.ErrStoreIsClosed)
+	})
+}
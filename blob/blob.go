@@ -0,0 +1,192 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package blob provides content-addressable storage of values in a shelf, with deduplication and reference counting.
+package blob
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/nuts-foundation/go-stoabs"
+)
+
+// refsShelfPrefix is the prefix of the shelves holding the reference counts of the blobs in a shelf (8 bytes, big endian),
+// keyed by the blob's key.
+const refsShelfPrefix = stoabs.ReservedShelfPrefix + "refs/"
+
+// ErrNotFound is returned when the blob doesn't exist.
+var ErrNotFound = errors.New("blob not found")
+
+// ErrCorrupt is returned when the stored value of a blob doesn't match its key.
+var ErrCorrupt = errors.New("blob doesn't match its hash")
+
+// New returns the blob store that holds its blobs in the given shelf, which must not be used for anything else.
+// The shelf is locked (see stoabs.WithShelfLock) when blobs are added or released, so it can be shared by multiple processes.
+func New(store stoabs.KVStore, shelfName string) *Store {
+	return &Store{
+		store:     store,
+		shelfName: shelfName,
+		refsShelf: refsShelfPrefix + shelfName,
+	}
+}
+
+// Store holds content-addressable blobs: the key of a blob is the SHA-256 hash of its value, so storing the same value
+// twice stores it once. Every Put adds a reference to the blob, and every Release removes one. Blobs without references
+// are removed by GC. Use New to create it.
+type Store struct {
+	store     stoabs.KVStore
+	shelfName string
+	refsShelf string
+}
+
+// Key returns the key of a blob holding the given value.
+func Key(value []byte) stoabs.HashKey {
+	return sha256.Sum256(value)
+}
+
+// Put stores the value if it isn't stored yet, and adds a reference to it. It returns the key of the blob.
+func (s *Store) Put(ctx context.Context, value []byte) (stoabs.HashKey, error) {
+	key := Key(value)
+	err := s.write(ctx, func(blobs stoabs.Writer, refs stoabs.Writer) error {
+		count, err := refCount(refs, key)
+		if err != nil {
+			return err
+		}
+		if _, err := blobs.Get(key); errors.Is(err, stoabs.ErrKeyNotFound) {
+			if err := blobs.Put(key, value); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		}
+		return refs.Put(key, binary.BigEndian.AppendUint64(nil, count+1))
+	})
+	return key, err
+}
+
+// Get returns the value of the blob with the given key.
+// It returns ErrNotFound if it doesn't exist, or ErrCorrupt if the stored value doesn't match the key.
+func (s *Store) Get(ctx context.Context, key stoabs.HashKey) ([]byte, error) {
+	var result []byte
+	found := false
+	err := s.store.ReadShelf(ctx, s.shelfName, func(reader stoabs.Reader) error {
+		value, err := reader.Get(key)
+		if errors.Is(err, stoabs.ErrKeyNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if Key(value) != key {
+			return fmt.Errorf("%w (key=%s)", ErrCorrupt, key)
+		}
+		result = append([]byte{}, value...)
+		found = true
+		return nil
+	})
+	if err == nil && !found {
+		return nil, ErrNotFound
+	}
+	return result, err
+}
+
+// RefCount returns the number of references to the blob with the given key, which is 0 if it doesn't exist.
+func (s *Store) RefCount(ctx context.Context, key stoabs.HashKey) (uint64, error) {
+	var result uint64
+	err := s.store.ReadShelf(ctx, s.refsShelf, func(reader stoabs.Reader) error {
+		var err error
+		result, err = refCount(reader, key)
+		return err
+	})
+	return result, err
+}
+
+// Release removes a reference to the blob with the given key. The blob itself is removed by GC when it has no references left.
+// It returns ErrNotFound if the blob has no references.
+func (s *Store) Release(ctx context.Context, key stoabs.HashKey) error {
+	return s.write(ctx, func(_ stoabs.Writer, refs stoabs.Writer) error {
+		count, err := refCount(refs, key)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrNotFound
+		}
+		if count == 1 {
+			return refs.Delete(key)
+		}
+		return refs.Put(key, binary.BigEndian.AppendUint64(nil, count-1))
+	})
+}
+
+// GC removes all blobs without references, in a single transaction. It returns the number of removed blobs.
+func (s *Store) GC(ctx context.Context) (int, error) {
+	var removed int
+	err := s.write(ctx, func(blobs stoabs.Writer, refs stoabs.Writer) error {
+		removed = 0
+		var unreferenced []stoabs.Key
+		err := blobs.Iterate(func(key stoabs.Key, _ []byte) error {
+			count, err := refCount(refs, key)
+			if err != nil {
+				return err
+			}
+			if count == 0 {
+				unreferenced = append(unreferenced, key)
+			}
+			return nil
+		}, stoabs.HashKey{})
+		if err != nil {
+			return err
+		}
+		for _, key := range unreferenced {
+			if err := blobs.Delete(key); err != nil {
+				return err
+			}
+			if err := refs.Delete(key); err != nil {
+				return err
+			}
+		}
+		removed = len(unreferenced)
+		return nil
+	})
+	return removed, err
+}
+
+func (s *Store) write(ctx context.Context, fn func(blobs stoabs.Writer, refs stoabs.Writer) error) error {
+	return s.store.Write(ctx, func(tx stoabs.WriteTx) error {
+		return fn(tx.GetShelfWriter(s.shelfName), tx.GetShelfWriter(s.refsShelf))
+	}, stoabs.WithShelfLock(s.shelfName))
+}
+
+func refCount(reader stoabs.Reader, key stoabs.Key) (uint64, error) {
+	data, err := reader.Get(key)
+	if errors.Is(err, stoabs.ErrKeyNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(data) != 8 {
+		return 0, fmt.Errorf("invalid blob reference count (key=%s)", key)
+	}
+	return binary.BigEndian.Uint64(data), nil
+}
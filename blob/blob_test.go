@@ -0,0 +1,138 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package blob
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/nuts-foundation/go-stoabs/redis7"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+const shelfName = "payloads"
+
+func TestStore(t *testing.T) {
+	t.Run("deduplicates and counts references", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		blobs := New(underlying, shelfName)
+
+		key1, err := blobs.Put(ctx, []byte("payload"))
+		require.NoError(t, err)
+		key2, err := blobs.Put(ctx, []byte("payload"))
+		require.NoError(t, err)
+
+		assert.Equal(t, Key([]byte("payload")), key1)
+		assert.Equal(t, key1, key2)
+		assert.Equal(t, uint64(2), refs(t, blobs, key1))
+		value, err := blobs.Get(ctx, key1)
+		require.NoError(t, err)
+		assert.Equal(t, "payload", string(value))
+		count := 0
+		_ = underlying.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			return reader.Iterate(func(_ stoabs.Key, _ []byte) error {
+				count++
+				return nil
+			}, stoabs.HashKey{})
+		})
+		assert.Equal(t, 1, count)
+	})
+	t.Run("GC removes unreferenced blobs", func(t *testing.T) {
+		blobs := New(stores.BBolt(t), shelfName)
+		kept, _ := blobs.Put(ctx, []byte("kept"))
+		released, _ := blobs.Put(ctx, []byte("released"))
+		_, _ = blobs.Put(ctx, []byte("kept"))
+		require.NoError(t, blobs.Release(ctx, kept))
+		require.NoError(t, blobs.Release(ctx, released))
+
+		// released blobs remain until GC
+		_, err := blobs.Get(ctx, released)
+		require.NoError(t, err)
+		removed, err := blobs.GC(ctx)
+
+		require.NoError(t, err)
+		assert.Equal(t, 1, removed)
+		_, err = blobs.Get(ctx, released)
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.Equal(t, uint64(1), refs(t, blobs, kept))
+		_, err = blobs.Get(ctx, kept)
+		assert.NoError(t, err)
+	})
+	t.Run("release without references", func(t *testing.T) {
+		blobs := New(stores.BBolt(t), shelfName)
+
+		err := blobs.Release(ctx, Key([]byte("unknown")))
+
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+	t.Run("empty value", func(t *testing.T) {
+		blobs := New(stores.BBolt(t), shelfName)
+		key, err := blobs.Put(ctx, []byte{})
+		require.NoError(t, err)
+
+		value, err := blobs.Get(ctx, key)
+
+		require.NoError(t, err)
+		assert.Empty(t, value)
+	})
+	t.Run("corrupt blob", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		blobs := New(underlying, shelfName)
+		key, _ := blobs.Put(ctx, []byte("payload"))
+		require.NoError(t, underlying.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			return writer.Put(key, []byte("tampered"))
+		}))
+
+		_, err := blobs.Get(ctx, key)
+
+		assert.ErrorIs(t, err, ErrCorrupt)
+	})
+	t.Run("Redis", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		underlying, err := redis7.CreateRedisStore("db", &redis.Options{Addr: mr.Addr()})
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = underlying.Close(ctx)
+		})
+		blobs := New(underlying, shelfName)
+		key, err := blobs.Put(ctx, []byte("payload"))
+		require.NoError(t, err)
+		require.NoError(t, blobs.Release(ctx, key))
+
+		removed, err := blobs.GC(ctx)
+
+		require.NoError(t, err)
+		assert.Equal(t, 1, removed)
+		_, err = blobs.Get(ctx, key)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
+func refs(t *testing.T, blobs *Store, key stoabs.HashKey) uint64 {
+	result, err := blobs.RefCount(ctx, key)
+	require.NoError(t, err)
+	return result
+}
@@ -0,0 +1,395 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package bloom provides a KVStore that answers lookups of absent keys using per-shelf bloom filters,
+// without accessing the underlying store.
+package bloom
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/util"
+	"github.com/sirupsen/logrus"
+)
+
+var _ stoabs.KVStore = (*Store)(nil)
+var _ stoabs.ShelfLister = (*Store)(nil)
+var _ stoabs.WriteTx = (*tx)(nil)
+var _ stoabs.Writer = (*shelf)(nil)
+
+// filterShelf is the shelf holding the persisted filters, keyed by shelf name.
+const filterShelf = stoabs.ReservedShelfPrefix + "bloom"
+
+// Config specifies the filter of a shelf.
+type Config struct {
+	// ExpectedEntries is the number of entries the filter is sized for.
+	// The false positive rate increases when the shelf contains more entries.
+	ExpectedEntries uint
+	// FalsePositiveRate is the rate of lookups of absent keys that still access the underlying store, e.g. 0.01.
+	FalsePositiveRate float64
+	// KeyType is the type of the keys of the shelf, used to iterate over it when the filter is built.
+	// It defaults to stoabs.BytesKey, which doesn't work for other key types in Redis.
+	KeyType stoabs.Key
+}
+
+// Option configures the store.
+type Option func(s *Store)
+
+// WithShelf enables a filter for the given shelf. Lookups on shelves without filter always access the underlying store.
+func WithShelf(shelfName string, config Config) Option {
+	return func(s *Store) {
+		if config.KeyType == nil {
+			config.KeyType = stoabs.BytesKey{}
+		}
+		s.filters[shelfName] = &shelfFilter{config: config, live: newFilter(config.ExpectedEntries, config.FalsePositiveRate), stored: true}
+	}
+}
+
+// WithPersistInterval makes write transactions persist the changed filters when the given interval has passed since they
+// were last persisted. By default, filters are only persisted by Persist and Close.
+func WithPersistInterval(interval time.Duration) Option {
+	return func(s *Store) {
+		s.persistInterval = interval
+	}
+}
+
+// WithLogger overrides the default logger.
+func WithLogger(log *logrus.Logger) Option {
+	return func(s *Store) {
+		s.log = log
+	}
+}
+
+// Stats contains the filter counters.
+type Stats struct {
+	// Negatives counts the lookups that were answered by a filter, without accessing the underlying store.
+	Negatives uint64
+	// FalsePositives counts the lookups of absent keys that a filter couldn't rule out.
+	FalsePositives uint64
+}
+
+// Wrap creates a store that maintains a bloom filter for the shelves specified using WithShelf. Get returns
+// stoabs.ErrKeyNotFound without accessing the underlying store if the filter rules out the key.
+// The filter of a shelf is loaded by the first Get on it: it's read from the underlying store if it was persisted
+// (see Persist), otherwise it's built by iterating over the shelf. Deleted keys are only removed from the filter when it's rebuilt.
+// Writes invalidate the persisted filter in the same transaction, so a filter that wasn't persisted after the last write
+// (e.g. because the process crashed) is rebuilt instead of returning false negatives.
+// The store must not be written to by other means, since the filters would not contain the written keys.
+func Wrap(store stoabs.KVStore, opts ...Option) *Store {
+	result := &Store{
+		underlying: store,
+		filters:    map[string]*shelfFilter{},
+		now:        time.Now,
+		log:        logrus.StandardLogger(),
+	}
+	for _, opt := range opts {
+		opt(result)
+	}
+	result.lastPersist = result.now()
+	return result
+}
+
+// Store is a KVStore with bloom filters. Use Wrap to create it.
+type Store struct {
+	underlying      stoabs.KVStore
+	filters         map[string]*shelfFilter
+	persistInterval time.Duration
+	now             func() time.Time
+	log             *logrus.Logger
+	// persistLock is held (shared) by write transactions, and exclusively while persisting filters,
+	// so the persisted filters contain the keys of all write transactions committed before.
+	persistLock util.ContextRWLocker
+	// lastPersist is guarded by persistLock.
+	lastPersist time.Time
+
+	negatives      atomic.Uint64
+	falsePositives atomic.Uint64
+}
+
+// shelfFilter holds the filter of a shelf.
+type shelfFilter struct {
+	config Config
+	mux    sync.Mutex
+	// live contains the keys written since the store was wrapped, and all keys of the shelf once loaded.
+	live   *filter
+	loaded bool
+	// stored indicates the persisted filter (if any) may be valid, so writes must invalidate it.
+	stored bool
+	// dirty indicates keys were added since the filter was last persisted.
+	dirty bool
+}
+
+// Stats returns the filter counters.
+func (s *Store) Stats() Stats {
+	return Stats{Negatives: s.negatives.Load(), FalsePositives: s.falsePositives.Load()}
+}
+
+// Persist writes the filters that changed since they were last persisted to the underlying store.
+// It must not be called from within a transaction on the store.
+func (s *Store) Persist(ctx context.Context) error {
+	if err := s.persistLock.LockContext(ctx); err != nil {
+		return err
+	}
+	defer s.persistLock.Unlock()
+	return s.persist(ctx)
+}
+
+// persist writes the changed filters, the caller must hold persistLock exclusively.
+func (s *Store) persist(ctx context.Context) error {
+	s.lastPersist = s.now()
+	marshalled := map[string][]byte{}
+	for name, f := range s.filters {
+		f.mux.Lock()
+		// filters that haven't been loaded only contain the recently written keys
+		if f.loaded && f.dirty {
+			marshalled[name] = f.live.marshal()
+		}
+		f.mux.Unlock()
+	}
+	if len(marshalled) == 0 {
+		return nil
+	}
+	err := s.underlying.WriteShelf(ctx, filterShelf, func(writer stoabs.Writer) error {
+		for name, data := range marshalled {
+			if err := writer.Put(stoabs.BytesKey(name), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to persist bloom filters: %w", err)
+	}
+	for name := range marshalled {
+		f := s.filters[name]
+		f.mux.Lock()
+		f.stored = true
+		f.dirty = false
+		f.mux.Unlock()
+	}
+	return nil
+}
+
+// Close persists the filters and closes the underlying store.
+func (s *Store) Close(ctx context.Context) error {
+	return errors.Join(s.Persist(ctx), s.underlying.Close(ctx))
+}
+
+func (s *Store) Write(ctx context.Context, fn func(stoabs.WriteTx) error, opts ...stoabs.TxOption) error {
+	if err := s.persistLock.RLockContext(ctx); err != nil {
+		return err
+	}
+	var invalidated map[string]bool
+	err := s.underlying.Write(ctx, func(underlyingTx stoabs.WriteTx) error {
+		invalidated = map[string]bool{}
+		return fn(&tx{ReadTx: underlyingTx, writeTx: underlyingTx, store: s, invalidated: invalidated})
+	}, opts...)
+	if err == nil {
+		for name := range invalidated {
+			f := s.filters[name]
+			f.mux.Lock()
+			f.stored = false
+			f.mux.Unlock()
+		}
+	}
+	s.persistLock.RUnlock()
+	if err == nil && s.persistInterval > 0 {
+		s.persistIfDue(ctx)
+	}
+	return err
+}
+
+// persistIfDue persists the filters if the persist interval has passed. It's skipped if other transactions are in
+// progress (e.g. when called from a transaction nested in another one), and failures are only logged.
+func (s *Store) persistIfDue(ctx context.Context) {
+	if !s.persistLock.TryLock() {
+		return
+	}
+	defer s.persistLock.Unlock()
+	if s.now().Sub(s.lastPersist) < s.persistInterval {
+		return
+	}
+	if err := s.persist(ctx); err != nil {
+		s.log.WithError(err).Warn("Unable to persist bloom filters")
+	}
+}
+
+func (s *Store) WriteShelf(ctx context.Context, shelfName string, fn func(stoabs.Writer) error) error {
+	return s.Write(ctx, func(tx stoabs.WriteTx) error {
+		return fn(tx.GetShelfWriter(shelfName))
+	})
+}
+
+func (s *Store) Read(ctx context.Context, fn func(stoabs.ReadTx) error) error {
+	return s.underlying.Read(ctx, func(underlyingTx stoabs.ReadTx) error {
+		return fn(&tx{ReadTx: underlyingTx, store: s})
+	})
+}
+
+func (s *Store) ReadShelf(ctx context.Context, shelfName string, fn func(stoabs.Reader) error) error {
+	return s.Read(ctx, func(tx stoabs.ReadTx) error {
+		return fn(tx.GetShelfReader(shelfName))
+	})
+}
+
+// ShelfNames returns the shelves of the underlying store. The shelf holding the persisted filters is reserved,
+// so it's only listed when requested using stoabs.ContextWithReservedShelves.
+func (s *Store) ShelfNames(ctx context.Context) ([]string, error) {
+	return stoabs.ShelfNames(ctx, s.underlying)
+}
+
+// load reads the persisted filter of the shelf, or builds it by iterating over the shelf, using the given transaction.
+// Keys written since the store was wrapped are already in the live filter, so it's merged with the loaded filter.
+func (f *shelfFilter) load(tx stoabs.ReadTx, shelfName string) error {
+	loaded, err := f.read(tx, shelfName)
+	if err != nil {
+		return err
+	}
+	if loaded == nil {
+		loaded = newFilter(f.config.ExpectedEntries, f.config.FalsePositiveRate)
+		err = tx.GetShelfReader(shelfName).Iterate(func(key stoabs.Key, _ []byte) error {
+			loaded.add(key.Bytes())
+			return nil
+		}, f.config.KeyType)
+		if err != nil {
+			return fmt.Errorf("unable to build bloom filter of shelf %s: %w", shelfName, err)
+		}
+		f.dirty = true
+	}
+	f.live.merge(loaded)
+	f.loaded = true
+	return nil
+}
+
+// read returns the persisted filter, or nil if it wasn't persisted or has another shape than configured.
+func (f *shelfFilter) read(tx stoabs.ReadTx, shelfName string) (*filter, error) {
+	data, err := tx.GetShelfReader(filterShelf).Get(stoabs.BytesKey(shelfName))
+	if errors.Is(err, stoabs.ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read bloom filter of shelf %s: %w", shelfName, err)
+	}
+	result, err := unmarshalFilter(data)
+	if err != nil || !result.sameShape(f.live) {
+		// corrupt, or the configuration changed
+		return nil, nil
+	}
+	return result, nil
+}
+
+type tx struct {
+	stoabs.ReadTx
+	writeTx stoabs.WriteTx
+	store   *Store
+	// invalidated holds the shelves of which this transaction deleted the persisted filter.
+	invalidated map[string]bool
+}
+
+func (t *tx) GetShelfReader(shelfName string) stoabs.Reader {
+	return t.shelf(shelfName, t.ReadTx.GetShelfReader(shelfName), nil)
+}
+
+func (t *tx) GetShelfWriter(shelfName string) stoabs.Writer {
+	writer := t.writeTx.GetShelfWriter(shelfName)
+	return t.shelf(shelfName, writer, writer)
+}
+
+func (t *tx) shelf(shelfName string, reader stoabs.Reader, writer stoabs.Writer) *shelf {
+	return &shelf{Reader: reader, writer: writer, name: shelfName, filter: t.store.filters[shelfName], tx: t}
+}
+
+func (t *tx) Store() stoabs.KVStore {
+	return t.store
+}
+
+type shelf struct {
+	stoabs.Reader
+	writer stoabs.Writer
+	name   string
+	// filter is nil if the shelf has no filter.
+	filter *shelfFilter
+	tx     *tx
+}
+
+func (s *shelf) Get(key stoabs.Key) ([]byte, error) {
+	if s.filter == nil {
+		return s.Reader.Get(key)
+	}
+	mayContain, err := s.mayContain(key)
+	if err != nil {
+		return nil, err
+	}
+	if !mayContain {
+		s.tx.store.negatives.Add(1)
+		return nil, stoabs.ErrKeyNotFound
+	}
+	value, err := s.Reader.Get(key)
+	if errors.Is(err, stoabs.ErrKeyNotFound) {
+		s.tx.store.falsePositives.Add(1)
+	}
+	return value, err
+}
+
+// mayContain loads the filter if required, and returns whether it might contain the key.
+func (s *shelf) mayContain(key stoabs.Key) (bool, error) {
+	s.filter.mux.Lock()
+	defer s.filter.mux.Unlock()
+	if !s.filter.loaded {
+		if err := s.filter.load(s.tx.ReadTx, s.name); err != nil {
+			return false, err
+		}
+	}
+	return s.filter.live.mayContain(key.Bytes()), nil
+}
+
+func (s *shelf) Put(key stoabs.Key, value []byte) error {
+	if s.filter != nil {
+		if err := s.add(key); err != nil {
+			return err
+		}
+	}
+	return s.writer.Put(key, value)
+}
+
+// add adds the key to the filter before it's written, and invalidates the persisted filter if this transaction didn't yet.
+func (s *shelf) add(key stoabs.Key) error {
+	s.filter.mux.Lock()
+	s.filter.live.add(key.Bytes())
+	s.filter.dirty = true
+	stored := s.filter.stored
+	s.filter.mux.Unlock()
+	if !stored || s.tx.invalidated[s.name] {
+		return nil
+	}
+	if err := s.tx.writeTx.GetShelfWriter(filterShelf).Delete(stoabs.BytesKey(s.name)); err != nil {
+		return fmt.Errorf("unable to invalidate bloom filter of shelf %s: %w", s.name, err)
+	}
+	s.tx.invalidated[s.name] = true
+	return nil
+}
+
+func (s *shelf) Delete(key stoabs.Key) error {
+	return s.writer.Delete(key)
+}
@@ -0,0 +1,227 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package bloom
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/nuts-foundation/go-stoabs/redis7"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+const shelfName = "test"
+
+var config = Config{ExpectedEntries: 100, FalsePositiveRate: 0.01}
+
+func TestBloom(t *testing.T) {
+	provider := func(t *testing.T) (stoabs.KVStore, error) {
+		return Wrap(stores.BBolt(t), WithShelf(shelfName, config)), nil
+	}
+
+	kvtests.TestReadingAndWriting(t, provider)
+	kvtests.TestRange(t, provider)
+	kvtests.TestIterate(t, provider)
+	kvtests.TestEmpty(t, provider)
+	kvtests.TestDelete(t, provider)
+	kvtests.TestWriteTransactions(t, provider)
+	kvtests.TestShelfNames(t, provider)
+}
+
+func TestStore_Get(t *testing.T) {
+	t.Run("absent keys are answered by the filter", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t), WithShelf(shelfName, config))
+		require.NoError(t, put(store, "present"))
+
+		_, err := get(store, "absent")
+
+		assert.ErrorIs(t, err, stoabs.ErrKeyNotFound)
+		assert.Equal(t, uint64(1), store.Stats().Negatives)
+		value, err := get(store, "present")
+		require.NoError(t, err)
+		assert.Equal(t, "value", value)
+	})
+	t.Run("existing entries are added when building the filter", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		require.NoError(t, put(underlying, "existing"))
+		store := Wrap(underlying, WithShelf(shelfName, config))
+
+		value, err := get(store, "existing")
+
+		require.NoError(t, err)
+		assert.Equal(t, "value", value)
+	})
+	t.Run("shelves without filter", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t))
+
+		_, err := get(store, "absent")
+
+		assert.ErrorIs(t, err, stoabs.ErrKeyNotFound)
+		assert.Equal(t, Stats{}, store.Stats())
+	})
+	t.Run("keys of other types (Redis)", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		underlying, err := redis7.CreateRedisStore("db", &redis.Options{Addr: mr.Addr()})
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = underlying.Close(ctx)
+		})
+		require.NoError(t, underlying.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			return writer.Put(stoabs.Uint32Key(1), []byte("value"))
+		}))
+		store := Wrap(underlying, WithShelf(shelfName, Config{ExpectedEntries: 100, FalsePositiveRate: 0.01, KeyType: stoabs.Uint32Key(0)}))
+
+		err = store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			_, err := reader.Get(stoabs.Uint32Key(1))
+			return err
+		})
+
+		assert.NoError(t, err)
+	})
+}
+
+func TestStore_Persist(t *testing.T) {
+	t.Run("persisted filter is used after restart", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		store := Wrap(underlying, WithShelf(shelfName, config))
+		require.NoError(t, put(store, "present"))
+		_, _ = get(store, "present") // loads the filter
+		require.NoError(t, store.Persist(ctx))
+		// an entry written by other means is not in the persisted filter
+		require.NoError(t, put(underlying, "unknown"))
+
+		restarted := Wrap(underlying, WithShelf(shelfName, config))
+
+		_, err := get(restarted, "present")
+		assert.NoError(t, err)
+		_, err = get(restarted, "unknown")
+		assert.ErrorIs(t, err, stoabs.ErrKeyNotFound, "should've used the persisted filter")
+	})
+	t.Run("writes after persisting invalidate the persisted filter", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		store := Wrap(underlying, WithShelf(shelfName, config))
+		_, _ = get(store, "present")
+		require.NoError(t, store.Persist(ctx))
+		require.NoError(t, put(store, "written"))
+
+		// the process crashed without persisting the filter
+		restarted := Wrap(underlying, WithShelf(shelfName, config))
+
+		value, err := get(restarted, "written")
+		require.NoError(t, err)
+		assert.Equal(t, "value", value)
+	})
+	t.Run("rolled back writes don't invalidate the persisted filter", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		store := Wrap(underlying, WithShelf(shelfName, config))
+		_, _ = get(store, "present")
+		require.NoError(t, store.Persist(ctx))
+		_ = store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			_ = writer.Put(stoabs.BytesKey("rolled back"), []byte("value"))
+			return assert.AnError
+		})
+		require.NoError(t, put(store, "written"))
+
+		restarted := Wrap(underlying, WithShelf(shelfName, config))
+
+		_, err := get(restarted, "written")
+		assert.NoError(t, err)
+	})
+	t.Run("interval", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		store := Wrap(underlying, WithShelf(shelfName, config), WithPersistInterval(time.Minute))
+		now := time.Now()
+		store.now = func() time.Time { return now }
+		_, _ = get(store, "present")
+		require.NoError(t, put(store, "first"))
+		require.False(t, persisted(t, underlying))
+
+		now = now.Add(time.Minute)
+		require.NoError(t, put(store, "second"))
+
+		assert.True(t, persisted(t, underlying))
+	})
+	t.Run("close persists filters", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		store := Wrap(unclosable{underlying}, WithShelf(shelfName, config))
+		_, _ = get(store, "present")
+
+		require.NoError(t, store.Close(ctx))
+
+		assert.True(t, persisted(t, underlying))
+	})
+}
+
+func TestStore_ShelfNames(t *testing.T) {
+	underlying := stores.BBolt(t)
+	store := Wrap(underlying, WithShelf(shelfName, config))
+	require.NoError(t, put(store, "key"))
+	_, _ = get(store, "key")
+	require.NoError(t, store.Persist(ctx))
+
+	names, err := store.ShelfNames(ctx)
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{shelfName}, names)
+}
+
+// unclosable is a store that can't be closed, to inspect it after the store wrapping it has been closed.
+type unclosable struct {
+	stoabs.KVStore
+}
+
+func (u unclosable) Close(_ context.Context) error {
+	return nil
+}
+
+func put(store stoabs.KVStore, key string) error {
+	return store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+		return writer.Put(stoabs.BytesKey(key), []byte("value"))
+	})
+}
+
+func get(store stoabs.KVStore, key string) (string, error) {
+	var result []byte
+	err := store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+		var err error
+		result, err = reader.Get(stoabs.BytesKey(key))
+		return err
+	})
+	return string(result), err
+}
+
+func persisted(t *testing.T, store stoabs.KVStore) bool {
+	var result bool
+	err := store.ReadShelf(ctx, filterShelf, func(reader stoabs.Reader) error {
+		_, err := reader.Get(stoabs.BytesKey(shelfName))
+		result = err == nil
+		return nil
+	})
+	require.NoError(t, err)
+	return result
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package bloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+)
+
+// filterHeaderSize is the size of the header of a marshalled filter: the number of hash functions (4 bytes) and the
+// number of bits (8 bytes), big endian.
+const filterHeaderSize = 12
+
+// filter is a bloom filter, using double hashing (Kirsch-Mitzenmacher) of the 128-bit FNV-1a hash of the key.
+// The hash is stable, so filters can be persisted. It's not safe for concurrent use.
+type filter struct {
+	bits []uint64
+	// m is the number of bits.
+	m uint64
+	// k is the number of hash functions.
+	k uint32
+}
+
+// newFilter creates a filter sized for the given number of entries and false positive rate.
+func newFilter(expectedEntries uint, falsePositiveRate float64) *filter {
+	n := math.Max(float64(expectedEntries), 1)
+	m := uint64(math.Ceil(-n * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	m = max(m, 64)
+	k := uint32(math.Max(math.Round(float64(m)/n*math.Ln2), 1))
+	return &filter{bits: make([]uint64, (m+63)/64), m: m, k: k}
+}
+
+func (f *filter) add(key []byte) {
+	h1, h2 := hash(key)
+	for i := uint32(0); i < f.k; i++ {
+		bit := (h1 + uint64(i)*h2) % f.m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// mayContain returns false if the key has certainly not been added, true if it might have been added.
+func (f *filter) mayContain(key []byte) bool {
+	h1, h2 := hash(key)
+	for i := uint32(0); i < f.k; i++ {
+		bit := (h1 + uint64(i)*h2) % f.m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// sameShape returns whether the filters have the same number of bits and hash functions, so they can be merged.
+func (f *filter) sameShape(other *filter) bool {
+	return f.m == other.m && f.k == other.k
+}
+
+// merge adds the keys of the other filter, which must have the same shape.
+func (f *filter) merge(other *filter) {
+	for i, word := range other.bits {
+		f.bits[i] |= word
+	}
+}
+
+func (f *filter) marshal() []byte {
+	result := make([]byte, filterHeaderSize, filterHeaderSize+len(f.bits)*8)
+	binary.BigEndian.PutUint32(result, f.k)
+	binary.BigEndian.PutUint64(result[4:], f.m)
+	for _, word := range f.bits {
+		result = binary.BigEndian.AppendUint64(result, word)
+	}
+	return result
+}
+
+func unmarshalFilter(data []byte) (*filter, error) {
+	if len(data) < filterHeaderSize {
+		return nil, errors.New("invalid bloom filter: too short")
+	}
+	result := &filter{k: binary.BigEndian.Uint32(data), m: binary.BigEndian.Uint64(data[4:])}
+	data = data[filterHeaderSize:]
+	if result.k == 0 || result.m == 0 || uint64(len(data)) != (result.m+63)/64*8 {
+		return nil, errors.New("invalid bloom filter: size mismatch")
+	}
+	result.bits = make([]uint64, len(data)/8)
+	for i := range result.bits {
+		result.bits[i] = binary.BigEndian.Uint64(data[i*8:])
+	}
+	return result, nil
+}
+
+func hash(key []byte) (uint64, uint64) {
+	h := fnv.New128a()
+	_, _ = h.Write(key)
+	sum := h.Sum(nil)
+	// an odd second hash makes sure all bits can be reached when the number of bits is even
+	return binary.BigEndian.Uint64(sum[:8]), binary.BigEndian.Uint64(sum[8:]) | 1
+}
@@ -0,0 +1,73 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package bloom
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilter(t *testing.T) {
+	f := newFilter(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		f.add([]byte(fmt.Sprintf("key-%d", i)))
+	}
+
+	t.Run("no false negatives", func(t *testing.T) {
+		for i := 0; i < 1000; i++ {
+			assert.True(t, f.mayContain([]byte(fmt.Sprintf("key-%d", i))))
+		}
+	})
+	t.Run("false positive rate", func(t *testing.T) {
+		falsePositives := 0
+		for i := 0; i < 10000; i++ {
+			if f.mayContain([]byte(fmt.Sprintf("absent-%d", i))) {
+				falsePositives++
+			}
+		}
+		assert.Less(t, falsePositives, 200)
+	})
+	t.Run("marshal", func(t *testing.T) {
+		unmarshalled, err := unmarshalFilter(f.marshal())
+
+		require.NoError(t, err)
+		assert.Equal(t, f, unmarshalled)
+	})
+	t.Run("unmarshal invalid data", func(t *testing.T) {
+		_, err := unmarshalFilter([]byte{1})
+		assert.EqualError(t, err, "invalid bloom filter: too short")
+
+		_, err = unmarshalFilter(f.marshal()[:filterHeaderSize+8])
+		assert.EqualError(t, err, "invalid bloom filter: size mismatch")
+	})
+	t.Run("merge", func(t *testing.T) {
+		other := newFilter(1000, 0.01)
+		other.add([]byte("other"))
+		require.True(t, f.sameShape(other))
+
+		other.merge(f)
+
+		assert.True(t, other.mayContain([]byte("other")))
+		assert.True(t, other.mayContain([]byte("key-1")))
+		assert.False(t, f.sameShape(newFilter(10, 0.01)))
+	})
+}
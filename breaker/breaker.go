@@ -0,0 +1,283 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package breaker provides a KVStore that fails fast when the underlying store is unhealthy (circuit breaker).
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/util"
+	"github.com/sirupsen/logrus"
+)
+
+var _ stoabs.KVStore = (*Store)(nil)
+var _ stoabs.ShelfLister = (*Store)(nil)
+var _ stoabs.WriteTx = (*writeTx)(nil)
+
+const defaultFailureThreshold = 5
+
+const defaultOpenTimeout = 10 * time.Second
+
+// ErrCircuitOpen is returned when the circuit breaker is open, without invoking the underlying store. Is also a ErrDatabase.
+var ErrCircuitOpen = stoabs.DatabaseError(errors.New("circuit breaker is open"))
+
+// State is the state of the circuit breaker.
+type State int
+
+const (
+	// StateClosed means operations are passed to the underlying store.
+	StateClosed State = iota
+	// StateHalfOpen means a single operation is passed to the underlying store to probe whether it recovered,
+	// other operations fail with ErrCircuitOpen.
+	StateHalfOpen
+	// StateOpen means all operations fail with ErrCircuitOpen.
+	StateOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateHalfOpen:
+		return "half-open"
+	case StateOpen:
+		return "open"
+	default:
+		return fmt.Sprintf("unknown (%d)", int(s))
+	}
+}
+
+// Stats contains the circuit breaker state and counters.
+type Stats struct {
+	State State
+	// Failures counts the operations that failed because of the underlying store.
+	Failures uint64
+	// Trips counts the times the circuit breaker opened.
+	Trips uint64
+	// Rejected counts the operations that failed with ErrCircuitOpen.
+	Rejected uint64
+}
+
+// Option configures the circuit breaker.
+type Option func(s *Store)
+
+// WithFailureThreshold sets the number of consecutive failed operations after which the circuit breaker opens.
+// It defaults to 5.
+func WithFailureThreshold(failures int) Option {
+	return func(s *Store) {
+		s.failureThreshold = failures
+	}
+}
+
+// WithOpenTimeout sets the time the circuit breaker stays open before it probes the underlying store.
+// It defaults to 10 seconds.
+func WithOpenTimeout(timeout time.Duration) Option {
+	return func(s *Store) {
+		s.openTimeout = timeout
+	}
+}
+
+// WithLogger overrides the default logger.
+func WithLogger(log *logrus.Logger) Option {
+	return func(s *Store) {
+		s.log = log
+	}
+}
+
+// Wrap creates a store that opens the circuit after a number of consecutive operations failed (see WithFailureThreshold),
+// failing all operations with ErrCircuitOpen. After a while (see WithOpenTimeout) a single operation is let through
+// to probe the underlying store: if it succeeds the circuit closes, otherwise it stays open.
+// Errors returned by transaction functions and context cancellation don't count as failures of the underlying store.
+func Wrap(store stoabs.KVStore, opts ...Option) *Store {
+	result := &Store{
+		underlying:       store,
+		failureThreshold: defaultFailureThreshold,
+		openTimeout:      defaultOpenTimeout,
+		now:              time.Now,
+		log:              logrus.StandardLogger(),
+	}
+	for _, opt := range opts {
+		opt(result)
+	}
+	return result
+}
+
+// Store is a KVStore with a circuit breaker. Use Wrap to create it.
+type Store struct {
+	underlying       stoabs.KVStore
+	failureThreshold int
+	openTimeout      time.Duration
+	now              func() time.Time
+	log              *logrus.Logger
+
+	mux sync.Mutex
+	// failures is the number of consecutive failures.
+	failures int
+	// openedAt is the time the circuit opened.
+	openedAt time.Time
+	stats    Stats
+}
+
+// Stats returns the circuit breaker state and counters.
+func (s *Store) Stats() Stats {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.stats
+}
+
+// Close closes the underlying store, regardless of the state of the circuit breaker.
+func (s *Store) Close(ctx context.Context) error {
+	return s.underlying.Close(ctx)
+}
+
+func (s *Store) Write(ctx context.Context, fn func(stoabs.WriteTx) error, opts ...stoabs.TxOption) error {
+	return s.execute(ctx, func() error {
+		return s.underlying.Write(ctx, func(underlyingTx stoabs.WriteTx) error {
+			return fn(&writeTx{WriteTx: underlyingTx, store: s})
+		}, opts...)
+	})
+}
+
+func (s *Store) WriteShelf(ctx context.Context, shelfName string, fn func(stoabs.Writer) error) error {
+	return s.Write(ctx, func(tx stoabs.WriteTx) error {
+		return fn(tx.GetShelfWriter(shelfName))
+	})
+}
+
+func (s *Store) Read(ctx context.Context, fn func(stoabs.ReadTx) error) error {
+	return s.execute(ctx, func() error {
+		return s.underlying.Read(ctx, func(underlyingTx stoabs.ReadTx) error {
+			return fn(&readTx{ReadTx: underlyingTx, store: s})
+		})
+	})
+}
+
+func (s *Store) ReadShelf(ctx context.Context, shelfName string, fn func(stoabs.Reader) error) error {
+	return s.Read(ctx, func(tx stoabs.ReadTx) error {
+		return fn(tx.GetShelfReader(shelfName))
+	})
+}
+
+// ShelfNames returns the shelves of the underlying store.
+func (s *Store) ShelfNames(ctx context.Context) ([]string, error) {
+	var result []string
+	err := s.execute(ctx, func() error {
+		var err error
+		result, err = stoabs.ShelfNames(ctx, s.underlying)
+		return err
+	})
+	return result, err
+}
+
+// execute invokes call if the circuit allows it, and updates the state according to the result.
+// Only errors that signal a failure of the store (see util.IsStoreFailure) count as failures,
+// errors returned by the transaction function are application errors unless they stem from the store (e.g. a failed Get).
+func (s *Store) execute(ctx context.Context, call func() error) error {
+	probe, err := s.allow()
+	if err != nil {
+		return err
+	}
+	err = call()
+	switch {
+	case !util.IsStoreFailure(err):
+		s.succeeded()
+	case ctx.Err() != nil:
+		// not a failure of the store, but the probe (if it was one) didn't tell whether the store recovered
+		if probe {
+			s.reopen()
+		}
+	default:
+		s.failed(err)
+	}
+	return err
+}
+
+// allow returns whether an operation may be executed, and whether it is a probe.
+func (s *Store) allow() (bool, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	switch s.stats.State {
+	case StateOpen:
+		if s.now().Sub(s.openedAt) >= s.openTimeout {
+			s.stats.State = StateHalfOpen
+			return true, nil
+		}
+	case StateClosed:
+		return false, nil
+	}
+	s.stats.Rejected++
+	return false, ErrCircuitOpen
+}
+
+func (s *Store) succeeded() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.failures = 0
+	if s.stats.State != StateClosed {
+		s.stats.State = StateClosed
+		s.log.Info("Store recovered, circuit breaker closed")
+	}
+}
+
+func (s *Store) failed(err error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.stats.Failures++
+	s.failures++
+	if s.stats.State == StateHalfOpen || (s.stats.State == StateClosed && s.failures >= s.failureThreshold) {
+		if s.stats.State == StateClosed {
+			s.stats.Trips++
+			s.log.WithError(err).Warn("Store is failing, circuit breaker opened")
+		}
+		s.stats.State = StateOpen
+		s.openedAt = s.now()
+	}
+}
+
+// reopen opens the circuit again after an inconclusive probe, allowing the next operation to probe right away.
+func (s *Store) reopen() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.stats.State == StateHalfOpen {
+		s.stats.State = StateOpen
+	}
+}
+
+type readTx struct {
+	stoabs.ReadTx
+	store *Store
+}
+
+func (t *readTx) Store() stoabs.KVStore {
+	return t.store
+}
+
+type writeTx struct {
+	stoabs.WriteTx
+	store *Store
+}
+
+func (t *writeTx) Store() stoabs.KVStore {
+	return t.store
+}
@@ -0,0 +1,148 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package breaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/stretchr/testify/assert"
+)
+
+var ctx = context.Background()
+
+var errUnavailable = stoabs.DatabaseError(errors.New("connection refused"))
+
+func TestBreaker(t *testing.T) {
+	provider := func(t *testing.T) (stoabs.KVStore, error) {
+		return Wrap(stores.BBolt(t)), nil
+	}
+
+	kvtests.TestReadingAndWriting(t, provider)
+	kvtests.TestRange(t, provider)
+	kvtests.TestIterate(t, provider)
+	kvtests.TestEmpty(t, provider)
+	kvtests.TestDelete(t, provider)
+	kvtests.TestWriteTransactions(t, provider)
+	kvtests.TestShelfNames(t, provider)
+}
+
+func TestStore(t *testing.T) {
+	underlying := &faultyStore{KVStore: stores.BBolt(t)}
+	store := Wrap(underlying, WithFailureThreshold(2), WithOpenTimeout(time.Minute))
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	t.Run("failures below threshold are returned", func(t *testing.T) {
+		underlying.err = errUnavailable
+
+		assert.ErrorIs(t, read(store), errUnavailable)
+		assert.Equal(t, StateClosed, store.Stats().State)
+	})
+	t.Run("opens when threshold is reached", func(t *testing.T) {
+		assert.ErrorIs(t, read(store), errUnavailable)
+		assert.Equal(t, StateOpen, store.Stats().State)
+	})
+	t.Run("fails fast when open", func(t *testing.T) {
+		underlying.calls = 0
+
+		err := read(store)
+
+		assert.ErrorIs(t, err, ErrCircuitOpen)
+		assert.ErrorIs(t, err, stoabs.ErrDatabase{})
+		assert.Equal(t, 0, underlying.calls)
+	})
+	t.Run("failed probe keeps circuit open", func(t *testing.T) {
+		now = now.Add(time.Minute)
+
+		assert.ErrorIs(t, read(store), errUnavailable)
+		assert.Equal(t, StateOpen, store.Stats().State)
+		assert.ErrorIs(t, read(store), ErrCircuitOpen)
+	})
+	t.Run("successful probe closes circuit", func(t *testing.T) {
+		underlying.err = nil
+		now = now.Add(time.Minute)
+
+		assert.NoError(t, read(store))
+		assert.Equal(t, Stats{State: StateClosed, Failures: 3, Trips: 1, Rejected: 2}, store.Stats())
+	})
+}
+
+func TestStore_applicationErrors(t *testing.T) {
+	store := Wrap(stores.BBolt(t), WithFailureThreshold(1))
+
+	t.Run("transaction function error", func(t *testing.T) {
+		err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+			return errors.New("failed")
+		})
+
+		assert.EqualError(t, err, "failed")
+		assert.Equal(t, StateClosed, store.Stats().State)
+	})
+	t.Run("cancelled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+			return tx.GetShelfWriter("test").Put(stoabs.BytesKey("key"), []byte("value"))
+		})
+
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, StateClosed, store.Stats().State)
+	})
+}
+
+func TestStore_storeErrorsInTransaction(t *testing.T) {
+	store := Wrap(stores.BBolt(t), WithFailureThreshold(1))
+
+	// e.g. an I/O error of Redis, returned by a Get inside the transaction function
+	err := store.Read(ctx, func(tx stoabs.ReadTx) error {
+		return errUnavailable
+	})
+
+	assert.ErrorIs(t, err, errUnavailable)
+	assert.Equal(t, StateOpen, store.Stats().State)
+}
+
+// faultyStore fails all read transactions with err, if set.
+type faultyStore struct {
+	stoabs.KVStore
+	err   error
+	calls int
+}
+
+func (f *faultyStore) Read(ctx context.Context, fn func(stoabs.ReadTx) error) error {
+	f.calls++
+	if f.err != nil {
+		return f.err
+	}
+	return f.KVStore.Read(ctx, fn)
+}
+
+func read(store stoabs.KVStore) error {
+	return store.ReadShelf(ctx, "test", func(reader stoabs.Reader) error {
+		_, err := reader.Empty()
+		return err
+	})
+}
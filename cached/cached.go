@@ -0,0 +1,350 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package cached provides a KVStore that serves reads from a (faster) cache store, falling back to a backing store.
+package cached
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/sirupsen/logrus"
+)
+
+var _ stoabs.KVStore = (*Store)(nil)
+var _ stoabs.ShelfLister = (*Store)(nil)
+var _ stoabs.WriteTx = (*tx)(nil)
+var _ stoabs.Writer = (*shelf)(nil)
+
+const defaultTTL = 5 * time.Minute
+
+// Cache entries consist of the expiry time (Unix nanoseconds, 8 bytes, big endian) followed by the value.
+const expirySize = 8
+
+// Option configures the caching store.
+type Option func(s *Store)
+
+// WithTTL sets the time after which cached values expire. It defaults to 5 minutes.
+func WithTTL(ttl time.Duration) Option {
+	return func(s *Store) {
+		s.ttl = ttl
+	}
+}
+
+// WithWriteThrough makes writes update the cache with the new values, instead of invalidating the cached values.
+func WithWriteThrough() Option {
+	return func(s *Store) {
+		s.writeThrough = true
+	}
+}
+
+// WithClock overrides the clock that determines whether cached values expired, which defaults to stoabs.SystemClock.
+func WithClock(clock stoabs.Clock) Option {
+	return func(s *Store) {
+		s.now = clock.Now
+	}
+}
+
+// WithKeyType specifies the type of the keys of the given shelf, which is required for rehydrating evicted entries
+// (see RehydrateEvicted) of shelves of which the keys aren't stoabs.BytesKey, since evictions only report the key in its string form.
+func WithKeyType(shelf string, keyType stoabs.Key) Option {
+	return func(s *Store) {
+		s.keyTypes[shelf] = keyType
+	}
+}
+
+// WithLogger overrides the default logger.
+func WithLogger(log *logrus.Logger) Option {
+	return func(s *Store) {
+		s.log = log
+	}
+}
+
+// Stats contains the cache counters.
+type Stats struct {
+	Hits   uint64
+	Misses uint64
+	// Errors counts failed reads from and writes to the cache store.
+	Errors uint64
+	// Rehydrations counts evicted entries that were cached again, see RehydrateEvicted.
+	Rehydrations uint64
+}
+
+// Wrap creates a store that serves Get from the cache store, reading the value from the backing store
+// (and adding it to the cache) if it isn't cached or has expired. Iterate and Range are always served by the backing store.
+// Keys written through the store are invalidated (or updated, see WithWriteThrough) in the cache after the transaction
+// has been committed on the backing store. Since a concurrent reader might cache a value it read before the
+// commit, cached values can be stale for at most the TTL (see WithTTL).
+// Failures of the cache store are logged and counted, but never returned: the backing store is used instead.
+// The cache store must not be written to by other means.
+func Wrap(backing, cache stoabs.KVStore, opts ...Option) *Store {
+	result := &Store{
+		backing:  backing,
+		cache:    cache,
+		ttl:      defaultTTL,
+		now:      time.Now,
+		log:      logrus.StandardLogger(),
+		keyTypes: map[string]stoabs.Key{},
+	}
+	for _, opt := range opts {
+		opt(result)
+	}
+	return result
+}
+
+// Store is a KVStore that caches values. Use Wrap to create it.
+type Store struct {
+	backing      stoabs.KVStore
+	cache        stoabs.KVStore
+	ttl          time.Duration
+	writeThrough bool
+	now          func() time.Time
+	log          *logrus.Logger
+	keyTypes     map[string]stoabs.Key
+
+	hits         atomic.Uint64
+	misses       atomic.Uint64
+	errors       atomic.Uint64
+	rehydrations atomic.Uint64
+}
+
+// Stats returns the cache counters.
+func (s *Store) Stats() Stats {
+	return Stats{Hits: s.hits.Load(), Misses: s.misses.Load(), Errors: s.errors.Load(), Rehydrations: s.rehydrations.Load()}
+}
+
+// RehydrateEvicted watches the entries evicted from the cache store (e.g. by Redis when it reached its memory limit,
+// see stoabs.WatchEvictions), and caches them again with the value read from the backing store, until the given context is cancelled.
+// Evicted entries that no longer exist in the backing store aren't cached again. Since the cache store evicts entries
+// when it's out of memory, rehydrating evicted entries evicts others: only use it if the cache is expected to hold all
+// frequently read entries. It returns errors.ErrUnsupported if the cache store doesn't report evictions.
+func (s *Store) RehydrateEvicted(ctx context.Context) error {
+	return stoabs.WatchEvictions(ctx, s.cache, func(eviction stoabs.Eviction) {
+		s.rehydrate(ctx, eviction)
+	})
+}
+
+func (s *Store) rehydrate(ctx context.Context, eviction stoabs.Eviction) {
+	keyType, ok := s.keyTypes[eviction.Shelf]
+	if !ok {
+		keyType = stoabs.BytesKey{}
+	}
+	key, err := keyType.FromString(eviction.Key)
+	if err != nil {
+		s.log.WithError(err).Warnf("Unable to rehydrate evicted cache entry, unexpected key (shelf=%s, key=%s)", eviction.Shelf, eviction.Key)
+		return
+	}
+	var value []byte
+	err = s.backing.ReadShelf(ctx, eviction.Shelf, func(reader stoabs.Reader) error {
+		value, err = reader.Get(key)
+		return err
+	})
+	if errors.Is(err, stoabs.ErrKeyNotFound) {
+		return
+	}
+	if err != nil {
+		s.log.WithError(err).Warnf("Unable to rehydrate evicted cache entry (shelf=%s, key=%s)", eviction.Shelf, eviction.Key)
+		return
+	}
+	if s.putCached(ctx, eviction.Shelf, key, value) {
+		s.rehydrations.Add(1)
+	}
+}
+
+// Close closes both the backing and cache store.
+func (s *Store) Close(ctx context.Context) error {
+	return errors.Join(s.backing.Close(ctx), s.cache.Close(ctx))
+}
+
+func (s *Store) Write(ctx context.Context, fn func(stoabs.WriteTx) error, opts ...stoabs.TxOption) error {
+	var mutations []mutation
+	err := s.backing.Write(ctx, func(backingTx stoabs.WriteTx) error {
+		mutations = nil
+		return fn(&tx{ReadTx: backingTx, writeTx: backingTx, store: s, ctx: ctx, mutations: &mutations})
+	}, opts...)
+	if err != nil {
+		return err
+	}
+	s.updateCache(ctx, mutations)
+	return nil
+}
+
+func (s *Store) WriteShelf(ctx context.Context, shelfName string, fn func(stoabs.Writer) error) error {
+	return s.Write(ctx, func(tx stoabs.WriteTx) error {
+		return fn(tx.GetShelfWriter(shelfName))
+	})
+}
+
+func (s *Store) Read(ctx context.Context, fn func(stoabs.ReadTx) error) error {
+	return s.backing.Read(ctx, func(backingTx stoabs.ReadTx) error {
+		return fn(&tx{ReadTx: backingTx, store: s, ctx: ctx})
+	})
+}
+
+func (s *Store) ReadShelf(ctx context.Context, shelfName string, fn func(stoabs.Reader) error) error {
+	return s.Read(ctx, func(tx stoabs.ReadTx) error {
+		return fn(tx.GetShelfReader(shelfName))
+	})
+}
+
+// ShelfNames returns the shelves of the backing store.
+func (s *Store) ShelfNames(ctx context.Context) ([]string, error) {
+	return stoabs.ShelfNames(ctx, s.backing)
+}
+
+// getCached returns the cached value of the key, or false if it isn't cached.
+func (s *Store) getCached(ctx context.Context, shelfName string, key stoabs.Key) ([]byte, bool) {
+	var result []byte
+	err := s.cache.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+		var err error
+		result, err = reader.Get(key)
+		return err
+	})
+	if errors.Is(err, stoabs.ErrKeyNotFound) {
+		return nil, false
+	}
+	if err != nil {
+		s.cacheFailed(err)
+		return nil, false
+	}
+	if len(result) < expirySize || s.now().UnixNano() >= int64(binary.BigEndian.Uint64(result)) {
+		return nil, false
+	}
+	return result[expirySize:], true
+}
+
+// putCached caches the value of the key. It returns false if the cache store failed.
+func (s *Store) putCached(ctx context.Context, shelfName string, key stoabs.Key, value []byte) bool {
+	err := s.cache.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+		return writer.Put(key, s.cacheEntry(value))
+	})
+	if err != nil {
+		s.cacheFailed(err)
+		return false
+	}
+	return true
+}
+
+func (s *Store) cacheEntry(value []byte) []byte {
+	result := binary.BigEndian.AppendUint64(make([]byte, 0, expirySize+len(value)), uint64(s.now().Add(s.ttl).UnixNano()))
+	return append(result, value...)
+}
+
+// updateCache invalidates (or updates, when write-through is enabled) the cached values of the mutated keys.
+func (s *Store) updateCache(ctx context.Context, mutations []mutation) {
+	if len(mutations) == 0 {
+		return
+	}
+	err := s.cache.Write(ctx, func(tx stoabs.WriteTx) error {
+		for _, m := range mutations {
+			writer := tx.GetShelfWriter(m.shelf)
+			var err error
+			if s.writeThrough && !m.delete {
+				err = writer.Put(m.key, s.cacheEntry(m.value))
+			} else {
+				err = writer.Delete(m.key)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.cacheFailed(err)
+	}
+}
+
+func (s *Store) cacheFailed(err error) {
+	s.errors.Add(1)
+	s.log.WithError(err).Warn("Cache store failed, using backing store")
+}
+
+type mutation struct {
+	shelf  string
+	key    stoabs.Key
+	value  []byte
+	delete bool
+}
+
+type tx struct {
+	stoabs.ReadTx
+	writeTx   stoabs.WriteTx
+	store     *Store
+	ctx       context.Context
+	mutations *[]mutation
+}
+
+func (t *tx) GetShelfReader(shelfName string) stoabs.Reader {
+	return &shelf{Reader: t.ReadTx.GetShelfReader(shelfName), name: shelfName, tx: t}
+}
+
+func (t *tx) GetShelfWriter(shelfName string) stoabs.Writer {
+	writer := t.writeTx.GetShelfWriter(shelfName)
+	return &shelf{Reader: writer, writer: writer, name: shelfName, tx: t}
+}
+
+func (t *tx) Store() stoabs.KVStore {
+	return t.store
+}
+
+type shelf struct {
+	stoabs.Reader
+	writer stoabs.Writer
+	name   string
+	tx     *tx
+}
+
+func (s *shelf) Get(key stoabs.Key) ([]byte, error) {
+	store := s.tx.store
+	// write transactions read from the backing store, since the cache doesn't reflect uncommitted writes
+	if s.tx.writeTx != nil {
+		return s.Reader.Get(key)
+	}
+	if value, ok := store.getCached(s.tx.ctx, s.name, key); ok {
+		store.hits.Add(1)
+		return value, nil
+	}
+	store.misses.Add(1)
+	value, err := s.Reader.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	store.putCached(s.tx.ctx, s.name, key, value)
+	return value, nil
+}
+
+func (s *shelf) Put(key stoabs.Key, value []byte) error {
+	if err := s.writer.Put(key, value); err != nil {
+		return err
+	}
+	*s.tx.mutations = append(*s.tx.mutations, mutation{shelf: s.name, key: key, value: append(value[:0:0], value...)})
+	return nil
+}
+
+func (s *shelf) Delete(key stoabs.Key) error {
+	if err := s.writer.Delete(key); err != nil {
+		return err
+	}
+	*s.tx.mutations = append(*s.tx.mutations, mutation{shelf: s.name, key: key, delete: true})
+	return nil
+}
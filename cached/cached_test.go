@@ -0,0 +1,244 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package cached
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/nuts-foundation/go-stoabs/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+var key = stoabs.BytesKey("key")
+
+const shelfName = "test"
+
+func TestCached(t *testing.T) {
+	provider := func(t *testing.T) (stoabs.KVStore, error) {
+		return Wrap(stores.BBolt(t), stores.BBolt(t)), nil
+	}
+
+	kvtests.TestReadingAndWriting(t, provider)
+	kvtests.TestRange(t, provider)
+	kvtests.TestIterate(t, provider)
+	kvtests.TestEmpty(t, provider)
+	kvtests.TestDelete(t, provider)
+	kvtests.TestWriteTransactions(t, provider)
+	kvtests.TestShelfNames(t, provider)
+}
+
+func TestStore_Get(t *testing.T) {
+	t.Run("read-through", func(t *testing.T) {
+		backing, cache := stores.BBolt(t), stores.BBolt(t)
+		put(t, backing, "v1")
+		store := Wrap(backing, cache)
+
+		assert.Equal(t, []byte("v1"), get(t, store))
+		assert.Equal(t, []byte("v1"), get(t, store))
+
+		assert.Equal(t, Stats{Hits: 1, Misses: 1}, store.Stats())
+		assert.Equal(t, "v1", string(get(t, cache)[expirySize:]))
+	})
+	t.Run("expired", func(t *testing.T) {
+		backing, cache := stores.BBolt(t), stores.BBolt(t)
+		put(t, backing, "v1")
+		store := Wrap(backing, cache, WithTTL(time.Minute))
+		now := time.Now()
+		store.now = func() time.Time {
+			return now
+		}
+		_ = get(t, store)
+
+		now = now.Add(time.Minute)
+		_ = get(t, store)
+
+		assert.Equal(t, Stats{Misses: 2}, store.Stats())
+	})
+	t.Run("expired, with clock", func(t *testing.T) {
+		backing, cache := stores.BBolt(t), stores.BBolt(t)
+		put(t, backing, "v1")
+		clock := mocks.NewClock(time.Now())
+		store := Wrap(backing, cache, WithTTL(time.Minute), WithClock(clock))
+		_ = get(t, store)
+		clock.Advance(time.Minute - time.Second)
+		_ = get(t, store)
+
+		clock.Advance(time.Second)
+		_ = get(t, store)
+
+		assert.Equal(t, Stats{Hits: 1, Misses: 2}, store.Stats())
+	})
+	t.Run("not found isn't cached", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t), stores.BBolt(t))
+
+		assert.Nil(t, get(t, store))
+		assert.Nil(t, get(t, store))
+
+		assert.Equal(t, Stats{Misses: 2}, store.Stats())
+	})
+	t.Run("cache unavailable", func(t *testing.T) {
+		backing, cache := stores.BBolt(t), stores.BBolt(t)
+		put(t, backing, "v1")
+		_ = cache.Close(ctx)
+		store := Wrap(backing, cache)
+
+		assert.Equal(t, []byte("v1"), get(t, store))
+
+		assert.Equal(t, Stats{Misses: 1, Errors: 2}, store.Stats())
+	})
+}
+
+func TestStore_Write(t *testing.T) {
+	t.Run("invalidates cached value", func(t *testing.T) {
+		backing, cache := stores.BBolt(t), stores.BBolt(t)
+		store := Wrap(backing, cache)
+		put(t, store, "v1")
+		_ = get(t, store)
+
+		put(t, store, "v2")
+
+		assert.Nil(t, get(t, cache))
+		assert.Equal(t, []byte("v2"), get(t, store))
+	})
+	t.Run("delete invalidates cached value", func(t *testing.T) {
+		backing, cache := stores.BBolt(t), stores.BBolt(t)
+		store := Wrap(backing, cache, WithWriteThrough())
+		put(t, store, "v1")
+
+		require.NoError(t, store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			return writer.Delete(key)
+		}))
+
+		assert.Nil(t, get(t, cache))
+		assert.Nil(t, get(t, store))
+	})
+	t.Run("write-through", func(t *testing.T) {
+		backing, cache := stores.BBolt(t), stores.BBolt(t)
+		store := Wrap(backing, cache, WithWriteThrough())
+
+		put(t, store, "v1")
+
+		assert.Equal(t, []byte("v1"), get(t, store))
+		assert.Equal(t, Stats{Hits: 1}, store.Stats())
+	})
+	t.Run("rollback doesn't touch cache", func(t *testing.T) {
+		backing, cache := stores.BBolt(t), stores.BBolt(t)
+		store := Wrap(backing, cache)
+		put(t, store, "v1")
+		_ = get(t, store)
+
+		_ = store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			_ = writer.Put(key, []byte("v2"))
+			return errors.New("failed")
+		})
+
+		assert.Equal(t, []byte("v1"), get(t, store))
+		assert.Equal(t, Stats{Hits: 1, Misses: 1}, store.Stats())
+	})
+}
+
+func TestStore_RehydrateEvicted(t *testing.T) {
+	t.Run("caches evicted entries again", func(t *testing.T) {
+		backing := stores.BBolt(t)
+		put(t, backing, "v1")
+		cache := &evictingStore{KVStore: stores.BBolt(t), evictions: []stoabs.Eviction{
+			{Shelf: shelfName, Key: key.String()},
+			// not in the backing store
+			{Shelf: shelfName, Key: stoabs.BytesKey("other").String()},
+		}}
+		store := Wrap(backing, cache)
+
+		err := store.RehydrateEvicted(ctx)
+
+		require.NoError(t, err)
+		assert.Equal(t, "v1", string(get(t, cache)[expirySize:]))
+		assert.Equal(t, []byte("v1"), get(t, store))
+		assert.Equal(t, Stats{Hits: 1, Rehydrations: 1}, store.Stats())
+	})
+	t.Run("key type", func(t *testing.T) {
+		backing := stores.BBolt(t)
+		require.NoError(t, backing.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			return writer.Put(stoabs.Uint32Key(10), []byte("v1"))
+		}))
+		cache := &evictingStore{KVStore: stores.BBolt(t), evictions: []stoabs.Eviction{{Shelf: shelfName, Key: "10"}}}
+		store := Wrap(backing, cache, WithKeyType(shelfName, stoabs.Uint32Key(0)))
+
+		err := store.RehydrateEvicted(ctx)
+
+		require.NoError(t, err)
+		assert.Equal(t, Stats{Rehydrations: 1}, store.Stats())
+	})
+	t.Run("invalid key", func(t *testing.T) {
+		cache := &evictingStore{KVStore: stores.BBolt(t), evictions: []stoabs.Eviction{{Shelf: shelfName, Key: "not hex"}}}
+		store := Wrap(stores.BBolt(t), cache)
+
+		err := store.RehydrateEvicted(ctx)
+
+		require.NoError(t, err)
+		assert.Equal(t, Stats{}, store.Stats())
+	})
+	t.Run("cache doesn't report evictions", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t), stores.BBolt(t))
+
+		err := store.RehydrateEvicted(ctx)
+
+		assert.ErrorIs(t, err, errors.ErrUnsupported)
+	})
+}
+
+// evictingStore is a store that reports the given evictions when watched.
+type evictingStore struct {
+	stoabs.KVStore
+	evictions []stoabs.Eviction
+}
+
+func (e *evictingStore) WatchEvictions(_ context.Context, fn func(eviction stoabs.Eviction)) error {
+	for _, eviction := range e.evictions {
+		fn(eviction)
+	}
+	return nil
+}
+
+func put(t *testing.T, store stoabs.KVStore, value string) {
+	require.NoError(t, store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+		return writer.Put(key, []byte(value))
+	}))
+}
+
+func get(t *testing.T, store stoabs.KVStore) []byte {
+	var result []byte
+	err := store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+		var err error
+		result, err = reader.Get(key)
+		return err
+	})
+	if !errors.Is(err, stoabs.ErrKeyNotFound) {
+		require.NoError(t, err)
+	}
+	return result
+}
@@ -0,0 +1,137 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"math/bits"
+	"strings"
+)
+
+// Capabilities is a set of optional features a store supports, see StoreCapabilities.
+type Capabilities uint64
+
+const (
+	// CapabilityShelfLister means the store implements ShelfLister.
+	CapabilityShelfLister Capabilities = 1 << iota
+	// CapabilityLocker means the store implements Locker.
+	CapabilityLocker
+	// CapabilityDistributedLock means the key locks of the store (see Locker) also exclude callers in other processes.
+	CapabilityDistributedLock
+	// CapabilityLockInspector means the store implements LockInspector.
+	CapabilityLockInspector
+	// CapabilityLeaser means the store implements Leaser.
+	CapabilityLeaser
+	// CapabilityReadOptions means the store implements OptionReader.
+	CapabilityReadOptions
+	// CapabilityState means the store implements StateReporter.
+	CapabilityState
+	// CapabilityStats means the store implements StatsReader.
+	CapabilityStats
+	// CapabilityFreeze means the store implements Freezer.
+	CapabilityFreeze
+	// CapabilitySnapshot means the store implements Snapshotter.
+	CapabilitySnapshot
+	// CapabilityEvictionWatcher means the store implements EvictionWatcher.
+	CapabilityEvictionWatcher
+	// CapabilityOrderedIteration means Reader.Iterate visits keys in their byte order (see WithOrderedIteration).
+	CapabilityOrderedIteration
+	// CapabilityBulkDelete means the writers of the store implement BulkDeleter.
+	CapabilityBulkDelete
+	// CapabilityLazyRange means the readers and writers of the store implement LazyRanger.
+	CapabilityLazyRange
+	// CapabilityEntries means the readers and writers of the store implement EntryIterator.
+	CapabilityEntries
+	// CapabilityKeyHistogram means the readers and writers of the store implement KeyHistogrammer.
+	CapabilityKeyHistogram
+	// CapabilitySample means the readers and writers of the store implement Sampler.
+	CapabilitySample
+	// CapabilityKeyChecker means the readers and writers of the store implement KeyChecker.
+	CapabilityKeyChecker
+	// CapabilityRangeAggregator means the readers and writers of the store implement RangeAggregator.
+	CapabilityRangeAggregator
+)
+
+var capabilityNames = []string{
+	"ShelfLister", "Locker", "DistributedLock", "LockInspector", "Leaser", "ReadOptions", "State", "Stats", "Freeze",
+	"Snapshot", "EvictionWatcher", "OrderedIteration", "BulkDelete", "LazyRange", "Entries", "KeyHistogram", "Sample",
+	"KeyChecker", "RangeAggregator",
+}
+
+// Has returns whether all the given capabilities are in the set.
+func (c Capabilities) Has(capabilities Capabilities) bool {
+	return c&capabilities == capabilities
+}
+
+// String returns the names of the capabilities in the set, separated by a pipe (|).
+func (c Capabilities) String() string {
+	var names []string
+	for remaining := c; remaining != 0; remaining &= remaining - 1 {
+		index := bits.TrailingZeros64(uint64(remaining))
+		if index < len(capabilityNames) {
+			names = append(names, capabilityNames[index])
+		} else {
+			names = append(names, "Unknown")
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// CapabilityReporter is implemented by stores that report their capabilities, see StoreCapabilities.
+type CapabilityReporter interface {
+	// Capabilities returns the optional features the store supports.
+	Capabilities() Capabilities
+}
+
+// StoreCapabilities returns the optional features the given store supports, so consumers (e.g. generic middleware) can adapt
+// to the store instead of type-asserting the optional interfaces. If the store doesn't implement CapabilityReporter,
+// they're detected from the optional interfaces the store implements. The capabilities of its readers and writers
+// (e.g. CapabilityBulkDelete) and behavior (e.g. CapabilityOrderedIteration) can't be detected, so they're only reported
+// by stores implementing CapabilityReporter.
+func StoreCapabilities(store KVStore) Capabilities {
+	if reporter, ok := store.(CapabilityReporter); ok {
+		return reporter.Capabilities()
+	}
+	var result Capabilities
+	detect := func(capability Capabilities, implemented bool) {
+		if implemented {
+			result |= capability
+		}
+	}
+	_, ok := store.(ShelfLister)
+	detect(CapabilityShelfLister, ok)
+	_, ok = store.(Locker)
+	detect(CapabilityLocker, ok)
+	_, ok = store.(LockInspector)
+	detect(CapabilityLockInspector, ok)
+	_, ok = store.(Leaser)
+	detect(CapabilityLeaser, ok)
+	_, ok = store.(OptionReader)
+	detect(CapabilityReadOptions, ok)
+	_, ok = store.(StateReporter)
+	detect(CapabilityState, ok)
+	_, ok = store.(StatsReader)
+	detect(CapabilityStats, ok)
+	_, ok = store.(Freezer)
+	detect(CapabilityFreeze, ok)
+	_, ok = store.(Snapshotter)
+	detect(CapabilitySnapshot, ok)
+	_, ok = store.(EvictionWatcher)
+	detect(CapabilityEvictionWatcher, ok)
+	return result
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+	"testing"
+)
+
+type capabilityReporter struct {
+	*MockKVStore
+}
+
+func (capabilityReporter) Capabilities() Capabilities {
+	return CapabilityLocker | CapabilityDistributedLock
+}
+
+func TestStoreCapabilities(t *testing.T) {
+	t.Run("reported by the store", func(t *testing.T) {
+		store := capabilityReporter{MockKVStore: NewMockKVStore(gomock.NewController(t))}
+
+		assert.Equal(t, CapabilityLocker|CapabilityDistributedLock, StoreCapabilities(store))
+	})
+	t.Run("detected", func(t *testing.T) {
+		store := struct {
+			KVStore
+			StatsReader
+			Freezer
+		}{KVStore: NewMockKVStore(gomock.NewController(t)), StatsReader: statsReader{}, Freezer: &freezer{}}
+
+		assert.Equal(t, CapabilityStats|CapabilityFreeze, StoreCapabilities(store))
+	})
+	t.Run("none", func(t *testing.T) {
+		assert.Equal(t, Capabilities(0), StoreCapabilities(NewMockKVStore(gomock.NewController(t))))
+	})
+}
+
+func TestCapabilities(t *testing.T) {
+	capabilities := CapabilityLocker | CapabilityDistributedLock | CapabilitySample
+
+	t.Run("Has", func(t *testing.T) {
+		assert.True(t, capabilities.Has(CapabilityLocker))
+		assert.True(t, capabilities.Has(CapabilityLocker|CapabilitySample))
+		assert.False(t, capabilities.Has(CapabilityLocker|CapabilityLeaser))
+		assert.True(t, capabilities.Has(0))
+	})
+	t.Run("String", func(t *testing.T) {
+		assert.Equal(t, "Locker|DistributedLock|Sample", capabilities.String())
+		assert.Equal(t, "", Capabilities(0).String())
+		assert.Equal(t, "Unknown", Capabilities(1<<63).String())
+	})
+	t.Run("all capabilities are named", func(t *testing.T) {
+		assert.Equal(t, "RangeAggregator", CapabilityRangeAggregator.String())
+	})
+}
@@ -0,0 +1,373 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package cdc provides a KVStore that records all committed mutations in a journal (change-data-capture),
+// which can be tailed by downstream consumers.
+package cdc
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+)
+
+var _ stoabs.KVStore = (*Store)(nil)
+var _ stoabs.ShelfLister = (*Store)(nil)
+var _ stoabs.WriteTx = (*tx)(nil)
+var _ stoabs.Writer = (*shelf)(nil)
+
+// journalShelf is the shelf holding the journal entries, keyed by offset (stoabs.Uint64Key).
+// Key 0 holds the journal state.
+const journalShelf = stoabs.ReservedShelfPrefix + "cdc"
+
+const stateKey = stoabs.Uint64Key(0)
+
+// ErrOffsetExpired is returned by Tail when the requested offset has been removed from the journal by the retention policy.
+var ErrOffsetExpired = errors.New("journal offset no longer available")
+
+// Entry is a mutation recorded in the journal.
+type Entry struct {
+	// Offset is the position of the entry in the journal, starting at 1.
+	Offset uint64 `json:"offset"`
+	// TxID identifies the transaction the mutation was part of. It's the offset of the first entry of that transaction.
+	TxID uint64 `json:"txId"`
+	// Timestamp is the time the transaction was committed.
+	Timestamp time.Time `json:"timestamp"`
+	// Shelf is the shelf the mutation applies to.
+	Shelf string `json:"shelf"`
+	// Key is the byte representation of the mutated key.
+	Key []byte `json:"key"`
+	// KeyType is the type of the mutated key (see the KeyType constants), or empty for other key types.
+	KeyType string `json:"keyType,omitempty"`
+	// OldValueHash is the SHA-256 hash of the value before the mutation, or nil if the key did not exist.
+	OldValueHash []byte `json:"oldValueHash,omitempty"`
+	// NewValueHash is the SHA-256 hash of the value after the mutation, or nil if the key was deleted.
+	NewValueHash []byte `json:"newValueHash,omitempty"`
+}
+
+// Key types recorded in Entry.KeyType.
+const (
+	KeyTypeBytes  = "bytes"
+	KeyTypeUint32 = "uint32"
+	KeyTypeUint64 = "uint64"
+	KeyTypeHash   = "hash"
+)
+
+// ParsedKey returns the mutated key as the type recorded in KeyType, since some backends (e.g. Redis) store keys in a
+// type-specific form, so the key can't be looked up by its bytes alone. Keys of other types are returned as stoabs.BytesKey.
+func (e Entry) ParsedKey() (stoabs.Key, error) {
+	return ParseKey(e.KeyType, e.Key)
+}
+
+// ParseKey parses the byte representation of a key as the given key type (see the KeyType constants).
+// Keys of other types are returned as stoabs.BytesKey.
+func ParseKey(keyType string, key []byte) (stoabs.Key, error) {
+	switch keyType {
+	case KeyTypeUint32:
+		return stoabs.Uint32Key(0).FromBytes(key)
+	case KeyTypeUint64:
+		return stoabs.Uint64Key(0).FromBytes(key)
+	case KeyTypeHash:
+		return stoabs.HashKey{}.FromBytes(key)
+	default:
+		return stoabs.BytesKey(key), nil
+	}
+}
+
+// KeyTypeOf returns the key type (see the KeyType constants) of the given key, or an empty string for other key types.
+func KeyTypeOf(key stoabs.Key) string {
+	switch key.(type) {
+	case stoabs.BytesKey:
+		return KeyTypeBytes
+	case stoabs.Uint32Key:
+		return KeyTypeUint32
+	case stoabs.Uint64Key:
+		return KeyTypeUint64
+	case stoabs.HashKey:
+		return KeyTypeHash
+	default:
+		return ""
+	}
+}
+
+// state is stored at stateKey in the journal shelf.
+type state struct {
+	// First is the offset of the oldest retained entry.
+	First uint64 `json:"first"`
+	// Next is the offset that will be assigned to the next entry.
+	Next uint64 `json:"next"`
+}
+
+// Option configures the CDC store.
+type Option func(s *Store)
+
+// WithRetention limits the number of entries kept in the journal. When entries are appended,
+// the oldest exceeding entries are removed. By default, all entries are kept.
+func WithRetention(entries uint64) Option {
+	return func(s *Store) {
+		s.retention = entries
+	}
+}
+
+// Wrap creates a store that records every Put and Delete in a journal, which is written in the same transaction as the
+// mutations themselves. Since entries are assigned consecutive offsets, all write transactions acquire the write lock
+// (see stoabs.WithWriteLock).
+func Wrap(store stoabs.KVStore, opts ...Option) *Store {
+	result := &Store{
+		underlying: store,
+		now:        time.Now,
+	}
+	for _, opt := range opts {
+		opt(result)
+	}
+	return result
+}
+
+// Store is a KVStore that journals mutations. Use Wrap to create it.
+type Store struct {
+	underlying stoabs.KVStore
+	retention  uint64
+	now        func() time.Time
+}
+
+// Tail calls fn for every journal entry, starting at the given offset (inclusive), in a single read transaction.
+// Offset 0 starts at the oldest retained entry. It returns the offset to pass to the next invocation to continue
+// where this one stopped, which is also the case when fn returns an error.
+// If the given offset has been removed by the retention policy, ErrOffsetExpired is returned.
+func (s *Store) Tail(ctx context.Context, offset uint64, fn func(Entry) error) (uint64, error) {
+	next := offset
+	err := s.underlying.ReadShelf(ctx, journalShelf, func(reader stoabs.Reader) error {
+		journal := stoabs.JSONShelf[Entry](reader)
+		current, err := readState(reader)
+		if err != nil {
+			return err
+		}
+		if offset == 0 {
+			next = current.First
+		} else if offset < current.First {
+			return fmt.Errorf("%w (offset=%d, oldest=%d)", ErrOffsetExpired, offset, current.First)
+		}
+		for ; next < current.Next; next++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			entry, err := journal.Get(stoabs.Uint64Key(next))
+			if err != nil {
+				return fmt.Errorf("unable to read journal entry (offset=%d): %w", next, err)
+			}
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return next, err
+}
+
+// NextOffset returns the offset that will be assigned to the next journal entry.
+func (s *Store) NextOffset(ctx context.Context) (uint64, error) {
+	var result uint64
+	err := s.underlying.ReadShelf(ctx, journalShelf, func(reader stoabs.Reader) error {
+		current, err := readState(reader)
+		result = current.Next
+		return err
+	})
+	return result, err
+}
+
+func (s *Store) Close(ctx context.Context) error {
+	return s.underlying.Close(ctx)
+}
+
+func (s *Store) Write(ctx context.Context, fn func(stoabs.WriteTx) error, opts ...stoabs.TxOption) error {
+	if !(stoabs.WriteLockOption{}).Enabled(opts) {
+		opts = append(opts, stoabs.WithWriteLock())
+	}
+	return s.underlying.Write(ctx, func(underlyingTx stoabs.WriteTx) error {
+		t := &tx{ReadTx: underlyingTx, writeTx: underlyingTx, store: s, values: map[valueCacheKey][]byte{}}
+		if err := fn(t); err != nil {
+			return err
+		}
+		return t.appendEntries()
+	}, opts...)
+}
+
+func (s *Store) WriteShelf(ctx context.Context, shelfName string, fn func(stoabs.Writer) error) error {
+	return s.Write(ctx, func(tx stoabs.WriteTx) error {
+		return fn(tx.GetShelfWriter(shelfName))
+	})
+}
+
+func (s *Store) Read(ctx context.Context, fn func(stoabs.ReadTx) error) error {
+	return s.underlying.Read(ctx, func(underlyingTx stoabs.ReadTx) error {
+		return fn(&tx{ReadTx: underlyingTx, store: s})
+	})
+}
+
+func (s *Store) ReadShelf(ctx context.Context, shelfName string, fn func(stoabs.Reader) error) error {
+	return s.Read(ctx, func(tx stoabs.ReadTx) error {
+		return fn(tx.GetShelfReader(shelfName))
+	})
+}
+
+// ShelfNames returns the shelves of the underlying store. The journal is a reserved shelf, so it's only listed when
+// requested using stoabs.ContextWithReservedShelves.
+func (s *Store) ShelfNames(ctx context.Context) ([]string, error) {
+	return stoabs.ShelfNames(ctx, s.underlying)
+}
+
+func readState(reader stoabs.Reader) (state, error) {
+	result, err := stoabs.JSONShelf[state](reader).Get(stateKey)
+	if errors.Is(err, stoabs.ErrKeyNotFound) {
+		return state{First: 1, Next: 1}, nil
+	}
+	if err != nil {
+		return state{}, fmt.Errorf("unable to read journal state: %w", err)
+	}
+	return result, nil
+}
+
+type tx struct {
+	stoabs.ReadTx
+	writeTx stoabs.WriteTx
+	store   *Store
+	entries []Entry
+	// values holds the hashes of values written in this transaction (nil if deleted), to determine the old value hash
+	// of subsequent writes to the same key, since not all backends can read values written in the same transaction.
+	values map[valueCacheKey][]byte
+}
+
+func (t *tx) GetShelfReader(shelfName string) stoabs.Reader {
+	return t.ReadTx.GetShelfReader(shelfName)
+}
+
+func (t *tx) GetShelfWriter(shelfName string) stoabs.Writer {
+	writer := t.writeTx.GetShelfWriter(shelfName)
+	return &shelf{Writer: writer, name: shelfName, tx: t}
+}
+
+func (t *tx) Store() stoabs.KVStore {
+	return t.store
+}
+
+func (t *tx) record(shelfName string, key stoabs.Key, oldValueHash, newValueHash []byte) {
+	t.entries = append(t.entries, Entry{
+		Shelf:        shelfName,
+		Key:          key.Bytes(),
+		KeyType:      KeyTypeOf(key),
+		OldValueHash: oldValueHash,
+		NewValueHash: newValueHash,
+	})
+	t.values[cacheKey(shelfName, key)] = newValueHash
+}
+
+// appendEntries writes the entries recorded in this transaction to the journal, and applies the retention policy.
+func (t *tx) appendEntries() error {
+	if len(t.entries) == 0 {
+		return nil
+	}
+	writer := t.writeTx.GetShelfWriter(journalShelf)
+	journal := stoabs.JSONShelf[Entry](writer)
+	current, err := readState(writer)
+	if err != nil {
+		return err
+	}
+	txID := current.Next
+	timestamp := t.store.now()
+	for _, entry := range t.entries {
+		entry.Offset = current.Next
+		entry.TxID = txID
+		entry.Timestamp = timestamp
+		if err := journal.Put(stoabs.Uint64Key(entry.Offset), entry); err != nil {
+			return err
+		}
+		current.Next++
+	}
+	retention := t.store.retention
+	for ; retention > 0 && current.Next-current.First > retention; current.First++ {
+		if err := writer.Delete(stoabs.Uint64Key(current.First)); err != nil {
+			return err
+		}
+	}
+	return stoabs.JSONShelf[state](writer).Put(stateKey, current)
+}
+
+type shelf struct {
+	stoabs.Writer
+	name string
+	tx   *tx
+}
+
+func (s *shelf) Put(key stoabs.Key, value []byte) error {
+	oldValueHash, err := s.currentHash(key)
+	if err != nil {
+		return err
+	}
+	if err := s.Writer.Put(key, value); err != nil {
+		return err
+	}
+	newValueHash := sha256.Sum256(value)
+	s.tx.record(s.name, key, oldValueHash, newValueHash[:])
+	return nil
+}
+
+func (s *shelf) Delete(key stoabs.Key) error {
+	oldValueHash, err := s.currentHash(key)
+	if err != nil {
+		return err
+	}
+	if err := s.Writer.Delete(key); err != nil {
+		return err
+	}
+	if oldValueHash == nil {
+		// key did not exist, nothing changed
+		return nil
+	}
+	s.tx.record(s.name, key, oldValueHash, nil)
+	return nil
+}
+
+// currentHash returns the hash of the current value of the given key, or nil if it does not exist.
+func (s *shelf) currentHash(key stoabs.Key) ([]byte, error) {
+	if hash, ok := s.tx.values[cacheKey(s.name, key)]; ok {
+		return hash, nil
+	}
+	value, err := s.Writer.Get(key)
+	if errors.Is(err, stoabs.ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(value)
+	return hash[:], nil
+}
+
+// valueCacheKey identifies a key in the value hash cache of a transaction.
+type valueCacheKey struct {
+	shelf string
+	key   string
+}
+
+func cacheKey(shelfName string, key stoabs.Key) valueCacheKey {
+	return valueCacheKey{shelf: shelfName, key: string(key.Bytes())}
+}
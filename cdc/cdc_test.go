@@ -0,0 +1,233 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package cdc
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/nuts-foundation/go-stoabs/redis7"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+var key = stoabs.BytesKey("key")
+
+const shelfName = "test"
+
+func TestCDC(t *testing.T) {
+	provider := func(t *testing.T) (stoabs.KVStore, error) {
+		return Wrap(stores.BBolt(t)), nil
+	}
+
+	kvtests.TestReadingAndWriting(t, provider)
+	kvtests.TestRange(t, provider)
+	kvtests.TestIterate(t, provider)
+	kvtests.TestEmpty(t, provider)
+	kvtests.TestDelete(t, provider)
+	kvtests.TestWriteTransactions(t, provider)
+	kvtests.TestShelfNames(t, provider)
+}
+
+func TestStore_Tail(t *testing.T) {
+	v1, v2 := sha256.Sum256([]byte("v1")), sha256.Sum256([]byte("v2"))
+
+	t.Run("records mutations", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t))
+		store.now = func() time.Time {
+			return time.Unix(1000, 0).UTC()
+		}
+		write(t, store, func(writer stoabs.Writer) error {
+			_ = writer.Put(key, []byte("v1"))
+			return writer.Put(key, []byte("v2"))
+		})
+		write(t, store, func(writer stoabs.Writer) error {
+			// deleting a non-existing key isn't recorded
+			_ = writer.Delete(stoabs.BytesKey("other"))
+			return writer.Delete(key)
+		})
+
+		entries, next := tail(t, store, 0)
+
+		assert.Equal(t, uint64(4), next)
+		assert.Equal(t, []Entry{
+			{Offset: 1, TxID: 1, Timestamp: time.Unix(1000, 0).UTC(), Shelf: shelfName, Key: key, KeyType: KeyTypeBytes, NewValueHash: v1[:]},
+			{Offset: 2, TxID: 1, Timestamp: time.Unix(1000, 0).UTC(), Shelf: shelfName, Key: key, KeyType: KeyTypeBytes, OldValueHash: v1[:], NewValueHash: v2[:]},
+			{Offset: 3, TxID: 3, Timestamp: time.Unix(1000, 0).UTC(), Shelf: shelfName, Key: key, KeyType: KeyTypeBytes, OldValueHash: v2[:]},
+		}, entries)
+	})
+	t.Run("continue from offset", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t))
+		write(t, store, func(writer stoabs.Writer) error {
+			return writer.Put(key, []byte("v1"))
+		})
+		_, next := tail(t, store, 0)
+		write(t, store, func(writer stoabs.Writer) error {
+			return writer.Put(key, []byte("v2"))
+		})
+
+		entries, next := tail(t, store, next)
+
+		require.Len(t, entries, 1)
+		assert.Equal(t, uint64(2), entries[0].Offset)
+		assert.Equal(t, uint64(3), next)
+		entries, _ = tail(t, store, next)
+		assert.Empty(t, entries)
+	})
+	t.Run("keys of different shelves don't collide", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t))
+		require.NoError(t, store.Write(ctx, func(tx stoabs.WriteTx) error {
+			_ = tx.GetShelfWriter("a/b").Put(stoabs.BytesKey("c"), []byte("v1"))
+			return tx.GetShelfWriter("a").Put(stoabs.BytesKey("b/c"), []byte("v2"))
+		}))
+
+		entries, _ := tail(t, store, 0)
+
+		require.Len(t, entries, 2)
+		assert.Nil(t, entries[1].OldValueHash)
+	})
+	t.Run("rollback isn't recorded", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t))
+		_ = store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			_ = writer.Put(key, []byte("v1"))
+			return errors.New("failed")
+		})
+
+		entries, next := tail(t, store, 0)
+
+		assert.Empty(t, entries)
+		assert.Equal(t, uint64(1), next)
+	})
+	t.Run("retention", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t), WithRetention(2))
+		for i := 0; i < 3; i++ {
+			write(t, store, func(writer stoabs.Writer) error {
+				return writer.Put(stoabs.Uint32Key(i), []byte("v1"))
+			})
+		}
+
+		entries, _ := tail(t, store, 0)
+		_, err := store.Tail(ctx, 1, func(_ Entry) error {
+			return nil
+		})
+
+		require.Len(t, entries, 2)
+		assert.Equal(t, uint64(2), entries[0].Offset)
+		assert.ErrorIs(t, err, ErrOffsetExpired)
+	})
+	t.Run("callback error", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t))
+		write(t, store, func(writer stoabs.Writer) error {
+			_ = writer.Put(stoabs.Uint32Key(1), []byte("v1"))
+			return writer.Put(stoabs.Uint32Key(2), []byte("v1"))
+		})
+
+		next, err := store.Tail(ctx, 0, func(entry Entry) error {
+			if entry.Offset == 2 {
+				return errors.New("failed")
+			}
+			return nil
+		})
+
+		assert.EqualError(t, err, "failed")
+		assert.Equal(t, uint64(2), next)
+	})
+	t.Run("Redis", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		underlying, err := redis7.CreateRedisStore("db", &redis.Options{Addr: mr.Addr()})
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = underlying.Close(ctx)
+		})
+		store := Wrap(underlying)
+		write(t, store, func(writer stoabs.Writer) error {
+			_ = writer.Put(key, []byte("v1"))
+			return writer.Put(key, []byte("v2"))
+		})
+
+		entries, _ := tail(t, store, 0)
+
+		require.Len(t, entries, 2)
+		assert.Equal(t, v1[:], entries[1].OldValueHash)
+	})
+}
+
+func TestEntry_ParsedKey(t *testing.T) {
+	for _, key := range []stoabs.Key{stoabs.BytesKey("key"), stoabs.Uint32Key(10), stoabs.Uint64Key(10), stoabs.HashKey{1}} {
+		t.Run(fmt.Sprintf("%T", key), func(t *testing.T) {
+			store := Wrap(stores.BBolt(t))
+			write(t, store, func(writer stoabs.Writer) error {
+				return writer.Put(key, []byte("v1"))
+			})
+			entries, _ := tail(t, store, 0)
+			require.Len(t, entries, 1)
+
+			actual, err := entries[0].ParsedKey()
+
+			require.NoError(t, err)
+			assert.Equal(t, key, actual)
+		})
+	}
+	t.Run("unknown key type", func(t *testing.T) {
+		actual, err := Entry{Key: []byte{1}}.ParsedKey()
+
+		require.NoError(t, err)
+		assert.Equal(t, stoabs.BytesKey{1}, actual)
+	})
+}
+
+func TestStore_NextOffset(t *testing.T) {
+	store := Wrap(stores.BBolt(t))
+	next, err := store.NextOffset(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), next)
+
+	write(t, store, func(writer stoabs.Writer) error {
+		return writer.Put(key, []byte("v1"))
+	})
+
+	next, err = store.NextOffset(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), next)
+}
+
+func write(t *testing.T, store stoabs.KVStore, fn func(writer stoabs.Writer) error) {
+	require.NoError(t, store.WriteShelf(ctx, shelfName, fn))
+}
+
+func tail(t *testing.T, store *Store, offset uint64) ([]Entry, uint64) {
+	var result []Entry
+	next, err := store.Tail(ctx, offset, func(entry Entry) error {
+		result = append(result, entry)
+		return nil
+	})
+	require.NoError(t, err)
+	return result, next
+}
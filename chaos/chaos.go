@@ -0,0 +1,334 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package chaos provides a KVStore that injects faults (latency, transient errors, failed commits and torn AfterCommit hooks),
+// to test the resilience of applications to storage failures.
+package chaos
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/util"
+)
+
+var _ stoabs.KVStore = (*Store)(nil)
+var _ stoabs.ShelfLister = (*Store)(nil)
+var _ stoabs.WriteTx = (*writeTx)(nil)
+
+// ErrInjected is the error of injected faults. Is also a ErrDatabase, so it's treated as a failure of the store.
+var ErrInjected = stoabs.DatabaseError(errors.New("injected fault"))
+
+// Stats contains the number of injected faults.
+type Stats struct {
+	// Delays counts the transactions that were delayed.
+	Delays uint64
+	// Errors counts the transient errors, see WithTransientErrors.
+	Errors uint64
+	// CommitFailures counts the write transactions of which the commit failed, see WithCommitFailures.
+	CommitFailures uint64
+	// TornHooks counts the committed write transactions of which not all AfterCommit hooks were invoked, see WithTornHooks.
+	TornHooks uint64
+}
+
+// Option configures the faults injected by the store.
+type Option func(s *Store)
+
+// WithSeed sets the seed of the random number generator that decides which faults are injected.
+// Given the same seed and the same sequence of operations, the same faults are injected. It defaults to 0.
+func WithSeed(seed int64) Option {
+	return func(s *Store) {
+		s.random = rand.New(rand.NewSource(seed))
+	}
+}
+
+// WithLatency delays every transaction and ShelfNames call by a random duration between min and max.
+func WithLatency(min, max time.Duration) Option {
+	return func(s *Store) {
+		s.minLatency = min
+		s.maxLatency = max
+	}
+}
+
+// WithTransientErrors makes transactions, ShelfNames calls and operations on readers and writers fail with ErrInjected
+// with the given probability (between 0 and 1).
+func WithTransientErrors(probability float64) Option {
+	return func(s *Store) {
+		s.errorProbability = probability
+	}
+}
+
+// WithCommitFailures makes the commit of write transactions fail with the given probability (between 0 and 1),
+// after the transaction function succeeded. The transaction is rolled back, and the error is a stoabs.ErrCommitFailed
+// which wraps ErrInjected.
+func WithCommitFailures(probability float64) Option {
+	return func(s *Store) {
+		s.commitFailureProbability = probability
+	}
+}
+
+// WithTornHooks makes committed write transactions invoke only part (possibly none) of their AfterCommit hooks
+// with the given probability (between 0 and 1), as if the application crashed right after the commit.
+func WithTornHooks(probability float64) Option {
+	return func(s *Store) {
+		s.tornHookProbability = probability
+	}
+}
+
+// Wrap creates a store that injects faults into the operations on the given store, as configured by the options.
+// Without options, operations are passed to the underlying store unaltered.
+func Wrap(store stoabs.KVStore, opts ...Option) *Store {
+	result := &Store{
+		underlying: store,
+		random:     rand.New(rand.NewSource(0)),
+		enabled:    true,
+	}
+	for _, opt := range opts {
+		opt(result)
+	}
+	return result
+}
+
+// Store is a KVStore that injects faults. Use Wrap to create it.
+type Store struct {
+	underlying               stoabs.KVStore
+	minLatency               time.Duration
+	maxLatency               time.Duration
+	errorProbability         float64
+	commitFailureProbability float64
+	tornHookProbability      float64
+
+	mux     sync.Mutex
+	random  *rand.Rand
+	enabled bool
+	stats   Stats
+}
+
+// SetEnabled enables or disables fault injection, e.g. to verify the state of the store after a test.
+// Fault injection is enabled when the store is created.
+func (s *Store) SetEnabled(enabled bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.enabled = enabled
+}
+
+// Stats returns the number of injected faults.
+func (s *Store) Stats() Stats {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.stats
+}
+
+// Close closes the underlying store, without injecting faults.
+func (s *Store) Close(ctx context.Context) error {
+	return s.underlying.Close(ctx)
+}
+
+func (s *Store) Write(ctx context.Context, fn func(stoabs.WriteTx) error, opts ...stoabs.TxOption) error {
+	if err := s.before(ctx); err != nil {
+		stoabs.OnRollbackOption{}.Invoke(opts)
+		return err
+	}
+	var hooks []stoabs.TxOption
+	var underlyingOpts []stoabs.TxOption
+	for _, opt := range opts {
+		if _, ok := opt.(*stoabs.AfterCommitOption); ok {
+			hooks = append(hooks, opt)
+		} else {
+			underlyingOpts = append(underlyingOpts, opt)
+		}
+	}
+	underlyingOpts = append(underlyingOpts, stoabs.AfterCommit(func() {
+		stoabs.AfterCommitOption{}.Invoke(hooks[:s.hooksToInvoke(len(hooks))])
+	}))
+	return s.underlying.Write(ctx, func(underlyingTx stoabs.WriteTx) error {
+		if err := fn(&writeTx{readTx: readTx{ReadTx: underlyingTx, store: s}, writeTx: underlyingTx}); err != nil {
+			return err
+		}
+		if s.inject(s.commitFailureProbability, func(stats *Stats) { stats.CommitFailures++ }) {
+			// rolls back the underlying transaction
+			return util.WrapError(stoabs.ErrCommitFailed, ErrInjected)
+		}
+		return nil
+	}, underlyingOpts...)
+}
+
+func (s *Store) WriteShelf(ctx context.Context, shelfName string, fn func(stoabs.Writer) error) error {
+	return s.Write(ctx, func(tx stoabs.WriteTx) error {
+		return fn(tx.GetShelfWriter(shelfName))
+	})
+}
+
+func (s *Store) Read(ctx context.Context, fn func(stoabs.ReadTx) error) error {
+	if err := s.before(ctx); err != nil {
+		return err
+	}
+	return s.underlying.Read(ctx, func(underlyingTx stoabs.ReadTx) error {
+		return fn(&readTx{ReadTx: underlyingTx, store: s})
+	})
+}
+
+func (s *Store) ReadShelf(ctx context.Context, shelfName string, fn func(stoabs.Reader) error) error {
+	return s.Read(ctx, func(tx stoabs.ReadTx) error {
+		return fn(tx.GetShelfReader(shelfName))
+	})
+}
+
+// ShelfNames returns the shelves of the underlying store.
+func (s *Store) ShelfNames(ctx context.Context) ([]string, error) {
+	if err := s.before(ctx); err != nil {
+		return nil, err
+	}
+	return stoabs.ShelfNames(ctx, s.underlying)
+}
+
+// before is called before each operation on the underlying store, it delays the operation and/or returns a transient error.
+func (s *Store) before(ctx context.Context) error {
+	if delay := s.latency(); delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return stoabs.DatabaseError(ctx.Err())
+		}
+	}
+	return s.transientError()
+}
+
+func (s *Store) latency() time.Duration {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if !s.enabled || s.maxLatency <= 0 {
+		return 0
+	}
+	s.stats.Delays++
+	delay := s.minLatency
+	if s.maxLatency > s.minLatency {
+		delay += time.Duration(s.random.Int63n(int64(s.maxLatency - s.minLatency)))
+	}
+	return delay
+}
+
+func (s *Store) transientError() error {
+	if s.inject(s.errorProbability, func(stats *Stats) { stats.Errors++ }) {
+		return ErrInjected
+	}
+	return nil
+}
+
+// hooksToInvoke returns the number of AfterCommit hooks (out of the given number) to invoke.
+func (s *Store) hooksToInvoke(hooks int) int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if !s.enabled || hooks == 0 || s.random.Float64() >= s.tornHookProbability {
+		return hooks
+	}
+	s.stats.TornHooks++
+	return s.random.Intn(hooks)
+}
+
+// inject returns whether a fault with the given probability must be injected, and if so counts it.
+func (s *Store) inject(probability float64, count func(stats *Stats)) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if !s.enabled || probability <= 0 || s.random.Float64() >= probability {
+		return false
+	}
+	count(&s.stats)
+	return true
+}
+
+type readTx struct {
+	stoabs.ReadTx
+	store *Store
+}
+
+func (t *readTx) GetShelfReader(shelfName string) stoabs.Reader {
+	return &reader{Reader: t.ReadTx.GetShelfReader(shelfName), store: t.store}
+}
+
+func (t *readTx) Store() stoabs.KVStore {
+	return t.store
+}
+
+type writeTx struct {
+	readTx
+	writeTx stoabs.WriteTx
+}
+
+func (t *writeTx) GetShelfWriter(shelfName string) stoabs.Writer {
+	underlying := t.writeTx.GetShelfWriter(shelfName)
+	return &writer{reader: reader{Reader: underlying, store: t.store}, writer: underlying}
+}
+
+// reader injects transient errors into the operations of a reader.
+type reader struct {
+	stoabs.Reader
+	store *Store
+}
+
+func (s *reader) Empty() (bool, error) {
+	if err := s.store.transientError(); err != nil {
+		return false, err
+	}
+	return s.Reader.Empty()
+}
+
+func (s *reader) Get(key stoabs.Key) ([]byte, error) {
+	if err := s.store.transientError(); err != nil {
+		return nil, err
+	}
+	return s.Reader.Get(key)
+}
+
+func (s *reader) Iterate(callback stoabs.CallerFn, keyType stoabs.Key) error {
+	if err := s.store.transientError(); err != nil {
+		return err
+	}
+	return s.Reader.Iterate(callback, keyType)
+}
+
+func (s *reader) Range(from stoabs.Key, to stoabs.Key, callback stoabs.CallerFn, stopAtNil bool) error {
+	if err := s.store.transientError(); err != nil {
+		return err
+	}
+	return s.Reader.Range(from, to, callback, stopAtNil)
+}
+
+// writer injects transient errors into the operations of a writer.
+type writer struct {
+	reader
+	writer stoabs.Writer
+}
+
+func (s *writer) Put(key stoabs.Key, value []byte) error {
+	if err := s.store.transientError(); err != nil {
+		return err
+	}
+	return s.writer.Put(key, value)
+}
+
+func (s *writer) Delete(key stoabs.Key) error {
+	if err := s.store.transientError(); err != nil {
+		return err
+	}
+	return s.writer.Delete(key)
+}
@@ -0,0 +1,185 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package chaos
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/nuts-foundation/go-stoabs/util"
+	"github.com/stretchr/testify/assert"
+)
+
+var ctx = context.Background()
+
+const shelf = "test"
+
+var key = stoabs.BytesKey("key")
+
+func TestChaos(t *testing.T) {
+	provider := func(t *testing.T) (stoabs.KVStore, error) {
+		return Wrap(stores.BBolt(t)), nil
+	}
+
+	kvtests.TestReadingAndWriting(t, provider)
+	kvtests.TestRange(t, provider)
+	kvtests.TestIterate(t, provider)
+	kvtests.TestEmpty(t, provider)
+	kvtests.TestDelete(t, provider)
+	kvtests.TestWriteTransactions(t, provider)
+	kvtests.TestShelfNames(t, provider)
+}
+
+func TestWithLatency(t *testing.T) {
+	store := Wrap(stores.BBolt(t), WithLatency(20*time.Millisecond, 30*time.Millisecond))
+
+	start := time.Now()
+	assert.NoError(t, read(store))
+
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+	assert.Equal(t, uint64(1), store.Stats().Delays)
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, time.Millisecond)
+		defer cancel()
+
+		err := store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+			return nil
+		})
+
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.ErrorIs(t, err, stoabs.ErrDatabase{})
+	})
+}
+
+func TestWithTransientErrors(t *testing.T) {
+	t.Run("always", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t), WithTransientErrors(1))
+
+		err := read(store)
+
+		assert.ErrorIs(t, err, ErrInjected)
+		assert.True(t, util.IsStoreFailure(err))
+		assert.Equal(t, uint64(1), store.Stats().Errors)
+	})
+	t.Run("in readers and writers", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t), WithTransientErrors(0.5), WithSeed(1))
+
+		var failures int
+		for i := 0; i < 100; i++ {
+			err := store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+				if err := writer.Put(key, []byte("value")); err != nil {
+					failures++
+				}
+				return nil
+			})
+			if err != nil {
+				failures++
+			}
+		}
+
+		assert.Greater(t, failures, 0)
+		assert.Less(t, failures, 200)
+		assert.Equal(t, uint64(failures), store.Stats().Errors)
+	})
+	t.Run("disabled", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t), WithTransientErrors(1))
+		store.SetEnabled(false)
+
+		assert.NoError(t, read(store))
+		assert.Equal(t, Stats{}, store.Stats())
+	})
+}
+
+func TestWithCommitFailures(t *testing.T) {
+	store := Wrap(stores.BBolt(t), WithCommitFailures(1))
+	var rolledBack, committed bool
+
+	err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+		return tx.GetShelfWriter(shelf).Put(key, []byte("value"))
+	}, stoabs.OnRollback(func() {
+		rolledBack = true
+	}), stoabs.AfterCommit(func() {
+		committed = true
+	}))
+
+	assert.ErrorIs(t, err, stoabs.ErrCommitFailed)
+	assert.ErrorIs(t, err, ErrInjected)
+	assert.True(t, rolledBack)
+	assert.False(t, committed)
+	assert.Equal(t, uint64(1), store.Stats().CommitFailures)
+	// the transaction was rolled back
+	store.SetEnabled(false)
+	err = store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+		_, err := reader.Get(key)
+		return err
+	})
+	assert.ErrorIs(t, err, stoabs.ErrKeyNotFound)
+}
+
+func TestWithTornHooks(t *testing.T) {
+	store := Wrap(stores.BBolt(t), WithTornHooks(1))
+	var invoked []int
+
+	err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+		return tx.GetShelfWriter(shelf).Put(key, []byte("value"))
+	}, stoabs.AfterCommit(func() {
+		invoked = append(invoked, 1)
+	}), stoabs.AfterCommit(func() {
+		invoked = append(invoked, 2)
+	}))
+
+	assert.NoError(t, err)
+	assert.Less(t, len(invoked), 2)
+	if len(invoked) == 1 {
+		// hooks are invoked in order, so only the last ones are skipped
+		assert.Equal(t, []int{1}, invoked)
+	}
+	assert.Equal(t, uint64(1), store.Stats().TornHooks)
+	// the transaction was committed
+	err = store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+		_, err := reader.Get(key)
+		return err
+	})
+	assert.NoError(t, err)
+}
+
+func TestWithSeed(t *testing.T) {
+	results := func(seed int64) []bool {
+		store := Wrap(stores.BBolt(t), WithTransientErrors(0.5), WithSeed(seed))
+		var result []bool
+		for i := 0; i < 20; i++ {
+			result = append(result, read(store) == nil)
+		}
+		return result
+	}
+
+	assert.Equal(t, results(42), results(42))
+	assert.NotEqual(t, results(42), results(43))
+}
+
+func read(store stoabs.KVStore) error {
+	return store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+		return nil
+	})
+}
@@ -0,0 +1,261 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package checksum provides a KVStore that appends a checksum to every value, and verifies it when the value is read.
+package checksum
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/nuts-foundation/go-stoabs"
+)
+
+var _ stoabs.KVStore = (*Store)(nil)
+var _ stoabs.ShelfLister = (*Store)(nil)
+var _ stoabs.WriteTx = (*tx)(nil)
+var _ stoabs.Writer = (*shelf)(nil)
+
+// Algorithm identifies a checksum algorithm. It's stored as last byte of every value written through the store.
+type Algorithm byte
+
+const (
+	// CRC32C checksums values using CRC-32 with the Castagnoli polynomial (4 bytes), which is hardware accelerated on most CPUs.
+	CRC32C Algorithm = 1
+	// XXHash checksums values using 64-bit xxHash (8 bytes).
+	XXHash Algorithm = 2
+)
+
+func (a Algorithm) String() string {
+	switch a {
+	case CRC32C:
+		return "crc32c"
+	case XXHash:
+		return "xxhash"
+	default:
+		return fmt.Sprintf("unknown (%d)", byte(a))
+	}
+}
+
+// size returns the length of the checksum in bytes, or 0 if the algorithm is unknown.
+func (a Algorithm) size() int {
+	switch a {
+	case CRC32C:
+		return 4
+	case XXHash:
+		return 8
+	default:
+		return 0
+	}
+}
+
+// appendSum appends the checksum of the value to dst.
+func (a Algorithm) appendSum(dst []byte, value []byte) []byte {
+	if a == XXHash {
+		return binary.BigEndian.AppendUint64(dst, xxhash.Sum64(value))
+	}
+	return binary.BigEndian.AppendUint32(dst, crc32.Checksum(value, castagnoli))
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrChecksum is returned when a value read from the underlying store doesn't match its checksum,
+// e.g. because of bit rot or because it wasn't written through the checksumming store.
+type ErrChecksum struct {
+	Shelf  string
+	Key    stoabs.Key
+	Reason string
+}
+
+func (e ErrChecksum) Error() string {
+	return fmt.Sprintf("checksum verification failed for key %s of shelf %s: %s", e.Key, e.Shelf, e.Reason)
+}
+
+// Is returns true for any ErrChecksum, so errors.Is(err, ErrChecksum{}) matches regardless of shelf and key.
+func (e ErrChecksum) Is(other error) bool {
+	_, ok := other.(ErrChecksum)
+	return ok
+}
+
+// Option configures the checksumming store.
+type Option func(s *Store)
+
+// WithAlgorithm sets the algorithm used to checksum values. It defaults to CRC32C.
+// Values are always verified using the algorithm they were written with, so the algorithm can be changed at any time.
+func WithAlgorithm(algorithm Algorithm) Option {
+	return func(s *Store) {
+		s.algorithm = algorithm
+	}
+}
+
+// WithKeyType specifies the type of the keys of the given shelf, used by VerifyAll to iterate over it.
+// It's required for shelves with other keys than stoabs.BytesKey in Redis, which stores keys in their string form.
+func WithKeyType(shelfName string, keyType stoabs.Key) Option {
+	return func(s *Store) {
+		s.keyTypes[shelfName] = keyType
+	}
+}
+
+// Wrap creates a store that appends a checksum and the algorithm (see WithAlgorithm) to every value before writing it
+// to the underlying store, and verifies the checksum when reading it (Get, Iterate and Range), failing with ErrChecksum
+// on mismatch. All values in the underlying store must have been written through the checksumming store.
+func Wrap(store stoabs.KVStore, opts ...Option) *Store {
+	result := &Store{
+		underlying: store,
+		algorithm:  CRC32C,
+		keyTypes:   map[string]stoabs.Key{},
+	}
+	for _, opt := range opts {
+		opt(result)
+	}
+	return result
+}
+
+// Store is a KVStore that checksums values. Use Wrap to create it.
+type Store struct {
+	underlying stoabs.KVStore
+	algorithm  Algorithm
+	keyTypes   map[string]stoabs.Key
+}
+
+func (s *Store) Close(ctx context.Context) error {
+	return s.underlying.Close(ctx)
+}
+
+func (s *Store) Write(ctx context.Context, fn func(stoabs.WriteTx) error, opts ...stoabs.TxOption) error {
+	return s.underlying.Write(ctx, func(underlyingTx stoabs.WriteTx) error {
+		return fn(&tx{ReadTx: underlyingTx, writeTx: underlyingTx, store: s})
+	}, opts...)
+}
+
+func (s *Store) WriteShelf(ctx context.Context, shelfName string, fn func(stoabs.Writer) error) error {
+	return s.Write(ctx, func(tx stoabs.WriteTx) error {
+		return fn(tx.GetShelfWriter(shelfName))
+	})
+}
+
+func (s *Store) Read(ctx context.Context, fn func(stoabs.ReadTx) error) error {
+	return s.underlying.Read(ctx, func(underlyingTx stoabs.ReadTx) error {
+		return fn(&tx{ReadTx: underlyingTx, store: s})
+	})
+}
+
+func (s *Store) ReadShelf(ctx context.Context, shelfName string, fn func(stoabs.Reader) error) error {
+	return s.Read(ctx, func(tx stoabs.ReadTx) error {
+		return fn(tx.GetShelfReader(shelfName))
+	})
+}
+
+// ShelfNames returns the shelves of the underlying store.
+func (s *Store) ShelfNames(ctx context.Context) ([]string, error) {
+	return stoabs.ShelfNames(ctx, s.underlying)
+}
+
+// seal appends the checksum and algorithm to the value.
+func (s *Store) seal(value []byte) []byte {
+	result := make([]byte, 0, len(value)+s.algorithm.size()+1)
+	result = append(result, value...)
+	result = s.algorithm.appendSum(result, value)
+	return append(result, byte(s.algorithm))
+}
+
+// Verify verifies the checksum of a value as stored in the underlying store, and returns the value without the checksum.
+// It returns ErrChecksum if the checksum doesn't match.
+func Verify(shelfName string, key stoabs.Key, stored []byte) ([]byte, error) {
+	if len(stored) == 0 {
+		return nil, ErrChecksum{Shelf: shelfName, Key: key, Reason: "missing checksum"}
+	}
+	algorithm := Algorithm(stored[len(stored)-1])
+	size := algorithm.size()
+	if size == 0 {
+		return nil, ErrChecksum{Shelf: shelfName, Key: key, Reason: "unsupported algorithm: " + algorithm.String()}
+	}
+	if len(stored) < size+1 {
+		return nil, ErrChecksum{Shelf: shelfName, Key: key, Reason: "missing checksum"}
+	}
+	end := len(stored) - size - 1
+	// limit the capacity, so appending to the value doesn't overwrite the checksum
+	value := stored[:end:end]
+	if string(algorithm.appendSum(nil, value)) != string(stored[end:len(stored)-1]) {
+		return nil, ErrChecksum{Shelf: shelfName, Key: key, Reason: algorithm.String() + " mismatch"}
+	}
+	return value, nil
+}
+
+type tx struct {
+	stoabs.ReadTx
+	writeTx stoabs.WriteTx
+	store   *Store
+}
+
+func (t *tx) GetShelfReader(shelfName string) stoabs.Reader {
+	return &shelf{Reader: t.ReadTx.GetShelfReader(shelfName), name: shelfName, store: t.store}
+}
+
+func (t *tx) GetShelfWriter(shelfName string) stoabs.Writer {
+	writer := t.writeTx.GetShelfWriter(shelfName)
+	return &shelf{Reader: writer, writer: writer, name: shelfName, store: t.store}
+}
+
+func (t *tx) Store() stoabs.KVStore {
+	return t.store
+}
+
+type shelf struct {
+	stoabs.Reader
+	writer stoabs.Writer
+	name   string
+	store  *Store
+}
+
+func (s *shelf) Get(key stoabs.Key) ([]byte, error) {
+	value, err := s.Reader.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return Verify(s.name, key, value)
+}
+
+func (s *shelf) Iterate(callback stoabs.CallerFn, keyType stoabs.Key) error {
+	return s.Reader.Iterate(s.verifyingCallback(callback), keyType)
+}
+
+func (s *shelf) Range(from stoabs.Key, to stoabs.Key, callback stoabs.CallerFn, stopAtNil bool) error {
+	return s.Reader.Range(from, to, s.verifyingCallback(callback), stopAtNil)
+}
+
+func (s *shelf) verifyingCallback(callback stoabs.CallerFn) stoabs.CallerFn {
+	return func(key stoabs.Key, value []byte) error {
+		verified, err := Verify(s.name, key, value)
+		if err != nil {
+			return err
+		}
+		return callback(key, verified)
+	}
+}
+
+func (s *shelf) Put(key stoabs.Key, value []byte) error {
+	return s.writer.Put(key, s.store.seal(value))
+}
+
+func (s *shelf) Delete(key stoabs.Key) error {
+	return s.writer.Delete(key)
+}
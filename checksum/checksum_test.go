@@ -0,0 +1,189 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package checksum
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+var key = stoabs.BytesKey("key")
+
+const shelfName = "test"
+
+func TestChecksum(t *testing.T) {
+	for _, algorithm := range []Algorithm{CRC32C, XXHash} {
+		t.Run(algorithm.String(), func(t *testing.T) {
+			provider := func(t *testing.T) (stoabs.KVStore, error) {
+				return Wrap(stores.BBolt(t), WithAlgorithm(algorithm)), nil
+			}
+
+			kvtests.TestReadingAndWriting(t, provider)
+			kvtests.TestRange(t, provider)
+			kvtests.TestIterate(t, provider)
+			kvtests.TestEmpty(t, provider)
+			kvtests.TestDelete(t, provider)
+			kvtests.TestWriteTransactions(t, provider)
+			kvtests.TestShelfNames(t, provider)
+		})
+	}
+}
+
+func TestStore_Put(t *testing.T) {
+	t.Run("crc32c", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		store := Wrap(underlying)
+
+		require.NoError(t, put(store, key, []byte("value")))
+
+		// CRC-32C of "value", followed by the algorithm
+		assert.Equal(t, []byte("value\xe1\xe0\x03\x63\x01"), get(t, underlying, key))
+		assert.Equal(t, []byte("value"), get(t, store, key))
+	})
+	t.Run("xxhash", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		store := Wrap(underlying, WithAlgorithm(XXHash))
+
+		require.NoError(t, put(store, key, []byte("value")))
+
+		raw := get(t, underlying, key)
+		assert.Len(t, raw, len("value")+8+1)
+		assert.Equal(t, byte(XXHash), raw[len(raw)-1])
+		assert.Equal(t, []byte("value"), get(t, store, key))
+	})
+	t.Run("empty value", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t))
+
+		require.NoError(t, put(store, key, []byte{}))
+
+		assert.Empty(t, get(t, store, key))
+	})
+	t.Run("value isn't modified", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t))
+		value := make([]byte, 5, 100)
+		copy(value, "value")
+
+		require.NoError(t, put(store, key, value))
+
+		assert.Equal(t, []byte("value"), value[:5])
+		assert.Equal(t, make([]byte, 95), value[5:100])
+	})
+	t.Run("algorithm can be changed", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		require.NoError(t, put(Wrap(underlying, WithAlgorithm(XXHash)), key, []byte("value")))
+
+		assert.Equal(t, []byte("value"), get(t, Wrap(underlying), key))
+	})
+}
+
+func TestStore_Get(t *testing.T) {
+	corruptions := map[string][]byte{
+		"bit flip":              []byte("valuf\xe1\xe0\x03\x63\x01"),
+		"missing checksum":      []byte("\x01"),
+		"empty":                 {},
+		"unsupported algorithm": []byte("value\xe1\xe0\x03\x63\x07"),
+	}
+	for name, raw := range corruptions {
+		t.Run(name, func(t *testing.T) {
+			underlying := stores.BBolt(t)
+			require.NoError(t, put(underlying, key, raw))
+
+			_, err := getErr(Wrap(underlying), key)
+
+			assert.ErrorIs(t, err, ErrChecksum{})
+			var checksumErr ErrChecksum
+			require.True(t, errors.As(err, &checksumErr))
+			assert.Equal(t, shelfName, checksumErr.Shelf)
+			assert.Equal(t, key, checksumErr.Key)
+		})
+	}
+	t.Run("error message", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		require.NoError(t, put(underlying, key, corruptions["bit flip"]))
+
+		_, err := getErr(Wrap(underlying), key)
+
+		assert.EqualError(t, err, "checksum verification failed for key 6b6579 of shelf test: crc32c mismatch")
+	})
+	t.Run("iterate and range", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		store := Wrap(underlying)
+		require.NoError(t, put(store, stoabs.BytesKey("a"), []byte("value")))
+		require.NoError(t, put(underlying, stoabs.BytesKey("b"), corruptions["bit flip"]))
+		callback := func(stoabs.Key, []byte) error {
+			return nil
+		}
+
+		err := store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			return reader.Iterate(callback, stoabs.BytesKey{})
+		})
+		assert.ErrorIs(t, err, ErrChecksum{})
+		err = store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			return reader.Range(stoabs.BytesKey("a"), stoabs.BytesKey("c"), callback, false)
+		})
+		assert.ErrorIs(t, err, ErrChecksum{})
+		err = store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			return reader.Range(stoabs.BytesKey("a"), stoabs.BytesKey("b"), callback, false)
+		})
+		assert.NoError(t, err)
+	})
+}
+
+func TestVerify(t *testing.T) {
+	t.Run("capacity of value is limited", func(t *testing.T) {
+		stored := []byte("value\xe1\xe0\x03\x63\x01")
+
+		value, err := Verify(shelfName, key, stored)
+
+		require.NoError(t, err)
+		_ = append(value, 'x')
+		assert.Equal(t, []byte("value\xe1\xe0\x03\x63\x01"), stored)
+	})
+}
+
+func put(store stoabs.KVStore, key stoabs.Key, value []byte) error {
+	return store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+		return writer.Put(key, value)
+	})
+}
+
+func getErr(store stoabs.KVStore, key stoabs.Key) ([]byte, error) {
+	var result []byte
+	err := store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+		var err error
+		result, err = reader.Get(key)
+		return err
+	})
+	return result, err
+}
+
+func get(t *testing.T, store stoabs.KVStore, key stoabs.Key) []byte {
+	result, err := getErr(store, key)
+	require.NoError(t, err)
+	return result
+}
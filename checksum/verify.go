@@ -0,0 +1,75 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package checksum
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nuts-foundation/go-stoabs"
+)
+
+// Report is the result of VerifyAll.
+type Report struct {
+	// Shelves is the number of verified shelves.
+	Shelves int
+	// Entries is the number of verified entries, including the corrupt entries.
+	Entries int
+	// Corrupt holds the entries that failed verification, in the order they were found.
+	Corrupt []ErrChecksum
+}
+
+// VerifyAll verifies the checksums of all entries of all shelves of the underlying store (which must implement
+// stoabs.ShelfLister), e.g. to periodically detect bit rot in long-lived database files. Reserved shelves are only
+// verified if the context includes them (see stoabs.ContextWithReservedShelves). Every shelf is read in its own
+// read transaction. Corrupt entries don't stop the verification, they're listed in the report instead.
+// Keys are read as stoabs.BytesKey unless specified otherwise using WithKeyType.
+// It returns an error if reading fails, with the report of the entries verified until then.
+func (s *Store) VerifyAll(ctx context.Context) (Report, error) {
+	var report Report
+	shelves, err := stoabs.ShelfNames(ctx, s.underlying)
+	if err != nil {
+		return report, fmt.Errorf("unable to list shelves: %w", err)
+	}
+	for _, shelfName := range shelves {
+		keyType, ok := s.keyTypes[shelfName]
+		if !ok {
+			keyType = stoabs.BytesKey{}
+		}
+		err := s.underlying.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			return reader.Iterate(func(key stoabs.Key, value []byte) error {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				report.Entries++
+				var corrupt ErrChecksum
+				if _, err := Verify(shelfName, key, value); errors.As(err, &corrupt) {
+					report.Corrupt = append(report.Corrupt, corrupt)
+				}
+				return nil
+			}, keyType)
+		})
+		if err != nil {
+			return report, fmt.Errorf("unable to verify shelf %s: %w", shelfName, err)
+		}
+		report.Shelves++
+	}
+	return report, nil
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package checksum
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestStore_VerifyAll(t *testing.T) {
+	t.Run("reports corrupt entries", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		store := Wrap(underlying)
+		require.NoError(t, put(store, stoabs.BytesKey("a"), []byte("value")))
+		require.NoError(t, put(underlying, stoabs.BytesKey("b"), []byte("valuf\xe1\xe0\x03\x63\x01")))
+		require.NoError(t, store.WriteShelf(ctx, "other", func(writer stoabs.Writer) error {
+			return writer.Put(key, []byte("value"))
+		}))
+
+		report, err := store.VerifyAll(ctx)
+
+		require.NoError(t, err)
+		assert.Equal(t, 2, report.Shelves)
+		assert.Equal(t, 3, report.Entries)
+		require.Len(t, report.Corrupt, 1)
+		assert.Equal(t, shelfName, report.Corrupt[0].Shelf)
+		assert.Equal(t, stoabs.BytesKey("b"), report.Corrupt[0].Key)
+	})
+	t.Run("reserved shelves", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		store := Wrap(underlying)
+		require.NoError(t, underlying.WriteShelf(ctx, stoabs.ReservedShelfPrefix+"internal", func(writer stoabs.Writer) error {
+			return writer.Put(key, []byte("unchecked"))
+		}))
+
+		report, err := store.VerifyAll(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, report.Corrupt)
+
+		report, err = store.VerifyAll(stoabs.ContextWithReservedShelves(ctx))
+		require.NoError(t, err)
+		assert.Len(t, report.Corrupt, 1)
+	})
+	t.Run("cancelled", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t))
+		require.NoError(t, put(store, key, []byte("value")))
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+
+		_, err := store.VerifyAll(cancelled)
+
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+	t.Run("store can't list shelves", func(t *testing.T) {
+		store := Wrap(stoabs.NewMockKVStore(gomock.NewController(t)))
+
+		_, err := store.VerifyAll(ctx)
+
+		assert.ErrorIs(t, err, errors.ErrUnsupported)
+	})
+}
@@ -0,0 +1,378 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package chunk provides a KVStore that transparently splits large values into chunks.
+package chunk
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/nuts-foundation/go-stoabs"
+)
+
+var _ stoabs.KVStore = (*Store)(nil)
+var _ stoabs.ShelfLister = (*Store)(nil)
+var _ stoabs.WriteTx = (*tx)(nil)
+var _ stoabs.Writer = (*shelf)(nil)
+
+// chunkShelfPrefix is the prefix of the shelves holding the chunks of a shelf, followed by the name of the shelf.
+const chunkShelfPrefix = stoabs.ReservedShelfPrefix + "chunks/"
+
+const (
+	// inline marks values stored as-is, because they're smaller than the threshold.
+	inline byte = 0
+	// chunked marks the manifest of a value that is stored in chunks.
+	chunked byte = 1
+)
+
+const defaultThreshold = 256 * 1024
+
+const defaultChunkSize = 256 * 1024
+
+// ErrCorrupt is returned when a chunked value read from the underlying store is incomplete or doesn't match its hashes.
+var ErrCorrupt = errors.New("chunked value is corrupt")
+
+// Option configures the chunking store.
+type Option func(s *Store)
+
+// WithThreshold sets the minimum size (in bytes) of values to split into chunks. Smaller values are stored as-is.
+// It defaults to 256 KiB.
+func WithThreshold(threshold int) Option {
+	return func(s *Store) {
+		s.threshold = threshold
+	}
+}
+
+// WithChunkSize sets the maximum size (in bytes) of the chunks values are split into. It defaults to 256 KiB.
+// Values are read using the chunk size they were written with, so it can be changed at any time.
+func WithChunkSize(size int) Option {
+	return func(s *Store) {
+		s.chunkSize = size
+	}
+}
+
+// Wrap creates a store that splits values larger than the threshold (see WithThreshold) into chunks (see WithChunkSize),
+// which are stored in the _stoabs/chunks/<shelf> shelf of the underlying store. The value itself is replaced by a manifest
+// holding the SHA-256 hash of every chunk, which is verified when reading the value.
+// Every value is prefixed with a single byte indicating whether it's chunked, meaning all values in the underlying store
+// must have been written through the chunking store.
+func Wrap(store stoabs.KVStore, opts ...Option) *Store {
+	result := &Store{
+		underlying: store,
+		threshold:  defaultThreshold,
+		chunkSize:  defaultChunkSize,
+	}
+	for _, opt := range opts {
+		opt(result)
+	}
+	return result
+}
+
+// Store is a KVStore that splits large values into chunks. Use Wrap to create it.
+type Store struct {
+	underlying stoabs.KVStore
+	threshold  int
+	chunkSize  int
+}
+
+func (s *Store) Close(ctx context.Context) error {
+	return s.underlying.Close(ctx)
+}
+
+func (s *Store) Write(ctx context.Context, fn func(stoabs.WriteTx) error, opts ...stoabs.TxOption) error {
+	return s.underlying.Write(ctx, func(underlyingTx stoabs.WriteTx) error {
+		return fn(&tx{ReadTx: underlyingTx, writeTx: underlyingTx, store: s})
+	}, opts...)
+}
+
+func (s *Store) WriteShelf(ctx context.Context, shelfName string, fn func(stoabs.Writer) error) error {
+	return s.Write(ctx, func(tx stoabs.WriteTx) error {
+		return fn(tx.GetShelfWriter(shelfName))
+	})
+}
+
+func (s *Store) Read(ctx context.Context, fn func(stoabs.ReadTx) error) error {
+	return s.underlying.Read(ctx, func(underlyingTx stoabs.ReadTx) error {
+		return fn(&tx{ReadTx: underlyingTx, store: s})
+	})
+}
+
+func (s *Store) ReadShelf(ctx context.Context, shelfName string, fn func(stoabs.Reader) error) error {
+	return s.Read(ctx, func(tx stoabs.ReadTx) error {
+		return fn(tx.GetShelfReader(shelfName))
+	})
+}
+
+// ShelfNames returns the shelves of the underlying store. The shelves holding chunks are reserved,
+// so they're only listed when requested using stoabs.ContextWithReservedShelves.
+func (s *Store) ShelfNames(ctx context.Context) ([]string, error) {
+	return stoabs.ShelfNames(ctx, s.underlying)
+}
+
+// Open returns a reader that streams the value of the given key chunk by chunk, verifying every chunk when it's read,
+// and the size of the value. The reader must have been obtained from a transaction of the chunking store,
+// and the returned reader is only valid during that transaction.
+// It returns stoabs.ErrKeyNotFound if the key doesn't exist.
+func Open(reader stoabs.Reader, key stoabs.Key) (io.Reader, int64, error) {
+	s, ok := reader.(*shelf)
+	if !ok {
+		return nil, 0, fmt.Errorf("reader isn't a reader of the chunking store (type=%T)", reader)
+	}
+	raw, err := s.Reader.Get(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(raw) > 0 && raw[0] == inline {
+		return bytes.NewReader(raw[1:]), int64(len(raw) - 1), nil
+	}
+	m, err := parseManifest(raw)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w (key=%s): %w", ErrCorrupt, key, err)
+	}
+	return &chunkReader{shelf: s, key: key, manifest: m}, int64(m.size), nil
+}
+
+// manifest describes a chunked value: its size, the size of its chunks (except the last one), and the hash of every chunk.
+type manifest struct {
+	size      uint64
+	chunkSize uint64
+	hashes    [][sha256.Size]byte
+}
+
+func (m manifest) bytes() []byte {
+	result := binary.AppendUvarint([]byte{chunked}, m.size)
+	result = binary.AppendUvarint(result, m.chunkSize)
+	for _, hash := range m.hashes {
+		result = append(result, hash[:]...)
+	}
+	return result
+}
+
+// chunkLen returns the expected length of the given chunk.
+func (m manifest) chunkLen(index int) uint64 {
+	if index == len(m.hashes)-1 {
+		return m.size - uint64(index)*m.chunkSize
+	}
+	return m.chunkSize
+}
+
+func parseManifest(raw []byte) (manifest, error) {
+	if len(raw) == 0 {
+		return manifest{}, errors.New("missing header")
+	}
+	if raw[0] != chunked {
+		return manifest{}, fmt.Errorf("unknown header: %d", raw[0])
+	}
+	data := raw[1:]
+	var result manifest
+	var n int
+	if result.size, n = binary.Uvarint(data); n <= 0 {
+		return manifest{}, errors.New("invalid size")
+	}
+	data = data[n:]
+	if result.chunkSize, n = binary.Uvarint(data); n <= 0 || result.chunkSize == 0 {
+		return manifest{}, errors.New("invalid chunk size")
+	}
+	data = data[n:]
+	count := (result.size + result.chunkSize - 1) / result.chunkSize
+	if uint64(len(data)) != count*sha256.Size {
+		return manifest{}, fmt.Errorf("expected %d chunk hashes", count)
+	}
+	result.hashes = make([][sha256.Size]byte, count)
+	for i := range result.hashes {
+		copy(result.hashes[i][:], data[i*sha256.Size:])
+	}
+	return result, nil
+}
+
+// chunkKey returns the key of a chunk of the value of the given key: the key followed by the index of the chunk.
+// Since all chunk keys of a key have the same length, they can't collide with the chunk keys of other keys.
+func chunkKey(key stoabs.Key, index int) stoabs.Key {
+	return stoabs.BytesKey(binary.BigEndian.AppendUint32(bytes.Clone(key.Bytes()), uint32(index)))
+}
+
+type tx struct {
+	stoabs.ReadTx
+	writeTx stoabs.WriteTx
+	store   *Store
+}
+
+func (t *tx) GetShelfReader(shelfName string) stoabs.Reader {
+	return &shelf{Reader: t.ReadTx.GetShelfReader(shelfName), name: shelfName, tx: t}
+}
+
+func (t *tx) GetShelfWriter(shelfName string) stoabs.Writer {
+	writer := t.writeTx.GetShelfWriter(shelfName)
+	return &shelf{Reader: writer, writer: writer, name: shelfName, tx: t}
+}
+
+func (t *tx) Store() stoabs.KVStore {
+	return t.store
+}
+
+type shelf struct {
+	stoabs.Reader
+	writer stoabs.Writer
+	name   string
+	tx     *tx
+}
+
+func (s *shelf) Get(key stoabs.Key) ([]byte, error) {
+	value, err := s.Reader.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.value(key, value)
+}
+
+func (s *shelf) Iterate(callback stoabs.CallerFn, keyType stoabs.Key) error {
+	return s.Reader.Iterate(s.assemblingCallback(callback), keyType)
+}
+
+func (s *shelf) Range(from stoabs.Key, to stoabs.Key, callback stoabs.CallerFn, stopAtNil bool) error {
+	return s.Reader.Range(from, to, s.assemblingCallback(callback), stopAtNil)
+}
+
+func (s *shelf) assemblingCallback(callback stoabs.CallerFn) stoabs.CallerFn {
+	return func(key stoabs.Key, raw []byte) error {
+		value, err := s.value(key, raw)
+		if err != nil {
+			return err
+		}
+		return callback(key, value)
+	}
+}
+
+// value returns the value stored as given raw value, reading and verifying its chunks if it's chunked.
+func (s *shelf) value(key stoabs.Key, raw []byte) ([]byte, error) {
+	if len(raw) > 0 && raw[0] == inline {
+		return raw[1:], nil
+	}
+	m, err := parseManifest(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w (key=%s): %w", ErrCorrupt, key, err)
+	}
+	result := make([]byte, 0, m.size)
+	for i := range m.hashes {
+		chunk, err := s.chunk(key, m, i)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, chunk...)
+	}
+	return result, nil
+}
+
+// chunk reads and verifies the chunk with the given index of the value of the given key.
+func (s *shelf) chunk(key stoabs.Key, m manifest, index int) ([]byte, error) {
+	chunk, err := s.tx.ReadTx.GetShelfReader(chunkShelfPrefix + s.name).Get(chunkKey(key, index))
+	if errors.Is(err, stoabs.ErrKeyNotFound) {
+		return nil, fmt.Errorf("%w (key=%s): missing chunk %d", ErrCorrupt, key, index)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(chunk)) != m.chunkLen(index) || sha256.Sum256(chunk) != m.hashes[index] {
+		return nil, fmt.Errorf("%w (key=%s): chunk %d doesn't match its hash", ErrCorrupt, key, index)
+	}
+	return chunk, nil
+}
+
+func (s *shelf) Put(key stoabs.Key, value []byte) error {
+	if err := s.deleteChunks(key); err != nil {
+		return err
+	}
+	if len(value) < s.tx.store.threshold || s.tx.store.chunkSize <= 0 {
+		return s.writer.Put(key, append([]byte{inline}, value...))
+	}
+	m := manifest{size: uint64(len(value)), chunkSize: uint64(s.tx.store.chunkSize)}
+	chunks := s.tx.writeTx.GetShelfWriter(chunkShelfPrefix + s.name)
+	for start := 0; start < len(value); start += s.tx.store.chunkSize {
+		chunk := value[start:min(start+s.tx.store.chunkSize, len(value))]
+		if err := chunks.Put(chunkKey(key, len(m.hashes)), chunk); err != nil {
+			return err
+		}
+		m.hashes = append(m.hashes, sha256.Sum256(chunk))
+	}
+	return s.writer.Put(key, m.bytes())
+}
+
+func (s *shelf) Delete(key stoabs.Key) error {
+	if err := s.deleteChunks(key); err != nil {
+		return err
+	}
+	return s.writer.Delete(key)
+}
+
+// deleteChunks deletes the chunks of the current value of the given key, if it's chunked.
+func (s *shelf) deleteChunks(key stoabs.Key) error {
+	raw, err := s.writer.Get(key)
+	if errors.Is(err, stoabs.ErrKeyNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(raw) > 0 && raw[0] == inline {
+		return nil
+	}
+	m, err := parseManifest(raw)
+	if err != nil {
+		return fmt.Errorf("%w (key=%s): %w", ErrCorrupt, key, err)
+	}
+	chunks := s.tx.writeTx.GetShelfWriter(chunkShelfPrefix + s.name)
+	for i := range m.hashes {
+		if err := chunks.Delete(chunkKey(key, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chunkReader streams a chunked value, reading a chunk when the previous one has been consumed.
+type chunkReader struct {
+	shelf    *shelf
+	key      stoabs.Key
+	manifest manifest
+	// next is the index of the next chunk to read.
+	next    int
+	current []byte
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.current) == 0 {
+		if r.next == len(r.manifest.hashes) {
+			return 0, io.EOF
+		}
+		chunk, err := r.shelf.chunk(r.key, r.manifest, r.next)
+		if err != nil {
+			return 0, err
+		}
+		r.current = chunk
+		r.next++
+	}
+	n := copy(p, r.current)
+	r.current = r.current[n:]
+	return n, nil
+}
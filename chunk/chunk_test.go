@@ -0,0 +1,231 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package chunk
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+var key = stoabs.BytesKey("key")
+
+const shelfName = "test"
+
+var largeValue = bytes.Repeat([]byte("0123456789"), 1000)
+
+func TestChunk(t *testing.T) {
+	provider := func(t *testing.T) (stoabs.KVStore, error) {
+		// chunk all values to exercise assembling in every operation
+		return Wrap(stores.BBolt(t), WithThreshold(0), WithChunkSize(3)), nil
+	}
+
+	kvtests.TestReadingAndWriting(t, provider)
+	kvtests.TestRange(t, provider)
+	kvtests.TestIterate(t, provider)
+	kvtests.TestEmpty(t, provider)
+	kvtests.TestDelete(t, provider)
+	kvtests.TestWriteTransactions(t, provider)
+	kvtests.TestShelfNames(t, provider)
+}
+
+func TestStore_Put(t *testing.T) {
+	t.Run("chunked", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		store := Wrap(underlying, WithThreshold(1000), WithChunkSize(4096))
+
+		require.NoError(t, put(store, key, largeValue))
+
+		assert.Equal(t, chunked, get(t, underlying, shelfName, key)[0])
+		assert.Equal(t, 3, count(t, underlying, chunkShelfPrefix+shelfName))
+		assert.Equal(t, largeValue, get(t, store, shelfName, key))
+	})
+	t.Run("below threshold", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		store := Wrap(underlying)
+
+		require.NoError(t, put(store, key, []byte("small")))
+
+		assert.Equal(t, []byte("\x00small"), get(t, underlying, shelfName, key))
+		assert.Equal(t, []byte("small"), get(t, store, shelfName, key))
+	})
+	t.Run("overwriting removes the old chunks", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		store := Wrap(underlying, WithThreshold(1000), WithChunkSize(1000))
+		require.NoError(t, put(store, key, largeValue))
+		require.Equal(t, 10, count(t, underlying, chunkShelfPrefix+shelfName))
+
+		require.NoError(t, put(store, key, largeValue[:2500]))
+
+		assert.Equal(t, 3, count(t, underlying, chunkShelfPrefix+shelfName))
+		assert.Equal(t, largeValue[:2500], get(t, store, shelfName, key))
+
+		require.NoError(t, put(store, key, []byte("small")))
+
+		assert.Equal(t, 0, count(t, underlying, chunkShelfPrefix+shelfName))
+	})
+	t.Run("chunk size changed", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		require.NoError(t, put(Wrap(underlying, WithThreshold(1000), WithChunkSize(1000)), key, largeValue))
+
+		assert.Equal(t, largeValue, get(t, Wrap(underlying, WithChunkSize(10)), shelfName, key))
+	})
+}
+
+func TestStore_Delete(t *testing.T) {
+	underlying := stores.BBolt(t)
+	store := Wrap(underlying, WithThreshold(1000), WithChunkSize(1000))
+	require.NoError(t, put(store, key, largeValue))
+
+	err := store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+		return writer.Delete(key)
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, 0, count(t, underlying, shelfName))
+	assert.Equal(t, 0, count(t, underlying, chunkShelfPrefix+shelfName))
+}
+
+func TestStore_Get(t *testing.T) {
+	setup := func(t *testing.T) (stoabs.KVStore, *Store) {
+		underlying := stores.BBolt(t)
+		store := Wrap(underlying, WithThreshold(1000), WithChunkSize(1000))
+		require.NoError(t, put(store, key, largeValue))
+		return underlying, store
+	}
+	read := func(store stoabs.KVStore) error {
+		return store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			_, err := reader.Get(key)
+			return err
+		})
+	}
+
+	t.Run("corrupt chunk", func(t *testing.T) {
+		underlying, store := setup(t)
+		require.NoError(t, underlying.WriteShelf(ctx, chunkShelfPrefix+shelfName, func(writer stoabs.Writer) error {
+			return writer.Put(chunkKey(key, 1), bytes.Repeat([]byte("x"), 1000))
+		}))
+
+		err := read(store)
+
+		assert.ErrorIs(t, err, ErrCorrupt)
+		assert.ErrorContains(t, err, "chunk 1 doesn't match its hash")
+	})
+	t.Run("missing chunk", func(t *testing.T) {
+		underlying, store := setup(t)
+		require.NoError(t, underlying.WriteShelf(ctx, chunkShelfPrefix+shelfName, func(writer stoabs.Writer) error {
+			return writer.Delete(chunkKey(key, 9))
+		}))
+
+		err := read(store)
+
+		assert.ErrorIs(t, err, ErrCorrupt)
+		assert.ErrorContains(t, err, "missing chunk 9")
+	})
+	t.Run("invalid manifest", func(t *testing.T) {
+		underlying, store := setup(t)
+		require.NoError(t, underlying.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			return writer.Put(key, []byte{chunked, 1})
+		}))
+
+		err := read(store)
+
+		assert.ErrorIs(t, err, ErrCorrupt)
+	})
+}
+
+func TestOpen(t *testing.T) {
+	store := Wrap(stores.BBolt(t), WithThreshold(1000), WithChunkSize(1000))
+	require.NoError(t, put(store, key, largeValue))
+	require.NoError(t, put(store, stoabs.BytesKey("small"), []byte("small")))
+
+	t.Run("chunked", func(t *testing.T) {
+		err := store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			stream, size, err := Open(reader, key)
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(largeValue)), size)
+			actual, err := io.ReadAll(stream)
+			require.NoError(t, err)
+			assert.Equal(t, largeValue, actual)
+			return nil
+		})
+		require.NoError(t, err)
+	})
+	t.Run("not chunked", func(t *testing.T) {
+		err := store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			stream, size, err := Open(reader, stoabs.BytesKey("small"))
+			require.NoError(t, err)
+			assert.Equal(t, int64(5), size)
+			actual, err := io.ReadAll(stream)
+			require.NoError(t, err)
+			assert.Equal(t, []byte("small"), actual)
+			return nil
+		})
+		require.NoError(t, err)
+	})
+	t.Run("not found", func(t *testing.T) {
+		err := store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			_, _, err := Open(reader, stoabs.BytesKey("other"))
+			return err
+		})
+		assert.ErrorIs(t, err, stoabs.ErrKeyNotFound)
+	})
+	t.Run("reader of another store", func(t *testing.T) {
+		_, _, err := Open(stoabs.NilReader{}, key)
+
+		assert.ErrorContains(t, err, "reader isn't a reader of the chunking store")
+	})
+}
+
+func put(store stoabs.KVStore, key stoabs.Key, value []byte) error {
+	return store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+		return writer.Put(key, value)
+	})
+}
+
+func get(t *testing.T, store stoabs.KVStore, shelf string, key stoabs.Key) []byte {
+	var result []byte
+	require.NoError(t, store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+		var err error
+		result, err = reader.Get(key)
+		return err
+	}))
+	return result
+}
+
+// count returns the number of entries in the shelf of the underlying store.
+func count(t *testing.T, store stoabs.KVStore, shelf string) int {
+	var result int
+	require.NoError(t, store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+		return reader.Iterate(func(_ stoabs.Key, _ []byte) error {
+			result++
+			return nil
+		}, stoabs.BytesKey{})
+	}))
+	return result
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Clock provides the current time and timers. Time-dependent behavior (e.g. expiry or lock acquisition timeouts)
+// uses it, so it can be tested with a fake clock (see WithClock) instead of sleeping.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// After returns a channel that receives the current time once the given duration elapsed.
+	After(d time.Duration) <-chan time.Time
+}
+
+// SystemClock is the Clock that uses the system time.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// WithClock overrides the clock used for time-dependent behavior (e.g. lock acquisition timeouts), which defaults to SystemClock.
+func WithClock(clock Clock) Option {
+	return func(config *Config) {
+		config.Clock = clock
+	}
+}
+
+// ContextWithTimeout is like context.WithTimeout, but the timeout is measured by the given clock:
+// the returned context is done when the clock reaches the timeout, after which its Err() returns context.DeadlineExceeded.
+// For SystemClock (or a nil clock) it's equivalent to context.WithTimeout.
+func ContextWithTimeout(ctx context.Context, clock Clock, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if clock == nil || clock == SystemClock {
+		return context.WithTimeout(ctx, timeout)
+	}
+	inner, cancel := context.WithCancel(ctx)
+	result := &clockTimeoutContext{Context: inner}
+	go func() {
+		select {
+		case <-clock.After(timeout):
+			result.mux.Lock()
+			result.expired = true
+			result.mux.Unlock()
+			cancel()
+		case <-inner.Done():
+		}
+	}()
+	return result, cancel
+}
+
+// clockTimeoutContext is a context that reports context.DeadlineExceeded when it was cancelled because its timeout
+// on a Clock passed.
+type clockTimeoutContext struct {
+	context.Context
+	mux     sync.Mutex
+	expired bool
+}
+
+func (c *clockTimeoutContext) Err() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.expired {
+		return context.DeadlineExceeded
+	}
+	return c.Context.Err()
+}
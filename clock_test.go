@@ -0,0 +1,71 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// manualClock is a Clock of which the timers fire when the test sends a value on timer.
+type manualClock struct {
+	timer chan time.Time
+}
+
+func (m manualClock) Now() time.Time {
+	return time.Now()
+}
+
+func (m manualClock) After(_ time.Duration) <-chan time.Time {
+	return m.timer
+}
+
+func TestContextWithTimeout(t *testing.T) {
+	t.Run("expires when clock reaches the timeout", func(t *testing.T) {
+		clock := manualClock{timer: make(chan time.Time, 1)}
+		ctx, cancel := ContextWithTimeout(context.Background(), clock, time.Hour)
+		defer cancel()
+		assert.NoError(t, ctx.Err())
+
+		clock.timer <- time.Now()
+
+		<-ctx.Done()
+		assert.ErrorIs(t, ctx.Err(), context.DeadlineExceeded)
+	})
+	t.Run("cancelled", func(t *testing.T) {
+		clock := manualClock{timer: make(chan time.Time)}
+		ctx, cancel := ContextWithTimeout(context.Background(), clock, time.Hour)
+
+		cancel()
+
+		<-ctx.Done()
+		assert.ErrorIs(t, ctx.Err(), context.Canceled)
+	})
+	t.Run("system clock", func(t *testing.T) {
+		ctx, cancel := ContextWithTimeout(context.Background(), SystemClock, time.Millisecond)
+		defer cancel()
+
+		<-ctx.Done()
+		assert.ErrorIs(t, ctx.Err(), context.DeadlineExceeded)
+		_, hasDeadline := ctx.Deadline()
+		assert.True(t, hasDeadline)
+	})
+}
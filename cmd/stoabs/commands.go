@@ -0,0 +1,348 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/bbolt"
+	"github.com/nuts-foundation/go-stoabs/dump"
+	"github.com/nuts-foundation/go-stoabs/util"
+	"github.com/nuts-foundation/go-stoabs/verify"
+	bboltdb "go.etcd.io/bbolt"
+)
+
+// fileLockTimeout is the maximum time to wait for a BBolt database that is in use.
+const fileLockTimeout = 5 * time.Second
+
+// compactTxMaxSize is the maximum size of a transaction when compacting a BBolt database.
+const compactTxMaxSize = 64 * 1024 * 1024
+
+func listCommand(flags *flag.FlagSet) action {
+	reserved := reservedFlag(flags)
+	return func(ctx context.Context, env env, store stoabs.KVStore, _ string, args []string) error {
+		w := tabwriter.NewWriter(env.stdout, 0, 4, 2, ' ', 0)
+		if len(args) == 0 {
+			if *reserved {
+				ctx = stoabs.ContextWithReservedShelves(ctx)
+			}
+			names, err := stoabs.ShelfNames(ctx, store)
+			if err != nil {
+				return err
+			}
+			_, _ = fmt.Fprintln(w, "SHELF\tENTRIES\tSIZE")
+			err = store.Read(ctx, func(tx stoabs.ReadTx) error {
+				for _, name := range names {
+					stats := tx.GetShelfReader(name).Stats()
+					_, _ = fmt.Fprintf(w, "%s\t%d\t%d\n", name, stats.NumEntries, stats.ShelfSize)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			return w.Flush()
+		}
+		_, _ = fmt.Fprintln(w, "KEY\tSIZE")
+		err := store.ReadShelf(ctx, args[0], func(reader stoabs.Reader) error {
+			return reader.Iterate(func(key stoabs.Key, value []byte) error {
+				_, err := fmt.Fprintf(w, "%s\t%d\n", util.FormatKey(key.Bytes()), len(value))
+				return err
+			}, stoabs.BytesKey{})
+		})
+		if err != nil {
+			return err
+		}
+		return w.Flush()
+	}
+}
+
+func getCommand(flags *flag.FlagSet) action {
+	hexKey := hexFlag(flags)
+	return func(ctx context.Context, env env, store stoabs.KVStore, _ string, args []string) error {
+		key, err := parseKey(args[1], *hexKey)
+		if err != nil {
+			return err
+		}
+		return store.ReadShelf(ctx, args[0], func(reader stoabs.Reader) error {
+			value, err := reader.Get(key)
+			if err != nil {
+				return err
+			}
+			_, err = env.stdout.Write(value)
+			return err
+		})
+	}
+}
+
+func putCommand(flags *flag.FlagSet) action {
+	hexKey := hexFlag(flags)
+	return func(ctx context.Context, env env, store stoabs.KVStore, _ string, args []string) error {
+		key, err := parseKey(args[1], *hexKey)
+		if err != nil {
+			return err
+		}
+		var value []byte
+		if len(args) == 3 {
+			value = []byte(args[2])
+		} else if value, err = io.ReadAll(env.stdin); err != nil {
+			return fmt.Errorf("unable to read value from stdin: %w", err)
+		}
+		return store.WriteShelf(ctx, args[0], func(writer stoabs.Writer) error {
+			return writer.Put(key, value)
+		})
+	}
+}
+
+func deleteCommand(flags *flag.FlagSet) action {
+	hexKey := hexFlag(flags)
+	return func(ctx context.Context, env env, store stoabs.KVStore, _ string, args []string) error {
+		key, err := parseKey(args[1], *hexKey)
+		if err != nil {
+			return err
+		}
+		return store.WriteShelf(ctx, args[0], func(writer stoabs.Writer) error {
+			return writer.Delete(key)
+		})
+	}
+}
+
+func diffCommand(flags *flag.FlagSet) action {
+	repair := flags.Bool("repair", false, "makes the other store equal to the first store")
+	return func(ctx context.Context, env env, store stoabs.KVStore, _ string, args []string) error {
+		other, closeConn, err := open(args[0], false)
+		if err != nil {
+			return fmt.Errorf("unable to open other store: %w", err)
+		}
+		defer func() {
+			_ = other.Close(context.Background())
+			_ = closeConn()
+		}()
+		opts := []verify.Option{verify.WithShelves(args[1:]...)}
+		if *repair {
+			opts = append(opts, verify.WithRepair())
+		}
+		report, err := verify.Diff(ctx, store, other, opts...)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(env.stdout, 0, 4, 2, ' ', 0)
+		for _, difference := range report.Differences {
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", difference.Kind, difference.Shelf, util.FormatKey(difference.Key.Bytes()))
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(env.stdout, "compared %d entries: %d missing, %d extra, %d changed\n", report.Compared, report.Missing, report.Extra, report.Changed)
+		if *repair {
+			_, _ = fmt.Fprintf(env.stdout, "repaired %d entries\n", report.Repaired)
+			return nil
+		}
+		if !report.Equal() {
+			return errors.New("stores differ")
+		}
+		return nil
+	}
+}
+
+func exportCommand(flags *flag.FlagSet) action {
+	binary := flags.Bool("binary", false, "uses the binary export format instead of newline-delimited JSON")
+	output := flags.String("o", "", "writes the export to the given file instead of stdout")
+	reserved := reservedFlag(flags)
+	return func(ctx context.Context, env env, store stoabs.KVStore, _ string, shelves []string) error {
+		if *reserved {
+			ctx = stoabs.ContextWithReservedShelves(ctx)
+		}
+		w := env.stdout
+		if *output != "" {
+			file, err := os.Create(*output)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			w = file
+		}
+		var err error
+		if *binary {
+			err = dump.ExportBinary(ctx, store, w, shelves...)
+		} else {
+			err = dump.Export(ctx, store, w, shelves...)
+		}
+		if err != nil {
+			return err
+		}
+		if file, ok := w.(*os.File); ok && *output != "" {
+			return file.Sync()
+		}
+		return nil
+	}
+}
+
+func importCommand(flags *flag.FlagSet) action {
+	binary := flags.Bool("binary", false, "reads the binary export format instead of newline-delimited JSON")
+	input := flags.String("i", "", "reads the export from the given file instead of stdin")
+	return func(ctx context.Context, env env, store stoabs.KVStore, _ string, _ []string) error {
+		r := env.stdin
+		if *input != "" {
+			file, err := os.Open(*input)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			r = file
+		}
+		if *binary {
+			return dump.ImportBinary(ctx, store, r)
+		}
+		return dump.Import(ctx, store, r)
+	}
+}
+
+func compactCommand(_ *flag.FlagSet) action {
+	return func(_ context.Context, env env, _ stoabs.KVStore, uri string, _ []string) error {
+		parsed, err := url.Parse(uri)
+		if err != nil {
+			return fmt.Errorf("invalid store URI: %w", err)
+		}
+		if parsed.Scheme != "bbolt" {
+			return fmt.Errorf("compact is only supported for BBolt stores: %w", errors.ErrUnsupported)
+		}
+		filePath, err := filePath(parsed)
+		if err != nil {
+			return err
+		}
+		before, after, err := compact(filePath)
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(env.stdout, "compacted %s: %d -> %d bytes\n", filePath, before, after)
+		return nil
+	}
+}
+
+func verifyCommand(_ *flag.FlagSet) action {
+	return func(ctx context.Context, env env, store stoabs.KVStore, _ string, _ []string) error {
+		names, err := stoabs.ShelfNames(stoabs.ContextWithReservedShelves(ctx), store)
+		if err != nil {
+			return err
+		}
+		var entries int
+		err = store.Read(ctx, func(tx stoabs.ReadTx) error {
+			if boltTx, ok := tx.Unwrap().(*bboltdb.Tx); ok {
+				var problems []error
+				for err := range boltTx.Check() {
+					_, _ = fmt.Fprintln(env.stderr, "integrity error:", err)
+					problems = append(problems, err)
+				}
+				if len(problems) > 0 {
+					return fmt.Errorf("database contains %d integrity error(s)", len(problems))
+				}
+			}
+			for _, name := range names {
+				err := tx.GetShelfReader(name).Iterate(func(_ stoabs.Key, _ []byte) error {
+					entries++
+					return nil
+				}, stoabs.BytesKey{})
+				if err != nil {
+					return fmt.Errorf("unable to read shelf %s: %w", name, err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(env.stdout, "verified %d entries in %d shelves\n", entries, len(names))
+		return nil
+	}
+}
+
+// compact rewrites the BBolt database to a new file and replaces the original with it.
+// It returns the file sizes before and after compaction.
+func compact(filePath string) (int64, int64, error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return 0, 0, err
+	}
+	src, err := bboltdb.Open(filePath, info.Mode(), &bboltdb.Options{ReadOnly: true, Timeout: fileLockTimeout})
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to open %s: %w", filePath, err)
+	}
+	defer src.Close()
+	tempPath := filePath + ".compact"
+	// a file left behind by an interrupted compaction would be merged into the result
+	if err := os.Remove(tempPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return 0, 0, fmt.Errorf("unable to remove %s: %w", tempPath, err)
+	}
+	dst, err := bboltdb.Open(tempPath, info.Mode(), &bboltdb.Options{Timeout: fileLockTimeout})
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to create %s: %w", tempPath, err)
+	}
+	err = bboltdb.Compact(dst, src, compactTxMaxSize)
+	if err == nil {
+		err = bbolt.RecordCompaction(dst, time.Now())
+	}
+	if err == nil {
+		err = dst.Close()
+	} else {
+		_ = dst.Close()
+	}
+	if err != nil {
+		_ = os.Remove(tempPath)
+		return 0, 0, fmt.Errorf("unable to compact %s: %w", filePath, err)
+	}
+	compacted, err := os.Stat(tempPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := os.Rename(tempPath, filePath); err != nil {
+		return 0, 0, err
+	}
+	return info.Size(), compacted.Size(), nil
+}
+
+func hexFlag(flags *flag.FlagSet) *bool {
+	return flags.Bool("hex", false, "specifies the key is hex encoded")
+}
+
+func reservedFlag(flags *flag.FlagSet) *bool {
+	return flags.Bool("reserved", false, "includes the reserved shelves used internally by stoabs (prefixed with "+stoabs.ReservedShelfPrefix+")")
+}
+
+func parseKey(value string, hexEncoded bool) (stoabs.Key, error) {
+	if !hexEncoded {
+		return stoabs.BytesKey(value), nil
+	}
+	key, err := hex.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex key: %w", err)
+	}
+	return stoabs.BytesKey(key), nil
+}
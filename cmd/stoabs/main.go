@@ -0,0 +1,208 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Command stoabs inspects and maintains stores of any supported backend.
+//
+// Usage:
+//
+//	stoabs <command> [flags] <store URI> [arguments]
+//
+// Run stoabs without arguments for a list of commands, see open for the supported store URIs.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/sirupsen/logrus"
+)
+
+// errUsage signals the command was invoked incorrectly. The usage has already been printed.
+var errUsage = errors.New("invalid usage")
+
+// env holds the input and output of a command.
+type env struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+type command struct {
+	usage       string
+	description string
+	// minArgs is the minimum number of positional arguments, including the store URI.
+	minArgs int
+	// maxArgs is the maximum number of positional arguments, -1 for no maximum.
+	maxArgs int
+	// skipOpen specifies the command operates on the URI itself, so the store is not opened.
+	skipOpen bool
+	// create specifies the store is created if it doesn't exist, otherwise the command fails.
+	create bool
+	// setup registers the flags of the command and returns the action to execute.
+	setup func(flags *flag.FlagSet) action
+}
+
+// action executes a command on the store. args excludes the store URI. store is nil if command.skipOpen is set.
+type action func(ctx context.Context, env env, store stoabs.KVStore, uri string, args []string) error
+
+var commands = map[string]command{
+	"list": {
+		usage:       "list [-reserved] <uri> [shelf]",
+		description: "lists the shelves of the store, or the keys of the given shelf",
+		minArgs:     1, maxArgs: 2,
+		setup: listCommand,
+	},
+	"get": {
+		usage:       "get [-hex] <uri> <shelf> <key>",
+		description: "writes the value of a key to stdout",
+		minArgs:     3, maxArgs: 3,
+		setup: getCommand,
+	},
+	"put": {
+		usage:       "put [-hex] <uri> <shelf> <key> [value]",
+		description: "sets the value of a key, reading it from stdin if not given",
+		minArgs:     3, maxArgs: 4,
+		create: true,
+		setup:  putCommand,
+	},
+	"delete": {
+		usage:       "delete [-hex] <uri> <shelf> <key>",
+		description: "deletes a key",
+		minArgs:     3, maxArgs: 3,
+		setup: deleteCommand,
+	},
+	"diff": {
+		usage:       "diff [-repair] <uri> <other uri> [shelf...]",
+		description: "compares the given shelves (or all shelves) of two stores, exiting with an error if they differ",
+		minArgs:     2, maxArgs: -1,
+		setup: diffCommand,
+	},
+	"export": {
+		usage:       "export [-binary] [-reserved] [-o file] <uri> [shelf...]",
+		description: "exports the given shelves (or all shelves) to stdout or a file",
+		minArgs:     1, maxArgs: -1,
+		setup: exportCommand,
+	},
+	"import": {
+		usage:       "import [-binary] [-i file] <uri>",
+		description: "imports an export from stdin or a file",
+		minArgs:     1, maxArgs: 1,
+		create: true,
+		setup:  importCommand,
+	},
+	"compact": {
+		usage:       "compact <uri>",
+		description: "rewrites a BBolt database to reclaim free space, the database must not be in use",
+		minArgs:     1, maxArgs: 1,
+		skipOpen: true,
+		setup:    compactCommand,
+	},
+	"verify": {
+		usage:       "verify <uri>",
+		description: "reads all entries of the store and checks the integrity of BBolt databases",
+		minArgs:     1, maxArgs: 1,
+		setup: verifyCommand,
+	},
+}
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	err := run(ctx, os.Args[1:], env{stdin: os.Stdin, stdout: os.Stdout, stderr: os.Stderr})
+	cancel()
+	if err != nil {
+		if !errors.Is(err, errUsage) {
+			_, _ = fmt.Fprintln(os.Stderr, "error:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, env env) error {
+	if len(args) == 0 {
+		printUsage(env.stderr)
+		return errUsage
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		_, _ = fmt.Fprintf(env.stderr, "unknown command: %s\n", args[0])
+		printUsage(env.stderr)
+		return errUsage
+	}
+	flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	flags.SetOutput(env.stderr)
+	flags.Usage = func() {
+		_, _ = fmt.Fprintf(env.stderr, "usage: stoabs %s\n\n%s\n", cmd.usage, cmd.description)
+		flags.PrintDefaults()
+	}
+	debug := flags.Bool("debug", false, "enables debug logging")
+	fn := cmd.setup(flags)
+	if err := flags.Parse(args[1:]); err != nil {
+		return errUsage
+	}
+	positional := flags.Args()
+	if len(positional) < cmd.minArgs || (cmd.maxArgs >= 0 && len(positional) > cmd.maxArgs) {
+		flags.Usage()
+		return errUsage
+	}
+
+	log := logrus.New()
+	log.SetOutput(env.stderr)
+	log.SetLevel(logrus.WarnLevel)
+	if *debug {
+		log.SetLevel(logrus.DebugLevel)
+	}
+	uri := positional[0]
+	if cmd.skipOpen {
+		return fn(ctx, env, nil, uri, positional[1:])
+	}
+	store, closeConn, err := open(uri, cmd.create, stoabs.WithLogger(log))
+	if err != nil {
+		return fmt.Errorf("unable to open store: %w", err)
+	}
+	err = fn(ctx, env, store, uri, positional[1:])
+	if closeErr := store.Close(context.Background()); closeErr != nil && err == nil {
+		err = fmt.Errorf("unable to close store: %w", closeErr)
+	}
+	if closeErr := closeConn(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func printUsage(w io.Writer) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteString("usage: stoabs <command> [flags] <uri> [arguments]\n\ncommands:\n")
+	for _, name := range names {
+		_, _ = fmt.Fprintf(&b, "  %-8s %s\n", name, commands[name].description)
+	}
+	b.WriteString("\nstore URIs: bbolt:<file>, badger:<dir>, redis://<host>:<port>/<db>?prefix=<prefix>, grpc://<host>:<port>\n")
+	_, _ = io.WriteString(w, b.String())
+}
@@ -0,0 +1,218 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/bbolt"
+	"github.com/nuts-foundation/go-stoabs/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+func TestRun(t *testing.T) {
+	directory := util.TestDirectory(t)
+	uri := "bbolt:" + path.Join(directory, "bbolt.db")
+
+	t.Run("put and get", func(t *testing.T) {
+		_, err := execute(t, "", "put", uri, "users", "alice", "admin")
+		require.NoError(t, err)
+		_, err = execute(t, "operator", "put", uri, "users", "bob")
+		require.NoError(t, err)
+
+		stdout, err := execute(t, "", "get", uri, "users", "alice")
+		require.NoError(t, err)
+		assert.Equal(t, "admin", stdout)
+		stdout, err = execute(t, "", "get", uri, "users", "bob")
+		require.NoError(t, err)
+		assert.Equal(t, "operator", stdout)
+	})
+	t.Run("hex keys", func(t *testing.T) {
+		_, err := execute(t, "", "put", "-hex", uri, "binary", "ff00", "value")
+		require.NoError(t, err)
+
+		stdout, err := execute(t, "", "get", "-hex", uri, "binary", "ff00")
+		require.NoError(t, err)
+		assert.Equal(t, "value", stdout)
+		stdout, err = execute(t, "", "list", uri, "binary")
+		require.NoError(t, err)
+		assert.Contains(t, stdout, "0xff00")
+	})
+	t.Run("list", func(t *testing.T) {
+		stdout, err := execute(t, "", "list", uri)
+		require.NoError(t, err)
+		lines := strings.Split(strings.TrimSpace(stdout), "\n")
+		require.Len(t, lines, 3)
+		assert.True(t, strings.HasPrefix(lines[1], "binary"))
+		assert.True(t, strings.HasPrefix(lines[2], "users"))
+
+		stdout, err = execute(t, "", "list", uri, "users")
+		require.NoError(t, err)
+		assert.Contains(t, stdout, "alice")
+		assert.Contains(t, stdout, "bob")
+	})
+	t.Run("delete", func(t *testing.T) {
+		_, err := execute(t, "", "delete", uri, "users", "bob")
+		require.NoError(t, err)
+
+		_, err = execute(t, "", "get", uri, "users", "bob")
+		assert.ErrorIs(t, err, stoabs.ErrKeyNotFound)
+	})
+	t.Run("export and import", func(t *testing.T) {
+		for _, format := range [][]string{nil, {"-binary"}} {
+			exportFile := path.Join(directory, "export")
+			target := "bbolt:" + path.Join(util.TestDirectory(t), "target.db")
+
+			exportArgs := append(append([]string{"export"}, format...), "-o", exportFile, uri, "users")
+			_, err := execute(t, "", exportArgs...)
+			require.NoError(t, err)
+			importArgs := append(append([]string{"import"}, format...), "-i", exportFile, target)
+			_, err = execute(t, "", importArgs...)
+			require.NoError(t, err)
+
+			stdout, err := execute(t, "", "get", target, "users", "alice")
+			require.NoError(t, err)
+			assert.Equal(t, "admin", stdout)
+			_, err = execute(t, "", "get", "-hex", target, "binary", "ff00")
+			assert.ErrorIs(t, err, stoabs.ErrKeyNotFound)
+		}
+	})
+	t.Run("export to stdout, import from stdin", func(t *testing.T) {
+		target := "bbolt:" + path.Join(util.TestDirectory(t), "target.db")
+
+		export, err := execute(t, "", "export", uri)
+		require.NoError(t, err)
+		_, err = execute(t, export, "import", target)
+		require.NoError(t, err)
+
+		stdout, err := execute(t, "", "get", "-hex", target, "binary", "ff00")
+		require.NoError(t, err)
+		assert.Equal(t, "value", stdout)
+	})
+	t.Run("verify", func(t *testing.T) {
+		stdout, err := execute(t, "", "verify", uri)
+
+		require.NoError(t, err)
+		assert.Equal(t, "verified 2 entries in 2 shelves\n", stdout)
+	})
+	t.Run("diff", func(t *testing.T) {
+		target := "bbolt:" + path.Join(util.TestDirectory(t), "target.db")
+		_, err := execute(t, "", "put", target, "users", "carol", "guest")
+		require.NoError(t, err)
+
+		stdout, err := execute(t, "", "diff", uri, target)
+		assert.EqualError(t, err, "stores differ")
+		assert.Contains(t, stdout, "missing  users   alice")
+		assert.Contains(t, stdout, "extra    users   carol")
+		assert.Contains(t, stdout, "compared 2 entries: 2 missing, 1 extra, 0 changed")
+
+		stdout, err = execute(t, "", "diff", "-repair", uri, target, "users")
+		require.NoError(t, err)
+		assert.Contains(t, stdout, "repaired 2 entries")
+		stdout, err = execute(t, "", "diff", uri, target, "users")
+		require.NoError(t, err)
+		assert.Equal(t, "compared 1 entries: 0 missing, 0 extra, 0 changed\n", stdout)
+	})
+	t.Run("compact", func(t *testing.T) {
+		// left behind by an interrupted compaction
+		require.NoError(t, os.WriteFile(path.Join(directory, "bbolt.db.compact"), []byte("stale"), 0600))
+
+		stdout, err := execute(t, "", "compact", uri)
+		require.NoError(t, err)
+		assert.Contains(t, stdout, "compacted")
+
+		stdout, err = execute(t, "", "get", uri, "users", "alice")
+		require.NoError(t, err)
+		assert.Equal(t, "admin", stdout)
+		_, err = os.Stat(path.Join(directory, "bbolt.db.compact"))
+		assert.True(t, os.IsNotExist(err))
+		store, err := bbolt.CreateBBoltStore(path.Join(directory, "bbolt.db"))
+		require.NoError(t, err)
+		defer store.Close(ctx)
+		stats, err := stoabs.Stats(ctx, store)
+		require.NoError(t, err)
+		assert.WithinDuration(t, time.Now(), stats.LastCompaction, time.Minute)
+	})
+	t.Run("store that does not exist is not created", func(t *testing.T) {
+		missing := path.Join(directory, "missing.db")
+
+		_, err := execute(t, "", "list", "bbolt:"+missing)
+
+		assert.ErrorContains(t, err, "store does not exist")
+		_, err = os.Stat(missing)
+		assert.True(t, os.IsNotExist(err))
+	})
+	t.Run("compact is only supported for bbolt", func(t *testing.T) {
+		_, err := execute(t, "", "compact", "badger:"+path.Join(directory, "badger"))
+
+		assert.ErrorIs(t, err, errors.ErrUnsupported)
+	})
+}
+
+func TestRun_usage(t *testing.T) {
+	t.Run("no command", func(t *testing.T) {
+		var stderr bytes.Buffer
+
+		err := run(ctx, nil, env{stderr: &stderr})
+
+		assert.ErrorIs(t, err, errUsage)
+		assert.Contains(t, stderr.String(), "commands:")
+	})
+	t.Run("unknown command", func(t *testing.T) {
+		var stderr bytes.Buffer
+
+		err := run(ctx, []string{"foo"}, env{stderr: &stderr})
+
+		assert.ErrorIs(t, err, errUsage)
+		assert.Contains(t, stderr.String(), "unknown command: foo")
+	})
+	t.Run("missing arguments", func(t *testing.T) {
+		var stderr bytes.Buffer
+
+		err := run(ctx, []string{"get", "bbolt:test.db"}, env{stderr: &stderr})
+
+		assert.ErrorIs(t, err, errUsage)
+		assert.Contains(t, stderr.String(), "usage: stoabs get")
+	})
+	t.Run("invalid store URI", func(t *testing.T) {
+		_, err := execute(t, "", "list", "foo:bar")
+
+		assert.EqualError(t, err, `unable to open store: unsupported store URI scheme: "foo"`)
+	})
+}
+
+func execute(t *testing.T, stdin string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	err := run(ctx, args, env{stdin: strings.NewReader(stdin), stdout: &stdout, stderr: &stderr})
+	if stderr.Len() > 0 {
+		t.Log(stderr.String())
+	}
+	return stdout.String(), err
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpen(t *testing.T) {
+	t.Run("bbolt", func(t *testing.T) {
+		store, closer, err := open("bbolt:"+path.Join(util.TestDirectory(t), "bbolt.db"), true)
+		require.NoError(t, err)
+		defer closer()
+		defer store.Close(ctx)
+
+		assert.NoError(t, store.WriteShelf(ctx, "test", func(writer stoabs.Writer) error {
+			return writer.Put(stoabs.BytesKey("key"), []byte("value"))
+		}))
+	})
+	t.Run("bbolt file does not exist", func(t *testing.T) {
+		filePath := path.Join(util.TestDirectory(t), "bbolt.db")
+
+		_, _, err := open("bbolt:"+filePath, false)
+
+		assert.EqualError(t, err, "store does not exist: "+filePath)
+		_, err = os.Stat(filePath)
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+	})
+	t.Run("redis", func(t *testing.T) {
+		redis := miniredis.RunT(t)
+
+		store, closer, err := open("redis://"+redis.Addr()+"/0?prefix=app", false)
+		require.NoError(t, err)
+		defer closer()
+		defer store.Close(ctx)
+
+		require.NoError(t, store.WriteShelf(ctx, "test", func(writer stoabs.Writer) error {
+			return writer.Put(stoabs.BytesKey("key"), []byte("value"))
+		}))
+		assert.NotEmpty(t, redis.Keys())
+		assert.Contains(t, redis.Keys()[0], "app")
+	})
+	t.Run("grpc without host", func(t *testing.T) {
+		_, _, err := open("grpc:foo", false)
+
+		assert.EqualError(t, err, "invalid gRPC URI: missing host")
+	})
+	t.Run("unsupported scheme", func(t *testing.T) {
+		_, _, err := open("mysql://localhost", false)
+
+		assert.EqualError(t, err, `unsupported store URI scheme: "mysql"`)
+	})
+}
+
+func TestFilePath(t *testing.T) {
+	testCases := map[string]string{
+		"bbolt:data/file.db":         "data/file.db",
+		"bbolt:///var/data/file.db":  "/var/data/file.db",
+		"bbolt://data/file.db":       "data/file.db",
+		"badger:/var/data/directory": "/var/data/directory",
+	}
+	for uri, expected := range testCases {
+		t.Run(uri, func(t *testing.T) {
+			parsed, _ := url.Parse(uri)
+
+			actual, err := filePath(parsed)
+
+			require.NoError(t, err)
+			assert.Equal(t, expected, actual)
+		})
+	}
+	t.Run("missing path", func(t *testing.T) {
+		parsed, _ := url.Parse("bbolt:")
+
+		_, err := filePath(parsed)
+
+		assert.EqualError(t, err, "invalid bbolt URI: missing path")
+	})
+}
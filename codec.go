@@ -0,0 +1,141 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Codec converts values of type T to bytes and back.
+type Codec[T any] interface {
+	Marshal(value T) ([]byte, error)
+	Unmarshal(data []byte, value *T) error
+}
+
+// JSONCodec is a Codec that encodes values as JSON.
+type JSONCodec[T any] struct{}
+
+func (JSONCodec[T]) Marshal(value T) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (JSONCodec[T]) Unmarshal(data []byte, value *T) error {
+	return json.Unmarshal(data, value)
+}
+
+// CBORCodec is a Codec that encodes values as CBOR (RFC 8949).
+type CBORCodec[T any] struct{}
+
+func (CBORCodec[T]) Marshal(value T) ([]byte, error) {
+	return cbor.Marshal(value)
+}
+
+func (CBORCodec[T]) Unmarshal(data []byte, value *T) error {
+	return cbor.Unmarshal(data, value)
+}
+
+// ErrReadOnlyShelf is returned when writing to a CodecShelf that was created from a Reader that isn't a Writer.
+var ErrReadOnlyShelf = errors.New("shelf is read-only")
+
+// CodecShelf reads and writes values of type T from/to a shelf, using a Codec to convert them to and from bytes.
+// Use JSONShelf, CBORShelf or NewCodecShelf to create one.
+type CodecShelf[T any] struct {
+	reader Reader
+	codec  Codec[T]
+}
+
+// NewCodecShelf wraps the given shelf reader with the given codec. If reader is a Writer, values can also be written.
+func NewCodecShelf[T any](reader Reader, codec Codec[T]) *CodecShelf[T] {
+	return &CodecShelf[T]{reader: reader, codec: codec}
+}
+
+// JSONShelf wraps the given shelf reader (or writer), storing values of type T as JSON.
+func JSONShelf[T any](reader Reader) *CodecShelf[T] {
+	return NewCodecShelf[T](reader, JSONCodec[T]{})
+}
+
+// CBORShelf wraps the given shelf reader (or writer), storing values of type T as CBOR.
+func CBORShelf[T any](reader Reader) *CodecShelf[T] {
+	return NewCodecShelf[T](reader, CBORCodec[T]{})
+}
+
+// Get returns the value for the given key, or ErrKeyNotFound if it does not exist.
+func (c *CodecShelf[T]) Get(key Key) (T, error) {
+	var result T
+	data, err := c.reader.Get(key)
+	if err != nil {
+		return result, err
+	}
+	return result, c.unmarshal(key, data, &result)
+}
+
+// Iterate calls the callback for every entry in the shelf, see Reader.Iterate.
+func (c *CodecShelf[T]) Iterate(callback func(key Key, value T) error, keyType Key) error {
+	return c.reader.Iterate(c.unmarshalling(callback), keyType)
+}
+
+// Range calls the callback for every entry in the given key range, see Reader.Range.
+func (c *CodecShelf[T]) Range(from Key, to Key, callback func(key Key, value T) error, stopAtNil bool) error {
+	return c.reader.Range(from, to, c.unmarshalling(callback), stopAtNil)
+}
+
+// Put stores the given value.
+// Returns ErrReadOnlyShelf if the shelf was created from a Reader that isn't a Writer.
+func (c *CodecShelf[T]) Put(key Key, value T) error {
+	writer, ok := c.reader.(Writer)
+	if !ok {
+		return ErrReadOnlyShelf
+	}
+	data, err := c.codec.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("unable to marshal value (key=%s): %w", key, err)
+	}
+	return writer.Put(key, data)
+}
+
+// Delete removes the given key.
+// Returns ErrReadOnlyShelf if the shelf was created from a Reader that isn't a Writer.
+func (c *CodecShelf[T]) Delete(key Key) error {
+	writer, ok := c.reader.(Writer)
+	if !ok {
+		return ErrReadOnlyShelf
+	}
+	return writer.Delete(key)
+}
+
+func (c *CodecShelf[T]) unmarshalling(callback func(key Key, value T) error) CallerFn {
+	return func(key Key, data []byte) error {
+		var value T
+		if err := c.unmarshal(key, data, &value); err != nil {
+			return err
+		}
+		return callback(key, value)
+	}
+}
+
+func (c *CodecShelf[T]) unmarshal(key Key, data []byte, value *T) error {
+	if err := c.codec.Unmarshal(data, value); err != nil {
+		return fmt.Errorf("unable to unmarshal value (key=%s): %w", key, err)
+	}
+	return nil
+}
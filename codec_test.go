@@ -0,0 +1,123 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+type testValue struct {
+	Name  string
+	Count int
+}
+
+func TestCodecShelf(t *testing.T) {
+	key := BytesKey{1}
+	value := testValue{Name: "test", Count: 2}
+
+	t.Run("JSON", func(t *testing.T) {
+		writer := NewMockWriter(gomock.NewController(t))
+		writer.EXPECT().Put(key, []byte(`{"Name":"test","Count":2}`))
+		writer.EXPECT().Get(key).Return([]byte(`{"Name":"test","Count":2}`), nil)
+		shelf := JSONShelf[testValue](writer)
+
+		require.NoError(t, shelf.Put(key, value))
+		actual, err := shelf.Get(key)
+
+		require.NoError(t, err)
+		assert.Equal(t, value, actual)
+	})
+	t.Run("CBOR", func(t *testing.T) {
+		var stored []byte
+		writer := NewMockWriter(gomock.NewController(t))
+		writer.EXPECT().Put(key, gomock.Any()).DoAndReturn(func(_ Key, data []byte) error {
+			stored = data
+			return nil
+		})
+		shelf := CBORShelf[testValue](writer)
+
+		require.NoError(t, shelf.Put(key, value))
+		writer.EXPECT().Get(key).Return(stored, nil)
+		actual, err := shelf.Get(key)
+
+		require.NoError(t, err)
+		assert.Equal(t, value, actual)
+	})
+	t.Run("Get - not found", func(t *testing.T) {
+		reader := NewMockReader(gomock.NewController(t))
+		reader.EXPECT().Get(key).Return(nil, ErrKeyNotFound)
+
+		_, err := JSONShelf[testValue](reader).Get(key)
+
+		assert.ErrorIs(t, err, ErrKeyNotFound)
+	})
+	t.Run("Get - invalid value", func(t *testing.T) {
+		reader := NewMockReader(gomock.NewController(t))
+		reader.EXPECT().Get(key).Return([]byte("{"), nil)
+
+		_, err := JSONShelf[testValue](reader).Get(key)
+
+		assert.EqualError(t, err, "unable to unmarshal value (key=01): unexpected end of JSON input")
+	})
+	t.Run("Iterate", func(t *testing.T) {
+		reader := NewMockReader(gomock.NewController(t))
+		reader.EXPECT().Iterate(gomock.Any(), BytesKey{}).DoAndReturn(func(callback CallerFn, _ Key) error {
+			return callback(key, []byte(`{"Name":"test","Count":2}`))
+		})
+		var actual []testValue
+
+		err := JSONShelf[testValue](reader).Iterate(func(_ Key, value testValue) error {
+			actual = append(actual, value)
+			return nil
+		}, BytesKey{})
+
+		require.NoError(t, err)
+		assert.Equal(t, []testValue{value}, actual)
+	})
+	t.Run("Range", func(t *testing.T) {
+		reader := NewMockReader(gomock.NewController(t))
+		reader.EXPECT().Range(key, key.Next(), gomock.Any(), false).DoAndReturn(func(_ Key, _ Key, callback CallerFn, _ bool) error {
+			return callback(key, []byte(`invalid`))
+		})
+
+		err := JSONShelf[testValue](reader).Range(key, key.Next(), func(_ Key, _ testValue) error {
+			t.Fatal("callback should not be invoked")
+			return nil
+		}, false)
+
+		assert.ErrorContains(t, err, "unable to unmarshal value (key=01)")
+	})
+	t.Run("read-only", func(t *testing.T) {
+		shelf := JSONShelf[testValue](NewMockReader(gomock.NewController(t)))
+
+		assert.ErrorIs(t, shelf.Put(key, value), ErrReadOnlyShelf)
+		assert.ErrorIs(t, shelf.Delete(key), ErrReadOnlyShelf)
+	})
+	t.Run("Put - marshal error", func(t *testing.T) {
+		shelf := JSONShelf[func()](NewMockWriter(gomock.NewController(t)))
+
+		err := shelf.Put(key, func() {})
+
+		assert.EqualError(t, err, "unable to marshal value (key=01): json: unsupported type: func()")
+	})
+}
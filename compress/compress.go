@@ -0,0 +1,302 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package compress provides a KVStore that transparently compresses values.
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/nuts-foundation/go-stoabs"
+)
+
+var _ stoabs.KVStore = (*Store)(nil)
+var _ stoabs.ShelfLister = (*Store)(nil)
+var _ stoabs.WriteTx = (*tx)(nil)
+var _ stoabs.Writer = (*shelf)(nil)
+
+// Codec identifies a compression algorithm. It's stored as first byte of every value written through the store.
+type Codec byte
+
+const (
+	// none marks values that are stored uncompressed, because they're smaller than the threshold or don't compress well.
+	none Codec = 0
+	// Zstd compresses values using Zstandard.
+	Zstd Codec = 1
+	// Snappy compresses values using the Snappy block format.
+	Snappy Codec = 2
+	// Gzip compresses values using gzip.
+	Gzip Codec = 3
+	// zstdDictionary marks values compressed using Zstandard with the trained dictionary of their shelf (see TrainDictionary),
+	// followed by the ID of the dictionary.
+	zstdDictionary Codec = 4
+)
+
+func (c Codec) String() string {
+	switch c {
+	case none:
+		return "none"
+	case Zstd:
+		return "zstd"
+	case Snappy:
+		return "snappy"
+	case Gzip:
+		return "gzip"
+	case zstdDictionary:
+		return "zstd (dictionary)"
+	default:
+		return fmt.Sprintf("unknown (%d)", byte(c))
+	}
+}
+
+const defaultThreshold = 1024
+
+// ErrDecompressionFailed is returned when a value read from the underlying store can't be decompressed.
+var ErrDecompressionFailed = errors.New("unable to decompress value")
+
+// zstdEncoder and zstdDecoder are safe for concurrent use when using EncodeAll and DecodeAll.
+var zstdEncoder, _ = zstd.NewWriter(nil)
+var zstdDecoder, _ = zstd.NewReader(nil)
+
+// Option configures the compressing store.
+type Option func(s *Store)
+
+// WithCompression sets the codec used to compress values. It defaults to Zstd.
+// Values are always decompressed using the codec they were compressed with, so the codec can be changed at any time.
+func WithCompression(codec Codec) Option {
+	return func(s *Store) {
+		s.codec = codec
+	}
+}
+
+// WithThreshold sets the minimum size (in bytes) of values to compress. Smaller values are stored uncompressed.
+// It defaults to 1024 bytes.
+func WithThreshold(threshold int) Option {
+	return func(s *Store) {
+		s.threshold = threshold
+	}
+}
+
+// Wrap creates a store that compresses values larger than the threshold (see WithThreshold) before writing them
+// to the underlying store. Every value is prefixed with a single byte indicating the codec, meaning all values in
+// the underlying store must have been written through the compressing store.
+func Wrap(store stoabs.KVStore, opts ...Option) *Store {
+	result := &Store{
+		underlying:        store,
+		codec:             Zstd,
+		threshold:         defaultThreshold,
+		dictionaries:      map[uint32]*dictionary{},
+		shelfDictionaries: map[string]uint32{},
+	}
+	for _, opt := range opts {
+		opt(result)
+	}
+	return result
+}
+
+// Store is a KVStore that compresses values. Use Wrap to create it.
+type Store struct {
+	underlying stoabs.KVStore
+	codec      Codec
+	threshold  int
+	// mux guards dictionaries and shelfDictionaries, which cache the dictionaries read from the underlying store.
+	mux          sync.RWMutex
+	dictionaries map[uint32]*dictionary
+	// shelfDictionaries holds the ID of the dictionary used to compress the values of a shelf, 0 if it has none.
+	shelfDictionaries map[string]uint32
+}
+
+func (s *Store) Close(ctx context.Context) error {
+	return s.underlying.Close(ctx)
+}
+
+func (s *Store) Write(ctx context.Context, fn func(stoabs.WriteTx) error, opts ...stoabs.TxOption) error {
+	return s.underlying.Write(ctx, func(underlyingTx stoabs.WriteTx) error {
+		return fn(&tx{ReadTx: underlyingTx, writeTx: underlyingTx, store: s})
+	}, opts...)
+}
+
+func (s *Store) WriteShelf(ctx context.Context, shelfName string, fn func(stoabs.Writer) error) error {
+	return s.Write(ctx, func(tx stoabs.WriteTx) error {
+		return fn(tx.GetShelfWriter(shelfName))
+	})
+}
+
+func (s *Store) Read(ctx context.Context, fn func(stoabs.ReadTx) error) error {
+	return s.underlying.Read(ctx, func(underlyingTx stoabs.ReadTx) error {
+		return fn(&tx{ReadTx: underlyingTx, store: s})
+	})
+}
+
+func (s *Store) ReadShelf(ctx context.Context, shelfName string, fn func(stoabs.Reader) error) error {
+	return s.Read(ctx, func(tx stoabs.ReadTx) error {
+		return fn(tx.GetShelfReader(shelfName))
+	})
+}
+
+// ShelfNames returns the shelves of the underlying store.
+func (s *Store) ShelfNames(ctx context.Context) ([]string, error) {
+	return stoabs.ShelfNames(ctx, s.underlying)
+}
+
+// compress compresses the value using the codec of the store, and the given dictionary if Zstd is used and it's not nil.
+func (s *Store) compress(value []byte, dict *dictionary) ([]byte, error) {
+	if len(value) < s.threshold || s.codec == none {
+		return append([]byte{byte(none)}, value...), nil
+	}
+	var result []byte
+	switch {
+	case s.codec == Zstd && dict != nil:
+		result = dict.encoder.EncodeAll(value, binary.BigEndian.AppendUint32([]byte{byte(zstdDictionary)}, dict.id))
+	case s.codec == Zstd:
+		result = zstdEncoder.EncodeAll(value, []byte{byte(Zstd)})
+	case s.codec == Snappy:
+		result = append([]byte{byte(Snappy)}, s2.EncodeSnappy(nil, value)...)
+	case s.codec == Gzip:
+		buf := bytes.NewBuffer([]byte{byte(Gzip)})
+		writer := gzip.NewWriter(buf)
+		_, _ = writer.Write(value)
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		result = buf.Bytes()
+	default:
+		return nil, fmt.Errorf("unsupported codec: %s", s.codec)
+	}
+	if len(result) > len(value) {
+		// doesn't compress well, store as-is
+		return append([]byte{byte(none)}, value...), nil
+	}
+	return result, nil
+}
+
+// decompress decompresses the value, reading the dictionary it was compressed with from the given transaction if needed.
+func (s *Store) decompress(tx stoabs.ReadTx, value []byte) ([]byte, error) {
+	if len(value) == 0 {
+		return nil, fmt.Errorf("%w: missing header", ErrDecompressionFailed)
+	}
+	codec, data := Codec(value[0]), value[1:]
+	var result []byte
+	var err error
+	switch codec {
+	case none:
+		return data, nil
+	case Zstd:
+		result, err = zstdDecoder.DecodeAll(data, nil)
+	case zstdDictionary:
+		if len(data) < 4 {
+			return nil, fmt.Errorf("%w: missing dictionary ID", ErrDecompressionFailed)
+		}
+		var dict *dictionary
+		if dict, err = s.dictionary(tx, binary.BigEndian.Uint32(data)); err == nil {
+			result, err = dict.decoder.DecodeAll(data[4:], nil)
+		}
+	case Snappy:
+		result, err = s2.Decode(nil, data)
+	case Gzip:
+		var reader *gzip.Reader
+		if reader, err = gzip.NewReader(bytes.NewReader(data)); err == nil {
+			result, err = io.ReadAll(reader)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported codec: %s", ErrDecompressionFailed, codec)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w (codec=%s): %w", ErrDecompressionFailed, codec, err)
+	}
+	return result, nil
+}
+
+type tx struct {
+	stoabs.ReadTx
+	writeTx stoabs.WriteTx
+	store   *Store
+}
+
+func (t *tx) GetShelfReader(shelfName string) stoabs.Reader {
+	return &shelf{Reader: t.ReadTx.GetShelfReader(shelfName), name: shelfName, tx: t.ReadTx, store: t.store}
+}
+
+func (t *tx) GetShelfWriter(shelfName string) stoabs.Writer {
+	writer := t.writeTx.GetShelfWriter(shelfName)
+	return &shelf{Reader: writer, writer: writer, name: shelfName, tx: t.ReadTx, store: t.store}
+}
+
+func (t *tx) Store() stoabs.KVStore {
+	return t.store
+}
+
+type shelf struct {
+	stoabs.Reader
+	writer stoabs.Writer
+	name   string
+	// tx is the underlying transaction, from which dictionaries are read.
+	tx    stoabs.ReadTx
+	store *Store
+}
+
+func (s *shelf) Get(key stoabs.Key) ([]byte, error) {
+	value, err := s.Reader.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.store.decompress(s.tx, value)
+}
+
+func (s *shelf) Iterate(callback stoabs.CallerFn, keyType stoabs.Key) error {
+	return s.Reader.Iterate(s.decompressingCallback(callback), keyType)
+}
+
+func (s *shelf) Range(from stoabs.Key, to stoabs.Key, callback stoabs.CallerFn, stopAtNil bool) error {
+	return s.Reader.Range(from, to, s.decompressingCallback(callback), stopAtNil)
+}
+
+func (s *shelf) decompressingCallback(callback stoabs.CallerFn) stoabs.CallerFn {
+	return func(key stoabs.Key, value []byte) error {
+		decompressed, err := s.store.decompress(s.tx, value)
+		if err != nil {
+			return err
+		}
+		return callback(key, decompressed)
+	}
+}
+
+func (s *shelf) Put(key stoabs.Key, value []byte) error {
+	dict, err := s.store.shelfDictionary(s.tx, s.name)
+	if err != nil {
+		return err
+	}
+	compressed, err := s.store.compress(value, dict)
+	if err != nil {
+		return err
+	}
+	return s.writer.Put(key, compressed)
+}
+
+func (s *shelf) Delete(key stoabs.Key) error {
+	return s.writer.Delete(key)
+}
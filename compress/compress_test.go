@@ -0,0 +1,138 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package compress
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+var key = stoabs.BytesKey("key")
+
+const shelfName = "test"
+
+var largeValue = []byte(strings.Repeat(`{"name":"value"},`, 1000))
+
+func TestCompress(t *testing.T) {
+	provider := func(t *testing.T) (stoabs.KVStore, error) {
+		// compress all values to exercise decompression in every operation
+		return Wrap(stores.BBolt(t), WithThreshold(0)), nil
+	}
+
+	kvtests.TestReadingAndWriting(t, provider)
+	kvtests.TestRange(t, provider)
+	kvtests.TestIterate(t, provider)
+	kvtests.TestEmpty(t, provider)
+	kvtests.TestDelete(t, provider)
+	kvtests.TestWriteTransactions(t, provider)
+	kvtests.TestShelfNames(t, provider)
+}
+
+func TestStore_Put(t *testing.T) {
+	for _, codec := range []Codec{Zstd, Snappy, Gzip} {
+		t.Run(codec.String(), func(t *testing.T) {
+			underlying := stores.BBolt(t)
+			store := Wrap(underlying, WithCompression(codec))
+
+			require.NoError(t, put(store, key, largeValue))
+
+			raw := get(t, underlying, key)
+			assert.Equal(t, byte(codec), raw[0])
+			assert.Less(t, len(raw), len(largeValue)/10)
+			assert.Equal(t, largeValue, get(t, store, key))
+		})
+	}
+	t.Run("below threshold", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		store := Wrap(underlying)
+
+		require.NoError(t, put(store, key, []byte("small")))
+
+		assert.Equal(t, []byte("\x00small"), get(t, underlying, key))
+		assert.Equal(t, []byte("small"), get(t, store, key))
+	})
+	t.Run("incompressible", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		store := Wrap(underlying, WithThreshold(0))
+
+		require.NoError(t, put(store, key, []byte{1}))
+
+		assert.Equal(t, []byte{0, 1}, get(t, underlying, key))
+	})
+	t.Run("codec can be changed", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		require.NoError(t, put(Wrap(underlying, WithCompression(Gzip)), key, largeValue))
+
+		assert.Equal(t, largeValue, get(t, Wrap(underlying, WithCompression(Snappy)), key))
+	})
+}
+
+func TestStore_Get(t *testing.T) {
+	t.Run("unsupported codec", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		require.NoError(t, put(underlying, key, []byte{100, 1}))
+
+		_, err := getErr(Wrap(underlying), key)
+
+		assert.ErrorIs(t, err, ErrDecompressionFailed)
+		assert.EqualError(t, err, "unable to decompress value: unsupported codec: unknown (100)")
+	})
+	t.Run("corrupt value", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		require.NoError(t, put(underlying, key, []byte{byte(Zstd), 1, 2, 3}))
+
+		_, err := getErr(Wrap(underlying), key)
+
+		assert.ErrorIs(t, err, ErrDecompressionFailed)
+	})
+}
+
+func put(store stoabs.KVStore, key stoabs.Key, value []byte) error {
+	return store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+		return writer.Put(key, value)
+	})
+}
+
+func getErr(store stoabs.KVStore, key stoabs.Key) ([]byte, error) {
+	var result []byte
+	err := store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+		var err error
+		result, err = reader.Get(key)
+		return err
+	})
+	return result, err
+}
+
+func get(t *testing.T, store stoabs.KVStore, key stoabs.Key) []byte {
+	result, err := getErr(store, key)
+	if !errors.Is(err, stoabs.ErrKeyNotFound) {
+		require.NoError(t, err)
+	}
+	return result
+}
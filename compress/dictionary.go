@@ -0,0 +1,209 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package compress
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+	"github.com/nuts-foundation/go-stoabs"
+)
+
+// dictionaryShelf is the shelf of the underlying store that holds the trained dictionaries, keyed by their ID.
+const dictionaryShelf = stoabs.ReservedShelfPrefix + "compress/dictionaries"
+
+// shelfDictionaryShelf is the shelf of the underlying store that holds the ID of the dictionary of a shelf, keyed by shelf name.
+const shelfDictionaryShelf = stoabs.ReservedShelfPrefix + "compress/shelves"
+
+// maxDictionarySize is the maximum size of a trained dictionary.
+const maxDictionarySize = 64 * 1024
+
+// firstDictionaryID is the ID of the first trained dictionary, since Zstandard reserves lower IDs.
+const firstDictionaryID = 32768
+
+// dictionary is a trained Zstandard dictionary, see Store.TrainDictionary.
+type dictionary struct {
+	id uint32
+	// encoder and decoder are safe for concurrent use when using EncodeAll and DecodeAll.
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+func newDictionary(data []byte) (*dictionary, error) {
+	info, err := zstd.InspectDictionary(data)
+	if err != nil {
+		return nil, err
+	}
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderDict(data))
+	if err != nil {
+		return nil, err
+	}
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderDicts(data))
+	if err != nil {
+		return nil, err
+	}
+	return &dictionary{id: info.ID(), encoder: encoder, decoder: decoder}, nil
+}
+
+// TrainDictionary trains a Zstandard dictionary using a random sample of (at most) the given number of values of the shelf.
+// Values written to the shelf afterwards are compressed with it, which improves the compression ratio of many small,
+// similar values (e.g. JSON documents) dramatically; consider lowering the threshold (see WithThreshold) for them.
+// Existing values keep the compression they were written with until they're written again.
+// Dictionaries are only used with the Zstd codec. They're stored in the underlying store, where they're kept after
+// training a new dictionary for the shelf, since values compressed with them refer to them. Other stores wrapping the same
+// underlying store (e.g. in other processes) use a dictionary trained after they started writing to the shelf once they're recreated.
+func (s *Store) TrainDictionary(ctx context.Context, shelfName string, samples int) error {
+	if samples <= 0 {
+		return errors.New("number of samples must be greater than 0")
+	}
+	// reservoir sampling, so the sample represents the whole shelf
+	var sample [][]byte
+	seen := 0
+	err := s.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+		return reader.Iterate(func(_ stoabs.Key, value []byte) error {
+			seen++
+			if len(sample) < samples {
+				sample = append(sample, bytes.Clone(value))
+			} else if i := rand.IntN(seen); i < samples {
+				sample[i] = bytes.Clone(value)
+			}
+			return nil
+		}, stoabs.BytesKey{})
+	})
+	if err != nil {
+		return err
+	}
+	if len(sample) == 0 {
+		return fmt.Errorf("unable to train dictionary: shelf %s has no values", shelfName)
+	}
+	// The dictionary is trained in the transaction storing it, so its ID is allocated sequentially without colliding
+	// with the ID of another dictionary: values compressed with that dictionary would become unreadable.
+	var trained *dictionary
+	err = s.underlying.Write(ctx, func(tx stoabs.WriteTx) error {
+		writer := tx.GetShelfWriter(dictionaryShelf)
+		nextID, err := nextDictionaryID(writer)
+		if err != nil {
+			return err
+		}
+		data, err := dict.BuildZstdDict(sample, dict.Options{MaxDictSize: maxDictionarySize, HashBytes: 6, ZstdDictID: nextID})
+		if err != nil {
+			return fmt.Errorf("unable to train dictionary: %w", err)
+		}
+		if trained, err = newDictionary(data); err != nil {
+			return fmt.Errorf("unable to train dictionary: %w", err)
+		}
+		id := binary.BigEndian.AppendUint32(nil, trained.id)
+		if err := writer.Put(stoabs.BytesKey(id), data); err != nil {
+			return fmt.Errorf("unable to store dictionary: %w", err)
+		}
+		if err := tx.GetShelfWriter(shelfDictionaryShelf).Put(stoabs.BytesKey(shelfName), id); err != nil {
+			return fmt.Errorf("unable to store dictionary: %w", err)
+		}
+		return nil
+	}, stoabs.WithShelfLock(dictionaryShelf))
+	if err != nil {
+		return err
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.dictionaries[trained.id] = trained
+	s.shelfDictionaries[shelfName] = trained.id
+	return nil
+}
+
+// nextDictionaryID returns the ID for a new dictionary: the ID following the highest ID of the stored dictionaries.
+func nextDictionaryID(reader stoabs.Reader) (uint32, error) {
+	result := uint32(firstDictionaryID)
+	err := reader.Iterate(func(key stoabs.Key, _ []byte) error {
+		if len(key.Bytes()) != 4 {
+			return fmt.Errorf("invalid dictionary ID: %s", key)
+		}
+		id := binary.BigEndian.Uint32(key.Bytes())
+		if id == math.MaxUint32 {
+			return errors.New("no dictionary IDs left")
+		}
+		result = max(result, id+1)
+		return nil
+	}, stoabs.BytesKey{})
+	if err != nil {
+		return 0, fmt.Errorf("unable to allocate dictionary ID: %w", err)
+	}
+	return result, nil
+}
+
+// shelfDictionary returns the dictionary to compress the values of the given shelf with, or nil if it has none
+// (or the codec isn't Zstd). It's read from the given transaction once, after which it's cached.
+func (s *Store) shelfDictionary(tx stoabs.ReadTx, shelfName string) (*dictionary, error) {
+	if s.codec != Zstd {
+		return nil, nil
+	}
+	s.mux.RLock()
+	id, ok := s.shelfDictionaries[shelfName]
+	s.mux.RUnlock()
+	if !ok {
+		value, err := tx.GetShelfReader(shelfDictionaryShelf).Get(stoabs.BytesKey(shelfName))
+		switch {
+		case errors.Is(err, stoabs.ErrKeyNotFound):
+		case err != nil:
+			return nil, fmt.Errorf("unable to read dictionary of shelf %s: %w", shelfName, err)
+		case len(value) != 4:
+			return nil, fmt.Errorf("invalid dictionary ID of shelf %s", shelfName)
+		default:
+			id = binary.BigEndian.Uint32(value)
+		}
+		s.mux.Lock()
+		s.shelfDictionaries[shelfName] = id
+		s.mux.Unlock()
+	}
+	if id == 0 {
+		return nil, nil
+	}
+	return s.dictionary(tx, id)
+}
+
+// dictionary returns the dictionary with the given ID, reading it from the given transaction if it isn't cached yet.
+func (s *Store) dictionary(tx stoabs.ReadTx, id uint32) (*dictionary, error) {
+	s.mux.RLock()
+	result, ok := s.dictionaries[id]
+	s.mux.RUnlock()
+	if ok {
+		return result, nil
+	}
+	data, err := tx.GetShelfReader(dictionaryShelf).Get(stoabs.BytesKey(binary.BigEndian.AppendUint32(nil, id)))
+	if errors.Is(err, stoabs.ErrKeyNotFound) {
+		return nil, fmt.Errorf("dictionary %d not found", id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read dictionary %d: %w", id, err)
+	}
+	if result, err = newDictionary(data); err != nil {
+		return nil, fmt.Errorf("invalid dictionary %d: %w", id, err)
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.dictionaries[id] = result
+	return result, nil
+}
@@ -0,0 +1,162 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package compress
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_TrainDictionary(t *testing.T) {
+	const count = 500
+	// document returns a small JSON document, similar to the other documents
+	document := func(i int) []byte {
+		return []byte(fmt.Sprintf(`{"id":"urn:uuid:%08d","type":"VerifiableCredential","issuer":"did:web:example.com","subject":{"name":"subject %d"}}`, i, i))
+	}
+	// writeDocuments writes the documents and returns the size of the values in the underlying store
+	writeDocuments := func(t *testing.T, store *Store, underlying stoabs.KVStore) int {
+		require.NoError(t, store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			for i := 0; i < count; i++ {
+				if err := writer.Put(stoabs.Uint32Key(i), document(i)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+		size := 0
+		require.NoError(t, underlying.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			return reader.Iterate(func(_ stoabs.Key, value []byte) error {
+				size += len(value)
+				return nil
+			}, stoabs.Uint32Key(0))
+		}))
+		return size
+	}
+	assertDocuments := func(t *testing.T, store stoabs.KVStore) {
+		for _, i := range []int{0, count / 2, count - 1} {
+			assert.Equal(t, document(i), get(t, store, stoabs.Uint32Key(i)))
+		}
+	}
+
+	t.Run("improves compression of small values", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		store := Wrap(underlying, WithThreshold(0))
+		withoutDictionary := writeDocuments(t, store, underlying)
+
+		require.NoError(t, store.TrainDictionary(ctx, shelfName, 100))
+		withDictionary := writeDocuments(t, store, underlying)
+
+		assert.Less(t, withDictionary, withoutDictionary/2)
+		assert.Equal(t, byte(zstdDictionary), get(t, underlying, stoabs.Uint32Key(0))[0])
+		assertDocuments(t, store)
+	})
+	t.Run("dictionary is read from the underlying store", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		store := Wrap(underlying, WithThreshold(0))
+		writeDocuments(t, store, underlying)
+		require.NoError(t, store.TrainDictionary(ctx, shelfName, 100))
+		writeDocuments(t, store, underlying)
+
+		other := Wrap(underlying, WithThreshold(0))
+		assertDocuments(t, other)
+		require.NoError(t, put(other, key, document(count)))
+
+		assert.Equal(t, byte(zstdDictionary), get(t, underlying, key)[0])
+		assert.Equal(t, document(count), get(t, store, key))
+	})
+	t.Run("values written before training remain readable", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		store := Wrap(underlying, WithThreshold(0))
+		writeDocuments(t, store, underlying)
+
+		require.NoError(t, store.TrainDictionary(ctx, shelfName, 100))
+
+		assert.NotEqual(t, byte(zstdDictionary), get(t, underlying, stoabs.Uint32Key(0))[0])
+		assertDocuments(t, store)
+	})
+	t.Run("values of previous dictionaries remain readable", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		store := Wrap(underlying, WithThreshold(0))
+		writeDocuments(t, store, underlying)
+		require.NoError(t, store.TrainDictionary(ctx, shelfName, 100))
+		writeDocuments(t, store, underlying)
+
+		require.NoError(t, store.TrainDictionary(ctx, shelfName, 100))
+		require.NoError(t, put(store, key, document(count)))
+
+		assertDocuments(t, Wrap(underlying))
+		assert.Equal(t, document(count), get(t, Wrap(underlying), key))
+	})
+	t.Run("dictionary IDs are allocated sequentially", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		store := Wrap(underlying, WithThreshold(0))
+		writeDocuments(t, store, underlying)
+
+		require.NoError(t, store.TrainDictionary(ctx, shelfName, 100))
+		require.NoError(t, store.TrainDictionary(ctx, shelfName, 100))
+
+		var ids []uint32
+		require.NoError(t, underlying.ReadShelf(ctx, dictionaryShelf, func(reader stoabs.Reader) error {
+			return reader.Iterate(func(key stoabs.Key, _ []byte) error {
+				ids = append(ids, binary.BigEndian.Uint32(key.Bytes()))
+				return nil
+			}, stoabs.BytesKey{})
+		}))
+		assert.Equal(t, []uint32{firstDictionaryID, firstDictionaryID + 1}, ids)
+	})
+	t.Run("not used by other codecs", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		store := Wrap(underlying, WithThreshold(0))
+		writeDocuments(t, store, underlying)
+		require.NoError(t, store.TrainDictionary(ctx, shelfName, 100))
+
+		require.NoError(t, put(Wrap(underlying, WithThreshold(0), WithCompression(Snappy)), key, largeValue))
+
+		assert.Equal(t, byte(Snappy), get(t, underlying, key)[0])
+	})
+	t.Run("empty shelf", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t))
+
+		err := store.TrainDictionary(ctx, shelfName, 100)
+
+		assert.EqualError(t, err, "unable to train dictionary: shelf test has no values")
+	})
+	t.Run("invalid number of samples", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t))
+
+		err := store.TrainDictionary(ctx, shelfName, 0)
+
+		assert.EqualError(t, err, "number of samples must be greater than 0")
+	})
+	t.Run("missing dictionary", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		require.NoError(t, put(underlying, key, []byte{byte(zstdDictionary), 0, 0, 0, 1, 2, 3}))
+
+		_, err := getErr(Wrap(underlying), key)
+
+		assert.ErrorIs(t, err, ErrDecompressionFailed)
+		assert.EqualError(t, err, "unable to decompress value (codec=zstd (dictionary)): dictionary 1 not found")
+	})
+}
@@ -0,0 +1,289 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package config builds a KVStore from a declarative configuration, which can be read from a configuration file
+// (e.g. JSON) and/or environment variables.
+package config
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/badger"
+	"github.com/nuts-foundation/go-stoabs/bbolt"
+	"github.com/nuts-foundation/go-stoabs/metrics"
+	"github.com/nuts-foundation/go-stoabs/metrics/expvars"
+	"github.com/nuts-foundation/go-stoabs/redis7"
+	"github.com/nuts-foundation/go-stoabs/remote"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Supported store types.
+const (
+	TypeBBolt  = "bbolt"
+	TypeBadger = "badger"
+	TypeRedis  = "redis"
+	TypeRemote = "remote"
+)
+
+// Config specifies which store to create and how to connect to it.
+// Durations are specified in nanoseconds in JSON, and in the format of time.ParseDuration in environment variables.
+type Config struct {
+	// Type specifies the backend: bbolt, badger, redis or remote.
+	Type   string       `json:"type" env:"TYPE"`
+	BBolt  BBoltConfig  `json:"bbolt" env:"BBOLT"`
+	Badger BadgerConfig `json:"badger" env:"BADGER"`
+	Redis  RedisConfig  `json:"redis" env:"REDIS"`
+	Remote RemoteConfig `json:"remote" env:"REMOTE"`
+	// NoSync specifies the store should not flush its data to disk, see stoabs.WithNoSync.
+	NoSync bool `json:"noSync" env:"NOSYNC"`
+	// LockAcquireTimeout overrides the default timeout for acquiring a lock, see stoabs.WithLockAcquireTimeout.
+	LockAcquireTimeout time.Duration `json:"lockAcquireTimeout" env:"LOCK_ACQUIRE_TIMEOUT"`
+	// LockLease overrides the default lease of distributed locks, see stoabs.WithLockLease.
+	LockLease time.Duration `json:"lockLease" env:"LOCK_LEASE"`
+	// KeyPrefix is prepended to all shelf names, see stoabs.WithKeyPrefix.
+	KeyPrefix string `json:"keyPrefix" env:"KEY_PREFIX"`
+	// OrderedIteration makes Iterate visit keys in their byte order, see stoabs.WithOrderedIteration.
+	OrderedIteration bool          `json:"orderedIteration" env:"ORDERED_ITERATION"`
+	Metrics          MetricsConfig `json:"metrics" env:"METRICS"`
+}
+
+// BBoltConfig specifies a BBolt store.
+type BBoltConfig struct {
+	// Path specifies the database file.
+	Path string `json:"path" env:"PATH"`
+}
+
+// BadgerConfig specifies a Badger store.
+type BadgerConfig struct {
+	// Path specifies the database directory.
+	Path string `json:"path" env:"PATH"`
+}
+
+// RedisConfig specifies a Redis store.
+type RedisConfig struct {
+	// Address specifies the host:port of the Redis server.
+	Address  string `json:"address" env:"ADDRESS"`
+	Username string `json:"username" env:"USERNAME"`
+	Password string `json:"password" env:"PASSWORD"`
+	Database int    `json:"database" env:"DATABASE"`
+	// Prefix is prepended to all keys, so multiple applications can share a database.
+	Prefix       string        `json:"prefix" env:"PREFIX"`
+	DialTimeout  time.Duration `json:"dialTimeout" env:"DIAL_TIMEOUT"`
+	ReadTimeout  time.Duration `json:"readTimeout" env:"READ_TIMEOUT"`
+	WriteTimeout time.Duration `json:"writeTimeout" env:"WRITE_TIMEOUT"`
+	TLS          TLSConfig     `json:"tls" env:"TLS"`
+}
+
+// RemoteConfig specifies a store exposed by remote.NewServer.
+type RemoteConfig struct {
+	// Address specifies the host:port of the gRPC server.
+	Address string    `json:"address" env:"ADDRESS"`
+	TLS     TLSConfig `json:"tls" env:"TLS"`
+}
+
+// TLSConfig specifies a TLS client configuration.
+type TLSConfig struct {
+	// Enabled specifies whether TLS is used. If not, the other properties are ignored.
+	Enabled bool `json:"enabled" env:"ENABLED"`
+	// CAFile specifies a PEM file with the certificates of trusted CAs. If not set, the system roots are used.
+	CAFile string `json:"caFile" env:"CA_FILE"`
+	// CertFile and KeyFile specify the PEM files of the client certificate and its private key, if required by the server.
+	CertFile           string `json:"certFile" env:"CERT_FILE"`
+	KeyFile            string `json:"keyFile" env:"KEY_FILE"`
+	ServerName         string `json:"serverName" env:"SERVER_NAME"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify" env:"INSECURE_SKIP_VERIFY"`
+}
+
+// MetricsConfig specifies the metrics of the store.
+type MetricsConfig struct {
+	// Enabled specifies whether the metrics of metrics.NewShelfCollector are registered.
+	Enabled bool `json:"enabled" env:"ENABLED"`
+	// Expvar specifies the name of the expvar variable to publish the same metrics as, without Prometheus (see expvars.Metrics.Publish).
+	// If empty, they aren't published.
+	Expvar string `json:"expvar" env:"EXPVAR"`
+	// Registerer specifies where the metrics are registered. If not set, prometheus.DefaultRegisterer is used.
+	Registerer prometheus.Registerer `json:"-" env:"-"`
+}
+
+// Open creates the store specified by the configuration. The options are applied after the options derived from the config,
+// e.g. to specify a logger. They aren't applied to remote stores, which are configured by the server.
+func Open(config Config, opts ...stoabs.Option) (stoabs.KVStore, error) {
+	store, err := open(config, append(config.options(), opts...))
+	if err != nil {
+		return nil, err
+	}
+	registerer := config.Metrics.Registerer
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+	var collector prometheus.Collector
+	if config.Metrics.Enabled {
+		collector = metrics.NewShelfCollector(store)
+		if err := registerer.Register(collector); err != nil {
+			_ = store.Close(context.Background())
+			return nil, fmt.Errorf("unable to register metrics: %w", err)
+		}
+	}
+	if config.Metrics.Expvar != "" {
+		if err := expvars.New(expvars.WithShelves(store)).Publish(config.Metrics.Expvar); err != nil {
+			if collector != nil {
+				registerer.Unregister(collector)
+			}
+			_ = store.Close(context.Background())
+			return nil, fmt.Errorf("unable to publish metrics: %w", err)
+		}
+	}
+	return store, nil
+}
+
+func open(config Config, opts []stoabs.Option) (stoabs.KVStore, error) {
+	switch config.Type {
+	case TypeBBolt:
+		if config.BBolt.Path == "" {
+			return nil, errors.New("bbolt: path is required")
+		}
+		return bbolt.CreateBBoltStore(config.BBolt.Path, opts...)
+	case TypeBadger:
+		if config.Badger.Path == "" {
+			return nil, errors.New("badger: path is required")
+		}
+		return badger.CreateBadgerStore(config.Badger.Path, opts...)
+	case TypeRedis:
+		if config.Redis.Address == "" {
+			return nil, errors.New("redis: address is required")
+		}
+		tlsConfig, err := config.Redis.TLS.load()
+		if err != nil {
+			return nil, fmt.Errorf("redis: %w", err)
+		}
+		return redis7.CreateRedisStore(config.Redis.Prefix, &redis.Options{
+			Addr:         config.Redis.Address,
+			Username:     config.Redis.Username,
+			Password:     config.Redis.Password,
+			DB:           config.Redis.Database,
+			DialTimeout:  config.Redis.DialTimeout,
+			ReadTimeout:  config.Redis.ReadTimeout,
+			WriteTimeout: config.Redis.WriteTimeout,
+			TLSConfig:    tlsConfig,
+		}, opts...)
+	case TypeRemote:
+		if config.Remote.Address == "" {
+			return nil, errors.New("remote: address is required")
+		}
+		if len(config.options()) > 0 {
+			return nil, errors.New("remote: noSync, lockAcquireTimeout, lockLease, keyPrefix and orderedIteration are configured by the server")
+		}
+		tlsConfig, err := config.Remote.TLS.load()
+		if err != nil {
+			return nil, fmt.Errorf("remote: %w", err)
+		}
+		transportCredentials := insecure.NewCredentials()
+		if tlsConfig != nil {
+			transportCredentials = credentials.NewTLS(tlsConfig)
+		}
+		conn, err := grpc.NewClient(config.Remote.Address, grpc.WithTransportCredentials(transportCredentials))
+		if err != nil {
+			return nil, fmt.Errorf("remote: %w", err)
+		}
+		return &remoteStore{Client: remote.NewClient(conn), conn: conn}, nil
+	case "":
+		return nil, errors.New("store type is required")
+	default:
+		return nil, fmt.Errorf("unsupported store type: %q", config.Type)
+	}
+}
+
+func (c Config) options() []stoabs.Option {
+	var result []stoabs.Option
+	if c.NoSync {
+		result = append(result, stoabs.WithNoSync())
+	}
+	if c.LockAcquireTimeout > 0 {
+		result = append(result, stoabs.WithLockAcquireTimeout(c.LockAcquireTimeout))
+	}
+	if c.LockLease > 0 {
+		result = append(result, stoabs.WithLockLease(c.LockLease))
+	}
+	if c.KeyPrefix != "" {
+		result = append(result, stoabs.WithKeyPrefix(c.KeyPrefix))
+	}
+	if c.OrderedIteration {
+		result = append(result, stoabs.WithOrderedIteration())
+	}
+	return result
+}
+
+// load returns the TLS configuration, or nil if TLS isn't enabled.
+func (c TLSConfig) load() (*tls.Config, error) {
+	if !c.Enabled {
+		return nil, nil
+	}
+	result := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.CAFile != "" {
+		data, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CA file: %w", err)
+		}
+		result.RootCAs = x509.NewCertPool()
+		if !result.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in CA file: %s", c.CAFile)
+		}
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate: %w", err)
+		}
+		result.Certificates = []tls.Certificate{certificate}
+	}
+	return result, nil
+}
+
+// remoteStore closes the gRPC connection when the store is closed, since it was created by Open.
+type remoteStore struct {
+	*remote.Client
+	conn      *grpc.ClientConn
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func (r *remoteStore) Close(ctx context.Context) error {
+	r.closeOnce.Do(func() {
+		_ = r.Client.Close(ctx)
+		if err := r.conn.Close(); err != nil {
+			r.closeErr = stoabs.DatabaseError(err)
+		}
+	})
+	return r.closeErr
+}
@@ -0,0 +1,221 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"net"
+	"path"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/remote"
+	"github.com/nuts-foundation/go-stoabs/remote/remotepb"
+	"github.com/nuts-foundation/go-stoabs/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+var ctx = context.Background()
+
+func TestOpen(t *testing.T) {
+	t.Run("bbolt", func(t *testing.T) {
+		store, err := Open(Config{Type: TypeBBolt, BBolt: BBoltConfig{Path: path.Join(util.TestDirectory(t), "bbolt.db")}, NoSync: true})
+		require.NoError(t, err)
+		defer store.Close(ctx)
+
+		assertWritable(t, store)
+	})
+	t.Run("badger", func(t *testing.T) {
+		store, err := Open(Config{Type: TypeBadger, Badger: BadgerConfig{Path: path.Join(util.TestDirectory(t), "badger")}})
+		require.NoError(t, err)
+		defer store.Close(ctx)
+
+		assertWritable(t, store)
+	})
+	t.Run("redis", func(t *testing.T) {
+		redis := miniredis.RunT(t)
+
+		store, err := Open(Config{Type: TypeRedis, Redis: RedisConfig{Address: redis.Addr(), Prefix: "app"}})
+		require.NoError(t, err)
+		defer store.Close(ctx)
+
+		assertWritable(t, store)
+		assert.Contains(t, redis.Keys()[0], "app")
+	})
+	t.Run("remote", func(t *testing.T) {
+		server := startServer(t)
+
+		store, err := Open(Config{Type: TypeRemote, Remote: RemoteConfig{Address: server}})
+		require.NoError(t, err)
+
+		assertWritable(t, store)
+		assert.NoError(t, store.Close(ctx))
+		assert.NoError(t, store.Close(ctx))
+	})
+	t.Run("metrics", func(t *testing.T) {
+		registry := prometheus.NewRegistry()
+
+		store, err := Open(Config{
+			Type:    TypeBBolt,
+			BBolt:   BBoltConfig{Path: path.Join(util.TestDirectory(t), "bbolt.db")},
+			Metrics: MetricsConfig{Enabled: true, Registerer: registry},
+		})
+		require.NoError(t, err)
+		defer store.Close(ctx)
+		assertWritable(t, store)
+
+		count, err := testutil.GatherAndCount(registry, "stoabs_shelf_entries")
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+	})
+	t.Run("expvar metrics", func(t *testing.T) {
+		config := Config{
+			Type:    TypeBBolt,
+			BBolt:   BBoltConfig{Path: path.Join(util.TestDirectory(t), "bbolt.db")},
+			Metrics: MetricsConfig{Expvar: "config_test_stoabs"},
+		}
+		store, err := Open(config)
+		require.NoError(t, err)
+		defer store.Close(ctx)
+		assertWritable(t, store)
+
+		assert.Contains(t, expvar.Get("config_test_stoabs").String(), `"stoabs_shelf_entries":{"test":1}`)
+		t.Run("already published", func(t *testing.T) {
+			config.BBolt.Path = path.Join(util.TestDirectory(t), "bbolt.db")
+
+			_, err := Open(config)
+
+			assert.EqualError(t, err, "unable to publish metrics: expvar variable config_test_stoabs already published")
+		})
+		t.Run("already published unregisters Prometheus metrics", func(t *testing.T) {
+			registry := prometheus.NewRegistry()
+			config.BBolt.Path = path.Join(util.TestDirectory(t), "bbolt.db")
+			config.Metrics.Enabled = true
+			config.Metrics.Registerer = registry
+
+			_, err := Open(config)
+			require.Error(t, err)
+
+			// registering the metrics again succeeds
+			config.Metrics.Expvar = ""
+			store, err := Open(config)
+			require.NoError(t, err)
+			assert.NoError(t, store.Close(ctx))
+		})
+	})
+	t.Run("errors", func(t *testing.T) {
+		testCases := []struct {
+			name   string
+			config Config
+			err    string
+		}{
+			{name: "missing type", config: Config{}, err: "store type is required"},
+			{name: "unsupported type", config: Config{Type: "mysql"}, err: `unsupported store type: "mysql"`},
+			{name: "bbolt without path", config: Config{Type: TypeBBolt}, err: "bbolt: path is required"},
+			{name: "badger without path", config: Config{Type: TypeBadger}, err: "badger: path is required"},
+			{name: "redis without address", config: Config{Type: TypeRedis}, err: "redis: address is required"},
+			{name: "remote without address", config: Config{Type: TypeRemote}, err: "remote: address is required"},
+			{
+				name:   "remote with store options",
+				config: Config{Type: TypeRemote, Remote: RemoteConfig{Address: "localhost:1234"}, KeyPrefix: "app"},
+				err:    "remote: noSync, lockAcquireTimeout, lockLease, keyPrefix and orderedIteration are configured by the server",
+			},
+			{
+				name:   "invalid CA file",
+				config: Config{Type: TypeRedis, Redis: RedisConfig{Address: "localhost:6379", TLS: TLSConfig{Enabled: true, CAFile: "does-not-exist.pem"}}},
+				err:    "redis: unable to read CA file: open does-not-exist.pem: no such file or directory",
+			},
+		}
+		for _, testCase := range testCases {
+			t.Run(testCase.name, func(t *testing.T) {
+				_, err := Open(testCase.config)
+
+				assert.EqualError(t, err, testCase.err)
+			})
+		}
+	})
+}
+
+func TestConfig_JSON(t *testing.T) {
+	var config Config
+
+	err := json.Unmarshal([]byte(`{"type": "redis", "redis": {"address": "localhost:6379", "database": 2, "tls": {"enabled": true}}}`), &config)
+
+	require.NoError(t, err)
+	assert.Equal(t, Config{Type: TypeRedis, Redis: RedisConfig{Address: "localhost:6379", Database: 2, TLS: TLSConfig{Enabled: true}}}, config)
+}
+
+func TestTLSConfig_load(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		tlsConfig, err := TLSConfig{CAFile: "ignored.pem"}.load()
+
+		assert.NoError(t, err)
+		assert.Nil(t, tlsConfig)
+	})
+	t.Run("enabled", func(t *testing.T) {
+		tlsConfig, err := TLSConfig{Enabled: true, ServerName: "redis.local"}.load()
+
+		require.NoError(t, err)
+		assert.Equal(t, "redis.local", tlsConfig.ServerName)
+		assert.Nil(t, tlsConfig.RootCAs)
+	})
+	t.Run("key without certificate", func(t *testing.T) {
+		_, err := TLSConfig{Enabled: true, KeyFile: "key.pem"}.load()
+
+		assert.ErrorContains(t, err, "unable to load client certificate")
+	})
+}
+
+func assertWritable(t *testing.T, store stoabs.KVStore) {
+	require.NoError(t, store.WriteShelf(ctx, "test", func(writer stoabs.Writer) error {
+		return writer.Put(stoabs.BytesKey("key"), []byte("value"))
+	}))
+	var value []byte
+	require.NoError(t, store.ReadShelf(ctx, "test", func(reader stoabs.Reader) error {
+		var err error
+		value, err = reader.Get(stoabs.BytesKey("key"))
+		return err
+	}))
+	assert.Equal(t, []byte("value"), value)
+}
+
+// startServer exposes a new BBolt store using remote.NewServer and returns its address.
+func startServer(t *testing.T) string {
+	store, err := Open(Config{Type: TypeBBolt, BBolt: BBoltConfig{Path: path.Join(util.TestDirectory(t), "server.db")}})
+	require.NoError(t, err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer()
+	remotepb.RegisterKVStoreServer(server, remote.NewServer(store))
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(func() {
+		server.Stop()
+		_ = store.Close(context.Background())
+	})
+	return listener.Addr().String()
+}
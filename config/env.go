@@ -0,0 +1,103 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// LoadEnv overrides the properties of the configuration for which an environment variable is set.
+// The names of the variables are derived from the env tags of the properties, joined by underscores and prefixed with
+// the given prefix, e.g. with prefix STORAGE: STORAGE_TYPE, STORAGE_BBOLT_PATH and STORAGE_REDIS_TLS_ENABLED.
+// Variables that are set but empty are ignored.
+func (c *Config) LoadEnv(prefix string) error {
+	return loadEnv(reflect.ValueOf(c).Elem(), prefix, os.LookupEnv)
+}
+
+// FromEnv returns the configuration specified by the environment variables, see Config.LoadEnv.
+func FromEnv(prefix string) (Config, error) {
+	var result Config
+	err := result.LoadEnv(prefix)
+	return result, err
+}
+
+func loadEnv(value reflect.Value, prefix string, lookup func(string) (string, bool)) error {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		tag := field.Tag.Get("env")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		name := tag
+		if prefix != "" {
+			name = prefix + "_" + tag
+		}
+		target := value.Field(i)
+		if target.Kind() == reflect.Struct {
+			if err := loadEnv(target, name, lookup); err != nil {
+				return err
+			}
+			continue
+		}
+		raw, ok := lookup(name)
+		if !ok || raw == "" {
+			continue
+		}
+		if err := set(target, raw); err != nil {
+			return fmt.Errorf("invalid value for %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func set(target reflect.Value, raw string) error {
+	if target.Type() == durationType {
+		duration, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		target.SetInt(int64(duration))
+		return nil
+	}
+	switch target.Kind() {
+	case reflect.String:
+		target.SetString(raw)
+	case reflect.Bool:
+		value, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		target.SetBool(value)
+	case reflect.Int:
+		value, err := strconv.Atoi(raw)
+		if err != nil {
+			return err
+		}
+		target.SetInt(int64(value))
+	default:
+		return fmt.Errorf("unsupported type: %s", target.Type())
+	}
+	return nil
+}
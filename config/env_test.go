@@ -0,0 +1,86 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("STORAGE_TYPE", "redis")
+	t.Setenv("STORAGE_NOSYNC", "true")
+	t.Setenv("STORAGE_LOCK_ACQUIRE_TIMEOUT", "5s")
+	t.Setenv("STORAGE_LOCK_LEASE", "20s")
+	t.Setenv("STORAGE_KEY_PREFIX", "app1/")
+	t.Setenv("STORAGE_ORDERED_ITERATION", "true")
+	t.Setenv("STORAGE_REDIS_ADDRESS", "localhost:6379")
+	t.Setenv("STORAGE_REDIS_DATABASE", "3")
+	t.Setenv("STORAGE_REDIS_TLS_ENABLED", "true")
+	t.Setenv("STORAGE_REDIS_TLS_CA_FILE", "ca.pem")
+	t.Setenv("STORAGE_BBOLT_PATH", "")
+
+	config, err := FromEnv("STORAGE")
+
+	require.NoError(t, err)
+	assert.Equal(t, Config{
+		Type:               TypeRedis,
+		NoSync:             true,
+		LockAcquireTimeout: 5 * time.Second,
+		LockLease:          20 * time.Second,
+		KeyPrefix:          "app1/",
+		OrderedIteration:   true,
+		Redis: RedisConfig{
+			Address:  "localhost:6379",
+			Database: 3,
+			TLS:      TLSConfig{Enabled: true, CAFile: "ca.pem"},
+		},
+	}, config)
+}
+
+func TestConfig_LoadEnv(t *testing.T) {
+	t.Run("overrides configured properties", func(t *testing.T) {
+		t.Setenv("STORAGE_BBOLT_PATH", "/data/override.db")
+		config := Config{Type: TypeBBolt, BBolt: BBoltConfig{Path: "/data/network.db"}, NoSync: true}
+
+		err := config.LoadEnv("STORAGE")
+
+		require.NoError(t, err)
+		assert.Equal(t, Config{Type: TypeBBolt, BBolt: BBoltConfig{Path: "/data/override.db"}, NoSync: true}, config)
+	})
+	t.Run("invalid value", func(t *testing.T) {
+		t.Setenv("STORAGE_REDIS_DATABASE", "one")
+		var config Config
+
+		err := config.LoadEnv("STORAGE")
+
+		assert.EqualError(t, err, `invalid value for STORAGE_REDIS_DATABASE: strconv.Atoi: parsing "one": invalid syntax`)
+	})
+	t.Run("invalid duration", func(t *testing.T) {
+		t.Setenv("STORAGE_REDIS_DIAL_TIMEOUT", "5")
+		var config Config
+
+		err := config.LoadEnv("STORAGE")
+
+		assert.ErrorContains(t, err, "invalid value for STORAGE_REDIS_DIAL_TIMEOUT")
+	})
+}
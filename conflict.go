@@ -0,0 +1,88 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ConflictError describes a transaction that couldn't be committed because a concurrent transaction modified the data it read,
+// which can happen in stores with optimistic concurrency control (Badger). It matches ErrConflict.
+// Use errors.As to find out which key caused the conflict, to identify hot keys.
+type ConflictError struct {
+	// Shelf is the name of the shelf containing Key. It's empty if it's unknown which key caused the conflict.
+	Shelf string
+	// Key is a key the transaction read, which was modified by a concurrent transaction. It's nil if it's unknown.
+	Key Key
+	// Attempts is the number of times the transaction was attempted (see RetryConflicts).
+	Attempts int
+	// Cause is the error the store returned, if any.
+	Cause error
+}
+
+// NewConflictError returns a ConflictError for a first attempt of a transaction that conflicted on the given key of the shelf.
+func NewConflictError(shelfName string, key Key, cause error) *ConflictError {
+	return &ConflictError{Shelf: shelfName, Key: key, Attempts: 1, Cause: cause}
+}
+
+func (e *ConflictError) Error() string {
+	result := ErrConflict.Error()
+	if e.Key != nil {
+		result += fmt.Sprintf(" (shelf=%s, key=%s)", e.Shelf, e.Key)
+	}
+	if e.Attempts > 1 {
+		result += fmt.Sprintf(" after %d attempts", e.Attempts)
+	}
+	if e.Cause != nil {
+		result += ": " + e.Cause.Error()
+	}
+	return result
+}
+
+// Unwrap returns ErrConflict and the cause, so the error matches ErrConflict (and ErrCommitFailed and ErrDatabase).
+func (e *ConflictError) Unwrap() []error {
+	if e.Cause == nil {
+		return []error{ErrConflict}
+	}
+	return []error{ErrConflict, e.Cause}
+}
+
+// RetryConflicts executes the given write transaction, retrying it when it fails with ErrConflict up to the given number
+// of attempts in total. Other errors are returned immediately. If the last attempt conflicted, the returned error
+// (if it's a ConflictError) reports the number of attempts.
+// Since the transaction may run multiple times, fn must not have side effects outside the transaction.
+func RetryConflicts(ctx context.Context, store KVStore, attempts int, fn func(WriteTx) error, opts ...TxOption) error {
+	if attempts < 1 {
+		return fmt.Errorf("invalid number of attempts: %d", attempts)
+	}
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		err = store.Write(ctx, fn, opts...)
+		if !errors.Is(err, ErrConflict) {
+			return err
+		}
+		var conflict *ConflictError
+		if errors.As(err, &conflict) {
+			conflict.Attempts = attempt
+		}
+	}
+	return err
+}
@@ -0,0 +1,81 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"testing"
+)
+
+func TestConflictError(t *testing.T) {
+	cause := errors.New("badger conflict")
+	err := NewConflictError("accounts", BytesKey("alice"), cause)
+
+	t.Run("matches ErrConflict and cause", func(t *testing.T) {
+		assert.ErrorIs(t, err, ErrConflict)
+		assert.ErrorIs(t, err, ErrCommitFailed)
+		assert.ErrorIs(t, err, ErrDatabase{})
+		assert.ErrorIs(t, err, cause)
+		assert.NotErrorIs(t, err, ErrTimeout)
+	})
+	t.Run("error message", func(t *testing.T) {
+		assert.EqualError(t, err, "database error: unable to commit transaction: conflicting concurrent transaction (shelf=accounts, key=616c696365): badger conflict")
+		assert.EqualError(t, &ConflictError{Attempts: 3}, "database error: unable to commit transaction: conflicting concurrent transaction after 3 attempts")
+	})
+}
+
+func TestRetryConflicts(t *testing.T) {
+	ctx := context.Background()
+	fn := func(tx WriteTx) error { return nil }
+
+	t.Run("succeeds after conflict", func(t *testing.T) {
+		store := NewMockKVStore(gomock.NewController(t))
+		gomock.InOrder(
+			store.EXPECT().Write(ctx, gomock.Any()).Return(NewConflictError("", nil, nil)),
+			store.EXPECT().Write(ctx, gomock.Any()).Return(nil),
+		)
+
+		assert.NoError(t, RetryConflicts(ctx, store, 3, fn))
+	})
+	t.Run("reports attempts", func(t *testing.T) {
+		store := NewMockKVStore(gomock.NewController(t))
+		store.EXPECT().Write(ctx, gomock.Any()).Return(NewConflictError("", nil, nil)).Times(2)
+
+		err := RetryConflicts(ctx, store, 2, fn)
+
+		var conflict *ConflictError
+		require.ErrorAs(t, err, &conflict)
+		assert.Equal(t, 2, conflict.Attempts)
+	})
+	t.Run("other errors aren't retried", func(t *testing.T) {
+		store := NewMockKVStore(gomock.NewController(t))
+		store.EXPECT().Write(ctx, gomock.Any()).Return(ErrStoreIsClosed)
+
+		assert.ErrorIs(t, RetryConflicts(ctx, store, 3, fn), ErrStoreIsClosed)
+	})
+	t.Run("invalid number of attempts", func(t *testing.T) {
+		err := RetryConflicts(ctx, NewMockKVStore(gomock.NewController(t)), 0, fn)
+
+		assert.EqualError(t, err, "invalid number of attempts: 0")
+	})
+}
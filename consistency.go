@@ -0,0 +1,123 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"context"
+	"time"
+)
+
+// Consistency specifies how fresh the data read by a transaction must be, see WithConsistency.
+type Consistency struct {
+	level        consistencyLevel
+	maxStaleness time.Duration
+}
+
+type consistencyLevel int
+
+const (
+	strongConsistency consistencyLevel = iota
+	boundedStaleness
+	eventualConsistency
+)
+
+var (
+	// ConsistencyStrong reads the latest committed data. This is the default.
+	ConsistencyStrong = Consistency{level: strongConsistency}
+	// ConsistencyEventual allows reading data that is arbitrarily stale (e.g. from an asynchronous replica),
+	// in exchange for lower latency and less load on the primary.
+	ConsistencyEventual = Consistency{level: eventualConsistency}
+)
+
+// ConsistencyBoundedStaleness allows reading data that is at most maxStaleness behind the latest committed data.
+func ConsistencyBoundedStaleness(maxStaleness time.Duration) Consistency {
+	return Consistency{level: boundedStaleness, maxStaleness: maxStaleness}
+}
+
+// IsStrong returns whether the latest committed data must be read.
+func (c Consistency) IsStrong() bool {
+	return c.level == strongConsistency
+}
+
+// IsEventual returns whether arbitrarily stale data may be read.
+func (c Consistency) IsEventual() bool {
+	return c.level == eventualConsistency
+}
+
+// MaxStaleness returns how far behind the latest committed data reads may be: 0 for strong consistency,
+// and -1 (unbounded) for eventual consistency.
+func (c Consistency) MaxStaleness() time.Duration {
+	switch c.level {
+	case boundedStaleness:
+		return c.maxStaleness
+	case eventualConsistency:
+		return -1
+	default:
+		return 0
+	}
+}
+
+func (c Consistency) String() string {
+	switch c.level {
+	case boundedStaleness:
+		return "bounded-staleness(" + c.maxStaleness.String() + ")"
+	case eventualConsistency:
+		return "eventual"
+	default:
+		return "strong"
+	}
+}
+
+// ConsistencyOption see WithConsistency
+type ConsistencyOption struct {
+	consistency Consistency
+}
+
+// Consistency returns the consistency specified in the given options, or ConsistencyStrong if there is none.
+func (o ConsistencyOption) Consistency(opts []TxOption) Consistency {
+	for _, opt := range opts {
+		if consistency, ok := opt.(ConsistencyOption); ok {
+			return consistency.consistency
+		}
+	}
+	return ConsistencyStrong
+}
+
+// WithConsistency is a transaction option that specifies the consistency of the reads of a read transaction,
+// allowing callers to trade freshness for latency on distributed backends (e.g. reading from Redis replicas).
+// Backends that have a single copy of the data (BBolt, Badger) always read the latest data and ignore the option.
+func WithConsistency(consistency Consistency) TxOption {
+	return ConsistencyOption{consistency: consistency}
+}
+
+// OptionReader is implemented by stores whose read transactions accept transaction options (e.g. WithConsistency).
+type OptionReader interface {
+	// ReadWithOptions is like KVStore.Read, but accepts transaction options.
+	ReadWithOptions(ctx context.Context, fn func(ReadTx) error, opts ...TxOption) error
+}
+
+// ReadWithOptions starts a read transaction with the given options on the store.
+// If the store does not implement OptionReader, the options are ignored and the store reads with its default (strong)
+// consistency, which is always allowed since the other levels only relax it.
+func ReadWithOptions(ctx context.Context, store KVStore, fn func(ReadTx) error, opts ...TxOption) error {
+	if reader, ok := store.(OptionReader); ok {
+		return reader.ReadWithOptions(ctx, fn, opts...)
+	}
+	return store.Read(ctx, fn)
+}
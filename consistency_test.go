@@ -0,0 +1,80 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+	"testing"
+	"time"
+)
+
+type optionReader struct {
+	*MockKVStore
+	opts []TxOption
+}
+
+func (o *optionReader) ReadWithOptions(_ context.Context, _ func(ReadTx) error, opts ...TxOption) error {
+	o.opts = opts
+	return nil
+}
+
+func TestConsistency(t *testing.T) {
+	bounded := ConsistencyBoundedStaleness(5 * time.Second)
+
+	assert.True(t, ConsistencyStrong.IsStrong())
+	assert.True(t, ConsistencyEventual.IsEventual())
+	assert.False(t, bounded.IsStrong() || bounded.IsEventual())
+	assert.Equal(t, time.Duration(0), ConsistencyStrong.MaxStaleness())
+	assert.Equal(t, 5*time.Second, bounded.MaxStaleness())
+	assert.Equal(t, time.Duration(-1), ConsistencyEventual.MaxStaleness())
+	assert.Equal(t, "bounded-staleness(5s)", bounded.String())
+	assert.Equal(t, "strong", ConsistencyStrong.String())
+	assert.Equal(t, "eventual", ConsistencyEventual.String())
+}
+
+func TestWithConsistency(t *testing.T) {
+	assert.Equal(t, ConsistencyStrong, ConsistencyOption{}.Consistency(nil))
+	assert.Equal(t, ConsistencyEventual, ConsistencyOption{}.Consistency([]TxOption{WithWriteLock(), WithConsistency(ConsistencyEventual)}))
+}
+
+func TestReadWithOptions(t *testing.T) {
+	ctx := context.Background()
+	fn := func(ReadTx) error {
+		return nil
+	}
+
+	t.Run("store supports options", func(t *testing.T) {
+		store := &optionReader{MockKVStore: NewMockKVStore(gomock.NewController(t))}
+
+		err := ReadWithOptions(ctx, store, fn, WithConsistency(ConsistencyEventual))
+
+		assert.NoError(t, err)
+		assert.Equal(t, []TxOption{WithConsistency(ConsistencyEventual)}, store.opts)
+	})
+	t.Run("options are ignored", func(t *testing.T) {
+		store := NewMockKVStore(gomock.NewController(t))
+		store.EXPECT().Read(ctx, gomock.Any()).Return(nil)
+
+		err := ReadWithOptions(ctx, store, fn, WithConsistency(ConsistencyEventual))
+
+		assert.NoError(t, err)
+	})
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrDeadlock is returned when acquiring key locks (see LockKeys) would deadlock, because the owner of a requested lock
+// is (transitively) waiting for a lock held by the caller. Is also a ErrDatabase.
+var ErrDeadlock = DatabaseError(errors.New("deadlock detected"))
+
+// LockOwner identifies the owner of key locks, see ContextWithLockOwner.
+type LockOwner struct {
+	// Label describes the owner in lock dumps (see Locks), e.g. the name of the job or request.
+	Label string
+}
+
+type lockOwnerKey struct{}
+
+// ContextWithLockOwner returns a context with a new lock owner with the given label.
+// Key locks acquired with the returned context (or a context derived from it) belong to the same owner,
+// which allows stores to detect deadlocks between owners acquiring locks in multiple calls to LockKeys:
+// instead of waiting forever, the lock acquisition that would close the cycle fails with ErrDeadlock.
+// Acquiring a lock that's already held by the same owner also fails with ErrDeadlock.
+// An owner must not acquire locks concurrently (e.g. from multiple goroutines).
+// Locks acquired without an owner belong to an anonymous owner that only exists for the duration of the call.
+func ContextWithLockOwner(ctx context.Context, label string) context.Context {
+	return context.WithValue(ctx, lockOwnerKey{}, &LockOwner{Label: label})
+}
+
+// LockOwnerFromContext returns the lock owner of the given context set by ContextWithLockOwner,
+// or nil if the context doesn't have one.
+func LockOwnerFromContext(ctx context.Context) *LockOwner {
+	owner, _ := ctx.Value(lockOwnerKey{}).(*LockOwner)
+	return owner
+}
+
+// LockInfo describes a key lock that is currently held, see Locks.
+type LockInfo struct {
+	Shelf string
+	Key   Key
+	// Holder is the label of the owner holding the lock (see ContextWithLockOwner).
+	// It's empty if the owner doesn't have a label.
+	Holder string
+	// Waiters contains the labels of the owners waiting to acquire the lock.
+	Waiters []string
+}
+
+// LockInspector is implemented by stores that can list the key locks that are currently held, to diagnose hanging lock acquisitions.
+type LockInspector interface {
+	// Locks returns the key locks that are currently held, sorted by shelf and key.
+	Locks() []LockInfo
+}
+
+// Locks returns the key locks that are currently held in the given store.
+// If the store does not implement LockInspector, it returns errors.ErrUnsupported.
+func Locks(store KVStore) ([]LockInfo, error) {
+	inspector, ok := store.(LockInspector)
+	if !ok {
+		return nil, fmt.Errorf("listing locks of %T: %w", store, errors.ErrUnsupported)
+	}
+	return inspector.Locks(), nil
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+	"testing"
+)
+
+func TestContextWithLockOwner(t *testing.T) {
+	ctx := context.Background()
+	assert.Nil(t, LockOwnerFromContext(ctx))
+
+	ownerCtx := ContextWithLockOwner(ctx, "job")
+	derived, cancel := context.WithCancel(ownerCtx)
+	defer cancel()
+
+	owner := LockOwnerFromContext(derived)
+	assert.Equal(t, "job", owner.Label)
+	assert.Same(t, owner, LockOwnerFromContext(ownerCtx))
+	assert.NotSame(t, owner, LockOwnerFromContext(ContextWithLockOwner(ctx, "job")))
+}
+
+func TestLocks(t *testing.T) {
+	t.Run("supported", func(t *testing.T) {
+		expected := []LockInfo{{Shelf: "shelf", Key: BytesKey("key"), Holder: "job"}}
+		store := struct {
+			KVStore
+			LockInspector
+		}{LockInspector: lockInspector(expected)}
+
+		locks, err := Locks(store)
+
+		assert.NoError(t, err)
+		assert.Equal(t, expected, locks)
+	})
+	t.Run("unsupported", func(t *testing.T) {
+		_, err := Locks(NewMockKVStore(gomock.NewController(t)))
+
+		assert.True(t, errors.Is(err, errors.ErrUnsupported))
+	})
+}
+
+func TestErrDeadlock(t *testing.T) {
+	assert.ErrorIs(t, ErrDeadlock, ErrDatabase{})
+	assert.NotErrorIs(t, ErrDeadlock, ErrTimeout)
+}
+
+type lockInspector []LockInfo
+
+func (l lockInspector) Locks() []LockInfo {
+	return l
+}
@@ -0,0 +1,52 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+// BulkDeleter is implemented by Writers that can delete the entries matching a predicate in a single pass over the shelf.
+type BulkDeleter interface {
+	// DeleteWhere deletes all entries of the shelf for which the predicate returns true,
+	// and returns the number of deleted entries. Keys are parsed as the given key type.
+	DeleteWhere(keyType Key, predicate func(key Key, value []byte) bool) (int, error)
+}
+
+// DeleteWhere deletes all entries of the shelf for which the predicate returns true, and returns the number of deleted entries.
+// If the writer does not implement BulkDeleter, the matching keys are collected while iterating the shelf,
+// and deleted afterwards. All deletions are part of the writer's transaction, see batch.DeleteWhere to spread
+// deleting a large number of entries over multiple transactions.
+func DeleteWhere(writer Writer, keyType Key, predicate func(key Key, value []byte) bool) (int, error) {
+	if deleter, ok := writer.(BulkDeleter); ok {
+		return deleter.DeleteWhere(keyType, predicate)
+	}
+	var keys []Key
+	err := writer.Iterate(func(key Key, value []byte) error {
+		if predicate(key, value) {
+			keys = append(keys, key)
+		}
+		return nil
+	}, keyType)
+	if err != nil {
+		return 0, err
+	}
+	for i, key := range keys {
+		if err := writer.Delete(key); err != nil {
+			return i, err
+		}
+	}
+	return len(keys), nil
+}
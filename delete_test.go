@@ -0,0 +1,71 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+	"testing"
+)
+
+type bulkDeletingWriter struct {
+	*MockWriter
+}
+
+func (b bulkDeletingWriter) DeleteWhere(_ Key, _ func(key Key, value []byte) bool) (int, error) {
+	return 42, nil
+}
+
+func TestDeleteWhere(t *testing.T) {
+	all := func(Key, []byte) bool {
+		return true
+	}
+
+	t.Run("writer implements BulkDeleter", func(t *testing.T) {
+		writer := bulkDeletingWriter{MockWriter: NewMockWriter(gomock.NewController(t))}
+
+		deleted, err := DeleteWhere(writer, BytesKey{}, all)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 42, deleted)
+	})
+	t.Run("iterate fails", func(t *testing.T) {
+		writer := NewMockWriter(gomock.NewController(t))
+		writer.EXPECT().Iterate(gomock.Any(), BytesKey{}).Return(errors.New("failed"))
+
+		_, err := DeleteWhere(writer, BytesKey{}, all)
+
+		assert.EqualError(t, err, "failed")
+	})
+	t.Run("delete fails", func(t *testing.T) {
+		writer := NewMockWriter(gomock.NewController(t))
+		writer.EXPECT().Iterate(gomock.Any(), BytesKey{}).DoAndReturn(func(callback CallerFn, _ Key) error {
+			_ = callback(BytesKey("a"), nil)
+			return callback(BytesKey("b"), nil)
+		})
+		writer.EXPECT().Delete(BytesKey("a")).Return(nil)
+		writer.EXPECT().Delete(BytesKey("b")).Return(errors.New("failed"))
+
+		deleted, err := DeleteWhere(writer, BytesKey{}, all)
+
+		assert.EqualError(t, err, "failed")
+		assert.Equal(t, 1, deleted)
+	})
+}
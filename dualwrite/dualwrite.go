@@ -0,0 +1,289 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package dualwrite provides a KVStore that writes to two backends, to support migrating from one backend to another without downtime.
+package dualwrite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync/atomic"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/util"
+	"github.com/sirupsen/logrus"
+)
+
+var _ stoabs.KVStore = (*Store)(nil)
+var _ stoabs.ShelfLister = (*Store)(nil)
+var _ stoabs.WriteTx = (*tx)(nil)
+var _ stoabs.Writer = (*shelf)(nil)
+
+// Target identifies one of the two stores.
+type Target int
+
+const (
+	// Old is the store that is being migrated from.
+	Old Target = iota
+	// New is the store that is being migrated to.
+	New
+)
+
+// Option configures the dual-write store.
+type Option func(s *Store)
+
+// WithReadPrimary specifies which store serves reads before cutover. It defaults to Old.
+func WithReadPrimary(target Target) Option {
+	return func(s *Store) {
+		s.readPrimary.Store(int32(target))
+	}
+}
+
+// WithShadowReads makes Get also read the key from the store that isn't the read primary, counting divergent results.
+// The value from the read primary is always returned.
+func WithShadowReads() Option {
+	return func(s *Store) {
+		s.shadowReads = true
+	}
+}
+
+// WithLogger overrides the default logger.
+func WithLogger(log *logrus.Logger) Option {
+	return func(s *Store) {
+		s.log = log
+	}
+}
+
+// Stats contains the divergence counters of the dual-write store.
+type Stats struct {
+	// SecondaryWriteFailures counts transactions that were committed on the primary store, but failed on the secondary store.
+	SecondaryWriteFailures uint64
+	// ReadDivergences counts shadow reads (see WithShadowReads) that returned a different result than the read primary.
+	ReadDivergences uint64
+}
+
+// Wrap creates a store that writes to both old and new. Before cutover, transactions are committed on old first (the primary),
+// after which the mutations are applied to new (the secondary) in a separate transaction. Failures on the secondary store
+// are logged and counted, but not returned to the caller. Call Cutover to make new the primary.
+// Writes through the store are serialized, so the mutations are applied to the secondary store in the order in which they
+// were committed on the primary store. Writes that bypass the store (e.g. directly on old) aren't replicated.
+// Callers should not read values written in the same transaction (see stoabs.KVStore.Write).
+func Wrap(old, new stoabs.KVStore, opts ...Option) *Store {
+	result := &Store{
+		stores:    [2]stoabs.KVStore{old, new},
+		log:       logrus.StandardLogger(),
+		writeLock: &util.ContextRWLocker{},
+	}
+	for _, opt := range opts {
+		opt(result)
+	}
+	return result
+}
+
+// Store is a KVStore that writes to two stores. Use Wrap to create it.
+type Store struct {
+	stores      [2]stoabs.KVStore
+	log         *logrus.Logger
+	shadowReads bool
+	// writePrimary and readPrimary hold the Target that is primary for writes and reads.
+	writePrimary atomic.Int32
+	readPrimary  atomic.Int32
+	// writeLock is held while committing a transaction on the primary store and replicating it to the secondary store,
+	// so concurrent writers can't apply their mutations to the secondary store in a different order than on the primary.
+	writeLock *util.ContextRWLocker
+
+	secondaryWriteFailures atomic.Uint64
+	readDivergences        atomic.Uint64
+}
+
+// Cutover makes new the primary for both reads and writes, after which old is only written to as secondary.
+func (s *Store) Cutover() {
+	s.writePrimary.Store(int32(New))
+	s.readPrimary.Store(int32(New))
+	s.log.Info("Dual-write store cut over to new store")
+}
+
+// Stats returns the divergence counters.
+func (s *Store) Stats() Stats {
+	return Stats{
+		SecondaryWriteFailures: s.secondaryWriteFailures.Load(),
+		ReadDivergences:        s.readDivergences.Load(),
+	}
+}
+
+// Close closes both stores.
+func (s *Store) Close(ctx context.Context) error {
+	return errors.Join(s.stores[Old].Close(ctx), s.stores[New].Close(ctx))
+}
+
+func (s *Store) Write(ctx context.Context, fn func(stoabs.WriteTx) error, opts ...stoabs.TxOption) error {
+	if err := s.writeLock.LockContext(ctx); err != nil {
+		return stoabs.DatabaseError(err)
+	}
+	defer s.writeLock.Unlock()
+	primary, secondary := s.targets(&s.writePrimary)
+	var mutations []mutation
+	err := s.stores[primary].Write(ctx, func(primaryTx stoabs.WriteTx) error {
+		mutations = nil
+		return fn(&tx{ReadTx: primaryTx, writeTx: primaryTx, store: s, ctx: ctx, mutations: &mutations})
+	}, opts...)
+	if err != nil {
+		return err
+	}
+	s.replicate(ctx, secondary, mutations)
+	return nil
+}
+
+func (s *Store) WriteShelf(ctx context.Context, shelfName string, fn func(stoabs.Writer) error) error {
+	return s.Write(ctx, func(tx stoabs.WriteTx) error {
+		return fn(tx.GetShelfWriter(shelfName))
+	})
+}
+
+func (s *Store) Read(ctx context.Context, fn func(stoabs.ReadTx) error) error {
+	primary, _ := s.targets(&s.readPrimary)
+	return s.stores[primary].Read(ctx, func(primaryTx stoabs.ReadTx) error {
+		return fn(&tx{ReadTx: primaryTx, store: s, ctx: ctx})
+	})
+}
+
+func (s *Store) ReadShelf(ctx context.Context, shelfName string, fn func(stoabs.Reader) error) error {
+	return s.Read(ctx, func(tx stoabs.ReadTx) error {
+		return fn(tx.GetShelfReader(shelfName))
+	})
+}
+
+// ShelfNames returns the shelves of the read primary.
+func (s *Store) ShelfNames(ctx context.Context) ([]string, error) {
+	primary, _ := s.targets(&s.readPrimary)
+	return stoabs.ShelfNames(ctx, s.stores[primary])
+}
+
+func (s *Store) targets(primary *atomic.Int32) (Target, Target) {
+	if Target(primary.Load()) == New {
+		return New, Old
+	}
+	return Old, New
+}
+
+// replicate applies the mutations committed on the primary store to the secondary store.
+func (s *Store) replicate(ctx context.Context, secondary Target, mutations []mutation) {
+	if len(mutations) == 0 {
+		return
+	}
+	err := s.stores[secondary].Write(ctx, func(tx stoabs.WriteTx) error {
+		for _, m := range mutations {
+			writer := tx.GetShelfWriter(m.shelf)
+			var err error
+			if m.delete {
+				err = writer.Delete(m.key)
+			} else {
+				err = writer.Put(m.key, m.value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.secondaryWriteFailures.Add(1)
+		s.log.WithError(err).Errorf("Dual-write store: unable to apply %d mutation(s) to secondary store", len(mutations))
+	}
+}
+
+// shadowGet reads the key from the store that isn't the read primary and counts a divergence if the result differs.
+func (s *Store) shadowGet(ctx context.Context, shelfName string, key stoabs.Key, expected []byte, expectedErr error) {
+	_, secondary := s.targets(&s.readPrimary)
+	var actual []byte
+	actualErr := s.stores[secondary].ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+		var err error
+		actual, err = reader.Get(key)
+		return err
+	})
+	if !bytes.Equal(expected, actual) || !errors.Is(actualErr, expectedErr) {
+		s.readDivergences.Add(1)
+		s.log.Debugf("Dual-write store: shadow read diverged (shelf=%s, key=%s)", shelfName, key)
+	}
+}
+
+type mutation struct {
+	shelf  string
+	key    stoabs.Key
+	value  []byte
+	delete bool
+}
+
+type tx struct {
+	stoabs.ReadTx
+	writeTx   stoabs.WriteTx
+	store     *Store
+	ctx       context.Context
+	mutations *[]mutation
+}
+
+func (t *tx) GetShelfReader(shelfName string) stoabs.Reader {
+	return &shelf{Reader: t.ReadTx.GetShelfReader(shelfName), name: shelfName, tx: t}
+}
+
+func (t *tx) GetShelfWriter(shelfName string) stoabs.Writer {
+	writer := t.writeTx.GetShelfWriter(shelfName)
+	return &shelf{Reader: writer, writer: writer, name: shelfName, tx: t}
+}
+
+func (t *tx) Store() stoabs.KVStore {
+	return t.store
+}
+
+// Unwrap returns nil, since writes on the transaction of the primary store would bypass the secondary store.
+func (t *tx) Unwrap() interface{} {
+	return nil
+}
+
+type shelf struct {
+	stoabs.Reader
+	writer stoabs.Writer
+	name   string
+	tx     *tx
+}
+
+func (s *shelf) Get(key stoabs.Key) ([]byte, error) {
+	value, err := s.Reader.Get(key)
+	// shadow reads are only performed in read transactions, to avoid slowing down (locked) write transactions
+	if s.tx.store.shadowReads && s.tx.writeTx == nil {
+		s.tx.store.shadowGet(s.tx.ctx, s.name, key, value, err)
+	}
+	return value, err
+}
+
+func (s *shelf) Put(key stoabs.Key, value []byte) error {
+	if err := s.writer.Put(key, value); err != nil {
+		return err
+	}
+	*s.tx.mutations = append(*s.tx.mutations, mutation{shelf: s.name, key: key, value: append(value[:0:0], value...)})
+	return nil
+}
+
+func (s *shelf) Delete(key stoabs.Key) error {
+	if err := s.writer.Delete(key); err != nil {
+		return err
+	}
+	*s.tx.mutations = append(*s.tx.mutations, mutation{shelf: s.name, key: key, delete: true})
+	return nil
+}
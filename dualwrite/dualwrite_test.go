@@ -0,0 +1,192 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package dualwrite
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+var key = stoabs.BytesKey{1, 2, 3}
+var value = []byte("value")
+
+const shelfName = "test"
+
+func TestDualWrite(t *testing.T) {
+	provider := func(t *testing.T) (stoabs.KVStore, error) {
+		return Wrap(stores.BBolt(t), stores.BBolt(t)), nil
+	}
+
+	kvtests.TestReadingAndWriting(t, provider)
+	kvtests.TestRange(t, provider)
+	kvtests.TestIterate(t, provider)
+	kvtests.TestEmpty(t, provider)
+	kvtests.TestDelete(t, provider)
+	kvtests.TestWriteTransactions(t, provider)
+	kvtests.TestShelfNames(t, provider)
+}
+
+func TestStore_Write(t *testing.T) {
+	t.Run("writes to both stores", func(t *testing.T) {
+		old, new := stores.BBolt(t), stores.BBolt(t)
+		store := Wrap(old, new)
+
+		err := store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			_ = writer.Put(stoabs.BytesKey{1}, value)
+			_ = writer.Put(key, value)
+			return writer.Delete(stoabs.BytesKey{1})
+		})
+
+		require.NoError(t, err)
+		for _, s := range []stoabs.KVStore{old, new} {
+			assert.Equal(t, value, get(t, s, key))
+			assert.Nil(t, get(t, s, stoabs.BytesKey{1}))
+		}
+		assert.Equal(t, Stats{}, store.Stats())
+	})
+	t.Run("rollback writes to neither store", func(t *testing.T) {
+		old, new := stores.BBolt(t), stores.BBolt(t)
+		store := Wrap(old, new)
+
+		err := store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			_ = writer.Put(key, value)
+			return errors.New("failed")
+		})
+
+		assert.EqualError(t, err, "failed")
+		assert.Nil(t, get(t, old, key))
+		assert.Nil(t, get(t, new, key))
+	})
+	t.Run("secondary failure is counted, not returned", func(t *testing.T) {
+		old, new := stores.BBolt(t), stores.BBolt(t)
+		store := Wrap(old, new)
+		_ = new.Close(ctx)
+
+		err := store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			return writer.Put(key, value)
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, value, get(t, old, key))
+		assert.Equal(t, uint64(1), store.Stats().SecondaryWriteFailures)
+	})
+	t.Run("primary failure is returned", func(t *testing.T) {
+		old, new := stores.BBolt(t), stores.BBolt(t)
+		store := Wrap(old, new)
+		_ = old.Close(ctx)
+
+		err := store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			return writer.Put(key, value)
+		})
+
+		assert.ErrorIs(t, err, stoabs.ErrStoreIsClosed)
+		assert.Nil(t, get(t, new, key))
+	})
+}
+
+func TestStore_Write_concurrent(t *testing.T) {
+	old, new := stores.BBolt(t), stores.BBolt(t)
+	store := Wrap(old, new)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_ = store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+				return writer.Put(key, []byte{byte(i)})
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, get(t, old, key), get(t, new, key))
+	assert.Equal(t, Stats{}, store.Stats())
+}
+
+func TestStore_Unwrap(t *testing.T) {
+	store := Wrap(stores.BBolt(t), stores.BBolt(t))
+
+	err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+		assert.Nil(t, tx.Unwrap())
+		return nil
+	})
+
+	assert.NoError(t, err)
+}
+
+func TestStore_Cutover(t *testing.T) {
+	old, new := stores.BBolt(t), stores.BBolt(t)
+	store := Wrap(old, new)
+	require.NoError(t, new.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+		return writer.Put(key, []byte("only in new"))
+	}))
+	assert.Equal(t, []byte(nil), get(t, store, key))
+
+	store.Cutover()
+
+	assert.Equal(t, []byte("only in new"), get(t, store, key))
+	// writes now go to new first, so old being unavailable isn't fatal
+	_ = old.Close(ctx)
+	err := store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+		return writer.Put(key, value)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, value, get(t, new, key))
+	assert.Equal(t, uint64(1), store.Stats().SecondaryWriteFailures)
+}
+
+func TestStore_ShadowReads(t *testing.T) {
+	old, new := stores.BBolt(t), stores.BBolt(t)
+	store := Wrap(old, new, WithReadPrimary(New), WithShadowReads())
+	require.NoError(t, store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+		return writer.Put(key, value)
+	}))
+	require.NoError(t, old.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+		return writer.Put(stoabs.BytesKey{1}, value)
+	}))
+
+	assert.Equal(t, value, get(t, store, key))
+	assert.Equal(t, uint64(0), store.Stats().ReadDivergences)
+	assert.Nil(t, get(t, store, stoabs.BytesKey{1}))
+	assert.Equal(t, uint64(1), store.Stats().ReadDivergences)
+}
+
+func get(t *testing.T, store stoabs.KVStore, key stoabs.Key) []byte {
+	var result []byte
+	err := store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+		var err error
+		result, err = reader.Get(key)
+		return err
+	})
+	if !errors.Is(err, stoabs.ErrKeyNotFound) {
+		require.NoError(t, err)
+	}
+	return result
+}
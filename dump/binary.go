@@ -0,0 +1,215 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package dump
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"github.com/nuts-foundation/go-stoabs"
+)
+
+// The binary format consists of a header followed by blocks. Each block is framed as:
+//
+//	tag (1 byte) | payload length (uvarint) | payload | CRC-32C of the preceding fields (4 bytes, big endian)
+//
+// A shelf block (payload: shelf name) starts a shelf, entry blocks (payload: uvarint key length | key | value) that
+// follow it belong to that shelf. The last block is the manifest (payload: uvarint total entries | uvarint number of shelves |
+// per shelf: uvarint name length | name | uvarint entries | CRC-32C of all bytes preceding the manifest block).
+const (
+	tagShelf    byte = 1
+	tagEntry    byte = 2
+	tagManifest byte = 3
+)
+
+// binaryHeader identifies the binary format (magic and version).
+var binaryHeader = []byte{'S', 'T', 'O', 'A', 'B', 'S', 0, 1}
+
+// defaultMaxBlockSize limits the size of a single block, to avoid huge allocations when reading corrupt input.
+const defaultMaxBlockSize = 1 << 30
+
+var maxBlockSize uint64 = defaultMaxBlockSize
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrCorrupt is returned by ImportBinary when the input is not a valid binary export, or fails integrity checks.
+var ErrCorrupt = errors.New("corrupt binary export")
+
+// ExportBinary writes all entries of the given shelves to w in a compact binary format with per-block checksums
+// and a trailing manifest, suitable for large stores. Shelf selection and consistency are the same as for Export.
+func ExportBinary(ctx context.Context, store stoabs.KVStore, w io.Writer, shelves ...string) error {
+	writer := &blockWriter{w: bufio.NewWriter(w), stream: crc32.New(crcTable)}
+	if err := writer.write(binaryHeader); err != nil {
+		return err
+	}
+	var manifest manifest
+	err := export(ctx, store, shelves, func(record Record) error {
+		if len(manifest.shelves) == 0 || manifest.shelves[len(manifest.shelves)-1].name != record.Shelf {
+			manifest.shelves = append(manifest.shelves, manifestShelf{name: record.Shelf})
+			if err := writer.block(tagShelf, []byte(record.Shelf)); err != nil {
+				return err
+			}
+		}
+		manifest.shelves[len(manifest.shelves)-1].entries++
+		manifest.entries++
+		payload := binary.AppendUvarint(nil, uint64(len(record.Key)))
+		payload = append(payload, record.Key...)
+		return writer.block(tagEntry, append(payload, record.Value...))
+	})
+	if err != nil {
+		return err
+	}
+	if err := writer.block(tagManifest, manifest.marshal(writer.stream.Sum32())); err != nil {
+		return err
+	}
+	return writer.w.Flush()
+}
+
+// ImportBinary reads a binary export as written by ExportBinary from r and writes the entries to the store.
+// Every block is verified before it is imported and the manifest is checked at the end.
+// Integrity errors are reported as ErrCorrupt. Batching and error semantics are the same as for Import,
+// meaning entries read before a corrupt block was encountered may have been imported already.
+func ImportBinary(ctx context.Context, store stoabs.KVStore, r io.Reader) error {
+	reader := &blockReader{r: bufio.NewReader(r), stream: crc32.New(crcTable)}
+	header := make([]byte, len(binaryHeader))
+	if err := reader.read(header); err != nil || !bytes.Equal(header, binaryHeader) {
+		return fmt.Errorf("%w: invalid header", ErrCorrupt)
+	}
+	var actual manifest
+	shelf := ""
+	return importRecords(ctx, store, func() (*Record, error) {
+		for {
+			streamSum := reader.stream.Sum32()
+			tag, payload, err := reader.block()
+			if err != nil {
+				return nil, err
+			}
+			switch tag {
+			case tagShelf:
+				shelf = string(payload)
+				actual.shelves = append(actual.shelves, manifestShelf{name: shelf})
+			case tagEntry:
+				if len(actual.shelves) == 0 {
+					return nil, fmt.Errorf("%w: entry outside shelf", ErrCorrupt)
+				}
+				keyLength, n := binary.Uvarint(payload)
+				if n <= 0 || keyLength > uint64(len(payload)-n) {
+					return nil, fmt.Errorf("%w: invalid entry", ErrCorrupt)
+				}
+				key := payload[n : n+int(keyLength)]
+				actual.shelves[len(actual.shelves)-1].entries++
+				actual.entries++
+				return &Record{Shelf: shelf, Key: key, Value: payload[n+int(keyLength):]}, nil
+			case tagManifest:
+				if !bytes.Equal(payload, actual.marshal(streamSum)) {
+					return nil, fmt.Errorf("%w: manifest mismatch", ErrCorrupt)
+				}
+				return nil, nil
+			default:
+				return nil, fmt.Errorf("%w: unknown block type %d", ErrCorrupt, tag)
+			}
+		}
+	})
+}
+
+type manifest struct {
+	entries uint64
+	shelves []manifestShelf
+}
+
+type manifestShelf struct {
+	name    string
+	entries uint64
+}
+
+func (m manifest) marshal(streamSum uint32) []byte {
+	result := binary.AppendUvarint(nil, m.entries)
+	result = binary.AppendUvarint(result, uint64(len(m.shelves)))
+	for _, shelf := range m.shelves {
+		result = binary.AppendUvarint(result, uint64(len(shelf.name)))
+		result = append(result, shelf.name...)
+		result = binary.AppendUvarint(result, shelf.entries)
+	}
+	return binary.BigEndian.AppendUint32(result, streamSum)
+}
+
+type blockWriter struct {
+	w *bufio.Writer
+	// stream holds the checksum of all bytes written so far
+	stream hash.Hash32
+}
+
+func (b *blockWriter) block(tag byte, payload []byte) error {
+	// the importer rejects larger blocks, so fail now instead of producing an export that can't be imported
+	if uint64(len(payload)) > maxBlockSize {
+		return fmt.Errorf("block too large to export (%d bytes, max %d)", len(payload), maxBlockSize)
+	}
+	frame := binary.AppendUvarint([]byte{tag}, uint64(len(payload)))
+	frame = append(frame, payload...)
+	return b.write(binary.BigEndian.AppendUint32(frame, crc32.Checksum(frame, crcTable)))
+}
+
+func (b *blockWriter) write(data []byte) error {
+	_, _ = b.stream.Write(data)
+	_, err := b.w.Write(data)
+	return err
+}
+
+type blockReader struct {
+	r *bufio.Reader
+	// stream holds the checksum of all bytes read so far
+	stream hash.Hash32
+}
+
+func (b *blockReader) block() (byte, []byte, error) {
+	frame := make([]byte, 1, 1+binary.MaxVarintLen64)
+	if err := b.read(frame); err != nil {
+		return 0, nil, fmt.Errorf("%w: unexpected end of input (missing manifest)", ErrCorrupt)
+	}
+	length, err := binary.ReadUvarint(b.r)
+	if err != nil || length > maxBlockSize {
+		return 0, nil, fmt.Errorf("%w: invalid block length", ErrCorrupt)
+	}
+	frame = binary.AppendUvarint(frame, length)
+	_, _ = b.stream.Write(frame[1:])
+	payload := make([]byte, length+4)
+	if err := b.read(payload); err != nil {
+		return 0, nil, fmt.Errorf("%w: unexpected end of input", ErrCorrupt)
+	}
+	frame = append(frame, payload[:length]...)
+	if crc32.Checksum(frame, crcTable) != binary.BigEndian.Uint32(payload[length:]) {
+		return 0, nil, fmt.Errorf("%w: checksum mismatch", ErrCorrupt)
+	}
+	return frame[0], payload[:length], nil
+}
+
+func (b *blockReader) read(data []byte) error {
+	if _, err := io.ReadFull(b.r, data); err != nil {
+		return err
+	}
+	_, _ = b.stream.Write(data)
+	return nil
+}
@@ -0,0 +1,131 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package dump
+
+import (
+	"bufio"
+	"bytes"
+	"hash/crc32"
+	"testing"
+
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportBinary(t *testing.T) {
+	t.Run("round trip", func(t *testing.T) {
+		source := stores.BBolt(t)
+		writeTestData(t, source)
+		buf := new(bytes.Buffer)
+		require.NoError(t, ExportBinary(ctx, source, buf))
+		assert.True(t, bytes.HasPrefix(buf.Bytes(), binaryHeader))
+		target := stores.BBolt(t)
+
+		err := ImportBinary(ctx, target, buf)
+
+		require.NoError(t, err)
+		assert.Equal(t, exportToString(t, source), exportToString(t, target))
+	})
+	t.Run("empty export", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		require.NoError(t, ExportBinary(ctx, stores.BBolt(t), buf))
+
+		err := ImportBinary(ctx, stores.BBolt(t), buf)
+
+		assert.NoError(t, err)
+	})
+	t.Run("entry exceeds max block size", func(t *testing.T) {
+		defer func() {
+			maxBlockSize = defaultMaxBlockSize
+		}()
+		maxBlockSize = 4
+		source := stores.BBolt(t)
+		writeTestData(t, source)
+
+		err := ExportBinary(ctx, source, new(bytes.Buffer))
+
+		assert.ErrorContains(t, err, "block too large to export")
+	})
+}
+
+func TestImportBinary(t *testing.T) {
+	source := stores.BBolt(t)
+	writeTestData(t, source)
+	buf := new(bytes.Buffer)
+	require.NoError(t, ExportBinary(ctx, source, buf))
+	valid := buf.Bytes()
+
+	t.Run("invalid header", func(t *testing.T) {
+		err := ImportBinary(ctx, stores.BBolt(t), bytes.NewReader([]byte("{}")))
+
+		assert.ErrorIs(t, err, ErrCorrupt)
+		assert.EqualError(t, err, "corrupt binary export: invalid header")
+	})
+	t.Run("flipped bit", func(t *testing.T) {
+		corrupt := append([]byte{}, valid...)
+		corrupt[len(binaryHeader)+3] ^= 1
+
+		err := ImportBinary(ctx, stores.BBolt(t), bytes.NewReader(corrupt))
+
+		assert.ErrorIs(t, err, ErrCorrupt)
+		assert.EqualError(t, err, "corrupt binary export: checksum mismatch")
+	})
+	t.Run("truncated", func(t *testing.T) {
+		err := ImportBinary(ctx, stores.BBolt(t), bytes.NewReader(valid[:len(valid)-10]))
+
+		assert.ErrorIs(t, err, ErrCorrupt)
+	})
+	t.Run("missing manifest", func(t *testing.T) {
+		// cut off the manifest block, leaving intact blocks only
+		err := ImportBinary(ctx, stores.BBolt(t), bytes.NewReader(valid[:len(valid)-manifestLength(t, valid)]))
+
+		assert.EqualError(t, err, "corrupt binary export: unexpected end of input (missing manifest)")
+	})
+	t.Run("manifest mismatch", func(t *testing.T) {
+		// replace the manifest by a valid block holding a manifest with an incorrect number of entries
+		payload := valid[:len(valid)-manifestLength(t, valid)]
+		buf := new(bytes.Buffer)
+		writer := &blockWriter{w: bufio.NewWriter(buf), stream: crc32.New(crcTable)}
+		require.NoError(t, writer.write(payload))
+		incorrect := manifest{entries: 4, shelves: []manifestShelf{{name: "a", entries: 2}, {name: "b", entries: 2}}}
+		require.NoError(t, writer.block(tagManifest, incorrect.marshal(writer.stream.Sum32())))
+		require.NoError(t, writer.w.Flush())
+		corrupt := buf.Bytes()
+
+		err := ImportBinary(ctx, stores.BBolt(t), bytes.NewReader(corrupt))
+
+		assert.EqualError(t, err, "corrupt binary export: manifest mismatch")
+	})
+}
+
+// manifestLength returns the length of the manifest block at the end of the given export.
+func manifestLength(t *testing.T, export []byte) int {
+	for i := len(export) - 1; i >= 0; i-- {
+		if export[i] == tagManifest {
+			// tag | length | payload | crc
+			if int(export[i+1])+6 == len(export)-i {
+				return len(export) - i
+			}
+		}
+	}
+	t.Fatal("manifest not found")
+	return 0
+}
@@ -0,0 +1,137 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package dump exports shelves of a KVStore to a stream and imports them again, independent of the backend.
+package dump
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/nuts-foundation/go-stoabs"
+)
+
+// importBatchSize specifies how many records are written in a single transaction when importing.
+const importBatchSize = 1000
+
+// Record is a single shelf/key/value triple as it appears in an export.
+// Key and value are base64 encoded when marshalled to JSON.
+type Record struct {
+	Shelf string `json:"shelf"`
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// Export writes all entries of the given shelves to w as newline-delimited JSON (one Record per line).
+// If no shelves are given, all shelves of the store are exported, which requires the store to implement stoabs.ShelfLister.
+// Reserved shelves used internally by stoabs are only exported if the context includes them (see stoabs.ContextWithReservedShelves).
+// The shelves are read in a single read transaction.
+// Keys are read as stoabs.BytesKey, so backends that store keys in their string representation (Redis) can only be exported
+// when their keys were written as stoabs.BytesKey or stoabs.HashKey.
+func Export(ctx context.Context, store stoabs.KVStore, w io.Writer, shelves ...string) error {
+	buffered := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buffered)
+	if err := export(ctx, store, shelves, func(record Record) error {
+		return encoder.Encode(record)
+	}); err != nil {
+		return err
+	}
+	return buffered.Flush()
+}
+
+// Import reads newline-delimited JSON as written by Export from r and writes the records to the store.
+// Records are written in batches, each batch in its own transaction. When an error occurs,
+// batches that were written before the error are not rolled back.
+func Import(ctx context.Context, store stoabs.KVStore, r io.Reader) error {
+	decoder := json.NewDecoder(bufio.NewReader(r))
+	line := 0
+	return importRecords(ctx, store, func() (*Record, error) {
+		var record Record
+		line++
+		if err := decoder.Decode(&record); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("invalid record (line %d): %w", line, err)
+		}
+		if record.Shelf == "" {
+			return nil, fmt.Errorf("invalid record (line %d): missing shelf", line)
+		}
+		return &record, nil
+	})
+}
+
+func export(ctx context.Context, store stoabs.KVStore, shelves []string, visitor func(record Record) error) error {
+	if len(shelves) == 0 {
+		var err error
+		shelves, err = stoabs.ShelfNames(ctx, store)
+		if err != nil {
+			return fmt.Errorf("unable to list shelves: %w", err)
+		}
+	}
+	return store.Read(ctx, func(tx stoabs.ReadTx) error {
+		for _, shelf := range shelves {
+			err := tx.GetShelfReader(shelf).Iterate(func(key stoabs.Key, value []byte) error {
+				return visitor(Record{Shelf: shelf, Key: key.Bytes(), Value: value})
+			}, stoabs.BytesKey{})
+			if err != nil {
+				return fmt.Errorf("unable to export shelf %s: %w", shelf, err)
+			}
+		}
+		return nil
+	})
+}
+
+// importRecords reads records using next until it returns nil, and writes them to the store in batches.
+func importRecords(ctx context.Context, store stoabs.KVStore, next func() (*Record, error)) error {
+	batch := make([]Record, 0, importBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+			for _, record := range batch {
+				if err := tx.GetShelfWriter(record.Shelf).Put(stoabs.BytesKey(record.Key), record.Value); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		batch = batch[:0]
+		return err
+	}
+	for {
+		record, err := next()
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			return flush()
+		}
+		batch = append(batch, *record)
+		if len(batch) == importBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,160 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package dump
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+var ctx = context.Background()
+
+func TestExport(t *testing.T) {
+	t.Run("all shelves", func(t *testing.T) {
+		store := stores.BBolt(t)
+		writeTestData(t, store)
+		buf := new(bytes.Buffer)
+
+		err := Export(ctx, store, buf)
+
+		require.NoError(t, err)
+		assert.Equal(t, `{"shelf":"a","key":"AQ==","value":"dmFsdWUx"}
+{"shelf":"a","key":"Ag==","value":"dmFsdWUy"}
+{"shelf":"b","key":"AQ==","value":""}
+`, buf.String())
+	})
+	t.Run("reserved shelves", func(t *testing.T) {
+		store := stores.BBolt(t)
+		writeTestData(t, store)
+		err := store.WriteShelf(ctx, stoabs.ReservedShelfPrefix+"internal", func(writer stoabs.Writer) error {
+			return writer.Put(stoabs.BytesKey{1}, []byte("value"))
+		})
+		require.NoError(t, err)
+		reserved := `{"shelf":"_stoabs/internal","key":"AQ==","value":"dmFsdWU="}
+`
+
+		t.Run("excluded by default", func(t *testing.T) {
+			buf := new(bytes.Buffer)
+
+			err := Export(ctx, store, buf)
+
+			require.NoError(t, err)
+			assert.NotContains(t, buf.String(), reserved)
+		})
+		t.Run("included", func(t *testing.T) {
+			buf := new(bytes.Buffer)
+
+			err := Export(stoabs.ContextWithReservedShelves(ctx), store, buf)
+
+			require.NoError(t, err)
+			assert.True(t, strings.HasPrefix(buf.String(), reserved))
+		})
+	})
+	t.Run("selected shelves", func(t *testing.T) {
+		store := stores.BBolt(t)
+		writeTestData(t, store)
+		buf := new(bytes.Buffer)
+
+		err := Export(ctx, store, buf, "b", "non-existing")
+
+		require.NoError(t, err)
+		assert.Equal(t, `{"shelf":"b","key":"AQ==","value":""}
+`, buf.String())
+	})
+	t.Run("store can't list shelves", func(t *testing.T) {
+		store := stoabs.NewMockKVStore(gomock.NewController(t))
+
+		err := Export(ctx, store, new(bytes.Buffer))
+
+		assert.ErrorIs(t, err, errors.ErrUnsupported)
+	})
+}
+
+func TestImport(t *testing.T) {
+	t.Run("round trip", func(t *testing.T) {
+		source := stores.BBolt(t)
+		writeTestData(t, source)
+		buf := new(bytes.Buffer)
+		require.NoError(t, Export(ctx, source, buf))
+		target := stores.BBolt(t)
+
+		err := Import(ctx, target, buf)
+
+		require.NoError(t, err)
+		exported := new(bytes.Buffer)
+		require.NoError(t, Export(ctx, target, exported))
+		assert.Equal(t, exportToString(t, source), exported.String())
+	})
+	t.Run("more records than batch size", func(t *testing.T) {
+		source := stores.BBolt(t)
+		err := source.WriteShelf(ctx, "large", func(writer stoabs.Writer) error {
+			for i := 0; i < importBatchSize*2+1; i++ {
+				if err := writer.Put(stoabs.Uint32Key(i), []byte("x")); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		buf := new(bytes.Buffer)
+		require.NoError(t, Export(ctx, source, buf))
+		target := stores.BBolt(t)
+
+		err = Import(ctx, target, buf)
+
+		require.NoError(t, err)
+		assert.Equal(t, exportToString(t, source), exportToString(t, target))
+	})
+	t.Run("invalid JSON", func(t *testing.T) {
+		err := Import(ctx, stores.BBolt(t), strings.NewReader(`{"shelf":"a","key":"AQ==","value":""}
+{"shelf":`))
+
+		assert.ErrorContains(t, err, "invalid record (line 2)")
+	})
+	t.Run("missing shelf", func(t *testing.T) {
+		err := Import(ctx, stores.BBolt(t), strings.NewReader(`{"key":"AQ==","value":""}`))
+
+		assert.EqualError(t, err, "invalid record (line 1): missing shelf")
+	})
+}
+
+func writeTestData(t *testing.T, store stoabs.KVStore) {
+	err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+		a := tx.GetShelfWriter("a")
+		_ = a.Put(stoabs.BytesKey{1}, []byte("value1"))
+		_ = a.Put(stoabs.BytesKey{2}, []byte("value2"))
+		return tx.GetShelfWriter("b").Put(stoabs.BytesKey{1}, []byte{})
+	})
+	require.NoError(t, err)
+}
+
+func exportToString(t *testing.T, store stoabs.KVStore) string {
+	buf := new(bytes.Buffer)
+	require.NoError(t, Export(ctx, store, buf))
+	return buf.String()
+}
@@ -0,0 +1,383 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package encrypt provides a KVStore that encrypts values at rest using AES-GCM.
+package encrypt
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/nuts-foundation/go-stoabs"
+)
+
+var _ stoabs.KVStore = (*Store)(nil)
+var _ stoabs.ShelfLister = (*Store)(nil)
+var _ stoabs.WriteTx = (*tx)(nil)
+var _ stoabs.Writer = (*shelf)(nil)
+
+// envelopeVersion is the first byte of every encrypted value, identifying the envelope format:
+//
+//	envelope version (1 byte) | key version (4 bytes, big endian) | nonce (12 bytes) | ciphertext and GCM tag
+const envelopeVersion byte = 1
+
+const envelopeHeaderSize = 1 + 4
+
+// ErrDecryptionFailed is returned when a value can't be decrypted, because it was tampered with,
+// moved to another key or shelf, or encrypted with an unknown key.
+var ErrDecryptionFailed = errors.New("unable to decrypt value")
+
+// Keyring supplies the keys used to encrypt and decrypt values. Keys are identified by a version,
+// which is stored with every encrypted value so the key can be rotated without re-encrypting all data at once.
+// Implement it to retrieve keys from a KMS. Keys of a given version must never change.
+type Keyring interface {
+	// CurrentKey returns the version and AES key (16, 24 or 32 bytes) that is used to encrypt new values.
+	CurrentKey(ctx context.Context) (uint32, []byte, error)
+	// Key returns the AES key with the given version, used to decrypt values.
+	Key(ctx context.Context, version uint32) ([]byte, error)
+}
+
+// NewStaticKeyring returns a Keyring holding the given keys, encrypting new values with the key of the current version.
+func NewStaticKeyring(current uint32, keys map[uint32][]byte) Keyring {
+	return staticKeyring{current: current, keys: keys}
+}
+
+type staticKeyring struct {
+	current uint32
+	keys    map[uint32][]byte
+}
+
+func (s staticKeyring) CurrentKey(ctx context.Context) (uint32, []byte, error) {
+	key, err := s.Key(ctx, s.current)
+	return s.current, key, err
+}
+
+func (s staticKeyring) Key(_ context.Context, version uint32) ([]byte, error) {
+	key, ok := s.keys[version]
+	if !ok {
+		return nil, fmt.Errorf("unknown key version: %d", version)
+	}
+	return key, nil
+}
+
+// Option configures the encrypting store.
+type Option func(s *Store)
+
+// WithKeyHashing replaces keys by their HMAC-SHA256 (using the given secret) before they're stored,
+// so the keys themselves don't reveal information either. Since the original keys can't be recovered,
+// Iterate yields the hashed keys (as stoabs.BytesKey) and Range is not supported.
+func WithKeyHashing(secret []byte) Option {
+	return func(s *Store) {
+		s.hashSecret = secret
+	}
+}
+
+// Wrap creates a store that encrypts values before writing them to the underlying store, and decrypts them when read.
+// Each value is encrypted using AES-GCM with a random nonce, binding it to its shelf and key (as additional data).
+// Keys and shelf names are stored in plaintext, unless WithKeyHashing is used.
+func Wrap(store stoabs.KVStore, keyring Keyring, opts ...Option) *Store {
+	result := &Store{
+		underlying: store,
+		keyring:    keyring,
+	}
+	for _, opt := range opts {
+		opt(result)
+	}
+	return result
+}
+
+// Store is a KVStore that encrypts values. Use Wrap to create it.
+type Store struct {
+	underlying stoabs.KVStore
+	keyring    Keyring
+	hashSecret []byte
+	subjects   SubjectKeyring
+	subjectOf  func(shelfName string, key stoabs.Key) string
+	// ciphers caches the cipher.AEAD per key version
+	ciphers sync.Map
+}
+
+// Rotate re-encrypts all values in the given shelf that were not encrypted with the current key, in a single transaction.
+// keyType specifies the key type to use when iterating the shelf (see stoabs.Reader.Iterate); it's ignored when keys are hashed.
+// It returns the number of re-encrypted values.
+func (s *Store) Rotate(ctx context.Context, shelfName string, keyType stoabs.Key) (int, error) {
+	if s.hashSecret != nil {
+		keyType = stoabs.BytesKey{}
+	}
+	count := 0
+	err := s.underlying.Write(ctx, func(underlyingTx stoabs.WriteTx) error {
+		t := &tx{ReadTx: underlyingTx, writeTx: underlyingTx, store: s, ctx: ctx}
+		writer := underlyingTx.GetShelfWriter(shelfName)
+		current, _, err := t.currentAEAD()
+		if err != nil {
+			return err
+		}
+		type entry struct {
+			key   stoabs.Key
+			value []byte
+		}
+		var stale []entry
+		err = writer.Iterate(func(key stoabs.Key, value []byte) error {
+			if len(value) >= envelopeHeaderSize && value[0] == envelopeVersion && binary.BigEndian.Uint32(value[1:envelopeHeaderSize]) == current {
+				return nil
+			}
+			if len(value) > 0 && value[0] == subjectEnvelopeVersion {
+				// encrypted with the key of a subject, which isn't rotated
+				return nil
+			}
+			plaintext, err := t.decrypt(shelfName, key, value)
+			if err != nil {
+				return err
+			}
+			stale = append(stale, entry{key: key, value: plaintext})
+			return nil
+		}, keyType)
+		if err != nil {
+			return err
+		}
+		for _, e := range stale {
+			ciphertext, err := t.encrypt(shelfName, e.key, e.value, "")
+			if err != nil {
+				return err
+			}
+			if err := writer.Put(e.key, ciphertext); err != nil {
+				return err
+			}
+		}
+		count = len(stale)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (s *Store) Close(ctx context.Context) error {
+	return s.underlying.Close(ctx)
+}
+
+func (s *Store) Write(ctx context.Context, fn func(stoabs.WriteTx) error, opts ...stoabs.TxOption) error {
+	return s.underlying.Write(ctx, func(underlyingTx stoabs.WriteTx) error {
+		return fn(&tx{ReadTx: underlyingTx, writeTx: underlyingTx, store: s, ctx: ctx})
+	}, opts...)
+}
+
+func (s *Store) WriteShelf(ctx context.Context, shelfName string, fn func(stoabs.Writer) error) error {
+	return s.Write(ctx, func(tx stoabs.WriteTx) error {
+		return fn(tx.GetShelfWriter(shelfName))
+	})
+}
+
+func (s *Store) Read(ctx context.Context, fn func(stoabs.ReadTx) error) error {
+	return s.underlying.Read(ctx, func(underlyingTx stoabs.ReadTx) error {
+		return fn(&tx{ReadTx: underlyingTx, store: s, ctx: ctx})
+	})
+}
+
+func (s *Store) ReadShelf(ctx context.Context, shelfName string, fn func(stoabs.Reader) error) error {
+	return s.Read(ctx, func(tx stoabs.ReadTx) error {
+		return fn(tx.GetShelfReader(shelfName))
+	})
+}
+
+// ShelfNames returns the shelves of the underlying store. Shelf names are not encrypted.
+func (s *Store) ShelfNames(ctx context.Context) ([]string, error) {
+	return stoabs.ShelfNames(ctx, s.underlying)
+}
+
+func (s *Store) aead(ctx context.Context, version uint32, key []byte) (cipher.AEAD, error) {
+	if cached, ok := s.ciphers.Load(version); ok {
+		return cached.(cipher.AEAD), nil
+	}
+	if key == nil {
+		var err error
+		if key, err = s.keyring.Key(ctx, version); err != nil {
+			return nil, fmt.Errorf("unable to retrieve key (version=%d): %w", version, err)
+		}
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key (version=%d): %w", version, err)
+	}
+	result, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	s.ciphers.Store(version, result)
+	return result, nil
+}
+
+func (s *Store) storeKey(key stoabs.Key) stoabs.Key {
+	if s.hashSecret == nil {
+		return key
+	}
+	mac := hmac.New(sha256.New, s.hashSecret)
+	mac.Write(key.Bytes())
+	return stoabs.BytesKey(mac.Sum(nil))
+}
+
+type tx struct {
+	stoabs.ReadTx
+	writeTx stoabs.WriteTx
+	store   *Store
+	ctx     context.Context
+	// current is the AEAD used to encrypt values written in this transaction, retrieved on first use.
+	current        cipher.AEAD
+	currentVersion uint32
+}
+
+func (t *tx) GetShelfReader(shelfName string) stoabs.Reader {
+	return &shelf{Reader: t.ReadTx.GetShelfReader(shelfName), name: shelfName, tx: t}
+}
+
+func (t *tx) GetShelfWriter(shelfName string) stoabs.Writer {
+	writer := t.writeTx.GetShelfWriter(shelfName)
+	return &shelf{Reader: writer, writer: writer, name: shelfName, tx: t}
+}
+
+func (t *tx) Store() stoabs.KVStore {
+	return t.store
+}
+
+func (t *tx) currentAEAD() (uint32, cipher.AEAD, error) {
+	if t.current == nil {
+		version, key, err := t.store.keyring.CurrentKey(t.ctx)
+		if err != nil {
+			return 0, nil, fmt.Errorf("unable to retrieve current key: %w", err)
+		}
+		if t.current, err = t.store.aead(t.ctx, version, key); err != nil {
+			return 0, nil, err
+		}
+		t.currentVersion = version
+	}
+	return t.currentVersion, t.current, nil
+}
+
+// encrypt encrypts the value with the current key, or with the key of the given subject (if not empty).
+func (t *tx) encrypt(shelfName string, key stoabs.Key, plaintext []byte, subjectID string) ([]byte, error) {
+	if subjectID != "" {
+		return t.encryptForSubject(shelfName, key, plaintext, subjectID)
+	}
+	version, aead, err := t.currentAEAD()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]byte, envelopeHeaderSize+aead.NonceSize(), envelopeHeaderSize+aead.NonceSize()+len(plaintext)+aead.Overhead())
+	result[0] = envelopeVersion
+	binary.BigEndian.PutUint32(result[1:], version)
+	nonce := result[envelopeHeaderSize:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(result, nonce, plaintext, additionalData(shelfName, key)), nil
+}
+
+func (t *tx) decrypt(shelfName string, key stoabs.Key, envelope []byte) ([]byte, error) {
+	if len(envelope) > 0 && envelope[0] == subjectEnvelopeVersion {
+		return t.decryptForSubject(shelfName, key, envelope)
+	}
+	if len(envelope) < envelopeHeaderSize || envelope[0] != envelopeVersion {
+		return nil, fmt.Errorf("%w: invalid envelope", ErrDecryptionFailed)
+	}
+	aead, err := t.store.aead(t.ctx, binary.BigEndian.Uint32(envelope[1:]), nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecryptionFailed, err)
+	}
+	if len(envelope) < envelopeHeaderSize+aead.NonceSize() {
+		return nil, fmt.Errorf("%w: invalid envelope", ErrDecryptionFailed)
+	}
+	nonce := envelope[envelopeHeaderSize : envelopeHeaderSize+aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, envelope[envelopeHeaderSize+aead.NonceSize():], additionalData(shelfName, key))
+	if err != nil {
+		return nil, fmt.Errorf("%w (shelf=%s, key=%s)", ErrDecryptionFailed, shelfName, key)
+	}
+	return plaintext, nil
+}
+
+// additionalData binds a value to its shelf and (stored) key, so it can't be moved elsewhere without detection.
+func additionalData(shelfName string, key stoabs.Key) []byte {
+	result := binary.AppendUvarint(nil, uint64(len(shelfName)))
+	result = append(result, shelfName...)
+	return append(result, key.Bytes()...)
+}
+
+type shelf struct {
+	stoabs.Reader
+	writer stoabs.Writer
+	name   string
+	tx     *tx
+}
+
+func (s *shelf) Get(key stoabs.Key) ([]byte, error) {
+	storeKey := s.tx.store.storeKey(key)
+	value, err := s.Reader.Get(storeKey)
+	if err != nil {
+		return nil, err
+	}
+	return s.tx.decrypt(s.name, storeKey, value)
+}
+
+func (s *shelf) Iterate(callback stoabs.CallerFn, keyType stoabs.Key) error {
+	if s.tx.store.hashSecret != nil {
+		keyType = stoabs.BytesKey{}
+	}
+	return s.Reader.Iterate(s.decryptingCallback(callback), keyType)
+}
+
+func (s *shelf) Range(from stoabs.Key, to stoabs.Key, callback stoabs.CallerFn, stopAtNil bool) error {
+	if s.tx.store.hashSecret != nil {
+		return fmt.Errorf("range over hashed keys: %w", errors.ErrUnsupported)
+	}
+	return s.Reader.Range(from, to, s.decryptingCallback(callback), stopAtNil)
+}
+
+func (s *shelf) decryptingCallback(callback stoabs.CallerFn) stoabs.CallerFn {
+	return func(key stoabs.Key, value []byte) error {
+		plaintext, err := s.tx.decrypt(s.name, key, value)
+		if err != nil {
+			return err
+		}
+		return callback(key, plaintext)
+	}
+}
+
+func (s *shelf) Put(key stoabs.Key, value []byte) error {
+	storeKey := s.tx.store.storeKey(key)
+	var subjectID string
+	if s.tx.store.subjectOf != nil {
+		subjectID = s.tx.store.subjectOf(s.name, key)
+	}
+	ciphertext, err := s.tx.encrypt(s.name, storeKey, value, subjectID)
+	if err != nil {
+		return err
+	}
+	return s.writer.Put(storeKey, ciphertext)
+}
+
+func (s *shelf) Delete(key stoabs.Key) error {
+	return s.writer.Delete(s.tx.store.storeKey(key))
+}
@@ -0,0 +1,200 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package encrypt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+var key = stoabs.BytesKey("key")
+var value = []byte("sensitive value")
+
+const shelfName = "test"
+
+var key1 = bytes.Repeat([]byte{1}, 32)
+var key2 = bytes.Repeat([]byte{2}, 32)
+
+func TestEncrypt(t *testing.T) {
+	provider := func(t *testing.T) (stoabs.KVStore, error) {
+		return Wrap(stores.BBolt(t), NewStaticKeyring(1, map[uint32][]byte{1: key1})), nil
+	}
+
+	kvtests.TestReadingAndWriting(t, provider)
+	kvtests.TestRange(t, provider)
+	kvtests.TestIterate(t, provider)
+	kvtests.TestEmpty(t, provider)
+	kvtests.TestDelete(t, provider)
+	kvtests.TestWriteTransactions(t, provider)
+	kvtests.TestShelfNames(t, provider)
+}
+
+func TestStore_Put(t *testing.T) {
+	t.Run("value is encrypted in underlying store", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		store := Wrap(underlying, NewStaticKeyring(1, map[uint32][]byte{1: key1}))
+
+		require.NoError(t, put(store, key, value))
+
+		raw := get(t, underlying, key)
+		assert.NotContains(t, string(raw), string(value))
+		assert.Equal(t, envelopeVersion, raw[0])
+		assert.Equal(t, value, get(t, store, key))
+	})
+	t.Run("keyring error", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t), NewStaticKeyring(2, map[uint32][]byte{1: key1}))
+
+		err := put(store, key, value)
+
+		assert.EqualError(t, err, "unable to retrieve current key: unknown key version: 2")
+	})
+	t.Run("invalid key", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t), NewStaticKeyring(1, map[uint32][]byte{1: {1, 2, 3}}))
+
+		err := put(store, key, value)
+
+		assert.EqualError(t, err, "invalid key (version=1): crypto/aes: invalid key size 3")
+	})
+}
+
+func TestStore_Get(t *testing.T) {
+	t.Run("wrong key", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		require.NoError(t, put(Wrap(underlying, NewStaticKeyring(1, map[uint32][]byte{1: key1})), key, value))
+		store := Wrap(underlying, NewStaticKeyring(1, map[uint32][]byte{1: key2}))
+
+		err := store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			_, err := reader.Get(key)
+			return err
+		})
+
+		assert.ErrorIs(t, err, ErrDecryptionFailed)
+	})
+	t.Run("value moved to another key", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		store := Wrap(underlying, NewStaticKeyring(1, map[uint32][]byte{1: key1}))
+		require.NoError(t, put(store, key, value))
+		require.NoError(t, put(underlying, stoabs.BytesKey("other"), get(t, underlying, key)))
+
+		err := store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			_, err := reader.Get(stoabs.BytesKey("other"))
+			return err
+		})
+
+		assert.ErrorIs(t, err, ErrDecryptionFailed)
+	})
+	t.Run("plaintext value", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		require.NoError(t, put(underlying, key, value))
+		store := Wrap(underlying, NewStaticKeyring(1, map[uint32][]byte{1: key1}))
+
+		err := store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			_, err := reader.Get(key)
+			return err
+		})
+
+		assert.EqualError(t, err, "unable to decrypt value: invalid envelope")
+	})
+}
+
+func TestStore_Rotate(t *testing.T) {
+	underlying := stores.BBolt(t)
+	require.NoError(t, put(Wrap(underlying, NewStaticKeyring(1, map[uint32][]byte{1: key1})), key, value))
+	store := Wrap(underlying, NewStaticKeyring(2, map[uint32][]byte{1: key1, 2: key2}))
+	require.NoError(t, put(store, stoabs.BytesKey("new"), value))
+
+	count, err := store.Rotate(ctx, shelfName, stoabs.BytesKey{})
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	// old key can now be removed
+	store = Wrap(underlying, NewStaticKeyring(2, map[uint32][]byte{2: key2}))
+	assert.Equal(t, value, get(t, store, key))
+	assert.Equal(t, value, get(t, store, stoabs.BytesKey("new")))
+	count, err = store.Rotate(ctx, shelfName, stoabs.BytesKey{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestWithKeyHashing(t *testing.T) {
+	underlying := stores.BBolt(t)
+	store := Wrap(underlying, NewStaticKeyring(1, map[uint32][]byte{1: key1}), WithKeyHashing([]byte("secret")))
+	require.NoError(t, put(store, key, value))
+
+	t.Run("key is hashed in underlying store", func(t *testing.T) {
+		assert.Nil(t, get(t, underlying, key))
+		assert.Equal(t, value, get(t, store, key))
+	})
+	t.Run("iterate yields hashed keys", func(t *testing.T) {
+		var keys []stoabs.Key
+		err := store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			return reader.Iterate(func(key stoabs.Key, v []byte) error {
+				keys = append(keys, key)
+				assert.Equal(t, value, v)
+				return nil
+			}, stoabs.Uint32Key(0))
+		})
+		require.NoError(t, err)
+		require.Len(t, keys, 1)
+		assert.Len(t, keys[0].Bytes(), 32)
+	})
+	t.Run("range is not supported", func(t *testing.T) {
+		err := store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			return reader.Range(stoabs.BytesKey{0}, stoabs.BytesKey{255}, func(_ stoabs.Key, _ []byte) error {
+				return nil
+			}, false)
+		})
+		assert.ErrorIs(t, err, errors.ErrUnsupported)
+	})
+	t.Run("delete", func(t *testing.T) {
+		require.NoError(t, store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			return writer.Delete(key)
+		}))
+		assert.Nil(t, get(t, store, key))
+	})
+}
+
+func put(store stoabs.KVStore, key stoabs.Key, value []byte) error {
+	return store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+		return writer.Put(key, value)
+	})
+}
+
+func get(t *testing.T, store stoabs.KVStore, key stoabs.Key) []byte {
+	var result []byte
+	err := store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+		var err error
+		result, err = reader.Get(key)
+		return err
+	})
+	if !errors.Is(err, stoabs.ErrKeyNotFound) {
+		require.NoError(t, err)
+	}
+	return result
+}
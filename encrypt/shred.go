@@ -0,0 +1,202 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package encrypt
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/nuts-foundation/go-stoabs"
+)
+
+// subjectEnvelopeVersion is the first byte of values encrypted with the key of a subject:
+//
+//	envelope version (1 byte) | subject ID length (uvarint) | subject ID | nonce (12 bytes) | ciphertext and GCM tag
+const subjectEnvelopeVersion byte = 2
+
+// subjectShelf is the shelf holding the keys of the subjects, see NewSubjectKeyring.
+const subjectShelf = stoabs.ReservedShelfPrefix + "subjects"
+
+const subjectKeySize = 32
+
+// ErrShredded is returned when a value can't be decrypted (or written) because the key of its subject was destroyed.
+var ErrShredded = errors.New("subject key was shredded")
+
+// ErrUnknownSubject is returned when writing a value for a subject that wasn't registered.
+var ErrUnknownSubject = errors.New("unknown subject")
+
+// SubjectKeyring holds a key per subject (e.g. a person), which encrypts the values of that subject only.
+// Destroying the key (Shred) renders all values of the subject unreadable, without having to find and delete them.
+type SubjectKeyring interface {
+	// Register creates the key of the subject, if it doesn't exist yet. It returns ErrShredded if the key was destroyed.
+	Register(ctx context.Context, subjectID string) error
+	// SubjectKey returns the AES key of the subject. It returns ErrUnknownSubject if the subject wasn't registered,
+	// and ErrShredded if its key was destroyed.
+	SubjectKey(ctx context.Context, subjectID string) ([]byte, error)
+	// Shred destroys the key of the subject. Subsequent calls to Register for the subject fail.
+	Shred(ctx context.Context, subjectID string) error
+}
+
+// WithSubjects encrypts values belonging to a subject with the key of that subject, held by the given keyring.
+// The subjectOf function returns the subject of the value stored under the given shelf and (unhashed) key,
+// or an empty string if the value doesn't belong to a subject, in which case it's encrypted with the key of the Keyring.
+// The subject ID is stored (unencrypted) with every value, so use pseudonymous identifiers.
+func WithSubjects(keyring SubjectKeyring, subjectOf func(shelfName string, key stoabs.Key) string) Option {
+	return func(s *Store) {
+		s.subjects = keyring
+		s.subjectOf = subjectOf
+	}
+}
+
+// RegisterSubject creates the key of the given subject, which is required before writing values of the subject.
+func (s *Store) RegisterSubject(ctx context.Context, subjectID string) error {
+	if s.subjects == nil {
+		return errors.New("no subject keyring configured")
+	}
+	return s.subjects.Register(ctx, subjectID)
+}
+
+// Shred destroys the key of the given subject, rendering all of its values unreadable: reading them returns ErrShredded.
+// The values themselves aren't removed.
+func (s *Store) Shred(ctx context.Context, subjectID string) error {
+	if s.subjects == nil {
+		return errors.New("no subject keyring configured")
+	}
+	return s.subjects.Shred(ctx, subjectID)
+}
+
+func (t *tx) subjectAEAD(subjectID string) (cipher.AEAD, error) {
+	if t.store.subjects == nil {
+		return nil, errors.New("no subject keyring configured")
+	}
+	key, err := t.store.subjects.SubjectKey(t.ctx, subjectID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve key of subject %s: %w", subjectID, err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key of subject %s: %w", subjectID, err)
+	}
+	return cipher.NewGCM(block)
+}
+
+func (t *tx) encryptForSubject(shelfName string, key stoabs.Key, plaintext []byte, subjectID string) ([]byte, error) {
+	aead, err := t.subjectAEAD(subjectID)
+	if err != nil {
+		return nil, err
+	}
+	result := binary.AppendUvarint([]byte{subjectEnvelopeVersion}, uint64(len(subjectID)))
+	result = append(result, subjectID...)
+	headerSize := len(result)
+	result = append(result, make([]byte, aead.NonceSize())...)
+	nonce := result[headerSize:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(result, nonce, plaintext, additionalData(shelfName, key)), nil
+}
+
+func (t *tx) decryptForSubject(shelfName string, key stoabs.Key, envelope []byte) ([]byte, error) {
+	length, n := binary.Uvarint(envelope[1:])
+	if n <= 0 || uint64(len(envelope)-1-n) < length {
+		return nil, fmt.Errorf("%w: invalid envelope", ErrDecryptionFailed)
+	}
+	subjectID := string(envelope[1+n : 1+n+int(length)])
+	aead, err := t.subjectAEAD(subjectID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecryptionFailed, err)
+	}
+	headerSize := 1 + n + int(length)
+	if len(envelope) < headerSize+aead.NonceSize() {
+		return nil, fmt.Errorf("%w: invalid envelope", ErrDecryptionFailed)
+	}
+	nonce := envelope[headerSize : headerSize+aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, envelope[headerSize+aead.NonceSize():], additionalData(shelfName, key))
+	if err != nil {
+		return nil, fmt.Errorf("%w (shelf=%s, key=%s)", ErrDecryptionFailed, shelfName, key)
+	}
+	return plaintext, nil
+}
+
+// NewSubjectKeyring returns a SubjectKeyring that stores random keys in the given store. Shredded keys are overwritten by
+// an empty value, so the subject can't be registered again.
+// Keep the keys in another store than the encrypted values (e.g. a small, separate BBolt file that is compacted after
+// shredding, or Redis), since a BBolt file may retain deleted data in its free pages.
+func NewSubjectKeyring(store stoabs.KVStore) SubjectKeyring {
+	return &storeSubjectKeyring{store: store}
+}
+
+type storeSubjectKeyring struct {
+	store stoabs.KVStore
+}
+
+func (k *storeSubjectKeyring) Register(ctx context.Context, subjectID string) error {
+	return k.store.Write(ctx, func(tx stoabs.WriteTx) error {
+		writer := tx.GetShelfWriter(subjectShelf)
+		key, err := writer.Get(stoabs.BytesKey(subjectID))
+		if err == nil {
+			if len(key) == 0 {
+				return ErrShredded
+			}
+			return nil
+		}
+		if !errors.Is(err, stoabs.ErrKeyNotFound) {
+			return err
+		}
+		key = make([]byte, subjectKeySize)
+		if _, err := rand.Read(key); err != nil {
+			return err
+		}
+		return writer.Put(stoabs.BytesKey(subjectID), key)
+	}, stoabs.WithShelfLock(subjectShelf))
+}
+
+func (k *storeSubjectKeyring) SubjectKey(ctx context.Context, subjectID string) ([]byte, error) {
+	var result []byte
+	err := k.store.ReadShelf(ctx, subjectShelf, func(reader stoabs.Reader) error {
+		key, err := reader.Get(stoabs.BytesKey(subjectID))
+		if err != nil {
+			return err
+		}
+		// copied, since the value may only be valid during the transaction
+		result = append([]byte{}, key...)
+		return nil
+	})
+	if errors.Is(err, stoabs.ErrKeyNotFound) || (err == nil && result == nil) {
+		return nil, ErrUnknownSubject
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		return nil, ErrShredded
+	}
+	return result, nil
+}
+
+func (k *storeSubjectKeyring) Shred(ctx context.Context, subjectID string) error {
+	return k.store.Write(ctx, func(tx stoabs.WriteTx) error {
+		return tx.GetShelfWriter(subjectShelf).Put(stoabs.BytesKey(subjectID), []byte{})
+	}, stoabs.WithShelfLock(subjectShelf))
+}
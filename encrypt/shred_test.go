@@ -0,0 +1,128 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package encrypt
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// subjectOfKey returns the part of the key before the first slash as subject, e.g. "alice/email" belongs to "alice".
+func subjectOfKey(_ string, key stoabs.Key) string {
+	subject, _, ok := strings.Cut(string(key.Bytes()), "/")
+	if !ok {
+		return ""
+	}
+	return subject
+}
+
+func TestStore_Shred(t *testing.T) {
+	setup := func(t *testing.T, opts ...Option) (*Store, stoabs.KVStore) {
+		underlying := stores.BBolt(t)
+		keyring := NewSubjectKeyring(stores.BBolt(t))
+		store := Wrap(underlying, NewStaticKeyring(1, map[uint32][]byte{1: key1}), append([]Option{WithSubjects(keyring, subjectOfKey)}, opts...)...)
+		require.NoError(t, store.RegisterSubject(ctx, "alice"))
+		require.NoError(t, store.RegisterSubject(ctx, "bob"))
+		return store, underlying
+	}
+
+	t.Run("values of a subject are encrypted with its key", func(t *testing.T) {
+		store, underlying := setup(t)
+
+		require.NoError(t, put(store, stoabs.BytesKey("alice/email"), value))
+		require.NoError(t, put(store, key, value))
+
+		raw := get(t, underlying, stoabs.BytesKey("alice/email"))
+		assert.Equal(t, subjectEnvelopeVersion, raw[0])
+		assert.NotContains(t, string(raw), string(value))
+		assert.Equal(t, value, get(t, store, stoabs.BytesKey("alice/email")))
+		assert.Equal(t, envelopeVersion, get(t, underlying, key)[0])
+	})
+	t.Run("shredded values can't be read", func(t *testing.T) {
+		store, _ := setup(t)
+		require.NoError(t, put(store, stoabs.BytesKey("alice/email"), value))
+		require.NoError(t, put(store, stoabs.BytesKey("bob/email"), value))
+		require.NoError(t, put(store, key, value))
+
+		require.NoError(t, store.Shred(ctx, "alice"))
+
+		err := store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			_, err := reader.Get(stoabs.BytesKey("alice/email"))
+			return err
+		})
+		assert.ErrorIs(t, err, ErrShredded)
+		assert.ErrorIs(t, err, ErrDecryptionFailed)
+		assert.Equal(t, value, get(t, store, stoabs.BytesKey("bob/email")))
+		assert.Equal(t, value, get(t, store, key))
+	})
+	t.Run("writing for a shredded subject fails", func(t *testing.T) {
+		store, _ := setup(t)
+		require.NoError(t, store.Shred(ctx, "alice"))
+
+		assert.ErrorIs(t, put(store, stoabs.BytesKey("alice/email"), value), ErrShredded)
+		assert.ErrorIs(t, store.RegisterSubject(ctx, "alice"), ErrShredded)
+	})
+	t.Run("writing for an unknown subject fails", func(t *testing.T) {
+		store, _ := setup(t)
+
+		err := put(store, stoabs.BytesKey("carol/email"), value)
+
+		assert.ErrorIs(t, err, ErrUnknownSubject)
+	})
+	t.Run("registering twice keeps the key", func(t *testing.T) {
+		store, _ := setup(t)
+		require.NoError(t, put(store, stoabs.BytesKey("alice/email"), value))
+
+		require.NoError(t, store.RegisterSubject(ctx, "alice"))
+
+		assert.Equal(t, value, get(t, store, stoabs.BytesKey("alice/email")))
+	})
+	t.Run("with key hashing", func(t *testing.T) {
+		store, _ := setup(t, WithKeyHashing([]byte("secret")))
+		require.NoError(t, put(store, stoabs.BytesKey("alice/email"), value))
+		assert.Equal(t, value, get(t, store, stoabs.BytesKey("alice/email")))
+
+		require.NoError(t, store.Shred(ctx, "alice"))
+
+		err := store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			_, err := reader.Get(stoabs.BytesKey("alice/email"))
+			return err
+		})
+		assert.ErrorIs(t, err, ErrShredded)
+	})
+	t.Run("rotate skips values of subjects", func(t *testing.T) {
+		store, _ := setup(t)
+		require.NoError(t, put(store, stoabs.BytesKey("alice/email"), value))
+
+		count, err := store.Rotate(ctx, shelfName, stoabs.BytesKey{})
+
+		require.NoError(t, err)
+		assert.Equal(t, 0, count)
+	})
+	t.Run("no subject keyring configured", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t), NewStaticKeyring(1, map[uint32][]byte{1: key1}))
+
+		assert.EqualError(t, store.Shred(ctx, "alice"), "no subject keyring configured")
+	})
+}
@@ -0,0 +1,51 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Eviction describes an entry that was removed by the database itself, e.g. by Redis when it reached its memory limit
+// (maxmemory) and the eviction policy allows removing the store's keys.
+type Eviction struct {
+	Shelf string
+	// Key is the string form of the key (see Key.String), since the database doesn't know the key type.
+	Key string
+}
+
+// EvictionWatcher is implemented by stores of which entries can be evicted by the database.
+type EvictionWatcher interface {
+	// WatchEvictions invokes fn for every evicted entry of the store, until the given context is cancelled.
+	// It blocks while watching, and fn is invoked sequentially. Entries evicted while not watching aren't reported.
+	// Returns a ErrDatabase if watching is unsuccessful.
+	WatchEvictions(ctx context.Context, fn func(eviction Eviction)) error
+}
+
+// WatchEvictions invokes fn for every entry evicted from the given store, until the given context is cancelled.
+// If the store does not implement EvictionWatcher, it returns errors.ErrUnsupported.
+func WatchEvictions(ctx context.Context, store KVStore, fn func(eviction Eviction)) error {
+	watcher, ok := store.(EvictionWatcher)
+	if !ok {
+		return fmt.Errorf("watching evictions of %T: %w", store, errors.ErrUnsupported)
+	}
+	return watcher.WatchEvictions(ctx, fn)
+}
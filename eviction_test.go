@@ -0,0 +1,57 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+	"testing"
+)
+
+type stubEvictionWatcher struct {
+	*MockKVStore
+}
+
+func (s *stubEvictionWatcher) WatchEvictions(_ context.Context, fn func(eviction Eviction)) error {
+	fn(Eviction{Shelf: "shelf", Key: "key"})
+	return nil
+}
+
+func TestWatchEvictions(t *testing.T) {
+	t.Run("not supported", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+
+		err := WatchEvictions(context.Background(), NewMockKVStore(ctrl), func(Eviction) {})
+
+		assert.ErrorIs(t, err, errors.ErrUnsupported)
+	})
+	t.Run("supported", func(t *testing.T) {
+		store := &stubEvictionWatcher{MockKVStore: NewMockKVStore(gomock.NewController(t))}
+		var evictions []Eviction
+
+		err := WatchEvictions(context.Background(), store, func(eviction Eviction) {
+			evictions = append(evictions, eviction)
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, []Eviction{{Shelf: "shelf", Key: "key"}}, evictions)
+	})
+}
@@ -0,0 +1,40 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import "errors"
+
+// KeyChecker is implemented by Readers that can check whether a key exists without reading its value, e.g. from an index.
+type KeyChecker interface {
+	// Exists returns whether the shelf contains the given key.
+	Exists(key Key) (bool, error)
+}
+
+// Exists returns whether the reader contains the given key. If the reader does not implement KeyChecker,
+// it falls back to Reader.Get.
+func Exists(reader Reader, key Key) (bool, error) {
+	if checker, ok := reader.(KeyChecker); ok {
+		return checker.Exists(key)
+	}
+	_, err := reader.Get(key)
+	if errors.Is(err, ErrKeyNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
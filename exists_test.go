@@ -0,0 +1,67 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+	"testing"
+)
+
+func TestExists(t *testing.T) {
+	t.Run("uses KeyChecker", func(t *testing.T) {
+		reader := keyCheckerReader{Reader: NewMockReader(gomock.NewController(t)), keys: map[string]bool{"a": true}}
+
+		exists, err := Exists(reader, BytesKey("a"))
+
+		assert.NoError(t, err)
+		assert.True(t, exists)
+	})
+	t.Run("falls back to Get", func(t *testing.T) {
+		reader := NewMockReader(gomock.NewController(t))
+		reader.EXPECT().Get(BytesKey("a")).Return([]byte("value"), nil)
+		reader.EXPECT().Get(BytesKey("b")).Return(nil, ErrKeyNotFound)
+
+		exists, err := Exists(reader, BytesKey("a"))
+		assert.NoError(t, err)
+		assert.True(t, exists)
+		exists, err = Exists(reader, BytesKey("b"))
+		assert.NoError(t, err)
+		assert.False(t, exists)
+	})
+	t.Run("error", func(t *testing.T) {
+		reader := NewMockReader(gomock.NewController(t))
+		reader.EXPECT().Get(BytesKey("a")).Return(nil, errors.New("failed"))
+
+		exists, err := Exists(reader, BytesKey("a"))
+
+		assert.EqualError(t, err, "failed")
+		assert.False(t, exists)
+	})
+}
+
+type keyCheckerReader struct {
+	Reader
+	keys map[string]bool
+}
+
+func (k keyCheckerReader) Exists(key Key) (bool, error) {
+	return k.keys[string(key.Bytes())], nil
+}
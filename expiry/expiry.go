@@ -0,0 +1,317 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package expiry expires keys at a given time, for backends without native key expiry (e.g. BBolt).
+package expiry
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/sirupsen/logrus"
+)
+
+// scheduleShelf holds the keys to expire, ordered by expiry time. Its keys consist of the expiry time (Unix nanos, 8 bytes)
+// followed by the entry (see entryKey). Its values are empty.
+const scheduleShelf = stoabs.ReservedShelfPrefix + "expiry/schedule"
+
+// entryShelf maps entries (see entryKey) to their expiry time, so the expiry of a key can be changed or removed.
+const entryShelf = stoabs.ReservedShelfPrefix + "expiry/keys"
+
+const defaultBatchSize = 100
+
+const defaultInterval = time.Minute
+
+// errBatchFull stops reading the schedule when a batch is complete.
+var errBatchFull = errors.New("batch full")
+
+// Option configures the Worker.
+type Option func(w *Worker)
+
+// WithBatchSize sets the maximum number of keys deleted in a single transaction, which keeps transactions small.
+func WithBatchSize(batchSize int) Option {
+	return func(w *Worker) {
+		w.batchSize = batchSize
+	}
+}
+
+// WithInterval sets the time Run waits between expiring keys.
+func WithInterval(interval time.Duration) Option {
+	return func(w *Worker) {
+		w.interval = interval
+	}
+}
+
+// WithOnExpired registers a callback that is called for every expired key, after the transaction deleting it committed.
+func WithOnExpired(fn func(shelf string, key stoabs.Key)) Option {
+	return func(w *Worker) {
+		w.onExpired = append(w.onExpired, fn)
+	}
+}
+
+// WithKeyType specifies the type of the keys of the given shelf, which are passed to the OnExpired callbacks
+// (see WithOnExpired). Keys of other shelves are passed as stoabs.BytesKey.
+func WithKeyType(shelf string, keyType stoabs.Key) Option {
+	return func(w *Worker) {
+		w.keyTypes[shelf] = keyType
+	}
+}
+
+// WithClock overrides the clock that determines which keys expired and when Run purges, which defaults to stoabs.SystemClock.
+func WithClock(clock stoabs.Clock) Option {
+	return func(w *Worker) {
+		w.now = clock.Now
+		w.after = clock.After
+	}
+}
+
+// WithLogger overrides the default logger.
+func WithLogger(log *logrus.Logger) Option {
+	return func(w *Worker) {
+		w.log = log
+	}
+}
+
+// New creates a Worker that deletes keys of the given store at the time set by Expire.
+// The schedule is kept in the store itself, in a shelf ordered by expiry time, so it requires a backend with ordered keys
+// (BBolt or Badger). Redis supports key expiry natively.
+func New(store stoabs.KVStore, opts ...Option) *Worker {
+	result := &Worker{
+		store:     store,
+		batchSize: defaultBatchSize,
+		interval:  defaultInterval,
+		log:       logrus.StandardLogger(),
+		keyTypes:  map[string]stoabs.Key{},
+		now:       time.Now,
+		after:     time.After,
+	}
+	for _, opt := range opts {
+		opt(result)
+	}
+	return result
+}
+
+// Worker expires keys. Use New to create it.
+type Worker struct {
+	store     stoabs.KVStore
+	batchSize int
+	interval  time.Duration
+	onExpired []func(shelf string, key stoabs.Key)
+	log       *logrus.Logger
+	keyTypes  map[string]stoabs.Key
+	now       func() time.Time
+	after     func(d time.Duration) <-chan time.Time
+}
+
+// Expire sets the time at which the given key is deleted, in the given transaction. It replaces an earlier set expiry time.
+// The key doesn't have to exist yet: typically it's written in the same transaction.
+func (w *Worker) Expire(tx stoabs.WriteTx, shelf string, key stoabs.Key, at time.Time) error {
+	if at.UnixNano() < 0 {
+		return errors.New("expiry time is before the Unix epoch")
+	}
+	entry, err := entryKey(shelf, key)
+	if err != nil {
+		return err
+	}
+	if err := w.persist(tx, entry); err != nil {
+		return err
+	}
+	expiry := binary.BigEndian.AppendUint64(nil, uint64(at.UnixNano()))
+	if err := tx.GetShelfWriter(scheduleShelf).Put(stoabs.BytesKey(append(expiry, entry...)), []byte{}); err != nil {
+		return err
+	}
+	return tx.GetShelfWriter(entryShelf).Put(stoabs.BytesKey(entry), expiry)
+}
+
+// Persist removes the expiry time of the given key, in the given transaction. It does nothing if the key doesn't expire.
+func (w *Worker) Persist(tx stoabs.WriteTx, shelf string, key stoabs.Key) error {
+	entry, err := entryKey(shelf, key)
+	if err != nil {
+		return err
+	}
+	return w.persist(tx, entry)
+}
+
+// ExpiresAt returns the time at which the given key is deleted. It returns false if the key doesn't expire.
+func (w *Worker) ExpiresAt(ctx context.Context, shelf string, key stoabs.Key) (time.Time, bool, error) {
+	entry, err := entryKey(shelf, key)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	var result time.Time
+	var found bool
+	err = w.store.ReadShelf(ctx, entryShelf, func(reader stoabs.Reader) error {
+		expiry, err := reader.Get(stoabs.BytesKey(entry))
+		if errors.Is(err, stoabs.ErrKeyNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(expiry) != 8 {
+			return errors.New("invalid expiry entry")
+		}
+		result, found = time.Unix(0, int64(binary.BigEndian.Uint64(expiry))), true
+		return nil
+	})
+	return result, found, err
+}
+
+// Run expires keys until the given context is cancelled. Errors are logged and retried after the interval (see WithInterval).
+func (w *Worker) Run(ctx context.Context) {
+	for {
+		_, err := w.Purge(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			w.log.WithError(err).Warn("Expiring keys failed, retrying")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.after(w.interval):
+		}
+	}
+}
+
+// Purge deletes all keys whose expiry time passed, in transactions of at most the batch size (see WithBatchSize).
+// It returns the number of deleted keys.
+func (w *Worker) Purge(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		count, err := w.purgeBatch(ctx)
+		total += count
+		if err != nil || count < w.batchSize {
+			return total, err
+		}
+	}
+}
+
+type expired struct {
+	shelf string
+	key   stoabs.Key
+}
+
+// purgeBatch deletes at most a batch of expired keys in a single transaction, and invokes the callbacks after it committed.
+func (w *Worker) purgeBatch(ctx context.Context) (int, error) {
+	now := w.now().UnixNano()
+	if now < 0 {
+		return 0, nil
+	}
+	var batch []expired
+	err := w.store.Write(ctx, func(tx stoabs.WriteTx) error {
+		batch = nil
+		var scheduled [][]byte
+		schedule := tx.GetShelfWriter(scheduleShelf)
+		// the end of the range is exclusive: include keys expiring at the current time
+		end := []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+		if now < math.MaxInt64 {
+			end = binary.BigEndian.AppendUint64(nil, uint64(now+1))
+		}
+		err := schedule.Range(stoabs.BytesKey{}, stoabs.BytesKey(end), func(key stoabs.Key, _ []byte) error {
+			if len(key.Bytes()) < 8 {
+				return errors.New("invalid expiry entry")
+			}
+			// copied, since the key may only be valid during the callback
+			scheduled = append(scheduled, append([]byte{}, key.Bytes()...))
+			if len(scheduled) == w.batchSize {
+				return errBatchFull
+			}
+			return nil
+		}, false)
+		if err != nil && !errors.Is(err, errBatchFull) {
+			return err
+		}
+		for _, scheduledKey := range scheduled {
+			entry := scheduledKey[8:]
+			shelf, key, err := w.parseEntry(entry)
+			if err != nil {
+				return err
+			}
+			if err := tx.GetShelfWriter(shelf).Delete(key); err != nil {
+				return err
+			}
+			if err := schedule.Delete(stoabs.BytesKey(scheduledKey)); err != nil {
+				return err
+			}
+			if err := tx.GetShelfWriter(entryShelf).Delete(stoabs.BytesKey(entry)); err != nil {
+				return err
+			}
+			batch = append(batch, expired{shelf: shelf, key: key})
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, curr := range batch {
+		for _, fn := range w.onExpired {
+			fn(curr.shelf, curr.key)
+		}
+	}
+	return len(batch), nil
+}
+
+// persist removes the scheduled expiry of the given entry, if any.
+func (w *Worker) persist(tx stoabs.WriteTx, entry []byte) error {
+	entries := tx.GetShelfWriter(entryShelf)
+	expiry, err := entries.Get(stoabs.BytesKey(entry))
+	if errors.Is(err, stoabs.ErrKeyNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := tx.GetShelfWriter(scheduleShelf).Delete(stoabs.BytesKey(append(expiry, entry...))); err != nil {
+		return err
+	}
+	return entries.Delete(stoabs.BytesKey(entry))
+}
+
+func (w *Worker) parseEntry(entry []byte) (string, stoabs.Key, error) {
+	if len(entry) < 2 || len(entry) < 2+int(binary.BigEndian.Uint16(entry)) {
+		return "", nil, errors.New("invalid expiry entry")
+	}
+	nameLength := 2 + int(binary.BigEndian.Uint16(entry))
+	shelf := string(entry[2:nameLength])
+	keyType, ok := w.keyTypes[shelf]
+	if !ok {
+		keyType = stoabs.BytesKey{}
+	}
+	key, err := keyType.FromBytes(entry[nameLength:])
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid key of shelf %s: %w", shelf, err)
+	}
+	return shelf, key, nil
+}
+
+// entryKey returns the key identifying the given key: the length of the shelf name (2 bytes), the shelf name and the key.
+func entryKey(shelf string, key stoabs.Key) ([]byte, error) {
+	if len(shelf) > math.MaxUint16 {
+		return nil, errors.New("shelf name too long")
+	}
+	result := binary.BigEndian.AppendUint16(nil, uint16(len(shelf)))
+	result = append(result, shelf...)
+	return append(result, key.Bytes()...), nil
+}
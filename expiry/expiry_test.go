@@ -0,0 +1,231 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package expiry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/nuts-foundation/go-stoabs/mocks"
+	"github.com/nuts-foundation/go-stoabs/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+const shelf = "sessions"
+
+func TestWorker(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	setup := func(t *testing.T, opts ...Option) (*Worker, stoabs.KVStore) {
+		store := stores.BBolt(t)
+		worker := New(store, append([]Option{WithKeyType(shelf, stoabs.Uint32Key(0))}, opts...)...)
+		worker.now = func() time.Time {
+			return now
+		}
+		return worker, store
+	}
+	put := func(t *testing.T, worker *Worker, store stoabs.KVStore, key uint32, at time.Time) {
+		require.NoError(t, store.Write(ctx, func(tx stoabs.WriteTx) error {
+			if err := tx.GetShelfWriter(shelf).Put(stoabs.Uint32Key(key), []byte("value")); err != nil {
+				return err
+			}
+			return worker.Expire(tx, shelf, stoabs.Uint32Key(key), at)
+		}))
+	}
+
+	t.Run("expired keys are deleted", func(t *testing.T) {
+		var expired []stoabs.Key
+		worker, store := setup(t, WithOnExpired(func(shelfName string, key stoabs.Key) {
+			assert.Equal(t, shelf, shelfName)
+			expired = append(expired, key)
+		}))
+		put(t, worker, store, 1, now.Add(-time.Second))
+		put(t, worker, store, 2, now)
+		put(t, worker, store, 3, now.Add(time.Second))
+
+		count, err := worker.Purge(ctx)
+
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+		assert.Equal(t, []stoabs.Key{stoabs.Uint32Key(1), stoabs.Uint32Key(2)}, expired)
+		assert.Equal(t, []uint32{3}, keys(t, store))
+		_, found, err := worker.ExpiresAt(ctx, shelf, stoabs.Uint32Key(1))
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+	t.Run("in batches", func(t *testing.T) {
+		worker, store := setup(t, WithBatchSize(3))
+		for i := uint32(0); i < 10; i++ {
+			put(t, worker, store, i, now.Add(-time.Duration(i)*time.Second))
+		}
+		counter := &interceptor{}
+		worker.store = stoabs.Chain(store, counter)
+
+		count, err := worker.Purge(ctx)
+
+		require.NoError(t, err)
+		assert.Equal(t, 10, count)
+		assert.Equal(t, 4, counter.transactions)
+		assert.Empty(t, keys(t, store))
+	})
+	t.Run("expire again replaces expiry time", func(t *testing.T) {
+		worker, store := setup(t)
+		put(t, worker, store, 1, now.Add(-time.Second))
+		put(t, worker, store, 1, now.Add(time.Hour))
+
+		count, err := worker.Purge(ctx)
+
+		require.NoError(t, err)
+		assert.Equal(t, 0, count)
+		at, found, err := worker.ExpiresAt(ctx, shelf, stoabs.Uint32Key(1))
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, now.Add(time.Hour).UnixNano(), at.UnixNano())
+	})
+	t.Run("persist", func(t *testing.T) {
+		worker, store := setup(t)
+		put(t, worker, store, 1, now.Add(-time.Second))
+		require.NoError(t, store.Write(ctx, func(tx stoabs.WriteTx) error {
+			if err := worker.Persist(tx, shelf, stoabs.Uint32Key(1)); err != nil {
+				return err
+			}
+			// key without expiry
+			return worker.Persist(tx, shelf, stoabs.Uint32Key(2))
+		}))
+
+		count, err := worker.Purge(ctx)
+
+		require.NoError(t, err)
+		assert.Equal(t, 0, count)
+		assert.Equal(t, []uint32{1}, keys(t, store))
+	})
+	t.Run("failed transaction doesn't invoke callbacks", func(t *testing.T) {
+		called := false
+		worker, store := setup(t, WithOnExpired(func(_ string, _ stoabs.Key) {
+			called = true
+		}))
+		put(t, worker, store, 1, now.Add(-time.Second))
+		worker.store = stoabs.Chain(store, &interceptor{deleteErr: errors.New("failed")})
+
+		_, err := worker.Purge(ctx)
+
+		assert.EqualError(t, err, "failed")
+		assert.False(t, called)
+		assert.Equal(t, []uint32{1}, keys(t, store))
+	})
+	t.Run("Run expires keys until cancelled", func(t *testing.T) {
+		expired := make(chan stoabs.Key, 1)
+		worker, store := setup(t, WithInterval(10*time.Millisecond), WithOnExpired(func(_ string, key stoabs.Key) {
+			expired <- key
+		}))
+		ctx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			worker.Run(ctx)
+			close(done)
+		}()
+
+		put(t, worker, store, 1, now)
+
+		select {
+		case key := <-expired:
+			assert.Equal(t, stoabs.Uint32Key(1), key)
+		case <-time.After(5 * time.Second):
+			t.Fatal("key didn't expire")
+		}
+		cancel()
+		<-done
+	})
+	t.Run("Run with clock", func(t *testing.T) {
+		clock := mocks.NewClock(now)
+		expired := make(chan stoabs.Key, 1)
+		store := stores.BBolt(t)
+		worker := New(store, WithKeyType(shelf, stoabs.Uint32Key(0)), WithClock(clock), WithInterval(time.Hour), WithOnExpired(func(_ string, key stoabs.Key) {
+			expired <- key
+		}))
+		put(t, worker, store, 1, now.Add(time.Hour))
+		ctx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			worker.Run(ctx)
+			close(done)
+		}()
+		// wait until the first purge is done and Run waits for the interval
+		util.WaitFor(t, func() (bool, error) {
+			return clock.Waiters() == 1, nil
+		}, 5*time.Second, "Run didn't wait for the interval")
+		assert.Len(t, expired, 0)
+
+		clock.Advance(time.Hour)
+
+		select {
+		case key := <-expired:
+			assert.Equal(t, stoabs.Uint32Key(1), key)
+		case <-time.After(5 * time.Second):
+			t.Fatal("key didn't expire")
+		}
+		cancel()
+		<-done
+	})
+	t.Run("expiry time before epoch", func(t *testing.T) {
+		worker, store := setup(t)
+
+		err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+			return worker.Expire(tx, shelf, stoabs.Uint32Key(1), time.Unix(-1, 0))
+		})
+
+		assert.EqualError(t, err, "expiry time is before the Unix epoch")
+	})
+}
+
+// interceptor counts transactions, and fails deletes if deleteErr is set.
+type interceptor struct {
+	stoabs.NoopInterceptor
+	transactions int
+	deleteErr    error
+}
+
+func (i *interceptor) Transaction(ctx context.Context, _ stoabs.TxInfo, next func(ctx context.Context) error) error {
+	i.transactions++
+	return next(ctx)
+}
+
+func (i *interceptor) Delete(_ stoabs.Call, key stoabs.Key, next func(key stoabs.Key) error) error {
+	if i.deleteErr != nil {
+		return i.deleteErr
+	}
+	return next(key)
+}
+
+func keys(t *testing.T, store stoabs.KVStore) []uint32 {
+	result := []uint32{}
+	require.NoError(t, store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+		return reader.Iterate(func(key stoabs.Key, _ []byte) error {
+			result = append(result, uint32(key.(stoabs.Uint32Key)))
+			return nil
+		}, stoabs.Uint32Key(0))
+	}))
+	return result
+}
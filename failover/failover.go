@@ -0,0 +1,376 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package failover provides a KVStore that falls back to a standby store when the primary store is unavailable.
+package failover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/util"
+	"github.com/sirupsen/logrus"
+)
+
+var _ stoabs.KVStore = (*Store)(nil)
+var _ stoabs.ShelfLister = (*Store)(nil)
+var _ stoabs.WriteTx = (*writeTx)(nil)
+
+const defaultFailureThreshold = 3
+
+const defaultRetryInterval = 10 * time.Second
+
+// ErrReadOnly is returned by write operations when the primary store is unavailable and the store has degraded to
+// serving reads from the standby store.
+var ErrReadOnly = errors.New("store is read-only, primary store is unavailable")
+
+// State is the state of the failover store.
+type State int
+
+const (
+	// StatePrimary means all operations are executed on the primary store.
+	StatePrimary State = iota
+	// StateReadOnly means the primary store is unavailable: reads are served by the standby store and writes fail with ErrReadOnly.
+	// The primary store is retried periodically, see WithRetryInterval.
+	StateReadOnly
+	// StatePromoted means the primary store is unavailable and the standby store has been promoted:
+	// all operations are executed on the standby store. This is permanent, since the stores have diverged.
+	StatePromoted
+)
+
+func (s State) String() string {
+	switch s {
+	case StatePrimary:
+		return "primary"
+	case StateReadOnly:
+		return "read-only"
+	case StatePromoted:
+		return "promoted"
+	default:
+		return fmt.Sprintf("unknown (%d)", int(s))
+	}
+}
+
+// EventType is the type of Event.
+type EventType int
+
+const (
+	// EventFailedOver is emitted when the store degraded to read-only, after the primary store failed.
+	EventFailedOver EventType = iota
+	// EventPromoted is emitted when the standby store has been promoted, after the primary store failed.
+	EventPromoted
+	// EventRecovered is emitted when the primary store is available again after the store degraded to read-only.
+	EventRecovered
+)
+
+func (e EventType) String() string {
+	switch e {
+	case EventFailedOver:
+		return "failed over"
+	case EventPromoted:
+		return "promoted"
+	case EventRecovered:
+		return "recovered"
+	default:
+		return fmt.Sprintf("unknown (%d)", int(e))
+	}
+}
+
+// Event describes a state change of the failover store.
+type Event struct {
+	Type EventType
+	// State is the state after the change.
+	State State
+	// Err is the last error of the primary store. It's nil for EventRecovered.
+	Err       error
+	Timestamp time.Time
+}
+
+// Stats contains the failover counters.
+type Stats struct {
+	State State
+	// PrimaryFailures counts the operations that failed because the primary store was unavailable.
+	PrimaryFailures uint64
+	// Failovers counts the times the store degraded to read-only or promoted the standby store.
+	Failovers uint64
+	// Recoveries counts the times the primary store became available again.
+	Recoveries uint64
+	// StandbyReads counts the read operations served by the standby store while being read-only.
+	StandbyReads uint64
+	// RejectedWrites counts the write operations that failed with ErrReadOnly.
+	RejectedWrites uint64
+}
+
+// Option configures the failover store.
+type Option func(s *Store)
+
+// WithFailureThreshold sets the number of consecutive failed operations after which the primary store is considered
+// unavailable. It defaults to 3.
+func WithFailureThreshold(failures int) Option {
+	return func(s *Store) {
+		s.failureThreshold = failures
+	}
+}
+
+// WithRetryInterval sets the time after which a read-only store retries the primary store. It defaults to 10 seconds.
+func WithRetryInterval(interval time.Duration) Option {
+	return func(s *Store) {
+		s.retryInterval = interval
+	}
+}
+
+// WithPromotion makes the store promote the standby store when the primary store is unavailable,
+// instead of degrading to read-only. After promotion, the primary store is not used anymore.
+func WithPromotion() Option {
+	return func(s *Store) {
+		s.promote = true
+	}
+}
+
+// WithEventHandler sets a function that is called (synchronously) on every state change.
+func WithEventHandler(handler func(Event)) Option {
+	return func(s *Store) {
+		s.handler = handler
+	}
+}
+
+// WithLogger overrides the default logger.
+func WithLogger(log *logrus.Logger) Option {
+	return func(s *Store) {
+		s.log = log
+	}
+}
+
+// Wrap creates a store that executes all operations on the primary store, until a number of consecutive operations
+// failed (see WithFailureThreshold). It then degrades to read-only, serving reads from the standby store
+// and retrying the primary store periodically, or promotes the standby store (see WithPromotion).
+// Errors returned by transaction functions and context cancellation don't count as failures of the primary store.
+// The standby store is expected to be kept up-to-date by other means, e.g. using the replica package.
+func Wrap(primary, standby stoabs.KVStore, opts ...Option) *Store {
+	result := &Store{
+		primary:          primary,
+		standby:          standby,
+		failureThreshold: defaultFailureThreshold,
+		retryInterval:    defaultRetryInterval,
+		now:              time.Now,
+		log:              logrus.StandardLogger(),
+	}
+	for _, opt := range opts {
+		opt(result)
+	}
+	return result
+}
+
+// Store is a KVStore with a primary and standby store. Use Wrap to create it.
+type Store struct {
+	primary          stoabs.KVStore
+	standby          stoabs.KVStore
+	failureThreshold int
+	retryInterval    time.Duration
+	promote          bool
+	handler          func(Event)
+	now              func() time.Time
+	log              *logrus.Logger
+
+	mux sync.Mutex
+	// failures is the number of consecutive failures of the primary store.
+	failures int
+	// retryAt is the time at which a read-only store retries the primary store.
+	retryAt time.Time
+	stats   Stats
+}
+
+// Stats returns the failover state and counters.
+func (s *Store) Stats() Stats {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.stats
+}
+
+// Close closes both the primary and standby store.
+func (s *Store) Close(ctx context.Context) error {
+	return errors.Join(s.primary.Close(ctx), s.standby.Close(ctx))
+}
+
+func (s *Store) Write(ctx context.Context, fn func(stoabs.WriteTx) error, opts ...stoabs.TxOption) error {
+	return s.execute(ctx, true, func(store stoabs.KVStore) error {
+		return store.Write(ctx, func(underlyingTx stoabs.WriteTx) error {
+			return fn(&writeTx{WriteTx: underlyingTx, store: s})
+		}, opts...)
+	})
+}
+
+func (s *Store) WriteShelf(ctx context.Context, shelfName string, fn func(stoabs.Writer) error) error {
+	return s.Write(ctx, func(tx stoabs.WriteTx) error {
+		return fn(tx.GetShelfWriter(shelfName))
+	})
+}
+
+func (s *Store) Read(ctx context.Context, fn func(stoabs.ReadTx) error) error {
+	return s.execute(ctx, false, func(store stoabs.KVStore) error {
+		return store.Read(ctx, func(underlyingTx stoabs.ReadTx) error {
+			return fn(&readTx{ReadTx: underlyingTx, store: s})
+		})
+	})
+}
+
+func (s *Store) ReadShelf(ctx context.Context, shelfName string, fn func(stoabs.Reader) error) error {
+	return s.Read(ctx, func(tx stoabs.ReadTx) error {
+		return fn(tx.GetShelfReader(shelfName))
+	})
+}
+
+// ShelfNames returns the shelves of the store currently serving reads.
+func (s *Store) ShelfNames(ctx context.Context) ([]string, error) {
+	var result []string
+	err := s.execute(ctx, false, func(store stoabs.KVStore) error {
+		var err error
+		result, err = stoabs.ShelfNames(ctx, store)
+		return err
+	})
+	return result, err
+}
+
+// execute invokes call on the store that should handle the operation, and updates the state according to the result.
+// Only errors that signal a failure of the store (see util.IsStoreFailure) count as failures of the primary store,
+// errors returned by the transaction function are application errors unless they stem from the store (e.g. a failed Get).
+func (s *Store) execute(ctx context.Context, write bool, call func(store stoabs.KVStore) error) error {
+	store, err := s.route(write)
+	if err != nil {
+		return err
+	}
+	err = call(store)
+	if store != s.primary {
+		return err
+	}
+	if !util.IsStoreFailure(err) {
+		s.succeeded()
+		return err
+	}
+	if ctx.Err() != nil {
+		return err
+	}
+	if s.failed(err) == StateReadOnly && !write {
+		// the primary store is unavailable, serve this read from the standby store as well
+		s.countStandbyRead()
+		return call(s.standby)
+	}
+	return err
+}
+
+// route returns the store that should handle the operation.
+func (s *Store) route(write bool) (stoabs.KVStore, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	switch s.stats.State {
+	case StatePromoted:
+		return s.standby, nil
+	case StateReadOnly:
+		if !s.now().Before(s.retryAt) {
+			// retry the primary store, other operations keep using the standby store until it succeeded
+			s.retryAt = s.now().Add(s.retryInterval)
+			return s.primary, nil
+		}
+		if write {
+			s.stats.RejectedWrites++
+			return nil, ErrReadOnly
+		}
+		s.stats.StandbyReads++
+		return s.standby, nil
+	default:
+		return s.primary, nil
+	}
+}
+
+func (s *Store) succeeded() {
+	s.mux.Lock()
+	s.failures = 0
+	if s.stats.State != StateReadOnly {
+		s.mux.Unlock()
+		return
+	}
+	s.stats.State = StatePrimary
+	s.stats.Recoveries++
+	event := Event{Type: EventRecovered, State: StatePrimary, Timestamp: s.now()}
+	s.mux.Unlock()
+	s.log.Info("Primary store is available again")
+	s.emit(event)
+}
+
+// failed records a failure of the primary store, and returns the resulting state.
+func (s *Store) failed(err error) State {
+	s.mux.Lock()
+	s.stats.PrimaryFailures++
+	s.failures++
+	state := s.stats.State
+	if state == StateReadOnly {
+		// retrying the primary store failed
+		s.mux.Unlock()
+		return state
+	}
+	if s.failures < s.failureThreshold {
+		s.mux.Unlock()
+		return state
+	}
+	event := Event{Type: EventFailedOver, State: StateReadOnly, Err: err, Timestamp: s.now()}
+	if s.promote {
+		event.Type = EventPromoted
+		event.State = StatePromoted
+	}
+	s.stats.State = event.State
+	s.stats.Failovers++
+	s.retryAt = s.now().Add(s.retryInterval)
+	s.mux.Unlock()
+	s.log.WithError(err).Warnf("Primary store is unavailable, store is now %s", event.State)
+	s.emit(event)
+	return event.State
+}
+
+func (s *Store) countStandbyRead() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.stats.StandbyReads++
+}
+
+func (s *Store) emit(event Event) {
+	if s.handler != nil {
+		s.handler(event)
+	}
+}
+
+type readTx struct {
+	stoabs.ReadTx
+	store *Store
+}
+
+func (t *readTx) Store() stoabs.KVStore {
+	return t.store
+}
+
+type writeTx struct {
+	stoabs.WriteTx
+	store *Store
+}
+
+func (t *writeTx) Store() stoabs.KVStore {
+	return t.store
+}
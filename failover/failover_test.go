@@ -0,0 +1,250 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package failover
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+var key = stoabs.BytesKey("key")
+
+const shelfName = "test"
+
+var errUnavailable = stoabs.DatabaseError(errors.New("connection refused"))
+
+func TestFailover(t *testing.T) {
+	provider := func(t *testing.T) (stoabs.KVStore, error) {
+		return Wrap(stores.BBolt(t), stores.BBolt(t)), nil
+	}
+
+	kvtests.TestReadingAndWriting(t, provider)
+	kvtests.TestRange(t, provider)
+	kvtests.TestIterate(t, provider)
+	kvtests.TestEmpty(t, provider)
+	kvtests.TestDelete(t, provider)
+	kvtests.TestWriteTransactions(t, provider)
+	kvtests.TestShelfNames(t, provider)
+}
+
+func TestStore_readOnly(t *testing.T) {
+	primary := &faultyStore{KVStore: stores.BBolt(t)}
+	standby := stores.BBolt(t)
+	put(t, primary, "primary")
+	put(t, standby, "standby")
+	var events []Event
+	store := Wrap(primary, standby, WithFailureThreshold(2), WithEventHandler(func(event Event) {
+		events = append(events, event)
+	}))
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	t.Run("failures below threshold are returned", func(t *testing.T) {
+		primary.err = errUnavailable
+
+		_, err := get(store)
+
+		assert.ErrorIs(t, err, errUnavailable)
+		assert.Equal(t, StatePrimary, store.Stats().State)
+	})
+	t.Run("degrades to read-only when threshold is reached", func(t *testing.T) {
+		value, err := get(store)
+
+		require.NoError(t, err)
+		assert.Equal(t, "standby", value)
+		assert.Equal(t, StateReadOnly, store.Stats().State)
+		require.Len(t, events, 1)
+		assert.Equal(t, EventFailedOver, events[0].Type)
+		assert.ErrorIs(t, events[0].Err, errUnavailable)
+	})
+	t.Run("writes fail", func(t *testing.T) {
+		err := store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			return writer.Put(key, []byte("new"))
+		})
+
+		assert.ErrorIs(t, err, ErrReadOnly)
+	})
+	t.Run("retries primary after interval", func(t *testing.T) {
+		now = now.Add(defaultRetryInterval)
+
+		value, err := get(store)
+
+		require.NoError(t, err)
+		assert.Equal(t, "standby", value)
+		assert.Equal(t, StateReadOnly, store.Stats().State)
+	})
+	t.Run("recovers when primary is available", func(t *testing.T) {
+		primary.err = nil
+		now = now.Add(defaultRetryInterval)
+
+		value, err := get(store)
+
+		require.NoError(t, err)
+		assert.Equal(t, "primary", value)
+		require.Len(t, events, 2)
+		assert.Equal(t, EventRecovered, events[1].Type)
+		assert.Equal(t, Stats{
+			State:           StatePrimary,
+			PrimaryFailures: 3,
+			Failovers:       1,
+			Recoveries:      1,
+			StandbyReads:    2,
+			RejectedWrites:  1,
+		}, store.Stats())
+	})
+}
+
+func TestStore_promotion(t *testing.T) {
+	primary := &faultyStore{KVStore: stores.BBolt(t), err: errUnavailable}
+	standby := stores.BBolt(t)
+	var events []Event
+	store := Wrap(primary, standby, WithPromotion(), WithFailureThreshold(1), WithEventHandler(func(event Event) {
+		events = append(events, event)
+	}))
+
+	_, err := get(store)
+	assert.ErrorIs(t, err, errUnavailable)
+	primary.err = nil
+	put(t, store, "promoted")
+
+	assert.Equal(t, StatePromoted, store.Stats().State)
+	require.Len(t, events, 1)
+	assert.Equal(t, EventPromoted, events[0].Type)
+	value, _ := get(standby)
+	assert.Equal(t, "promoted", value)
+	_, err = get(primary)
+	assert.ErrorIs(t, err, stoabs.ErrKeyNotFound)
+}
+
+func TestStore_applicationErrors(t *testing.T) {
+	primary := &faultyStore{KVStore: stores.BBolt(t)}
+	store := Wrap(primary, stores.BBolt(t), WithFailureThreshold(1))
+
+	t.Run("transaction function error", func(t *testing.T) {
+		err := store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			return errors.New("failed")
+		})
+
+		assert.EqualError(t, err, "failed")
+		assert.Equal(t, StatePrimary, store.Stats().State)
+	})
+	t.Run("key not found", func(t *testing.T) {
+		_, err := get(store)
+
+		assert.ErrorIs(t, err, stoabs.ErrKeyNotFound)
+		assert.Equal(t, StatePrimary, store.Stats().State)
+	})
+	t.Run("cancelled context", func(t *testing.T) {
+		primary.err = context.Canceled
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		err := store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			return nil
+		})
+
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, StatePrimary, store.Stats().State)
+	})
+}
+
+func TestStore_storeErrorsInTransaction(t *testing.T) {
+	primary := stores.BBolt(t)
+	standby := stores.BBolt(t)
+	put(t, standby, "standby")
+	store := Wrap(primary, standby, WithFailureThreshold(1))
+
+	calls := 0
+	var value []byte
+	err := store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+		calls++
+		if calls == 1 {
+			// e.g. an I/O error of Redis, returned by a Get inside the transaction function
+			return errUnavailable
+		}
+		var err error
+		value, err = reader.Get(key)
+		return err
+	})
+
+	require.NoError(t, err, "read should be served by the standby store")
+	assert.Equal(t, "standby", string(value))
+	assert.Equal(t, StateReadOnly, store.Stats().State)
+	assert.Equal(t, uint64(1), store.Stats().PrimaryFailures)
+}
+
+// faultyStore fails all transactions with err, if set.
+type faultyStore struct {
+	stoabs.KVStore
+	err error
+}
+
+func (f *faultyStore) Write(ctx context.Context, fn func(stoabs.WriteTx) error, opts ...stoabs.TxOption) error {
+	if f.err != nil {
+		return f.err
+	}
+	return f.KVStore.Write(ctx, fn, opts...)
+}
+
+func (f *faultyStore) Read(ctx context.Context, fn func(stoabs.ReadTx) error) error {
+	if f.err != nil {
+		return f.err
+	}
+	return f.KVStore.Read(ctx, fn)
+}
+
+func (f *faultyStore) WriteShelf(ctx context.Context, shelfName string, fn func(stoabs.Writer) error) error {
+	if f.err != nil {
+		return f.err
+	}
+	return f.KVStore.WriteShelf(ctx, shelfName, fn)
+}
+
+func (f *faultyStore) ReadShelf(ctx context.Context, shelfName string, fn func(stoabs.Reader) error) error {
+	if f.err != nil {
+		return f.err
+	}
+	return f.KVStore.ReadShelf(ctx, shelfName, fn)
+}
+
+func put(t *testing.T, store stoabs.KVStore, value string) {
+	require.NoError(t, store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+		return writer.Put(key, []byte(value))
+	}))
+}
+
+func get(store stoabs.KVStore) (string, error) {
+	var result []byte
+	err := store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+		var err error
+		result, err = reader.Get(key)
+		return err
+	})
+	return string(result), err
+}
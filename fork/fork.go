@@ -0,0 +1,403 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package fork provides copy-on-write forks of stores, for tests and what-if simulations.
+package fork
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/util"
+)
+
+var _ stoabs.KVStore = (*Store)(nil)
+var _ stoabs.ShelfLister = (*Store)(nil)
+var _ stoabs.StateReporter = (*Store)(nil)
+var _ stoabs.WriteTx = (*tx)(nil)
+var _ stoabs.Writer = (*overlay)(nil)
+
+// errStop stops iterating the base store.
+var errStop = errors.New("stop")
+
+// Wrap creates a copy-on-write fork of the given store: an in-memory overlay that reads through to the store,
+// while changes written to the fork are only kept in memory. This allows tests and what-if simulations to mutate
+// a (copy of a) store freely without affecting it, and without copying all of its data first.
+// Changes written to the store after forking are visible in the fork, unless the fork changed the same key.
+// To fork a fixed state of the store, fork a snapshot of it (see stoabs.SnapshotStore).
+// The store must stay open while the fork is used; closing the fork doesn't close the store.
+// Write transactions on the fork are serialized. Its shelves are iterated in byte order if the shelves of the store are.
+func Wrap(store stoabs.KVStore) *Store {
+	return &Store{
+		base:      store,
+		shelves:   map[string]*changes{},
+		writeLock: &util.ContextRWLocker{},
+		drainer:   &util.Drainer{},
+	}
+}
+
+// Store is a copy-on-write fork of a store, see Wrap.
+type Store struct {
+	base stoabs.KVStore
+	// writeLock serializes write transactions, so only the committing transaction modifies the shelves of the fork.
+	writeLock *util.ContextRWLocker
+	drainer   *util.Drainer
+	// mux guards shelves: it's held (read) by read transactions and (write) when committing a write transaction.
+	mux     sync.RWMutex
+	shelves map[string]*changes
+}
+
+func (s *Store) Close(ctx context.Context) error {
+	return s.drainer.Close(ctx, func() error {
+		s.mux.Lock()
+		defer s.mux.Unlock()
+		s.shelves = nil
+		return nil
+	})
+}
+
+func (s *Store) State() stoabs.State {
+	return s.drainer.State()
+}
+
+func (s *Store) Read(ctx context.Context, fn func(stoabs.ReadTx) error) error {
+	ctx, done, err := s.drainer.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.base.Read(ctx, func(baseTx stoabs.ReadTx) error {
+		return fn(&tx{ctx: ctx, store: s, base: baseTx})
+	})
+}
+
+func (s *Store) ReadShelf(ctx context.Context, shelfName string, fn func(stoabs.Reader) error) error {
+	return s.Read(ctx, func(tx stoabs.ReadTx) error {
+		return fn(tx.GetShelfReader(shelfName))
+	})
+}
+
+func (s *Store) Write(ctx context.Context, fn func(stoabs.WriteTx) error, opts ...stoabs.TxOption) error {
+	ctx, done, err := s.drainer.BeginWrite(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	err = s.writeLock.LockContextHolder(ctx, (stoabs.LabelOption{}).Label(opts))
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = stoabs.ErrLockTimeout{Lock: "fork write lock", Holder: s.writeLock.Holder()}
+	}
+	if err != nil {
+		return fmt.Errorf("unable to obtain fork write lock: %w", stoabs.DatabaseError(err))
+	}
+	defer s.writeLock.Unlock()
+
+	writeTx := &tx{ctx: ctx, store: s, changes: map[string]*changes{}}
+	err = s.base.Read(ctx, func(baseTx stoabs.ReadTx) error {
+		writeTx.base = baseTx
+		return fn(writeTx)
+	})
+	if err == nil && ctx.Err() != nil {
+		err = util.WrapError(stoabs.ErrCommitFailed, ctx.Err())
+	}
+	if err != nil {
+		stoabs.OnRollbackOption{}.Invoke(opts)
+		return err
+	}
+	s.mux.Lock()
+	for shelfName, shelfChanges := range writeTx.changes {
+		committed, ok := s.shelves[shelfName]
+		if !ok {
+			committed = newChanges()
+			s.shelves[shelfName] = committed
+		}
+		committed.apply(shelfChanges)
+	}
+	s.mux.Unlock()
+	stoabs.AfterCommitOption{}.Invoke(opts)
+	return nil
+}
+
+func (s *Store) WriteShelf(ctx context.Context, shelfName string, fn func(stoabs.Writer) error) error {
+	return s.Write(ctx, func(tx stoabs.WriteTx) error {
+		return fn(tx.GetShelfWriter(shelfName))
+	})
+}
+
+// ShelfNames returns the names of the shelves of the store, and of the shelves that were written to in the fork.
+// If the store doesn't implement stoabs.ShelfLister, it returns errors.ErrUnsupported.
+func (s *Store) ShelfNames(ctx context.Context) ([]string, error) {
+	ctx, done, err := s.drainer.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	names, err := stoabs.ShelfNames(ctx, s.base)
+	if err != nil {
+		return nil, err
+	}
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	for shelfName, shelfChanges := range s.shelves {
+		if len(shelfChanges.entries) > 0 {
+			names = append(names, shelfName)
+		}
+	}
+	sort.Strings(names)
+	return stoabs.FilterReservedShelves(ctx, slices.Compact(names)), nil
+}
+
+type tx struct {
+	ctx   context.Context
+	store *Store
+	base  stoabs.ReadTx
+	// changes holds the uncommitted changes of a write transaction, nil for read transactions.
+	changes map[string]*changes
+}
+
+func (t *tx) GetShelfReader(shelfName string) stoabs.Reader {
+	if invalid := stoabs.InvalidShelf(shelfName); invalid != nil {
+		return invalid
+	}
+	// only expose the methods of Reader, so it can't be type-asserted to Writer
+	return struct{ stoabs.Reader }{t.shelf(shelfName)}
+}
+
+func (t *tx) GetShelfWriter(shelfName string) stoabs.Writer {
+	if invalid := stoabs.InvalidShelf(shelfName); invalid != nil {
+		return invalid
+	}
+	shelfChanges, ok := t.changes[shelfName]
+	if !ok {
+		shelfChanges = newChanges()
+		t.changes[shelfName] = shelfChanges
+	}
+	return &overlay{ctx: t.ctx, base: t.shelf(shelfName), changes: shelfChanges}
+}
+
+// shelf returns a reader that reads the committed changes of the fork to the shelf, falling back to the store.
+// For write transactions, the uncommitted changes of the transaction are read as well.
+func (t *tx) shelf(shelfName string) stoabs.Reader {
+	result := t.base.GetShelfReader(shelfName)
+	if committed, ok := t.store.shelves[shelfName]; ok {
+		result = &overlay{ctx: t.ctx, base: result, changes: committed}
+	}
+	if uncommitted, ok := t.changes[shelfName]; ok {
+		result = &overlay{ctx: t.ctx, base: result, changes: uncommitted}
+	}
+	return result
+}
+
+func (t *tx) Store() stoabs.KVStore {
+	return t.store
+}
+
+func (t *tx) Unwrap() interface{} {
+	return nil
+}
+
+// changes holds the changes to a shelf: the entries that were written and the keys that were deleted.
+type changes struct {
+	entries map[string][]byte
+	deleted map[string]struct{}
+	// sorted holds the keys of the entries in byte order, nil if keys were added since it was last sorted.
+	sorted []string
+}
+
+func newChanges() *changes {
+	return &changes{entries: map[string][]byte{}, deleted: map[string]struct{}{}}
+}
+
+func (c *changes) put(key string, value []byte) {
+	if _, exists := c.entries[key]; !exists {
+		c.sorted = nil
+	}
+	c.entries[key] = bytes.Clone(value)
+	delete(c.deleted, key)
+}
+
+func (c *changes) delete(key string) {
+	if _, exists := c.entries[key]; exists {
+		delete(c.entries, key)
+		c.sorted = nil
+	}
+	c.deleted[key] = struct{}{}
+}
+
+// apply applies the given changes.
+func (c *changes) apply(other *changes) {
+	for key := range other.deleted {
+		c.delete(key)
+	}
+	for key, value := range other.entries {
+		c.put(key, value)
+	}
+}
+
+// sortedKeys returns the keys of the entries in byte order. The result isn't modified afterward,
+// so callbacks may modify the changes while iterating.
+func (c *changes) sortedKeys() []string {
+	if c.sorted == nil {
+		c.sorted = make([]string, 0, len(c.entries))
+		for key := range c.entries {
+			c.sorted = append(c.sorted, key)
+		}
+		sort.Strings(c.sorted)
+	}
+	return c.sorted
+}
+
+// overlay reads the changes to a shelf on top of a base reader. Writing to it modifies the changes.
+type overlay struct {
+	ctx     context.Context
+	base    stoabs.Reader
+	changes *changes
+}
+
+func (o *overlay) Empty() (bool, error) {
+	empty := true
+	err := o.Iterate(func(_ stoabs.Key, _ []byte) error {
+		empty = false
+		return errStop
+	}, stoabs.BytesKey{})
+	if err != nil && !errors.Is(err, errStop) {
+		return false, err
+	}
+	return empty, nil
+}
+
+func (o *overlay) Get(key stoabs.Key) ([]byte, error) {
+	k := string(key.Bytes())
+	if _, deleted := o.changes.deleted[k]; deleted {
+		return nil, stoabs.ErrKeyNotFound
+	}
+	if value, changed := o.changes.entries[k]; changed {
+		return bytes.Clone(value), nil
+	}
+	return o.base.Get(key)
+}
+
+func (o *overlay) Put(key stoabs.Key, value []byte) error {
+	o.changes.put(string(key.Bytes()), value)
+	return nil
+}
+
+func (o *overlay) Delete(key stoabs.Key) error {
+	o.changes.delete(string(key.Bytes()))
+	return nil
+}
+
+func (o *overlay) Iterate(callback stoabs.CallerFn, keyType stoabs.Key) error {
+	return o.merge(func(fn stoabs.CallerFn) error {
+		return o.base.Iterate(fn, keyType)
+	}, keyType, func(string) bool { return true }, callback)
+}
+
+func (o *overlay) Range(from stoabs.Key, to stoabs.Key, callback stoabs.CallerFn, stopAtNil bool) error {
+	if stopAtNil {
+		inner := callback
+		var prevKey stoabs.Key
+		callback = func(key stoabs.Key, value []byte) error {
+			if prevKey != nil && !prevKey.Next().Equals(key) {
+				// gap found, stop here
+				return errStop
+			}
+			prevKey = key
+			return inner(key, value)
+		}
+	}
+	fromKey, toKey := string(from.Bytes()), string(to.Bytes())
+	err := o.merge(func(fn stoabs.CallerFn) error {
+		return o.base.Range(from, to, fn, false)
+	}, from, func(k string) bool { return k >= fromKey && k < toKey }, callback)
+	if errors.Is(err, errStop) {
+		return nil
+	}
+	return err
+}
+
+// merge calls the callback for the entries visited by the base reader (using visit) that weren't changed,
+// and for the changed entries for which inRange returns true. If the base reader visits keys in byte order,
+// the merged entries are visited in byte order as well.
+func (o *overlay) merge(visit func(stoabs.CallerFn) error, keyType stoabs.Key, inRange func(string) bool, callback stoabs.CallerFn) error {
+	// the keys changed when iteration started, keys changed by the callback are visited in the order of the base reader
+	keys := o.changes.sortedKeys()
+	next := 0
+	// visitChanged visits the changed entries with keys before the given key, or all remaining entries if it's nil
+	visitChanged := func(before *string) error {
+		for ; next < len(keys) && (before == nil || keys[next] < *before); next++ {
+			if o.ctx.Err() != nil {
+				return stoabs.DatabaseError(o.ctx.Err())
+			}
+			k := keys[next]
+			value, exists := o.changes.entries[k]
+			if !exists || !inRange(k) {
+				// deleted by the callback, or not in range
+				continue
+			}
+			key, err := keyType.FromBytes([]byte(k))
+			if err != nil {
+				return err
+			}
+			if err := callback(key, bytes.Clone(value)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	err := visit(func(key stoabs.Key, value []byte) error {
+		k := string(key.Bytes())
+		if err := visitChanged(&k); err != nil {
+			return err
+		}
+		if _, deleted := o.changes.deleted[k]; deleted {
+			return nil
+		}
+		if changed, exists := o.changes.entries[k]; exists {
+			if i := sort.SearchStrings(keys, k); i < len(keys) && keys[i] == k {
+				// visited in the order of the changed keys
+				return nil
+			}
+			value = bytes.Clone(changed)
+		}
+		return callback(key, value)
+	})
+	if err != nil {
+		return err
+	}
+	return visitChanged(nil)
+}
+
+// Stats counts the entries of the merged shelf.
+func (o *overlay) Stats() stoabs.ShelfStats {
+	var result stoabs.ShelfStats
+	_ = o.Iterate(func(key stoabs.Key, value []byte) error {
+		result.NumEntries++
+		result.ShelfSize += uint(len(key.Bytes()) + len(value))
+		return nil
+	}, stoabs.BytesKey{})
+	return result
+}
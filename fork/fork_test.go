@@ -0,0 +1,139 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package fork
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+func TestStore(t *testing.T) {
+	provider := func(t *testing.T) (stoabs.KVStore, error) {
+		store := stores.BBolt(t)
+		return Wrap(store), nil
+	}
+
+	kvtests.TestReadingAndWriting(t, provider)
+	kvtests.TestRange(t, provider)
+	kvtests.TestIterate(t, provider)
+	kvtests.TestOrderedIteration(t, provider)
+	kvtests.TestEmpty(t, provider)
+	kvtests.TestClose(t, provider)
+	kvtests.TestDelete(t, provider)
+	kvtests.TestStats(t, provider)
+	kvtests.TestWriteTransactions(t, provider)
+	kvtests.TestTransactionWriteLock(t, provider)
+	kvtests.TestByteTransparency(t, provider)
+	kvtests.TestLinearizability(t, provider)
+	kvtests.TestErrors(t, provider)
+	kvtests.TestShelfNames(t, provider)
+}
+
+func TestStore_copyOnWrite(t *testing.T) {
+	const shelf = "shelf"
+	store := stores.BBolt(t)
+	require.NoError(t, store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+		for _, key := range []string{"a", "c", "e"} {
+			if err := writer.Put(stoabs.BytesKey(key), []byte("original "+key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	forked := Wrap(store)
+	defer forked.Close(ctx)
+	require.NoError(t, forked.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+		_ = writer.Put(stoabs.BytesKey("b"), []byte("forked b"))
+		_ = writer.Put(stoabs.BytesKey("c"), []byte("forked c"))
+		_ = writer.Put(stoabs.BytesKey("f"), []byte("forked f"))
+		return writer.Delete(stoabs.BytesKey("e"))
+	}))
+	entries := func(store stoabs.KVStore) []string {
+		var result []string
+		require.NoError(t, store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+			return reader.Iterate(func(key stoabs.Key, value []byte) error {
+				result = append(result, string(key.Bytes())+"="+string(value))
+				return nil
+			}, stoabs.BytesKey{})
+		}))
+		return result
+	}
+
+	t.Run("fork sees its changes in byte order", func(t *testing.T) {
+		assert.Equal(t, []string{"a=original a", "b=forked b", "c=forked c", "f=forked f"}, entries(forked))
+	})
+	t.Run("original is unaffected", func(t *testing.T) {
+		assert.Equal(t, []string{"a=original a", "c=original c", "e=original e"}, entries(store))
+	})
+	t.Run("range", func(t *testing.T) {
+		var keys []string
+		require.NoError(t, forked.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+			return reader.Range(stoabs.BytesKey("b"), stoabs.BytesKey("f"), func(key stoabs.Key, _ []byte) error {
+				keys = append(keys, string(key.Bytes()))
+				return nil
+			}, false)
+		}))
+		assert.Equal(t, []string{"b", "c"}, keys)
+	})
+	t.Run("range stops at gaps in merged keys", func(t *testing.T) {
+		var keys []string
+		require.NoError(t, forked.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+			return reader.Range(stoabs.BytesKey("a"), stoabs.BytesKey("z"), func(key stoabs.Key, _ []byte) error {
+				keys = append(keys, string(key.Bytes()))
+				return nil
+			}, true)
+		}))
+		assert.Equal(t, []string{"a", "b", "c"}, keys)
+	})
+	t.Run("get", func(t *testing.T) {
+		require.NoError(t, forked.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+			value, err := reader.Get(stoabs.BytesKey("a"))
+			assert.NoError(t, err)
+			assert.Equal(t, "original a", string(value))
+			_, err = reader.Get(stoabs.BytesKey("e"))
+			assert.ErrorIs(t, err, stoabs.ErrKeyNotFound)
+			return nil
+		}))
+	})
+	t.Run("rolled back changes are discarded", func(t *testing.T) {
+		err := forked.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+			_ = writer.Put(stoabs.BytesKey("g"), []byte("rolled back"))
+			return assert.AnError
+		})
+
+		assert.ErrorIs(t, err, assert.AnError)
+		assert.Len(t, entries(forked), 4)
+	})
+	t.Run("closing the fork doesn't close the original", func(t *testing.T) {
+		other := Wrap(store)
+
+		require.NoError(t, other.Close(ctx))
+
+		assert.ErrorIs(t, other.Read(ctx, func(stoabs.ReadTx) error { return nil }), stoabs.ErrStoreIsClosed)
+		assert.NoError(t, store.Read(ctx, func(stoabs.ReadTx) error { return nil }))
+	})
+}
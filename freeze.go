@@ -0,0 +1,62 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrFrozen is returned when starting a write transaction on a store that has been frozen, see Freezer.
+var ErrFrozen = errors.New("store is frozen")
+
+// Freezer is implemented by stores that can be made read-only at runtime, e.g. during backups, migrations or incident response.
+type Freezer interface {
+	// Freeze makes new write transactions fail with ErrFrozen, and waits for in-flight write transactions to finish.
+	// Read transactions aren't affected. If the context is done first, a ErrDatabase with the context error is returned
+	// and the store stays frozen: call Freeze again to wait for the remaining write transactions, or Unfreeze.
+	// Freezing a frozen store only waits for in-flight write transactions (if any).
+	Freeze(ctx context.Context) error
+	// Unfreeze allows write transactions again. Unfreezing a store that isn't frozen does nothing.
+	Unfreeze()
+	// Frozen returns whether the store is frozen.
+	Frozen() bool
+}
+
+// Freeze freezes the given store, see Freezer.Freeze.
+// If the store does not implement Freezer, it returns errors.ErrUnsupported.
+func Freeze(ctx context.Context, store Store) error {
+	freezer, ok := store.(Freezer)
+	if !ok {
+		return fmt.Errorf("freezing %T: %w", store, errors.ErrUnsupported)
+	}
+	return freezer.Freeze(ctx)
+}
+
+// Unfreeze unfreezes the given store, see Freezer.Unfreeze.
+// If the store does not implement Freezer, it returns errors.ErrUnsupported.
+func Unfreeze(store Store) error {
+	freezer, ok := store.(Freezer)
+	if !ok {
+		return fmt.Errorf("unfreezing %T: %w", store, errors.ErrUnsupported)
+	}
+	freezer.Unfreeze()
+	return nil
+}
@@ -0,0 +1,65 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+	"testing"
+)
+
+func TestFreeze(t *testing.T) {
+	t.Run("supported", func(t *testing.T) {
+		f := &freezer{}
+		store := struct {
+			KVStore
+			Freezer
+		}{Freezer: f}
+
+		assert.NoError(t, Freeze(context.Background(), store))
+		assert.True(t, f.frozen)
+		assert.NoError(t, Unfreeze(store))
+		assert.False(t, f.frozen)
+	})
+	t.Run("unsupported", func(t *testing.T) {
+		store := NewMockKVStore(gomock.NewController(t))
+
+		assert.ErrorIs(t, Freeze(context.Background(), store), errors.ErrUnsupported)
+		assert.ErrorIs(t, Unfreeze(store), errors.ErrUnsupported)
+	})
+}
+
+type freezer struct {
+	frozen bool
+}
+
+func (f *freezer) Freeze(_ context.Context) error {
+	f.frozen = true
+	return nil
+}
+
+func (f *freezer) Unfreeze() {
+	f.frozen = false
+}
+
+func (f *freezer) Frozen() bool {
+	return f.frozen
+}
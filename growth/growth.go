@@ -0,0 +1,217 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package growth samples the number of entries and size of shelves periodically, so their growth over time can be queried.
+package growth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/timeseries"
+	"github.com/sirupsen/logrus"
+)
+
+// sampleShelf is the time-series shelf holding the samples. Every point holds the samples of all shelves taken at its time.
+const sampleShelf = stoabs.ReservedShelfPrefix + "growth"
+
+const defaultInterval = 5 * time.Minute
+
+const defaultRetention = 30 * 24 * time.Hour
+
+// Sample holds the number of entries and size of a shelf at a point in time, as reported by stoabs.Reader.Stats.
+type Sample struct {
+	Time    time.Time `json:"-"`
+	Shelf   string    `json:"shelf"`
+	Entries uint      `json:"entries"`
+	Size    uint      `json:"size"`
+}
+
+// Growth is the change of a shelf between two samples, see Sampler.Growth.
+type Growth struct {
+	First Sample
+	Last  Sample
+}
+
+// Entries returns the change of the number of entries, which is negative if the shelf shrunk.
+func (g Growth) Entries() int64 {
+	return int64(g.Last.Entries) - int64(g.First.Entries)
+}
+
+// Size returns the change of the size in bytes, which is negative if the shelf shrunk.
+func (g Growth) Size() int64 {
+	return int64(g.Last.Size) - int64(g.First.Size)
+}
+
+// Option configures the Sampler.
+type Option func(s *Sampler)
+
+// WithInterval sets the time Run waits between samples, which defaults to 5 minutes.
+func WithInterval(interval time.Duration) Option {
+	return func(s *Sampler) {
+		s.interval = interval
+	}
+}
+
+// WithRetention sets how long samples are kept, which defaults to 30 days. Older samples are removed when sampling,
+// so the samples form a ring buffer of the retention (at the granularity of the buckets of the time-series).
+func WithRetention(retention time.Duration) Option {
+	return func(s *Sampler) {
+		s.retention = retention
+	}
+}
+
+// WithShelves specifies the shelves to sample. By default, all shelves of the store (see stoabs.ShelfNames) are sampled,
+// except the reserved shelves used internally by stoabs packages.
+func WithShelves(shelves ...string) Option {
+	return func(s *Sampler) {
+		s.shelves = shelves
+	}
+}
+
+// WithClock overrides the clock that determines the time of samples and when Run samples, which defaults to stoabs.SystemClock.
+func WithClock(clock stoabs.Clock) Option {
+	return func(s *Sampler) {
+		s.clock = clock
+	}
+}
+
+// WithLogger overrides the default logger.
+func WithLogger(log *logrus.Logger) Option {
+	return func(s *Sampler) {
+		s.log = log
+	}
+}
+
+// New creates a Sampler that records the number of entries and size of the shelves of the given store in the store itself.
+func New(store stoabs.KVStore, opts ...Option) *Sampler {
+	result := &Sampler{
+		store:     store,
+		interval:  defaultInterval,
+		retention: defaultRetention,
+		log:       logrus.StandardLogger(),
+		clock:     stoabs.SystemClock,
+	}
+	for _, opt := range opts {
+		opt(result)
+	}
+	result.series = timeseries.New(store, sampleShelf,
+		timeseries.WithBucketWidth(time.Hour),
+		timeseries.WithRetention(result.retention),
+		timeseries.WithClock(result.clock),
+	)
+	return result
+}
+
+// Sampler records samples of shelves. Use New to create it.
+type Sampler struct {
+	store     stoabs.KVStore
+	series    *timeseries.Series
+	interval  time.Duration
+	retention time.Duration
+	shelves   []string
+	log       *logrus.Logger
+	clock     stoabs.Clock
+}
+
+// Run samples the shelves until the given context is cancelled. Errors are logged and retried after the interval (see WithInterval).
+func (s *Sampler) Run(ctx context.Context) {
+	for {
+		err := s.Sample(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			s.log.WithError(err).Warn("Sampling shelves failed, retrying")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.clock.After(s.interval):
+		}
+	}
+}
+
+// Sample records a sample of every shelf, reading their statistics in a single read transaction.
+func (s *Sampler) Sample(ctx context.Context) error {
+	shelves := s.shelves
+	if shelves == nil {
+		var err error
+		if shelves, err = stoabs.ShelfNames(ctx, s.store); err != nil {
+			return fmt.Errorf("unable to list shelves: %w", err)
+		}
+	}
+	var samples []Sample
+	err := s.store.Read(ctx, func(tx stoabs.ReadTx) error {
+		for _, name := range shelves {
+			stats := tx.GetShelfReader(name).Stats()
+			samples = append(samples, Sample{Shelf: name, Entries: stats.NumEntries, Size: stats.ShelfSize})
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to read shelf statistics: %w", err)
+	}
+	value, err := json.Marshal(samples)
+	if err != nil {
+		return err
+	}
+	return s.series.Append(ctx, timeseries.Point{Time: s.clock.Now(), Value: value})
+}
+
+// Query calls fn for every sample taken from (inclusive) to (exclusive) the given times, ordered by time.
+func (s *Sampler) Query(ctx context.Context, from, to time.Time, fn func(Sample) error) error {
+	return s.series.Range(ctx, from, to, func(point timeseries.Point) error {
+		var samples []Sample
+		if err := json.Unmarshal(point.Value, &samples); err != nil {
+			return fmt.Errorf("invalid samples (time=%s): %w", point.Time, err)
+		}
+		for _, sample := range samples {
+			sample.Time = point.Time
+			if err := fn(sample); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// History returns the samples of the given shelf taken from (inclusive) to (exclusive) the given times, ordered by time.
+func (s *Sampler) History(ctx context.Context, shelf string, from, to time.Time) ([]Sample, error) {
+	var result []Sample
+	err := s.Query(ctx, from, to, func(sample Sample) error {
+		if sample.Shelf == shelf {
+			result = append(result, sample)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// Growth returns the change of the given shelf between its first and last sample taken from (inclusive) to (exclusive)
+// the given times. It returns false if the shelf wasn't sampled in that period.
+func (s *Sampler) Growth(ctx context.Context, shelf string, from, to time.Time) (Growth, bool, error) {
+	history, err := s.History(ctx, shelf, from, to)
+	if err != nil || len(history) == 0 {
+		return Growth{}, false, err
+	}
+	return Growth{First: history[0], Last: history[len(history)-1]}, true, nil
+}
@@ -0,0 +1,172 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package growth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/nuts-foundation/go-stoabs/mocks"
+	"github.com/nuts-foundation/go-stoabs/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+var now = time.Unix(1700000000, 0)
+
+func TestSampler(t *testing.T) {
+	t.Run("samples all shelves", func(t *testing.T) {
+		clock := mocks.NewClock(now)
+		store := stores.BBolt(t)
+		put(t, store, "users", 1, 2)
+		put(t, store, "sessions", 1)
+		sampler := New(store, WithClock(clock))
+
+		require.NoError(t, sampler.Sample(ctx))
+		clock.Advance(time.Minute)
+		put(t, store, "users", 3)
+		require.NoError(t, sampler.Sample(ctx))
+
+		var samples []Sample
+		require.NoError(t, sampler.Query(ctx, now, now.Add(time.Hour), func(sample Sample) error {
+			samples = append(samples, sample)
+			return nil
+		}))
+		require.Len(t, samples, 4)
+		assert.Equal(t, "sessions", samples[0].Shelf)
+		assert.Equal(t, uint(1), samples[0].Entries)
+		assert.Equal(t, "users", samples[1].Shelf)
+		assert.Equal(t, uint(2), samples[1].Entries)
+		assert.Equal(t, now.UnixNano(), samples[1].Time.UnixNano())
+		assert.Equal(t, uint(3), samples[3].Entries)
+		assert.Equal(t, now.Add(time.Minute).UnixNano(), samples[3].Time.UnixNano())
+		t.Run("internal shelves aren't sampled", func(t *testing.T) {
+			for _, sample := range samples {
+				assert.NotEqual(t, sampleShelf, sample.Shelf)
+			}
+		})
+	})
+	t.Run("history and growth of a shelf", func(t *testing.T) {
+		clock := mocks.NewClock(now)
+		store := stores.BBolt(t)
+		sampler := New(store, WithClock(clock), WithShelves("users"))
+		put(t, store, "users", 1, 2, 3)
+		require.NoError(t, sampler.Sample(ctx))
+		clock.Advance(time.Hour)
+		put(t, store, "users", 4, 5)
+		require.NoError(t, sampler.Sample(ctx))
+		clock.Advance(time.Hour)
+		require.NoError(t, store.WriteShelf(ctx, "users", func(writer stoabs.Writer) error {
+			return writer.Delete(stoabs.Uint32Key(1))
+		}))
+		require.NoError(t, sampler.Sample(ctx))
+
+		history, err := sampler.History(ctx, "users", now, clock.Now().Add(time.Second))
+		require.NoError(t, err)
+		growth, found, err := sampler.Growth(ctx, "users", now, clock.Now().Add(time.Second))
+		require.NoError(t, err)
+
+		require.Len(t, history, 3)
+		assert.Equal(t, []uint{3, 5, 4}, []uint{history[0].Entries, history[1].Entries, history[2].Entries})
+		assert.True(t, found)
+		assert.Equal(t, int64(1), growth.Entries())
+		assert.Equal(t, history[0], growth.First)
+		assert.Equal(t, history[2], growth.Last)
+		t.Run("shrinking", func(t *testing.T) {
+			growth, _, err := sampler.Growth(ctx, "users", now.Add(time.Hour), clock.Now().Add(time.Second))
+			require.NoError(t, err)
+			assert.Equal(t, int64(-1), growth.Entries())
+		})
+		t.Run("not sampled", func(t *testing.T) {
+			_, found, err := sampler.Growth(ctx, "sessions", now, clock.Now().Add(time.Second))
+			require.NoError(t, err)
+			assert.False(t, found)
+		})
+	})
+	t.Run("samples older than the retention are removed", func(t *testing.T) {
+		clock := mocks.NewClock(now)
+		store := stores.BBolt(t)
+		sampler := New(store, WithClock(clock), WithShelves("users"), WithRetention(2*time.Hour))
+		require.NoError(t, sampler.Sample(ctx))
+		clock.Advance(4 * time.Hour)
+		require.NoError(t, sampler.Sample(ctx))
+
+		history, err := sampler.History(ctx, "users", now, clock.Now().Add(time.Second))
+
+		require.NoError(t, err)
+		require.Len(t, history, 1)
+		assert.Equal(t, clock.Now().UnixNano(), history[0].Time.UnixNano())
+	})
+	t.Run("Run samples until cancelled", func(t *testing.T) {
+		clock := mocks.NewClock(now)
+		store := stores.BBolt(t)
+		sampler := New(store, WithClock(clock), WithShelves("users"), WithInterval(time.Minute))
+		ctx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			sampler.Run(ctx)
+			close(done)
+		}()
+		// wait until the first sample is taken and Run waits for the interval
+		util.WaitFor(t, func() (bool, error) {
+			return clock.Waiters() == 1, nil
+		}, 5*time.Second, "Run didn't wait for the interval")
+		clock.Advance(time.Minute)
+		util.WaitFor(t, func() (bool, error) {
+			history, err := sampler.History(ctx, "users", now, now.Add(time.Hour))
+			return len(history) == 2, err
+		}, 5*time.Second, "Run didn't sample again")
+
+		cancel()
+		<-done
+	})
+	t.Run("failing store", func(t *testing.T) {
+		store := stores.BBolt(t)
+		sampler := New(stoabs.Chain(store, failingInterceptor{}), WithShelves("users"))
+
+		err := sampler.Sample(ctx)
+
+		assert.EqualError(t, err, "unable to read shelf statistics: failed")
+	})
+}
+
+type failingInterceptor struct {
+	stoabs.NoopInterceptor
+}
+
+func (failingInterceptor) Transaction(context.Context, stoabs.TxInfo, func(ctx context.Context) error) error {
+	return errors.New("failed")
+}
+
+func put(t *testing.T, store stoabs.KVStore, shelf string, keys ...uint32) {
+	require.NoError(t, store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+		for _, key := range keys {
+			if err := writer.Put(stoabs.Uint32Key(key), []byte("value")); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
@@ -0,0 +1,220 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Histogram is the approximate distribution of the keys of a shelf across its keyspace,
+// which helps choosing split points (e.g. for sharding) and finding hotspots.
+// The keyspace between the smallest and largest key is divided into buckets of equal width,
+// based on the first 8 bytes of the keys as big-endian number.
+type Histogram struct {
+	// Buckets contains the buckets ordered by key. Buckets without keys are included with a zero Count.
+	Buckets []HistogramBucket
+	// Last is the largest key of the shelf.
+	Last Key
+}
+
+// HistogramBucket is a range of the keyspace in a Histogram.
+type HistogramBucket struct {
+	// From is the lower bound of the bucket (inclusive). The bucket ends at From of the next bucket (exclusive),
+	// the last bucket at Histogram.Last (inclusive). From of the first bucket is the smallest key of the shelf.
+	From Key
+	// Count is the number of keys in the bucket.
+	Count uint
+}
+
+// Total returns the number of keys in the histogram.
+func (h Histogram) Total() uint {
+	var total uint
+	for _, bucket := range h.Buckets {
+		total += bucket.Count
+	}
+	return total
+}
+
+// SplitPoints returns the bucket boundaries which divide the keys into the given number of parts of approximately equal size:
+// for each part, the boundary closest to where it should end. It returns at most parts-1 keys in order, fewer if the
+// histogram has too few (non-empty) buckets.
+func (h Histogram) SplitPoints(parts int) []Key {
+	total := h.Total()
+	if parts < 2 || total == 0 {
+		return nil
+	}
+	// before holds the number of keys before each bucket boundary
+	before := make([]uint, len(h.Buckets))
+	for i := 1; i < len(h.Buckets); i++ {
+		before[i] = before[i-1] + h.Buckets[i-1].Count
+	}
+	var result []Key
+	last := 0
+	for part := 1; part < parts; part++ {
+		target := total * uint(part) / uint(parts)
+		best := 0
+		for i := last + 1; i < len(h.Buckets) && before[i] < total; i++ {
+			if best == 0 || distance(before[i], target) < distance(before[best], target) {
+				best = i
+			}
+		}
+		if best != 0 && best != last && before[best] > 0 {
+			result = append(result, h.Buckets[best].From)
+			last = best
+		}
+	}
+	return result
+}
+
+func distance(a uint, b uint) uint {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+// KeyHistogrammer is implemented by Readers that compute a key histogram natively (e.g. without reading values), see KeyHistogram.
+type KeyHistogrammer interface {
+	// KeyHistogram returns the distribution of the keys of the shelf over (at most) the given number of buckets.
+	// The caller will have to supply the correct key type, such that the keys can be parsed.
+	KeyHistogram(buckets int, keyType Key) (Histogram, error)
+}
+
+// KeyHistogram returns the distribution of the keys of the shelf over (at most) the given number of buckets.
+// An empty shelf yields a Histogram without buckets.
+// If the reader does not implement KeyHistogrammer, it falls back to iterating over the shelf twice using Reader.Iterate.
+func KeyHistogram(reader Reader, buckets int, keyType Key) (Histogram, error) {
+	if histogrammer, ok := reader.(KeyHistogrammer); ok {
+		return histogrammer.KeyHistogram(buckets, keyType)
+	}
+	var first, last []byte
+	err := reader.Iterate(func(key Key, _ []byte) error {
+		if first == nil || bytes.Compare(key.Bytes(), first) < 0 {
+			first = key.Bytes()
+		}
+		if last == nil || bytes.Compare(key.Bytes(), last) > 0 {
+			last = key.Bytes()
+		}
+		return nil
+	}, keyType)
+	if err != nil || first == nil {
+		return Histogram{}, err
+	}
+	builder, err := NewHistogramBuilder(buckets, keyType, first, last)
+	if err != nil {
+		return Histogram{}, err
+	}
+	err = reader.Iterate(func(key Key, _ []byte) error {
+		builder.Add(key.Bytes())
+		return nil
+	}, keyType)
+	if err != nil {
+		return Histogram{}, err
+	}
+	return builder.Histogram()
+}
+
+// HistogramBuilder builds a Histogram from the keys of a shelf. It's used by KeyHistogrammer implementations.
+type HistogramBuilder struct {
+	keyType Key
+	first   Key
+	last    Key
+	// keyLength is the length of the keys if the key type only accepts keys of a fixed size, 0 otherwise.
+	keyLength int
+	// prefixLength is the number of bytes of the keys (at most 8) that determine the bucket.
+	prefixLength int
+	low          uint64
+	width        uint64
+	counts       []uint
+}
+
+// NewHistogramBuilder creates a HistogramBuilder for the given number of buckets, and smallest and largest key of the shelf.
+// Returns an error if buckets is smaller than 1, or the keys can't be parsed as the given key type.
+func NewHistogramBuilder(buckets int, keyType Key, first []byte, last []byte) (*HistogramBuilder, error) {
+	if buckets < 1 {
+		return nil, fmt.Errorf("invalid number of histogram buckets: %d", buckets)
+	}
+	firstKey, err := keyType.FromBytes(bytes.Clone(first))
+	if err != nil {
+		return nil, err
+	}
+	lastKey, err := keyType.FromBytes(bytes.Clone(last))
+	if err != nil {
+		return nil, err
+	}
+	result := &HistogramBuilder{keyType: keyType, first: firstKey, last: lastKey, prefixLength: 8}
+	if _, err := keyType.FromBytes(append(bytes.Clone(first), 0)); err != nil {
+		// the key type only accepts keys of a fixed size
+		result.keyLength = len(first)
+		result.prefixLength = min(len(first), 8)
+	}
+	result.low = result.prefix(first)
+	span := result.prefix(last) - result.low
+	count := uint64(buckets)
+	if span < count-1 {
+		count = span + 1
+	}
+	result.width = span/count + 1
+	result.counts = make([]uint, count)
+	return result, nil
+}
+
+// Add counts the given key, which must be between the first and last key the builder was created with.
+func (b *HistogramBuilder) Add(key []byte) {
+	b.counts[(b.prefix(key)-b.low)/b.width]++
+}
+
+// Histogram returns the Histogram of the added keys.
+func (b *HistogramBuilder) Histogram() (Histogram, error) {
+	result := Histogram{Buckets: make([]HistogramBucket, len(b.counts)), Last: b.last}
+	for i, count := range b.counts {
+		result.Buckets[i].Count = count
+		if i == 0 {
+			result.Buckets[i].From = b.first
+			continue
+		}
+		from, err := b.keyType.FromBytes(b.bound(b.low + uint64(i)*b.width))
+		if err != nil {
+			return Histogram{}, err
+		}
+		result.Buckets[i].From = from
+	}
+	return result, nil
+}
+
+// prefix returns the first prefixLength bytes of the key (padded with zeros) as big-endian number.
+func (b *HistogramBuilder) prefix(key []byte) uint64 {
+	var buf [8]byte
+	copy(buf[8-b.prefixLength:], key[:min(len(key), b.prefixLength)])
+	return binary.BigEndian.Uint64(buf[:])
+}
+
+// bound returns the smallest key that has the given prefix: padded with zeros for fixed-size keys,
+// and without trailing zeros for variable-size keys.
+func (b *HistogramBuilder) bound(prefix uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], prefix)
+	result := bytes.Clone(buf[8-b.prefixLength:])
+	if b.keyLength == 0 {
+		return bytes.TrimRight(result, "\x00")
+	}
+	return append(result, make([]byte, b.keyLength-len(result))...)
+}
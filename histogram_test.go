@@ -0,0 +1,161 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"testing"
+)
+
+func TestKeyHistogram(t *testing.T) {
+	// unordered, as Iterate doesn't guarantee ordering
+	keys := []Key{BytesKey("c"), BytesKey("a"), BytesKey("zz"), BytesKey("b"), BytesKey("y")}
+	iterate := func(callback CallerFn, _ Key) error {
+		for _, key := range keys {
+			if err := callback(key, []byte{1}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	t.Run("falls back to Iterate", func(t *testing.T) {
+		reader := NewMockReader(gomock.NewController(t))
+		reader.EXPECT().Iterate(gomock.Any(), BytesKey{}).DoAndReturn(iterate).Times(2)
+
+		actual, err := KeyHistogram(reader, 2, BytesKey{})
+
+		require.NoError(t, err)
+		require.Len(t, actual.Buckets, 2)
+		assert.Equal(t, BytesKey("a"), actual.Buckets[0].From)
+		assert.Equal(t, uint(3), actual.Buckets[0].Count)
+		assert.Equal(t, uint(2), actual.Buckets[1].Count)
+		assert.Equal(t, BytesKey("zz"), actual.Last)
+	})
+	t.Run("empty shelf", func(t *testing.T) {
+		reader := NewMockReader(gomock.NewController(t))
+		reader.EXPECT().Iterate(gomock.Any(), BytesKey{}).Return(nil)
+
+		actual, err := KeyHistogram(reader, 2, BytesKey{})
+
+		require.NoError(t, err)
+		assert.Empty(t, actual.Buckets)
+	})
+	t.Run("error", func(t *testing.T) {
+		reader := NewMockReader(gomock.NewController(t))
+		reader.EXPECT().Iterate(gomock.Any(), BytesKey{}).Return(errors.New("failed"))
+
+		_, err := KeyHistogram(reader, 2, BytesKey{})
+
+		assert.EqualError(t, err, "failed")
+	})
+}
+
+func TestHistogramBuilder(t *testing.T) {
+	t.Run("fixed-size keys", func(t *testing.T) {
+		builder, err := NewHistogramBuilder(4, Uint32Key(0), Uint32Key(10).Bytes(), Uint32Key(49).Bytes())
+		require.NoError(t, err)
+		for i := uint32(10); i < 50; i++ {
+			builder.Add(Uint32Key(i).Bytes())
+		}
+
+		actual, err := builder.Histogram()
+
+		require.NoError(t, err)
+		assert.Equal(t, []HistogramBucket{
+			{From: Uint32Key(10), Count: 10},
+			{From: Uint32Key(20), Count: 10},
+			{From: Uint32Key(30), Count: 10},
+			{From: Uint32Key(40), Count: 10},
+		}, actual.Buckets)
+	})
+	t.Run("hash keys are padded", func(t *testing.T) {
+		first := HashKey{0x00, 1}
+		last := HashKey{0xff, 1}
+		builder, err := NewHistogramBuilder(2, HashKey{}, first.Bytes(), last.Bytes())
+		require.NoError(t, err)
+		builder.Add(first.Bytes())
+		builder.Add(last.Bytes())
+
+		actual, err := builder.Histogram()
+
+		require.NoError(t, err)
+		require.Len(t, actual.Buckets, 2)
+		assert.Len(t, actual.Buckets[1].From.Bytes(), 32)
+		assert.Equal(t, uint(1), actual.Buckets[1].Count)
+	})
+	t.Run("bytes keys are trimmed", func(t *testing.T) {
+		// bucket width is 0x100
+		builder, err := NewHistogramBuilder(2, BytesKey{}, []byte("a"), []byte("a\x00\x00\x00\x00\x00\x01\xfe"))
+		require.NoError(t, err)
+
+		actual, err := builder.Histogram()
+
+		require.NoError(t, err)
+		assert.Equal(t, BytesKey("a\x00\x00\x00\x00\x00\x01"), actual.Buckets[1].From)
+	})
+	t.Run("fewer buckets than requested if the keyspace is small", func(t *testing.T) {
+		builder, err := NewHistogramBuilder(10, Uint32Key(0), Uint32Key(1).Bytes(), Uint32Key(3).Bytes())
+		require.NoError(t, err)
+
+		actual, err := builder.Histogram()
+
+		require.NoError(t, err)
+		assert.Len(t, actual.Buckets, 3)
+	})
+	t.Run("invalid number of buckets", func(t *testing.T) {
+		_, err := NewHistogramBuilder(0, Uint32Key(0), Uint32Key(1).Bytes(), Uint32Key(3).Bytes())
+
+		assert.EqualError(t, err, "invalid number of histogram buckets: 0")
+	})
+	t.Run("invalid keys", func(t *testing.T) {
+		_, err := NewHistogramBuilder(2, Uint32Key(0), []byte{1}, Uint32Key(3).Bytes())
+
+		assert.Error(t, err)
+	})
+}
+
+func TestHistogram_SplitPoints(t *testing.T) {
+	histogram := Histogram{Buckets: []HistogramBucket{
+		{From: Uint32Key(0), Count: 10},
+		{From: Uint32Key(10), Count: 10},
+		{From: Uint32Key(20), Count: 60},
+		{From: Uint32Key(30), Count: 20},
+		{From: Uint32Key(40), Count: 0},
+	}}
+
+	t.Run("equal parts", func(t *testing.T) {
+		assert.Equal(t, []Key{Uint32Key(20), Uint32Key(30)}, histogram.SplitPoints(3))
+	})
+	t.Run("bucket larger than a part", func(t *testing.T) {
+		assert.Equal(t, []Key{Uint32Key(20), Uint32Key(30)}, histogram.SplitPoints(5))
+	})
+	t.Run("no split point after the last key", func(t *testing.T) {
+		assert.Equal(t, []Key{Uint32Key(20)}, histogram.SplitPoints(2))
+	})
+	t.Run("single part", func(t *testing.T) {
+		assert.Empty(t, histogram.SplitPoints(1))
+	})
+	t.Run("empty histogram", func(t *testing.T) {
+		assert.Empty(t, Histogram{}.SplitPoints(2))
+	})
+}
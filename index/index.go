@@ -0,0 +1,557 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package index provides a KVStore that maintains secondary indexes on shelves, in the same transaction as the
+// indexed values are written.
+package index
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/util"
+)
+
+var _ stoabs.KVStore = (*Store)(nil)
+var _ stoabs.ShelfLister = (*Store)(nil)
+var _ stoabs.WriteTx = (*tx)(nil)
+var _ stoabs.Writer = (*shelf)(nil)
+
+// indexShelfPrefix is the prefix of the shelves holding the indexes, followed by the index name.
+// Index shelves are keyed by index value (stoabs.BytesKey), and hold the sorted keys of all entries with that index value:
+// each key is encoded as its length (4 bytes, big endian) followed by its bytes.
+const indexShelfPrefix = stoabs.ReservedShelfPrefix + "index/"
+
+// Definition declares a secondary index on a shelf.
+type Definition struct {
+	// Name identifies the index.
+	Name string
+	// Shelf is the name of the indexed shelf.
+	Shelf string
+	// KeyType is the type of the keys of the indexed shelf, used to parse them when querying the index.
+	// Defaults to stoabs.BytesKey.
+	KeyType stoabs.Key
+	// Extract returns the index values of an entry of the indexed shelf.
+	// It may return multiple values (e.g. tags), or none to leave the entry out of the index.
+	Extract func(key stoabs.Key, value []byte) ([][]byte, error)
+	// Unique specifies that an index value may only map to a single key: writing an entry with an index value of
+	// another entry fails with ErrUniqueViolation. Since the constraint is checked on every write, swapping the
+	// index values of two entries requires deleting one of them first.
+	Unique bool
+}
+
+// ErrUniqueViolation is returned when writing an entry violates a unique index.
+// Use errors.Is(err, ErrUniqueViolation{}) to check for any violation, or errors.As to inspect it.
+type ErrUniqueViolation struct {
+	// Index is the name of the violated index.
+	Index string
+	// Value is the index value that is already taken.
+	Value []byte
+	// Key is the key of the entry that has the index value.
+	Key []byte
+}
+
+func (e ErrUniqueViolation) Error() string {
+	return fmt.Sprintf("unique index %s violated: value %s is already used by key %s", e.Index, util.FormatKey(e.Value), util.FormatKey(e.Key))
+}
+
+func (e ErrUniqueViolation) Is(other error) bool {
+	_, ok := other.(ErrUniqueViolation)
+	return ok
+}
+
+func (d Definition) shelfName() string {
+	return indexShelfPrefix + d.Name
+}
+
+func (d Definition) keyType() stoabs.Key {
+	if d.KeyType == nil {
+		return stoabs.BytesKey{}
+	}
+	return d.KeyType
+}
+
+// Option configures the index store.
+type Option func(s *Store)
+
+// WithIndex declares the given index. Index names must be unique.
+func WithIndex(definition Definition) Option {
+	return func(s *Store) {
+		s.indexes[definition.Name] = definition
+		s.byShelf[definition.Shelf] = append(s.byShelf[definition.Shelf], definition)
+	}
+}
+
+// Wrap creates a store that maintains the declared indexes on every Put and Delete on the indexed shelves,
+// in the same transaction. Since the entries of an index value are stored together, concurrent write transactions
+// would overwrite each other's index updates; all write transactions therefore lock the index shelves (see stoabs.WithShelfLock).
+// Indexes work best for values with a high cardinality (e.g. fingerprints or identifiers), since every write of an entry rewrites
+// the entries of its index values.
+//
+// Entries written before an index was declared aren't indexed until Rebuild is called.
+func Wrap(store stoabs.KVStore, opts ...Option) *Store {
+	result := &Store{
+		underlying: store,
+		indexes:    map[string]Definition{},
+		byShelf:    map[string][]Definition{},
+	}
+	for _, opt := range opts {
+		opt(result)
+	}
+	for _, definition := range result.indexes {
+		result.indexShelves = append(result.indexShelves, definition.shelfName())
+	}
+	sort.Strings(result.indexShelves)
+	return result
+}
+
+// Store is a KVStore that maintains secondary indexes. Use Wrap to create it.
+type Store struct {
+	underlying stoabs.KVStore
+	indexes    map[string]Definition
+	byShelf    map[string][]Definition
+	// indexShelves holds the names of the index shelves, which are locked by every write transaction.
+	indexShelves []string
+}
+
+// Lookup calls fn with the key and value of every entry of the indexed shelf with the given index value, in key order.
+func (s *Store) Lookup(ctx context.Context, name string, indexValue []byte, fn stoabs.CallerFn) error {
+	definition, err := s.definition(name)
+	if err != nil {
+		return err
+	}
+	return s.underlying.Read(ctx, func(tx stoabs.ReadTx) error {
+		keys, err := readPosting(tx.GetShelfReader(definition.shelfName()), indexValue)
+		if err != nil {
+			return err
+		}
+		return visit(tx.GetShelfReader(definition.Shelf), definition, keys, fn)
+	})
+}
+
+// QueryIndex calls fn with the key and value of every entry of the indexed shelf with an index value from (inclusive)
+// to (exclusive) the given index values, ordered by index value and then key.
+// Index values are visited using stoabs.Reader.Range, so it's subject to the same backend limitations
+// (e.g. Redis only visits index values that can be reached using stoabs.Key.Next). Use Lookup for exact matches instead.
+func (s *Store) QueryIndex(ctx context.Context, name string, from, to []byte, fn stoabs.CallerFn) error {
+	definition, err := s.definition(name)
+	if err != nil {
+		return err
+	}
+	return s.underlying.Read(ctx, func(tx stoabs.ReadTx) error {
+		reader := tx.GetShelfReader(definition.Shelf)
+		return tx.GetShelfReader(definition.shelfName()).Range(stoabs.BytesKey(from), stoabs.BytesKey(to), func(_ stoabs.Key, posting []byte) error {
+			keys, err := decodePosting(posting)
+			if err != nil {
+				return err
+			}
+			return visit(reader, definition, keys, fn)
+		}, false)
+	})
+}
+
+// Rebuild recreates the given index from the current entries of the indexed shelf, in a single write transaction.
+// It's required after declaring an index on a shelf that already contains entries, or after changing its Extract function.
+func (s *Store) Rebuild(ctx context.Context, name string) error {
+	definition, err := s.definition(name)
+	if err != nil {
+		return err
+	}
+	return s.underlying.Write(ctx, func(tx stoabs.WriteTx) error {
+		writer := tx.GetShelfWriter(definition.shelfName())
+		var stale []stoabs.Key
+		err := writer.Iterate(func(key stoabs.Key, _ []byte) error {
+			stale = append(stale, key)
+			return nil
+		}, stoabs.BytesKey{})
+		if err != nil {
+			return err
+		}
+		for _, key := range stale {
+			if err := writer.Delete(key); err != nil {
+				return err
+			}
+		}
+		postings := map[string][][]byte{}
+		err = tx.GetShelfReader(definition.Shelf).Iterate(func(key stoabs.Key, value []byte) error {
+			indexValues, err := definition.Extract(key, value)
+			if err != nil {
+				return err
+			}
+			for _, indexValue := range indexValues {
+				if err := checkUnique(definition, indexValue, postings[string(indexValue)], key.Bytes()); err != nil {
+					return err
+				}
+				postings[string(indexValue)] = insertKey(postings[string(indexValue)], key.Bytes())
+			}
+			return nil
+		}, definition.keyType())
+		if err != nil {
+			return err
+		}
+		for indexValue, keys := range postings {
+			if err := writer.Put(stoabs.BytesKey(indexValue), encodePosting(keys)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, stoabs.WithShelfLock(definition.shelfName()))
+}
+
+func (s *Store) definition(name string) (Definition, error) {
+	definition, ok := s.indexes[name]
+	if !ok {
+		return Definition{}, fmt.Errorf("unknown index: %s", name)
+	}
+	return definition, nil
+}
+
+func (s *Store) Close(ctx context.Context) error {
+	return s.underlying.Close(ctx)
+}
+
+func (s *Store) Write(ctx context.Context, fn func(stoabs.WriteTx) error, opts ...stoabs.TxOption) error {
+	if len(s.indexShelves) > 0 {
+		opts = append(opts, stoabs.WithShelfLock(s.indexShelves...))
+	}
+	return s.underlying.Write(ctx, func(underlyingTx stoabs.WriteTx) error {
+		t := &tx{ReadTx: underlyingTx, writeTx: underlyingTx, store: s, values: map[valueCacheKey][]byte{}, postings: map[postingKey][][]byte{}}
+		if err := fn(t); err != nil {
+			return err
+		}
+		return t.flush()
+	}, opts...)
+}
+
+func (s *Store) WriteShelf(ctx context.Context, shelfName string, fn func(stoabs.Writer) error) error {
+	return s.Write(ctx, func(tx stoabs.WriteTx) error {
+		return fn(tx.GetShelfWriter(shelfName))
+	})
+}
+
+func (s *Store) Read(ctx context.Context, fn func(stoabs.ReadTx) error) error {
+	return s.underlying.Read(ctx, func(underlyingTx stoabs.ReadTx) error {
+		return fn(&tx{ReadTx: underlyingTx, store: s})
+	})
+}
+
+func (s *Store) ReadShelf(ctx context.Context, shelfName string, fn func(stoabs.Reader) error) error {
+	return s.Read(ctx, func(tx stoabs.ReadTx) error {
+		return fn(tx.GetShelfReader(shelfName))
+	})
+}
+
+// ShelfNames returns the shelves of the underlying store. The index shelves are reserved,
+// so they're only listed when requested using stoabs.ContextWithReservedShelves.
+func (s *Store) ShelfNames(ctx context.Context) ([]string, error) {
+	return stoabs.ShelfNames(ctx, s.underlying)
+}
+
+// postingKey identifies the entries of an index value.
+type postingKey struct {
+	index      string
+	indexValue string
+}
+
+type tx struct {
+	stoabs.ReadTx
+	writeTx stoabs.WriteTx
+	store   *Store
+	// values holds the values written to indexed shelves in this transaction (nil if deleted), to determine the index values
+	// to remove on subsequent writes to the same key, since not all backends can read values written in the same transaction.
+	values map[valueCacheKey][]byte
+	// postings holds the keys of the index values changed in this transaction, which are written when the transaction completes.
+	postings map[postingKey][][]byte
+}
+
+func (t *tx) GetShelfReader(shelfName string) stoabs.Reader {
+	return t.ReadTx.GetShelfReader(shelfName)
+}
+
+func (t *tx) GetShelfWriter(shelfName string) stoabs.Writer {
+	writer := t.writeTx.GetShelfWriter(shelfName)
+	definitions := t.store.byShelf[shelfName]
+	if len(definitions) == 0 {
+		return writer
+	}
+	return &shelf{Writer: writer, name: shelfName, definitions: definitions, tx: t}
+}
+
+func (t *tx) Store() stoabs.KVStore {
+	return t.store
+}
+
+// posting returns the keys of the given index value, as changed in this transaction.
+func (t *tx) posting(definition Definition, indexValue []byte) ([][]byte, error) {
+	pk := postingKey{index: definition.Name, indexValue: string(indexValue)}
+	if keys, ok := t.postings[pk]; ok {
+		return keys, nil
+	}
+	keys, err := readPosting(t.writeTx.GetShelfWriter(definition.shelfName()), indexValue)
+	if err != nil {
+		return nil, err
+	}
+	t.postings[pk] = keys
+	return keys, nil
+}
+
+// flush writes the index values changed in this transaction.
+func (t *tx) flush() error {
+	for pk, keys := range t.postings {
+		writer := t.writeTx.GetShelfWriter(t.store.indexes[pk.index].shelfName())
+		var err error
+		if len(keys) == 0 {
+			err = writer.Delete(stoabs.BytesKey(pk.indexValue))
+		} else {
+			err = writer.Put(stoabs.BytesKey(pk.indexValue), encodePosting(keys))
+		}
+		if err != nil {
+			return fmt.Errorf("unable to update index %s: %w", pk.index, err)
+		}
+	}
+	return nil
+}
+
+type shelf struct {
+	stoabs.Writer
+	name        string
+	definitions []Definition
+	tx          *tx
+}
+
+func (s *shelf) Put(key stoabs.Key, value []byte) error {
+	oldValue, exists, err := s.currentValue(key)
+	if err != nil {
+		return err
+	}
+	// check all unique indexes before changing any index, so a violation leaves the transaction untouched
+	for _, definition := range s.definitions {
+		if err := s.checkUnique(definition, key, value); err != nil {
+			return err
+		}
+	}
+	for _, definition := range s.definitions {
+		if err := s.reindex(definition, key, oldValue, exists, value, true); err != nil {
+			return err
+		}
+	}
+	if err := s.Writer.Put(key, value); err != nil {
+		return err
+	}
+	s.tx.values[cacheKey(s.name, key)] = bytes.Clone(value)
+	return nil
+}
+
+func (s *shelf) Delete(key stoabs.Key) error {
+	oldValue, exists, err := s.currentValue(key)
+	if err != nil {
+		return err
+	}
+	for _, definition := range s.definitions {
+		if err := s.reindex(definition, key, oldValue, exists, nil, false); err != nil {
+			return err
+		}
+	}
+	if err := s.Writer.Delete(key); err != nil {
+		return err
+	}
+	s.tx.values[cacheKey(s.name, key)] = nil
+	return nil
+}
+
+// reindex moves the key from the index values of the old value to the index values of the new value.
+func (s *shelf) reindex(definition Definition, key stoabs.Key, oldValue []byte, oldExists bool, newValue []byte, newExists bool) error {
+	var oldIndexValues, newIndexValues [][]byte
+	var err error
+	if oldExists {
+		if oldIndexValues, err = definition.Extract(key, oldValue); err != nil {
+			return fmt.Errorf("unable to extract values of index %s: %w", definition.Name, err)
+		}
+	}
+	if newExists {
+		if newIndexValues, err = definition.Extract(key, newValue); err != nil {
+			return fmt.Errorf("unable to extract values of index %s: %w", definition.Name, err)
+		}
+	}
+	for _, indexValue := range oldIndexValues {
+		keys, err := s.tx.posting(definition, indexValue)
+		if err != nil {
+			return err
+		}
+		s.tx.postings[postingKey{index: definition.Name, indexValue: string(indexValue)}] = removeKey(keys, key.Bytes())
+	}
+	for _, indexValue := range newIndexValues {
+		keys, err := s.tx.posting(definition, indexValue)
+		if err != nil {
+			return err
+		}
+		s.tx.postings[postingKey{index: definition.Name, indexValue: string(indexValue)}] = insertKey(keys, key.Bytes())
+	}
+	return nil
+}
+
+// checkUnique returns ErrUniqueViolation if the definition is a unique index, and an index value of the given value is
+// already used by another key.
+func (s *shelf) checkUnique(definition Definition, key stoabs.Key, value []byte) error {
+	if !definition.Unique {
+		return nil
+	}
+	indexValues, err := definition.Extract(key, value)
+	if err != nil {
+		return fmt.Errorf("unable to extract values of index %s: %w", definition.Name, err)
+	}
+	for _, indexValue := range indexValues {
+		keys, err := s.tx.posting(definition, indexValue)
+		if err != nil {
+			return err
+		}
+		if err := checkUnique(definition, indexValue, keys, key.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// currentValue returns the current value of the given key, and whether it exists.
+func (s *shelf) currentValue(key stoabs.Key) ([]byte, bool, error) {
+	if value, ok := s.tx.values[cacheKey(s.name, key)]; ok {
+		return value, value != nil, nil
+	}
+	value, err := s.Writer.Get(key)
+	if errors.Is(err, stoabs.ErrKeyNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return bytes.Clone(value), true, nil
+}
+
+// valueCacheKey identifies a key in the value cache of a transaction.
+type valueCacheKey struct {
+	shelf string
+	key   string
+}
+
+func cacheKey(shelfName string, key stoabs.Key) valueCacheKey {
+	return valueCacheKey{shelf: shelfName, key: string(key.Bytes())}
+}
+
+// checkUnique returns ErrUniqueViolation if the index is unique, and the index value maps to another key than the given one.
+func checkUnique(definition Definition, indexValue []byte, keys [][]byte, key []byte) error {
+	if !definition.Unique {
+		return nil
+	}
+	for _, other := range keys {
+		if !bytes.Equal(other, key) {
+			return ErrUniqueViolation{Index: definition.Name, Value: bytes.Clone(indexValue), Key: other}
+		}
+	}
+	return nil
+}
+
+// visit calls fn with each of the given keys and its value in the indexed shelf.
+// Keys that don't exist in the indexed shelf are skipped.
+func visit(reader stoabs.Reader, definition Definition, keys [][]byte, fn stoabs.CallerFn) error {
+	for _, keyBytes := range keys {
+		key, err := definition.keyType().FromBytes(keyBytes)
+		if err != nil {
+			return fmt.Errorf("invalid key in index %s: %w", definition.Name, err)
+		}
+		value, err := reader.Get(key)
+		if errors.Is(err, stoabs.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readPosting(reader stoabs.Reader, indexValue []byte) ([][]byte, error) {
+	data, err := reader.Get(stoabs.BytesKey(indexValue))
+	if errors.Is(err, stoabs.ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodePosting(data)
+}
+
+func encodePosting(keys [][]byte) []byte {
+	var result []byte
+	for _, key := range keys {
+		result = binary.BigEndian.AppendUint32(result, uint32(len(key)))
+		result = append(result, key...)
+	}
+	return result
+}
+
+func decodePosting(data []byte) ([][]byte, error) {
+	var result [][]byte
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, errors.New("invalid index entry")
+		}
+		length := binary.BigEndian.Uint32(data)
+		data = data[4:]
+		if uint64(len(data)) < uint64(length) {
+			return nil, errors.New("invalid index entry")
+		}
+		result = append(result, bytes.Clone(data[:length]))
+		data = data[length:]
+	}
+	return result, nil
+}
+
+// insertKey adds the key to the sorted keys, if it isn't present yet.
+func insertKey(keys [][]byte, key []byte) [][]byte {
+	i, found := sort.Find(len(keys), func(i int) int {
+		return bytes.Compare(key, keys[i])
+	})
+	if found {
+		return keys
+	}
+	result := make([][]byte, 0, len(keys)+1)
+	result = append(result, keys[:i]...)
+	result = append(result, key)
+	return append(result, keys[i:]...)
+}
+
+// removeKey removes the key from the sorted keys, if present.
+func removeKey(keys [][]byte, key []byte) [][]byte {
+	i, found := sort.Find(len(keys), func(i int) int {
+		return bytes.Compare(key, keys[i])
+	})
+	if !found {
+		return keys
+	}
+	result := make([][]byte, 0, len(keys)-1)
+	result = append(result, keys[:i]...)
+	return append(result, keys[i+1:]...)
+}
@@ -0,0 +1,340 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package index
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/nuts-foundation/go-stoabs/redis7"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+const shelfName = "fruit"
+
+// byColor indexes values of the form "<color>:<name>" by color.
+var byColor = Definition{
+	Name:  "byColor",
+	Shelf: shelfName,
+	Extract: func(_ stoabs.Key, value []byte) ([][]byte, error) {
+		color, _, ok := strings.Cut(string(value), ":")
+		if !ok {
+			return nil, nil
+		}
+		return [][]byte{[]byte(color)}, nil
+	},
+}
+
+func TestIndex(t *testing.T) {
+	provider := func(t *testing.T) (stoabs.KVStore, error) {
+		return Wrap(stores.BBolt(t), WithIndex(Definition{Name: "test", Shelf: "test", Extract: byColor.Extract})), nil
+	}
+
+	kvtests.TestReadingAndWriting(t, provider)
+	kvtests.TestRange(t, provider)
+	kvtests.TestIterate(t, provider)
+	kvtests.TestEmpty(t, provider)
+	kvtests.TestDelete(t, provider)
+	kvtests.TestWriteTransactions(t, provider)
+	kvtests.TestShelfNames(t, provider)
+}
+
+func TestStore_Lookup(t *testing.T) {
+	t.Run("maintains index on put and delete", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t), WithIndex(byColor))
+		write(t, store, func(writer stoabs.Writer) error {
+			_ = writer.Put(stoabs.BytesKey("2"), []byte("red:cherry"))
+			_ = writer.Put(stoabs.BytesKey("1"), []byte("red:apple"))
+			return writer.Put(stoabs.BytesKey("3"), []byte("yellow:banana"))
+		})
+
+		assert.Equal(t, []string{"1=red:apple", "2=red:cherry"}, lookup(t, store, "red"))
+		assert.Equal(t, []string{"3=yellow:banana"}, lookup(t, store, "yellow"))
+
+		write(t, store, func(writer stoabs.Writer) error {
+			// the apple turned green, the banana was eaten
+			_ = writer.Put(stoabs.BytesKey("1"), []byte("green:apple"))
+			return writer.Delete(stoabs.BytesKey("3"))
+		})
+
+		assert.Equal(t, []string{"2=red:cherry"}, lookup(t, store, "red"))
+		assert.Equal(t, []string{"1=green:apple"}, lookup(t, store, "green"))
+		assert.Empty(t, lookup(t, store, "yellow"))
+		// index values without entries are removed
+		_ = store.underlying.ReadShelf(ctx, byColor.shelfName(), func(reader stoabs.Reader) error {
+			_, err := reader.Get(stoabs.BytesKey("yellow"))
+			assert.ErrorIs(t, err, stoabs.ErrKeyNotFound)
+			return nil
+		})
+	})
+	t.Run("multiple writes of a key in a transaction", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t), WithIndex(byColor))
+		write(t, store, func(writer stoabs.Writer) error {
+			_ = writer.Put(stoabs.BytesKey("1"), []byte("red:apple"))
+			_ = writer.Put(stoabs.BytesKey("1"), []byte("green:apple"))
+			_ = writer.Put(stoabs.BytesKey("2"), []byte("red:cherry"))
+			return writer.Delete(stoabs.BytesKey("2"))
+		})
+
+		assert.Empty(t, lookup(t, store, "red"))
+		assert.Equal(t, []string{"1=green:apple"}, lookup(t, store, "green"))
+	})
+	t.Run("rollback leaves index untouched", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t), WithIndex(byColor))
+
+		err := store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			_ = writer.Put(stoabs.BytesKey("1"), []byte("red:apple"))
+			return errors.New("failed")
+		})
+
+		require.Error(t, err)
+		assert.Empty(t, lookup(t, store, "red"))
+	})
+	t.Run("multi-valued index", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t), WithIndex(Definition{
+			Name:  "byTag",
+			Shelf: shelfName,
+			Extract: func(_ stoabs.Key, value []byte) ([][]byte, error) {
+				var result [][]byte
+				for _, tag := range strings.Split(string(value), ",") {
+					result = append(result, []byte(tag))
+				}
+				return result, nil
+			},
+		}))
+		write(t, store, func(writer stoabs.Writer) error {
+			return writer.Put(stoabs.BytesKey("1"), []byte("a,b"))
+		})
+
+		var keys []string
+		err := store.Lookup(ctx, "byTag", []byte("b"), func(key stoabs.Key, _ []byte) error {
+			keys = append(keys, string(key.(stoabs.BytesKey)))
+			return nil
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, []string{"1"}, keys)
+	})
+	t.Run("extract error fails the write", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t), WithIndex(Definition{
+			Name:  "failing",
+			Shelf: shelfName,
+			Extract: func(_ stoabs.Key, _ []byte) ([][]byte, error) {
+				return nil, errors.New("invalid value")
+			},
+		}))
+
+		err := store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			return writer.Put(stoabs.BytesKey("1"), []byte("value"))
+		})
+
+		assert.EqualError(t, err, "unable to extract values of index failing: invalid value")
+	})
+	t.Run("unknown index", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t))
+
+		err := store.Lookup(ctx, "byColor", []byte("red"), nil)
+
+		assert.EqualError(t, err, "unknown index: byColor")
+	})
+	t.Run("Redis", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		underlying, err := redis7.CreateRedisStore("db", &redis.Options{Addr: mr.Addr()})
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = underlying.Close(ctx)
+		})
+		definition := byColor
+		definition.KeyType = stoabs.Uint32Key(0)
+		store := Wrap(underlying, WithIndex(definition))
+		write(t, store, func(writer stoabs.Writer) error {
+			_ = writer.Put(stoabs.Uint32Key(1), []byte("red:apple"))
+			_ = writer.Put(stoabs.Uint32Key(2), []byte("red:cherry"))
+			// Redis can't read values written in the same transaction
+			return writer.Put(stoabs.Uint32Key(1), []byte("green:apple"))
+		})
+
+		var keys []stoabs.Key
+		err = store.Lookup(ctx, "byColor", []byte("red"), func(key stoabs.Key, _ []byte) error {
+			keys = append(keys, key)
+			return nil
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, []stoabs.Key{stoabs.Uint32Key(2)}, keys)
+	})
+}
+
+func TestUnique(t *testing.T) {
+	unique := byColor
+	unique.Unique = true
+
+	t.Run("put fails if another key has the index value", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t), WithIndex(unique))
+		write(t, store, func(writer stoabs.Writer) error {
+			return writer.Put(stoabs.BytesKey("1"), []byte("red:apple"))
+		})
+
+		var putErr error
+		err := store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			putErr = writer.Put(stoabs.BytesKey("2"), []byte("red:cherry"))
+			// the failed put leaves the transaction untouched, so it can continue
+			return writer.Put(stoabs.BytesKey("3"), []byte("yellow:banana"))
+		})
+
+		require.NoError(t, err)
+		assert.ErrorIs(t, putErr, ErrUniqueViolation{})
+		var violation ErrUniqueViolation
+		require.ErrorAs(t, putErr, &violation)
+		assert.Equal(t, ErrUniqueViolation{Index: "byColor", Value: []byte("red"), Key: []byte("1")}, violation)
+		assert.EqualError(t, putErr, "unique index byColor violated: value red is already used by key 1")
+		assert.Equal(t, []string{"1=red:apple"}, lookup(t, store, "red"))
+		assert.Equal(t, []string{"3=yellow:banana"}, lookup(t, store, "yellow"))
+	})
+	t.Run("violation within a transaction", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t), WithIndex(unique))
+
+		err := store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			_ = writer.Put(stoabs.BytesKey("1"), []byte("red:apple"))
+			return writer.Put(stoabs.BytesKey("2"), []byte("red:cherry"))
+		})
+
+		assert.ErrorIs(t, err, ErrUniqueViolation{})
+		assert.Empty(t, lookup(t, store, "red"))
+	})
+	t.Run("rewriting a key and reusing values of deleted keys", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t), WithIndex(unique))
+		write(t, store, func(writer stoabs.Writer) error {
+			_ = writer.Put(stoabs.BytesKey("1"), []byte("red:apple"))
+			return writer.Put(stoabs.BytesKey("1"), []byte("red:apple2"))
+		})
+
+		write(t, store, func(writer stoabs.Writer) error {
+			_ = writer.Delete(stoabs.BytesKey("1"))
+			return writer.Put(stoabs.BytesKey("2"), []byte("red:cherry"))
+		})
+
+		assert.Equal(t, []string{"2=red:cherry"}, lookup(t, store, "red"))
+	})
+	t.Run("rebuild fails on violation", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		require.NoError(t, underlying.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			_ = writer.Put(stoabs.BytesKey("1"), []byte("red:apple"))
+			return writer.Put(stoabs.BytesKey("2"), []byte("red:cherry"))
+		}))
+
+		err := Wrap(underlying, WithIndex(unique)).Rebuild(ctx, "byColor")
+
+		assert.ErrorIs(t, err, ErrUniqueViolation{})
+	})
+}
+
+func TestStore_QueryIndex(t *testing.T) {
+	store := Wrap(stores.BBolt(t), WithIndex(byColor))
+	write(t, store, func(writer stoabs.Writer) error {
+		_ = writer.Put(stoabs.BytesKey("1"), []byte("red:apple"))
+		_ = writer.Put(stoabs.BytesKey("2"), []byte("blue:berry"))
+		_ = writer.Put(stoabs.BytesKey("3"), []byte("green:pear"))
+		return writer.Put(stoabs.BytesKey("4"), []byte("yellow:banana"))
+	})
+
+	var values []string
+	err := store.QueryIndex(ctx, "byColor", []byte("blue"), []byte("red"), func(_ stoabs.Key, value []byte) error {
+		values = append(values, string(value))
+		return nil
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"blue:berry", "green:pear"}, values)
+}
+
+func TestStore_Rebuild(t *testing.T) {
+	underlying := stores.BBolt(t)
+	require.NoError(t, underlying.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+		_ = writer.Put(stoabs.BytesKey("1"), []byte("red:apple"))
+		return writer.Put(stoabs.BytesKey("2"), []byte("red:cherry"))
+	}))
+	// stale index entry
+	require.NoError(t, underlying.WriteShelf(ctx, byColor.shelfName(), func(writer stoabs.Writer) error {
+		return writer.Put(stoabs.BytesKey("blue"), encodePosting([][]byte{[]byte("1")}))
+	}))
+	store := Wrap(underlying, WithIndex(byColor))
+
+	err := store.Rebuild(ctx, "byColor")
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1=red:apple", "2=red:cherry"}, lookup(t, store, "red"))
+	assert.Empty(t, lookup(t, store, "blue"))
+}
+
+func TestStore_ShelfNames(t *testing.T) {
+	store := Wrap(stores.BBolt(t), WithIndex(byColor))
+	write(t, store, func(writer stoabs.Writer) error {
+		return writer.Put(stoabs.BytesKey("1"), []byte("red:apple"))
+	})
+
+	names, err := store.ShelfNames(ctx)
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{shelfName}, names)
+}
+
+func Test_posting(t *testing.T) {
+	keys := insertKey(nil, []byte("b"))
+	keys = insertKey(keys, []byte("a"))
+	keys = insertKey(keys, []byte("c"))
+	keys = insertKey(keys, []byte("a"))
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b"), []byte("c")}, keys)
+
+	decoded, err := decodePosting(encodePosting(keys))
+	require.NoError(t, err)
+	assert.Equal(t, keys, decoded)
+
+	keys = removeKey(keys, []byte("b"))
+	keys = removeKey(keys, []byte("d"))
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("c")}, keys)
+
+	_, err = decodePosting([]byte{0, 0, 0, 5, 1})
+	assert.EqualError(t, err, "invalid index entry")
+}
+
+func write(t *testing.T, store stoabs.KVStore, fn func(writer stoabs.Writer) error) {
+	require.NoError(t, store.WriteShelf(ctx, shelfName, fn))
+}
+
+// lookup returns the entries with the given color as "<key>=<value>".
+func lookup(t *testing.T, store *Store, color string) []string {
+	var result []string
+	err := store.Lookup(ctx, byColor.Name, []byte(color), func(key stoabs.Key, value []byte) error {
+		result = append(result, string(key.Bytes())+"="+string(value))
+		return nil
+	})
+	require.NoError(t, err)
+	return result
+}
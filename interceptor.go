@@ -0,0 +1,211 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import "context"
+
+// TxInfo describes a transaction intercepted by an Interceptor.
+type TxInfo struct {
+	// Writable is true for transactions started by Write or WriteShelf.
+	Writable bool
+	// Shelf holds the name of the shelf for transactions started by ReadShelf or WriteShelf, and is empty otherwise.
+	Shelf string
+	// Options holds the options passed to Write.
+	Options []TxOption
+}
+
+// Call describes the shelf operation intercepted by an Interceptor.
+type Call struct {
+	// Context holds the context of the transaction the operation is executed in.
+	Context context.Context
+	// Tx describes the transaction the operation is executed in.
+	Tx TxInfo
+	// Shelf holds the name of the shelf.
+	Shelf string
+}
+
+// Interceptor intercepts the operations on a store wrapped by Chain. Every method is passed the next step of the chain
+// (the next interceptor or eventually the store), which it should call unless it wants to short-circuit the operation.
+// It may alter the arguments passed to next and the results it returns, e.g. to encrypt values or validate keys.
+// Embed NoopInterceptor to only implement the methods of interest.
+type Interceptor interface {
+	// Transaction is called for every transaction. Calling next begins the transaction, runs it,
+	// and (for writable transactions) commits it, so errors returned by next include commit failures.
+	Transaction(ctx context.Context, tx TxInfo, next func(ctx context.Context) error) error
+	// Get is called for Reader.Get.
+	Get(call Call, key Key, next func(key Key) ([]byte, error)) ([]byte, error)
+	// Put is called for Writer.Put.
+	Put(call Call, key Key, value []byte, next func(key Key, value []byte) error) error
+	// Delete is called for Writer.Delete.
+	Delete(call Call, key Key, next func(key Key) error) error
+	// Iterate is called for Reader.Iterate.
+	Iterate(call Call, callback CallerFn, keyType Key, next func(callback CallerFn, keyType Key) error) error
+	// Range is called for Reader.Range.
+	Range(call Call, from Key, to Key, callback CallerFn, stopAtNil bool, next func(from Key, to Key, callback CallerFn, stopAtNil bool) error) error
+}
+
+// NoopInterceptor is an Interceptor that passes all operations on unaltered.
+type NoopInterceptor struct{}
+
+func (NoopInterceptor) Transaction(ctx context.Context, _ TxInfo, next func(ctx context.Context) error) error {
+	return next(ctx)
+}
+
+func (NoopInterceptor) Get(_ Call, key Key, next func(key Key) ([]byte, error)) ([]byte, error) {
+	return next(key)
+}
+
+func (NoopInterceptor) Put(_ Call, key Key, value []byte, next func(key Key, value []byte) error) error {
+	return next(key, value)
+}
+
+func (NoopInterceptor) Delete(_ Call, key Key, next func(key Key) error) error {
+	return next(key)
+}
+
+func (NoopInterceptor) Iterate(_ Call, callback CallerFn, keyType Key, next func(callback CallerFn, keyType Key) error) error {
+	return next(callback, keyType)
+}
+
+func (NoopInterceptor) Range(_ Call, from Key, to Key, callback CallerFn, stopAtNil bool, next func(from Key, to Key, callback CallerFn, stopAtNil bool) error) error {
+	return next(from, to, callback, stopAtNil)
+}
+
+// Chain returns a KVStore that passes all operations through the given interceptors before executing them on the store.
+// The first interceptor is the outermost: it's called first and sees the results of all other interceptors.
+// Other operations (Empty, Stats, Close and ShelfNames) aren't intercepted.
+func Chain(store KVStore, interceptors ...Interceptor) KVStore {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		store = &interceptedStore{underlying: store, interceptor: interceptors[i]}
+	}
+	return store
+}
+
+type interceptedStore struct {
+	underlying  KVStore
+	interceptor Interceptor
+}
+
+func (s *interceptedStore) Close(ctx context.Context) error {
+	return s.underlying.Close(ctx)
+}
+
+// ShelfNames returns the shelves of the underlying store, or errors.ErrUnsupported if it doesn't implement ShelfLister.
+func (s *interceptedStore) ShelfNames(ctx context.Context) ([]string, error) {
+	return ShelfNames(ctx, s.underlying)
+}
+
+func (s *interceptedStore) Write(ctx context.Context, fn func(WriteTx) error, opts ...TxOption) error {
+	info := TxInfo{Writable: true, Options: opts}
+	return s.interceptor.Transaction(ctx, info, func(ctx context.Context) error {
+		return s.underlying.Write(ctx, func(tx WriteTx) error {
+			return fn(&interceptedWriteTx{interceptedReadTx: interceptedReadTx{ReadTx: tx, store: s, ctx: ctx, info: info}, underlying: tx})
+		}, opts...)
+	})
+}
+
+func (s *interceptedStore) Read(ctx context.Context, fn func(ReadTx) error) error {
+	info := TxInfo{}
+	return s.interceptor.Transaction(ctx, info, func(ctx context.Context) error {
+		return s.underlying.Read(ctx, func(tx ReadTx) error {
+			return fn(&interceptedReadTx{ReadTx: tx, store: s, ctx: ctx, info: info})
+		})
+	})
+}
+
+func (s *interceptedStore) WriteShelf(ctx context.Context, shelfName string, fn func(Writer) error) error {
+	info := TxInfo{Writable: true, Shelf: shelfName}
+	return s.interceptor.Transaction(ctx, info, func(ctx context.Context) error {
+		return s.underlying.WriteShelf(ctx, shelfName, func(writer Writer) error {
+			return fn(s.writer(Call{Context: ctx, Tx: info, Shelf: shelfName}, writer))
+		})
+	})
+}
+
+func (s *interceptedStore) ReadShelf(ctx context.Context, shelfName string, fn func(Reader) error) error {
+	info := TxInfo{Shelf: shelfName}
+	return s.interceptor.Transaction(ctx, info, func(ctx context.Context) error {
+		return s.underlying.ReadShelf(ctx, shelfName, func(reader Reader) error {
+			return fn(s.reader(Call{Context: ctx, Tx: info, Shelf: shelfName}, reader))
+		})
+	})
+}
+
+func (s *interceptedStore) reader(call Call, reader Reader) *interceptedReader {
+	return &interceptedReader{Reader: reader, interceptor: s.interceptor, call: call}
+}
+
+func (s *interceptedStore) writer(call Call, writer Writer) *interceptedWriter {
+	return &interceptedWriter{interceptedReader: s.reader(call, writer), underlying: writer}
+}
+
+type interceptedReadTx struct {
+	ReadTx
+	store *interceptedStore
+	ctx   context.Context
+	info  TxInfo
+}
+
+func (t *interceptedReadTx) GetShelfReader(shelfName string) Reader {
+	return t.store.reader(Call{Context: t.ctx, Tx: t.info, Shelf: shelfName}, t.ReadTx.GetShelfReader(shelfName))
+}
+
+func (t *interceptedReadTx) Store() KVStore {
+	return t.store
+}
+
+type interceptedWriteTx struct {
+	interceptedReadTx
+	underlying WriteTx
+}
+
+func (t *interceptedWriteTx) GetShelfWriter(shelfName string) Writer {
+	return t.store.writer(Call{Context: t.ctx, Tx: t.info, Shelf: shelfName}, t.underlying.GetShelfWriter(shelfName))
+}
+
+type interceptedReader struct {
+	Reader
+	interceptor Interceptor
+	call        Call
+}
+
+func (r *interceptedReader) Get(key Key) ([]byte, error) {
+	return r.interceptor.Get(r.call, key, r.Reader.Get)
+}
+
+func (r *interceptedReader) Iterate(callback CallerFn, keyType Key) error {
+	return r.interceptor.Iterate(r.call, callback, keyType, r.Reader.Iterate)
+}
+
+func (r *interceptedReader) Range(from Key, to Key, callback CallerFn, stopAtNil bool) error {
+	return r.interceptor.Range(r.call, from, to, callback, stopAtNil, r.Reader.Range)
+}
+
+type interceptedWriter struct {
+	*interceptedReader
+	underlying Writer
+}
+
+func (w *interceptedWriter) Put(key Key, value []byte) error {
+	return w.interceptor.Put(w.call, key, value, w.underlying.Put)
+}
+
+func (w *interceptedWriter) Delete(key Key) error {
+	return w.interceptor.Delete(w.call, key, w.underlying.Delete)
+}
@@ -0,0 +1,198 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChain(t *testing.T) {
+	provider := func(t *testing.T) (stoabs.KVStore, error) {
+		store := stores.BBolt(t)
+		return stoabs.Chain(store, stoabs.NoopInterceptor{}, &recorder{}), nil
+	}
+
+	kvtests.TestReadingAndWriting(t, provider)
+	kvtests.TestRange(t, provider)
+	kvtests.TestIterate(t, provider)
+	kvtests.TestEmpty(t, provider)
+	kvtests.TestClose(t, provider)
+	kvtests.TestDelete(t, provider)
+	kvtests.TestStats(t, provider)
+	kvtests.TestWriteTransactions(t, provider)
+	kvtests.TestTransactionWriteLock(t, provider)
+	kvtests.TestShelfNames(t, provider)
+}
+
+func TestChain_interceptors(t *testing.T) {
+	ctx := context.Background()
+	key := stoabs.BytesKey("key")
+
+	t.Run("called in order", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		var calls []string
+		store := stoabs.Chain(underlying, &recorder{name: "outer", calls: &calls}, &recorder{name: "inner", calls: &calls})
+
+		err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+			assert.Same(t, store, tx.Store())
+			return tx.GetShelfWriter("shelf").Put(key, []byte("value"))
+		})
+		require.NoError(t, err)
+		err = store.ReadShelf(ctx, "shelf", func(reader stoabs.Reader) error {
+			_, err := reader.Get(key)
+			return err
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{
+			"outer: begin writable", "inner: begin writable", "outer: put shelf/key", "inner: put shelf/key", "inner: end writable", "outer: end writable",
+			"outer: begin shelf", "inner: begin shelf", "outer: get shelf/key", "inner: get shelf/key", "inner: end shelf", "outer: end shelf",
+		}, calls)
+	})
+	t.Run("transforms values", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		store := stoabs.Chain(underlying, reverser{})
+
+		err := store.WriteShelf(ctx, "shelf", func(writer stoabs.Writer) error {
+			return writer.Put(key, []byte("abc"))
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, "cba", read(t, underlying, "shelf", key))
+		assert.Equal(t, "abc", read(t, store, "shelf", key))
+		err = store.ReadShelf(ctx, "shelf", func(reader stoabs.Reader) error {
+			return reader.Iterate(func(_ stoabs.Key, value []byte) error {
+				assert.Equal(t, "abc", string(value))
+				return nil
+			}, stoabs.BytesKey{})
+		})
+		require.NoError(t, err)
+	})
+	t.Run("short-circuits operations", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		store := stoabs.Chain(underlying, validator{})
+
+		err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+			if err := tx.GetShelfWriter("shelf").Put(key, []byte("value")); err != nil {
+				return err
+			}
+			return tx.GetShelfWriter("shelf").Put(stoabs.BytesKey("other"), nil)
+		})
+
+		assert.EqualError(t, err, "empty value for key other")
+		err = underlying.ReadShelf(ctx, "shelf", func(reader stoabs.Reader) error {
+			_, err := reader.Get(key)
+			return err
+		})
+		assert.ErrorIs(t, err, stoabs.ErrKeyNotFound)
+	})
+}
+
+func read(t *testing.T, store stoabs.KVStore, shelf string, key stoabs.Key) string {
+	var result []byte
+	err := store.ReadShelf(context.Background(), shelf, func(reader stoabs.Reader) error {
+		var err error
+		result, err = reader.Get(key)
+		return err
+	})
+	require.NoError(t, err)
+	return string(result)
+}
+
+// recorder records the transactions, gets and puts.
+type recorder struct {
+	stoabs.NoopInterceptor
+	name  string
+	calls *[]string
+}
+
+func (r *recorder) record(format string, args ...interface{}) {
+	if r.calls != nil {
+		*r.calls = append(*r.calls, r.name+": "+fmt.Sprintf(format, args...))
+	}
+}
+
+func (r *recorder) Transaction(ctx context.Context, tx stoabs.TxInfo, next func(ctx context.Context) error) error {
+	description := tx.Shelf
+	if description == "" && tx.Writable {
+		description = "writable"
+	}
+	r.record("begin %s", description)
+	defer r.record("end %s", description)
+	return next(ctx)
+}
+
+func (r *recorder) Get(call stoabs.Call, key stoabs.Key, next func(key stoabs.Key) ([]byte, error)) ([]byte, error) {
+	r.record("get %s/%s", call.Shelf, key.Bytes())
+	return next(key)
+}
+
+func (r *recorder) Put(call stoabs.Call, key stoabs.Key, value []byte, next func(key stoabs.Key, value []byte) error) error {
+	r.record("put %s/%s", call.Shelf, key.Bytes())
+	return next(key, value)
+}
+
+// reverser stores values in reverse byte order.
+type reverser struct {
+	stoabs.NoopInterceptor
+}
+
+func reverse(value []byte) []byte {
+	result := make([]byte, len(value))
+	for i, b := range value {
+		result[len(value)-1-i] = b
+	}
+	return result
+}
+
+func (reverser) Get(_ stoabs.Call, key stoabs.Key, next func(key stoabs.Key) ([]byte, error)) ([]byte, error) {
+	value, err := next(key)
+	return reverse(value), err
+}
+
+func (reverser) Put(_ stoabs.Call, key stoabs.Key, value []byte, next func(key stoabs.Key, value []byte) error) error {
+	return next(key, reverse(value))
+}
+
+func (reverser) Iterate(_ stoabs.Call, callback stoabs.CallerFn, keyType stoabs.Key, next func(callback stoabs.CallerFn, keyType stoabs.Key) error) error {
+	return next(func(key stoabs.Key, value []byte) error {
+		return callback(key, reverse(value))
+	}, keyType)
+}
+
+// validator rejects empty values.
+type validator struct {
+	stoabs.NoopInterceptor
+}
+
+func (validator) Put(_ stoabs.Call, key stoabs.Key, value []byte, next func(key stoabs.Key, value []byte) error) error {
+	if len(value) == 0 {
+		return errors.New("empty value for key " + string(key.Bytes()))
+	}
+	return next(key, value)
+}
@@ -0,0 +1,194 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package invariant checks relations between shelves of a KVStore, e.g. that every key of a shelf exists in its index shelf,
+// since derived shelves can drift from their source after crashes or bugs.
+package invariant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nuts-foundation/go-stoabs"
+)
+
+const defaultMaxViolations = 1000
+
+// Invariant declares that for every entry of Shelf, keys derived from the entry must exist in the Target shelf.
+type Invariant struct {
+	// Name identifies the invariant in reports.
+	Name string
+	// Shelf is the shelf whose entries are checked.
+	Shelf string
+	// KeyType specifies the type of the keys of Shelf. It defaults to stoabs.BytesKey.
+	KeyType stoabs.Key
+	// Target is the shelf in which the keys must exist.
+	Target string
+	// TargetKeys returns the keys that must exist in Target for the given entry of Shelf (e.g. the keys of an index).
+	// If nil, the key of the entry itself must exist in Target.
+	TargetKeys func(key stoabs.Key, value []byte) ([]stoabs.Key, error)
+	// Repair repairs a violation, e.g. by writing the missing entry of Target or deleting the entry of Shelf.
+	// It's called in a write transaction when checking using WithRepair. If nil, violations can't be repaired.
+	Repair func(tx stoabs.WriteTx, violation Violation) error
+}
+
+// KeysExist returns an invariant that requires every key of the given shelf to exist in the target shelf.
+func KeysExist(shelf string, target string) Invariant {
+	return Invariant{
+		Name:   fmt.Sprintf("keys of %s exist in %s", shelf, target),
+		Shelf:  shelf,
+		Target: target,
+	}
+}
+
+func (i Invariant) keyType() stoabs.Key {
+	if i.KeyType == nil {
+		return stoabs.BytesKey{}
+	}
+	return i.KeyType
+}
+
+func (i Invariant) targetKeys(key stoabs.Key, value []byte) ([]stoabs.Key, error) {
+	if i.TargetKeys == nil {
+		return []stoabs.Key{key}, nil
+	}
+	return i.TargetKeys(key, value)
+}
+
+// Violation is an entry that violates an invariant.
+type Violation struct {
+	// Invariant is the name of the violated invariant.
+	Invariant string
+	// Key is the key of the entry of the checked shelf.
+	Key stoabs.Key
+	// TargetKey is the key that doesn't exist in the target shelf.
+	TargetKey stoabs.Key
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: %s doesn't exist (key=%s)", v.Invariant, v.TargetKey, v.Key)
+}
+
+// Report describes the result of checking invariants.
+type Report struct {
+	// Checked is the number of entries checked.
+	Checked int
+	// Violated is the number of violations.
+	Violated int
+	// Violations holds the violations, up to the maximum (see WithMaxViolations).
+	Violations []Violation
+	// Repaired is the number of violations that were repaired, see WithRepair.
+	Repaired int
+}
+
+// Holds returns true if no violations were found.
+func (r Report) Holds() bool {
+	return r.Violated == 0
+}
+
+// Option configures Check.
+type Option func(cfg *config)
+
+type config struct {
+	maxViolations int
+	repair        bool
+}
+
+// WithMaxViolations sets the maximum number of violations recorded in Report.Violations, which limits memory use.
+// Violations are counted regardless.
+func WithMaxViolations(maxViolations int) Option {
+	return func(cfg *config) {
+		cfg.maxViolations = maxViolations
+	}
+}
+
+// WithRepair repairs the violations of invariants that specify a Repair function, after checking each invariant.
+// The violations of each invariant are repaired in a single write transaction.
+func WithRepair() Option {
+	return func(cfg *config) {
+		cfg.repair = true
+	}
+}
+
+// Check checks the given invariants, e.g. when the application starts. Every invariant is checked in a single read
+// transaction, so the shelf and target are read from the same snapshot (on backends that support it).
+// Violations of an invariant are reported, not returned as error; use Report.Holds to check whether all invariants hold.
+func Check(ctx context.Context, store stoabs.KVStore, invariants []Invariant, opts ...Option) (Report, error) {
+	cfg := config{maxViolations: defaultMaxViolations}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	var report Report
+	for _, invariant := range invariants {
+		violations, err := check(ctx, store, invariant, cfg, &report)
+		if err != nil {
+			return report, fmt.Errorf("unable to check invariant %s: %w", invariant.Name, err)
+		}
+		if len(violations) == 0 || !cfg.repair || invariant.Repair == nil {
+			continue
+		}
+		err = store.Write(ctx, func(tx stoabs.WriteTx) error {
+			for _, violation := range violations {
+				if err := invariant.Repair(tx, violation); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return report, fmt.Errorf("unable to repair invariant %s: %w", invariant.Name, err)
+		}
+		report.Repaired += len(violations)
+	}
+	return report, nil
+}
+
+// check checks a single invariant. The violations are returned for repairing, if the invariant can be repaired.
+func check(ctx context.Context, store stoabs.KVStore, invariant Invariant, cfg config, report *Report) ([]Violation, error) {
+	var violations []Violation
+	err := store.Read(ctx, func(tx stoabs.ReadTx) error {
+		target := tx.GetShelfReader(invariant.Target)
+		return tx.GetShelfReader(invariant.Shelf).Iterate(func(key stoabs.Key, value []byte) error {
+			report.Checked++
+			targetKeys, err := invariant.targetKeys(key, value)
+			if err != nil {
+				return err
+			}
+			for _, targetKey := range targetKeys {
+				_, err := target.Get(targetKey)
+				if err == nil {
+					continue
+				}
+				if !errors.Is(err, stoabs.ErrKeyNotFound) {
+					return err
+				}
+				violation := Violation{Invariant: invariant.Name, Key: key, TargetKey: targetKey}
+				report.Violated++
+				if len(report.Violations) < cfg.maxViolations {
+					report.Violations = append(report.Violations, violation)
+				}
+				if cfg.repair && invariant.Repair != nil {
+					violations = append(violations, violation)
+				}
+			}
+			return nil
+		}, invariant.keyType())
+	})
+	return violations, err
+}
@@ -0,0 +1,143 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package invariant
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+func TestCheck(t *testing.T) {
+	// documents are indexed by their value in the index shelf
+	byValue := Invariant{
+		Name:   "documents are indexed",
+		Shelf:  "documents",
+		Target: "index",
+		TargetKeys: func(_ stoabs.Key, value []byte) ([]stoabs.Key, error) {
+			return []stoabs.Key{stoabs.BytesKey(value)}, nil
+		},
+		Repair: func(tx stoabs.WriteTx, violation Violation) error {
+			return tx.GetShelfWriter("index").Put(violation.TargetKey, violation.Key.Bytes())
+		},
+	}
+	setup := func(t *testing.T) stoabs.KVStore {
+		store := stores.BBolt(t)
+		put(t, store, "documents", "1", "a")
+		put(t, store, "documents", "2", "b")
+		put(t, store, "documents", "3", "c")
+		put(t, store, "index", "a", "1")
+		put(t, store, "metadata", "1", "")
+		put(t, store, "metadata", "2", "")
+		return store
+	}
+
+	t.Run("holds", func(t *testing.T) {
+		store := setup(t)
+		put(t, store, "index", "b", "2")
+		put(t, store, "index", "c", "3")
+
+		report, err := Check(ctx, store, []Invariant{byValue})
+
+		require.NoError(t, err)
+		assert.True(t, report.Holds())
+		assert.Equal(t, 3, report.Checked)
+	})
+	t.Run("violated", func(t *testing.T) {
+		store := setup(t)
+
+		report, err := Check(ctx, store, []Invariant{byValue, KeysExist("documents", "metadata")})
+
+		require.NoError(t, err)
+		assert.False(t, report.Holds())
+		assert.Equal(t, 6, report.Checked)
+		assert.Equal(t, 3, report.Violated)
+		assert.Equal(t, []Violation{
+			{Invariant: "documents are indexed", Key: stoabs.BytesKey("2"), TargetKey: stoabs.BytesKey("b")},
+			{Invariant: "documents are indexed", Key: stoabs.BytesKey("3"), TargetKey: stoabs.BytesKey("c")},
+			{Invariant: "keys of documents exist in metadata", Key: stoabs.BytesKey("3"), TargetKey: stoabs.BytesKey("3")},
+		}, report.Violations)
+		assert.Equal(t, "keys of documents exist in metadata: 33 doesn't exist (key=33)", report.Violations[2].String())
+	})
+	t.Run("max violations", func(t *testing.T) {
+		store := setup(t)
+
+		report, err := Check(ctx, store, []Invariant{byValue}, WithMaxViolations(1))
+
+		require.NoError(t, err)
+		assert.Equal(t, 2, report.Violated)
+		assert.Len(t, report.Violations, 1)
+	})
+	t.Run("repair", func(t *testing.T) {
+		store := setup(t)
+
+		report, err := Check(ctx, store, []Invariant{byValue, KeysExist("documents", "metadata")}, WithRepair())
+
+		require.NoError(t, err)
+		assert.Equal(t, 3, report.Violated)
+		assert.Equal(t, 2, report.Repaired, "invariant without Repair can't be repaired")
+		report, err = Check(ctx, store, []Invariant{byValue})
+		require.NoError(t, err)
+		assert.True(t, report.Holds())
+	})
+	t.Run("missing target shelf", func(t *testing.T) {
+		store := setup(t)
+
+		report, err := Check(ctx, store, []Invariant{KeysExist("documents", "other")})
+
+		require.NoError(t, err)
+		assert.Equal(t, 3, report.Violated)
+	})
+	t.Run("TargetKeys fails", func(t *testing.T) {
+		store := setup(t)
+		invariant := byValue
+		invariant.TargetKeys = func(_ stoabs.Key, _ []byte) ([]stoabs.Key, error) {
+			return nil, errors.New("failed")
+		}
+
+		_, err := Check(ctx, store, []Invariant{invariant})
+
+		assert.EqualError(t, err, "unable to check invariant documents are indexed: failed")
+	})
+	t.Run("repair fails", func(t *testing.T) {
+		store := setup(t)
+		invariant := byValue
+		invariant.Repair = func(_ stoabs.WriteTx, _ Violation) error {
+			return errors.New("failed")
+		}
+
+		report, err := Check(ctx, store, []Invariant{invariant}, WithRepair())
+
+		assert.EqualError(t, err, "unable to repair invariant documents are indexed: failed")
+		assert.Equal(t, 0, report.Repaired)
+	})
+}
+
+func put(t *testing.T, store stoabs.KVStore, shelf string, key string, value string) {
+	require.NoError(t, store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+		return writer.Put(stoabs.BytesKey(key), []byte(value))
+	}))
+}
@@ -0,0 +1,159 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"bytes"
+	"errors"
+	"iter"
+)
+
+// errStopIteration stops the scan of a SeqReader when the loop over its iterator breaks.
+var errStopIteration = errors.New("stop iteration")
+
+// KeyValue is an entry of a shelf.
+type KeyValue struct {
+	Key   Key
+	Value []byte
+}
+
+// Seq adapts the callback-style scans of the given reader to Go iterators, so they can be consumed with range-over-func:
+//
+//	entries := stoabs.Seq(reader)
+//	for key, value := range entries.All(stoabs.BytesKey{}) {
+//		...
+//	}
+//	if err := entries.Err(); err != nil {
+//		...
+//	}
+func Seq(reader Reader) *SeqReader {
+	return &SeqReader{reader: reader}
+}
+
+// SeqReader provides Go iterators over the entries of a Reader, see Seq.
+// Values are only valid until the next iteration, as they are in a CallerFn (see stoabs.WithValueCloning).
+type SeqReader struct {
+	reader Reader
+	err    error
+}
+
+// All returns an iterator over all entries of the shelf, ordered as by Reader.Iterate.
+// If the scan fails, iteration stops and the error is returned by Err.
+func (s *SeqReader) All(keyType Key) iter.Seq2[Key, []byte] {
+	return s.scan(func(callback CallerFn) error {
+		return s.reader.Iterate(callback, keyType)
+	})
+}
+
+// Range returns an iterator over the entries from (inclusive) to (exclusive) the given keys, as by Reader.Range.
+// If the scan fails, iteration stops and the error is returned by Err.
+func (s *SeqReader) Range(from Key, to Key) iter.Seq2[Key, []byte] {
+	return s.scan(func(callback CallerFn) error {
+		return s.reader.Range(from, to, callback, false)
+	})
+}
+
+// Err returns the error of the last iteration, or nil if it completed or the loop broke before it did.
+func (s *SeqReader) Err() error {
+	return s.err
+}
+
+func (s *SeqReader) scan(fn func(callback CallerFn) error) iter.Seq2[Key, []byte] {
+	return func(yield func(Key, []byte) bool) {
+		s.err = fn(func(key Key, value []byte) error {
+			if !yield(key, value) {
+				return errStopIteration
+			}
+			return nil
+		})
+		if errors.Is(s.err, errStopIteration) {
+			s.err = nil
+		}
+	}
+}
+
+// Collect returns the entries of the given iterator. Values are copied, so they remain valid after iterating.
+func Collect(entries iter.Seq2[Key, []byte]) []KeyValue {
+	var result []KeyValue
+	for key, value := range entries {
+		result = append(result, KeyValue{Key: key, Value: bytes.Clone(value)})
+	}
+	return result
+}
+
+// PutAll puts all entries of the given iterator, e.g. to copy entries scanned from another shelf:
+//
+//	err := stoabs.PutAll(writer, stoabs.Seq(reader).All(stoabs.BytesKey{}))
+//
+// It stops at the first error.
+func PutAll(writer Writer, entries iter.Seq2[Key, []byte]) error {
+	for key, value := range entries {
+		if err := writer.Put(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Pairs returns an iterator over the keys and values of the given entries, e.g. to write them using PutAll.
+func Pairs(entries []KeyValue) iter.Seq2[Key, []byte] {
+	return func(yield func(Key, []byte) bool) {
+		for _, entry := range entries {
+			if !yield(entry.Key, entry.Value) {
+				return
+			}
+		}
+	}
+}
+
+// EntryIterator is implemented by Readers that iterate over entries natively, see Entries.
+type EntryIterator interface {
+	// Entries returns an iterator over the entries from (inclusive) to (exclusive) the given keys, ordered by key.
+	// Entries are read as the loop advances, and reading stops when the loop breaks.
+	// If reading fails, the error is yielded (with an empty KeyValue) and iteration stops.
+	Entries(from Key, to Key) iter.Seq2[KeyValue, error]
+}
+
+// Entries returns an iterator over the entries from (inclusive) to (exclusive) the given keys, ordered by key:
+//
+//	for entry, err := range stoabs.Entries(reader, from, to) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// Values are only valid until the next iteration, as they are in a CallerFn (see stoabs.WithValueCloning).
+// If the reader does not implement EntryIterator, it falls back to Reader.Range.
+func Entries(reader Reader, from Key, to Key) iter.Seq2[KeyValue, error] {
+	if iterator, ok := reader.(EntryIterator); ok {
+		return iterator.Entries(from, to)
+	}
+	return func(yield func(KeyValue, error) bool) {
+		err := reader.Range(from, to, func(key Key, value []byte) error {
+			if !yield(KeyValue{Key: key, Value: value}, nil) {
+				return errStopIteration
+			}
+			return nil
+		}, false)
+		if err != nil && !errors.Is(err, errStopIteration) {
+			yield(KeyValue{}, err)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"iter"
+	"testing"
+)
+
+func TestSeqReader(t *testing.T) {
+	entries := []KeyValue{
+		{Key: BytesKey("a"), Value: []byte("1")},
+		{Key: BytesKey("b"), Value: []byte("2")},
+		{Key: BytesKey("c"), Value: []byte("3")},
+	}
+	scan := func(callback CallerFn) error {
+		for _, entry := range entries {
+			if err := callback(entry.Key, entry.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	t.Run("All", func(t *testing.T) {
+		reader := NewMockReader(gomock.NewController(t))
+		reader.EXPECT().Iterate(gomock.Any(), BytesKey{}).DoAndReturn(func(callback CallerFn, _ Key) error {
+			return scan(callback)
+		})
+		seq := Seq(reader)
+
+		actual := Collect(seq.All(BytesKey{}))
+
+		assert.NoError(t, seq.Err())
+		assert.Equal(t, entries, actual)
+	})
+	t.Run("Range", func(t *testing.T) {
+		reader := NewMockReader(gomock.NewController(t))
+		reader.EXPECT().Range(BytesKey("a"), BytesKey("c"), gomock.Any(), false).DoAndReturn(func(_ Key, _ Key, callback CallerFn, _ bool) error {
+			return scan(callback)
+		})
+		seq := Seq(reader)
+
+		actual := Collect(seq.Range(BytesKey("a"), BytesKey("c")))
+
+		assert.NoError(t, seq.Err())
+		assert.Len(t, actual, 3)
+	})
+	t.Run("break stops the scan", func(t *testing.T) {
+		reader := NewMockReader(gomock.NewController(t))
+		var scanErr error
+		reader.EXPECT().Iterate(gomock.Any(), BytesKey{}).DoAndReturn(func(callback CallerFn, _ Key) error {
+			scanErr = scan(callback)
+			return scanErr
+		})
+		seq := Seq(reader)
+		var keys []Key
+
+		for key := range seq.All(BytesKey{}) {
+			keys = append(keys, key)
+			if len(keys) == 2 {
+				break
+			}
+		}
+
+		assert.NoError(t, seq.Err())
+		assert.Error(t, scanErr, "callback stops the scan")
+		assert.Equal(t, []Key{BytesKey("a"), BytesKey("b")}, keys)
+	})
+	t.Run("scan error", func(t *testing.T) {
+		reader := NewMockReader(gomock.NewController(t))
+		reader.EXPECT().Iterate(gomock.Any(), BytesKey{}).DoAndReturn(func(callback CallerFn, _ Key) error {
+			if err := callback(BytesKey("a"), nil); err != nil {
+				return err
+			}
+			return errors.New("failed")
+		})
+		seq := Seq(reader)
+
+		actual := Collect(seq.All(BytesKey{}))
+
+		assert.EqualError(t, seq.Err(), "failed")
+		assert.Len(t, actual, 1)
+	})
+}
+
+func TestCollect(t *testing.T) {
+	value := []byte("value")
+
+	actual := Collect(Pairs([]KeyValue{{Key: BytesKey("a"), Value: value}}))
+
+	require.Len(t, actual, 1)
+	value[0] = 'V'
+	assert.Equal(t, []byte("value"), actual[0].Value, "values are copied")
+}
+
+func TestPutAll(t *testing.T) {
+	entries := []KeyValue{{Key: BytesKey("a"), Value: []byte("1")}, {Key: BytesKey("b"), Value: []byte("2")}}
+	t.Run("ok", func(t *testing.T) {
+		writer := NewMockWriter(gomock.NewController(t))
+		writer.EXPECT().Put(BytesKey("a"), []byte("1"))
+		writer.EXPECT().Put(BytesKey("b"), []byte("2"))
+
+		assert.NoError(t, PutAll(writer, Pairs(entries)))
+	})
+	t.Run("error", func(t *testing.T) {
+		writer := NewMockWriter(gomock.NewController(t))
+		writer.EXPECT().Put(BytesKey("a"), []byte("1")).Return(errors.New("failed"))
+
+		assert.EqualError(t, PutAll(writer, Pairs(entries)), "failed")
+	})
+}
+
+func TestEntries(t *testing.T) {
+	t.Run("native", func(t *testing.T) {
+		reader := struct {
+			Reader
+			EntryIterator
+		}{EntryIterator: entryIterator{{Key: BytesKey("a")}}}
+
+		var keys []Key
+		for entry, err := range Entries(reader, BytesKey("a"), BytesKey("c")) {
+			require.NoError(t, err)
+			keys = append(keys, entry.Key)
+		}
+
+		assert.Equal(t, []Key{BytesKey("a")}, keys)
+	})
+	t.Run("falls back to Range", func(t *testing.T) {
+		reader := NewMockReader(gomock.NewController(t))
+		reader.EXPECT().Range(BytesKey("a"), BytesKey("c"), gomock.Any(), false).DoAndReturn(func(_ Key, _ Key, callback CallerFn, _ bool) error {
+			if err := callback(BytesKey("a"), []byte("1")); err != nil {
+				return err
+			}
+			return callback(BytesKey("b"), []byte("2"))
+		})
+
+		var entries []KeyValue
+		for entry, err := range Entries(reader, BytesKey("a"), BytesKey("c")) {
+			require.NoError(t, err)
+			entries = append(entries, entry)
+		}
+
+		assert.Equal(t, []KeyValue{{Key: BytesKey("a"), Value: []byte("1")}, {Key: BytesKey("b"), Value: []byte("2")}}, entries)
+	})
+	t.Run("break stops Range", func(t *testing.T) {
+		reader := NewMockReader(gomock.NewController(t))
+		var rangeErr error
+		reader.EXPECT().Range(gomock.Any(), gomock.Any(), gomock.Any(), false).DoAndReturn(func(_ Key, _ Key, callback CallerFn, _ bool) error {
+			if rangeErr = callback(BytesKey("a"), nil); rangeErr != nil {
+				return rangeErr
+			}
+			t.Fatal("Range wasn't stopped")
+			return nil
+		})
+
+		for _, err := range Entries(reader, BytesKey("a"), BytesKey("c")) {
+			require.NoError(t, err)
+			break
+		}
+
+		assert.Error(t, rangeErr)
+	})
+	t.Run("Range error", func(t *testing.T) {
+		reader := NewMockReader(gomock.NewController(t))
+		reader.EXPECT().Range(gomock.Any(), gomock.Any(), gomock.Any(), false).Return(errors.New("failed"))
+
+		var errs []error
+		for entry, err := range Entries(reader, BytesKey("a"), BytesKey("c")) {
+			assert.Nil(t, entry.Key)
+			errs = append(errs, err)
+		}
+
+		require.Len(t, errs, 1)
+		assert.EqualError(t, errs[0], "failed")
+	})
+}
+
+type entryIterator []KeyValue
+
+func (e entryIterator) Entries(_ Key, _ Key) iter.Seq2[KeyValue, error] {
+	return func(yield func(KeyValue, error) bool) {
+		for _, entry := range e {
+			if !yield(entry, nil) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,413 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package journal provides a KVStore that records the mutations of every committed transaction in a journal,
+// which can be replayed on another store, e.g. for point-in-time recovery or to find out who wrote a key.
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/cdc"
+)
+
+var _ stoabs.KVStore = (*Store)(nil)
+var _ stoabs.ShelfLister = (*Store)(nil)
+var _ stoabs.WriteTx = (*tx)(nil)
+var _ stoabs.Writer = (*shelf)(nil)
+
+// journalShelf is the shelf holding the journaled transactions, keyed by sequence number (stoabs.Uint64Key).
+// Key 0 holds the sequence number of the next transaction.
+const journalShelf = stoabs.ReservedShelfPrefix + "journal"
+
+const stateKey = stoabs.Uint64Key(0)
+
+// replayBatchSize is the number of transactions read from the journal at once when replaying.
+const replayBatchSize = 100
+
+// Seq is the sequence number of a journaled transaction, starting at 1.
+type Seq uint64
+
+// Transaction is a committed transaction recorded in the journal.
+type Transaction struct {
+	// Seq is the sequence number of the transaction.
+	Seq Seq `json:"seq"`
+	// Timestamp is the time the transaction was committed.
+	Timestamp time.Time `json:"timestamp"`
+	// Author is the author of the transaction as specified with WithAuthor, if any.
+	Author string `json:"author,omitempty"`
+	// Mutations contains the mutations of the transaction, in the order they were made.
+	Mutations []Mutation `json:"mutations"`
+}
+
+// Mutation is a Put or Delete recorded in the journal.
+type Mutation struct {
+	// Shelf is the shelf the mutation applies to.
+	Shelf string `json:"shelf"`
+	// Key is the byte representation of the mutated key.
+	Key []byte `json:"key"`
+	// KeyType is the type of the mutated key (see the cdc.KeyType constants), or empty for other key types.
+	KeyType string `json:"keyType,omitempty"`
+	// Value is the value written by a Put.
+	Value []byte `json:"value,omitempty"`
+	// Deleted is true if the key was deleted.
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// ParsedKey returns the mutated key as the type recorded in KeyType (see cdc.ParseKey).
+func (m Mutation) ParsedKey() (stoabs.Key, error) {
+	return cdc.ParseKey(m.KeyType, m.Key)
+}
+
+// AuthorOption specifies the author recorded in the journal for a write transaction.
+type AuthorOption struct {
+	author string
+}
+
+// Author returns the author specified in the given options, or an empty string if there is none.
+func (o AuthorOption) Author(opts []stoabs.TxOption) string {
+	for _, opt := range opts {
+		if author, ok := opt.(AuthorOption); ok {
+			return author.author
+		}
+	}
+	return ""
+}
+
+// WithAuthor returns a TxOption that records the given author (e.g. a user or component) with the transaction in the journal.
+func WithAuthor(author string) stoabs.TxOption {
+	return AuthorOption{author: author}
+}
+
+// Wrap creates a store that records the mutations of every committed write transaction in a journal, which is
+// written in the same transaction as the mutations themselves. Since transactions are assigned consecutive sequence
+// numbers, all write transactions acquire the write lock (see stoabs.WithWriteLock).
+func Wrap(store stoabs.KVStore) *Store {
+	return &Store{
+		underlying: store,
+		now:        time.Now,
+	}
+}
+
+// Store is a KVStore that journals transactions. Use Wrap to create it.
+type Store struct {
+	underlying stoabs.KVStore
+	now        func() time.Time
+}
+
+// Transactions calls fn for every journaled transaction, starting at the given sequence number (inclusive),
+// in a single read transaction. Sequence number 0 starts at the oldest retained transaction.
+// It returns the sequence number to pass to the next invocation to continue where this one stopped,
+// which is also the case when fn returns an error.
+func (s *Store) Transactions(ctx context.Context, from Seq, fn func(Transaction) error) (Seq, error) {
+	return s.transactions(ctx, from, 0, fn)
+}
+
+// transactions is like Transactions, but stops after max transactions if max is not 0.
+func (s *Store) transactions(ctx context.Context, from Seq, max int, fn func(Transaction) error) (Seq, error) {
+	next := from
+	err := s.underlying.ReadShelf(ctx, journalShelf, func(reader stoabs.Reader) error {
+		journal := stoabs.JSONShelf[Transaction](reader)
+		first, last, err := readBounds(reader)
+		if err != nil {
+			return err
+		}
+		if next < first {
+			next = first
+		}
+		for count := 0; next < last && (max == 0 || count < max); count++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			transaction, err := journal.Get(stoabs.Uint64Key(next))
+			if err != nil {
+				return fmt.Errorf("unable to read journaled transaction (seq=%d): %w", next, err)
+			}
+			if err := fn(transaction); err != nil {
+				return err
+			}
+			next++
+		}
+		return nil
+	})
+	return next, err
+}
+
+// ReplayJournal applies the journaled transactions, starting at the given sequence number (inclusive), to the target
+// store. Every transaction is applied in its own write transaction. It returns the sequence number to pass to the next
+// invocation to continue where this one stopped.
+func (s *Store) ReplayJournal(ctx context.Context, from Seq, target stoabs.KVStore) (Seq, error) {
+	next := from
+	for {
+		var batch []Transaction
+		// Transactions are read in batches and applied afterwards, so the target can be the underlying store itself.
+		batchNext, err := s.transactions(ctx, next, replayBatchSize, func(transaction Transaction) error {
+			batch = append(batch, transaction)
+			return nil
+		})
+		if err != nil {
+			// the transactions of the batch read before the error aren't applied
+			return next, err
+		}
+		next = batchNext
+		if len(batch) == 0 {
+			return next, nil
+		}
+		for _, transaction := range batch {
+			if err := Apply(ctx, target, transaction); err != nil {
+				return transaction.Seq, err
+			}
+		}
+	}
+}
+
+// Apply applies the mutations of the given transaction to the target store in a single write transaction.
+func Apply(ctx context.Context, target stoabs.KVStore, transaction Transaction) error {
+	err := target.Write(ctx, func(tx stoabs.WriteTx) error {
+		for _, mutation := range transaction.Mutations {
+			key, err := mutation.ParsedKey()
+			if err != nil {
+				return err
+			}
+			writer := tx.GetShelfWriter(mutation.Shelf)
+			if mutation.Deleted {
+				err = writer.Delete(key)
+			} else {
+				err = writer.Put(key, mutation.Value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to apply journaled transaction (seq=%d): %w", transaction.Seq, err)
+	}
+	return nil
+}
+
+// Export writes the journaled transactions, starting at the given sequence number (inclusive), to the given writer as
+// JSON lines, e.g. to keep the journal in a separate file. It returns the sequence number to pass to the next invocation
+// to continue where this one stopped. Use Decode to read the exported transactions.
+func (s *Store) Export(ctx context.Context, from Seq, w io.Writer) (Seq, error) {
+	encoder := json.NewEncoder(w)
+	return s.Transactions(ctx, from, func(transaction Transaction) error {
+		return encoder.Encode(transaction)
+	})
+}
+
+// Decode calls fn for every transaction read from the given reader, which contains transactions written by Export.
+func Decode(r io.Reader, fn func(Transaction) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var transaction Transaction
+		if err := json.Unmarshal(scanner.Bytes(), &transaction); err != nil {
+			return fmt.Errorf("invalid journaled transaction (line %d): %w", line, err)
+		}
+		if err := fn(transaction); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// Truncate removes the journaled transactions before the given sequence number (exclusive),
+// e.g. after a backup has been made. It returns the number of removed transactions.
+func (s *Store) Truncate(ctx context.Context, before Seq) (int, error) {
+	removed := 0
+	err := s.underlying.WriteShelf(ctx, journalShelf, func(writer stoabs.Writer) error {
+		first, last, err := readBounds(writer)
+		if err != nil {
+			return err
+		}
+		if before > last {
+			before = last
+		}
+		for seq := first; seq < before; seq++ {
+			if err := writer.Delete(stoabs.Uint64Key(seq)); err != nil {
+				return err
+			}
+			removed++
+		}
+		if removed == 0 {
+			return nil
+		}
+		return stoabs.JSONShelf[state](writer).Put(stateKey, state{First: before, Next: last})
+	})
+	return removed, err
+}
+
+// NextSeq returns the sequence number that will be assigned to the next journaled transaction.
+func (s *Store) NextSeq(ctx context.Context) (Seq, error) {
+	var result Seq
+	err := s.underlying.ReadShelf(ctx, journalShelf, func(reader stoabs.Reader) error {
+		var err error
+		_, result, err = readBounds(reader)
+		return err
+	})
+	return result, err
+}
+
+func (s *Store) Close(ctx context.Context) error {
+	return s.underlying.Close(ctx)
+}
+
+func (s *Store) Write(ctx context.Context, fn func(stoabs.WriteTx) error, opts ...stoabs.TxOption) error {
+	if !(stoabs.WriteLockOption{}).Enabled(opts) {
+		opts = append(opts, stoabs.WithWriteLock())
+	}
+	author := AuthorOption{}.Author(opts)
+	return s.underlying.Write(ctx, func(underlyingTx stoabs.WriteTx) error {
+		t := &tx{ReadTx: underlyingTx, writeTx: underlyingTx, store: s}
+		if err := fn(t); err != nil {
+			return err
+		}
+		return t.appendTransaction(author)
+	}, opts...)
+}
+
+func (s *Store) WriteShelf(ctx context.Context, shelfName string, fn func(stoabs.Writer) error) error {
+	return s.Write(ctx, func(tx stoabs.WriteTx) error {
+		return fn(tx.GetShelfWriter(shelfName))
+	})
+}
+
+func (s *Store) Read(ctx context.Context, fn func(stoabs.ReadTx) error) error {
+	return s.underlying.Read(ctx, func(underlyingTx stoabs.ReadTx) error {
+		return fn(&tx{ReadTx: underlyingTx, store: s})
+	})
+}
+
+func (s *Store) ReadShelf(ctx context.Context, shelfName string, fn func(stoabs.Reader) error) error {
+	return s.Read(ctx, func(tx stoabs.ReadTx) error {
+		return fn(tx.GetShelfReader(shelfName))
+	})
+}
+
+// ShelfNames returns the shelves of the underlying store. The journal is a reserved shelf, so it's only listed when
+// requested using stoabs.ContextWithReservedShelves.
+func (s *Store) ShelfNames(ctx context.Context) ([]string, error) {
+	return stoabs.ShelfNames(ctx, s.underlying)
+}
+
+// state is stored at stateKey in the journal shelf.
+type state struct {
+	// First is the sequence number of the oldest retained transaction.
+	First Seq `json:"first"`
+	// Next is the sequence number that will be assigned to the next transaction.
+	Next Seq `json:"next"`
+}
+
+// readBounds returns the sequence number of the oldest retained transaction and the next transaction.
+func readBounds(reader stoabs.Reader) (Seq, Seq, error) {
+	result, err := stoabs.JSONShelf[state](reader).Get(stateKey)
+	if errors.Is(err, stoabs.ErrKeyNotFound) {
+		return 1, 1, nil
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to read journal state: %w", err)
+	}
+	return result.First, result.Next, nil
+}
+
+type tx struct {
+	stoabs.ReadTx
+	writeTx   stoabs.WriteTx
+	store     *Store
+	mutations []Mutation
+}
+
+func (t *tx) GetShelfReader(shelfName string) stoabs.Reader {
+	return t.ReadTx.GetShelfReader(shelfName)
+}
+
+func (t *tx) GetShelfWriter(shelfName string) stoabs.Writer {
+	writer := t.writeTx.GetShelfWriter(shelfName)
+	return &shelf{Writer: writer, name: shelfName, tx: t}
+}
+
+func (t *tx) Store() stoabs.KVStore {
+	return t.store
+}
+
+// appendTransaction writes the mutations recorded in this transaction to the journal.
+func (t *tx) appendTransaction(author string) error {
+	if len(t.mutations) == 0 {
+		return nil
+	}
+	writer := t.writeTx.GetShelfWriter(journalShelf)
+	first, next, err := readBounds(writer)
+	if err != nil {
+		return err
+	}
+	transaction := Transaction{
+		Seq:       next,
+		Timestamp: t.store.now(),
+		Author:    author,
+		Mutations: t.mutations,
+	}
+	if err := stoabs.JSONShelf[Transaction](writer).Put(stoabs.Uint64Key(next), transaction); err != nil {
+		return err
+	}
+	return stoabs.JSONShelf[state](writer).Put(stateKey, state{First: first, Next: next + 1})
+}
+
+type shelf struct {
+	stoabs.Writer
+	name string
+	tx   *tx
+}
+
+func (s *shelf) Put(key stoabs.Key, value []byte) error {
+	if err := s.Writer.Put(key, value); err != nil {
+		return err
+	}
+	s.tx.mutations = append(s.tx.mutations, Mutation{
+		Shelf:   s.name,
+		Key:     bytes.Clone(key.Bytes()),
+		KeyType: cdc.KeyTypeOf(key),
+		Value:   bytes.Clone(value),
+	})
+	return nil
+}
+
+func (s *shelf) Delete(key stoabs.Key) error {
+	if err := s.Writer.Delete(key); err != nil {
+		return err
+	}
+	s.tx.mutations = append(s.tx.mutations, Mutation{
+		Shelf:   s.name,
+		Key:     bytes.Clone(key.Bytes()),
+		KeyType: cdc.KeyTypeOf(key),
+		Deleted: true,
+	})
+	return nil
+}
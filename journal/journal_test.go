@@ -0,0 +1,323 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package journal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+var key = stoabs.BytesKey("key")
+
+const shelfName = "test"
+
+func TestJournal(t *testing.T) {
+	provider := func(t *testing.T) (stoabs.KVStore, error) {
+		return Wrap(stores.BBolt(t)), nil
+	}
+
+	kvtests.TestReadingAndWriting(t, provider)
+	kvtests.TestRange(t, provider)
+	kvtests.TestIterate(t, provider)
+	kvtests.TestEmpty(t, provider)
+	kvtests.TestDelete(t, provider)
+	kvtests.TestWriteTransactions(t, provider)
+	kvtests.TestShelfNames(t, provider)
+}
+
+func TestStore_Transactions(t *testing.T) {
+	t.Run("records transactions", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t))
+		store.now = func() time.Time {
+			return time.Unix(1000, 0).UTC()
+		}
+		write(t, store, func(writer stoabs.Writer) error {
+			_ = writer.Put(key, []byte("v1"))
+			return writer.Put(stoabs.BytesKey("other"), []byte("v2"))
+		})
+		require.NoError(t, store.Write(ctx, func(tx stoabs.WriteTx) error {
+			return tx.GetShelfWriter(shelfName).Delete(key)
+		}, WithAuthor("alice")))
+
+		transactions, next := transactionsFrom(t, store, 0)
+
+		assert.Equal(t, Seq(3), next)
+		assert.Equal(t, []Transaction{
+			{Seq: 1, Timestamp: time.Unix(1000, 0).UTC(), Mutations: []Mutation{
+				{Shelf: shelfName, Key: key, KeyType: "bytes", Value: []byte("v1")},
+				{Shelf: shelfName, Key: []byte("other"), KeyType: "bytes", Value: []byte("v2")},
+			}},
+			{Seq: 2, Timestamp: time.Unix(1000, 0).UTC(), Author: "alice", Mutations: []Mutation{
+				{Shelf: shelfName, Key: key, KeyType: "bytes", Deleted: true},
+			}},
+		}, transactions)
+	})
+	t.Run("from sequence number", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t))
+		for i := 0; i < 3; i++ {
+			write(t, store, func(writer stoabs.Writer) error {
+				return writer.Put(key, []byte{byte(i)})
+			})
+		}
+
+		transactions, next := transactionsFrom(t, store, 2)
+
+		assert.Equal(t, Seq(4), next)
+		require.Len(t, transactions, 2)
+		assert.Equal(t, Seq(2), transactions[0].Seq)
+	})
+	t.Run("keys modified by the caller after writing", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t))
+		write(t, store, func(writer stoabs.Writer) error {
+			mutable := stoabs.BytesKey("key")
+			err := writer.Put(mutable, []byte("v1"))
+			mutable[0] = 'x'
+			return err
+		})
+
+		transactions, _ := transactionsFrom(t, store, 0)
+
+		require.Len(t, transactions, 1)
+		assert.Equal(t, []byte("key"), transactions[0].Mutations[0].Key)
+	})
+	t.Run("transactions without mutations or rolled back aren't recorded", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t))
+		write(t, store, func(writer stoabs.Writer) error {
+			return nil
+		})
+		_ = store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			_ = writer.Put(key, []byte("v1"))
+			return errors.New("failed")
+		})
+
+		transactions, next := transactionsFrom(t, store, 0)
+
+		assert.Empty(t, transactions)
+		assert.Equal(t, Seq(1), next)
+	})
+	t.Run("fn returns error", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t))
+		write(t, store, func(writer stoabs.Writer) error {
+			return writer.Put(key, []byte("v1"))
+		})
+
+		next, err := store.Transactions(ctx, 0, func(transaction Transaction) error {
+			return errors.New("failed")
+		})
+
+		assert.EqualError(t, err, "failed")
+		assert.Equal(t, Seq(1), next)
+	})
+}
+
+func TestStore_ReplayJournal(t *testing.T) {
+	t.Run("replays on another store", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t))
+		write(t, store, func(writer stoabs.Writer) error {
+			_ = writer.Put(key, []byte("v1"))
+			return writer.Put(stoabs.Uint64Key(1), []byte("v2"))
+		})
+		write(t, store, func(writer stoabs.Writer) error {
+			return writer.Delete(stoabs.Uint64Key(1))
+		})
+		target := stores.Redis(t)
+		write(t, target, func(writer stoabs.Writer) error {
+			return writer.Put(stoabs.Uint64Key(1), []byte("stale"))
+		})
+
+		next, err := store.ReplayJournal(ctx, 0, target)
+
+		require.NoError(t, err)
+		assert.Equal(t, Seq(3), next)
+		assert.Equal(t, []byte("v1"), get(t, target, key))
+		_ = target.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			_, err := reader.Get(stoabs.Uint64Key(1))
+			assert.ErrorIs(t, err, stoabs.ErrKeyNotFound)
+			return nil
+		})
+
+		t.Run("continue from returned sequence number", func(t *testing.T) {
+			write(t, store, func(writer stoabs.Writer) error {
+				return writer.Put(key, []byte("v3"))
+			})
+
+			next, err := store.ReplayJournal(ctx, next, target)
+
+			require.NoError(t, err)
+			assert.Equal(t, Seq(4), next)
+			assert.Equal(t, []byte("v3"), get(t, target, key))
+		})
+	})
+	t.Run("more transactions than a batch", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t))
+		for i := 0; i < replayBatchSize+1; i++ {
+			write(t, store, func(writer stoabs.Writer) error {
+				return writer.Put(stoabs.Uint64Key(uint64(i)), []byte("value"))
+			})
+		}
+		target := stores.BBolt(t)
+
+		next, err := store.ReplayJournal(ctx, 0, target)
+
+		require.NoError(t, err)
+		assert.Equal(t, Seq(replayBatchSize+2), next)
+		assert.Equal(t, []byte("value"), get(t, target, stoabs.Uint64Key(replayBatchSize)))
+	})
+	t.Run("reading fails after a batch", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		store := Wrap(underlying)
+		for i := 0; i < replayBatchSize+1; i++ {
+			write(t, store, func(writer stoabs.Writer) error {
+				return writer.Put(stoabs.Uint64Key(uint64(i)), []byte("value"))
+			})
+		}
+		require.NoError(t, underlying.WriteShelf(ctx, journalShelf, func(writer stoabs.Writer) error {
+			return writer.Put(stoabs.Uint64Key(replayBatchSize+1), []byte("invalid"))
+		}))
+		target := stores.BBolt(t)
+
+		next, err := store.ReplayJournal(ctx, 0, target)
+
+		assert.ErrorContains(t, err, fmt.Sprintf("unable to read journaled transaction (seq=%d)", replayBatchSize+1))
+		assert.Equal(t, Seq(replayBatchSize+1), next)
+		assert.Equal(t, []byte("value"), get(t, target, stoabs.Uint64Key(replayBatchSize-1)))
+	})
+	t.Run("target fails", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t))
+		write(t, store, func(writer stoabs.Writer) error {
+			return writer.Put(key, []byte("v1"))
+		})
+
+		next, err := store.ReplayJournal(ctx, 0, stoabs.ReadOnly(stores.BBolt(t)))
+
+		assert.ErrorIs(t, err, stoabs.ErrReadOnly)
+		assert.ErrorContains(t, err, "unable to apply journaled transaction (seq=1)")
+		assert.Equal(t, Seq(1), next)
+	})
+}
+
+func TestStore_Export(t *testing.T) {
+	store := Wrap(stores.BBolt(t))
+	write(t, store, func(writer stoabs.Writer) error {
+		return writer.Put(key, []byte("v1"))
+	})
+	write(t, store, func(writer stoabs.Writer) error {
+		return writer.Delete(key)
+	})
+	buf := new(bytes.Buffer)
+
+	next, err := store.Export(ctx, 0, buf)
+
+	require.NoError(t, err)
+	assert.Equal(t, Seq(3), next)
+	assert.Equal(t, 2, strings.Count(buf.String(), "\n"))
+
+	t.Run("decode", func(t *testing.T) {
+		var transactions []Transaction
+		err := Decode(buf, func(transaction Transaction) error {
+			transactions = append(transactions, transaction)
+			return nil
+		})
+
+		require.NoError(t, err)
+		expected, _ := transactionsFrom(t, store, 0)
+		assert.Equal(t, len(expected), len(transactions))
+		assert.Equal(t, expected[0].Mutations, transactions[0].Mutations)
+		assert.True(t, transactions[1].Mutations[0].Deleted)
+	})
+	t.Run("decode invalid", func(t *testing.T) {
+		err := Decode(strings.NewReader("{}\nnot json\n"), func(transaction Transaction) error {
+			return nil
+		})
+
+		assert.ErrorContains(t, err, "invalid journaled transaction (line 2)")
+	})
+}
+
+func TestStore_Truncate(t *testing.T) {
+	store := Wrap(stores.BBolt(t))
+	for i := 0; i < 3; i++ {
+		write(t, store, func(writer stoabs.Writer) error {
+			return writer.Put(key, []byte{byte(i)})
+		})
+	}
+
+	removed, err := store.Truncate(ctx, 3)
+
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	transactions, next := transactionsFrom(t, store, 0)
+	require.Len(t, transactions, 1)
+	assert.Equal(t, Seq(3), transactions[0].Seq)
+	assert.Equal(t, Seq(4), next)
+
+	t.Run("beyond last transaction", func(t *testing.T) {
+		removed, err := store.Truncate(ctx, 100)
+
+		require.NoError(t, err)
+		assert.Equal(t, 1, removed)
+		nextSeq, err := store.NextSeq(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, Seq(4), nextSeq)
+	})
+}
+
+func TestMutation_ParsedKey(t *testing.T) {
+	actual, err := Mutation{Key: stoabs.Uint32Key(5).Bytes(), KeyType: "uint32"}.ParsedKey()
+
+	require.NoError(t, err)
+	assert.Equal(t, stoabs.Uint32Key(5), actual)
+}
+
+func write(t *testing.T, store stoabs.KVStore, fn func(writer stoabs.Writer) error) {
+	require.NoError(t, store.WriteShelf(ctx, shelfName, fn))
+}
+
+func get(t *testing.T, store stoabs.KVStore, key stoabs.Key) []byte {
+	var result []byte
+	require.NoError(t, store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+		var err error
+		result, err = reader.Get(key)
+		return err
+	}))
+	return result
+}
+
+func transactionsFrom(t *testing.T, store *Store, from Seq) ([]Transaction, Seq) {
+	var result []Transaction
+	next, err := store.Transactions(ctx, from, func(transaction Transaction) error {
+		result = append(result, transaction)
+		return nil
+	})
+	require.NoError(t, err)
+	return result, next
+}
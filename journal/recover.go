@@ -0,0 +1,107 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package journal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/dump"
+)
+
+// errUntilReached is used to stop decoding the journal when a transaction after the recovery point is encountered.
+var errUntilReached = errors.New("recovery point reached")
+
+// RecoverOption configures a Recoverer.
+type RecoverOption func(r *Recoverer)
+
+// WithBinaryBackup specifies that backups are in the binary format written by dump.ExportBinary,
+// instead of the JSON format written by dump.Export.
+func WithBinaryBackup() RecoverOption {
+	return func(r *Recoverer) {
+		r.binary = true
+	}
+}
+
+// NewRecoverer creates a Recoverer that restores backups on stores created by the given function,
+// which must return an empty store.
+func NewRecoverer(create func() (stoabs.KVStore, error), opts ...RecoverOption) *Recoverer {
+	result := &Recoverer{create: create}
+	for _, opt := range opts {
+		opt(result)
+	}
+	return result
+}
+
+// Recoverer restores a store to a point in time, by restoring a backup and replaying the journaled transactions on it.
+type Recoverer struct {
+	create func() (stoabs.KVStore, error)
+	binary bool
+}
+
+// Recover creates a store, restores the backup on it and then replays the transactions read from the journal
+// (as written by Store.Export) that were committed at or before the given time. The backup may be nil to replay the
+// journal on an empty store.
+// The journal must contain all transactions committed since the backup was made. It may contain transactions from before
+// the backup, since replaying them ends in the same state, but until must not lie before the time the backup was made.
+// If recovery fails, the created store is closed.
+func (r *Recoverer) Recover(ctx context.Context, backup io.Reader, journal io.Reader, until time.Time) (stoabs.KVStore, error) {
+	store, err := r.create()
+	if err != nil {
+		return nil, fmt.Errorf("unable to create store: %w", err)
+	}
+	if err := r.recover(ctx, store, backup, journal, until); err != nil {
+		_ = store.Close(ctx)
+		return nil, err
+	}
+	return store, nil
+}
+
+func (r *Recoverer) recover(ctx context.Context, store stoabs.KVStore, backup io.Reader, journal io.Reader, until time.Time) error {
+	if backup != nil {
+		var err error
+		if r.binary {
+			err = dump.ImportBinary(ctx, store, backup)
+		} else {
+			err = dump.Import(ctx, store, backup)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to restore backup: %w", err)
+		}
+	}
+	var last Seq
+	err := Decode(journal, func(transaction Transaction) error {
+		if transaction.Timestamp.After(until) {
+			return errUntilReached
+		}
+		if last != 0 && transaction.Seq != last+1 {
+			return fmt.Errorf("journal is incomplete: transactions %d to %d are missing", last+1, transaction.Seq-1)
+		}
+		last = transaction.Seq
+		return Apply(ctx, store, transaction)
+	})
+	if err != nil && !errors.Is(err, errUntilReached) {
+		return fmt.Errorf("unable to replay journal: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package journal
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/dump"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecoverer_Recover(t *testing.T) {
+	// v1 is written before the backup, v2 at t=2000 and v3 at t=3000
+	store := Wrap(stores.BBolt(t))
+	store.now = func() time.Time {
+		return time.Unix(1000, 0)
+	}
+	write(t, store, func(writer stoabs.Writer) error {
+		return writer.Put(key, []byte("v1"))
+	})
+	backup := new(bytes.Buffer)
+	require.NoError(t, dump.Export(ctx, store, backup, shelfName))
+	binaryBackup := new(bytes.Buffer)
+	require.NoError(t, dump.ExportBinary(ctx, store, binaryBackup, shelfName))
+	for i, value := range []string{"v2", "v3"} {
+		store.now = func() time.Time {
+			return time.Unix(int64(2000+1000*i), 0)
+		}
+		write(t, store, func(writer stoabs.Writer) error {
+			return writer.Put(key, []byte(value))
+		})
+	}
+	journal := new(bytes.Buffer)
+	_, err := store.Export(ctx, 0, journal)
+	require.NoError(t, err)
+	recoverer := NewRecoverer(func() (stoabs.KVStore, error) {
+		return stores.BBolt(t), nil
+	})
+
+	t.Run("until point in time", func(t *testing.T) {
+		recovered, err := recoverer.Recover(ctx, bytes.NewReader(backup.Bytes()), bytes.NewReader(journal.Bytes()), time.Unix(2500, 0))
+
+		require.NoError(t, err)
+		assert.Equal(t, []byte("v2"), get(t, recovered, key))
+	})
+	t.Run("until is inclusive", func(t *testing.T) {
+		recovered, err := recoverer.Recover(ctx, bytes.NewReader(backup.Bytes()), bytes.NewReader(journal.Bytes()), time.Unix(3000, 0))
+
+		require.NoError(t, err)
+		assert.Equal(t, []byte("v3"), get(t, recovered, key))
+	})
+	t.Run("binary backup", func(t *testing.T) {
+		recoverer := NewRecoverer(func() (stoabs.KVStore, error) {
+			return stores.BBolt(t), nil
+		}, WithBinaryBackup())
+
+		recovered, err := recoverer.Recover(ctx, bytes.NewReader(binaryBackup.Bytes()), strings.NewReader(""), time.Unix(2500, 0))
+
+		require.NoError(t, err)
+		assert.Equal(t, []byte("v1"), get(t, recovered, key))
+	})
+	t.Run("without backup", func(t *testing.T) {
+		recovered, err := recoverer.Recover(ctx, nil, bytes.NewReader(journal.Bytes()), time.Unix(1000, 0))
+
+		require.NoError(t, err)
+		assert.Equal(t, []byte("v1"), get(t, recovered, key))
+	})
+	t.Run("incomplete journal", func(t *testing.T) {
+		lines := strings.SplitAfter(journal.String(), "\n")
+
+		_, err := recoverer.Recover(ctx, nil, strings.NewReader(lines[0]+lines[2]), time.Now())
+
+		assert.EqualError(t, err, "unable to replay journal: journal is incomplete: transactions 2 to 2 are missing")
+	})
+	t.Run("invalid backup", func(t *testing.T) {
+		_, err := recoverer.Recover(ctx, strings.NewReader("not json"), bytes.NewReader(journal.Bytes()), time.Now())
+
+		assert.ErrorContains(t, err, "unable to restore backup")
+	})
+	t.Run("store can't be created", func(t *testing.T) {
+		recoverer := NewRecoverer(func() (stoabs.KVStore, error) {
+			return nil, errors.New("failed")
+		})
+
+		_, err := recoverer.Recover(ctx, nil, bytes.NewReader(journal.Bytes()), time.Now())
+
+		assert.EqualError(t, err, "unable to create store: failed")
+	})
+}
@@ -23,7 +23,6 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
-	"math/big"
 	"strconv"
 )
 
@@ -57,13 +56,10 @@ func (u Uint32Key) String() string {
 }
 
 func (u Uint32Key) FromString(i string) (Key, error) {
-	result, err := strconv.ParseInt(i, 10, 32)
+	result, err := strconv.ParseUint(i, 10, 32)
 	if err != nil {
 		return nil, fmt.Errorf("given string can't be parsed as %T: %w", u, err)
 	}
-	if result < 0 {
-		return nil, fmt.Errorf("given string can't be parsed as %T, because it yields a signed integer", u)
-	}
 	return Uint32Key(result), nil
 }
 
@@ -82,6 +78,43 @@ func (u Uint32Key) Equals(other Key) bool {
 	return ok && o == u
 }
 
+// Uint64Key is a type helper for a uint64 as Key
+type Uint64Key uint64
+
+func (u Uint64Key) FromBytes(i []byte) (Key, error) {
+	if len(i) != 8 {
+		return nil, fmt.Errorf("given bytes (len=%d) can't be parsed as %T", len(i), u)
+	}
+	return Uint64Key(binary.BigEndian.Uint64(i)), nil
+}
+
+func (u Uint64Key) String() string {
+	return fmt.Sprintf("%d", u)
+}
+
+func (u Uint64Key) FromString(i string) (Key, error) {
+	result, err := strconv.ParseUint(i, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("given string can't be parsed as %T: %w", u, err)
+	}
+	return Uint64Key(result), nil
+}
+
+func (u Uint64Key) Bytes() []byte {
+	result := make([]byte, 8)
+	binary.BigEndian.PutUint64(result[:], uint64(u))
+	return result
+}
+
+func (u Uint64Key) Next() Key {
+	return u + 1
+}
+
+func (u Uint64Key) Equals(other Key) bool {
+	o, ok := other.(Uint64Key)
+	return ok && o == u
+}
+
 // HashKey is a type helper for a 256 bits hash as Key
 type HashKey [32]byte
 
@@ -115,13 +148,11 @@ func (s HashKey) Bytes() []byte {
 	return s[:]
 }
 
+// Next returns the hash incremented by one as a big-endian number. Like the integer keys, it wraps around to zero.
 func (s HashKey) Next() Key {
-	next := &big.Int{}
-	add := big.NewInt(1)
-	next.SetBytes(s[:])
-	next.Add(next, add)
-	result := next.Bytes()
-	return HashKey(*(*[32]byte)(result[:32]))
+	next := s
+	increment(next[:])
+	return next
 }
 
 func (s HashKey) Equals(other Key) bool {
@@ -152,15 +183,29 @@ func (b BytesKey) FromBytes(i []byte) (Key, error) {
 	return BytesKey(i), nil
 }
 
+// Next returns the key incremented by one as a big-endian number of the same length (including leading zeros),
+// so keys of equal length are enumerated in order. If all bytes are 0xFF (or the key is empty) a zero byte is appended
+// instead, which yields the smallest key that is greater.
 func (b BytesKey) Next() Key {
-	next := &big.Int{}
-	add := big.NewInt(1)
-	next.SetBytes(b)
-	next.Add(next, add)
-	return BytesKey(next.Bytes())
+	next := bytes.Clone(b)
+	if increment(next) {
+		return BytesKey(append(bytes.Clone(b), 0))
+	}
+	return BytesKey(next)
 }
 
 func (b BytesKey) Equals(other Key) bool {
 	o, ok := other.(BytesKey)
 	return ok && bytes.Equal(b, o)
 }
+
+// increment adds one to the given big-endian number in place, returning true if it overflowed (wrapping around to zero).
+func increment(number []byte) bool {
+	for i := len(number) - 1; i >= 0; i-- {
+		number[i]++
+		if number[i] != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -19,7 +19,10 @@
 package stoabs
 
 import (
+	"bytes"
 	"encoding/hex"
+	"math"
+	"math/big"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -63,6 +66,47 @@ func TestUint32Key_FromBytes(t *testing.T) {
 	})
 }
 
+func TestUint64Key_Next(t *testing.T) {
+	key := Uint64Key(1 << 40)
+
+	assert.Equal(t, "1099511627777", key.Next().String())
+}
+
+func TestUint64Key_String(t *testing.T) {
+	key := Uint64Key(1 << 40)
+
+	actual, err := key.FromString(key.String())
+	assert.NoError(t, err)
+	assert.Equal(t, key, actual)
+	_, err = key.FromString("-1")
+	assert.Error(t, err)
+}
+
+func TestUint64Key_Bytes(t *testing.T) {
+	key := Uint64Key(1)
+	expected := []byte{0, 0, 0, 0, 0, 0, 0, 1}
+
+	assert.Equal(t, expected, key.Bytes())
+}
+
+func TestUint64Key_FromBytes(t *testing.T) {
+	key := Uint64Key(1)
+	keyBytes := key.Bytes()
+
+	t.Run("ok", func(t *testing.T) {
+		actual, err := key.FromBytes(keyBytes)
+		assert.NoError(t, err)
+		assert.Equal(t, key, actual)
+		assert.True(t, key.Equals(actual))
+		assert.False(t, key.Equals(Uint32Key(1)))
+	})
+	t.Run("invalid length", func(t *testing.T) {
+		actual, err := key.FromBytes([]byte{1})
+		assert.EqualError(t, err, "given bytes (len=1) can't be parsed as stoabs.Uint64Key")
+		assert.Nil(t, actual)
+	})
+}
+
 func TestBytesKey_Next(t *testing.T) {
 	key := BytesKey([]byte{0x09})
 
@@ -154,3 +198,111 @@ func TestHashKey_FromBytes(t *testing.T) {
 		assert.Nil(t, actual)
 	})
 }
+
+func TestBytesKey_Next_Carry(t *testing.T) {
+	assert.Equal(t, BytesKey{0x01, 0x00}, BytesKey{0x00, 0xFF}.Next(), "leading zeros are retained")
+	assert.Equal(t, BytesKey{0x02, 0x00}, BytesKey{0x01, 0xFF}.Next())
+	assert.Equal(t, BytesKey{0xFF, 0xFF, 0x00}, BytesKey{0xFF, 0xFF}.Next(), "overflow appends a zero byte")
+	assert.Equal(t, BytesKey{0x00}, BytesKey{}.Next())
+}
+
+func TestHashKey_Next_Carry(t *testing.T) {
+	var key HashKey
+	key[31] = 0xFF
+
+	next := key.Next().(HashKey)
+
+	assert.Equal(t, byte(1), next[30])
+	assert.Equal(t, byte(0), next[31])
+	var max HashKey
+	for i := range max {
+		max[i] = 0xFF
+	}
+	assert.Equal(t, HashKey{}, max.Next(), "wraps around")
+}
+
+// testKeyRoundTrips asserts the string and byte representations of the key can be parsed again.
+func testKeyRoundTrips(t *testing.T, key Key) {
+	fromString, err := key.FromString(key.String())
+	if assert.NoError(t, err) {
+		assert.True(t, key.Equals(fromString), "string round-trip of %s", key)
+	}
+	fromBytes, err := key.FromBytes(key.Bytes())
+	if assert.NoError(t, err) {
+		assert.True(t, key.Equals(fromBytes), "bytes round-trip of %s", key)
+	}
+}
+
+// testNextIsSuccessor asserts that Next() returns the big-endian successor of the key, which has the same length
+// and sorts after it, unless it wraps around.
+func testNextIsSuccessor(t *testing.T, key Key, wraps bool) {
+	next := key.Next()
+	assert.False(t, key.Equals(next))
+	assert.Len(t, next.Bytes(), len(key.Bytes()))
+	if wraps {
+		assert.Equal(t, make([]byte, len(key.Bytes())), next.Bytes())
+		return
+	}
+	assert.Equal(t, 1, bytes.Compare(next.Bytes(), key.Bytes()), "%s.Next() = %s doesn't sort after it", key, next)
+	expected := new(big.Int).Add(new(big.Int).SetBytes(key.Bytes()), big.NewInt(1))
+	assert.Equal(t, 0, expected.Cmp(new(big.Int).SetBytes(next.Bytes())), "%s.Next() = %s isn't its successor", key, next)
+}
+
+func FuzzBytesKey(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{0x00})
+	f.Add([]byte{0x00, 0x01})
+	f.Add([]byte{0x01, 0xFF})
+	f.Add([]byte{0xFF, 0xFF})
+	f.Fuzz(func(t *testing.T, input []byte) {
+		key := BytesKey(input)
+		testKeyRoundTrips(t, key)
+
+		if bytes.Count(input, []byte{0xFF}) < len(input) {
+			testNextIsSuccessor(t, key, false)
+			return
+		}
+		// all bytes are 0xFF: the next key is the key with a zero byte appended
+		next := key.Next().Bytes()
+		assert.Equal(t, append(bytes.Clone(input), 0), next)
+		assert.Equal(t, 1, bytes.Compare(next, input))
+	})
+}
+
+func FuzzUint32Key(f *testing.F) {
+	f.Add(uint32(0))
+	f.Add(uint32(255))
+	f.Add(uint32(math.MaxUint32))
+	f.Fuzz(func(t *testing.T, input uint32) {
+		key := Uint32Key(input)
+		testKeyRoundTrips(t, key)
+		testNextIsSuccessor(t, key, input == math.MaxUint32)
+	})
+}
+
+func FuzzUint64Key(f *testing.F) {
+	f.Add(uint64(0))
+	f.Add(uint64(math.MaxUint32))
+	f.Add(uint64(math.MaxUint64))
+	f.Fuzz(func(t *testing.T, input uint64) {
+		key := Uint64Key(input)
+		testKeyRoundTrips(t, key)
+		testNextIsSuccessor(t, key, input == math.MaxUint64)
+	})
+}
+
+func FuzzHashKey(f *testing.F) {
+	f.Add(make([]byte, 32))
+	f.Add(bytes.Repeat([]byte{0xFF}, 32))
+	f.Add(append(make([]byte, 31), 0xFF))
+	f.Fuzz(func(t *testing.T, input []byte) {
+		if len(input) != 32 {
+			_, err := HashKey{}.FromBytes(input)
+			assert.Error(t, err)
+			return
+		}
+		key := HashKey(input)
+		testKeyRoundTrips(t, key)
+		testNextIsSuccessor(t, key, bytes.Equal(input, bytes.Repeat([]byte{0xFF}, 32)))
+	})
+}
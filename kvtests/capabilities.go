@@ -0,0 +1,879 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package kvtests
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// Capability is an optional capability of a store, implemented through one of the optional stoabs interfaces.
+type Capability string
+
+const (
+	// CapabilityShelfLister means the store implements stoabs.ShelfLister.
+	CapabilityShelfLister Capability = "ShelfLister"
+	// CapabilityLocker means the store implements stoabs.Locker.
+	CapabilityLocker Capability = "Locker"
+	// CapabilityLeaser means the store implements stoabs.Leaser.
+	CapabilityLeaser Capability = "Leaser"
+	// CapabilityReadOptions means the store implements stoabs.OptionReader.
+	CapabilityReadOptions Capability = "ReadOptions"
+	// CapabilityBulkDelete means the writers of the store implement stoabs.BulkDeleter.
+	CapabilityBulkDelete Capability = "BulkDelete"
+	// CapabilityLazyRange means the readers and writers of the store implement stoabs.LazyRanger.
+	CapabilityLazyRange Capability = "LazyRange"
+	// CapabilityState means the store implements stoabs.StateReporter.
+	CapabilityState Capability = "State"
+	// CapabilityStats means the store implements stoabs.StatsReader.
+	CapabilityStats Capability = "Stats"
+	// CapabilityEntries means the readers and writers of the store implement stoabs.EntryIterator.
+	CapabilityEntries Capability = "Entries"
+	// CapabilityFreeze means the store implements stoabs.Freezer.
+	CapabilityFreeze Capability = "Freeze"
+	// CapabilityKeyHistogram means the readers and writers of the store implement stoabs.KeyHistogrammer.
+	CapabilityKeyHistogram Capability = "KeyHistogram"
+	// CapabilitySample means the readers and writers of the store implement stoabs.Sampler.
+	CapabilitySample Capability = "Sample"
+	// CapabilitySnapshot means the store implements stoabs.Snapshotter.
+	CapabilitySnapshot Capability = "Snapshot"
+)
+
+// capability describes how to detect and test a Capability.
+type capability struct {
+	name Capability
+	// flag is the capability as reported by stores implementing stoabs.CapabilityReporter.
+	flag stoabs.Capabilities
+	// supported returns whether the store supports the capability.
+	supported func(t *testing.T, store stoabs.KVStore) bool
+	test      func(t *testing.T, storeProvider StoreProvider)
+}
+
+// capabilities lists all capabilities known to the conformance tests.
+// When adding an optional interface to stoabs, add it here so every backend is tested (or skipped) the same way.
+var capabilities = []capability{
+	{
+		name: CapabilityShelfLister,
+		flag: stoabs.CapabilityShelfLister,
+		supported: func(_ *testing.T, store stoabs.KVStore) bool {
+			_, ok := store.(stoabs.ShelfLister)
+			return ok
+		},
+		test: TestShelfNames,
+	},
+	{
+		name: CapabilityLocker,
+		flag: stoabs.CapabilityLocker,
+		supported: func(_ *testing.T, store stoabs.KVStore) bool {
+			_, ok := store.(stoabs.Locker)
+			return ok
+		},
+		test: TestLockKeys,
+	},
+	{
+		name: CapabilityLeaser,
+		flag: stoabs.CapabilityLeaser,
+		supported: func(_ *testing.T, store stoabs.KVStore) bool {
+			_, ok := store.(stoabs.Leaser)
+			return ok
+		},
+		test: TestLeases,
+	},
+	{
+		name: CapabilityReadOptions,
+		flag: stoabs.CapabilityReadOptions,
+		supported: func(_ *testing.T, store stoabs.KVStore) bool {
+			_, ok := store.(stoabs.OptionReader)
+			return ok
+		},
+		test: testReadOptions,
+	},
+	{
+		name: CapabilityBulkDelete,
+		flag: stoabs.CapabilityBulkDelete,
+		supported: func(t *testing.T, store stoabs.KVStore) bool {
+			return writerImplements(t, store, func(writer stoabs.Writer) bool {
+				_, ok := writer.(stoabs.BulkDeleter)
+				return ok
+			})
+		},
+		test: testBulkDelete,
+	},
+	{
+		name: CapabilityLazyRange,
+		flag: stoabs.CapabilityLazyRange,
+		supported: func(t *testing.T, store stoabs.KVStore) bool {
+			return writerImplements(t, store, func(writer stoabs.Writer) bool {
+				_, ok := writer.(stoabs.LazyRanger)
+				return ok
+			})
+		},
+		test: testLazyRange,
+	},
+	{
+		name: CapabilityState,
+		flag: stoabs.CapabilityState,
+		supported: func(_ *testing.T, store stoabs.KVStore) bool {
+			_, ok := store.(stoabs.StateReporter)
+			return ok
+		},
+		test: testState,
+	},
+	{
+		name: CapabilityStats,
+		flag: stoabs.CapabilityStats,
+		supported: func(_ *testing.T, store stoabs.KVStore) bool {
+			_, ok := store.(stoabs.StatsReader)
+			return ok
+		},
+		test: testStats,
+	},
+	{
+		name: CapabilityEntries,
+		flag: stoabs.CapabilityEntries,
+		supported: func(t *testing.T, store stoabs.KVStore) bool {
+			return writerImplements(t, store, func(writer stoabs.Writer) bool {
+				_, ok := writer.(stoabs.EntryIterator)
+				return ok
+			})
+		},
+		test: testEntries,
+	},
+	{
+		name: CapabilityKeyHistogram,
+		flag: stoabs.CapabilityKeyHistogram,
+		supported: func(t *testing.T, store stoabs.KVStore) bool {
+			return writerImplements(t, store, func(writer stoabs.Writer) bool {
+				_, ok := writer.(stoabs.KeyHistogrammer)
+				return ok
+			})
+		},
+		test: testKeyHistogram,
+	},
+	{
+		name: CapabilitySample,
+		flag: stoabs.CapabilitySample,
+		supported: func(t *testing.T, store stoabs.KVStore) bool {
+			return writerImplements(t, store, func(writer stoabs.Writer) bool {
+				_, ok := writer.(stoabs.Sampler)
+				return ok
+			})
+		},
+		test: testSample,
+	},
+	{
+		name: CapabilitySnapshot,
+		flag: stoabs.CapabilitySnapshot,
+		supported: func(_ *testing.T, store stoabs.KVStore) bool {
+			_, ok := store.(stoabs.Snapshotter)
+			return ok
+		},
+		test: testSnapshot,
+	},
+	{
+		name: CapabilityFreeze,
+		flag: stoabs.CapabilityFreeze,
+		supported: func(_ *testing.T, store stoabs.KVStore) bool {
+			_, ok := store.(stoabs.Freezer)
+			return ok
+		},
+		test: testFreeze,
+	},
+}
+
+// reportedCapabilities lists the capabilities that aren't tested by the conformance tests (see capabilities),
+// but are checked against the capabilities reported by stores implementing stoabs.CapabilityReporter.
+var reportedCapabilities = []capability{
+	{
+		flag: stoabs.CapabilityLockInspector,
+		supported: func(_ *testing.T, store stoabs.KVStore) bool {
+			_, ok := store.(stoabs.LockInspector)
+			return ok
+		},
+	},
+	{
+		flag: stoabs.CapabilityEvictionWatcher,
+		supported: func(_ *testing.T, store stoabs.KVStore) bool {
+			_, ok := store.(stoabs.EvictionWatcher)
+			return ok
+		},
+	},
+	{
+		flag: stoabs.CapabilityKeyChecker,
+		supported: func(t *testing.T, store stoabs.KVStore) bool {
+			return writerImplements(t, store, func(writer stoabs.Writer) bool {
+				_, ok := writer.(stoabs.KeyChecker)
+				return ok
+			})
+		},
+	},
+	{
+		flag: stoabs.CapabilityRangeAggregator,
+		supported: func(t *testing.T, store stoabs.KVStore) bool {
+			return writerImplements(t, store, func(writer stoabs.Writer) bool {
+				_, ok := writer.(stoabs.RangeAggregator)
+				return ok
+			})
+		},
+	},
+}
+
+var errDetected = errors.New("capability detected")
+
+// writerImplements returns whether the writer returned by the store matches the given check.
+// The transaction is rolled back, so it doesn't create the shelf.
+func writerImplements(t *testing.T, store stoabs.KVStore, check func(writer stoabs.Writer) bool) bool {
+	var result bool
+	err := store.Write(context.Background(), func(tx stoabs.WriteTx) error {
+		result = check(tx.GetShelfWriter(shelf))
+		return errDetected
+	})
+	require.ErrorIs(t, err, errDetected)
+	return result
+}
+
+// TestCapabilities detects the optional capabilities of the store and runs the conformance tests of the supported ones,
+// skipping the others. The backend declares the capabilities it supports, so a capability that's (accidentally) lost
+// or gained fails the test instead of silently being skipped or tested.
+// If the store reports its capabilities (see stoabs.CapabilityReporter), they must match the detected ones.
+func TestCapabilities(t *testing.T, storeProvider StoreProvider, declared ...Capability) {
+	t.Run("capabilities", func(t *testing.T) {
+		store := createStore(t, storeProvider)
+		isDeclared := map[Capability]bool{}
+		for _, name := range declared {
+			isDeclared[name] = true
+		}
+		for _, c := range capabilities {
+			supported := c.supported(t, store)
+			if supported != isDeclared[c.name] {
+				t.Errorf("capability %s: declared=%v, but supported=%v", c.name, isDeclared[c.name], supported)
+				continue
+			}
+			t.Run(string(c.name), func(t *testing.T) {
+				if !supported {
+					t.Skipf("%T does not support %s", store, c.name)
+				}
+				c.test(t, storeProvider)
+			})
+			delete(isDeclared, c.name)
+		}
+		for name := range isDeclared {
+			t.Errorf("unknown capability declared: %s", name)
+		}
+		t.Run("reported", func(t *testing.T) {
+			reporter, ok := store.(stoabs.CapabilityReporter)
+			if !ok {
+				t.Skipf("%T does not report its capabilities", store)
+			}
+			reported := reporter.Capabilities()
+			for _, c := range append(capabilities[:len(capabilities):len(capabilities)], reportedCapabilities...) {
+				if supported := c.supported(t, store); supported != reported.Has(c.flag) {
+					t.Errorf("capability %s: reported=%v, but supported=%v", c.flag, reported.Has(c.flag), supported)
+				}
+			}
+		})
+	})
+}
+
+// testReadOptions tests stoabs.OptionReader.
+func testReadOptions(t *testing.T, storeProvider StoreProvider) {
+	ctx := context.Background()
+	store := createStore(t, storeProvider)
+	require.NoError(t, store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+		return writer.Put(bytesKey, bytesValue)
+	}))
+
+	t.Run("strong reads see the last commit", func(t *testing.T) {
+		var actual []byte
+		err := stoabs.ReadWithOptions(ctx, store, func(tx stoabs.ReadTx) error {
+			var err error
+			actual, err = tx.GetShelfReader(shelf).Get(bytesKey)
+			return err
+		}, stoabs.WithConsistency(stoabs.ConsistencyStrong))
+
+		require.NoError(t, err)
+		assert.Equal(t, bytesValue, actual)
+	})
+	t.Run("eventual reads", func(t *testing.T) {
+		err := stoabs.ReadWithOptions(ctx, store, func(tx stoabs.ReadTx) error {
+			_, err := tx.GetShelfReader(shelf).Get(bytesKey)
+			if errors.Is(err, stoabs.ErrKeyNotFound) {
+				// replica might not have caught up yet
+				return nil
+			}
+			return err
+		}, stoabs.WithConsistency(stoabs.ConsistencyEventual))
+
+		assert.NoError(t, err)
+	})
+}
+
+// testBulkDelete tests the (native) stoabs.BulkDeleter implementation of writers.
+func testBulkDelete(t *testing.T, storeProvider StoreProvider) {
+	ctx := context.Background()
+	store := createStore(t, storeProvider)
+	keys := []stoabs.Key{bytesKey, bytesKey.Next(), bytesKey.Next().Next(), largerBytesKey}
+	require.NoError(t, store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+		for _, key := range keys {
+			if err := writer.Put(key, key.Bytes()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	var deleted int
+	err := store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+		var err error
+		// delete consecutive entries, to check deleting doesn't skip the successor of a deleted entry
+		deleted, err = writer.(stoabs.BulkDeleter).DeleteWhere(stoabs.BytesKey{}, func(key stoabs.Key, value []byte) bool {
+			return !key.Equals(largerBytesKey) && bytes.Equal(key.Bytes(), value)
+		})
+		return err
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, 3, deleted)
+	var remaining []stoabs.Key
+	require.NoError(t, store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+		return reader.Iterate(func(key stoabs.Key, _ []byte) error {
+			remaining = append(remaining, key)
+			return nil
+		}, stoabs.BytesKey{})
+	}))
+	assert.Equal(t, []stoabs.Key{largerBytesKey}, remaining)
+}
+
+// testLazyRange tests the (native) stoabs.LazyRanger implementation of readers.
+func testLazyRange(t *testing.T, storeProvider StoreProvider) {
+	ctx := context.Background()
+	store := createStore(t, storeProvider)
+	// bytesKey, its successor and largerBytesKey, with a gap before largerBytesKey
+	require.NoError(t, store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+		for _, key := range []stoabs.Key{bytesKey, bytesKey.Next(), largerBytesKey} {
+			if err := writer.Put(key, key.Bytes()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	rangeLazy := func(stopAtNil bool) (map[string][]byte, error) {
+		loaded := map[string][]byte{}
+		err := store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+			return reader.(stoabs.LazyRanger).RangeLazy(bytesKey, largerBytesKey.Next(), func(key stoabs.Key, load stoabs.ValueLoader) error {
+				if key.Equals(bytesKey) {
+					// don't load the first value
+					loaded[string(key.Bytes())] = nil
+					return nil
+				}
+				value, err := load()
+				loaded[string(key.Bytes())] = value
+				return err
+			}, stopAtNil)
+		})
+		return loaded, err
+	}
+
+	t.Run("values are loaded on demand", func(t *testing.T) {
+		loaded, err := rangeLazy(false)
+
+		require.NoError(t, err)
+		assert.Equal(t, map[string][]byte{
+			string(bytesKey.Bytes()):        nil,
+			string(bytesKey.Next().Bytes()): bytesKey.Next().Bytes(),
+			string(largerBytesKey.Bytes()):  largerBytesKey.Bytes(),
+		}, loaded)
+	})
+	t.Run("stop at nil", func(t *testing.T) {
+		loaded, err := rangeLazy(true)
+
+		require.NoError(t, err)
+		assert.Len(t, loaded, 2)
+		assert.NotContains(t, loaded, string(largerBytesKey.Bytes()))
+	})
+}
+
+// testState tests stoabs.StateReporter. The transitions while closing are tested by TestClose.
+func testState(t *testing.T, storeProvider StoreProvider) {
+	store := createStore(t, storeProvider)
+	require.Equal(t, stoabs.StateOpen, store.(stoabs.StateReporter).State())
+
+	require.NoError(t, store.Close(context.Background()))
+
+	assert.Equal(t, stoabs.StateClosed, store.(stoabs.StateReporter).State())
+}
+
+// testStats tests stoabs.StatsReader.
+func testStats(t *testing.T, storeProvider StoreProvider) {
+	ctx := context.Background()
+
+	t.Run("shelves", func(t *testing.T) {
+		store := createStore(t, storeProvider)
+		for _, name := range []string{"a", "b"} {
+			require.NoError(t, store.WriteShelf(ctx, name, func(writer stoabs.Writer) error {
+				return writer.Put(bytesKey, bytesValue)
+			}))
+		}
+
+		stats, err := stoabs.Stats(ctx, store)
+
+		require.NoError(t, err)
+		if _, ok := store.(stoabs.ShelfLister); ok {
+			names, err := stoabs.ShelfNames(ctx, store)
+			require.NoError(t, err)
+			assert.Equal(t, len(names), stats.Shelves)
+		}
+		assert.Equal(t, 0, stats.OpenTransactions)
+	})
+	t.Run("open transactions", func(t *testing.T) {
+		store := createStore(t, storeProvider)
+		started := make(chan struct{})
+		release := make(chan struct{})
+		done := make(chan error, 1)
+		go func() {
+			done <- store.Read(ctx, func(_ stoabs.ReadTx) error {
+				close(started)
+				<-release
+				return nil
+			})
+		}()
+		<-started
+
+		stats, err := stoabs.Stats(ctx, store)
+		close(release)
+
+		require.NoError(t, err)
+		assert.Equal(t, 1, stats.OpenTransactions)
+		assert.NoError(t, <-done)
+	})
+	t.Run("closed store", func(t *testing.T) {
+		store := createStore(t, storeProvider)
+		require.NoError(t, store.Close(ctx))
+
+		_, err := stoabs.Stats(ctx, store)
+
+		assert.ErrorIs(t, err, stoabs.ErrStoreIsClosed)
+	})
+}
+
+// testFreeze tests stoabs.Freezer.
+func testFreeze(t *testing.T, storeProvider StoreProvider) {
+	ctx := context.Background()
+	put := func(store stoabs.KVStore) error {
+		return store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+			return writer.Put(bytesKey, bytesValue)
+		})
+	}
+
+	t.Run("rejects writes until unfrozen", func(t *testing.T) {
+		store := createStore(t, storeProvider)
+		require.NoError(t, put(store))
+
+		require.NoError(t, stoabs.Freeze(ctx, store))
+
+		assert.True(t, store.(stoabs.Freezer).Frozen())
+		assert.ErrorIs(t, put(store), stoabs.ErrFrozen)
+		assert.ErrorIs(t, store.Write(ctx, func(_ stoabs.WriteTx) error {
+			return nil
+		}), stoabs.ErrFrozen)
+		err := store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+			_, err := reader.Get(bytesKey)
+			return err
+		})
+		assert.NoError(t, err, "reads are allowed")
+
+		require.NoError(t, stoabs.Unfreeze(store))
+
+		assert.False(t, store.(stoabs.Freezer).Frozen())
+		assert.NoError(t, put(store))
+	})
+	t.Run("waits for in-flight writes", func(t *testing.T) {
+		store := createStore(t, storeProvider)
+		started := make(chan struct{})
+		release := make(chan struct{})
+		written := make(chan error, 1)
+		go func() {
+			written <- store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+				close(started)
+				<-release
+				return writer.Put(bytesKey, bytesValue)
+			})
+		}()
+		<-started
+		frozen := make(chan error, 1)
+		go func() {
+			frozen <- stoabs.Freeze(ctx, store)
+		}()
+		assert.Eventually(t, store.(stoabs.Freezer).Frozen, time.Second, time.Millisecond)
+		assert.Len(t, frozen, 0)
+
+		close(release)
+
+		require.NoError(t, <-frozen)
+		require.NoError(t, <-written, "in-flight write commits")
+		err := store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+			_, err := reader.Get(bytesKey)
+			return err
+		})
+		assert.NoError(t, err)
+	})
+}
+
+// testEntries tests the (native) stoabs.EntryIterator implementation of readers.
+func testEntries(t *testing.T, storeProvider StoreProvider) {
+	ctx := context.Background()
+	store := createStore(t, storeProvider)
+	// keys 0..9, except 5
+	require.NoError(t, store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+		for i := uint32(0); i < 10; i++ {
+			if i == 5 {
+				continue
+			}
+			if err := writer.Put(stoabs.Uint32Key(i), stoabs.Uint32Key(i+100).Bytes()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	entries := func(from, to stoabs.Key, limit int) ([]stoabs.KeyValue, error) {
+		var result []stoabs.KeyValue
+		err := store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+			for entry, err := range reader.(stoabs.EntryIterator).Entries(from, to) {
+				if err != nil {
+					return err
+				}
+				result = append(result, stoabs.KeyValue{Key: entry.Key, Value: bytes.Clone(entry.Value)})
+				if len(result) == limit {
+					break
+				}
+			}
+			return nil
+		})
+		return result, err
+	}
+
+	t.Run("entries in range, ordered by key", func(t *testing.T) {
+		actual, err := entries(stoabs.Uint32Key(2), stoabs.Uint32Key(8), -1)
+
+		require.NoError(t, err)
+		var keys []stoabs.Key
+		for _, entry := range actual {
+			keys = append(keys, entry.Key)
+		}
+		assert.Equal(t, []stoabs.Key{stoabs.Uint32Key(2), stoabs.Uint32Key(3), stoabs.Uint32Key(4), stoabs.Uint32Key(6), stoabs.Uint32Key(7)}, keys)
+		assert.Equal(t, stoabs.Uint32Key(102).Bytes(), actual[0].Value)
+	})
+	t.Run("break stops iteration", func(t *testing.T) {
+		actual, err := entries(stoabs.Uint32Key(0), stoabs.Uint32Key(10), 2)
+
+		require.NoError(t, err)
+		assert.Len(t, actual, 2)
+	})
+	t.Run("empty range", func(t *testing.T) {
+		actual, err := entries(stoabs.Uint32Key(20), stoabs.Uint32Key(30), -1)
+
+		require.NoError(t, err)
+		assert.Empty(t, actual)
+	})
+	t.Run("TX context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		err := store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+			for _, err := range reader.(stoabs.EntryIterator).Entries(stoabs.Uint32Key(0), stoabs.Uint32Key(10)) {
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+// testKeyHistogram tests the (native) stoabs.KeyHistogrammer implementation of readers.
+func testKeyHistogram(t *testing.T, storeProvider StoreProvider) {
+	ctx := context.Background()
+	store := createStore(t, storeProvider)
+	histogram := func(shelfName string, buckets int, keyType stoabs.Key) (stoabs.Histogram, error) {
+		var result stoabs.Histogram
+		err := store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			var err error
+			result, err = reader.(stoabs.KeyHistogrammer).KeyHistogram(buckets, keyType)
+			return err
+		})
+		return result, err
+	}
+	// keys 0..99, with a hotspot of 100 keys in 1000..1099
+	require.NoError(t, store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+		for i := uint64(0); i < 100; i++ {
+			if err := writer.Put(stoabs.Uint64Key(i), []byte{1}); err != nil {
+				return err
+			}
+			if err := writer.Put(stoabs.Uint64Key(1000+i), []byte{1}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	t.Run("keys are distributed over the buckets", func(t *testing.T) {
+		actual, err := histogram(shelf, 11, stoabs.Uint64Key(0))
+
+		require.NoError(t, err)
+		require.Len(t, actual.Buckets, 11)
+		assert.Equal(t, uint(200), actual.Total())
+		assert.Equal(t, stoabs.Uint64Key(0), actual.Buckets[0].From)
+		assert.Equal(t, stoabs.Uint64Key(100), actual.Buckets[1].From)
+		assert.Equal(t, uint(100), actual.Buckets[0].Count)
+		assert.Equal(t, uint(0), actual.Buckets[5].Count)
+		assert.Equal(t, uint(100), actual.Buckets[10].Count)
+		assert.Equal(t, stoabs.Uint64Key(1099), actual.Last)
+	})
+	t.Run("empty shelf", func(t *testing.T) {
+		require.NoError(t, store.WriteShelf(ctx, "empty", func(writer stoabs.Writer) error {
+			if err := writer.Put(stoabs.Uint64Key(1), []byte{1}); err != nil {
+				return err
+			}
+			return writer.Delete(stoabs.Uint64Key(1))
+		}))
+
+		actual, err := histogram("empty", 10, stoabs.Uint64Key(0))
+
+		require.NoError(t, err)
+		assert.Empty(t, actual.Buckets)
+	})
+	t.Run("invalid number of buckets", func(t *testing.T) {
+		_, err := histogram(shelf, 0, stoabs.Uint64Key(0))
+
+		assert.EqualError(t, err, "invalid number of histogram buckets: 0")
+	})
+	t.Run("TX context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		err := store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+			_, err := reader.(stoabs.KeyHistogrammer).KeyHistogram(10, stoabs.Uint64Key(0))
+			return err
+		})
+
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+// testSample tests the (native) stoabs.Sampler implementation of readers.
+func testSample(t *testing.T, storeProvider StoreProvider) {
+	ctx := context.Background()
+	store := createStore(t, storeProvider)
+	sample := func(shelfName string, n int) ([]stoabs.KeyValue, error) {
+		var result []stoabs.KeyValue
+		err := store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			var err error
+			result, err = reader.(stoabs.Sampler).Sample(n, stoabs.Uint32Key(0))
+			return err
+		})
+		return result, err
+	}
+	put := func(shelfName string, count uint32) {
+		require.NoError(t, store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			for i := uint32(0); i < count; i++ {
+				if err := writer.Put(stoabs.Uint32Key(i), stoabs.Uint32Key(i+1000).Bytes()); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+	}
+	put(shelf, 100)
+	put("small", 4)
+
+	t.Run("distinct entries of the shelf", func(t *testing.T) {
+		actual, err := sample(shelf, 10)
+
+		require.NoError(t, err)
+		require.Len(t, actual, 10)
+		seen := map[stoabs.Uint32Key]bool{}
+		for _, entry := range actual {
+			key := entry.Key.(stoabs.Uint32Key)
+			assert.Less(t, uint32(key), uint32(100))
+			assert.Equal(t, stoabs.Uint32Key(key+1000).Bytes(), entry.Value)
+			assert.False(t, seen[key], "duplicate key %d", key)
+			seen[key] = true
+		}
+	})
+	t.Run("all entries if the shelf is smaller than the sample", func(t *testing.T) {
+		actual, err := sample("small", 10)
+
+		require.NoError(t, err)
+		assert.Len(t, actual, 4)
+	})
+	t.Run("every entry can be sampled", func(t *testing.T) {
+		seen := map[stoabs.Key]bool{}
+		for i := 0; i < 200 && len(seen) < 4; i++ {
+			actual, err := sample("small", 1)
+			require.NoError(t, err)
+			require.Len(t, actual, 1)
+			seen[actual[0].Key] = true
+		}
+
+		assert.Len(t, seen, 4)
+	})
+	t.Run("empty sample", func(t *testing.T) {
+		actual, err := sample(shelf, 0)
+
+		require.NoError(t, err)
+		assert.Empty(t, actual)
+	})
+	t.Run("TX context cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		err := store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+			_, err := reader.(stoabs.Sampler).Sample(10, stoabs.Uint32Key(0))
+			return err
+		})
+
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+// testSnapshot tests the stoabs.Snapshotter implementation of the store.
+func testSnapshot(t *testing.T, storeProvider StoreProvider) {
+	ctx := context.Background()
+	key1 := stoabs.BytesKey("1")
+	key2 := stoabs.BytesKey("2")
+	read := func(store stoabs.KVStore, key stoabs.Key) ([]byte, error) {
+		var result []byte
+		err := store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+			var err error
+			result, err = reader.Get(key)
+			return err
+		})
+		return result, err
+	}
+	newSnapshot := func(t *testing.T) (stoabs.KVStore, stoabs.KVStore) {
+		store := createStore(t, storeProvider)
+		require.NoError(t, store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+			return writer.Put(key1, []byte("before"))
+		}))
+		snapshot, err := store.(stoabs.Snapshotter).SnapshotStore(ctx)
+		require.NoError(t, err)
+		return store, snapshot
+	}
+
+	t.Run("doesn't see later writes", func(t *testing.T) {
+		store, snapshot := newSnapshot(t)
+		defer snapshot.Close(ctx)
+
+		require.NoError(t, store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+			if err := writer.Put(key1, []byte("after")); err != nil {
+				return err
+			}
+			return writer.Put(key2, []byte("after"))
+		}))
+
+		value, err := read(snapshot, key1)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("before"), value)
+		_, err = read(snapshot, key2)
+		assert.ErrorIs(t, err, stoabs.ErrKeyNotFound)
+		value, err = read(store, key1)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("after"), value)
+	})
+	t.Run("transactions return the snapshot as store", func(t *testing.T) {
+		_, snapshot := newSnapshot(t)
+		defer snapshot.Close(ctx)
+
+		err := snapshot.Read(ctx, func(tx stoabs.ReadTx) error {
+			assert.Same(t, snapshot, tx.Store())
+			return nil
+		})
+
+		assert.NoError(t, err)
+	})
+	t.Run("read-only", func(t *testing.T) {
+		_, snapshot := newSnapshot(t)
+		defer snapshot.Close(ctx)
+
+		err := snapshot.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+			return writer.Put(key2, []byte("value"))
+		})
+		assert.ErrorIs(t, err, stoabs.ErrReadOnly)
+		err = snapshot.Write(ctx, func(tx stoabs.WriteTx) error {
+			return tx.GetShelfWriter(shelf).Put(key2, []byte("value"))
+		})
+		assert.ErrorIs(t, err, stoabs.ErrReadOnly)
+	})
+	t.Run("closed independently of the store", func(t *testing.T) {
+		store, snapshot := newSnapshot(t)
+
+		require.NoError(t, snapshot.Close(ctx))
+
+		_, err := read(snapshot, key1)
+		assert.ErrorIs(t, err, stoabs.ErrStoreIsClosed)
+		value, err := read(store, key1)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("before"), value)
+		assert.NoError(t, snapshot.Close(ctx), "closing twice")
+	})
+	t.Run("keys of other types", func(t *testing.T) {
+		store := createStore(t, storeProvider)
+		keys := []stoabs.Key{stoabs.Uint32Key(12), stoabs.Uint32Key(300)}
+		require.NoError(t, store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+			for _, key := range keys {
+				if err := writer.Put(key, []byte(key.String())); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+		snapshot, err := store.(stoabs.Snapshotter).SnapshotStore(ctx)
+		require.NoError(t, err)
+		defer snapshot.Close(ctx)
+
+		value, err := read(snapshot, stoabs.Uint32Key(12))
+		require.NoError(t, err)
+		assert.Equal(t, []byte("12"), value)
+		var ranged []stoabs.Key
+		var iterated []stoabs.Key
+		err = snapshot.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+			if err := reader.Range(stoabs.Uint32Key(0), stoabs.Uint32Key(1000), func(key stoabs.Key, _ []byte) error {
+				ranged = append(ranged, key)
+				return nil
+			}, false); err != nil {
+				return err
+			}
+			return reader.Iterate(func(key stoabs.Key, _ []byte) error {
+				iterated = append(iterated, key)
+				return nil
+			}, stoabs.Uint32Key(0))
+		})
+		require.NoError(t, err)
+		assert.Equal(t, keys, ranged)
+		assert.ElementsMatch(t, keys, iterated)
+	})
+}
@@ -19,7 +19,9 @@
 package kvtests
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"errors"
 	"github.com/dgraph-io/badger/v4"
 	"github.com/nuts-foundation/go-stoabs"
@@ -27,6 +29,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.etcd.io/bbolt"
+	"math/rand"
 	"sync"
 	"testing"
 	"time"
@@ -288,9 +291,11 @@ func TestRange(t *testing.T, storeProvider StoreProvider) {
 			// Cancel read context
 			ctx, cancel := context.WithCancel(ctx)
 
+			calls := 0
 			err := store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
 				return reader.Range(bytesKey, largerBytesKey, func(key stoabs.Key, value []byte) error {
 					// cancel within Range to make sure the context cancellation is caught during Range()
+					calls++
 					cancel()
 					return nil
 				}, false)
@@ -298,6 +303,49 @@ func TestRange(t *testing.T, storeProvider StoreProvider) {
 
 			assert.ErrorIs(t, err, context.Canceled)
 			assert.ErrorIs(t, err, stoabs.ErrDatabase{})
+			assert.Equal(t, 1, calls, "cancellation should stop the scan")
+		})
+	})
+	t.Run("RangeLazy()", func(t *testing.T) {
+		store := createStore(t, storeProvider)
+		// 0 - 1 - gap - 3
+		require.NoError(t, store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+			for _, i := range []uint32{0, 1, 3} {
+				if err := writer.Put(stoabs.Uint32Key(i), []byte{byte(i)}); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+		rangeLazy := func(t *testing.T, stopAtNil bool) ([]stoabs.Key, [][]byte) {
+			var keys []stoabs.Key
+			var values [][]byte
+			require.NoError(t, store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+				return stoabs.RangeLazy(reader, stoabs.Uint32Key(0), stoabs.Uint32Key(5), func(key stoabs.Key, load stoabs.ValueLoader) error {
+					keys = append(keys, key)
+					if key.(stoabs.Uint32Key) == 0 {
+						// only load some values
+						return nil
+					}
+					value, err := load()
+					values = append(values, value)
+					return err
+				}, stopAtNil)
+			}))
+			return keys, values
+		}
+
+		t.Run("skip over gaps", func(t *testing.T) {
+			keys, values := rangeLazy(t, false)
+
+			assert.Equal(t, []stoabs.Key{stoabs.Uint32Key(0), stoabs.Uint32Key(1), stoabs.Uint32Key(3)}, keys)
+			assert.Equal(t, [][]byte{{1}, {3}}, values)
+		})
+		t.Run("stop at gaps", func(t *testing.T) {
+			keys, values := rangeLazy(t, true)
+
+			assert.Equal(t, []stoabs.Key{stoabs.Uint32Key(0), stoabs.Uint32Key(1)}, keys)
+			assert.Equal(t, [][]byte{{1}}, values)
 		})
 	})
 }
@@ -356,6 +404,45 @@ func TestEmpty(t *testing.T, storeProvider StoreProvider) {
 	})
 }
 
+// TestOrderedIteration tests that Iterate visits keys in their byte order, so the store must iterate in order natively
+// or be configured with stoabs.WithOrderedIteration.
+func TestOrderedIteration(t *testing.T, storeProvider StoreProvider) {
+	ctx := context.Background()
+
+	t.Run("ordered Iterate()", func(t *testing.T) {
+		store := createStore(t, storeProvider)
+		// more keys than fit a single page of results, written in random order,
+		// and the numerical order of Uint32Keys differs from the order of their string representation (e.g. 9 and 10)
+		const numKeys = 2500
+		order := rand.New(rand.NewSource(1)).Perm(numKeys)
+		err := store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+			for _, i := range order {
+				if err := writer.Put(stoabs.Uint32Key(i), []byte{1}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		require.NoError(t, err)
+
+		var actual []uint32
+		err = store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+			return reader.Iterate(func(key stoabs.Key, _ []byte) error {
+				actual = append(actual, uint32(key.(stoabs.Uint32Key)))
+				return nil
+			}, stoabs.Uint32Key(0))
+		})
+
+		require.NoError(t, err)
+		require.Len(t, actual, numKeys)
+		for i, key := range actual {
+			if !assert.Equal(t, uint32(i), key, "key at position %d", i) {
+				return
+			}
+		}
+	})
+}
+
 func TestIterate(t *testing.T, storeProvider StoreProvider) {
 	ctx := context.Background()
 
@@ -475,12 +562,37 @@ func TestIterate(t *testing.T, storeProvider StoreProvider) {
 
 			// Write some data
 			_ = store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
-				return writer.Put(bytesKey, bytesValue)
+				for i := 0; i < 10; i++ {
+					if err := writer.Put(stoabs.Uint32Key(i), bytesValue); err != nil {
+						return err
+					}
+				}
+				return nil
 			})
 
 			// Cancel read context
 			ctx, cancel := context.WithCancel(ctx)
-			// TODO: move cancellation inside iterator. Currently returns before iterator is reached
+
+			calls := 0
+			err := store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+				return reader.Iterate(func(key stoabs.Key, value []byte) error {
+					// cancel within Iterate to make sure the context cancellation is caught during Iterate()
+					calls++
+					cancel()
+					return nil
+				}, stoabs.Uint32Key(0))
+			})
+
+			assert.ErrorIs(t, err, context.Canceled)
+			assert.ErrorIs(t, err, stoabs.ErrDatabase{})
+			assert.Equal(t, 1, calls, "cancellation should stop the scan")
+		})
+		t.Run("TX context cancelled before iterating", func(t *testing.T) {
+			store := createStore(t, storeProvider)
+			_ = store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+				return writer.Put(bytesKey, bytesValue)
+			})
+			ctx, cancel := context.WithCancel(ctx)
 			cancel()
 
 			err := store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
@@ -493,6 +605,46 @@ func TestIterate(t *testing.T, storeProvider StoreProvider) {
 			assert.ErrorIs(t, err, stoabs.ErrDatabase{})
 		})
 	})
+	t.Run("Seq()", func(t *testing.T) {
+		store := createStore(t, storeProvider)
+		_ = store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+			for i := 0; i < 5; i++ {
+				if err := writer.Put(stoabs.Uint32Key(i), bytesValue); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+
+		t.Run("iterates over all keys", func(t *testing.T) {
+			var entries []stoabs.KeyValue
+			err := store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+				seq := stoabs.Seq(reader)
+				entries = stoabs.Collect(seq.All(stoabs.Uint32Key(0)))
+				return seq.Err()
+			})
+
+			require.NoError(t, err)
+			require.Len(t, entries, 5)
+			assert.Equal(t, bytesValue, entries[0].Value)
+		})
+		t.Run("break stops the scan", func(t *testing.T) {
+			var keys []stoabs.Key
+			err := store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+				seq := stoabs.Seq(reader)
+				for key := range seq.Range(stoabs.Uint32Key(0), stoabs.Uint32Key(5)) {
+					keys = append(keys, key)
+					if len(keys) == 2 {
+						break
+					}
+				}
+				return seq.Err()
+			})
+
+			assert.NoError(t, err)
+			assert.Len(t, keys, 2)
+		})
+	})
 }
 
 func TestWriteTransactions(t *testing.T, storeProvider StoreProvider) {
@@ -504,6 +656,7 @@ func TestWriteTransactions(t *testing.T, storeProvider StoreProvider) {
 
 			var rollbackCalled = false
 			var afterCommitCalled = false
+			var supported = true
 			err := store.Write(ctx, func(tx stoabs.WriteTx) error {
 				switch dbTX := tx.Unwrap().(type) {
 				case *bbolt.Tx:
@@ -511,8 +664,8 @@ func TestWriteTransactions(t *testing.T, storeProvider StoreProvider) {
 				case *badger.Txn:
 					dbTX.Discard()
 				default:
-					// Not supported
-					t.SkipNow()
+					// Not supported, skip after the transaction finished (skipping here would leave it open)
+					supported = false
 				}
 				return errors.New("rollbacked")
 			}, stoabs.OnRollback(func() {
@@ -520,6 +673,9 @@ func TestWriteTransactions(t *testing.T, storeProvider StoreProvider) {
 			}), stoabs.AfterCommit(func() {
 				afterCommitCalled = true
 			}))
+			if !supported {
+				t.SkipNow()
+			}
 			assert.Error(t, err)
 			assert.True(t, rollbackCalled)
 			assert.False(t, afterCommitCalled)
@@ -711,6 +867,294 @@ func TestTransactionWriteLock(t *testing.T, storeProvider StoreProvider) {
 			assert.ErrorIs(t, err, context.DeadlineExceeded)
 			assert.ErrorIs(t, err, stoabs.ErrDatabase{})
 		})
+
+		// holdWriteLock starts a transaction with the given label that holds the write lock until the returned function is called.
+		holdWriteLock := func(store stoabs.KVStore, label string) func() {
+			locked := make(chan struct{})
+			release := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				_ = store.Write(ctx, func(tx stoabs.WriteTx) error {
+					close(locked)
+					<-release
+					return nil
+				}, stoabs.WithWriteLock(), stoabs.WithLabel(label))
+			}()
+			<-locked
+			return func() {
+				close(release)
+				<-done
+			}
+		}
+
+		t.Run("lock timeout identifies holder", func(t *testing.T) {
+			store := createStore(t, storeProvider)
+			release := holdWriteLock(store, "holder")
+			defer release()
+			ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+			defer cancel()
+
+			err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+				return nil
+			}, stoabs.WithWriteLock(), stoabs.WithLabel("waiter"))
+
+			var lockTimeout stoabs.ErrLockTimeout
+			require.ErrorAs(t, err, &lockTimeout)
+			assert.Equal(t, "holder", lockTimeout.Holder)
+			assert.NotEmpty(t, lockTimeout.Lock)
+			assert.ErrorIs(t, err, stoabs.ErrTimeout)
+			assert.ErrorIs(t, err, stoabs.ErrDatabase{})
+		})
+
+		t.Run("context cancelled while waiting for lock", func(t *testing.T) {
+			store := createStore(t, storeProvider)
+			release := holdWriteLock(store, "holder")
+			defer release()
+			ctx, cancel := context.WithCancel(ctx)
+			time.AfterFunc(50*time.Millisecond, cancel)
+			start := time.Now()
+
+			err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+				return nil
+			}, stoabs.WithWriteLock())
+
+			assert.ErrorIs(t, err, context.Canceled)
+			assert.NotErrorIs(t, err, stoabs.ErrLockTimeout{})
+			assert.Less(t, time.Since(start), time.Second)
+		})
+	})
+}
+
+// TestLockKeys tests stores that implement stoabs.Locker.
+func TestLockKeys(t *testing.T, storeProvider StoreProvider) {
+	ctx := context.Background()
+
+	t.Run("LockKeys()", func(t *testing.T) {
+		t.Run("lock, release, then lock again", func(t *testing.T) {
+			store := createStore(t, storeProvider)
+			release, err := stoabs.LockKeys(ctx, store, shelf, bytesKey, largerBytesKey)
+			require.NoError(t, err)
+			release()
+
+			release, err = stoabs.LockKeys(ctx, store, shelf, largerBytesKey)
+
+			assert.NoError(t, err)
+			release()
+		})
+		t.Run("disjoint keys don't block", func(t *testing.T) {
+			store := createStore(t, storeProvider)
+			release, err := stoabs.LockKeys(ctx, store, shelf, bytesKey)
+			require.NoError(t, err)
+			defer release()
+
+			other, err := stoabs.LockKeys(ctx, store, shelf, largerBytesKey)
+			assert.NoError(t, err)
+			other()
+			// same key, other shelf
+			other, err = stoabs.LockKeys(ctx, store, shelf+"2", bytesKey)
+			assert.NoError(t, err)
+			other()
+		})
+		t.Run("overlapping keys block", func(t *testing.T) {
+			store := createStore(t, storeProvider)
+			release, err := stoabs.LockKeys(ctx, store, shelf, bytesKey, largerBytesKey)
+			require.NoError(t, err)
+			defer release()
+			ctx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+			defer cancel()
+
+			_, err = stoabs.LockKeys(ctx, store, shelf, largerBytesKey)
+
+			assert.ErrorIs(t, err, stoabs.ErrDatabase{})
+		})
+		t.Run("concurrent read-modify-write", func(t *testing.T) {
+			store := createStore(t, storeProvider)
+			const numRoutines = 10
+			wg := sync.WaitGroup{}
+			for i := 0; i < numRoutines; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					release, err := stoabs.LockKeys(ctx, store, shelf, bytesKey)
+					if !assert.NoError(t, err) {
+						return
+					}
+					defer release()
+					// read and write in separate transactions
+					var count byte
+					_ = store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+						value, _ := reader.Get(bytesKey)
+						if len(value) > 0 {
+							count = value[0]
+						}
+						return nil
+					})
+					assert.NoError(t, store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+						return writer.Put(bytesKey, []byte{count + 1})
+					}))
+				}()
+			}
+			wg.Wait()
+
+			_ = store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+				value, err := reader.Get(bytesKey)
+				assert.NoError(t, err)
+				assert.Equal(t, []byte{numRoutines}, value)
+				return nil
+			})
+		})
+	})
+}
+
+// TestLeases tests the stoabs.Leaser implementation of the store.
+func TestLeases(t *testing.T, storeProvider StoreProvider) {
+	ctx := context.Background()
+
+	t.Run("AcquireLease()", func(t *testing.T) {
+		t.Run("held lease can't be acquired", func(t *testing.T) {
+			store := createStore(t, storeProvider)
+			lease, err := stoabs.AcquireLease(ctx, store, "resource", time.Minute)
+			require.NoError(t, err)
+			defer lease.Release(ctx)
+
+			_, err = stoabs.AcquireLease(ctx, store, "resource", time.Minute)
+			assert.ErrorIs(t, err, stoabs.ErrLeaseHeld)
+			other, err := stoabs.AcquireLease(ctx, store, "other", time.Minute)
+			require.NoError(t, err)
+			_ = other.Release(ctx)
+		})
+		t.Run("released lease can be acquired", func(t *testing.T) {
+			store := createStore(t, storeProvider)
+			lease, err := stoabs.AcquireLease(ctx, store, "resource", time.Minute)
+			require.NoError(t, err)
+			require.NoError(t, lease.Release(ctx))
+
+			lease, err = stoabs.AcquireLease(ctx, store, "resource", time.Minute)
+
+			require.NoError(t, err)
+			assert.Equal(t, "resource", lease.Name())
+			_ = lease.Release(ctx)
+		})
+		t.Run("keep alive extends the lease", func(t *testing.T) {
+			store := createStore(t, storeProvider)
+			lease, err := stoabs.AcquireLease(ctx, store, "resource", time.Minute)
+			require.NoError(t, err)
+			defer lease.Release(ctx)
+			expiresAt := lease.ExpiresAt()
+			time.Sleep(time.Millisecond)
+
+			err = lease.KeepAlive(ctx)
+
+			require.NoError(t, err)
+			assert.True(t, lease.ExpiresAt().After(expiresAt))
+		})
+		t.Run("keep alive after release", func(t *testing.T) {
+			store := createStore(t, storeProvider)
+			lease, err := stoabs.AcquireLease(ctx, store, "resource", time.Minute)
+			require.NoError(t, err)
+			require.NoError(t, lease.Release(ctx))
+
+			err = lease.KeepAlive(ctx)
+
+			assert.ErrorIs(t, err, stoabs.ErrLeaseLost)
+		})
+		t.Run("lease expires when not kept alive", func(t *testing.T) {
+			store := createStore(t, storeProvider)
+			lease, err := stoabs.AcquireLease(ctx, store, "resource", 50*time.Millisecond)
+			require.NoError(t, err)
+			expired := make(chan struct{})
+			lease.OnExpired(func() {
+				close(expired)
+			})
+
+			select {
+			case <-expired:
+			case <-time.After(5 * time.Second):
+				t.Fatal("lease did not expire")
+			}
+			assert.ErrorIs(t, lease.KeepAlive(ctx), stoabs.ErrLeaseLost)
+		})
+	})
+}
+
+func TestAggregate(t *testing.T, storeProvider StoreProvider) {
+	ctx := context.Background()
+
+	t.Run("Aggregate()", func(t *testing.T) {
+		store := createStore(t, storeProvider)
+		// sparse keys, to be sure missing keys aren't counted (more than a single Redis page)
+		err := store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+			for i := uint64(10); i < 2500; i += 2 {
+				if err := writer.Put(stoabs.Uint64Key(i), binary.BigEndian.AppendUint64(nil, i<<32+i)); err != nil {
+					return err
+				}
+			}
+			return writer.Put(stoabs.Uint64Key(3000), []byte("not a number"))
+		})
+		require.NoError(t, err)
+		aggregate := func(t *testing.T, from, to stoabs.Key, aggregator stoabs.Aggregator) error {
+			return store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+				return stoabs.Aggregate(reader, from, to, aggregator)
+			})
+		}
+
+		t.Run("count", func(t *testing.T) {
+			var count stoabs.Count
+
+			require.NoError(t, aggregate(t, stoabs.Uint64Key(0), stoabs.Uint64Key(2500), &count))
+
+			assert.Equal(t, 1245, count.N)
+		})
+		t.Run("sum", func(t *testing.T) {
+			var sum stoabs.SumUint64
+
+			require.NoError(t, aggregate(t, stoabs.Uint64Key(11), stoabs.Uint64Key(2500), &sum))
+
+			var expected uint64
+			for i := uint64(12); i < 2500; i += 2 {
+				expected += i<<32 + i
+			}
+			assert.Equal(t, expected, sum.Sum)
+		})
+		t.Run("sum of invalid value", func(t *testing.T) {
+			var sum stoabs.SumUint64
+
+			err := aggregate(t, stoabs.Uint64Key(2400), stoabs.Uint64Key(3001), &sum)
+
+			assert.EqualError(t, err, "value of key 3000 is not an uint64")
+		})
+		t.Run("min and max key", func(t *testing.T) {
+			var minKey stoabs.MinKey
+			var maxKey stoabs.MaxKey
+
+			require.NoError(t, aggregate(t, stoabs.Uint64Key(1), stoabs.Uint64Key(2000), &minKey))
+			require.NoError(t, aggregate(t, stoabs.Uint64Key(1), stoabs.Uint64Key(2000), &maxKey))
+
+			assert.Equal(t, stoabs.Uint64Key(10), minKey.Key)
+			assert.Equal(t, stoabs.Uint64Key(1998), maxKey.Key)
+		})
+		t.Run("empty range", func(t *testing.T) {
+			var count stoabs.Count
+			var minKey stoabs.MinKey
+
+			require.NoError(t, aggregate(t, stoabs.Uint64Key(5000), stoabs.Uint64Key(6000), &count))
+			require.NoError(t, aggregate(t, stoabs.Uint64Key(5000), stoabs.Uint64Key(6000), &minKey))
+
+			assert.Equal(t, 0, count.N)
+			assert.Nil(t, minKey.Key)
+		})
+		t.Run("custom aggregator", func(t *testing.T) {
+			var keys []stoabs.Key
+
+			err := aggregate(t, stoabs.Uint64Key(10), stoabs.Uint64Key(15), stoabs.AggregatorFn(func(key stoabs.Key, _ []byte) error {
+				keys = append(keys, key)
+				return nil
+			}))
+
+			require.NoError(t, err)
+			assert.Equal(t, []stoabs.Key{stoabs.Uint64Key(10), stoabs.Uint64Key(12), stoabs.Uint64Key(14)}, keys)
+		})
 	})
 }
 
@@ -748,6 +1192,58 @@ func TestDelete(t *testing.T, storeProvider StoreProvider) {
 			assert.NoError(t, err)
 		})
 	})
+	t.Run("DeleteWhere()", func(t *testing.T) {
+		deleteWhere := func(t *testing.T, predicate func(key stoabs.Key, value []byte) bool) (int, []stoabs.Key) {
+			store := createStore(t, storeProvider)
+			require.NoError(t, store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+				for i := 0; i < 10; i++ {
+					if err := writer.Put(stoabs.Uint32Key(i), []byte{byte(i)}); err != nil {
+						return err
+					}
+				}
+				return nil
+			}))
+			var deleted int
+			require.NoError(t, store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+				var err error
+				deleted, err = stoabs.DeleteWhere(writer, stoabs.Uint32Key(0), predicate)
+				return err
+			}))
+			var remaining []stoabs.Key
+			require.NoError(t, store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+				return reader.Range(stoabs.Uint32Key(0), stoabs.Uint32Key(10), func(key stoabs.Key, _ []byte) error {
+					remaining = append(remaining, key)
+					return nil
+				}, false)
+			}))
+			return deleted, remaining
+		}
+
+		t.Run("matching entries are deleted", func(t *testing.T) {
+			deleted, remaining := deleteWhere(t, func(_ stoabs.Key, value []byte) bool {
+				return value[0]%2 == 0
+			})
+
+			assert.Equal(t, 5, deleted)
+			assert.Equal(t, []stoabs.Key{stoabs.Uint32Key(1), stoabs.Uint32Key(3), stoabs.Uint32Key(5), stoabs.Uint32Key(7), stoabs.Uint32Key(9)}, remaining)
+		})
+		t.Run("consecutive entries", func(t *testing.T) {
+			deleted, remaining := deleteWhere(t, func(key stoabs.Key, _ []byte) bool {
+				return key.(stoabs.Uint32Key) < 8
+			})
+
+			assert.Equal(t, 8, deleted)
+			assert.Equal(t, []stoabs.Key{stoabs.Uint32Key(8), stoabs.Uint32Key(9)}, remaining)
+		})
+		t.Run("all entries", func(t *testing.T) {
+			deleted, remaining := deleteWhere(t, func(stoabs.Key, []byte) bool {
+				return true
+			})
+
+			assert.Equal(t, 10, deleted)
+			assert.Empty(t, remaining)
+		})
+	})
 }
 
 func TestClose(t *testing.T, storeProvider StoreProvider) {
@@ -764,9 +1260,129 @@ func TestClose(t *testing.T, storeProvider StoreProvider) {
 			err := store.Close(ctx)
 			assert.Equal(t, stoabs.DatabaseError(context.Canceled), err)
 		})
+		t.Run("waits for in-flight transactions", func(t *testing.T) {
+			store := createStore(t, storeProvider)
+			started := make(chan struct{})
+			release := make(chan struct{})
+			written := make(chan error, 1)
+			go func() {
+				written <- store.WriteShelf(context.Background(), shelf, func(writer stoabs.Writer) error {
+					close(started)
+					<-release
+					return writer.Put(bytesKey, bytesValue)
+				})
+			}()
+			<-started
+			closed := make(chan error, 1)
+			go func() {
+				closed <- store.Close(context.Background())
+			}()
+
+			// new transactions are rejected while closing
+			assert.Eventually(t, func() bool {
+				return errors.Is(readWithTimeout(store), stoabs.ErrStoreIsClosed)
+			}, 5*time.Second, 10*time.Millisecond)
+			assertState(t, store, stoabs.StateClosing)
+			select {
+			case <-closed:
+				t.Fatal("Close() returned before in-flight transaction finished")
+			default:
+			}
+
+			close(release)
+			assert.NoError(t, <-written)
+			assert.NoError(t, <-closed)
+			assertState(t, store, stoabs.StateClosed)
+		})
+		t.Run("aborts in-flight transactions when context is done", func(t *testing.T) {
+			store := createStore(t, storeProvider)
+			started := make(chan struct{})
+			release := make(chan struct{})
+			written := make(chan error, 1)
+			go func() {
+				written <- store.WriteShelf(context.Background(), shelf, func(writer stoabs.Writer) error {
+					close(started)
+					<-release
+					return writer.Put(bytesKey, bytesValue)
+				})
+			}()
+			<-started
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			err := store.Close(ctx)
+			close(release)
+
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+			assert.ErrorIs(t, <-written, stoabs.ErrCommitFailed)
+			if _, supported := store.(stoabs.StateReporter); supported {
+				assert.Eventually(t, func() bool {
+					return store.(stoabs.StateReporter).State() == stoabs.StateClosed
+				}, 5*time.Second, 10*time.Millisecond)
+			}
+		})
+		t.Run("concurrent transactions", func(t *testing.T) {
+			store := createStore(t, storeProvider)
+			const clients = 8
+			wg := sync.WaitGroup{}
+			errs := make(chan error, clients)
+			for i := 0; i < clients; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					for j := 0; ; j++ {
+						var err error
+						if j%2 == 0 {
+							err = store.WriteShelf(context.Background(), shelf, func(writer stoabs.Writer) error {
+								return writer.Put(stoabs.Uint32Key(uint32(i)), bytesValue)
+							})
+						} else {
+							err = store.ReadShelf(context.Background(), shelf, func(reader stoabs.Reader) error {
+								_, err := reader.Get(stoabs.Uint32Key(uint32(i)))
+								if errors.Is(err, stoabs.ErrKeyNotFound) {
+									return nil
+								}
+								return err
+							})
+						}
+						if err != nil {
+							errs <- err
+							return
+						}
+					}
+				}(i)
+			}
+			time.Sleep(20 * time.Millisecond)
+
+			assert.NoError(t, store.Close(context.Background()))
+			wg.Wait()
+			close(errs)
+
+			for err := range errs {
+				// transactions either finished before Close or were rejected
+				assert.ErrorIs(t, err, stoabs.ErrStoreIsClosed)
+			}
+			assertState(t, store, stoabs.StateClosed)
+		})
 	})
 }
 
+// readWithTimeout starts a read transaction that gives up quickly when it has to wait for a lock.
+func readWithTimeout(store stoabs.KVStore) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	return store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+		return nil
+	})
+}
+
+// assertState asserts the lifecycle state of the store, if it implements stoabs.StateReporter.
+func assertState(t *testing.T, store stoabs.KVStore, expected stoabs.State) {
+	if reporter, ok := store.(stoabs.StateReporter); ok {
+		assert.Equal(t, expected, reporter.State())
+	}
+}
+
 func TestStats(t *testing.T, storeProvider StoreProvider) {
 	t.Run("stats", func(t *testing.T) {
 		ctx := context.Background()
@@ -802,6 +1418,392 @@ func TestStats(t *testing.T, storeProvider StoreProvider) {
 	})
 }
 
+// TestShelfNames tests stoabs.ShelfLister. The store returned by the provider must implement it.
+func TestShelfNames(t *testing.T, storeProvider StoreProvider) {
+	ctx := context.Background()
+
+	t.Run("ShelfNames()", func(t *testing.T) {
+		t.Run("empty store", func(t *testing.T) {
+			store := createStore(t, storeProvider)
+
+			names, err := stoabs.ShelfNames(ctx, store)
+
+			assert.NoError(t, err)
+			assert.Empty(t, names)
+		})
+		t.Run("lists shelves sorted by name", func(t *testing.T) {
+			store := createStore(t, storeProvider)
+			err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+				for _, name := range []string{"b", "a", "c.d"} {
+					if err := tx.GetShelfWriter(name).Put(bytesKey, bytesValue); err != nil {
+						return err
+					}
+				}
+				return tx.GetShelfWriter("b").Put(largerBytesKey, largerBytesValue)
+			})
+			require.NoError(t, err)
+
+			names, err := stoabs.ShelfNames(ctx, store)
+
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"a", "b", "c.d"}, names)
+		})
+		t.Run("reserved shelves", func(t *testing.T) {
+			store := createStore(t, storeProvider)
+			err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+				for _, name := range []string{"a", stoabs.ReservedShelfPrefix + "internal"} {
+					if err := tx.GetShelfWriter(name).Put(bytesKey, bytesValue); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			require.NoError(t, err)
+
+			t.Run("hidden by default", func(t *testing.T) {
+				names, err := stoabs.ShelfNames(ctx, store)
+
+				assert.NoError(t, err)
+				assert.Equal(t, []string{"a"}, names)
+			})
+			t.Run("listed when included", func(t *testing.T) {
+				names, err := stoabs.ShelfNames(stoabs.ContextWithReservedShelves(ctx), store)
+
+				// wrappers may list reserved shelves of their own
+				assert.NoError(t, err)
+				assert.Contains(t, names, stoabs.ReservedShelfPrefix+"internal")
+				assert.Contains(t, names, "a")
+				assert.IsNonDecreasing(t, names)
+			})
+		})
+		t.Run("closed store", func(t *testing.T) {
+			store := createStore(t, storeProvider)
+			_ = store.Close(ctx)
+
+			_, err := stoabs.ShelfNames(ctx, store)
+
+			assert.ErrorIs(t, err, stoabs.ErrStoreIsClosed)
+		})
+	})
+}
+
+// TestByteTransparency tests that values are stored and returned exactly as written, regardless of their contents,
+// which the codecs (see stoabs.CodecShelf) and other layers on top of the store rely on.
+func TestByteTransparency(t *testing.T, storeProvider StoreProvider) {
+	ctx := context.Background()
+
+	t.Run("byte transparency", func(t *testing.T) {
+		allBytes := make([]byte, 256)
+		for i := range allBytes {
+			allBytes[i] = byte(i)
+		}
+		values := map[string][]byte{
+			"all bytes":   allBytes,
+			"zero bytes":  make([]byte, 16),
+			"single byte": {0},
+			"large":       bytes.Repeat(allBytes, 256),
+		}
+		for name, value := range values {
+			t.Run(name, func(t *testing.T) {
+				store := createStore(t, storeProvider)
+				err := store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+					return writer.Put(bytesKey, value)
+				})
+				require.NoError(t, err)
+
+				var actual []byte
+				err = store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+					actual, err = reader.Get(bytesKey)
+					return err
+				})
+
+				require.NoError(t, err)
+				assert.True(t, bytes.Equal(value, actual), "value differs")
+			})
+		}
+		t.Run("codecs", func(t *testing.T) {
+			type document struct {
+				Text  string
+				Data  []byte
+				Count int
+			}
+			expected := document{Text: "Hello, \u4e16\u754c\x00", Data: allBytes, Count: -1}
+			store := createStore(t, storeProvider)
+			err := store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+				if err := stoabs.JSONShelf[document](writer).Put(bytesKey, expected); err != nil {
+					return err
+				}
+				return stoabs.CBORShelf[document](writer).Put(largerBytesKey, expected)
+			})
+			require.NoError(t, err)
+
+			err = store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+				fromJSON, err := stoabs.JSONShelf[document](reader).Get(bytesKey)
+				if err != nil {
+					return err
+				}
+				assert.Equal(t, expected, fromJSON)
+				fromCBOR, err := stoabs.CBORShelf[document](reader).Get(largerBytesKey)
+				if err != nil {
+					return err
+				}
+				assert.Equal(t, expected, fromCBOR)
+				return nil
+			})
+
+			require.NoError(t, err)
+		})
+	})
+}
+
+// PrefixedStoreProvider returns a store for each given key prefix (see stoabs.WithKeyPrefix), all sharing the same database.
+type PrefixedStoreProvider func(t *testing.T, prefixes ...string) ([]stoabs.KVStore, error)
+
+// TestKeyPrefix tests that stores with different key prefixes sharing a database don't see each other's shelves.
+// If listShelves is true, the stores must implement stoabs.ShelfLister.
+func TestKeyPrefix(t *testing.T, storeProvider PrefixedStoreProvider, listShelves bool) {
+	ctx := context.Background()
+
+	t.Run("key prefix", func(t *testing.T) {
+		stores, err := storeProvider(t, "app1/", "app2/", "")
+		require.NoError(t, err)
+		app1, app2, unprefixed := stores[0], stores[1], stores[2]
+		write := func(store stoabs.KVStore, value string) {
+			require.NoError(t, store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+				return writer.Put(stoabs.BytesKey(stringKey), []byte(value))
+			}))
+		}
+		read := func(store stoabs.KVStore, shelfName string) ([]byte, error) {
+			var result []byte
+			err := store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+				var err error
+				result, err = reader.Get(stoabs.BytesKey(stringKey))
+				return err
+			})
+			return result, err
+		}
+		write(app1, "app1")
+		write(app2, "app2")
+
+		t.Run("shelves are isolated", func(t *testing.T) {
+			value, err := read(app1, shelf)
+			require.NoError(t, err)
+			assert.Equal(t, "app1", string(value))
+			value, err = read(app2, shelf)
+			require.NoError(t, err)
+			assert.Equal(t, "app2", string(value))
+			_, err = read(unprefixed, shelf)
+			assert.ErrorIs(t, err, stoabs.ErrKeyNotFound)
+		})
+		t.Run("prefix is part of the shelf name", func(t *testing.T) {
+			value, err := read(unprefixed, "app1/"+shelf)
+
+			require.NoError(t, err)
+			assert.Equal(t, "app1", string(value))
+		})
+		if !listShelves {
+			return
+		}
+		t.Run("ShelfNames() only lists shelves with the prefix", func(t *testing.T) {
+			names, err := stoabs.ShelfNames(ctx, app1)
+			require.NoError(t, err)
+			assert.Equal(t, []string{shelf}, names)
+
+			names, err = stoabs.ShelfNames(ctx, unprefixed)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"app1/" + shelf, "app2/" + shelf}, names)
+		})
+	})
+}
+
+// ConfiguredStoreProvider returns a store created with the given options.
+type ConfiguredStoreProvider func(t *testing.T, opts ...stoabs.Option) (stoabs.KVStore, error)
+
+// TestValidator tests that validators registered with stoabs.WithValidator reject invalid values.
+func TestValidator(t *testing.T, storeProvider ConfiguredStoreProvider) {
+	ctx := context.Background()
+
+	t.Run("validator", func(t *testing.T) {
+		notEmpty := func(key stoabs.Key, value []byte) error {
+			if len(value) == 0 {
+				return errors.New("value is empty")
+			}
+			return nil
+		}
+		store, err := storeProvider(t, stoabs.WithValidator(shelf, notEmpty))
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = store.Close(context.Background())
+		})
+
+		t.Run("valid value is written", func(t *testing.T) {
+			err := store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+				return writer.Put(stoabs.BytesKey(stringKey), bytesValue)
+			})
+
+			require.NoError(t, err)
+		})
+		t.Run("invalid value is rejected and transaction rolled back", func(t *testing.T) {
+			err := store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+				if err := writer.Put(stoabs.BytesKey(stringKey), []byte("other")); err != nil {
+					return err
+				}
+				return writer.Put(stoabs.BytesKey(stringKey), []byte{})
+			})
+
+			assert.ErrorIs(t, err, stoabs.ErrInvalidValue{})
+			assert.ErrorContains(t, err, "value is empty")
+			_ = store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+				value, err := reader.Get(stoabs.BytesKey(stringKey))
+				assert.NoError(t, err)
+				assert.Equal(t, bytesValue, value)
+				return nil
+			})
+		})
+		t.Run("other shelves are not validated", func(t *testing.T) {
+			err := store.WriteShelf(ctx, "other", func(writer stoabs.Writer) error {
+				return writer.Put(stoabs.BytesKey(stringKey), []byte{})
+			})
+
+			assert.NoError(t, err)
+		})
+	})
+}
+
+// TestWriteOnce tests stoabs.WithWriteOnce.
+func TestWriteOnce(t *testing.T, storeProvider ConfiguredStoreProvider) {
+	ctx := context.Background()
+
+	t.Run("write-once", func(t *testing.T) {
+		store, err := storeProvider(t, stoabs.WithWriteOnce(shelf))
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = store.Close(context.Background())
+		})
+		require.NoError(t, store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+			return writer.Put(bytesKey, bytesValue)
+		}))
+		assertUnchanged := func(t *testing.T) {
+			_ = store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+				value, err := reader.Get(bytesKey)
+				assert.NoError(t, err)
+				assert.Equal(t, bytesValue, value)
+				return nil
+			})
+		}
+
+		t.Run("new key is written", func(t *testing.T) {
+			err := store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+				return writer.Put(largerBytesKey, largerBytesValue)
+			})
+
+			require.NoError(t, err)
+		})
+		t.Run("overwriting fails and rolls back the transaction", func(t *testing.T) {
+			err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+				if err := tx.GetShelfWriter("other").Put(bytesKey, bytesValue); err != nil {
+					return err
+				}
+				return tx.GetShelfWriter(shelf).Put(bytesKey, largerBytesValue)
+			})
+
+			assert.ErrorIs(t, err, stoabs.ErrImmutable{})
+			assertUnchanged(t)
+			_ = store.ReadShelf(ctx, "other", func(reader stoabs.Reader) error {
+				_, err := reader.Get(bytesKey)
+				assert.ErrorIs(t, err, stoabs.ErrKeyNotFound)
+				return nil
+			})
+		})
+		t.Run("overwriting with the same value fails", func(t *testing.T) {
+			err := store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+				return writer.Put(bytesKey, bytesValue)
+			})
+
+			assert.ErrorIs(t, err, stoabs.ErrImmutable{})
+		})
+		t.Run("deleting fails", func(t *testing.T) {
+			err := store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+				return writer.Delete(bytesKey)
+			})
+
+			assert.ErrorIs(t, err, stoabs.ErrImmutable{})
+			assertUnchanged(t)
+		})
+		t.Run("deleting matching entries fails", func(t *testing.T) {
+			err := store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+				_, err := stoabs.DeleteWhere(writer, stoabs.BytesKey{}, func(stoabs.Key, []byte) bool {
+					return true
+				})
+				return err
+			})
+
+			assert.ErrorIs(t, err, stoabs.ErrImmutable{})
+			assertUnchanged(t)
+		})
+		t.Run("other shelves are mutable", func(t *testing.T) {
+			err := store.WriteShelf(ctx, "other", func(writer stoabs.Writer) error {
+				if err := writer.Put(bytesKey, bytesValue); err != nil {
+					return err
+				}
+				return writer.Delete(bytesKey)
+			})
+
+			assert.NoError(t, err)
+		})
+		t.Run("writing a key twice in a transaction fails", func(t *testing.T) {
+			key := stoabs.BytesKey("twice")
+			err := store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+				if err := writer.Put(key, bytesValue); err != nil {
+					return err
+				}
+				return writer.Put(key, largerBytesValue)
+			})
+
+			assert.ErrorIs(t, err, stoabs.ErrImmutable{})
+			_ = store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+				_, err := reader.Get(key)
+				assert.ErrorIs(t, err, stoabs.ErrKeyNotFound)
+				return nil
+			})
+		})
+		t.Run("concurrent transactions writing a key", func(t *testing.T) {
+			const numTransactions = 10
+			key := stoabs.BytesKey("concurrent")
+			errs := make([]error, numTransactions)
+			wg := sync.WaitGroup{}
+			for i := 0; i < numTransactions; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					errs[i] = store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+						return writer.Put(key, []byte{byte(i)})
+					})
+				}(i)
+			}
+			wg.Wait()
+
+			// exactly one transaction writes the key, the others fail
+			written := -1
+			for i, err := range errs {
+				if err == nil {
+					assert.Equal(t, -1, written, "key written by multiple transactions")
+					written = i
+				} else {
+					assert.ErrorIs(t, err, stoabs.ErrImmutable{})
+				}
+			}
+			require.NotEqual(t, -1, written)
+			_ = store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+				value, err := reader.Get(key)
+				assert.NoError(t, err)
+				assert.Equal(t, []byte{byte(written)}, value)
+				return nil
+			})
+		})
+	})
+}
+
 func createStore(t *testing.T, provider StoreProvider) stoabs.KVStore {
 	store, err := provider(t)
 	if !assert.NoError(t, err) {
@@ -814,3 +1816,69 @@ func createStore(t *testing.T, provider StoreProvider) stoabs.KVStore {
 }
 
 // TODO: Write in other shelf with same key name, make sure they don't overwrite
+
+// FuzzRange fuzzes Range() boundaries with consecutive BytesKeys starting at a fuzzed key (e.g. carrying over 0xFF bytes,
+// or starting with an empty key), with a gap at a fuzzed position. A new store is created for every input.
+func FuzzRange(f *testing.F, storeProvider StoreProvider) {
+	ctx := context.Background()
+	f.Add([]byte{1, 2, 3}, uint8(4), uint8(2))
+	f.Add([]byte{1, 0xFE}, uint8(4), uint8(1))
+	f.Add([]byte{0, 0xFF}, uint8(3), uint8(0))
+	f.Add([]byte{0xFF, 0xFF}, uint8(3), uint8(1))
+	f.Add([]byte{}, uint8(5), uint8(3))
+	f.Fuzz(func(t *testing.T, from []byte, count uint8, gap uint8) {
+		if len(from) > 64 {
+			// keep keys small, since it doesn't make a difference for the boundaries
+			from = from[:64]
+		}
+		// generate 2 to 17 keys, the gap is never the first key
+		keys := []stoabs.Key{stoabs.BytesKey(from)}
+		for i := 0; i < int(count%16)+1; i++ {
+			keys = append(keys, keys[i].Next())
+		}
+		gapIndex := 1 + int(gap)%(len(keys)-1)
+		// the empty key can't be stored by all backends, so it's only used as start of the range
+		firstIndex := 0
+		if len(from) == 0 {
+			firstIndex = 1
+		}
+		var written []stoabs.Key
+		for i := firstIndex; i < len(keys)-1; i++ {
+			if i != gapIndex {
+				written = append(written, keys[i])
+			}
+		}
+		store := createStore(t, storeProvider)
+		require.NoError(t, store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+			for _, key := range written {
+				if err := writer.Put(key, key.Bytes()); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+		rangeKeys := func(stopAtNil bool) []stoabs.Key {
+			var result []stoabs.Key
+			require.NoError(t, store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+				return reader.Range(keys[0], keys[len(keys)-1], func(key stoabs.Key, value []byte) error {
+					assert.Equal(t, key.Bytes(), value)
+					result = append(result, key)
+					return nil
+				}, stopAtNil)
+			}))
+			return result
+		}
+
+		assert.Equal(t, written, rangeKeys(false), "skip over gaps")
+		var beforeGap []stoabs.Key
+		for _, key := range written {
+			if bytes.Compare(key.Bytes(), keys[gapIndex].Bytes()) > 0 {
+				break
+			}
+			beforeGap = append(beforeGap, key)
+		}
+		if firstIndex == 0 {
+			assert.Equal(t, beforeGap, rangeKeys(true), "stop at gaps")
+		}
+	})
+}
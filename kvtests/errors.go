@@ -0,0 +1,201 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package kvtests
+
+import (
+	"context"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// TestErrors tests that the store returns the errors of the stoabs error taxonomy in the right situations:
+// stoabs.ErrKeyNotFound, stoabs.ErrStoreIsClosed, stoabs.ErrCommitFailed, stoabs.ErrConflict and stoabs.ErrTimeout.
+func TestErrors(t *testing.T, storeProvider StoreProvider) {
+	ctx := context.Background()
+
+	t.Run("errors", func(t *testing.T) {
+		t.Run("ErrKeyNotFound", func(t *testing.T) {
+			store := createStore(t, storeProvider)
+			require.NoError(t, store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+				return writer.Put(bytesKey, bytesValue)
+			}))
+
+			err := store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+				_, err := reader.Get(largerBytesKey)
+				return err
+			})
+
+			assert.ErrorIs(t, err, stoabs.ErrKeyNotFound)
+			assert.NotErrorIs(t, err, stoabs.ErrDatabase{})
+		})
+		t.Run("ErrKeyNotFound for non-existing shelf", func(t *testing.T) {
+			store := createStore(t, storeProvider)
+
+			err := store.ReadShelf(ctx, "non-existing", func(reader stoabs.Reader) error {
+				_, err := reader.Get(bytesKey)
+				return err
+			})
+
+			assert.ErrorIs(t, err, stoabs.ErrKeyNotFound)
+		})
+		t.Run("ErrInvalidShelfName", func(t *testing.T) {
+			store := createStore(t, storeProvider)
+
+			t.Run("WriteShelf", func(t *testing.T) {
+				err := store.WriteShelf(ctx, "", func(writer stoabs.Writer) error {
+					return writer.Put(bytesKey, bytesValue)
+				})
+				assert.ErrorIs(t, err, stoabs.ErrInvalidShelfName{})
+			})
+			t.Run("ReadShelf", func(t *testing.T) {
+				err := store.ReadShelf(ctx, "invalid\x00", func(reader stoabs.Reader) error {
+					_, err := reader.Get(bytesKey)
+					return err
+				})
+				assert.ErrorIs(t, err, stoabs.ErrInvalidShelfName{})
+			})
+		})
+		t.Run("ErrStoreIsClosed", func(t *testing.T) {
+			store := createStore(t, storeProvider)
+			require.NoError(t, store.Close(ctx))
+
+			t.Run("Write", func(t *testing.T) {
+				err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+					return tx.GetShelfWriter(shelf).Put(bytesKey, bytesValue)
+				})
+				assert.ErrorIs(t, err, stoabs.ErrStoreIsClosed)
+			})
+			t.Run("WriteShelf", func(t *testing.T) {
+				err := store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+					return writer.Put(bytesKey, bytesValue)
+				})
+				assert.ErrorIs(t, err, stoabs.ErrStoreIsClosed)
+			})
+			t.Run("Read", func(t *testing.T) {
+				err := store.Read(ctx, func(tx stoabs.ReadTx) error {
+					_, err := tx.GetShelfReader(shelf).Get(bytesKey)
+					return err
+				})
+				assert.ErrorIs(t, err, stoabs.ErrStoreIsClosed)
+			})
+			t.Run("ReadShelf", func(t *testing.T) {
+				err := store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+					_, err := reader.Get(bytesKey)
+					return err
+				})
+				assert.ErrorIs(t, err, stoabs.ErrStoreIsClosed)
+			})
+		})
+		t.Run("ErrCommitFailed when cancelled before commit", func(t *testing.T) {
+			store := createStore(t, storeProvider)
+			ctx, cancel := context.WithCancel(ctx)
+
+			err := store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+				err := writer.Put(bytesKey, bytesValue)
+				cancel()
+				return err
+			})
+
+			assert.ErrorIs(t, err, stoabs.ErrCommitFailed)
+			assert.ErrorIs(t, err, context.Canceled)
+			assert.NotErrorIs(t, err, stoabs.ErrTimeout)
+		})
+		t.Run("ErrTimeout when deadline passes before commit", func(t *testing.T) {
+			store := createStore(t, storeProvider)
+			ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+			defer cancel()
+
+			err := store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+				err := writer.Put(bytesKey, bytesValue)
+				<-ctx.Done()
+				return err
+			})
+
+			assert.ErrorIs(t, err, stoabs.ErrTimeout)
+			assert.ErrorIs(t, err, stoabs.ErrCommitFailed)
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+		})
+		t.Run("ErrTimeout when waiting for write lock", func(t *testing.T) {
+			store := createStore(t, storeProvider)
+			locked := make(chan struct{})
+			release := make(chan struct{})
+			go func() {
+				_ = store.Write(ctx, func(tx stoabs.WriteTx) error {
+					close(locked)
+					<-release
+					return nil
+				}, stoabs.WithWriteLock())
+			}()
+			<-locked
+			defer close(release)
+			ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+			defer cancel()
+
+			err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+				return nil
+			}, stoabs.WithWriteLock())
+
+			assert.ErrorIs(t, err, stoabs.ErrTimeout)
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+		})
+		t.Run("ErrConflict for conflicting commits", func(t *testing.T) {
+			store := createStore(t, storeProvider)
+			require.NoError(t, store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+				return writer.Put(bytesKey, bytesValue)
+			}))
+			read := make(chan struct{})
+			written := make(chan error, 1)
+			go func() {
+				<-read
+				written <- store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+					return writer.Put(bytesKey, []byte("second"))
+				})
+			}()
+
+			// read-modify-write transaction, of which the read value is modified by another transaction in the meantime
+			err := store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+				if _, err := writer.Get(bytesKey); err != nil {
+					return err
+				}
+				close(read)
+				select {
+				case <-written:
+				case <-time.After(100 * time.Millisecond):
+					// store serializes write transactions, so the other one can't commit before this one
+				}
+				return writer.Put(bytesKey, []byte("first"))
+			})
+
+			// the store either serializes the transactions or detects the conflict, in which case it must return ErrConflict
+			// identifying the key that was modified concurrently
+			if err != nil {
+				assert.ErrorIs(t, err, stoabs.ErrConflict)
+				assert.ErrorIs(t, err, stoabs.ErrCommitFailed)
+				var conflict *stoabs.ConflictError
+				require.ErrorAs(t, err, &conflict)
+				assert.Equal(t, shelf, conflict.Shelf)
+				assert.Equal(t, bytesKey, conflict.Key)
+				assert.Equal(t, 1, conflict.Attempts)
+			}
+		})
+	})
+}
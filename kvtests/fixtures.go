@@ -0,0 +1,144 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package kvtests
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// UpdateGoldenEnv is the environment variable that makes AssertGolden (over)write the golden files instead of comparing them,
+// e.g. STOABS_UPDATE_GOLDEN=1 go test ./...
+const UpdateGoldenEnv = "STOABS_UPDATE_GOLDEN"
+
+// hexPrefix marks keys and values in a Fixture that are hex encoded, for binary data that isn't valid UTF-8.
+const hexPrefix = "hex:"
+
+// Fixture describes the contents of shelves: it maps the shelf name to the entries of the shelf.
+// Keys and values are strings of which the bytes are the stoabs.BytesKey and value.
+// Binary keys and values (that aren't valid UTF-8) are hex encoded and prefixed with "hex:", e.g. "hex:0102ff".
+// This way fixtures can be written as Go literals or JSON files (see LoadFixture) and compared as golden files (see AssertGolden).
+type Fixture map[string]map[string]string
+
+// Seed writes the entries of the fixture to the store in a single transaction.
+func Seed(t testing.TB, store stoabs.KVStore, fixture Fixture) {
+	t.Helper()
+	err := store.Write(context.Background(), func(tx stoabs.WriteTx) error {
+		for shelfName, entries := range fixture {
+			writer := tx.GetShelfWriter(shelfName)
+			for key, value := range entries {
+				k, err := decodeFixtureString(key)
+				if err != nil {
+					return fmt.Errorf("invalid key %q of shelf %s: %w", key, shelfName, err)
+				}
+				v, err := decodeFixtureString(value)
+				if err != nil {
+					return fmt.Errorf("invalid value of key %q of shelf %s: %w", key, shelfName, err)
+				}
+				if err := writer.Put(stoabs.BytesKey(k), v); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err, "unable to seed store")
+}
+
+// LoadFixture reads a Fixture from a JSON file, e.g. {"users": {"alice": "{\"admin\": true}"}}.
+func LoadFixture(t testing.TB, path string) Fixture {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var fixture Fixture
+	require.NoError(t, json.Unmarshal(data, &fixture), "invalid fixture %s", path)
+	return fixture
+}
+
+// Snapshot reads the contents of the given shelves into a Fixture. If no shelves are given, all shelves are read
+// (which requires the store to implement stoabs.ShelfLister), except the reserved shelves (see stoabs.ReservedShelfPrefix).
+// Empty shelves are omitted, so the result doesn't depend on whether a backend keeps empty shelves.
+func Snapshot(t testing.TB, store stoabs.KVStore, shelves ...string) Fixture {
+	t.Helper()
+	ctx := context.Background()
+	if len(shelves) == 0 {
+		var err error
+		shelves, err = stoabs.ShelfNames(ctx, store)
+		require.NoError(t, err, "unable to list shelves")
+	}
+	result := Fixture{}
+	err := store.Read(ctx, func(tx stoabs.ReadTx) error {
+		for _, shelfName := range shelves {
+			err := tx.GetShelfReader(shelfName).Iterate(func(key stoabs.Key, value []byte) error {
+				if result[shelfName] == nil {
+					result[shelfName] = map[string]string{}
+				}
+				result[shelfName][encodeFixtureString(key.Bytes())] = encodeFixtureString(value)
+				return nil
+			}, stoabs.BytesKey{})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err, "unable to read store")
+	return result
+}
+
+// AssertGolden asserts the contents of the given shelves (see Snapshot) equal the golden file at path.
+// If the UpdateGoldenEnv environment variable is set, the golden file is written instead.
+// Golden files are deterministic (entries are sorted), so they can be committed and reviewed.
+func AssertGolden(t testing.TB, store stoabs.KVStore, path string, shelves ...string) bool {
+	t.Helper()
+	actual := Snapshot(t, store, shelves...)
+	if os.Getenv(UpdateGoldenEnv) != "" {
+		// json.Marshal sorts map keys
+		data, err := json.MarshalIndent(actual, "", "  ")
+		require.NoError(t, err)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, append(data, '\n'), 0644))
+		return true
+	}
+	return assert.Equal(t, LoadFixture(t, path), actual, "store contents don't match golden file %s (set %s=1 to update)", path, UpdateGoldenEnv)
+}
+
+func encodeFixtureString(data []byte) string {
+	if !utf8.Valid(data) || strings.HasPrefix(string(data), hexPrefix) {
+		return hexPrefix + hex.EncodeToString(data)
+	}
+	return string(data)
+}
+
+func decodeFixtureString(s string) ([]byte, error) {
+	if strings.HasPrefix(s, hexPrefix) {
+		return hex.DecodeString(s[len(hexPrefix):])
+	}
+	return []byte(s), nil
+}
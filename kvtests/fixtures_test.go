@@ -0,0 +1,86 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package kvtests
+
+import (
+	"context"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/mocks"
+	"github.com/nuts-foundation/go-stoabs/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+)
+
+func TestSeed(t *testing.T) {
+	store := mocks.NewFake()
+
+	Seed(t, store, LoadFixture(t, "testdata/fixture.json"))
+
+	err := store.Read(context.Background(), func(tx stoabs.ReadTx) error {
+		value, err := tx.GetShelfReader("accounts").Get(stoabs.BytesKey("alice"))
+		require.NoError(t, err)
+		assert.Equal(t, `{"admin":true}`, string(value))
+		value, err = tx.GetShelfReader("blobs").Get(stoabs.BytesKey{1, 2, 0xff})
+		require.NoError(t, err)
+		assert.Equal(t, []byte{0, 0xff}, value)
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestSnapshot(t *testing.T) {
+	store := mocks.NewFake()
+	fixture := Fixture{
+		"accounts":         {"alice": "admin"},
+		"blobs":            {"hex:0102ff": "hex:00ff", "hex:6865783a": "looks like hex"},
+		"_stoabs/internal": {"key": "value"},
+	}
+	Seed(t, store, fixture)
+
+	t.Run("all shelves", func(t *testing.T) {
+		actual := Snapshot(t, store)
+
+		delete(fixture, "_stoabs/internal")
+		assert.Equal(t, fixture, actual)
+	})
+	t.Run("given shelves", func(t *testing.T) {
+		actual := Snapshot(t, store, "accounts", "empty")
+
+		assert.Equal(t, Fixture{"accounts": {"alice": "admin"}}, actual)
+	})
+}
+
+func TestAssertGolden(t *testing.T) {
+	store := mocks.NewFake()
+	Seed(t, store, LoadFixture(t, "testdata/fixture.json"))
+
+	t.Run("matches", func(t *testing.T) {
+		AssertGolden(t, store, "testdata/fixture.json")
+	})
+	t.Run("update", func(t *testing.T) {
+		t.Setenv(UpdateGoldenEnv, "1")
+		path := filepath.Join(util.TestDirectory(t), "golden.json")
+
+		AssertGolden(t, store, path)
+
+		assert.Equal(t, LoadFixture(t, "testdata/fixture.json"), LoadFixture(t, path))
+	})
+}
@@ -0,0 +1,189 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package kvtests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// operation is an operation on a single register (key) in a recorded history.
+type operation struct {
+	client int
+	// write is true for a read-modify-write transaction, false for a read transaction.
+	write bool
+	// invoke and complete are logical timestamps of when the operation started and returned.
+	invoke   int64
+	complete int64
+	// read is the value that was read, empty if the key didn't exist.
+	read string
+	// written is the (unique) value written by a write operation.
+	written string
+	// ok is true if the transaction was committed (or the read succeeded).
+	ok bool
+}
+
+// TestLinearizability runs concurrent read transactions and read-modify-write transactions (using stoabs.WithWriteLock)
+// on a single key, records the history of operations and checks it's linearizable.
+// Write transactions that fail (e.g. because of commit conflicts) are considered not to have taken effect.
+func TestLinearizability(t *testing.T, storeProvider StoreProvider) {
+	ctx := context.Background()
+
+	t.Run("linearizability", func(t *testing.T) {
+		const clients = 8
+		const operationsPerClient = 25
+		store := createStore(t, storeProvider)
+		var clock atomic.Int64
+		histories := make([][]operation, clients)
+		wg := sync.WaitGroup{}
+		for c := 0; c < clients; c++ {
+			wg.Add(1)
+			go func(client int) {
+				defer wg.Done()
+				random := rand.New(rand.NewSource(int64(client)))
+				for i := 0; i < operationsPerClient; i++ {
+					op := operation{client: client, write: random.Intn(2) == 0}
+					op.invoke = clock.Add(1)
+					var err error
+					if op.write {
+						op.written = fmt.Sprintf("%d-%d", client, i)
+						err = store.Write(ctx, func(tx stoabs.WriteTx) error {
+							writer := tx.GetShelfWriter(shelf)
+							var err error
+							op.read, err = readRegister(writer)
+							if err != nil {
+								return err
+							}
+							return writer.Put(bytesKey, []byte(op.written))
+						}, stoabs.WithWriteLock())
+					} else {
+						err = store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+							var err error
+							op.read, err = readRegister(reader)
+							return err
+						})
+					}
+					op.complete = clock.Add(1)
+					op.ok = err == nil
+					histories[client] = append(histories[client], op)
+				}
+			}(c)
+		}
+		wg.Wait()
+
+		var history []operation
+		committed := 0
+		for _, ops := range histories {
+			history = append(history, ops...)
+			for _, op := range ops {
+				if op.write && op.ok {
+					committed++
+				}
+			}
+		}
+		require.Greater(t, committed, 0, "no write transaction was committed")
+		assert.NoError(t, checkLinearizable(history))
+	})
+}
+
+func readRegister(reader stoabs.Reader) (string, error) {
+	value, err := reader.Get(bytesKey)
+	if errors.Is(err, stoabs.ErrKeyNotFound) {
+		return "", nil
+	}
+	return string(value), err
+}
+
+// checkLinearizable checks whether the history of operations on a register is linearizable.
+// Since every write operation reads the value it overwrites and writes a unique value, the committed writes must form
+// a single chain starting at the initial (empty) value; two writes overwriting the same value indicate a lost update.
+// The chain must respect real-time order (a write that completed before another was invoked comes first), and every read
+// must return a value that is no older than the last write completed before the read was invoked, and that wasn't
+// written by a write invoked after the read completed.
+func checkLinearizable(history []operation) error {
+	// successor maps a value to the committed write overwriting it
+	successor := map[string]operation{}
+	failed := map[string]operation{}
+	committed := 0
+	for _, op := range history {
+		if !op.write {
+			continue
+		}
+		if !op.ok {
+			failed[op.written] = op
+			continue
+		}
+		if other, exists := successor[op.read]; exists {
+			return fmt.Errorf("lost update: writes %s and %s both overwrote %q", other.written, op.written, op.read)
+		}
+		successor[op.read] = op
+		committed++
+	}
+	// position is the position of a value in the chain of committed writes, 0 for the initial value
+	position := map[string]int{"": 0}
+	var chain []operation
+	for value := ""; ; {
+		next, exists := successor[value]
+		if !exists {
+			break
+		}
+		chain = append(chain, next)
+		position[next.written] = len(chain)
+		value = next.written
+	}
+	if len(chain) != committed {
+		return fmt.Errorf("%d of %d committed writes don't form a chain from the initial value", committed-len(chain), committed)
+	}
+	for i, a := range chain {
+		for _, b := range chain[:i] {
+			if a.complete < b.invoke {
+				return fmt.Errorf("real-time order violated: write %s completed before %s was invoked, but was applied after it", a.written, b.written)
+			}
+		}
+	}
+	for _, op := range history {
+		if op.write || !op.ok {
+			continue
+		}
+		read, exists := position[op.read]
+		if !exists {
+			if _, isFailed := failed[op.read]; isFailed {
+				return fmt.Errorf("read by client %d returned %q of a write that wasn't committed", op.client, op.read)
+			}
+			return fmt.Errorf("read by client %d returned unknown value %q", op.client, op.read)
+		}
+		for pos, write := range chain {
+			if write.complete < op.invoke && pos+1 > read {
+				return fmt.Errorf("stale read: client %d read %q, but %s was committed before the read started", op.client, op.read, write.written)
+			}
+			if write.invoke > op.complete && pos+1 <= read {
+				return fmt.Errorf("read from the future: client %d read %q, which was written after the read completed", op.client, op.read)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package kvtests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_checkLinearizable(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		history := []operation{
+			{write: true, invoke: 1, complete: 4, read: "", written: "a", ok: true},
+			{write: false, invoke: 2, complete: 3, read: "", ok: true},
+			{write: true, invoke: 5, complete: 8, read: "a", written: "b", ok: true},
+			{write: true, invoke: 6, complete: 7, read: "a", written: "failed", ok: false},
+			{write: false, invoke: 6, complete: 9, read: "b", ok: true},
+		}
+		assert.NoError(t, checkLinearizable(history))
+	})
+	t.Run("lost update", func(t *testing.T) {
+		history := []operation{
+			{write: true, invoke: 1, complete: 3, read: "", written: "a", ok: true},
+			{write: true, invoke: 2, complete: 4, read: "", written: "b", ok: true},
+		}
+		assert.EqualError(t, checkLinearizable(history), `lost update: writes a and b both overwrote ""`)
+	})
+	t.Run("broken chain", func(t *testing.T) {
+		history := []operation{
+			{write: true, invoke: 1, complete: 2, read: "", written: "a", ok: true},
+			{write: true, invoke: 3, complete: 4, read: "unknown", written: "b", ok: true},
+		}
+		assert.EqualError(t, checkLinearizable(history), "1 of 2 committed writes don't form a chain from the initial value")
+	})
+	t.Run("real-time order violated", func(t *testing.T) {
+		history := []operation{
+			{write: true, invoke: 3, complete: 4, read: "", written: "a", ok: true},
+			{write: true, invoke: 1, complete: 2, read: "a", written: "b", ok: true},
+		}
+		assert.ErrorContains(t, checkLinearizable(history), "real-time order violated")
+	})
+	t.Run("stale read", func(t *testing.T) {
+		history := []operation{
+			{write: true, invoke: 1, complete: 2, read: "", written: "a", ok: true},
+			{write: false, invoke: 3, complete: 4, read: "", ok: true},
+		}
+		assert.ErrorContains(t, checkLinearizable(history), "stale read")
+	})
+	t.Run("read from the future", func(t *testing.T) {
+		history := []operation{
+			{write: false, invoke: 1, complete: 2, read: "a", ok: true},
+			{write: true, invoke: 3, complete: 4, read: "", written: "a", ok: true},
+		}
+		assert.ErrorContains(t, checkLinearizable(history), "read from the future")
+	})
+	t.Run("read of uncommitted write", func(t *testing.T) {
+		history := []operation{
+			{write: true, invoke: 1, complete: 2, read: "", written: "a", ok: false},
+			{write: false, invoke: 3, complete: 4, read: "a", ok: true},
+		}
+		assert.ErrorContains(t, checkLinearizable(history), "wasn't committed")
+	})
+}
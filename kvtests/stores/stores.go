@@ -0,0 +1,56 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package stores creates stores backed by the BBolt and Redis backends for tests,
+// e.g. of packages that wrap a KVStore. Stores are closed when the test completes.
+package stores
+
+import (
+	"context"
+	"path"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/bbolt"
+	"github.com/nuts-foundation/go-stoabs/redis7"
+	"github.com/nuts-foundation/go-stoabs/util"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+// BBolt creates a BBolt store in the test directory (see util.TestDirectory), without syncing to disk.
+func BBolt(t *testing.T) stoabs.KVStore {
+	store, err := bbolt.CreateBBoltStore(path.Join(util.TestDirectory(t), "bbolt.db"), stoabs.WithNoSync())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = store.Close(context.Background())
+	})
+	return store
+}
+
+// Redis creates a Redis store (with database prefix "db") backed by an in-memory Redis server (miniredis).
+func Redis(t *testing.T) stoabs.KVStore {
+	mr := miniredis.RunT(t)
+	store, err := redis7.CreateRedisStore("db", &redis.Options{Addr: mr.Addr()})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = store.Close(context.Background())
+	})
+	return store
+}
@@ -0,0 +1,47 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+// ValueLoader reads the value of an entry when it's called, see RangeLazy.
+type ValueLoader func() ([]byte, error)
+
+// LazyCallerFn is the function type which is called for each key when using RangeLazy().
+// The value is only read from the database if load is called, which is only valid during the callback.
+type LazyCallerFn func(key Key, load ValueLoader) error
+
+// LazyRanger is implemented by Readers that can range over keys without reading the values up front.
+type LazyRanger interface {
+	// RangeLazy is like Reader.Range, but the callback receives a ValueLoader instead of the value,
+	// so values of keys the callback skips are never read or copied.
+	RangeLazy(from Key, to Key, callback LazyCallerFn, stopAtNil bool) error
+}
+
+// RangeLazy calls the callback for each key on the shelf from (inclusive) and to (exclusive) given keys,
+// with a ValueLoader that reads the value. Filters that reject most keys avoid the cost of reading the values this way.
+// If the reader does not implement LazyRanger, it falls back to Reader.Range and the loader returns the value read by it.
+func RangeLazy(reader Reader, from Key, to Key, callback LazyCallerFn, stopAtNil bool) error {
+	if ranger, ok := reader.(LazyRanger); ok {
+		return ranger.RangeLazy(from, to, callback, stopAtNil)
+	}
+	return reader.Range(from, to, func(key Key, value []byte) error {
+		return callback(key, func() ([]byte, error) {
+			return value, nil
+		})
+	}, stopAtNil)
+}
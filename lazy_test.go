@@ -0,0 +1,57 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+	"testing"
+)
+
+func TestRangeLazy(t *testing.T) {
+	t.Run("falls back to Range", func(t *testing.T) {
+		reader := NewMockReader(gomock.NewController(t))
+		reader.EXPECT().Range(BytesKey("a"), BytesKey("c"), gomock.Any(), true).DoAndReturn(func(_ Key, _ Key, callback CallerFn, _ bool) error {
+			return callback(BytesKey("a"), []byte("value"))
+		})
+		var actual []byte
+
+		err := RangeLazy(reader, BytesKey("a"), BytesKey("c"), func(key Key, load ValueLoader) error {
+			var err error
+			actual, err = load()
+			return err
+		}, true)
+
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("value"), actual)
+	})
+	t.Run("callback error", func(t *testing.T) {
+		reader := NewMockReader(gomock.NewController(t))
+		reader.EXPECT().Range(gomock.Any(), gomock.Any(), gomock.Any(), false).DoAndReturn(func(_ Key, _ Key, callback CallerFn, _ bool) error {
+			return callback(BytesKey("a"), nil)
+		})
+
+		err := RangeLazy(reader, BytesKey("a"), BytesKey("c"), func(Key, ValueLoader) error {
+			return errors.New("failed")
+		}, false)
+
+		assert.EqualError(t, err, "failed")
+	})
+}
@@ -0,0 +1,69 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrLeaseHeld is returned by AcquireLease when the lease is currently held by another owner.
+var ErrLeaseHeld = errors.New("lease is held by another owner")
+
+// ErrLeaseLost is returned by Lease.KeepAlive when the lease has expired or has been released.
+var ErrLeaseLost = errors.New("lease lost")
+
+// Lease is short-lived, exclusive ownership of a named resource. It expires unless it's kept alive within its TTL.
+type Lease interface {
+	// Name returns the name of the leased resource.
+	Name() string
+	// ExpiresAt returns the time the lease expires unless it's kept alive.
+	ExpiresAt() time.Time
+	// KeepAlive extends the lease by its TTL. It returns ErrLeaseLost if the lease has expired or has been released,
+	// in which case the owner must stop using the resource.
+	KeepAlive(ctx context.Context) error
+	// Release gives up the lease, so it can be acquired by others. Releasing a lost lease is a no-op.
+	Release(ctx context.Context) error
+	// OnExpired registers a callback that is invoked when the lease expires without being released,
+	// or when KeepAlive finds it has been lost. If the lease has already expired, the callback is invoked immediately.
+	OnExpired(fn func())
+}
+
+// Leaser is implemented by stores that support leases.
+type Leaser interface {
+	// AcquireLease acquires a lease on the given name, which expires after the given TTL unless it's kept alive.
+	// It doesn't block: if the lease is held by another owner, it returns ErrLeaseHeld.
+	// Leases are independent of shelves and transactions.
+	AcquireLease(ctx context.Context, name string, ttl time.Duration) (Lease, error)
+}
+
+// AcquireLease acquires a lease on the given name in the given store.
+// If the store does not implement Leaser, it returns errors.ErrUnsupported.
+func AcquireLease(ctx context.Context, store KVStore, name string, ttl time.Duration) (Lease, error) {
+	leaser, ok := store.(Leaser)
+	if !ok {
+		return nil, fmt.Errorf("acquiring lease on %T: %w", store, errors.ErrUnsupported)
+	}
+	if ttl <= 0 {
+		return nil, errors.New("lease TTL must be positive")
+	}
+	return leaser.AcquireLease(ctx, name, ttl)
+}
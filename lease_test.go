@@ -0,0 +1,64 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+	"testing"
+	"time"
+)
+
+type stubLeaser struct {
+	*MockKVStore
+	acquired string
+}
+
+func (s *stubLeaser) AcquireLease(_ context.Context, name string, _ time.Duration) (Lease, error) {
+	s.acquired = name
+	return nil, nil
+}
+
+func TestAcquireLease(t *testing.T) {
+	t.Run("not supported", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+
+		lease, err := AcquireLease(context.Background(), NewMockKVStore(ctrl), "resource", time.Minute)
+
+		assert.ErrorIs(t, err, errors.ErrUnsupported)
+		assert.Nil(t, lease)
+	})
+	t.Run("supported", func(t *testing.T) {
+		store := &stubLeaser{MockKVStore: NewMockKVStore(gomock.NewController(t))}
+
+		_, err := AcquireLease(context.Background(), store, "resource", time.Minute)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "resource", store.acquired)
+	})
+	t.Run("invalid TTL", func(t *testing.T) {
+		store := &stubLeaser{MockKVStore: NewMockKVStore(gomock.NewController(t))}
+
+		_, err := AcquireLease(context.Background(), store, "resource", 0)
+
+		assert.EqualError(t, err, "lease TTL must be positive")
+	})
+}
@@ -0,0 +1,66 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"errors"
+	"fmt"
+)
+
+// State is the lifecycle state of a store.
+type State int
+
+const (
+	// StateOpen means the store accepts new transactions.
+	StateOpen State = iota
+	// StateClosing means Close has been called: new transactions fail with ErrStoreIsClosed,
+	// while in-flight transactions are allowed to finish (or are aborted when the context passed to Close is done).
+	StateClosing
+	// StateClosed means all transactions finished and the resources of the store have been released.
+	StateClosed
+)
+
+func (s State) String() string {
+	switch s {
+	case StateOpen:
+		return "open"
+	case StateClosing:
+		return "closing"
+	case StateClosed:
+		return "closed"
+	default:
+		return fmt.Sprintf("unknown (%d)", int(s))
+	}
+}
+
+// StateReporter is implemented by stores that report their lifecycle state.
+type StateReporter interface {
+	// State returns the current lifecycle state of the store.
+	State() State
+}
+
+// StateOf returns the lifecycle state of the given store.
+// If the store does not implement StateReporter, it returns errors.ErrUnsupported.
+func StateOf(store Store) (State, error) {
+	reporter, ok := store.(StateReporter)
+	if !ok {
+		return StateOpen, fmt.Errorf("reporting state of %T: %w", store, errors.ErrUnsupported)
+	}
+	return reporter.State(), nil
+}
@@ -0,0 +1,58 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+	"testing"
+)
+
+func TestState_String(t *testing.T) {
+	assert.Equal(t, "open", StateOpen.String())
+	assert.Equal(t, "closing", StateClosing.String())
+	assert.Equal(t, "closed", StateClosed.String())
+	assert.Equal(t, "unknown (10)", State(10).String())
+}
+
+func TestStateOf(t *testing.T) {
+	t.Run("supported", func(t *testing.T) {
+		store := struct {
+			KVStore
+			StateReporter
+		}{StateReporter: stateReporter(StateClosing)}
+
+		state, err := StateOf(store)
+
+		assert.NoError(t, err)
+		assert.Equal(t, StateClosing, state)
+	})
+	t.Run("unsupported", func(t *testing.T) {
+		_, err := StateOf(NewMockKVStore(gomock.NewController(t)))
+
+		assert.ErrorIs(t, err, errors.ErrUnsupported)
+	})
+}
+
+type stateReporter State
+
+func (s stateReporter) State() State {
+	return State(s)
+}
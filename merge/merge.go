@@ -0,0 +1,254 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package merge provides a KVStore that supports merge operators (see stoabs.Merger), which combine an operand with the
+// current value of a key at write time, e.g. adding to a counter or appending to a list.
+package merge
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/util"
+)
+
+var _ stoabs.KVStore = (*Store)(nil)
+var _ stoabs.ShelfLister = (*Store)(nil)
+var _ stoabs.WriteTx = (*tx)(nil)
+var _ stoabs.Writer = (*shelf)(nil)
+var _ stoabs.Merger = (*shelf)(nil)
+
+// Operator combines the operand with the existing value of a key (nil if the key doesn't exist), returning the new value.
+type Operator func(existing []byte, operand []byte) ([]byte, error)
+
+// AddUint64 adds the operand to the existing value, both 8-byte big-endian unsigned integers. The sum wraps around on overflow.
+func AddUint64(existing []byte, operand []byte) ([]byte, error) {
+	if len(operand) != 8 || (existing != nil && len(existing) != 8) {
+		return nil, errors.New("counter values must be 8 bytes")
+	}
+	var current uint64
+	if existing != nil {
+		current = binary.BigEndian.Uint64(existing)
+	}
+	return binary.BigEndian.AppendUint64(nil, current+binary.BigEndian.Uint64(operand)), nil
+}
+
+// Append appends the operand to the existing value.
+func Append(existing []byte, operand []byte) ([]byte, error) {
+	return append(bytes.Clone(existing), operand...), nil
+}
+
+// Union adds the members of the operand to the existing value, both sets encoded using EncodeSet.
+func Union(existing []byte, operand []byte) ([]byte, error) {
+	current, err := DecodeSet(existing)
+	if err != nil {
+		return nil, err
+	}
+	members, err := DecodeSet(operand)
+	if err != nil {
+		return nil, err
+	}
+	return EncodeSet(append(current, members...)...), nil
+}
+
+// EncodeSet encodes the given members as a set, for use with Union: sorted and without duplicates,
+// each member prefixed with its length (uvarint).
+func EncodeSet(members ...[]byte) []byte {
+	sorted := append([][]byte{}, members...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i], sorted[j]) < 0
+	})
+	var result []byte
+	for i, member := range sorted {
+		if i > 0 && bytes.Equal(member, sorted[i-1]) {
+			continue
+		}
+		result = binary.AppendUvarint(result, uint64(len(member)))
+		result = append(result, member...)
+	}
+	return result
+}
+
+// DecodeSet decodes a set encoded by EncodeSet.
+func DecodeSet(data []byte) ([][]byte, error) {
+	var result [][]byte
+	for len(data) > 0 {
+		length, n := binary.Uvarint(data)
+		if n <= 0 || uint64(len(data)-n) < length {
+			return nil, errors.New("invalid set")
+		}
+		result = append(result, data[n:n+int(length)])
+		data = data[n+int(length):]
+	}
+	return result, nil
+}
+
+// Option configures the merging store.
+type Option func(s *Store)
+
+// WithOperator registers the merge operator of the given shelf.
+func WithOperator(shelfName string, operator Operator) Option {
+	return func(s *Store) {
+		s.operators[shelfName] = operator
+	}
+}
+
+// Wrap creates a store whose writers of shelves with a merge operator (see WithOperator) implement stoabs.Merger:
+// use stoabs.Merge to merge an operand into the value of a key. Merging reads the current value and writes the merged value,
+// in the same transaction. To prevent concurrent transactions from overwriting each other's merges,
+// all write transactions lock the shelves with a merge operator (see stoabs.WithShelfLock).
+func Wrap(store stoabs.KVStore, opts ...Option) *Store {
+	result := &Store{
+		underlying: store,
+		operators:  map[string]Operator{},
+	}
+	for _, opt := range opts {
+		opt(result)
+	}
+	for shelfName := range result.operators {
+		result.shelves = append(result.shelves, shelfName)
+	}
+	sort.Strings(result.shelves)
+	return result
+}
+
+// Store is a KVStore that supports merge operators. Use Wrap to create it.
+type Store struct {
+	underlying stoabs.KVStore
+	operators  map[string]Operator
+	// shelves holds the names of the shelves with a merge operator, which are locked by every write transaction.
+	shelves []string
+}
+
+func (s *Store) Close(ctx context.Context) error {
+	return s.underlying.Close(ctx)
+}
+
+func (s *Store) Write(ctx context.Context, fn func(stoabs.WriteTx) error, opts ...stoabs.TxOption) error {
+	if len(s.shelves) > 0 {
+		opts = append(opts, stoabs.WithShelfLock(s.shelves...))
+	}
+	return s.underlying.Write(ctx, func(underlyingTx stoabs.WriteTx) error {
+		return fn(&tx{ReadTx: underlyingTx, writeTx: underlyingTx, store: s, values: map[valueCacheKey][]byte{}})
+	}, opts...)
+}
+
+func (s *Store) WriteShelf(ctx context.Context, shelfName string, fn func(stoabs.Writer) error) error {
+	return s.Write(ctx, func(tx stoabs.WriteTx) error {
+		return fn(tx.GetShelfWriter(shelfName))
+	})
+}
+
+func (s *Store) Read(ctx context.Context, fn func(stoabs.ReadTx) error) error {
+	return s.underlying.Read(ctx, fn)
+}
+
+func (s *Store) ReadShelf(ctx context.Context, shelfName string, fn func(stoabs.Reader) error) error {
+	return s.underlying.ReadShelf(ctx, shelfName, fn)
+}
+
+func (s *Store) ShelfNames(ctx context.Context) ([]string, error) {
+	return stoabs.ShelfNames(ctx, s.underlying)
+}
+
+type tx struct {
+	stoabs.ReadTx
+	writeTx stoabs.WriteTx
+	store   *Store
+	// values holds the values written to shelves with a merge operator in this transaction (nil if deleted),
+	// since not all backends can read values written in the same transaction.
+	values map[valueCacheKey][]byte
+}
+
+func (t *tx) GetShelfWriter(shelfName string) stoabs.Writer {
+	writer := t.writeTx.GetShelfWriter(shelfName)
+	operator, ok := t.store.operators[shelfName]
+	if !ok {
+		return writer
+	}
+	return &shelf{Writer: writer, name: shelfName, operator: operator, tx: t}
+}
+
+func (t *tx) Store() stoabs.KVStore {
+	return t.store
+}
+
+type shelf struct {
+	stoabs.Writer
+	name     string
+	operator Operator
+	tx       *tx
+}
+
+func (s *shelf) Merge(key stoabs.Key, operand []byte) error {
+	existing, err := s.currentValue(key)
+	if err != nil {
+		return err
+	}
+	merged, err := s.operator(existing, operand)
+	if err != nil {
+		return fmt.Errorf("unable to merge into key %s of shelf %s: %w", util.FormatKey(key.Bytes()), s.name, err)
+	}
+	return s.Put(key, merged)
+}
+
+func (s *shelf) Put(key stoabs.Key, value []byte) error {
+	if err := s.Writer.Put(key, value); err != nil {
+		return err
+	}
+	s.tx.values[cacheKey(s.name, key)] = bytes.Clone(value)
+	return nil
+}
+
+func (s *shelf) Delete(key stoabs.Key) error {
+	if err := s.Writer.Delete(key); err != nil {
+		return err
+	}
+	s.tx.values[cacheKey(s.name, key)] = nil
+	return nil
+}
+
+// currentValue returns the current value of the given key, or nil if it doesn't exist.
+func (s *shelf) currentValue(key stoabs.Key) ([]byte, error) {
+	if value, ok := s.tx.values[cacheKey(s.name, key)]; ok {
+		return value, nil
+	}
+	value, err := s.Writer.Get(key)
+	if errors.Is(err, stoabs.ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bytes.Clone(value), nil
+}
+
+// valueCacheKey identifies a key in the value cache of a transaction.
+type valueCacheKey struct {
+	shelf string
+	key   string
+}
+
+func cacheKey(shelfName string, key stoabs.Key) valueCacheKey {
+	return valueCacheKey{shelf: shelfName, key: string(key.Bytes())}
+}
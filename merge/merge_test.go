@@ -0,0 +1,204 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package merge
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+const counters = "counters"
+
+var key = stoabs.BytesKey("visits")
+
+func TestMerge(t *testing.T) {
+	provider := func(t *testing.T) (stoabs.KVStore, error) {
+		return Wrap(stores.BBolt(t), WithOperator("test", Append)), nil
+	}
+
+	kvtests.TestReadingAndWriting(t, provider)
+	kvtests.TestRange(t, provider)
+	kvtests.TestIterate(t, provider)
+	kvtests.TestDelete(t, provider)
+	kvtests.TestWriteTransactions(t, provider)
+	kvtests.TestShelfNames(t, provider)
+}
+
+func TestStore_Merge(t *testing.T) {
+	t.Run("merges into the current value", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t), WithOperator(counters, AddUint64))
+
+		merge(t, store, key, 1)
+		merge(t, store, key, 2)
+
+		assert.Equal(t, uint64(3), counter(t, store, key))
+	})
+	t.Run("merges within a transaction", func(t *testing.T) {
+		for name, underlying := range map[string]stoabs.KVStore{"bbolt": stores.BBolt(t), "redis": stores.Redis(t)} {
+			t.Run(name, func(t *testing.T) {
+				store := Wrap(underlying, WithOperator(counters, AddUint64))
+
+				err := store.WriteShelf(ctx, counters, func(writer stoabs.Writer) error {
+					// Redis can't read values written in the same transaction
+					if err := writer.Put(key, uint64Value(10)); err != nil {
+						return err
+					}
+					if err := stoabs.Merge(writer, key, uint64Value(1)); err != nil {
+						return err
+					}
+					return stoabs.Merge(writer, key, uint64Value(1))
+				})
+
+				require.NoError(t, err)
+				assert.Equal(t, uint64(12), counter(t, store, key))
+				err = store.WriteShelf(ctx, counters, func(writer stoabs.Writer) error {
+					if err := writer.Delete(key); err != nil {
+						return err
+					}
+					return stoabs.Merge(writer, key, uint64Value(5))
+				})
+				require.NoError(t, err)
+				assert.Equal(t, uint64(5), counter(t, store, key))
+			})
+		}
+	})
+	t.Run("concurrent merges", func(t *testing.T) {
+		for name, underlying := range map[string]stoabs.KVStore{"bbolt": stores.BBolt(t), "redis": stores.Redis(t)} {
+			t.Run(name, func(t *testing.T) {
+				store := Wrap(underlying, WithOperator(counters, AddUint64))
+				const routines = 10
+				var wg sync.WaitGroup
+				for i := 0; i < routines; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						assert.NoError(t, store.WriteShelf(ctx, counters, func(writer stoabs.Writer) error {
+							return stoabs.Merge(writer, key, uint64Value(1))
+						}))
+					}()
+				}
+				wg.Wait()
+
+				assert.Equal(t, uint64(routines), counter(t, store, key))
+			})
+		}
+	})
+	t.Run("keys of different shelves don't collide", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t), WithOperator("a", AddUint64), WithOperator("a/b", AddUint64))
+
+		err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+			if err := stoabs.Merge(tx.GetShelfWriter("a/b"), stoabs.BytesKey("c"), uint64Value(1)); err != nil {
+				return err
+			}
+			return stoabs.Merge(tx.GetShelfWriter("a"), stoabs.BytesKey("b/c"), uint64Value(2))
+		})
+
+		require.NoError(t, err)
+		var value []byte
+		require.NoError(t, store.ReadShelf(ctx, "a", func(reader stoabs.Reader) error {
+			value, err = reader.Get(stoabs.BytesKey("b/c"))
+			return err
+		}))
+		assert.Equal(t, uint64Value(2), value)
+	})
+	t.Run("operator error", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t), WithOperator(counters, AddUint64))
+
+		err := store.WriteShelf(ctx, counters, func(writer stoabs.Writer) error {
+			return stoabs.Merge(writer, key, []byte{1})
+		})
+
+		assert.EqualError(t, err, "unable to merge into key visits of shelf counters: counter values must be 8 bytes")
+	})
+	t.Run("shelf without operator", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t), WithOperator(counters, AddUint64))
+
+		err := store.WriteShelf(ctx, "other", func(writer stoabs.Writer) error {
+			return stoabs.Merge(writer, key, uint64Value(1))
+		})
+
+		assert.ErrorIs(t, err, errors.ErrUnsupported)
+	})
+}
+
+func TestOperators(t *testing.T) {
+	t.Run("Append", func(t *testing.T) {
+		value, err := Append(nil, []byte("a"))
+		require.NoError(t, err)
+		value, err = Append(value, []byte("b"))
+		require.NoError(t, err)
+
+		assert.Equal(t, []byte("ab"), value)
+	})
+	t.Run("Union", func(t *testing.T) {
+		value, err := Union(nil, EncodeSet([]byte("b"), []byte("a")))
+		require.NoError(t, err)
+		value, err = Union(value, EncodeSet([]byte("c"), []byte("a")))
+		require.NoError(t, err)
+
+		members, err := DecodeSet(value)
+		require.NoError(t, err)
+		assert.Equal(t, [][]byte{[]byte("a"), []byte("b"), []byte("c")}, members)
+	})
+	t.Run("Union of invalid set", func(t *testing.T) {
+		_, err := Union([]byte{5, 1}, nil)
+
+		assert.EqualError(t, err, "invalid set")
+	})
+	t.Run("AddUint64 wraps around", func(t *testing.T) {
+		value, err := AddUint64(uint64Value(^uint64(0)), uint64Value(2))
+
+		require.NoError(t, err)
+		assert.Equal(t, uint64Value(1), value)
+	})
+}
+
+func merge(t *testing.T, store stoabs.KVStore, key stoabs.Key, delta uint64) {
+	require.NoError(t, store.WriteShelf(ctx, counters, func(writer stoabs.Writer) error {
+		return stoabs.Merge(writer, key, uint64Value(delta))
+	}))
+}
+
+func counter(t *testing.T, store stoabs.KVStore, key stoabs.Key) uint64 {
+	var result uint64
+	require.NoError(t, store.ReadShelf(ctx, counters, func(reader stoabs.Reader) error {
+		value, err := reader.Get(key)
+		if err != nil {
+			return err
+		}
+		result = binary.BigEndian.Uint64(value)
+		return nil
+	}))
+	return result
+}
+
+func uint64Value(value uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, value)
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package metrics
+
+import (
+	"github.com/nuts-foundation/go-stoabs/breaker"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	breakerStateDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "circuit_breaker", "state"),
+		"State of the circuit breaker: 0 (closed), 1 (half-open) or 2 (open).",
+		nil, nil,
+	)
+	breakerFailuresDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "circuit_breaker", "failures_total"),
+		"Number of operations that failed because of the underlying store.",
+		nil, nil,
+	)
+	breakerTripsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "circuit_breaker", "trips_total"),
+		"Number of times the circuit breaker opened.",
+		nil, nil,
+	)
+	breakerRejectedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "circuit_breaker", "rejected_total"),
+		"Number of operations that failed because the circuit breaker was open.",
+		nil, nil,
+	)
+)
+
+// NewCircuitBreakerCollector returns a collector reporting the state and counters of the given circuit breaker.
+// To register collectors for multiple circuit breakers, use prometheus.WrapRegistererWith to label them.
+func NewCircuitBreakerCollector(store *breaker.Store) prometheus.Collector {
+	return &breakerCollector{store: store}
+}
+
+type breakerCollector struct {
+	store *breaker.Store
+}
+
+func (c *breakerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- breakerStateDesc
+	ch <- breakerFailuresDesc
+	ch <- breakerTripsDesc
+	ch <- breakerRejectedDesc
+}
+
+func (c *breakerCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.store.Stats()
+	ch <- prometheus.MustNewConstMetric(breakerStateDesc, prometheus.GaugeValue, float64(stats.State))
+	ch <- prometheus.MustNewConstMetric(breakerFailuresDesc, prometheus.CounterValue, float64(stats.Failures))
+	ch <- prometheus.MustNewConstMetric(breakerTripsDesc, prometheus.CounterValue, float64(stats.Trips))
+	ch <- prometheus.MustNewConstMetric(breakerRejectedDesc, prometheus.CounterValue, float64(stats.Rejected))
+}
@@ -0,0 +1,44 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nuts-foundation/go-stoabs/breaker"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewCircuitBreakerCollector(t *testing.T) {
+	store := breaker.Wrap(stores.BBolt(t))
+
+	err := testutil.CollectAndCompare(NewCircuitBreakerCollector(store), strings.NewReader(`
+# HELP stoabs_circuit_breaker_state State of the circuit breaker: 0 (closed), 1 (half-open) or 2 (open).
+# TYPE stoabs_circuit_breaker_state gauge
+stoabs_circuit_breaker_state 0
+# HELP stoabs_circuit_breaker_trips_total Number of times the circuit breaker opened.
+# TYPE stoabs_circuit_breaker_trips_total counter
+stoabs_circuit_breaker_trips_total 0
+`), "stoabs_circuit_breaker_state", "stoabs_circuit_breaker_trips_total")
+
+	assert.NoError(t, err)
+}
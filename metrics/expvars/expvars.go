@@ -0,0 +1,282 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package expvars exposes the metrics of package metrics as an expvar variable (served at /debug/vars) or as a JSON snapshot,
+// for applications that don't want to depend on the Prometheus client.
+package expvars
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/breaker"
+	"github.com/nuts-foundation/go-stoabs/quota"
+	"github.com/nuts-foundation/go-stoabs/retention"
+)
+
+// collectTimeout is the maximum duration of reading the statistics from a store when a snapshot is taken.
+const collectTimeout = 5 * time.Second
+
+// errorsName is the name of the entry of a snapshot that lists the errors that occurred while taking it.
+const errorsName = "stoabs_errors"
+
+// Option selects the metrics to expose.
+type Option func(m *Metrics)
+
+// WithStore exposes the statistics of the given store (see stoabs.Stats), like metrics.NewStoreCollector.
+// The store must implement stoabs.StatsReader.
+func WithStore(store stoabs.KVStore) Option {
+	return func(m *Metrics) {
+		m.store = store
+	}
+}
+
+// WithShelves exposes the number of entries and size of all shelves of the given store, like metrics.NewShelfCollector.
+// The store must implement stoabs.ShelfLister.
+func WithShelves(store stoabs.KVStore) Option {
+	return func(m *Metrics) {
+		m.shelves = store
+	}
+}
+
+// WithQuota exposes the usage and quotas of the shelves of the given store, like metrics.NewQuotaCollector.
+func WithQuota(store *quota.Store) Option {
+	return func(m *Metrics) {
+		m.quota = store
+	}
+}
+
+// WithRetention exposes the number of entries deleted by the given retention engine, like metrics.NewRetentionCollector.
+func WithRetention(engine *retention.Engine) Option {
+	return func(m *Metrics) {
+		m.retention = engine
+	}
+}
+
+// WithCircuitBreaker exposes the state and counters of the given circuit breaker, like metrics.NewCircuitBreakerCollector.
+func WithCircuitBreaker(store *breaker.Store) Option {
+	return func(m *Metrics) {
+		m.breaker = store
+	}
+}
+
+// New creates the metrics selected by the given options.
+func New(opts ...Option) *Metrics {
+	result := &Metrics{}
+	for _, opt := range opts {
+		opt(result)
+	}
+	return result
+}
+
+// Metrics takes snapshots of the selected metrics, use New to create it. Metrics have the same names as the metrics of
+// package metrics. Labeled metrics are maps by label value, e.g. stoabs_shelf_entries holds the number of entries by shelf,
+// and stoabs_quota_usage the usage by shelf and resource.
+type Metrics struct {
+	store     stoabs.KVStore
+	shelves   stoabs.KVStore
+	quota     *quota.Store
+	retention *retention.Engine
+	breaker   *breaker.Store
+}
+
+// Publish publishes the metrics as expvar variable with the given name, which is served by the expvar package at /debug/vars.
+// Every request takes a new snapshot. Errors that occur while taking it are listed in the stoabs_errors entry.
+// It returns an error if a variable with the name has already been published.
+func (m *Metrics) Publish(name string) error {
+	if expvar.Get(name) != nil {
+		return fmt.Errorf("expvar variable %s already published", name)
+	}
+	expvar.Publish(name, expvar.Func(func() any {
+		ctx, cancel := context.WithTimeout(context.Background(), collectTimeout)
+		defer cancel()
+		return withErrors(m.Snapshot(ctx))
+	}))
+	return nil
+}
+
+// ServeHTTP writes a snapshot as JSON object. Errors that occur while taking it are listed in the stoabs_errors entry.
+func (m *Metrics) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	ctx, cancel := context.WithTimeout(request.Context(), collectTimeout)
+	defer cancel()
+	writer.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(writer).Encode(withErrors(m.Snapshot(ctx)))
+}
+
+// Snapshot returns the current values of the metrics by name. Like the Prometheus collectors, metrics that can't be read
+// are left out, in which case it returns the (joined) errors along with the metrics that could be read.
+func (m *Metrics) Snapshot(ctx context.Context) (map[string]any, error) {
+	result := map[string]any{}
+	var errs []error
+	if m.store != nil {
+		if err := m.storeMetrics(ctx, result); err != nil {
+			errs = append(errs, fmt.Errorf("unable to read store statistics: %w", err))
+		}
+	}
+	if m.shelves != nil {
+		if err := m.shelfMetrics(ctx, result); err != nil {
+			errs = append(errs, fmt.Errorf("unable to read shelf statistics: %w", err))
+		}
+	}
+	if m.quota != nil {
+		if err := m.quotaMetrics(ctx, result); err != nil {
+			errs = append(errs, fmt.Errorf("unable to read quota usage: %w", err))
+		}
+	}
+	if m.retention != nil {
+		m.retentionMetrics(result)
+	}
+	if m.breaker != nil {
+		m.breakerMetrics(result)
+	}
+	return result, errors.Join(errs...)
+}
+
+// withErrors adds the messages of the errors that occurred while taking the snapshot to it.
+func withErrors(snapshot map[string]any, err error) map[string]any {
+	if err != nil {
+		var messages []string
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			for _, err := range joined.Unwrap() {
+				messages = append(messages, err.Error())
+			}
+		} else {
+			messages = append(messages, err.Error())
+		}
+		snapshot[errorsName] = messages
+	}
+	return snapshot
+}
+
+func (m *Metrics) storeMetrics(ctx context.Context, result map[string]any) error {
+	stats, err := stoabs.Stats(ctx, m.store)
+	if err != nil {
+		return err
+	}
+	result["stoabs_store_size_bytes"] = stats.Size
+	result["stoabs_store_free_pages"] = stats.FreePages
+	result["stoabs_store_memory_usage_bytes"] = stats.MemoryUsage
+	result["stoabs_store_shelves"] = stats.Shelves
+	result["stoabs_store_open_transactions"] = stats.OpenTransactions
+	result["stoabs_store_conflicts_total"] = stats.Conflicts
+	result["stoabs_store_evictions_total"] = stats.Evictions
+	if stats.MemoryLimit > 0 {
+		result["stoabs_store_memory_limit_bytes"] = stats.MemoryLimit
+	}
+	if !stats.LastCompaction.IsZero() {
+		result["stoabs_store_last_compaction_timestamp_seconds"] = stats.LastCompaction.Unix()
+	}
+	if !stats.LastBackup.IsZero() {
+		result["stoabs_store_last_backup_timestamp_seconds"] = stats.LastBackup.Unix()
+	}
+	if pages := stats.Pages; pages != nil {
+		result["stoabs_store_page_allocations_total"] = pages.PageAllocations
+		result["stoabs_store_page_allocated_bytes_total"] = pages.PageAllocatedBytes
+		result["stoabs_store_node_allocations_total"] = pages.NodeAllocations
+		result["stoabs_store_node_dereferences_total"] = pages.NodeDereferences
+		result["stoabs_store_node_rebalances_total"] = pages.Rebalances
+		result["stoabs_store_node_rebalance_seconds_total"] = pages.RebalanceDuration.Seconds()
+		result["stoabs_store_node_splits_total"] = pages.Splits
+		result["stoabs_store_node_spills_total"] = pages.Spills
+		result["stoabs_store_node_spill_seconds_total"] = pages.SpillDuration.Seconds()
+		result["stoabs_store_page_writes_total"] = pages.Writes
+		result["stoabs_store_page_write_seconds_total"] = pages.WriteDuration.Seconds()
+		result["stoabs_store_free_bytes"] = pages.FreeBytes
+		result["stoabs_store_freelist_bytes"] = pages.FreelistBytes
+		result["stoabs_store_read_transactions_total"] = pages.ReadTransactions
+	}
+	if disk := stats.Disk; disk != nil {
+		result["stoabs_store_commits_total"] = disk.Commits
+		result["stoabs_store_put_bytes_total"] = disk.BytesPut
+		result["stoabs_store_disk_written_bytes_total"] = disk.BytesWritten
+		result["stoabs_store_syncs_total"] = disk.Syncs
+		result["stoabs_store_sync_seconds_total"] = disk.SyncDuration.Seconds()
+		result["stoabs_store_write_amplification_ratio"] = disk.WriteAmplification()
+	}
+	return nil
+}
+
+func (m *Metrics) shelfMetrics(ctx context.Context, result map[string]any) error {
+	names, err := stoabs.ShelfNames(ctx, m.shelves)
+	if err != nil {
+		return err
+	}
+	entries, sizes := map[string]uint{}, map[string]uint{}
+	err = m.shelves.Read(ctx, func(tx stoabs.ReadTx) error {
+		for _, name := range names {
+			stats := tx.GetShelfReader(name).Stats()
+			entries[name] = stats.NumEntries
+			sizes[name] = stats.ShelfSize
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	result["stoabs_shelf_entries"] = entries
+	result["stoabs_shelf_size_bytes"] = sizes
+	return nil
+}
+
+func (m *Metrics) quotaMetrics(ctx context.Context, result map[string]any) error {
+	usages, err := m.quota.Usage(ctx)
+	if err != nil {
+		return err
+	}
+	usage, limits, utilization := map[string]map[string]uint64{}, map[string]map[string]uint64{}, map[string]map[string]float64{}
+	for shelfName, shelfUsage := range usages {
+		limit := m.quota.LimitOf(shelfName)
+		usage[shelfName] = map[string]uint64{"entries": shelfUsage.Entries, "bytes": shelfUsage.Bytes}
+		for resource, maximum := range map[string]uint64{"entries": limit.MaxEntries, "bytes": limit.MaxBytes} {
+			if maximum == 0 {
+				continue
+			}
+			if limits[shelfName] == nil {
+				limits[shelfName], utilization[shelfName] = map[string]uint64{}, map[string]float64{}
+			}
+			limits[shelfName][resource] = maximum
+			utilization[shelfName][resource] = float64(usage[shelfName][resource]) / float64(maximum)
+		}
+	}
+	result["stoabs_quota_usage"] = usage
+	result["stoabs_quota_limit"] = limits
+	result["stoabs_quota_utilization_ratio"] = utilization
+	return nil
+}
+
+func (m *Metrics) retentionMetrics(result map[string]any) {
+	stats := m.retention.Stats()
+	result["stoabs_retention_purged_total"] = stats.Purged
+	if !stats.LastRun.IsZero() {
+		result["stoabs_retention_last_run_timestamp_seconds"] = float64(stats.LastRun.UnixNano()) / 1e9
+	}
+}
+
+func (m *Metrics) breakerMetrics(result map[string]any) {
+	stats := m.breaker.Stats()
+	result["stoabs_circuit_breaker_state"] = int(stats.State)
+	result["stoabs_circuit_breaker_failures_total"] = stats.Failures
+	result["stoabs_circuit_breaker_trips_total"] = stats.Trips
+	result["stoabs_circuit_breaker_rejected_total"] = stats.Rejected
+}
@@ -0,0 +1,147 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package expvars
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/breaker"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/nuts-foundation/go-stoabs/metrics"
+	"github.com/nuts-foundation/go-stoabs/quota"
+	"github.com/nuts-foundation/go-stoabs/retention"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+func TestMetrics_Snapshot(t *testing.T) {
+	t.Run("all metrics", func(t *testing.T) {
+		store := stores.BBolt(t)
+		quotaStore := quota.Wrap(store, quota.WithLimit("test", quota.Limit{MaxEntries: 4}))
+		put(t, quotaStore, 4)
+		engine := retention.New(store, retention.WithRule(retention.Rule{Shelf: "test", MaxEntries: 2}))
+		_, err := engine.Enforce(ctx)
+		require.NoError(t, err)
+		breakerStore := breaker.Wrap(store)
+		m := New(WithStore(store), WithShelves(store), WithQuota(quotaStore), WithRetention(engine), WithCircuitBreaker(breakerStore))
+
+		snapshot, err := m.Snapshot(ctx)
+
+		require.NoError(t, err)
+		// the quota usage is stored in a reserved shelf
+		assert.Equal(t, 2, snapshot["stoabs_store_shelves"])
+		assert.Equal(t, map[string]uint{"test": 2}, snapshot["stoabs_shelf_entries"])
+		assert.Equal(t, map[string]map[string]uint64{"test": {"entries": 4}}, snapshot["stoabs_quota_limit"])
+		assert.Equal(t, map[string]map[string]float64{"test": {"entries": 1}}, snapshot["stoabs_quota_utilization_ratio"])
+		assert.Equal(t, map[string]uint64{"test": 2}, snapshot["stoabs_retention_purged_total"])
+		assert.Equal(t, 0, snapshot["stoabs_circuit_breaker_state"])
+		t.Run("same names as the Prometheus collectors", func(t *testing.T) {
+			registry := prometheus.NewRegistry()
+			registry.MustRegister(metrics.NewStoreCollector(store), metrics.NewShelfCollector(store),
+				metrics.NewQuotaCollector(quotaStore), metrics.NewRetentionCollector(engine),
+				metrics.NewCircuitBreakerCollector(breakerStore))
+			families, err := registry.Gather()
+			require.NoError(t, err)
+			var expected []string
+			for _, family := range families {
+				expected = append(expected, family.GetName())
+			}
+			var actual []string
+			for name := range snapshot {
+				actual = append(actual, name)
+			}
+
+			assert.ElementsMatch(t, expected, actual)
+		})
+	})
+	t.Run("failing store", func(t *testing.T) {
+		store := stores.BBolt(t)
+		require.NoError(t, store.Close(ctx))
+		m := New(WithStore(store), WithShelves(store), WithCircuitBreaker(breaker.Wrap(store)))
+
+		snapshot, err := m.Snapshot(ctx)
+
+		assert.ErrorContains(t, err, "unable to read store statistics")
+		assert.ErrorContains(t, err, "unable to read shelf statistics")
+		assert.Contains(t, snapshot, "stoabs_circuit_breaker_trips_total")
+		assert.NotContains(t, snapshot, "stoabs_store_shelves")
+	})
+}
+
+func TestMetrics_Publish(t *testing.T) {
+	store := stores.BBolt(t)
+	put(t, store, 1)
+	m := New(WithShelves(store))
+
+	require.NoError(t, m.Publish("expvars_test"))
+
+	var published map[string]map[string]uint
+	require.NoError(t, json.Unmarshal([]byte(expvar.Get("expvars_test").String()), &published))
+	assert.Equal(t, map[string]uint{"test": 1}, published["stoabs_shelf_entries"])
+	t.Run("already published", func(t *testing.T) {
+		err := m.Publish("expvars_test")
+
+		assert.EqualError(t, err, "expvar variable expvars_test already published")
+	})
+}
+
+func TestMetrics_ServeHTTP(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		store := stores.BBolt(t)
+		put(t, store, 1)
+		recorder := httptest.NewRecorder()
+
+		New(WithStore(store)).ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics.json", nil))
+
+		assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+		var snapshot map[string]any
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &snapshot))
+		assert.Equal(t, float64(1), snapshot["stoabs_store_shelves"])
+	})
+	t.Run("errors", func(t *testing.T) {
+		store := stores.BBolt(t)
+		require.NoError(t, store.Close(ctx))
+		recorder := httptest.NewRecorder()
+
+		New(WithStore(store), WithShelves(store)).ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics.json", nil))
+
+		var snapshot map[string][]string
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &snapshot))
+		assert.Len(t, snapshot[errorsName], 2)
+	})
+}
+
+func put(t *testing.T, store stoabs.KVStore, count int) {
+	require.NoError(t, store.WriteShelf(ctx, "test", func(writer stoabs.Writer) error {
+		for i := 0; i < count; i++ {
+			if err := writer.Put(stoabs.Uint32Key(i), []byte("value")); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
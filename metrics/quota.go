@@ -0,0 +1,84 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package metrics
+
+import (
+	"context"
+
+	"github.com/nuts-foundation/go-stoabs/quota"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	quotaUsageDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "quota", "usage"),
+		"Usage of a limited shelf, by resource (entries or bytes).",
+		[]string{"shelf", "resource"}, nil,
+	)
+	quotaLimitDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "quota", "limit"),
+		"Quota of a limited shelf, by resource (entries or bytes). Not reported for unlimited resources.",
+		[]string{"shelf", "resource"}, nil,
+	)
+	quotaUtilizationDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "quota", "utilization_ratio"),
+		"Usage of a limited shelf divided by its quota, by resource (entries or bytes).",
+		[]string{"shelf", "resource"}, nil,
+	)
+)
+
+// NewQuotaCollector returns a collector reporting the usage and quotas of the shelves of the given store.
+// Only shelves that have been written to through the store are reported, see quota.Store.Usage.
+func NewQuotaCollector(store *quota.Store) prometheus.Collector {
+	return &quotaCollector{store: store}
+}
+
+type quotaCollector struct {
+	store *quota.Store
+}
+
+func (c *quotaCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- quotaUsageDesc
+	ch <- quotaLimitDesc
+	ch <- quotaUtilizationDesc
+}
+
+func (c *quotaCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), collectTimeout)
+	defer cancel()
+	usages, err := c.store.Usage(ctx)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(quotaUsageDesc, err)
+		return
+	}
+	for shelfName, usage := range usages {
+		limit := c.store.LimitOf(shelfName)
+		collectQuota(ch, shelfName, "entries", usage.Entries, limit.MaxEntries)
+		collectQuota(ch, shelfName, "bytes", usage.Bytes, limit.MaxBytes)
+	}
+}
+
+func collectQuota(ch chan<- prometheus.Metric, shelfName string, resource string, usage uint64, limit uint64) {
+	ch <- prometheus.MustNewConstMetric(quotaUsageDesc, prometheus.GaugeValue, float64(usage), shelfName, resource)
+	if limit == 0 {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(quotaLimitDesc, prometheus.GaugeValue, float64(limit), shelfName, resource)
+	ch <- prometheus.MustNewConstMetric(quotaUtilizationDesc, prometheus.GaugeValue, float64(usage)/float64(limit), shelfName, resource)
+}
@@ -0,0 +1,56 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package metrics
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/nuts-foundation/go-stoabs/quota"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+func TestNewQuotaCollector(t *testing.T) {
+	store := quota.Wrap(stores.BBolt(t), quota.WithLimit("test", quota.Limit{MaxEntries: 4}))
+	require.NoError(t, store.WriteShelf(ctx, "test", func(writer stoabs.Writer) error {
+		return writer.Put(stoabs.BytesKey("key"), []byte("value"))
+	}))
+
+	err := testutil.CollectAndCompare(NewQuotaCollector(store), strings.NewReader(`
+# HELP stoabs_quota_limit Quota of a limited shelf, by resource (entries or bytes). Not reported for unlimited resources.
+# TYPE stoabs_quota_limit gauge
+stoabs_quota_limit{resource="entries",shelf="test"} 4
+# HELP stoabs_quota_usage Usage of a limited shelf, by resource (entries or bytes).
+# TYPE stoabs_quota_usage gauge
+stoabs_quota_usage{resource="bytes",shelf="test"} 5
+stoabs_quota_usage{resource="entries",shelf="test"} 1
+# HELP stoabs_quota_utilization_ratio Usage of a limited shelf divided by its quota, by resource (entries or bytes).
+# TYPE stoabs_quota_utilization_ratio gauge
+stoabs_quota_utilization_ratio{resource="entries",shelf="test"} 0.25
+`))
+
+	assert.NoError(t, err)
+}
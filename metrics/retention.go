@@ -0,0 +1,61 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package metrics
+
+import (
+	"github.com/nuts-foundation/go-stoabs/retention"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	retentionPurgedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "retention", "purged_total"),
+		"Number of entries deleted by retention rules, by shelf.",
+		[]string{"shelf"}, nil,
+	)
+	retentionLastRunDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "retention", "last_run_timestamp_seconds"),
+		"Time retention was last enforced successfully. Not reported before the first successful run.",
+		nil, nil,
+	)
+)
+
+// NewRetentionCollector returns a collector reporting the number of entries deleted by the given retention engine.
+func NewRetentionCollector(engine *retention.Engine) prometheus.Collector {
+	return &retentionCollector{engine: engine}
+}
+
+type retentionCollector struct {
+	engine *retention.Engine
+}
+
+func (c *retentionCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- retentionPurgedDesc
+	ch <- retentionLastRunDesc
+}
+
+func (c *retentionCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.engine.Stats()
+	for shelfName, count := range stats.Purged {
+		ch <- prometheus.MustNewConstMetric(retentionPurgedDesc, prometheus.CounterValue, float64(count), shelfName)
+	}
+	if !stats.LastRun.IsZero() {
+		ch <- prometheus.MustNewConstMetric(retentionLastRunDesc, prometheus.GaugeValue, float64(stats.LastRun.UnixNano())/1e9)
+	}
+}
@@ -0,0 +1,60 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/nuts-foundation/go-stoabs/retention"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRetentionCollector(t *testing.T) {
+	store := stores.BBolt(t)
+	require.NoError(t, store.WriteShelf(ctx, "test", func(writer stoabs.Writer) error {
+		for i := 0; i < 5; i++ {
+			if err := writer.Put(stoabs.Uint32Key(i), []byte("value")); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	engine := retention.New(store, retention.WithRule(retention.Rule{Shelf: "test", MaxEntries: 2}))
+	collector := NewRetentionCollector(engine)
+
+	// not yet run
+	err := testutil.CollectAndCompare(collector, strings.NewReader(""))
+	require.NoError(t, err)
+
+	_, err = engine.Enforce(ctx)
+	require.NoError(t, err)
+	err = testutil.CollectAndCompare(collector, strings.NewReader(`
+# HELP stoabs_retention_purged_total Number of entries deleted by retention rules, by shelf.
+# TYPE stoabs_retention_purged_total counter
+stoabs_retention_purged_total{shelf="test"} 3
+`), "stoabs_retention_purged_total")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, testutil.CollectAndCount(collector))
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collectTimeout is the maximum duration of reading the statistics from a store when metrics are collected.
+const collectTimeout = 5 * time.Second
+
+var (
+	shelfEntriesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "shelf", "entries"),
+		"Number of entries in a shelf.",
+		[]string{"shelf"}, nil,
+	)
+	shelfSizeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "shelf", "size_bytes"),
+		"Size of a shelf in bytes, as reported by the store.",
+		[]string{"shelf"}, nil,
+	)
+)
+
+// NewShelfCollector returns a collector reporting the number of entries and size of all shelves of the given store.
+// The store must implement stoabs.ShelfLister.
+func NewShelfCollector(store stoabs.KVStore) prometheus.Collector {
+	return &shelfCollector{store: store}
+}
+
+type shelfCollector struct {
+	store stoabs.KVStore
+}
+
+func (c *shelfCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- shelfEntriesDesc
+	ch <- shelfSizeDesc
+}
+
+func (c *shelfCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), collectTimeout)
+	defer cancel()
+	names, err := stoabs.ShelfNames(ctx, c.store)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(shelfEntriesDesc, err)
+		return
+	}
+	err = c.store.Read(ctx, func(tx stoabs.ReadTx) error {
+		for _, name := range names {
+			stats := tx.GetShelfReader(name).Stats()
+			ch <- prometheus.MustNewConstMetric(shelfEntriesDesc, prometheus.GaugeValue, float64(stats.NumEntries), name)
+			ch <- prometheus.MustNewConstMetric(shelfSizeDesc, prometheus.GaugeValue, float64(stats.ShelfSize), name)
+		}
+		return nil
+	})
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(shelfEntriesDesc, err)
+	}
+}
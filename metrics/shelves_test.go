@@ -0,0 +1,59 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewShelfCollector(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		store := stores.BBolt(t)
+		require.NoError(t, store.Write(ctx, func(tx stoabs.WriteTx) error {
+			_ = tx.GetShelfWriter("a").Put(stoabs.BytesKey("key"), []byte("value"))
+			_ = tx.GetShelfWriter("b").Put(stoabs.BytesKey("key1"), []byte("value"))
+			return tx.GetShelfWriter("b").Put(stoabs.BytesKey("key2"), []byte("value"))
+		}))
+		collector := NewShelfCollector(store)
+
+		err := testutil.CollectAndCompare(collector, strings.NewReader(`
+# HELP stoabs_shelf_entries Number of entries in a shelf.
+# TYPE stoabs_shelf_entries gauge
+stoabs_shelf_entries{shelf="a"} 1
+stoabs_shelf_entries{shelf="b"} 2
+`), "stoabs_shelf_entries")
+
+		assert.NoError(t, err)
+		assert.Equal(t, 4, testutil.CollectAndCount(collector))
+	})
+	t.Run("store can't list shelves", func(t *testing.T) {
+		collector := NewShelfCollector(struct{ stoabs.KVStore }{stores.BBolt(t)})
+
+		_, err := testutil.CollectAndLint(collector)
+
+		assert.ErrorContains(t, err, "unsupported operation")
+	})
+}
@@ -0,0 +1,209 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package metrics
+
+import (
+	"context"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	storeSizeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "store", "size_bytes"),
+		"Size of the database in bytes, as reported by the store.",
+		nil, nil,
+	)
+	storeFreePagesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "store", "free_pages"),
+		"Number of free pages in the database file (BBolt).",
+		nil, nil,
+	)
+	storeMemoryDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "store", "memory_usage_bytes"),
+		"Memory used by the database server in bytes (Redis).",
+		nil, nil,
+	)
+	storeMemoryLimitDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "store", "memory_limit_bytes"),
+		"Maximum memory the database server may use in bytes (Redis). Not reported if unlimited.",
+		nil, nil,
+	)
+	storeShelvesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "store", "shelves"),
+		"Number of shelves in the store.",
+		nil, nil,
+	)
+	storeOpenTransactionsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "store", "open_transactions"),
+		"Number of in-flight transactions.",
+		nil, nil,
+	)
+	storeLastCompactionDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "store", "last_compaction_timestamp_seconds"),
+		"Time the database was last compacted, as Unix timestamp. Not reported if unknown.",
+		nil, nil,
+	)
+	storeConflictsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "store", "conflicts_total"),
+		"Number of write transactions that failed because of a conflicting concurrent transaction (Badger).",
+		nil, nil,
+	)
+	storeEvictionsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "store", "evictions_total"),
+		"Number of entries evicted by the database server while watching evictions (Redis).",
+		nil, nil,
+	)
+	storeLastBackupDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "store", "last_backup_timestamp_seconds"),
+		"Time the last backup of the database was made, as Unix timestamp. Not reported if unknown.",
+		nil, nil,
+	)
+)
+
+// pageMetrics describes the page-level statistics (see stoabs.PageStats), which are only reported if the store supports them.
+var pageMetrics = []struct {
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+	value     func(stats *stoabs.PageStats) float64
+}{
+	{pageDesc("page_allocations_total", "Number of page allocations."), prometheus.CounterValue,
+		func(stats *stoabs.PageStats) float64 { return float64(stats.PageAllocations) }},
+	{pageDesc("page_allocated_bytes_total", "Number of bytes allocated for pages."), prometheus.CounterValue,
+		func(stats *stoabs.PageStats) float64 { return float64(stats.PageAllocatedBytes) }},
+	{pageDesc("node_allocations_total", "Number of node allocations."), prometheus.CounterValue,
+		func(stats *stoabs.PageStats) float64 { return float64(stats.NodeAllocations) }},
+	{pageDesc("node_dereferences_total", "Number of node dereferences."), prometheus.CounterValue,
+		func(stats *stoabs.PageStats) float64 { return float64(stats.NodeDereferences) }},
+	{pageDesc("node_rebalances_total", "Number of node rebalances."), prometheus.CounterValue,
+		func(stats *stoabs.PageStats) float64 { return float64(stats.Rebalances) }},
+	{pageDesc("node_rebalance_seconds_total", "Time spent rebalancing nodes."), prometheus.CounterValue,
+		func(stats *stoabs.PageStats) float64 { return stats.RebalanceDuration.Seconds() }},
+	{pageDesc("node_splits_total", "Number of nodes split."), prometheus.CounterValue,
+		func(stats *stoabs.PageStats) float64 { return float64(stats.Splits) }},
+	{pageDesc("node_spills_total", "Number of nodes spilled when committing."), prometheus.CounterValue,
+		func(stats *stoabs.PageStats) float64 { return float64(stats.Spills) }},
+	{pageDesc("node_spill_seconds_total", "Time spent spilling nodes."), prometheus.CounterValue,
+		func(stats *stoabs.PageStats) float64 { return stats.SpillDuration.Seconds() }},
+	{pageDesc("page_writes_total", "Number of page writes."), prometheus.CounterValue,
+		func(stats *stoabs.PageStats) float64 { return float64(stats.Writes) }},
+	{pageDesc("page_write_seconds_total", "Time spent writing pages to disk."), prometheus.CounterValue,
+		func(stats *stoabs.PageStats) float64 { return stats.WriteDuration.Seconds() }},
+	{pageDesc("free_bytes", "Number of bytes allocated in free pages."), prometheus.GaugeValue,
+		func(stats *stoabs.PageStats) float64 { return float64(stats.FreeBytes) }},
+	{pageDesc("freelist_bytes", "Number of bytes used by the freelist."), prometheus.GaugeValue,
+		func(stats *stoabs.PageStats) float64 { return float64(stats.FreelistBytes) }},
+	{pageDesc("read_transactions_total", "Number of read transactions started."), prometheus.CounterValue,
+		func(stats *stoabs.PageStats) float64 { return float64(stats.ReadTransactions) }},
+}
+
+// diskMetrics describes the statistics about writing committed transactions to disk (see stoabs.DiskStats),
+// which are only reported if the store supports them.
+var diskMetrics = []struct {
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+	value     func(stats *stoabs.DiskStats) float64
+}{
+	{diskDesc("commits_total", "Number of committed write transactions."), prometheus.CounterValue,
+		func(stats *stoabs.DiskStats) float64 { return float64(stats.Commits) }},
+	{diskDesc("put_bytes_total", "Number of logical bytes (keys and values) put by committed transactions."), prometheus.CounterValue,
+		func(stats *stoabs.DiskStats) float64 { return float64(stats.BytesPut) }},
+	{diskDesc("disk_written_bytes_total", "Number of bytes written to the database file when committing."), prometheus.CounterValue,
+		func(stats *stoabs.DiskStats) float64 { return float64(stats.BytesWritten) }},
+	{diskDesc("syncs_total", "Number of times the database file was synced when committing."), prometheus.CounterValue,
+		func(stats *stoabs.DiskStats) float64 { return float64(stats.Syncs) }},
+	{diskDesc("sync_seconds_total", "Time spent flushing committed transactions to disk, including syncing."), prometheus.CounterValue,
+		func(stats *stoabs.DiskStats) float64 { return stats.SyncDuration.Seconds() }},
+	{diskDesc("write_amplification_ratio", "Number of bytes written to disk per logical byte put."), prometheus.GaugeValue,
+		func(stats *stoabs.DiskStats) float64 { return stats.WriteAmplification() }},
+}
+
+func diskDesc(name string, help string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(namespace, "store", name), help+" Only reported by stores that report disk statistics (BBolt).", nil, nil)
+}
+
+func pageDesc(name string, help string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(namespace, "store", name), help+" Only reported by page-based stores (BBolt).", nil, nil)
+}
+
+// NewStoreCollector returns a collector reporting the statistics of the given store (see stoabs.Stats).
+// The store must implement stoabs.StatsReader.
+func NewStoreCollector(store stoabs.KVStore) prometheus.Collector {
+	return &storeCollector{store: store}
+}
+
+type storeCollector struct {
+	store stoabs.KVStore
+}
+
+func (c *storeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- storeSizeDesc
+	ch <- storeFreePagesDesc
+	ch <- storeMemoryDesc
+	ch <- storeShelvesDesc
+	ch <- storeOpenTransactionsDesc
+	ch <- storeLastCompactionDesc
+	ch <- storeLastBackupDesc
+	ch <- storeConflictsDesc
+	ch <- storeMemoryLimitDesc
+	ch <- storeEvictionsDesc
+	for _, metric := range pageMetrics {
+		ch <- metric.desc
+	}
+	for _, metric := range diskMetrics {
+		ch <- metric.desc
+	}
+}
+
+func (c *storeCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), collectTimeout)
+	defer cancel()
+	stats, err := stoabs.Stats(ctx, c.store)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(storeSizeDesc, err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(storeSizeDesc, prometheus.GaugeValue, float64(stats.Size))
+	ch <- prometheus.MustNewConstMetric(storeFreePagesDesc, prometheus.GaugeValue, float64(stats.FreePages))
+	ch <- prometheus.MustNewConstMetric(storeMemoryDesc, prometheus.GaugeValue, float64(stats.MemoryUsage))
+	ch <- prometheus.MustNewConstMetric(storeShelvesDesc, prometheus.GaugeValue, float64(stats.Shelves))
+	ch <- prometheus.MustNewConstMetric(storeOpenTransactionsDesc, prometheus.GaugeValue, float64(stats.OpenTransactions))
+	ch <- prometheus.MustNewConstMetric(storeConflictsDesc, prometheus.CounterValue, float64(stats.Conflicts))
+	ch <- prometheus.MustNewConstMetric(storeEvictionsDesc, prometheus.CounterValue, float64(stats.Evictions))
+	if stats.MemoryLimit > 0 {
+		ch <- prometheus.MustNewConstMetric(storeMemoryLimitDesc, prometheus.GaugeValue, float64(stats.MemoryLimit))
+	}
+	if !stats.LastCompaction.IsZero() {
+		ch <- prometheus.MustNewConstMetric(storeLastCompactionDesc, prometheus.GaugeValue, float64(stats.LastCompaction.Unix()))
+	}
+	if !stats.LastBackup.IsZero() {
+		ch <- prometheus.MustNewConstMetric(storeLastBackupDesc, prometheus.GaugeValue, float64(stats.LastBackup.Unix()))
+	}
+	if stats.Pages != nil {
+		for _, metric := range pageMetrics {
+			ch <- prometheus.MustNewConstMetric(metric.desc, metric.valueType, metric.value(stats.Pages))
+		}
+	}
+	if stats.Disk != nil {
+		for _, metric := range diskMetrics {
+			ch <- prometheus.MustNewConstMetric(metric.desc, metric.valueType, metric.value(stats.Disk))
+		}
+	}
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/nuts-foundation/go-stoabs/mocks"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewStoreCollector(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		store := stores.BBolt(t)
+		require.NoError(t, store.Write(ctx, func(tx stoabs.WriteTx) error {
+			_ = tx.GetShelfWriter("a").Put(stoabs.BytesKey("key"), []byte("value"))
+			return tx.GetShelfWriter("b").Put(stoabs.BytesKey("key"), []byte("value"))
+		}))
+		collector := NewStoreCollector(store)
+
+		err := testutil.CollectAndCompare(collector, strings.NewReader(`
+# HELP stoabs_store_open_transactions Number of in-flight transactions.
+# TYPE stoabs_store_open_transactions gauge
+stoabs_store_open_transactions 0
+# HELP stoabs_store_shelves Number of shelves in the store.
+# TYPE stoabs_store_shelves gauge
+stoabs_store_shelves 2
+`), "stoabs_store_shelves", "stoabs_store_open_transactions")
+
+		assert.NoError(t, err)
+		// timestamps are unknown, so they're not reported
+		assert.Equal(t, 7+len(pageMetrics)+len(diskMetrics), testutil.CollectAndCount(collector))
+		problems, err := testutil.CollectAndLint(collector)
+		assert.NoError(t, err)
+		assert.Empty(t, problems)
+	})
+	t.Run("page statistics", func(t *testing.T) {
+		store := stores.BBolt(t)
+		require.NoError(t, store.WriteShelf(ctx, "a", func(writer stoabs.Writer) error {
+			return writer.Put(stoabs.BytesKey("key"), []byte("value"))
+		}))
+		collector := NewStoreCollector(store)
+
+		assert.Equal(t, 1, testutil.CollectAndCount(collector, "stoabs_store_page_writes_total"))
+		assert.Equal(t, 1, testutil.CollectAndCount(collector, "stoabs_store_node_spills_total"))
+	})
+	t.Run("disk statistics", func(t *testing.T) {
+		store := stores.BBolt(t)
+		require.NoError(t, store.WriteShelf(ctx, "a", func(writer stoabs.Writer) error {
+			return writer.Put(stoabs.BytesKey("key"), []byte("value"))
+		}))
+		collector := NewStoreCollector(store)
+
+		err := testutil.CollectAndCompare(collector, strings.NewReader(`
+# HELP stoabs_store_commits_total Number of committed write transactions. Only reported by stores that report disk statistics (BBolt).
+# TYPE stoabs_store_commits_total counter
+stoabs_store_commits_total 1
+# HELP stoabs_store_put_bytes_total Number of logical bytes (keys and values) put by committed transactions. Only reported by stores that report disk statistics (BBolt).
+# TYPE stoabs_store_put_bytes_total counter
+stoabs_store_put_bytes_total 8
+`), "stoabs_store_commits_total", "stoabs_store_put_bytes_total")
+
+		assert.NoError(t, err)
+		assert.Equal(t, 1, testutil.CollectAndCount(collector, "stoabs_store_write_amplification_ratio"))
+	})
+	t.Run("store without page statistics", func(t *testing.T) {
+		collector := NewStoreCollector(mocks.NewFake())
+
+		assert.Equal(t, 7, testutil.CollectAndCount(collector))
+	})
+	t.Run("store can't report stats", func(t *testing.T) {
+		collector := NewStoreCollector(struct{ stoabs.KVStore }{stores.BBolt(t)})
+
+		_, err := testutil.CollectAndLint(collector)
+
+		assert.ErrorContains(t, err, "unsupported operation")
+	})
+}
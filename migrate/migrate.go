@@ -0,0 +1,245 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package migrate copies data between KVStores, e.g. when moving from one backend to another.
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nuts-foundation/go-stoabs"
+)
+
+const defaultChunkSize = 1000
+
+// checkpointShelf is the shelf in the destination store that keeps track of shelves that have been copied completely.
+const checkpointShelf = stoabs.ReservedShelfPrefix + "migrate"
+
+// Progress describes the progress of a Copy operation.
+type Progress struct {
+	// Shelf is the name of the shelf currently being copied.
+	Shelf string
+	// ShelfEntries is the number of entries copied from the current shelf.
+	ShelfEntries int
+	// TotalEntries is the number of entries copied in total.
+	TotalEntries int
+	// ShelvesDone is the number of shelves that have been copied completely (or skipped because of a checkpoint).
+	ShelvesDone int
+	// ShelvesTotal is the number of shelves that will be copied.
+	ShelvesTotal int
+}
+
+// Option configures a Copy operation.
+type Option func(cfg *config)
+
+type config struct {
+	shelves     []string
+	chunkSize   int
+	progress    func(Progress)
+	checkpoints bool
+	keyTypes    map[string]stoabs.Key
+}
+
+// WithShelves limits the copy to the given shelves. By default, all shelves are copied (the reserved shelves only if the context
+// includes them, see stoabs.ContextWithReservedShelves), which requires the source store to implement stoabs.ShelfLister.
+func WithShelves(shelves ...string) Option {
+	return func(cfg *config) {
+		cfg.shelves = shelves
+	}
+}
+
+// WithKeyType specifies the type of the keys of the given shelf, which is used to read the keys from the source and
+// write them to the destination. It's required when copying to or from Redis, which stores keys in their string form
+// (e.g. numbers in decimal), for consumers to be able to find the copied entries using their original key type.
+// Shelves without key type are copied with stoabs.BytesKey.
+func WithKeyType(shelf string, keyType stoabs.Key) Option {
+	return func(cfg *config) {
+		if cfg.keyTypes == nil {
+			cfg.keyTypes = map[string]stoabs.Key{}
+		}
+		cfg.keyTypes[shelf] = keyType
+	}
+}
+
+// WithChunkSize sets the maximum number of entries written to the destination store in a single transaction.
+func WithChunkSize(chunkSize int) Option {
+	return func(cfg *config) {
+		cfg.chunkSize = chunkSize
+	}
+}
+
+// WithProgress registers a callback that is invoked after every chunk written to the destination store.
+func WithProgress(fn func(Progress)) Option {
+	return func(cfg *config) {
+		cfg.progress = fn
+	}
+}
+
+// WithCheckpoints makes the copy resumable: shelves that have been copied completely are recorded in the destination store,
+// and skipped when Copy is invoked again after it was interrupted. A shelf that was being copied when the copy was
+// interrupted is copied again from the start, which is safe since writing the same entries is idempotent.
+// The checkpoints are removed when the copy completes successfully.
+func WithCheckpoints() Option {
+	return func(cfg *config) {
+		cfg.checkpoints = true
+	}
+}
+
+// Copy copies all entries of the shelves in src to dst, overwriting existing entries with the same key.
+// Each shelf is read in a single read transaction, and written in chunks (see WithChunkSize).
+// Source and destination must be different stores.
+// Keys are copied as stoabs.BytesKey unless specified otherwise using WithKeyType, see dump.Export for the implications for Redis.
+func Copy(ctx context.Context, src, dst stoabs.KVStore, opts ...Option) error {
+	cfg := config{chunkSize: defaultChunkSize}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.chunkSize <= 0 {
+		return errors.New("chunk size must be greater than 0")
+	}
+
+	shelves := cfg.shelves
+	if len(shelves) == 0 {
+		var err error
+		shelves, err = stoabs.ShelfNames(ctx, src)
+		if err != nil {
+			return fmt.Errorf("unable to list shelves: %w", err)
+		}
+	}
+
+	progress := Progress{ShelvesTotal: len(shelves)}
+	for _, shelf := range shelves {
+		if shelf == checkpointShelf {
+			// src was the destination of an interrupted copy
+			progress.ShelvesTotal--
+			continue
+		}
+		progress.Shelf = shelf
+		progress.ShelfEntries = 0
+		if cfg.checkpoints {
+			done, err := isCopied(ctx, dst, shelf)
+			if err != nil {
+				return err
+			}
+			if done {
+				progress.ShelvesDone++
+				continue
+			}
+		}
+		if err := copyShelf(ctx, src, dst, shelf, cfg, &progress); err != nil {
+			return fmt.Errorf("unable to copy shelf %s: %w", shelf, err)
+		}
+		if cfg.checkpoints {
+			err := dst.WriteShelf(ctx, checkpointShelf, func(writer stoabs.Writer) error {
+				return writer.Put(stoabs.BytesKey(shelf), []byte{1})
+			})
+			if err != nil {
+				return fmt.Errorf("unable to write checkpoint: %w", err)
+			}
+		}
+		progress.ShelvesDone++
+	}
+
+	if cfg.checkpoints {
+		return clearCheckpoints(ctx, dst, shelves)
+	}
+	return nil
+}
+
+type entry struct {
+	key   stoabs.Key
+	value []byte
+}
+
+func copyShelf(ctx context.Context, src, dst stoabs.KVStore, shelf string, cfg config, progress *Progress) error {
+	chunk := make([]entry, 0, cfg.chunkSize)
+	flush := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		err := dst.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+			for _, e := range chunk {
+				if err := writer.Put(e.key, e.value); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		progress.ShelfEntries += len(chunk)
+		progress.TotalEntries += len(chunk)
+		chunk = chunk[:0]
+		if cfg.progress != nil {
+			cfg.progress(*progress)
+		}
+		return nil
+	}
+	keyType, ok := cfg.keyTypes[shelf]
+	if !ok {
+		keyType = stoabs.BytesKey{}
+	}
+	err := src.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+		return reader.Iterate(func(key stoabs.Key, value []byte) error {
+			// copy the value, since some backends only guarantee its validity during the callback
+			chunk = append(chunk, entry{key: key, value: append(value[:0:0], value...)})
+			if len(chunk) == cfg.chunkSize {
+				return flush()
+			}
+			return nil
+		}, keyType)
+	})
+	if err != nil {
+		return err
+	}
+	return flush()
+}
+
+func isCopied(ctx context.Context, dst stoabs.KVStore, shelf string) (bool, error) {
+	var done bool
+	err := dst.ReadShelf(ctx, checkpointShelf, func(reader stoabs.Reader) error {
+		_, err := reader.Get(stoabs.BytesKey(shelf))
+		if errors.Is(err, stoabs.ErrKeyNotFound) {
+			return nil
+		}
+		done = err == nil
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("unable to read checkpoint: %w", err)
+	}
+	return done, nil
+}
+
+func clearCheckpoints(ctx context.Context, dst stoabs.KVStore, shelves []string) error {
+	err := dst.WriteShelf(ctx, checkpointShelf, func(writer stoabs.Writer) error {
+		for _, shelf := range shelves {
+			if err := writer.Delete(stoabs.BytesKey(shelf)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to clear checkpoints: %w", err)
+	}
+	return nil
+}
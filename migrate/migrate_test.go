@@ -0,0 +1,170 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package migrate
+
+import (
+	"context"
+	"errors"
+	"path"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/bbolt"
+	"github.com/nuts-foundation/go-stoabs/redis7"
+	"github.com/nuts-foundation/go-stoabs/util"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+var ctx = context.Background()
+
+func TestCopy(t *testing.T) {
+	t.Run("bbolt to Redis", func(t *testing.T) {
+		src := createBBoltStore(t)
+		writeEntries(t, src, "a", 5)
+		writeEntries(t, src, "b", 3)
+		mr := miniredis.RunT(t)
+		dst, err := redis7.CreateRedisStore("db", &redis.Options{Addr: mr.Addr()})
+		require.NoError(t, err)
+		defer dst.Close(ctx)
+
+		err = Copy(ctx, src, dst)
+
+		require.NoError(t, err)
+		assert.Equal(t, readEntries(t, src, "a"), readEntries(t, dst, "a"))
+		assert.Equal(t, readEntries(t, src, "b"), readEntries(t, dst, "b"))
+	})
+	t.Run("bbolt to Redis, with key type", func(t *testing.T) {
+		src := createBBoltStore(t)
+		writeEntries(t, src, "a", 5)
+		mr := miniredis.RunT(t)
+		dst, err := redis7.CreateRedisStore("db", &redis.Options{Addr: mr.Addr()})
+		require.NoError(t, err)
+		defer dst.Close(ctx)
+
+		err = Copy(ctx, src, dst, WithKeyType("a", stoabs.Uint32Key(0)))
+
+		require.NoError(t, err)
+		err = dst.ReadShelf(ctx, "a", func(reader stoabs.Reader) error {
+			for i := 0; i < 5; i++ {
+				value, err := reader.Get(stoabs.Uint32Key(i))
+				if err != nil {
+					return err
+				}
+				assert.Equal(t, []byte{byte(i)}, value)
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+	})
+	t.Run("selected shelves, chunked with progress", func(t *testing.T) {
+		src := createBBoltStore(t)
+		writeEntries(t, src, "a", 5)
+		writeEntries(t, src, "b", 3)
+		dst := createBBoltStore(t)
+		var progress []Progress
+
+		err := Copy(ctx, src, dst, WithShelves("a"), WithChunkSize(2), WithProgress(func(p Progress) {
+			progress = append(progress, p)
+		}))
+
+		require.NoError(t, err)
+		assert.Len(t, readEntries(t, dst, "a"), 5)
+		assert.Empty(t, readEntries(t, dst, "b"))
+		require.Len(t, progress, 3)
+		assert.Equal(t, Progress{Shelf: "a", ShelfEntries: 5, TotalEntries: 5, ShelvesTotal: 1}, progress[2])
+	})
+	t.Run("resume with checkpoints", func(t *testing.T) {
+		src := createBBoltStore(t)
+		writeEntries(t, src, "a", 2)
+		writeEntries(t, src, "b", 2)
+		dst := createBBoltStore(t)
+		interruptedCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		// interrupt while copying shelf b
+		err := Copy(interruptedCtx, src, dst, WithCheckpoints(), WithChunkSize(1), WithProgress(func(p Progress) {
+			if p.Shelf == "b" {
+				cancel()
+			}
+		}))
+		require.ErrorIs(t, err, context.Canceled)
+		// now remove shelf a's data from the source, to assert it isn't copied again
+		require.NoError(t, src.WriteShelf(ctx, "a", func(writer stoabs.Writer) error {
+			return writer.Delete(stoabs.Uint32Key(0))
+		}))
+		var copiedShelves []string
+
+		err = Copy(ctx, src, dst, WithCheckpoints(), WithProgress(func(p Progress) {
+			copiedShelves = append(copiedShelves, p.Shelf)
+		}))
+
+		require.NoError(t, err)
+		assert.Equal(t, []string{"b"}, copiedShelves)
+		assert.Len(t, readEntries(t, dst, "a"), 2)
+		assert.Len(t, readEntries(t, dst, "b"), 2)
+		assert.Empty(t, readEntries(t, dst, checkpointShelf), "checkpoints should be cleared")
+	})
+	t.Run("source can't list shelves", func(t *testing.T) {
+		err := Copy(ctx, stoabs.NewMockKVStore(gomock.NewController(t)), createBBoltStore(t))
+
+		assert.ErrorIs(t, err, errors.ErrUnsupported)
+	})
+	t.Run("invalid chunk size", func(t *testing.T) {
+		err := Copy(ctx, createBBoltStore(t), createBBoltStore(t), WithChunkSize(0))
+
+		assert.EqualError(t, err, "chunk size must be greater than 0")
+	})
+}
+
+func createBBoltStore(t *testing.T) stoabs.KVStore {
+	store, err := bbolt.CreateBBoltStore(path.Join(util.TestDirectory(t), "bbolt.db"), stoabs.WithNoSync())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = store.Close(context.Background())
+	})
+	return store
+}
+
+func writeEntries(t *testing.T, store stoabs.KVStore, shelf string, count int) {
+	err := store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+		for i := 0; i < count; i++ {
+			if err := writer.Put(stoabs.Uint32Key(i), []byte{byte(i)}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func readEntries(t *testing.T, store stoabs.KVStore, shelf string) map[string][]byte {
+	result := map[string][]byte{}
+	err := store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+		return reader.Iterate(func(key stoabs.Key, value []byte) error {
+			result[key.String()] = value
+			return nil
+		}, stoabs.BytesKey{})
+	})
+	require.NoError(t, err)
+	return result
+}
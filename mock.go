@@ -373,6 +373,127 @@ func (mr *MockStoreMockRecorder) Close(ctx any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockStore)(nil).Close), ctx)
 }
 
+// MockShelfLister is a mock of ShelfLister interface.
+type MockShelfLister struct {
+	ctrl     *gomock.Controller
+	recorder *MockShelfListerMockRecorder
+	isgomock struct{}
+}
+
+// MockShelfListerMockRecorder is the mock recorder for MockShelfLister.
+type MockShelfListerMockRecorder struct {
+	mock *MockShelfLister
+}
+
+// NewMockShelfLister creates a new mock instance.
+func NewMockShelfLister(ctrl *gomock.Controller) *MockShelfLister {
+	mock := &MockShelfLister{ctrl: ctrl}
+	mock.recorder = &MockShelfListerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockShelfLister) EXPECT() *MockShelfListerMockRecorder {
+	return m.recorder
+}
+
+// ShelfNames mocks base method.
+func (m *MockShelfLister) ShelfNames(ctx context.Context) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ShelfNames", ctx)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ShelfNames indicates an expected call of ShelfNames.
+func (mr *MockShelfListerMockRecorder) ShelfNames(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ShelfNames", reflect.TypeOf((*MockShelfLister)(nil).ShelfNames), ctx)
+}
+
+// MockLocker is a mock of Locker interface.
+type MockLocker struct {
+	ctrl     *gomock.Controller
+	recorder *MockLockerMockRecorder
+	isgomock struct{}
+}
+
+// MockLockerMockRecorder is the mock recorder for MockLocker.
+type MockLockerMockRecorder struct {
+	mock *MockLocker
+}
+
+// NewMockLocker creates a new mock instance.
+func NewMockLocker(ctrl *gomock.Controller) *MockLocker {
+	mock := &MockLocker{ctrl: ctrl}
+	mock.recorder = &MockLockerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockLocker) EXPECT() *MockLockerMockRecorder {
+	return m.recorder
+}
+
+// LockKeys mocks base method.
+func (m *MockLocker) LockKeys(ctx context.Context, shelfName string, keys ...Key) (func(), error) {
+	m.ctrl.T.Helper()
+	varargs := []any{ctx, shelfName}
+	for _, a := range keys {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "LockKeys", varargs...)
+	ret0, _ := ret[0].(func())
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LockKeys indicates an expected call of LockKeys.
+func (mr *MockLockerMockRecorder) LockKeys(ctx, shelfName any, keys ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{ctx, shelfName}, keys...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LockKeys", reflect.TypeOf((*MockLocker)(nil).LockKeys), varargs...)
+}
+
+// MockMerger is a mock of Merger interface.
+type MockMerger struct {
+	ctrl     *gomock.Controller
+	recorder *MockMergerMockRecorder
+	isgomock struct{}
+}
+
+// MockMergerMockRecorder is the mock recorder for MockMerger.
+type MockMergerMockRecorder struct {
+	mock *MockMerger
+}
+
+// NewMockMerger creates a new mock instance.
+func NewMockMerger(ctrl *gomock.Controller) *MockMerger {
+	mock := &MockMerger{ctrl: ctrl}
+	mock.recorder = &MockMergerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockMerger) EXPECT() *MockMergerMockRecorder {
+	return m.recorder
+}
+
+// Merge mocks base method.
+func (m *MockMerger) Merge(key Key, operand []byte) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Merge", key, operand)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Merge indicates an expected call of Merge.
+func (mr *MockMergerMockRecorder) Merge(key, operand any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Merge", reflect.TypeOf((*MockMerger)(nil).Merge), key, operand)
+}
+
 // MockTxOption is a mock of TxOption interface.
 type MockTxOption struct {
 	ctrl     *gomock.Controller
@@ -0,0 +1,92 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package mocks
+
+import (
+	"github.com/nuts-foundation/go-stoabs"
+	"sync"
+	"time"
+)
+
+var _ stoabs.Clock = (*Clock)(nil)
+
+// Clock is a fake stoabs.Clock for tests: time only passes when Advance or Set is called.
+type Clock struct {
+	mux     sync.Mutex
+	now     time.Time
+	waiters []clockWaiter
+}
+
+type clockWaiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+// NewClock creates a fake Clock that is set to the given time.
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.now
+}
+
+// After returns a channel that receives the time once the clock is advanced by the given duration.
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	result := make(chan time.Time, 1)
+	if d <= 0 {
+		result <- c.now
+		return result
+	}
+	c.waiters = append(c.waiters, clockWaiter{at: c.now.Add(d), c: result})
+	return result
+}
+
+// Advance moves the clock forward by the given duration, firing the timers (see After) that are due.
+func (c *Clock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set sets the clock to the given time, firing the timers (see After) that are due.
+func (c *Clock) Set(now time.Time) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.now = now
+	pending := c.waiters[:0]
+	for _, waiter := range c.waiters {
+		if waiter.at.After(now) {
+			pending = append(pending, waiter)
+		} else {
+			waiter.c <- now
+		}
+	}
+	c.waiters = pending
+}
+
+// Waiters returns the number of timers (see After) that haven't fired yet.
+// Tests can use it to wait until the code under test is waiting for the clock, before advancing it.
+func (c *Clock) Waiters() int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return len(c.waiters)
+}
@@ -0,0 +1,50 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package mocks
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewClock(start)
+	minute := clock.After(time.Minute)
+	hour := clock.After(time.Hour)
+	assert.Equal(t, 2, clock.Waiters())
+
+	clock.Advance(30 * time.Minute)
+
+	assert.Equal(t, start.Add(30*time.Minute), clock.Now())
+	assert.Equal(t, start.Add(30*time.Minute), <-minute)
+	assert.Len(t, hour, 0)
+	assert.Equal(t, 1, clock.Waiters())
+
+	t.Run("set", func(t *testing.T) {
+		clock.Set(start.Add(2 * time.Hour))
+
+		assert.Equal(t, start.Add(2*time.Hour), <-hour)
+		assert.Equal(t, 0, clock.Waiters())
+	})
+	t.Run("zero duration fires immediately", func(t *testing.T) {
+		assert.Equal(t, clock.Now(), <-clock.After(0))
+	})
+}
@@ -0,0 +1,400 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package mocks contains gomock-generated mocks of the stoabs interfaces (see mock.go) and Fake, an in-memory
+// stoabs.KVStore with scripted failure injection for use in tests.
+package mocks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/util"
+	"sort"
+	"sync"
+)
+
+var _ stoabs.KVStore = (*Fake)(nil)
+var _ stoabs.ShelfLister = (*Fake)(nil)
+var _ stoabs.StateReporter = (*Fake)(nil)
+var _ stoabs.StatsReader = (*Fake)(nil)
+var _ stoabs.CapabilityReporter = (*Fake)(nil)
+
+// Fake is an in-memory stoabs.KVStore for use in tests. Write transactions are serialized and applied atomically
+// when committed, read transactions see the state of the last commit.
+// Failures can be injected using FailCommit and FailOnKey.
+type Fake struct {
+	// writeMux serializes write transactions.
+	writeMux util.ContextRWLocker
+	// mux guards shelves and closed. Committed shelves are never modified (write transactions work on a copy),
+	// so transactions can read them without holding the lock.
+	mux     sync.RWMutex
+	shelves map[string]map[string][]byte
+	closed  bool
+	// drainer tracks in-flight transactions, so Close can wait for them
+	drainer util.Drainer
+
+	failuresMux sync.Mutex
+	// commits is the number of commits attempted since the fake was created.
+	commits int
+	// commitFailures maps the number of a commit (as counted by commits) to the error it must fail with.
+	commitFailures map[int]error
+	// keyFailures maps a shelf and key to the error operations on it must fail with.
+	keyFailures map[string]map[string]error
+}
+
+// NewFake creates a new, empty Fake.
+func NewFake() *Fake {
+	return &Fake{
+		shelves:        map[string]map[string][]byte{},
+		commitFailures: map[int]error{},
+		keyFailures:    map[string]map[string]error{},
+	}
+}
+
+// FailCommit makes the n-th commit of a write transaction after this call (starting at 1) fail with the given error,
+// wrapped in stoabs.ErrCommitFailed. The changes of the transaction are then discarded.
+func (f *Fake) FailCommit(n int, err error) {
+	f.failuresMux.Lock()
+	defer f.failuresMux.Unlock()
+	f.commitFailures[f.commits+n] = err
+}
+
+// FailOnKey makes Get, Put and Delete of the given key on the given shelf return the given error.
+// Passing a nil error removes the failure.
+func (f *Fake) FailOnKey(shelfName string, key stoabs.Key, err error) {
+	f.failuresMux.Lock()
+	defer f.failuresMux.Unlock()
+	if err == nil {
+		delete(f.keyFailures[shelfName], string(key.Bytes()))
+		return
+	}
+	if f.keyFailures[shelfName] == nil {
+		f.keyFailures[shelfName] = map[string]error{}
+	}
+	f.keyFailures[shelfName][string(key.Bytes())] = err
+}
+
+// Commits returns the number of commits of write transactions attempted, including the failed ones.
+func (f *Fake) Commits() int {
+	f.failuresMux.Lock()
+	defer f.failuresMux.Unlock()
+	return f.commits
+}
+
+func (f *Fake) keyFailure(shelfName string, key stoabs.Key) error {
+	f.failuresMux.Lock()
+	defer f.failuresMux.Unlock()
+	return f.keyFailures[shelfName][string(key.Bytes())]
+}
+
+// nextCommit registers a commit attempt and returns the error it must fail with, if any.
+func (f *Fake) nextCommit() error {
+	f.failuresMux.Lock()
+	defer f.failuresMux.Unlock()
+	f.commits++
+	err := f.commitFailures[f.commits]
+	delete(f.commitFailures, f.commits)
+	return err
+}
+
+func (f *Fake) Close(ctx context.Context) error {
+	return f.drainer.Close(ctx, func() error {
+		f.mux.Lock()
+		defer f.mux.Unlock()
+		f.closed = true
+		return nil
+	})
+}
+
+func (f *Fake) Capabilities() stoabs.Capabilities {
+	return stoabs.CapabilityShelfLister | stoabs.CapabilityState | stoabs.CapabilityStats | stoabs.CapabilityFreeze |
+		stoabs.CapabilityOrderedIteration
+}
+
+func (f *Fake) State() stoabs.State {
+	return f.drainer.State()
+}
+
+func (f *Fake) Freeze(ctx context.Context) error {
+	return f.drainer.Freeze(ctx)
+}
+
+func (f *Fake) Unfreeze() {
+	f.drainer.Unfreeze()
+}
+
+func (f *Fake) Frozen() bool {
+	return f.drainer.Frozen()
+}
+
+// Stats returns the number of shelves and open transactions. Size is the total size of the keys and values.
+func (f *Fake) Stats(_ context.Context) (stoabs.StoreStats, error) {
+	shelves, err := f.snapshot()
+	if err != nil {
+		return stoabs.StoreStats{}, err
+	}
+	result := stoabs.StoreStats{Shelves: len(shelves), OpenTransactions: f.drainer.InFlight()}
+	for _, entries := range shelves {
+		for key, value := range entries {
+			result.Size += uint64(len(key) + len(value))
+		}
+	}
+	return result, nil
+}
+
+func (f *Fake) Write(ctx context.Context, fn func(stoabs.WriteTx) error, opts ...stoabs.TxOption) error {
+	ctx, done, err := f.drainer.BeginWrite(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	if err := f.writeMux.LockContextHolder(ctx, (stoabs.LabelOption{}).Label(opts)); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = stoabs.ErrLockTimeout{Lock: "write lock", Holder: f.writeMux.Holder()}
+		}
+		return fmt.Errorf("unable to obtain write lock: %w", stoabs.DatabaseError(err))
+	}
+	shelves, err := f.snapshot()
+	if err != nil {
+		f.writeMux.Unlock()
+		return err
+	}
+	tx := &fakeTx{store: f, ctx: ctx, shelves: copyShelves(shelves)}
+	if err := fn(tx); err != nil {
+		f.writeMux.Unlock()
+		stoabs.OnRollbackOption{}.Invoke(opts)
+		return err
+	}
+	err = f.nextCommit()
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		f.writeMux.Unlock()
+		stoabs.OnRollbackOption{}.Invoke(opts)
+		return util.WrapError(stoabs.ErrCommitFailed, err)
+	}
+	f.mux.Lock()
+	f.shelves = tx.shelves
+	f.mux.Unlock()
+	f.writeMux.Unlock()
+	stoabs.AfterCommitOption{}.Invoke(opts)
+	return nil
+}
+
+func (f *Fake) Read(ctx context.Context, fn func(stoabs.ReadTx) error) error {
+	ctx, done, err := f.drainer.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	shelves, err := f.snapshot()
+	if err != nil {
+		return err
+	}
+	return fn(&fakeTx{store: f, ctx: ctx, shelves: shelves})
+}
+
+func (f *Fake) WriteShelf(ctx context.Context, shelfName string, fn func(stoabs.Writer) error) error {
+	return f.Write(ctx, func(tx stoabs.WriteTx) error {
+		return fn(tx.GetShelfWriter(shelfName))
+	})
+}
+
+func (f *Fake) ReadShelf(ctx context.Context, shelfName string, fn func(stoabs.Reader) error) error {
+	return f.Read(ctx, func(tx stoabs.ReadTx) error {
+		return fn(tx.GetShelfReader(shelfName))
+	})
+}
+
+func (f *Fake) ShelfNames(ctx context.Context) ([]string, error) {
+	shelves, err := f.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, 0, len(shelves))
+	for name := range shelves {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return stoabs.FilterReservedShelves(ctx, result), nil
+}
+
+// snapshot returns the shelves as of the last commit, which must not be modified.
+func (f *Fake) snapshot() (map[string]map[string][]byte, error) {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+	if f.closed {
+		return nil, stoabs.ErrStoreIsClosed
+	}
+	return f.shelves, nil
+}
+
+func copyShelves(shelves map[string]map[string][]byte) map[string]map[string][]byte {
+	result := make(map[string]map[string][]byte, len(shelves))
+	for name, entries := range shelves {
+		shelfCopy := make(map[string][]byte, len(entries))
+		for key, value := range entries {
+			shelfCopy[key] = value
+		}
+		result[name] = shelfCopy
+	}
+	return result
+}
+
+type fakeTx struct {
+	store   *Fake
+	ctx     context.Context
+	shelves map[string]map[string][]byte
+}
+
+func (t *fakeTx) GetShelfReader(shelfName string) stoabs.Reader {
+	if invalid := stoabs.InvalidShelf(shelfName); invalid != nil {
+		return invalid
+	}
+	entries, ok := t.shelves[shelfName]
+	if !ok {
+		return stoabs.NilReader{}
+	}
+	return &fakeShelf{tx: t, name: shelfName, entries: entries}
+}
+
+func (t *fakeTx) GetShelfWriter(shelfName string) stoabs.Writer {
+	if invalid := stoabs.InvalidShelf(shelfName); invalid != nil {
+		return invalid
+	}
+	entries, ok := t.shelves[shelfName]
+	if !ok {
+		entries = map[string][]byte{}
+		t.shelves[shelfName] = entries
+	}
+	return &fakeShelf{tx: t, name: shelfName, entries: entries}
+}
+
+func (t *fakeTx) Store() stoabs.KVStore {
+	return t.store
+}
+
+func (t *fakeTx) Unwrap() interface{} {
+	return nil
+}
+
+type fakeShelf struct {
+	tx      *fakeTx
+	name    string
+	entries map[string][]byte
+}
+
+func (s *fakeShelf) Empty() (bool, error) {
+	return len(s.entries) == 0, nil
+}
+
+func (s *fakeShelf) Get(key stoabs.Key) ([]byte, error) {
+	if err := s.tx.store.keyFailure(s.name, key); err != nil {
+		return nil, err
+	}
+	value, ok := s.entries[string(key.Bytes())]
+	if !ok {
+		return nil, stoabs.ErrKeyNotFound
+	}
+	return bytes.Clone(value), nil
+}
+
+func (s *fakeShelf) Put(key stoabs.Key, value []byte) error {
+	if err := s.tx.store.keyFailure(s.name, key); err != nil {
+		return err
+	}
+	s.entries[string(key.Bytes())] = bytes.Clone(value)
+	return nil
+}
+
+func (s *fakeShelf) Delete(key stoabs.Key) error {
+	if err := s.tx.store.keyFailure(s.name, key); err != nil {
+		return err
+	}
+	delete(s.entries, string(key.Bytes()))
+	return nil
+}
+
+func (s *fakeShelf) Iterate(callback stoabs.CallerFn, keyType stoabs.Key) error {
+	for _, k := range s.sortedKeys() {
+		if s.tx.ctx.Err() != nil {
+			return stoabs.DatabaseError(s.tx.ctx.Err())
+		}
+		key, err := keyType.FromBytes([]byte(k))
+		if err != nil {
+			return err
+		}
+		if err := callback(key, bytes.Clone(s.entries[k])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fakeShelf) Range(from stoabs.Key, to stoabs.Key, callback stoabs.CallerFn, stopAtNil bool) error {
+	var prevKey stoabs.Key
+	for _, k := range s.sortedKeys() {
+		if bytes.Compare([]byte(k), from.Bytes()) < 0 {
+			continue
+		}
+		if bytes.Compare([]byte(k), to.Bytes()) >= 0 {
+			break
+		}
+		if s.tx.ctx.Err() != nil {
+			return stoabs.DatabaseError(s.tx.ctx.Err())
+		}
+		key, err := from.FromBytes([]byte(k))
+		if err != nil {
+			return err
+		}
+		if stopAtNil && prevKey != nil && !prevKey.Next().Equals(key) {
+			// gap found, stop here
+			return nil
+		}
+		if err := callback(key, bytes.Clone(s.entries[k])); err != nil {
+			return err
+		}
+		prevKey = key
+	}
+	return nil
+}
+
+func (s *fakeShelf) Stats() stoabs.ShelfStats {
+	size := 0
+	for key, value := range s.entries {
+		size += len(key) + len(value)
+	}
+	return stoabs.ShelfStats{
+		NumEntries: uint(len(s.entries)),
+		ShelfSize:  uint(size),
+	}
+}
+
+// sortedKeys returns the keys of the shelf in byte order, so callbacks may modify the shelf while iterating.
+func (s *fakeShelf) sortedKeys() []string {
+	keys := make([]string, 0, len(s.entries))
+	for key := range s.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
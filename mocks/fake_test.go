@@ -0,0 +1,147 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package mocks
+
+import (
+	"context"
+	"errors"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+const shelf = "test"
+
+var key = stoabs.BytesKey("key")
+var value = []byte("value")
+
+func TestFake(t *testing.T) {
+	provider := func(t *testing.T) (stoabs.KVStore, error) {
+		return NewFake(), nil
+	}
+
+	kvtests.TestReadingAndWriting(t, provider)
+	kvtests.TestRange(t, provider)
+	kvtests.TestAggregate(t, provider)
+	kvtests.TestIterate(t, provider)
+	kvtests.TestOrderedIteration(t, provider)
+	kvtests.TestEmpty(t, provider)
+	kvtests.TestClose(t, provider)
+	kvtests.TestDelete(t, provider)
+	kvtests.TestStats(t, provider)
+	kvtests.TestWriteTransactions(t, provider)
+	kvtests.TestTransactionWriteLock(t, provider)
+	kvtests.TestByteTransparency(t, provider)
+	kvtests.TestLinearizability(t, provider)
+	kvtests.TestErrors(t, provider)
+	kvtests.TestCapabilities(t, provider, kvtests.CapabilityShelfLister, kvtests.CapabilityState, kvtests.CapabilityStats, kvtests.CapabilityFreeze)
+}
+
+func TestFake_FailCommit(t *testing.T) {
+	ctx := context.Background()
+	store := NewFake()
+	failure := errors.New("disk full")
+	put := func() error {
+		return store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+			return writer.Put(key, value)
+		})
+	}
+	require.NoError(t, put())
+
+	store.FailCommit(2, failure)
+
+	assert.NoError(t, put())
+	var rolledBack bool
+	err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+		return tx.GetShelfWriter(shelf).Delete(key)
+	}, stoabs.OnRollback(func() {
+		rolledBack = true
+	}))
+	assert.ErrorIs(t, err, failure)
+	assert.ErrorIs(t, err, stoabs.ErrCommitFailed)
+	assert.True(t, rolledBack)
+	assert.Equal(t, 3, store.Commits())
+	// changes of the failed transaction are discarded
+	err = store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+		actual, err := reader.Get(key)
+		assert.Equal(t, value, actual)
+		return err
+	})
+	assert.NoError(t, err)
+	// only the scripted commit fails
+	assert.NoError(t, put())
+}
+
+func TestFake_FailOnKey(t *testing.T) {
+	ctx := context.Background()
+	store := NewFake()
+	failure := errors.New("corrupt page")
+	otherKey := key.Next()
+
+	store.FailOnKey(shelf, key, failure)
+
+	err := store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+		assert.ErrorIs(t, writer.Put(key, value), failure)
+		assert.ErrorIs(t, writer.Delete(key), failure)
+		_, err := writer.Get(key)
+		assert.ErrorIs(t, err, failure)
+		return writer.Put(otherKey, value)
+	})
+	assert.NoError(t, err)
+
+	t.Run("removed", func(t *testing.T) {
+		store.FailOnKey(shelf, key, nil)
+
+		err := store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+			return writer.Put(key, value)
+		})
+
+		assert.NoError(t, err)
+	})
+}
+
+func TestFake_Isolation(t *testing.T) {
+	ctx := context.Background()
+	store := NewFake()
+
+	err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+		if err := tx.GetShelfWriter(shelf).Put(key, value); err != nil {
+			return err
+		}
+		// uncommitted writes aren't visible to other transactions
+		return store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+			empty, err := reader.Empty()
+			assert.True(t, empty)
+			return err
+		})
+	})
+	assert.NoError(t, err)
+
+	t.Run("closed", func(t *testing.T) {
+		require.NoError(t, store.Close(ctx))
+
+		err := store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+			return nil
+		})
+
+		assert.ErrorIs(t, err, stoabs.ErrStoreIsClosed)
+	})
+}
@@ -0,0 +1,302 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package namespace provides views of a KVStore that are restricted to the shelves of a single tenant.
+package namespace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/nuts-foundation/go-stoabs"
+)
+
+// shelfPrefix is prepended to the shelf names of all namespaces, followed by the tenant and a slash.
+const shelfPrefix = stoabs.ReservedShelfPrefix + "namespace/"
+
+// dropBatchSize specifies how many keys are deleted in a single transaction when dropping a namespace.
+const dropBatchSize = 1000
+
+var _ stoabs.KVStore = (*Store)(nil)
+var _ stoabs.ShelfLister = (*Store)(nil)
+
+// New returns a view of the store that only contains the shelves of the given tenant.
+// Shelves of the tenant are stored in the underlying store as _stoabs/namespace/<tenant>/<shelf>, so the tenant must not
+// be empty or contain a slash. Closing the view doesn't close the underlying store.
+// Transactions started on the view return nil from Unwrap, to prevent accessing other namespaces through the underlying database.
+func New(store stoabs.KVStore, tenant string) (*Store, error) {
+	if err := validate(tenant); err != nil {
+		return nil, err
+	}
+	return &Store{underlying: store, tenant: tenant, prefix: prefix(tenant)}, nil
+}
+
+// Store is a view of a KVStore restricted to a single tenant. Use New to create it.
+type Store struct {
+	underlying stoabs.KVStore
+	tenant     string
+	prefix     string
+	closed     atomic.Bool
+}
+
+// Stats contains statistics about a namespace.
+type Stats struct {
+	// Shelves holds the number of shelves in the namespace.
+	Shelves int
+	// NumEntries holds the total number of entries in the shelves of the namespace.
+	NumEntries uint
+	// Size holds the total size of the shelves of the namespace in bytes, as reported by the store.
+	Size uint
+}
+
+// Tenant returns the tenant of the namespace.
+func (s *Store) Tenant() string {
+	return s.tenant
+}
+
+// Close marks the view as closed, after which its operations return stoabs.ErrStoreIsClosed.
+// It doesn't close the underlying store.
+func (s *Store) Close(_ context.Context) error {
+	s.closed.Store(true)
+	return nil
+}
+
+func (s *Store) Write(ctx context.Context, fn func(stoabs.WriteTx) error, opts ...stoabs.TxOption) error {
+	if s.closed.Load() {
+		return stoabs.ErrStoreIsClosed
+	}
+	return s.underlying.Write(ctx, func(underlyingTx stoabs.WriteTx) error {
+		return fn(&writeTx{readTx: readTx{ReadTx: underlyingTx, store: s}, underlying: underlyingTx})
+	}, opts...)
+}
+
+func (s *Store) Read(ctx context.Context, fn func(stoabs.ReadTx) error) error {
+	if s.closed.Load() {
+		return stoabs.ErrStoreIsClosed
+	}
+	return s.underlying.Read(ctx, func(underlyingTx stoabs.ReadTx) error {
+		return fn(&readTx{ReadTx: underlyingTx, store: s})
+	})
+}
+
+func (s *Store) WriteShelf(ctx context.Context, shelfName string, fn func(stoabs.Writer) error) error {
+	if s.closed.Load() {
+		return stoabs.ErrStoreIsClosed
+	}
+	return s.underlying.WriteShelf(ctx, s.prefix+shelfName, fn)
+}
+
+func (s *Store) ReadShelf(ctx context.Context, shelfName string, fn func(stoabs.Reader) error) error {
+	if s.closed.Load() {
+		return stoabs.ErrStoreIsClosed
+	}
+	return s.underlying.ReadShelf(ctx, s.prefix+shelfName, fn)
+}
+
+// ShelfNames returns the shelves of the namespace. The underlying store must implement stoabs.ShelfLister.
+// The shelves of namespaces are reserved in the underlying store, but within the namespace only its own reserved shelves
+// (e.g. the journal of a journaled namespace) are hidden, unless the context includes them (see stoabs.ContextWithReservedShelves).
+func (s *Store) ShelfNames(ctx context.Context) ([]string, error) {
+	if s.closed.Load() {
+		return nil, stoabs.ErrStoreIsClosed
+	}
+	names, err := stoabs.ShelfNames(stoabs.ContextWithReservedShelves(ctx), s.underlying)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, 0)
+	for _, name := range names {
+		if strings.HasPrefix(name, s.prefix) {
+			result = append(result, name[len(s.prefix):])
+		}
+	}
+	return stoabs.FilterReservedShelves(ctx, result), nil
+}
+
+// Stats returns statistics about the shelves of the namespace. The underlying store must implement stoabs.ShelfLister.
+func (s *Store) Stats(ctx context.Context) (Stats, error) {
+	names, err := s.ShelfNames(stoabs.ContextWithReservedShelves(ctx))
+	if err != nil {
+		return Stats{}, err
+	}
+	result := Stats{Shelves: len(names)}
+	err = s.Read(ctx, func(tx stoabs.ReadTx) error {
+		for _, name := range names {
+			stats := tx.GetShelfReader(name).Stats()
+			result.NumEntries += stats.NumEntries
+			result.Size += stats.ShelfSize
+		}
+		return nil
+	})
+	if err != nil {
+		return Stats{}, err
+	}
+	return result, nil
+}
+
+// List returns the tenants that have shelves in the store, sorted alphabetically.
+// The store must implement stoabs.ShelfLister.
+func List(ctx context.Context, store stoabs.KVStore) ([]string, error) {
+	names, err := stoabs.ShelfNames(stoabs.ContextWithReservedShelves(ctx), store)
+	if err != nil {
+		return nil, err
+	}
+	tenants := map[string]struct{}{}
+	for _, name := range names {
+		if tenant, _, ok := Parse(name); ok {
+			tenants[tenant] = struct{}{}
+		}
+	}
+	result := make([]string, 0, len(tenants))
+	for tenant := range tenants {
+		result = append(result, tenant)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// Parse returns the tenant and shelf name of a shelf of the underlying store, if it belongs to a namespace.
+func Parse(underlyingShelf string) (tenant string, shelfName string, ok bool) {
+	if !strings.HasPrefix(underlyingShelf, shelfPrefix) {
+		return "", "", false
+	}
+	return strings.Cut(underlyingShelf[len(shelfPrefix):], "/")
+}
+
+// DropOption configures Drop.
+type DropOption func(cfg *dropConfig)
+
+type dropConfig struct {
+	keyTypes map[string]stoabs.Key
+}
+
+// WithKeyType specifies the type of the keys of the given shelf of the namespace, used to iterate over it while dropping.
+// It's required for shelves with other keys than stoabs.BytesKey in Redis, which stores keys in their string form.
+func WithKeyType(shelfName string, keyType stoabs.Key) DropOption {
+	return func(cfg *dropConfig) {
+		cfg.keyTypes[shelfName] = keyType
+	}
+}
+
+// Drop deletes all entries of the tenant's namespace. The store must implement stoabs.ShelfLister.
+// Entries are deleted in batches, each batch in its own transaction, so when an error occurs the namespace may be
+// partially deleted; dropping it again deletes the remaining entries.
+// Keys are read as stoabs.BytesKey, unless specified otherwise using WithKeyType.
+// Backends that keep empty shelves (e.g. BBolt) will still list the empty shelves afterwards.
+func Drop(ctx context.Context, store stoabs.KVStore, tenant string, opts ...DropOption) error {
+	cfg := dropConfig{keyTypes: map[string]stoabs.Key{}}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	ns, err := New(store, tenant)
+	if err != nil {
+		return err
+	}
+	names, err := ns.ShelfNames(stoabs.ContextWithReservedShelves(ctx))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		keyType, ok := cfg.keyTypes[name]
+		if !ok {
+			keyType = stoabs.BytesKey{}
+		}
+		if err := dropShelf(ctx, ns, name, keyType); err != nil {
+			return fmt.Errorf("unable to drop shelf %s of namespace %s: %w", name, tenant, err)
+		}
+	}
+	return nil
+}
+
+var errBatchFull = errors.New("batch full")
+
+func dropShelf(ctx context.Context, store stoabs.KVStore, shelfName string, keyType stoabs.Key) error {
+	for {
+		var keys []stoabs.Key
+		err := store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			return reader.Iterate(func(key stoabs.Key, _ []byte) error {
+				keys = append(keys, key)
+				if len(keys) == dropBatchSize {
+					return errBatchFull
+				}
+				return nil
+			}, keyType)
+		})
+		if err != nil && !errors.Is(err, errBatchFull) {
+			return err
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+		err = store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			for _, key := range keys {
+				if err := writer.Delete(key); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func validate(tenant string) error {
+	if tenant == "" {
+		return errors.New("tenant must not be empty")
+	}
+	if strings.Contains(tenant, "/") {
+		return fmt.Errorf("tenant must not contain a slash: %s", tenant)
+	}
+	return nil
+}
+
+func prefix(tenant string) string {
+	return shelfPrefix + tenant + "/"
+}
+
+type readTx struct {
+	stoabs.ReadTx
+	store *Store
+}
+
+func (t *readTx) GetShelfReader(shelfName string) stoabs.Reader {
+	return t.ReadTx.GetShelfReader(t.store.prefix + shelfName)
+}
+
+func (t *readTx) Store() stoabs.KVStore {
+	return t.store
+}
+
+func (t *readTx) Unwrap() interface{} {
+	return nil
+}
+
+type writeTx struct {
+	readTx
+	underlying stoabs.WriteTx
+}
+
+func (t *writeTx) GetShelfWriter(shelfName string) stoabs.Writer {
+	return t.underlying.GetShelfWriter(t.store.prefix + shelfName)
+}
@@ -0,0 +1,233 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package namespace
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/nuts-foundation/go-stoabs/redis7"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+func TestNamespace(t *testing.T) {
+	provider := func(t *testing.T) (stoabs.KVStore, error) {
+		return New(stores.BBolt(t), "tenant")
+	}
+
+	kvtests.TestReadingAndWriting(t, provider)
+	kvtests.TestRange(t, provider)
+	kvtests.TestIterate(t, provider)
+	kvtests.TestEmpty(t, provider)
+	kvtests.TestDelete(t, provider)
+	kvtests.TestStats(t, provider)
+	kvtests.TestWriteTransactions(t, provider)
+	kvtests.TestShelfNames(t, provider)
+}
+
+func TestNew(t *testing.T) {
+	store := stores.BBolt(t)
+
+	t.Run("empty tenant", func(t *testing.T) {
+		_, err := New(store, "")
+
+		assert.EqualError(t, err, "tenant must not be empty")
+	})
+	t.Run("tenant with slash", func(t *testing.T) {
+		_, err := New(store, "a/b")
+
+		assert.EqualError(t, err, "tenant must not contain a slash: a/b")
+	})
+}
+
+func TestStore_isolation(t *testing.T) {
+	store := stores.BBolt(t)
+	tenantA, _ := New(store, "a")
+	tenantB, _ := New(store, "b")
+	require.NoError(t, put(tenantA, "users", "alice", "A"))
+	require.NoError(t, put(tenantB, "users", "alice", "B"))
+
+	t.Run("tenants have separate shelves", func(t *testing.T) {
+		assert.Equal(t, "A", get(t, tenantA, "users", "alice"))
+		assert.Equal(t, "B", get(t, tenantB, "users", "alice"))
+	})
+	t.Run("shelves are invisible outside of namespace", func(t *testing.T) {
+		err := store.ReadShelf(ctx, "users", func(reader stoabs.Reader) error {
+			empty, err := reader.Empty()
+			assert.True(t, empty)
+			return err
+		})
+		require.NoError(t, err)
+	})
+	t.Run("transactions", func(t *testing.T) {
+		err := tenantA.Write(ctx, func(tx stoabs.WriteTx) error {
+			assert.Same(t, tenantA, tx.Store())
+			return tx.GetShelfWriter("users").Put(stoabs.BytesKey("bob"), []byte("A"))
+		})
+		require.NoError(t, err)
+
+		err = tenantB.Read(ctx, func(tx stoabs.ReadTx) error {
+			assert.Same(t, tenantB, tx.Store())
+			_, err := tx.GetShelfReader("users").Get(stoabs.BytesKey("bob"))
+			return err
+		})
+		assert.ErrorIs(t, err, stoabs.ErrKeyNotFound)
+	})
+	t.Run("list tenants", func(t *testing.T) {
+		require.NoError(t, put(store, "other", "key", "value"))
+
+		tenants, err := List(ctx, store)
+
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a", "b"}, tenants)
+	})
+	t.Run("closing the namespace leaves the store open", func(t *testing.T) {
+		tenant, _ := New(store, "c")
+
+		require.NoError(t, tenant.Close(ctx))
+
+		assert.ErrorIs(t, put(tenant, "users", "alice", "C"), stoabs.ErrStoreIsClosed)
+		assert.NoError(t, put(store, "other", "key", "value"))
+	})
+}
+
+func TestStore_Stats(t *testing.T) {
+	store := stores.BBolt(t)
+	tenant, _ := New(store, "tenant")
+	other, _ := New(store, "other")
+	require.NoError(t, put(tenant, "a", "1", "value"))
+	require.NoError(t, put(tenant, "a", "2", "value"))
+	require.NoError(t, put(tenant, "b", "1", "value"))
+	require.NoError(t, put(other, "a", "1", "value"))
+
+	stats, err := tenant.Stats(ctx)
+
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.Shelves)
+	assert.Equal(t, uint(3), stats.NumEntries)
+	assert.Less(t, uint(0), stats.Size)
+}
+
+func TestDrop(t *testing.T) {
+	store := stores.BBolt(t)
+	tenant, _ := New(store, "tenant")
+	other, _ := New(store, "other")
+	err := tenant.WriteShelf(ctx, "large", func(writer stoabs.Writer) error {
+		for i := 0; i < dropBatchSize*2+1; i++ {
+			if err := writer.Put(stoabs.Uint32Key(i), []byte("value")); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, put(tenant, "small", "key", "value"))
+	require.NoError(t, put(other, "small", "key", "value"))
+
+	err = Drop(ctx, store, "tenant")
+
+	require.NoError(t, err)
+	stats, err := tenant.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint(0), stats.NumEntries)
+	assert.Equal(t, "value", get(t, other, "small", "key"))
+
+	t.Run("invalid tenant", func(t *testing.T) {
+		assert.Error(t, Drop(ctx, store, ""))
+	})
+	t.Run("store doesn't support listing shelves", func(t *testing.T) {
+		err := Drop(ctx, struct{ stoabs.KVStore }{store}, "tenant")
+
+		assert.True(t, errors.Is(err, errors.ErrUnsupported))
+	})
+}
+
+func TestDrop_Redis(t *testing.T) {
+	mr := miniredis.RunT(t)
+	store, err := redis7.CreateRedisStore("db", &redis.Options{Addr: mr.Addr()})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = store.Close(ctx)
+	})
+	tenant, _ := New(store, "tenant")
+	err = tenant.WriteShelf(ctx, "numbers", func(writer stoabs.Writer) error {
+		return writer.Put(stoabs.Uint32Key(1), []byte("value"))
+	})
+	require.NoError(t, err)
+
+	err = Drop(ctx, store, "tenant", WithKeyType("numbers", stoabs.Uint32Key(0)))
+
+	require.NoError(t, err)
+	err = tenant.ReadShelf(ctx, "numbers", func(reader stoabs.Reader) error {
+		_, err := reader.Get(stoabs.Uint32Key(1))
+		return err
+	})
+	assert.ErrorIs(t, err, stoabs.ErrKeyNotFound)
+}
+
+func TestParse(t *testing.T) {
+	tenant, shelfName, ok := Parse("_stoabs/namespace/tenant/users")
+	assert.True(t, ok)
+	assert.Equal(t, "tenant", tenant)
+	assert.Equal(t, "users", shelfName)
+
+	_, _, ok = Parse("users")
+	assert.False(t, ok)
+}
+
+func TestStore_Unwrap(t *testing.T) {
+	tenant, _ := New(stores.BBolt(t), "tenant")
+
+	err := tenant.Write(ctx, func(tx stoabs.WriteTx) error {
+		assert.Nil(t, tx.Unwrap())
+		return nil
+	})
+	require.NoError(t, err)
+	err = tenant.Read(ctx, func(tx stoabs.ReadTx) error {
+		assert.Nil(t, tx.Unwrap())
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func put(store stoabs.KVStore, shelf, key, value string) error {
+	return store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+		return writer.Put(stoabs.BytesKey(key), []byte(value))
+	})
+}
+
+func get(t *testing.T, store stoabs.KVStore, shelf, key string) string {
+	var result []byte
+	err := store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+		var err error
+		result, err = reader.Get(stoabs.BytesKey(key))
+		return err
+	})
+	require.NoError(t, err)
+	return string(result)
+}
@@ -0,0 +1,379 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package outbox publishes the mutations recorded by a cdc.Store to a message broker (transactional outbox).
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/cdc"
+	"github.com/sirupsen/logrus"
+)
+
+// shelfPrefix prefixes the pending-outbox shelf in the outbox store, which is followed by the name of the bridge.
+// The shelf holds the pending messages keyed by sequence number (stoabs.Uint64Key), key 0 holds the outbox state.
+const shelfPrefix = stoabs.ReservedShelfPrefix + "outbox/"
+
+const stateKey = stoabs.Uint64Key(0)
+
+const defaultName = "default"
+
+const defaultBatchSize = 100
+
+const defaultInterval = time.Second
+
+// errBatchFull stops tailing the journal when a batch is complete.
+var errBatchFull = errors.New("batch full")
+
+// Message is a journal entry to publish.
+type Message struct {
+	// ID uniquely identifies the message: it's the offset of the entry in the journal. Messages are delivered at least once,
+	// so consumers must use it to ignore redelivered messages.
+	ID string
+	// Subject is the subject (or topic, or routing key) to publish the message to, see WithSubject.
+	Subject string
+	// Entry is the published mutation.
+	Entry cdc.Entry
+}
+
+// Publisher publishes messages to a message broker, e.g. NATS JetStream, Kafka or AMQP.
+type Publisher interface {
+	// Publish publishes the given messages in order. It must only return nil if the broker acknowledged all messages:
+	// if it returns an error, all messages are published again.
+	Publish(ctx context.Context, messages []Message) error
+}
+
+// PublisherFunc is a function that implements Publisher.
+type PublisherFunc func(ctx context.Context, messages []Message) error
+
+// Publish calls f.
+func (f PublisherFunc) Publish(ctx context.Context, messages []Message) error {
+	return f(ctx, messages)
+}
+
+// Stats contains the outbox counters.
+type Stats struct {
+	// Staged counts the journal entries added to the pending-outbox shelf.
+	Staged uint64
+	// Published counts the messages acknowledged by the broker.
+	Published uint64
+	// Failures counts failed publications.
+	Failures uint64
+	// Lost counts the journal entries that were removed by the journal's retention (see cdc.WithRetention) before they were staged.
+	Lost uint64
+}
+
+// Option configures the Bridge.
+type Option func(b *Bridge)
+
+// WithName sets the name of the bridge, which defaults to "default". It determines the pending-outbox shelf,
+// so bridges publishing to different brokers from the same outbox store must have different names.
+func WithName(name string) Option {
+	return func(b *Bridge) {
+		b.name = name
+	}
+}
+
+// WithShelves specifies the shelves of which mutations are published. By default, mutations of all shelves are published,
+// except those of the reserved shelves used internally by stoabs packages.
+func WithShelves(shelves ...string) Option {
+	return func(b *Bridge) {
+		b.shelves = map[string]bool{}
+		for _, shelf := range shelves {
+			b.shelves[shelf] = true
+		}
+	}
+}
+
+// WithSubject sets the function that determines the subject of the message of an entry, which defaults to the name of the shelf.
+func WithSubject(subject func(entry cdc.Entry) string) Option {
+	return func(b *Bridge) {
+		b.subject = subject
+	}
+}
+
+// WithBatchSize sets the maximum number of journal entries staged in a single transaction, and of messages published in a single call.
+func WithBatchSize(batchSize int) Option {
+	return func(b *Bridge) {
+		b.batchSize = batchSize
+	}
+}
+
+// WithInterval sets the time Run waits after all messages were published, or after a failure. It defaults to 1 second.
+func WithInterval(interval time.Duration) Option {
+	return func(b *Bridge) {
+		b.interval = interval
+	}
+}
+
+// WithClock overrides the clock that determines when Run publishes, which defaults to stoabs.SystemClock.
+func WithClock(clock stoabs.Clock) Option {
+	return func(b *Bridge) {
+		b.clock = clock
+	}
+}
+
+// WithLogger overrides the default logger.
+func WithLogger(log *logrus.Logger) Option {
+	return func(b *Bridge) {
+		b.log = log
+	}
+}
+
+// New creates a Bridge that publishes the mutations committed on source using the given publisher.
+// Journal entries are first staged in the pending-outbox shelf of the outbox store, together with the offset of the next entry
+// to stage, in a single transaction. Staging doesn't depend on the broker, so entries aren't removed from the journal by its
+// retention (see cdc.WithRetention) while the broker is unavailable. Pending messages are published in order, and removed from
+// the outbox after the broker acknowledged them (at-least-once delivery).
+// The outbox store is typically the store wrapped by source. It must not be source itself, since its writes would be journaled.
+func New(source *cdc.Store, outbox stoabs.KVStore, publisher Publisher, opts ...Option) *Bridge {
+	result := &Bridge{
+		source:    source,
+		outbox:    outbox,
+		publisher: publisher,
+		name:      defaultName,
+		subject: func(entry cdc.Entry) string {
+			return entry.Shelf
+		},
+		batchSize: defaultBatchSize,
+		interval:  defaultInterval,
+		clock:     stoabs.SystemClock,
+		log:       logrus.StandardLogger(),
+	}
+	for _, opt := range opts {
+		opt(result)
+	}
+	return result
+}
+
+// Bridge publishes mutations recorded by a cdc.Store to a message broker. Use New to create it.
+type Bridge struct {
+	source    *cdc.Store
+	outbox    stoabs.KVStore
+	publisher Publisher
+	name      string
+	shelves   map[string]bool
+	subject   func(entry cdc.Entry) string
+	batchSize int
+	interval  time.Duration
+	clock     stoabs.Clock
+	log       *logrus.Logger
+
+	// mux serializes syncs
+	mux       sync.Mutex
+	staged    atomic.Uint64
+	published atomic.Uint64
+	failures  atomic.Uint64
+	lost      atomic.Uint64
+}
+
+// state is stored at stateKey in the pending-outbox shelf.
+type state struct {
+	// Offset is the offset of the next journal entry to stage, 0 if no entries have been staged yet.
+	Offset uint64 `json:"offset"`
+	// First is the sequence number of the oldest pending message.
+	First uint64 `json:"first"`
+	// Next is the sequence number that will be assigned to the next pending message.
+	Next uint64 `json:"next"`
+}
+
+// Stats returns the outbox counters.
+func (b *Bridge) Stats() Stats {
+	return Stats{Staged: b.staged.Load(), Published: b.published.Load(), Failures: b.failures.Load(), Lost: b.lost.Load()}
+}
+
+// Run stages and publishes messages until the given context is cancelled.
+// Errors are logged and retried after the interval (see WithInterval).
+func (b *Bridge) Run(ctx context.Context) {
+	for {
+		err := b.Sync(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			b.log.WithError(err).Warn("Publishing mutations failed, retrying")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-b.clock.After(b.interval):
+		}
+	}
+}
+
+// Sync stages all journal entries committed on the source store so far, and then publishes all pending messages.
+// If publishing fails, the messages stay in the outbox and are published by the next Sync.
+func (b *Bridge) Sync(ctx context.Context) error {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	for {
+		staged, err := b.stage(ctx)
+		if err != nil {
+			return err
+		}
+		if staged < b.batchSize {
+			break
+		}
+	}
+	for {
+		published, err := b.publish(ctx)
+		if err != nil {
+			return err
+		}
+		if published < b.batchSize {
+			return nil
+		}
+	}
+}
+
+// stage moves a batch of journal entries to the pending-outbox shelf. It returns the number of visited journal entries.
+func (b *Bridge) stage(ctx context.Context) (int, error) {
+	// Sync is serialized, so the state doesn't change until it's written
+	current, err := b.readState(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var entries []cdc.Entry
+	collect := func(entry cdc.Entry) error {
+		if len(entries) == b.batchSize {
+			return errBatchFull
+		}
+		entries = append(entries, entry)
+		return nil
+	}
+	next, err := b.source.Tail(ctx, current.Offset, collect)
+	if errors.Is(err, cdc.ErrOffsetExpired) {
+		// the entries are gone, continue at the oldest entry
+		next, err = b.source.Tail(ctx, 0, collect)
+		if len(entries) > 0 {
+			lost := entries[0].Offset - current.Offset
+			b.lost.Add(lost)
+			b.log.Errorf("Journal entries were removed before they were published, increase the journal retention (lost=%d)", lost)
+		}
+	}
+	if err != nil && !errors.Is(err, errBatchFull) {
+		return 0, fmt.Errorf("unable to read journal: %w", err)
+	}
+	if next == current.Offset {
+		return 0, nil
+	}
+	var staged uint64
+	err = b.outbox.WriteShelf(ctx, b.shelfName(), func(writer stoabs.Writer) error {
+		pending := stoabs.JSONShelf[cdc.Entry](writer)
+		updated := current
+		staged = 0
+		for _, entry := range entries {
+			if !b.publishes(entry.Shelf) {
+				continue
+			}
+			if err := pending.Put(stoabs.Uint64Key(updated.Next), entry); err != nil {
+				return err
+			}
+			updated.Next++
+			staged++
+		}
+		updated.Offset = next
+		return stoabs.JSONShelf[state](writer).Put(stateKey, updated)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("unable to stage messages: %w", err)
+	}
+	b.staged.Add(staged)
+	return len(entries), nil
+}
+
+// publish publishes a batch of pending messages, and removes them from the outbox. It returns the number of published messages.
+func (b *Bridge) publish(ctx context.Context) (int, error) {
+	current, err := b.readState(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var messages []Message
+	err = b.outbox.ReadShelf(ctx, b.shelfName(), func(reader stoabs.Reader) error {
+		pending := stoabs.JSONShelf[cdc.Entry](reader)
+		for seq := current.First; seq < current.Next && len(messages) < b.batchSize; seq++ {
+			entry, err := pending.Get(stoabs.Uint64Key(seq))
+			if err != nil {
+				return fmt.Errorf("unable to read pending message (seq=%d): %w", seq, err)
+			}
+			messages = append(messages, Message{ID: strconv.FormatUint(entry.Offset, 10), Subject: b.subject(entry), Entry: entry})
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("unable to read outbox: %w", err)
+	}
+	if len(messages) == 0 {
+		return 0, nil
+	}
+	if err := b.publisher.Publish(ctx, messages); err != nil {
+		b.failures.Add(1)
+		return 0, fmt.Errorf("unable to publish messages: %w", err)
+	}
+	b.published.Add(uint64(len(messages)))
+	err = b.outbox.WriteShelf(ctx, b.shelfName(), func(writer stoabs.Writer) error {
+		updated := current
+		for ; updated.First < current.First+uint64(len(messages)); updated.First++ {
+			if err := writer.Delete(stoabs.Uint64Key(updated.First)); err != nil {
+				return err
+			}
+		}
+		return stoabs.JSONShelf[state](writer).Put(stateKey, updated)
+	})
+	if err != nil {
+		// the messages are published again by the next sync
+		return 0, fmt.Errorf("unable to remove published messages from outbox: %w", err)
+	}
+	return len(messages), nil
+}
+
+// publishes returns true if mutations of the given shelf are published, see WithShelves.
+func (b *Bridge) publishes(shelfName string) bool {
+	if b.shelves != nil {
+		return b.shelves[shelfName]
+	}
+	return !stoabs.IsReservedShelfName(shelfName)
+}
+
+func (b *Bridge) shelfName() string {
+	return shelfPrefix + b.name
+}
+
+func (b *Bridge) readState(ctx context.Context) (state, error) {
+	result := state{First: 1, Next: 1}
+	err := b.outbox.ReadShelf(ctx, b.shelfName(), func(reader stoabs.Reader) error {
+		current, err := stoabs.JSONShelf[state](reader).Get(stateKey)
+		if errors.Is(err, stoabs.ErrKeyNotFound) {
+			return nil
+		}
+		result = current
+		return err
+	})
+	if err != nil {
+		return state{}, fmt.Errorf("unable to read outbox state: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,248 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package outbox
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/cdc"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/nuts-foundation/go-stoabs/mocks"
+	"github.com/nuts-foundation/go-stoabs/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+const shelfName = "test"
+
+func TestBridge_Sync(t *testing.T) {
+	t.Run("publishes committed mutations", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		source := cdc.Wrap(underlying)
+		put(t, source, shelfName, "a")
+		put(t, source, stoabs.ReservedShelfPrefix+"internal", "b")
+		require.NoError(t, source.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			return writer.Delete(stoabs.BytesKey("a"))
+		}))
+		publisher := &recordingPublisher{}
+		bridge := New(source, underlying, publisher)
+
+		err := bridge.Sync(ctx)
+
+		require.NoError(t, err)
+		require.Len(t, publisher.messages, 2)
+		assert.Equal(t, "1", publisher.messages[0].ID)
+		assert.Equal(t, shelfName, publisher.messages[0].Subject)
+		assert.Equal(t, []byte("a"), publisher.messages[0].Entry.Key)
+		assert.NotNil(t, publisher.messages[0].Entry.NewValueHash)
+		assert.Equal(t, "3", publisher.messages[1].ID)
+		assert.Nil(t, publisher.messages[1].Entry.NewValueHash)
+		assert.Equal(t, Stats{Staged: 2, Published: 2}, bridge.Stats())
+		t.Run("only new mutations are published", func(t *testing.T) {
+			put(t, source, shelfName, "c")
+
+			require.NoError(t, bridge.Sync(ctx))
+
+			require.Len(t, publisher.messages, 3)
+			assert.Equal(t, "4", publisher.messages[2].ID)
+		})
+		t.Run("published messages are removed from the outbox", func(t *testing.T) {
+			current, err := bridge.readState(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, state{Offset: 5, First: 4, Next: 4}, current)
+			require.NoError(t, underlying.ReadShelf(ctx, bridge.shelfName(), func(reader stoabs.Reader) error {
+				assert.Equal(t, uint(1), reader.Stats().NumEntries)
+				return nil
+			}))
+		})
+	})
+	t.Run("failed publications are retried", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		source := cdc.Wrap(underlying)
+		put(t, source, shelfName, "a")
+		publisher := &recordingPublisher{err: errors.New("broker unavailable")}
+		bridge := New(source, underlying, publisher)
+
+		err := bridge.Sync(ctx)
+		assert.EqualError(t, err, "unable to publish messages: broker unavailable")
+
+		publisher.err = nil
+		put(t, source, shelfName, "b")
+		require.NoError(t, bridge.Sync(ctx))
+
+		require.Len(t, publisher.messages, 2)
+		assert.Equal(t, []byte("a"), publisher.messages[0].Entry.Key)
+		assert.Equal(t, []byte("b"), publisher.messages[1].Entry.Key)
+		assert.Equal(t, Stats{Staged: 2, Published: 2, Failures: 1}, bridge.Stats())
+	})
+	t.Run("pending messages outlive the journal retention", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		source := cdc.Wrap(underlying, cdc.WithRetention(2))
+		publisher := &recordingPublisher{err: errors.New("broker unavailable")}
+		bridge := New(source, underlying, publisher)
+		for _, key := range []string{"a", "b", "c", "d"} {
+			put(t, source, shelfName, key)
+			_ = bridge.Sync(ctx)
+		}
+
+		publisher.err = nil
+		require.NoError(t, bridge.Sync(ctx))
+
+		assert.Len(t, publisher.messages, 4)
+		assert.Equal(t, uint64(0), bridge.Stats().Lost)
+	})
+	t.Run("entries removed from the journal before they were staged", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		source := cdc.Wrap(underlying, cdc.WithRetention(2))
+		publisher := &recordingPublisher{}
+		bridge := New(source, underlying, publisher)
+		put(t, source, shelfName, "a")
+		require.NoError(t, bridge.Sync(ctx))
+		for _, key := range []string{"b", "c", "d", "e"} {
+			put(t, source, shelfName, key)
+		}
+
+		require.NoError(t, bridge.Sync(ctx))
+
+		require.Len(t, publisher.messages, 3)
+		assert.Equal(t, []byte("d"), publisher.messages[1].Entry.Key)
+		assert.Equal(t, uint64(2), bridge.Stats().Lost)
+	})
+	t.Run("in batches", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		source := cdc.Wrap(underlying)
+		for _, key := range []string{"a", "b", "c", "d", "e"} {
+			put(t, source, shelfName, key)
+		}
+		publisher := &recordingPublisher{}
+
+		err := New(source, underlying, publisher, WithBatchSize(2)).Sync(ctx)
+
+		require.NoError(t, err)
+		assert.Len(t, publisher.messages, 5)
+		assert.Equal(t, []int{2, 2, 1}, publisher.batches)
+	})
+	t.Run("shelves and subject", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		source := cdc.Wrap(underlying)
+		put(t, source, shelfName, "a")
+		put(t, source, "other", "b")
+		publisher := &recordingPublisher{}
+		bridge := New(source, underlying, publisher, WithShelves("other"), WithSubject(func(entry cdc.Entry) string {
+			return "stoabs." + entry.Shelf
+		}))
+
+		require.NoError(t, bridge.Sync(ctx))
+
+		require.Len(t, publisher.messages, 1)
+		assert.Equal(t, "stoabs.other", publisher.messages[0].Subject)
+	})
+	t.Run("bridges with different names", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		source := cdc.Wrap(underlying)
+		put(t, source, shelfName, "a")
+		nats, kafka := &recordingPublisher{}, &recordingPublisher{}
+
+		require.NoError(t, New(source, underlying, nats, WithName("nats")).Sync(ctx))
+		require.NoError(t, New(source, underlying, kafka, WithName("kafka")).Sync(ctx))
+
+		assert.Len(t, nats.messages, 1)
+		assert.Len(t, kafka.messages, 1)
+	})
+	t.Run("outbox unavailable", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		outbox := stores.BBolt(t)
+		_ = outbox.Close(ctx)
+
+		err := New(cdc.Wrap(underlying), outbox, &recordingPublisher{}).Sync(ctx)
+
+		assert.ErrorIs(t, err, stoabs.ErrStoreIsClosed)
+	})
+}
+
+func TestBridge_Run(t *testing.T) {
+	clock := mocks.NewClock(time.Now())
+	underlying := stores.BBolt(t)
+	source := cdc.Wrap(underlying)
+	publisher := &recordingPublisher{}
+	bridge := New(source, underlying, publisher, WithClock(clock), WithInterval(time.Second))
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		bridge.Run(ctx)
+		close(done)
+	}()
+
+	util.WaitFor(t, func() (bool, error) {
+		return clock.Waiters() == 1, nil
+	}, 5*time.Second, "Run didn't wait for the interval")
+	put(t, source, shelfName, "a")
+	clock.Advance(time.Second)
+	util.WaitFor(t, func() (bool, error) {
+		return bridge.Stats().Published == 1, nil
+	}, 5*time.Second, "Run didn't publish")
+
+	cancel()
+	<-done
+}
+
+func TestPublisherFunc_Publish(t *testing.T) {
+	var published []Message
+	publisher := PublisherFunc(func(_ context.Context, messages []Message) error {
+		published = messages
+		return nil
+	})
+
+	err := publisher.Publish(ctx, []Message{{ID: "1"}})
+
+	require.NoError(t, err)
+	assert.Len(t, published, 1)
+}
+
+// recordingPublisher records the published messages, or fails with err if it's set.
+type recordingPublisher struct {
+	mux      sync.Mutex
+	err      error
+	messages []Message
+	batches  []int
+}
+
+func (r *recordingPublisher) Publish(_ context.Context, messages []Message) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	r.messages = append(r.messages, messages...)
+	r.batches = append(r.batches, len(messages))
+	return nil
+}
+
+func put(t *testing.T, store stoabs.KVStore, shelf string, key string) {
+	require.NoError(t, store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+		return writer.Put(stoabs.BytesKey(key), []byte("value"))
+	}))
+}
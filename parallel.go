@@ -0,0 +1,111 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+)
+
+// ParallelRange calls the callback for each key/value pair on the shelf from (inclusive) and to (exclusive) given keys,
+// like Reader.Range, but splits the range into (at most) the given number of partitions that are scanned concurrently,
+// each in its own read transaction. The range is split evenly by key value, so partitions only contain a similar number
+// of entries if keys are distributed evenly (e.g. hashes or sequential numbers).
+// Pairs are passed in order within a partition, but the partitions are scanned concurrently,
+// so the callback must be safe for concurrent use. The partitions don't see a single snapshot of the shelf.
+// The first error returned by the callback or a partition is returned, after cancelling the other partitions.
+func ParallelRange(ctx context.Context, store KVStore, shelfName string, from Key, to Key, parts int, callback CallerFn) error {
+	bounds, err := splitRange(from, to, parts)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+	for i := 0; i < len(bounds)-1; i++ {
+		wg.Add(1)
+		go func(from, to Key) {
+			defer wg.Done()
+			err := store.ReadShelf(ctx, shelfName, func(reader Reader) error {
+				return reader.Range(from, to, callback, false)
+			})
+			if err != nil {
+				errOnce.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(bounds[i], bounds[i+1])
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// splitRange returns the boundaries of the partitions of the given range: partition i ranges from result[i] to result[i+1].
+// Keys are interpreted as big-endian numbers, padded to the same length. Empty partitions are omitted.
+func splitRange(from Key, to Key, parts int) ([]Key, error) {
+	if parts < 1 {
+		return nil, errors.New("number of partitions must be at least 1")
+	}
+	fromBytes, toBytes := from.Bytes(), to.Bytes()
+	if bytes.Compare(fromBytes, toBytes) >= 0 {
+		return []Key{from, to}, nil
+	}
+	length := len(fromBytes)
+	if len(toBytes) > length {
+		length = len(toBytes)
+	}
+	start := new(big.Int).SetBytes(pad(fromBytes, length))
+	end := new(big.Int).SetBytes(pad(toBytes, length))
+	size := new(big.Int).Sub(end, start)
+
+	result := []Key{from}
+	previous := fromBytes
+	for i := 1; i < parts; i++ {
+		// start + size * i / parts
+		boundary := new(big.Int).Mul(size, big.NewInt(int64(i)))
+		boundary.Div(boundary, big.NewInt(int64(parts)))
+		boundary.Add(boundary, start)
+		boundaryBytes := boundary.FillBytes(make([]byte, length))
+		// padding may yield boundaries not between from and to, or equal boundaries for small ranges
+		if bytes.Compare(boundaryBytes, previous) <= 0 || bytes.Compare(boundaryBytes, toBytes) >= 0 {
+			continue
+		}
+		key, err := from.FromBytes(boundaryBytes)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, key)
+		previous = boundaryBytes
+	}
+	return append(result, to), nil
+}
+
+// pad returns the given bytes, right-padded with zeroes to the given length.
+func pad(value []byte, length int) []byte {
+	result := make([]byte, length)
+	copy(result, value)
+	return result
+}
@@ -0,0 +1,130 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParallelRange(t *testing.T) {
+	ctx := context.Background()
+	underlying := stores.BBolt(t)
+	transactions := &counter{}
+	store := stoabs.Chain(underlying, transactions)
+	err := store.WriteShelf(ctx, "numbers", func(writer stoabs.Writer) error {
+		for i := 0; i < 1000; i++ {
+			if err := writer.Put(stoabs.Uint32Key(i), []byte{1}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	scan := func(t *testing.T, shelf string, from, to stoabs.Key, parts int) ([]stoabs.Key, error) {
+		var mux sync.Mutex
+		var keys []stoabs.Key
+		transactions.count.Store(0)
+		err := stoabs.ParallelRange(ctx, store, shelf, from, to, parts, func(key stoabs.Key, _ []byte) error {
+			mux.Lock()
+			defer mux.Unlock()
+			keys = append(keys, key)
+			return nil
+		})
+		return keys, err
+	}
+
+	t.Run("visits all keys in range once", func(t *testing.T) {
+		keys, err := scan(t, "numbers", stoabs.Uint32Key(100), stoabs.Uint32Key(900), 4)
+
+		require.NoError(t, err)
+		assert.Equal(t, int32(4), transactions.count.Load())
+		require.Len(t, keys, 800)
+		seen := map[stoabs.Uint32Key]bool{}
+		for _, key := range keys {
+			seen[key.(stoabs.Uint32Key)] = true
+		}
+		assert.Len(t, seen, 800)
+		assert.True(t, seen[100])
+		assert.False(t, seen[900])
+	})
+	t.Run("more partitions than keys", func(t *testing.T) {
+		keys, err := scan(t, "numbers", stoabs.Uint32Key(10), stoabs.Uint32Key(13), 8)
+
+		require.NoError(t, err)
+		assert.Equal(t, int32(3), transactions.count.Load())
+		assert.ElementsMatch(t, []stoabs.Key{stoabs.Uint32Key(10), stoabs.Uint32Key(11), stoabs.Uint32Key(12)}, keys)
+	})
+	t.Run("bytes keys of different length", func(t *testing.T) {
+		err := store.WriteShelf(ctx, "names", func(writer stoabs.Writer) error {
+			for _, name := range []string{"a", "ab", "b", "ba", "bb", "c", "d"} {
+				if err := writer.Put(stoabs.BytesKey(name), []byte{1}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		require.NoError(t, err)
+
+		keys, err := scan(t, "names", stoabs.BytesKey("ab"), stoabs.BytesKey("c"), 3)
+
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []stoabs.Key{stoabs.BytesKey("ab"), stoabs.BytesKey("b"), stoabs.BytesKey("ba"), stoabs.BytesKey("bb")}, keys)
+	})
+	t.Run("empty range", func(t *testing.T) {
+		keys, err := scan(t, "numbers", stoabs.Uint32Key(10), stoabs.Uint32Key(10), 4)
+
+		require.NoError(t, err)
+		assert.Empty(t, keys)
+	})
+	t.Run("error cancels other partitions", func(t *testing.T) {
+		var calls atomic.Int32
+		err := stoabs.ParallelRange(ctx, store, "numbers", stoabs.Uint32Key(0), stoabs.Uint32Key(1000), 4, func(key stoabs.Key, _ []byte) error {
+			calls.Add(1)
+			return errors.New("failure")
+		})
+
+		assert.EqualError(t, err, "failure")
+		assert.LessOrEqual(t, calls.Load(), int32(4))
+	})
+	t.Run("invalid number of partitions", func(t *testing.T) {
+		_, err := scan(t, "numbers", stoabs.Uint32Key(0), stoabs.Uint32Key(1000), 0)
+
+		assert.EqualError(t, err, "number of partitions must be at least 1")
+	})
+}
+
+// counter counts the transactions.
+type counter struct {
+	stoabs.NoopInterceptor
+	count atomic.Int32
+}
+
+func (c *counter) Transaction(ctx context.Context, _ stoabs.TxInfo, next func(ctx context.Context) error) error {
+	c.count.Add(1)
+	return next(ctx)
+}
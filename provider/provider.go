@@ -0,0 +1,221 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package provider manages the lifecycle of the named stores of an application: stores are opened when they're first
+// used, and closed together (in reverse order of registration) when the application shuts down.
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultCloseTimeout is the default maximum duration for closing a single store.
+const DefaultCloseTimeout = 10 * time.Second
+
+// ErrUnknownStore is returned when requesting a store that hasn't been registered.
+var ErrUnknownStore = errors.New("unknown store")
+
+// OpenFunc opens a store. It's called when the store is first requested.
+type OpenFunc func() (stoabs.KVStore, error)
+
+// Option configures the provider.
+type Option func(p *Provider)
+
+// WithCloseTimeout overrides DefaultCloseTimeout, the maximum duration for closing a single store.
+func WithCloseTimeout(timeout time.Duration) Option {
+	return func(p *Provider) {
+		p.closeTimeout = timeout
+	}
+}
+
+// WithLogger overrides the default logger.
+func WithLogger(log *logrus.Logger) Option {
+	return func(p *Provider) {
+		p.log = log
+	}
+}
+
+// StoreOption configures a registered store.
+type StoreOption func(e *entry)
+
+// WithStoreCloseTimeout overrides the close timeout of the provider for a single store.
+func WithStoreCloseTimeout(timeout time.Duration) StoreOption {
+	return func(e *entry) {
+		e.closeTimeout = timeout
+	}
+}
+
+// CloseError is returned (joined with the errors of other stores) when a store fails to close.
+type CloseError struct {
+	// Store holds the name of the store.
+	Store string
+	// Err holds the error returned by the store.
+	Err error
+}
+
+func (e CloseError) Error() string {
+	return fmt.Sprintf("unable to close store %s: %s", e.Store, e.Err)
+}
+
+func (e CloseError) Unwrap() error {
+	return e.Err
+}
+
+// New creates a Provider without stores.
+func New(opts ...Option) *Provider {
+	result := &Provider{
+		entries:      map[string]*entry{},
+		closeTimeout: DefaultCloseTimeout,
+		log:          logrus.StandardLogger(),
+	}
+	for _, opt := range opts {
+		opt(result)
+	}
+	return result
+}
+
+// Provider owns a set of named stores. It's safe for concurrent use.
+type Provider struct {
+	mux          sync.Mutex
+	entries      map[string]*entry
+	order        []*entry
+	closed       bool
+	closeTimeout time.Duration
+	log          *logrus.Logger
+}
+
+type entry struct {
+	name         string
+	open         OpenFunc
+	closeTimeout time.Duration
+	// mux guards store, and is held while opening or closing the store.
+	mux   sync.Mutex
+	store stoabs.KVStore
+}
+
+// Register adds a store to the provider, which is opened using the given function when it's first requested.
+// Stores are closed in reverse order of registration, so stores should be registered after the stores they depend on.
+// It returns an error if a store with the same name has already been registered, or the provider has been closed.
+func (p *Provider) Register(name string, open OpenFunc, opts ...StoreOption) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.closed {
+		return stoabs.ErrStoreIsClosed
+	}
+	if _, exists := p.entries[name]; exists {
+		return fmt.Errorf("store already registered: %s", name)
+	}
+	e := &entry{name: name, open: open, closeTimeout: p.closeTimeout}
+	for _, opt := range opts {
+		opt(e)
+	}
+	p.entries[name] = e
+	p.order = append(p.order, e)
+	return nil
+}
+
+// Get returns the store with the given name, opening it if it hasn't been opened yet.
+// If opening fails the error is returned, and the next call tries to open the store again.
+// It returns ErrUnknownStore if the store hasn't been registered, and stoabs.ErrStoreIsClosed if the provider has been closed.
+func (p *Provider) Get(name string) (stoabs.KVStore, error) {
+	p.mux.Lock()
+	e, exists := p.entries[name]
+	closed := p.closed
+	p.mux.Unlock()
+	if closed {
+		return nil, stoabs.ErrStoreIsClosed
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownStore, name)
+	}
+
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if e.store != nil {
+		return e.store, nil
+	}
+	// The provider might have been closed while waiting for the lock
+	p.mux.Lock()
+	closed = p.closed
+	p.mux.Unlock()
+	if closed {
+		return nil, stoabs.ErrStoreIsClosed
+	}
+	store, err := e.open()
+	if err != nil {
+		return nil, fmt.Errorf("unable to open store %s: %w", name, err)
+	}
+	e.store = store
+	return store, nil
+}
+
+// Opened returns the names of the stores that have been opened, in order of registration.
+func (p *Provider) Opened() []string {
+	p.mux.Lock()
+	order := p.order
+	p.mux.Unlock()
+	result := make([]string, 0)
+	for _, e := range order {
+		e.mux.Lock()
+		if e.store != nil {
+			result = append(result, e.name)
+		}
+		e.mux.Unlock()
+	}
+	return result
+}
+
+// Close closes all opened stores in reverse order of registration, after which the provider can't be used anymore.
+// Every store is closed with a context derived from the given one, bounded by the close timeout of the store.
+// All stores are closed, even if some fail: the errors are logged and returned joined, each as a CloseError.
+// It is safe to call multiple times.
+func (p *Provider) Close(ctx context.Context) error {
+	p.mux.Lock()
+	p.closed = true
+	order := p.order
+	p.mux.Unlock()
+
+	var errs []error
+	for i := len(order) - 1; i >= 0; i-- {
+		if err := order[i].close(ctx); err != nil {
+			p.log.WithError(err).Errorf("Unable to close store: %s", order[i].name)
+			errs = append(errs, CloseError{Store: order[i].name, Err: err})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (e *entry) close(ctx context.Context) error {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if e.store == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, e.closeTimeout)
+	defer cancel()
+	err := e.store.Close(ctx)
+	e.store = nil
+	return err
+}
@@ -0,0 +1,216 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/bbolt"
+	"github.com/nuts-foundation/go-stoabs/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+var ctx = context.Background()
+
+func TestProvider_Get(t *testing.T) {
+	t.Run("opens lazily and once", func(t *testing.T) {
+		p := New()
+		directory := util.TestDirectory(t)
+		var opened int
+		require.NoError(t, p.Register("a", func() (stoabs.KVStore, error) {
+			opened++
+			return bbolt.CreateBBoltStore(path.Join(directory, "a.db"), stoabs.WithNoSync())
+		}))
+		defer p.Close(ctx)
+		assert.Equal(t, 0, opened)
+		assert.Empty(t, p.Opened())
+
+		var wg sync.WaitGroup
+		stores := make([]stoabs.KVStore, 10)
+		for i := range stores {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				stores[i], _ = p.Get("a")
+			}(i)
+		}
+		wg.Wait()
+
+		assert.Equal(t, 1, opened)
+		assert.Equal(t, []string{"a"}, p.Opened())
+		for _, store := range stores {
+			assert.NotNil(t, store)
+			assert.Same(t, stores[0], store)
+		}
+	})
+	t.Run("open failure is retried", func(t *testing.T) {
+		p := New()
+		store := stoabs.NewMockKVStore(gomock.NewController(t))
+		attempts := 0
+		_ = p.Register("a", func() (stoabs.KVStore, error) {
+			attempts++
+			if attempts == 1 {
+				return nil, errors.New("failure")
+			}
+			return store, nil
+		})
+
+		_, err := p.Get("a")
+		assert.EqualError(t, err, "unable to open store a: failure")
+		actual, err := p.Get("a")
+		assert.NoError(t, err)
+		assert.Same(t, store, actual)
+	})
+	t.Run("unknown store", func(t *testing.T) {
+		_, err := New().Get("a")
+
+		assert.ErrorIs(t, err, ErrUnknownStore)
+	})
+	t.Run("closed provider", func(t *testing.T) {
+		p := New()
+		_ = p.Register("a", func() (stoabs.KVStore, error) {
+			t.Fatal("should not be opened")
+			return nil, nil
+		})
+		require.NoError(t, p.Close(ctx))
+
+		_, err := p.Get("a")
+
+		assert.ErrorIs(t, err, stoabs.ErrStoreIsClosed)
+	})
+}
+
+func TestProvider_Register(t *testing.T) {
+	t.Run("duplicate name", func(t *testing.T) {
+		p := New()
+		require.NoError(t, p.Register("a", nil))
+
+		assert.EqualError(t, p.Register("a", nil), "store already registered: a")
+	})
+	t.Run("closed provider", func(t *testing.T) {
+		p := New()
+		_ = p.Close(ctx)
+
+		assert.ErrorIs(t, p.Register("a", nil), stoabs.ErrStoreIsClosed)
+	})
+}
+
+func TestProvider_Close(t *testing.T) {
+	t.Run("closes opened stores in reverse order of registration", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		p := New()
+		var closed []string
+		for _, name := range []string{"a", "b", "c"} {
+			store := stoabs.NewMockKVStore(ctrl)
+			store.EXPECT().Close(gomock.Any()).DoAndReturn(func(_ context.Context) error {
+				closed = append(closed, name)
+				return nil
+			}).MaxTimes(1)
+			_ = p.Register(name, func() (stoabs.KVStore, error) {
+				return store, nil
+			})
+		}
+		_, _ = p.Get("c")
+		_, _ = p.Get("a")
+
+		err := p.Close(ctx)
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"c", "a"}, closed)
+		assert.Empty(t, p.Opened())
+		assert.NoError(t, p.Close(ctx))
+	})
+	t.Run("returns errors of all stores", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		p := New()
+		for _, name := range []string{"a", "b", "c"} {
+			store := stoabs.NewMockKVStore(ctrl)
+			if name == "b" {
+				store.EXPECT().Close(gomock.Any()).Return(nil)
+			} else {
+				store.EXPECT().Close(gomock.Any()).Return(errors.New("failure " + name))
+			}
+			_ = p.Register(name, func() (stoabs.KVStore, error) {
+				return store, nil
+			})
+			_, _ = p.Get(name)
+		}
+
+		err := p.Close(ctx)
+
+		assert.EqualError(t, err, "unable to close store c: failure c\nunable to close store a: failure a")
+		var closeError CloseError
+		require.ErrorAs(t, err, &closeError)
+		assert.Equal(t, "c", closeError.Store)
+	})
+	t.Run("close timeout", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		p := New(WithCloseTimeout(time.Hour))
+		for _, name := range []string{"a", "b"} {
+			opts := []StoreOption{}
+			if name == "b" {
+				opts = append(opts, WithStoreCloseTimeout(time.Millisecond))
+			}
+			store := stoabs.NewMockKVStore(ctrl)
+			store.EXPECT().Close(gomock.Any()).DoAndReturn(func(ctx context.Context) error {
+				deadline, _ := ctx.Deadline()
+				if time.Until(deadline) > time.Minute {
+					return nil
+				}
+				<-ctx.Done()
+				return stoabs.DatabaseError(ctx.Err())
+			})
+			_ = p.Register(name, func() (stoabs.KVStore, error) {
+				return store, nil
+			}, opts...)
+			_, _ = p.Get(name)
+		}
+
+		err := p.Close(ctx)
+
+		var closeError CloseError
+		require.ErrorAs(t, err, &closeError)
+		assert.Equal(t, "b", closeError.Store)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+	t.Run("releases file locks", func(t *testing.T) {
+		file := path.Join(util.TestDirectory(t), "a.db")
+		open := func() (stoabs.KVStore, error) {
+			return bbolt.CreateBBoltStore(file, stoabs.WithNoSync(), stoabs.WithLockAcquireTimeout(time.Millisecond))
+		}
+		p := New()
+		_ = p.Register("a", open)
+		_, err := p.Get("a")
+		require.NoError(t, err)
+
+		require.NoError(t, p.Close(ctx))
+
+		store, err := open()
+		require.NoError(t, err)
+		_ = store.Close(ctx)
+	})
+}
@@ -0,0 +1,259 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package queue provides a FIFO queue with visibility timeouts on top of a shelf, working on any KVStore.
+package queue
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+)
+
+// ErrEmpty is returned by Pop and Peek when the queue holds no visible messages.
+var ErrEmpty = errors.New("queue is empty")
+
+// ErrNotFound is returned by Ack when the message doesn't exist (anymore).
+var ErrNotFound = errors.New("message not found")
+
+// stateKey holds the queue state, messages are keyed by their ID (stoabs.Uint64Key) starting at 1.
+const stateKey = stoabs.Uint64Key(0)
+
+// Messages are stored as the time they become visible (Unix nanoseconds, 8 bytes, big endian) | deliveries (4 bytes, big endian) | value.
+const messageHeaderSize = 12
+
+// errStop stops a Range when the message has been found.
+var errStop = errors.New("stop")
+
+// Message is a message in the queue.
+type Message struct {
+	// ID identifies the message, IDs are assigned in the order messages are pushed.
+	ID uint64
+	// Value is the value pushed to the queue.
+	Value []byte
+	// Deliveries is the number of times the message has been returned by Pop, including the current one.
+	Deliveries uint32
+}
+
+// state is stored at stateKey in the queue shelf.
+type state struct {
+	// Head is the ID of the oldest message that may still be in the queue.
+	Head uint64 `json:"head"`
+	// Next is the ID that will be assigned to the next message.
+	Next uint64 `json:"next"`
+}
+
+// Option configures the queue.
+type Option func(q *Queue)
+
+// WithVisibilityTimeout specifies how long a popped message is hidden from Pop and Peek. If the message isn't
+// acknowledged (see Ack) within this time, it becomes visible again to be redelivered (at-least-once delivery).
+// By default, messages are removed when popped (at-most-once delivery).
+func WithVisibilityTimeout(timeout time.Duration) Option {
+	return func(q *Queue) {
+		q.visibilityTimeout = timeout
+	}
+}
+
+// New returns a queue that stores its messages in the given shelf, which must not be used for anything else.
+// All operations lock the shelf (see stoabs.WithShelfLock), so the queue can be used by multiple processes.
+func New(store stoabs.KVStore, shelfName string, opts ...Option) *Queue {
+	result := &Queue{
+		store:     store,
+		shelfName: shelfName,
+		now:       time.Now,
+	}
+	for _, opt := range opts {
+		opt(result)
+	}
+	return result
+}
+
+// Queue is a FIFO queue on top of a shelf. Use New to create it.
+type Queue struct {
+	store             stoabs.KVStore
+	shelfName         string
+	visibilityTimeout time.Duration
+	now               func() time.Time
+}
+
+// Push adds the value to the end of the queue, and returns the ID of the message.
+func (q *Queue) Push(ctx context.Context, value []byte) (uint64, error) {
+	var id uint64
+	err := q.write(ctx, func(writer stoabs.Writer, current *state) error {
+		id = current.Next
+		current.Next++
+		return writer.Put(stoabs.Uint64Key(id), encodeMessage(time.Time{}, 0, value))
+	})
+	return id, err
+}
+
+// Pop returns the oldest visible message. If a visibility timeout is configured (see WithVisibilityTimeout),
+// the message is hidden until the timeout expires and must be acknowledged using Ack. Otherwise, it's removed from the queue.
+// It returns ErrEmpty if there are no visible messages.
+func (q *Queue) Pop(ctx context.Context) (Message, error) {
+	var result Message
+	err := q.write(ctx, func(writer stoabs.Writer, current *state) error {
+		var err error
+		result, err = q.first(writer, current)
+		if err != nil {
+			return err
+		}
+		result.Deliveries++
+		if q.visibilityTimeout == 0 {
+			return writer.Delete(stoabs.Uint64Key(result.ID))
+		}
+		return writer.Put(stoabs.Uint64Key(result.ID), encodeMessage(q.now().Add(q.visibilityTimeout), result.Deliveries, result.Value))
+	})
+	return result, err
+}
+
+// Peek returns the oldest visible message without changing it. It returns ErrEmpty if there are no visible messages.
+func (q *Queue) Peek(ctx context.Context) (Message, error) {
+	var result Message
+	err := q.store.ReadShelf(ctx, q.shelfName, func(reader stoabs.Reader) error {
+		current, err := readState(reader)
+		if err != nil {
+			return err
+		}
+		result, err = q.first(reader, &current)
+		return err
+	})
+	if err == nil && result.ID == 0 {
+		// ReadShelf doesn't call the function if the shelf doesn't exist
+		return Message{}, ErrEmpty
+	}
+	return result, err
+}
+
+// Ack removes the message with the given ID from the queue, after it has been processed.
+// It returns ErrNotFound if the message doesn't exist, e.g. because it has already been acknowledged.
+func (q *Queue) Ack(ctx context.Context, id uint64) error {
+	return q.write(ctx, func(writer stoabs.Writer, _ *state) error {
+		_, err := writer.Get(stoabs.Uint64Key(id))
+		if errors.Is(err, stoabs.ErrKeyNotFound) || id == uint64(stateKey) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		return writer.Delete(stoabs.Uint64Key(id))
+	})
+}
+
+// Len returns the number of messages in the queue, including messages that are currently invisible.
+func (q *Queue) Len(ctx context.Context) (int, error) {
+	var result int
+	err := q.store.ReadShelf(ctx, q.shelfName, func(reader stoabs.Reader) error {
+		current, err := readState(reader)
+		if err != nil {
+			return err
+		}
+		return reader.Range(stoabs.Uint64Key(current.Head), stoabs.Uint64Key(current.Next), func(_ stoabs.Key, _ []byte) error {
+			result++
+			return nil
+		}, false)
+	})
+	return result, err
+}
+
+// first returns the oldest visible message, and advances the head of the queue past removed messages.
+func (q *Queue) first(reader stoabs.Reader, current *state) (Message, error) {
+	var result Message
+	now := q.now()
+	head := current.Next
+	err := reader.Range(stoabs.Uint64Key(current.Head), stoabs.Uint64Key(current.Next), func(key stoabs.Key, value []byte) error {
+		id := uint64(key.(stoabs.Uint64Key))
+		head = min(head, id)
+		message, visibleAt, err := decodeMessage(id, value)
+		if err != nil {
+			return err
+		}
+		if visibleAt.After(now) {
+			return nil
+		}
+		result = message
+		return errStop
+	}, false)
+	current.Head = head
+	if errors.Is(err, errStop) {
+		return result, nil
+	}
+	if err != nil {
+		return Message{}, err
+	}
+	return Message{}, ErrEmpty
+}
+
+// write performs the given function in a write transaction that locks the queue shelf, and writes the (updated) state.
+// The state is also written if the function returns ErrEmpty, since the head may have advanced.
+func (q *Queue) write(ctx context.Context, fn func(writer stoabs.Writer, current *state) error) error {
+	var fnErr error
+	err := q.store.Write(ctx, func(tx stoabs.WriteTx) error {
+		writer := tx.GetShelfWriter(q.shelfName)
+		current, err := readState(writer)
+		if err != nil {
+			return err
+		}
+		fnErr = fn(writer, &current)
+		if fnErr != nil && !errors.Is(fnErr, ErrEmpty) {
+			return fnErr
+		}
+		return stoabs.JSONShelf[state](writer).Put(stateKey, current)
+	}, stoabs.WithShelfLock(q.shelfName))
+	if err != nil {
+		return err
+	}
+	return fnErr
+}
+
+func readState(reader stoabs.Reader) (state, error) {
+	result, err := stoabs.JSONShelf[state](reader).Get(stateKey)
+	if errors.Is(err, stoabs.ErrKeyNotFound) {
+		return state{Head: 1, Next: 1}, nil
+	}
+	if err != nil {
+		return state{}, fmt.Errorf("unable to read queue state: %w", err)
+	}
+	return result, nil
+}
+
+func encodeMessage(visibleAt time.Time, deliveries uint32, value []byte) []byte {
+	result := make([]byte, messageHeaderSize, messageHeaderSize+len(value))
+	if !visibleAt.IsZero() {
+		binary.BigEndian.PutUint64(result, uint64(visibleAt.UnixNano()))
+	}
+	binary.BigEndian.PutUint32(result[8:], deliveries)
+	return append(result, value...)
+}
+
+func decodeMessage(id uint64, data []byte) (Message, time.Time, error) {
+	if len(data) < messageHeaderSize {
+		return Message{}, time.Time{}, fmt.Errorf("invalid queue message (id=%d)", id)
+	}
+	visibleAt := time.Unix(0, int64(binary.BigEndian.Uint64(data)))
+	return Message{
+		ID:         id,
+		Deliveries: binary.BigEndian.Uint32(data[8:]),
+		Value:      append([]byte(nil), data[messageHeaderSize:]...),
+	}, visibleAt, nil
+}
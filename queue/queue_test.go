@@ -0,0 +1,199 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package queue
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/nuts-foundation/go-stoabs/redis7"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+const shelfName = "jobs"
+
+func TestQueue(t *testing.T) {
+	t.Run("FIFO", func(t *testing.T) {
+		q := New(stores.BBolt(t), shelfName)
+		push(t, q, "a", "b", "c")
+
+		assert.Equal(t, []string{"a", "b", "c"}, popAll(t, q))
+		_, err := q.Pop(ctx)
+		assert.ErrorIs(t, err, ErrEmpty)
+	})
+	t.Run("empty queue", func(t *testing.T) {
+		q := New(stores.BBolt(t), shelfName)
+
+		_, err := q.Pop(ctx)
+		assert.ErrorIs(t, err, ErrEmpty)
+		_, err = q.Peek(ctx)
+		assert.ErrorIs(t, err, ErrEmpty)
+		n, err := q.Len(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 0, n)
+	})
+	t.Run("peek doesn't remove", func(t *testing.T) {
+		q := New(stores.BBolt(t), shelfName)
+		push(t, q, "a", "b")
+
+		message, err := q.Peek(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, Message{ID: 1, Value: []byte("a")}, message)
+		message, err = q.Peek(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), message.ID)
+		n, _ := q.Len(ctx)
+		assert.Equal(t, 2, n)
+	})
+	t.Run("visibility timeout", func(t *testing.T) {
+		now := time.Unix(1000, 0)
+		q := New(stores.BBolt(t), shelfName, WithVisibilityTimeout(time.Minute))
+		q.now = func() time.Time {
+			return now
+		}
+		push(t, q, "a", "b")
+
+		first, err := q.Pop(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, Message{ID: 1, Value: []byte("a"), Deliveries: 1}, first)
+		// hidden while being processed
+		second, err := q.Pop(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(2), second.ID)
+		_, err = q.Peek(ctx)
+		assert.ErrorIs(t, err, ErrEmpty)
+		n, _ := q.Len(ctx)
+		assert.Equal(t, 2, n)
+
+		// first is acknowledged, second times out and is redelivered
+		require.NoError(t, q.Ack(ctx, first.ID))
+		now = now.Add(time.Minute + time.Second)
+		redelivered, err := q.Pop(ctx)
+
+		require.NoError(t, err)
+		assert.Equal(t, Message{ID: 2, Value: []byte("b"), Deliveries: 2}, redelivered)
+		require.NoError(t, q.Ack(ctx, redelivered.ID))
+		n, _ = q.Len(ctx)
+		assert.Equal(t, 0, n)
+	})
+	t.Run("ack of unknown message", func(t *testing.T) {
+		q := New(stores.BBolt(t), shelfName, WithVisibilityTimeout(time.Minute))
+		push(t, q, "a")
+
+		assert.ErrorIs(t, q.Ack(ctx, 2), ErrNotFound)
+		assert.ErrorIs(t, q.Ack(ctx, 0), ErrNotFound)
+		require.NoError(t, q.Ack(ctx, 1))
+		assert.ErrorIs(t, q.Ack(ctx, 1), ErrNotFound)
+	})
+	t.Run("head advances past removed messages", func(t *testing.T) {
+		store := stores.BBolt(t)
+		q := New(store, shelfName)
+		push(t, q, "a", "b", "c")
+		popAll(t, q)
+
+		_ = store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			current, err := readState(reader)
+			require.NoError(t, err)
+			assert.Equal(t, state{Head: 4, Next: 4}, current)
+			return nil
+		})
+	})
+	t.Run("concurrent consumers receive each message once", func(t *testing.T) {
+		q := New(stores.BBolt(t), shelfName)
+		const count = 50
+		for i := 0; i < count; i++ {
+			_, err := q.Push(ctx, []byte{byte(i)})
+			require.NoError(t, err)
+		}
+
+		received := map[byte]int{}
+		mux := sync.Mutex{}
+		wg := sync.WaitGroup{}
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					message, err := q.Pop(ctx)
+					if err != nil {
+						assert.ErrorIs(t, err, ErrEmpty)
+						return
+					}
+					mux.Lock()
+					received[message.Value[0]]++
+					mux.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+
+		assert.Len(t, received, count)
+		for value, n := range received {
+			assert.Equal(t, 1, n, "message %d", value)
+		}
+	})
+	t.Run("Redis", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		store, err := redis7.CreateRedisStore("db", &redis.Options{Addr: mr.Addr()})
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = store.Close(ctx)
+		})
+		q := New(store, shelfName, WithVisibilityTimeout(time.Minute))
+		push(t, q, "a", "b")
+
+		first, err := q.Pop(ctx)
+		require.NoError(t, err)
+		require.NoError(t, q.Ack(ctx, first.ID))
+		second, err := q.Pop(ctx)
+
+		require.NoError(t, err)
+		assert.Equal(t, "b", string(second.Value))
+		n, _ := q.Len(ctx)
+		assert.Equal(t, 1, n)
+	})
+}
+
+func push(t *testing.T, q *Queue, values ...string) {
+	for _, value := range values {
+		_, err := q.Push(ctx, []byte(value))
+		require.NoError(t, err)
+	}
+}
+
+func popAll(t *testing.T, q *Queue) []string {
+	var result []string
+	for {
+		message, err := q.Pop(ctx)
+		if err != nil {
+			require.ErrorIs(t, err, ErrEmpty)
+			return result
+		}
+		result = append(result, string(message.Value))
+	}
+}
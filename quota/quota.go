@@ -0,0 +1,347 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package quota provides a KVStore that limits the number of entries and total value size of shelves.
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/namespace"
+)
+
+var _ stoabs.KVStore = (*Store)(nil)
+var _ stoabs.ShelfLister = (*Store)(nil)
+var _ stoabs.WriteTx = (*tx)(nil)
+var _ stoabs.Writer = (*shelf)(nil)
+
+// usageShelf is the shelf holding the usage of the limited shelves, keyed by shelf name.
+const usageShelf = stoabs.ReservedShelfPrefix + "quota"
+
+// Limit is the quota of a shelf. Zero values mean unlimited.
+type Limit struct {
+	// MaxEntries is the maximum number of entries in the shelf.
+	MaxEntries uint64
+	// MaxBytes is the maximum total size of the values in the shelf.
+	MaxBytes uint64
+}
+
+func (l Limit) unlimited() bool {
+	return l.MaxEntries == 0 && l.MaxBytes == 0
+}
+
+// Usage is the number of entries and total value size of a shelf.
+type Usage struct {
+	Entries uint64 `json:"entries"`
+	Bytes   uint64 `json:"bytes"`
+}
+
+// ErrQuotaExceeded is returned by Put when the write would exceed the quota of the shelf.
+// Use errors.Is(err, ErrQuotaExceeded{}) to test for it, or errors.As to inspect it.
+type ErrQuotaExceeded struct {
+	Shelf string
+	Limit Limit
+	// Usage is the usage the shelf would have had if the write had been executed.
+	Usage Usage
+}
+
+func (e ErrQuotaExceeded) Error() string {
+	return fmt.Sprintf("quota exceeded for shelf %s (entries=%d/%d, bytes=%d/%d)",
+		e.Shelf, e.Usage.Entries, e.Limit.MaxEntries, e.Usage.Bytes, e.Limit.MaxBytes)
+}
+
+func (e ErrQuotaExceeded) Is(other error) bool {
+	_, ok := other.(ErrQuotaExceeded)
+	return ok
+}
+
+// Option configures the quota store.
+type Option func(s *Store)
+
+// WithLimit sets the quota of the given shelf, overriding the default quota (see WithDefaultLimit).
+func WithLimit(shelfName string, limit Limit) Option {
+	return func(s *Store) {
+		s.limits[shelfName] = limit
+	}
+}
+
+// WithDefaultLimit sets the quota of shelves that have no quota of their own. By default, these are unlimited.
+func WithDefaultLimit(limit Limit) Option {
+	return func(s *Store) {
+		s.defaultLimit = limit
+	}
+}
+
+// WithKeyType specifies the type of the keys of the given shelf, used to iterate over it when determining its usage.
+// It's required for shelves with other keys than stoabs.BytesKey in Redis, which stores keys in their string form.
+func WithKeyType(shelfName string, keyType stoabs.Key) Option {
+	return func(s *Store) {
+		s.keyTypes[shelfName] = keyType
+	}
+}
+
+// Wrap creates a store that enforces the configured quotas when values are written.
+// The usage of limited shelves is stored in the underlying store, and updated in the same transaction as the writes.
+// When a shelf is first written to through this store, its usage is determined by iterating over it.
+// Writes that don't increase the usage (e.g. replacing a value with a smaller one) are allowed even when the shelf
+// exceeds its quota, which can happen when quotas are lowered.
+// Since concurrent transactions could read and update the same usage, all write transactions acquire the write lock
+// (see stoabs.WithWriteLock).
+// The store must not be written to by other means, since that would make the stored usage incorrect.
+func Wrap(store stoabs.KVStore, opts ...Option) *Store {
+	result := &Store{
+		underlying: store,
+		limits:     map[string]Limit{},
+		keyTypes:   map[string]stoabs.Key{},
+	}
+	for _, opt := range opts {
+		opt(result)
+	}
+	return result
+}
+
+// Store is a KVStore that enforces quotas. Use Wrap to create it.
+type Store struct {
+	underlying   stoabs.KVStore
+	limits       map[string]Limit
+	defaultLimit Limit
+	keyTypes     map[string]stoabs.Key
+}
+
+// LimitOf returns the quota of the given shelf.
+func (s *Store) LimitOf(shelfName string) Limit {
+	// reserved shelves are used by stoabs itself, so they're never limited. Shelves of namespaces (see namespace.New)
+	// hold application data, so they're limited nonetheless.
+	if _, _, namespaced := namespace.Parse(shelfName); stoabs.IsReservedShelfName(shelfName) && !namespaced {
+		return Limit{}
+	}
+	if limit, ok := s.limits[shelfName]; ok {
+		return limit
+	}
+	return s.defaultLimit
+}
+
+// Usage returns the usage of the limited shelves that have been written to through this store, keyed by shelf name.
+func (s *Store) Usage(ctx context.Context) (map[string]Usage, error) {
+	result := map[string]Usage{}
+	err := s.underlying.ReadShelf(ctx, usageShelf, func(reader stoabs.Reader) error {
+		usages := stoabs.JSONShelf[Usage](reader)
+		return usages.Iterate(func(key stoabs.Key, usage Usage) error {
+			result[string(key.Bytes())] = usage
+			return nil
+		}, stoabs.BytesKey{})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read quota usage: %w", err)
+	}
+	return result, nil
+}
+
+func (s *Store) Close(ctx context.Context) error {
+	return s.underlying.Close(ctx)
+}
+
+func (s *Store) Write(ctx context.Context, fn func(stoabs.WriteTx) error, opts ...stoabs.TxOption) error {
+	if !(stoabs.WriteLockOption{}).Enabled(opts) {
+		opts = append(opts, stoabs.WithWriteLock())
+	}
+	return s.underlying.Write(ctx, func(underlyingTx stoabs.WriteTx) error {
+		t := &tx{ReadTx: underlyingTx, writeTx: underlyingTx, store: s, usages: map[string]*Usage{}, sizes: map[sizeCacheKey]int{}}
+		if err := fn(t); err != nil {
+			return err
+		}
+		return t.writeUsages()
+	}, opts...)
+}
+
+func (s *Store) WriteShelf(ctx context.Context, shelfName string, fn func(stoabs.Writer) error) error {
+	return s.Write(ctx, func(tx stoabs.WriteTx) error {
+		return fn(tx.GetShelfWriter(shelfName))
+	})
+}
+
+func (s *Store) Read(ctx context.Context, fn func(stoabs.ReadTx) error) error {
+	return s.underlying.Read(ctx, func(underlyingTx stoabs.ReadTx) error {
+		return fn(&tx{ReadTx: underlyingTx, store: s})
+	})
+}
+
+func (s *Store) ReadShelf(ctx context.Context, shelfName string, fn func(stoabs.Reader) error) error {
+	return s.Read(ctx, func(tx stoabs.ReadTx) error {
+		return fn(tx.GetShelfReader(shelfName))
+	})
+}
+
+// ShelfNames returns the shelves of the underlying store. The shelf holding the usage is reserved,
+// so it's only listed when requested using stoabs.ContextWithReservedShelves.
+func (s *Store) ShelfNames(ctx context.Context) ([]string, error) {
+	return stoabs.ShelfNames(ctx, s.underlying)
+}
+
+type tx struct {
+	stoabs.ReadTx
+	writeTx stoabs.WriteTx
+	store   *Store
+	// usages holds the (updated) usage of the limited shelves written to in this transaction.
+	usages map[string]*Usage
+	// sizes holds the sizes of the values written in this transaction (-1 if deleted), to determine the size of the value
+	// replaced by subsequent writes to the same key, since not all backends can read values written in the same transaction.
+	sizes map[sizeCacheKey]int
+}
+
+func (t *tx) GetShelfReader(shelfName string) stoabs.Reader {
+	return t.ReadTx.GetShelfReader(shelfName)
+}
+
+func (t *tx) GetShelfWriter(shelfName string) stoabs.Writer {
+	writer := t.writeTx.GetShelfWriter(shelfName)
+	limit := t.store.LimitOf(shelfName)
+	if limit.unlimited() {
+		return writer
+	}
+	return &shelf{Writer: writer, name: shelfName, limit: limit, tx: t}
+}
+
+func (t *tx) Store() stoabs.KVStore {
+	return t.store
+}
+
+// usage returns the usage of the given shelf, reading (or determining) it if this transaction didn't write to it yet.
+func (t *tx) usage(shelfName string, writer stoabs.Writer) (*Usage, error) {
+	if usage, ok := t.usages[shelfName]; ok {
+		return usage, nil
+	}
+	usage, err := stoabs.JSONShelf[Usage](t.writeTx.GetShelfWriter(usageShelf)).Get(stoabs.BytesKey(shelfName))
+	if errors.Is(err, stoabs.ErrKeyNotFound) {
+		usage, err = determineUsage(writer, t.store.keyTypeOf(shelfName))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read quota usage of shelf %s: %w", shelfName, err)
+	}
+	t.usages[shelfName] = &usage
+	return &usage, nil
+}
+
+func (t *tx) writeUsages() error {
+	usages := stoabs.JSONShelf[Usage](t.writeTx.GetShelfWriter(usageShelf))
+	for shelfName, usage := range t.usages {
+		if err := usages.Put(stoabs.BytesKey(shelfName), *usage); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) keyTypeOf(shelfName string) stoabs.Key {
+	if keyType, ok := s.keyTypes[shelfName]; ok {
+		return keyType
+	}
+	return stoabs.BytesKey{}
+}
+
+func determineUsage(reader stoabs.Reader, keyType stoabs.Key) (Usage, error) {
+	var result Usage
+	err := reader.Iterate(func(_ stoabs.Key, value []byte) error {
+		result.Entries++
+		result.Bytes += uint64(len(value))
+		return nil
+	}, keyType)
+	return result, err
+}
+
+type shelf struct {
+	stoabs.Writer
+	name  string
+	limit Limit
+	tx    *tx
+}
+
+func (s *shelf) Put(key stoabs.Key, value []byte) error {
+	usage, err := s.tx.usage(s.name, s.Writer)
+	if err != nil {
+		return err
+	}
+	oldSize, err := s.currentSize(key)
+	if err != nil {
+		return err
+	}
+	updated := *usage
+	if oldSize < 0 {
+		updated.Entries++
+		oldSize = 0
+	}
+	updated.Bytes = updated.Bytes - uint64(oldSize) + uint64(len(value))
+	if (updated.Entries > usage.Entries && s.limit.MaxEntries > 0 && updated.Entries > s.limit.MaxEntries) ||
+		(updated.Bytes > usage.Bytes && s.limit.MaxBytes > 0 && updated.Bytes > s.limit.MaxBytes) {
+		return ErrQuotaExceeded{Shelf: s.name, Limit: s.limit, Usage: updated}
+	}
+	if err := s.Writer.Put(key, value); err != nil {
+		return err
+	}
+	*usage = updated
+	s.tx.sizes[s.cacheKey(key)] = len(value)
+	return nil
+}
+
+func (s *shelf) Delete(key stoabs.Key) error {
+	usage, err := s.tx.usage(s.name, s.Writer)
+	if err != nil {
+		return err
+	}
+	oldSize, err := s.currentSize(key)
+	if err != nil {
+		return err
+	}
+	if err := s.Writer.Delete(key); err != nil {
+		return err
+	}
+	if oldSize >= 0 {
+		usage.Entries--
+		usage.Bytes -= uint64(oldSize)
+	}
+	s.tx.sizes[s.cacheKey(key)] = -1
+	return nil
+}
+
+// currentSize returns the size of the current value of the given key, or -1 if it does not exist.
+func (s *shelf) currentSize(key stoabs.Key) (int, error) {
+	if size, ok := s.tx.sizes[s.cacheKey(key)]; ok {
+		return size, nil
+	}
+	value, err := s.Writer.Get(key)
+	if errors.Is(err, stoabs.ErrKeyNotFound) {
+		return -1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(value), nil
+}
+
+// sizeCacheKey identifies a key in the value size cache of a transaction.
+type sizeCacheKey struct {
+	shelf string
+	key   string
+}
+
+func (s *shelf) cacheKey(key stoabs.Key) sizeCacheKey {
+	return sizeCacheKey{shelf: s.name, key: string(key.Bytes())}
+}
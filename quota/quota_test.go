@@ -0,0 +1,217 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package quota
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/nuts-foundation/go-stoabs/namespace"
+	"github.com/nuts-foundation/go-stoabs/redis7"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+var ctx = context.Background()
+
+const shelfName = "test"
+
+func TestQuota(t *testing.T) {
+	provider := func(t *testing.T) (stoabs.KVStore, error) {
+		return Wrap(stores.BBolt(t), WithDefaultLimit(Limit{MaxEntries: 100000, MaxBytes: 1 << 30})), nil
+	}
+
+	kvtests.TestReadingAndWriting(t, provider)
+	kvtests.TestRange(t, provider)
+	kvtests.TestIterate(t, provider)
+	kvtests.TestEmpty(t, provider)
+	kvtests.TestDelete(t, provider)
+	kvtests.TestWriteTransactions(t, provider)
+	kvtests.TestShelfNames(t, provider)
+}
+
+func TestStore_Put(t *testing.T) {
+	t.Run("max entries", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t), WithLimit(shelfName, Limit{MaxEntries: 2}))
+		require.NoError(t, put(store, 1, "a"))
+		require.NoError(t, put(store, 2, "b"))
+
+		err := put(store, 3, "c")
+
+		assert.ErrorIs(t, err, ErrQuotaExceeded{})
+		assert.EqualError(t, err, "quota exceeded for shelf test (entries=3/2, bytes=3/0)")
+		// replacing an existing value is allowed
+		assert.NoError(t, put(store, 2, "c"))
+	})
+	t.Run("max bytes", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t), WithDefaultLimit(Limit{MaxBytes: 5}))
+		require.NoError(t, put(store, 1, "abc"))
+
+		err := put(store, 2, "abc")
+
+		var quotaErr ErrQuotaExceeded
+		require.True(t, errors.As(err, &quotaErr))
+		assert.Equal(t, ErrQuotaExceeded{Shelf: shelfName, Limit: Limit{MaxBytes: 5}, Usage: Usage{Entries: 2, Bytes: 6}}, quotaErr)
+		// replacing a value with a smaller one is allowed
+		assert.NoError(t, put(store, 1, "a"))
+		assert.NoError(t, put(store, 2, "abc"))
+	})
+	t.Run("deletes free up quota", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t), WithLimit(shelfName, Limit{MaxEntries: 1}))
+		require.NoError(t, put(store, 1, "a"))
+		require.NoError(t, store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			return writer.Delete(stoabs.Uint32Key(1))
+		}))
+
+		assert.NoError(t, put(store, 2, "b"))
+	})
+	t.Run("failed transaction doesn't change usage", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t), WithLimit(shelfName, Limit{MaxEntries: 1}))
+		_ = store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			_ = writer.Put(stoabs.Uint32Key(1), []byte("a"))
+			return errors.New("failed")
+		})
+
+		assert.NoError(t, put(store, 2, "b"))
+	})
+	t.Run("existing data is counted", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		require.NoError(t, put(underlying, 1, "a"))
+		store := Wrap(underlying, WithLimit(shelfName, Limit{MaxEntries: 1}))
+
+		err := put(store, 2, "b")
+
+		assert.ErrorIs(t, err, ErrQuotaExceeded{})
+	})
+	t.Run("other shelves are unlimited", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t), WithLimit("other", Limit{MaxEntries: 1}))
+		require.NoError(t, put(store, 1, "a"))
+
+		assert.NoError(t, put(store, 2, "b"))
+		usage, err := store.Usage(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, usage)
+	})
+	t.Run("shelves of namespaces are limited", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t), WithDefaultLimit(Limit{MaxEntries: 1}))
+		tenant, err := namespace.New(store, "tenant")
+		require.NoError(t, err)
+		require.NoError(t, put(tenant, 1, "a"))
+
+		err = put(tenant, 2, "b")
+
+		assert.ErrorIs(t, err, ErrQuotaExceeded{})
+	})
+	t.Run("keys of different shelves don't collide", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t), WithDefaultLimit(Limit{MaxEntries: 10}))
+		require.NoError(t, store.Write(ctx, func(tx stoabs.WriteTx) error {
+			_ = tx.GetShelfWriter("a/b").Put(stoabs.BytesKey("c"), []byte("abc"))
+			return tx.GetShelfWriter("a").Put(stoabs.BytesKey("b/c"), []byte("x"))
+		}))
+
+		usage, err := store.Usage(ctx)
+
+		require.NoError(t, err)
+		assert.Equal(t, Usage{Entries: 1, Bytes: 1}, usage["a"])
+	})
+	t.Run("multiple writes to the same key in a transaction (Redis)", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		underlying, err := redis7.CreateRedisStore("db", &redis.Options{Addr: mr.Addr()})
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = underlying.Close(ctx)
+		})
+		store := Wrap(underlying, WithLimit(shelfName, Limit{MaxEntries: 2}))
+
+		err = store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			for i := 0; i < 3; i++ {
+				if err := writer.Put(stoabs.Uint32Key(1), []byte("a")); err != nil {
+					return err
+				}
+			}
+			return writer.Put(stoabs.Uint32Key(2), []byte("b"))
+		})
+
+		require.NoError(t, err)
+		usage, err := store.Usage(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]Usage{shelfName: {Entries: 2, Bytes: 2}}, usage)
+	})
+}
+
+func TestStore_determineUsage_Redis(t *testing.T) {
+	mr := miniredis.RunT(t)
+	underlying, err := redis7.CreateRedisStore("db", &redis.Options{Addr: mr.Addr()})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = underlying.Close(ctx)
+	})
+	// existing entries with keys that are stored as odd-length decimal strings, which can't be parsed as BytesKey
+	require.NoError(t, put(underlying, 1, "a"))
+	require.NoError(t, put(underlying, 100, "b"))
+	store := Wrap(underlying, WithLimit(shelfName, Limit{MaxEntries: 3}), WithKeyType(shelfName, stoabs.Uint32Key(0)))
+
+	require.NoError(t, put(store, 2, "c"))
+
+	usage, err := store.Usage(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]Usage{shelfName: {Entries: 3, Bytes: 3}}, usage)
+}
+
+func TestStore_Write_acquiresWriteLock(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	underlying := stoabs.NewMockKVStore(ctrl)
+	underlying.EXPECT().Write(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, _ func(stoabs.WriteTx) error, opts ...stoabs.TxOption) error {
+			assert.True(t, (stoabs.WriteLockOption{}).Enabled(opts))
+			return nil
+		})
+	store := Wrap(underlying)
+
+	assert.NoError(t, store.Write(ctx, func(tx stoabs.WriteTx) error {
+		return nil
+	}))
+}
+
+func TestStore_Usage(t *testing.T) {
+	store := Wrap(stores.BBolt(t), WithDefaultLimit(Limit{MaxEntries: 10}))
+	require.NoError(t, put(store, 1, "a"))
+	require.NoError(t, put(store, 2, "bc"))
+
+	usage, err := store.Usage(ctx)
+
+	require.NoError(t, err)
+	assert.Equal(t, map[string]Usage{shelfName: {Entries: 2, Bytes: 3}}, usage)
+	t.Run("internal shelves are not limited", func(t *testing.T) {
+		assert.Equal(t, Limit{}, store.LimitOf(usageShelf))
+	})
+}
+
+func put(store stoabs.KVStore, key uint32, value string) error {
+	return store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+		return writer.Put(stoabs.Uint32Key(key), []byte(value))
+	})
+}
@@ -0,0 +1,263 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package ratelimit provides a KVStore that limits the rate of read and write transactions.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+)
+
+var _ stoabs.KVStore = (*Store)(nil)
+var _ stoabs.ShelfLister = (*Store)(nil)
+var _ stoabs.WriteTx = (*writeTx)(nil)
+
+// ErrRateLimited is returned when a transaction exceeds the rate limit, or (when blocking, see WithBlocking)
+// the rate limit doesn't allow it before the context deadline.
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// Limit is a token bucket rate limit.
+type Limit struct {
+	// Rate is the number of transactions per second. Zero means unlimited.
+	Rate float64
+	// Burst is the maximum number of transactions that can be started at once. It's at least 1.
+	Burst int
+}
+
+// Option configures the rate limiting store.
+type Option func(s *Store)
+
+// WithReadLimit limits the rate of all read transactions.
+func WithReadLimit(limit Limit) Option {
+	return func(s *Store) {
+		s.reads = newBucket(limit)
+	}
+}
+
+// WithWriteLimit limits the rate of all write transactions.
+func WithWriteLimit(limit Limit) Option {
+	return func(s *Store) {
+		s.writes = newBucket(limit)
+	}
+}
+
+// WithShelfReadLimit limits the rate of ReadShelf transactions on the given shelf, in addition to the global read limit.
+func WithShelfReadLimit(shelfName string, limit Limit) Option {
+	return func(s *Store) {
+		s.shelfReads[shelfName] = newBucket(limit)
+	}
+}
+
+// WithShelfWriteLimit limits the rate of WriteShelf transactions on the given shelf, in addition to the global write limit.
+func WithShelfWriteLimit(shelfName string, limit Limit) Option {
+	return func(s *Store) {
+		s.shelfWrites[shelfName] = newBucket(limit)
+	}
+}
+
+// WithBlocking makes transactions exceeding the rate limit wait until they're allowed, instead of failing with
+// ErrRateLimited. If that's not possible before the context deadline, ErrRateLimited is returned right away.
+func WithBlocking() Option {
+	return func(s *Store) {
+		s.blocking = true
+	}
+}
+
+// Wrap creates a store that limits the rate of transactions using token buckets.
+// Waiting (see WithBlocking) happens before the transaction is started, so no locks are held while waiting.
+// Shelf limits apply to ReadShelf and WriteShelf only, since the shelves accessed by Read and Write aren't known
+// before the transaction is started.
+func Wrap(store stoabs.KVStore, opts ...Option) *Store {
+	result := &Store{
+		underlying:  store,
+		shelfReads:  map[string]*bucket{},
+		shelfWrites: map[string]*bucket{},
+		now:         time.Now,
+	}
+	for _, opt := range opts {
+		opt(result)
+	}
+	return result
+}
+
+// Store is a KVStore that limits the rate of transactions. Use Wrap to create it.
+type Store struct {
+	underlying  stoabs.KVStore
+	reads       *bucket
+	writes      *bucket
+	shelfReads  map[string]*bucket
+	shelfWrites map[string]*bucket
+	blocking    bool
+	now         func() time.Time
+}
+
+func (s *Store) Close(ctx context.Context) error {
+	return s.underlying.Close(ctx)
+}
+
+func (s *Store) Write(ctx context.Context, fn func(stoabs.WriteTx) error, opts ...stoabs.TxOption) error {
+	if err := s.acquire(ctx, "", s.writes); err != nil {
+		return err
+	}
+	return s.underlying.Write(ctx, func(underlyingTx stoabs.WriteTx) error {
+		return fn(&writeTx{WriteTx: underlyingTx, store: s})
+	}, opts...)
+}
+
+func (s *Store) WriteShelf(ctx context.Context, shelfName string, fn func(stoabs.Writer) error) error {
+	if err := s.acquire(ctx, shelfName, s.writes, s.shelfWrites[shelfName]); err != nil {
+		return err
+	}
+	return s.underlying.WriteShelf(ctx, shelfName, fn)
+}
+
+func (s *Store) Read(ctx context.Context, fn func(stoabs.ReadTx) error) error {
+	if err := s.acquire(ctx, "", s.reads); err != nil {
+		return err
+	}
+	return s.underlying.Read(ctx, func(underlyingTx stoabs.ReadTx) error {
+		return fn(&readTx{ReadTx: underlyingTx, store: s})
+	})
+}
+
+func (s *Store) ReadShelf(ctx context.Context, shelfName string, fn func(stoabs.Reader) error) error {
+	if err := s.acquire(ctx, shelfName, s.reads, s.shelfReads[shelfName]); err != nil {
+		return err
+	}
+	return s.underlying.ReadShelf(ctx, shelfName, fn)
+}
+
+// ShelfNames returns the shelves of the underlying store. It's not rate limited.
+func (s *Store) ShelfNames(ctx context.Context) ([]string, error) {
+	return stoabs.ShelfNames(ctx, s.underlying)
+}
+
+// acquire takes a token from all given buckets (nil buckets are unlimited), waiting for them if blocking is enabled.
+func (s *Store) acquire(ctx context.Context, shelfName string, buckets ...*bucket) error {
+	now := s.now()
+	var wait time.Duration
+	var reserved []*bucket
+	for _, b := range buckets {
+		if b == nil {
+			continue
+		}
+		delay := b.reserve(now)
+		reserved = append(reserved, b)
+		if delay > wait {
+			wait = delay
+		}
+	}
+	if wait == 0 {
+		return nil
+	}
+	deadline, hasDeadline := ctx.Deadline()
+	if !s.blocking || (hasDeadline && now.Add(wait).After(deadline)) {
+		cancel(reserved)
+		return rateLimited(shelfName)
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		cancel(reserved)
+		return stoabs.DatabaseError(ctx.Err())
+	}
+}
+
+func cancel(buckets []*bucket) {
+	for _, b := range buckets {
+		b.cancel()
+	}
+}
+
+func rateLimited(shelfName string) error {
+	if shelfName == "" {
+		return ErrRateLimited
+	}
+	return fmt.Errorf("%w (shelf=%s)", ErrRateLimited, shelfName)
+}
+
+func newBucket(limit Limit) *bucket {
+	if limit.Rate <= 0 {
+		return nil
+	}
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	return &bucket{rate: limit.Rate, burst: burst, tokens: burst}
+}
+
+// bucket is a token bucket. Its token count becomes negative when tokens are reserved that are not available yet.
+type bucket struct {
+	mux    sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// reserve takes a token, and returns the time until it's available.
+func (b *bucket) reserve(now time.Time) time.Duration {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	if now.After(b.last) {
+		b.last = now
+	}
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// cancel returns a reserved token.
+func (b *bucket) cancel() {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.tokens = min(b.burst, b.tokens+1)
+}
+
+type readTx struct {
+	stoabs.ReadTx
+	store *Store
+}
+
+func (t *readTx) Store() stoabs.KVStore {
+	return t.store
+}
+
+type writeTx struct {
+	stoabs.WriteTx
+	store *Store
+}
+
+func (t *writeTx) Store() stoabs.KVStore {
+	return t.store
+}
@@ -0,0 +1,147 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+const shelfName = "test"
+
+func TestRateLimit(t *testing.T) {
+	provider := func(t *testing.T) (stoabs.KVStore, error) {
+		return Wrap(stores.BBolt(t), WithReadLimit(Limit{Rate: 1000000, Burst: 1000}), WithBlocking()), nil
+	}
+
+	kvtests.TestReadingAndWriting(t, provider)
+	kvtests.TestRange(t, provider)
+	kvtests.TestIterate(t, provider)
+	kvtests.TestEmpty(t, provider)
+	kvtests.TestDelete(t, provider)
+	kvtests.TestWriteTransactions(t, provider)
+	kvtests.TestShelfNames(t, provider)
+}
+
+func TestStore_rejecting(t *testing.T) {
+	t.Run("global limit", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t), WithWriteLimit(Limit{Rate: 1, Burst: 2}))
+		now := time.Now()
+		store.now = func() time.Time { return now }
+
+		assert.NoError(t, write(store, "a"))
+		assert.NoError(t, write(store, "b"))
+		assert.ErrorIs(t, write(store, "c"), ErrRateLimited)
+		// reads are not limited
+		assert.NoError(t, read(store, "a"))
+
+		now = now.Add(time.Second)
+
+		assert.NoError(t, write(store, "c"))
+		assert.ErrorIs(t, write(store, "d"), ErrRateLimited)
+	})
+	t.Run("shelf limit", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t), WithShelfReadLimit("a", Limit{Rate: 1}))
+		now := time.Now()
+		store.now = func() time.Time { return now }
+
+		assert.NoError(t, read(store, "a"))
+		err := read(store, "a")
+		assert.ErrorIs(t, err, ErrRateLimited)
+		assert.EqualError(t, err, "rate limit exceeded (shelf=a)")
+		assert.NoError(t, read(store, "b"))
+	})
+	t.Run("rejected shelf transaction doesn't use global token", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t), WithWriteLimit(Limit{Rate: 1, Burst: 2}), WithShelfWriteLimit("a", Limit{Rate: 1}))
+		now := time.Now()
+		store.now = func() time.Time { return now }
+
+		assert.NoError(t, write(store, "a"))
+		assert.ErrorIs(t, write(store, "a"), ErrRateLimited)
+		assert.NoError(t, write(store, "b"))
+	})
+}
+
+func TestStore_blocking(t *testing.T) {
+	t.Run("waits for token", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t), WithWriteLimit(Limit{Rate: 20}), WithBlocking())
+		require.NoError(t, write(store, "a"))
+		start := time.Now()
+
+		err := write(store, "a")
+
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+	})
+	t.Run("fails right away if token isn't available before deadline", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t), WithWriteLimit(Limit{Rate: 0.1}), WithBlocking())
+		require.NoError(t, write(store, "a"))
+		ctx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		start := time.Now()
+
+		err := store.WriteShelf(ctx, "a", func(writer stoabs.Writer) error {
+			return nil
+		})
+
+		assert.ErrorIs(t, err, ErrRateLimited)
+		assert.Less(t, time.Since(start), time.Second)
+	})
+	t.Run("context cancelled while waiting", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t), WithWriteLimit(Limit{Rate: 0.1}), WithBlocking())
+		require.NoError(t, write(store, "a"))
+		ctx, cancel := context.WithCancel(ctx)
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			cancel()
+		}()
+
+		err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+			return nil
+		})
+
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.ErrorIs(t, err, stoabs.ErrDatabase{})
+	})
+}
+
+func write(store stoabs.KVStore, shelfName string) error {
+	return store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+		return writer.Put(stoabs.BytesKey("key"), []byte("value"))
+	})
+}
+
+func read(store stoabs.KVStore, shelfName string) error {
+	return store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+		_, err := reader.Get(stoabs.BytesKey("key"))
+		if err == stoabs.ErrKeyNotFound {
+			return nil
+		}
+		return err
+	})
+}
@@ -0,0 +1,114 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrReadOnly is returned when writing to a store returned by ReadOnly.
+var ErrReadOnly = errors.New("store is read-only")
+
+// ReadOnly returns a view of the store that can only be read from: Write and WriteShelf return ErrReadOnly without
+// starting a transaction. It is meant to be handed to components that must never mutate the store.
+// Closing the view doesn't close the underlying store, since it's owned by the caller of ReadOnly.
+// Transactions started on the view return nil from Unwrap, to prevent circumventing it through the underlying database.
+// Likewise, shelf readers only expose the methods of Reader, so they can't be type-asserted to Writer.
+func ReadOnly(store KVStore) KVStore {
+	if _, ok := store.(*readOnlyStore); ok {
+		return store
+	}
+	return &readOnlyStore{underlying: store}
+}
+
+type readOnlyStore struct {
+	underlying KVStore
+}
+
+func (r *readOnlyStore) Close(_ context.Context) error {
+	return nil
+}
+
+func (r *readOnlyStore) Write(_ context.Context, _ func(WriteTx) error, _ ...TxOption) error {
+	return ErrReadOnly
+}
+
+func (r *readOnlyStore) Read(ctx context.Context, fn func(ReadTx) error) error {
+	return r.underlying.Read(ctx, func(tx ReadTx) error {
+		return fn(readOnlyTx{ReadTx: tx, store: r})
+	})
+}
+
+func (r *readOnlyStore) WriteShelf(_ context.Context, _ string, _ func(Writer) error) error {
+	return ErrReadOnly
+}
+
+func (r *readOnlyStore) ReadShelf(ctx context.Context, shelfName string, fn func(Reader) error) error {
+	return r.underlying.ReadShelf(ctx, shelfName, func(reader Reader) error {
+		return fn(readOnlyReader{reader: reader})
+	})
+}
+
+// ShelfNames returns the shelves of the underlying store, or errors.ErrUnsupported if it doesn't implement ShelfLister.
+func (r *readOnlyStore) ShelfNames(ctx context.Context) ([]string, error) {
+	return ShelfNames(ctx, r.underlying)
+}
+
+type readOnlyTx struct {
+	ReadTx
+	store *readOnlyStore
+}
+
+func (t readOnlyTx) Store() KVStore {
+	return t.store
+}
+
+func (t readOnlyTx) GetShelfReader(shelfName string) Reader {
+	return readOnlyReader{reader: t.ReadTx.GetShelfReader(shelfName)}
+}
+
+func (t readOnlyTx) Unwrap() interface{} {
+	return nil
+}
+
+// readOnlyReader wraps a Reader without embedding it, so the underlying reader can't be reached using type assertions.
+type readOnlyReader struct {
+	reader Reader
+}
+
+func (r readOnlyReader) Empty() (bool, error) {
+	return r.reader.Empty()
+}
+
+func (r readOnlyReader) Get(key Key) ([]byte, error) {
+	return r.reader.Get(key)
+}
+
+func (r readOnlyReader) Iterate(callback CallerFn, keyType Key) error {
+	return r.reader.Iterate(callback, keyType)
+}
+
+func (r readOnlyReader) Range(from Key, to Key, callback CallerFn, stopAtNil bool) error {
+	return r.reader.Range(from, to, callback, stopAtNil)
+}
+
+func (r readOnlyReader) Stats() ShelfStats {
+	return r.reader.Stats()
+}
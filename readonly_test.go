@@ -0,0 +1,124 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestReadOnly(t *testing.T) {
+	ctx := context.Background()
+	key := BytesKey("key")
+
+	t.Run("Write", func(t *testing.T) {
+		store := ReadOnly(NewMockKVStore(gomock.NewController(t)))
+
+		err := store.Write(ctx, func(tx WriteTx) error {
+			t.Fatal("should not be called")
+			return nil
+		})
+
+		assert.ErrorIs(t, err, ErrReadOnly)
+	})
+	t.Run("WriteShelf", func(t *testing.T) {
+		store := ReadOnly(NewMockKVStore(gomock.NewController(t)))
+
+		err := store.WriteShelf(ctx, "shelf", func(writer Writer) error {
+			t.Fatal("should not be called")
+			return nil
+		})
+
+		assert.ErrorIs(t, err, ErrReadOnly)
+	})
+	t.Run("Read", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		underlying := NewMockKVStore(ctrl)
+		reader := NewMockReader(ctrl)
+		reader.EXPECT().Get(key).Return([]byte("value"), nil)
+		tx := NewMockReadTx(ctrl)
+		tx.EXPECT().GetShelfReader("shelf").Return(reader)
+		underlying.EXPECT().Read(ctx, gomock.Any()).DoAndReturn(func(_ context.Context, fn func(ReadTx) error) error {
+			return fn(tx)
+		})
+		store := ReadOnly(underlying)
+
+		err := store.Read(ctx, func(tx ReadTx) error {
+			assert.Same(t, store, tx.Store())
+			assert.Nil(t, tx.Unwrap())
+			shelf := tx.GetShelfReader("shelf")
+			_, isWriter := shelf.(Writer)
+			assert.False(t, isWriter)
+			value, err := shelf.Get(key)
+			assert.Equal(t, []byte("value"), value)
+			return err
+		})
+
+		assert.NoError(t, err)
+	})
+	t.Run("ReadShelf", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		underlying := NewMockKVStore(ctrl)
+		// the underlying reader is a Writer as well, as is the case for most stores
+		reader := NewMockWriter(ctrl)
+		reader.EXPECT().Get(key).Return([]byte("value"), nil)
+		underlying.EXPECT().ReadShelf(ctx, "shelf", gomock.Any()).DoAndReturn(func(_ context.Context, _ string, fn func(Reader) error) error {
+			return fn(reader)
+		})
+
+		var value []byte
+		err := ReadOnly(underlying).ReadShelf(ctx, "shelf", func(reader Reader) error {
+			_, isWriter := reader.(Writer)
+			assert.False(t, isWriter)
+			var err error
+			value, err = reader.Get(key)
+			return err
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, []byte("value"), value)
+	})
+	t.Run("ShelfNames", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		underlying := struct {
+			*MockKVStore
+			*MockShelfLister
+		}{NewMockKVStore(ctrl), NewMockShelfLister(ctrl)}
+		underlying.MockShelfLister.EXPECT().ShelfNames(ctx).Return([]string{"a"}, nil)
+
+		names, err := ShelfNames(ctx, ReadOnly(underlying))
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"a"}, names)
+	})
+	t.Run("Close doesn't close underlying store", func(t *testing.T) {
+		store := ReadOnly(NewMockKVStore(gomock.NewController(t)))
+
+		assert.NoError(t, store.Close(ctx))
+	})
+	t.Run("wrapping twice", func(t *testing.T) {
+		store := ReadOnly(NewMockKVStore(gomock.NewController(t)))
+
+		assert.Same(t, store, ReadOnly(store))
+	})
+}
@@ -0,0 +1,124 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package redis7
+
+import (
+	"errors"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/redis/go-redis/v9"
+)
+
+var _ stoabs.RangeAggregator = shelf{}
+
+// aggregateScript computes an aggregate over the values of the given keys, so the values don't have to be transferred:
+//   - sum: returns the sums of the most and least significant 32 bits of the (uint64) values, and the (1-based) index of
+//     the first value that isn't an uint64 (8 bytes), or 0.
+//   - min/max: returns the index of the first/last existing key, or 0.
+var aggregateScript = redis.NewScript(`
+local values = redis.call('MGET', unpack(KEYS))
+if ARGV[1] == 'sum' then
+	local hi, lo = 0, 0
+	for i, v in ipairs(values) do
+		if v then
+			if #v ~= 8 then
+				return {0, 0, i}
+			end
+			local a, b, c, d, e, f, g, h = string.byte(v, 1, 8)
+			hi = hi + ((a * 256 + b) * 256 + c) * 256 + d
+			lo = lo + ((e * 256 + f) * 256 + g) * 256 + h
+		end
+	end
+	return {hi, lo, 0}
+end
+local found = 0
+for i, v in ipairs(values) do
+	if v then
+		found = i
+		if ARGV[1] == 'min' then
+			break
+		end
+	end
+end
+return found
+`)
+
+// Aggregate computes the built-in aggregates (stoabs.Count, stoabs.SumUint64, stoabs.MinKey and stoabs.MaxKey) in Redis.
+func (s shelf) Aggregate(from stoabs.Key, to stoabs.Key, aggregator stoabs.Aggregator) error {
+	switch agg := aggregator.(type) {
+	case *stoabs.Count:
+		return s.rangeKeys(from, to, func(keys []string) (bool, error) {
+			count, err := s.reader.Exists(s.ctx, keys...).Result()
+			if err != nil {
+				return false, stoabs.DatabaseError(err)
+			}
+			agg.N += int(count)
+			return true, nil
+		})
+	case *stoabs.SumUint64:
+		return s.rangeKeys(from, to, func(keys []string) (bool, error) {
+			result, err := aggregateScript.Run(s.ctx, s.reader, keys, "sum").Int64Slice()
+			if err != nil {
+				return false, stoabs.DatabaseError(err)
+			}
+			if result[2] > 0 {
+				key, err := s.fromRedisKey(keys[result[2]-1], from)
+				if err != nil {
+					return false, err
+				}
+				return false, stoabs.InvalidUint64Error(key)
+			}
+			agg.Sum += uint64(result[0])<<32 + uint64(result[1])
+			return true, nil
+		})
+	case *stoabs.MinKey:
+		return s.rangeKeys(from, to, func(keys []string) (bool, error) {
+			key, err := s.aggregateKey(keys, from, "min")
+			if key != nil {
+				if err := agg.Add(key, nil); err != nil {
+					return false, err
+				}
+			}
+			// keys are visited in ascending order, so the first key found is the lowest
+			return key == nil && err == nil, err
+		})
+	case *stoabs.MaxKey:
+		return s.rangeKeys(from, to, func(keys []string) (bool, error) {
+			key, err := s.aggregateKey(keys, from, "max")
+			if key != nil {
+				if err := agg.Add(key, nil); err != nil {
+					return false, err
+				}
+			}
+			return err == nil, err
+		})
+	}
+	return errors.ErrUnsupported
+}
+
+// aggregateKey returns the first (min) or last (max) existing key of the given keys, or nil if none exists.
+func (s shelf) aggregateKey(keys []string, keyType stoabs.Key, op string) (stoabs.Key, error) {
+	index, err := aggregateScript.Run(s.ctx, s.reader, keys, op).Int64()
+	if err != nil {
+		return nil, stoabs.DatabaseError(err)
+	}
+	if index == 0 {
+		return nil, nil
+	}
+	return s.fromRedisKey(keys[index-1], keyType)
+}
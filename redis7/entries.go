@@ -0,0 +1,103 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package redis7
+
+import (
+	"bytes"
+	"github.com/nuts-foundation/go-stoabs"
+	"iter"
+	"sort"
+)
+
+var _ stoabs.EntryIterator = shelf{}
+
+// Entries scans the keys of the shelf in batches, and reads the values of the keys from..to per page as the loop advances.
+// Unlike Range, which looks up every possible key from..to, its cost is proportional to the size of the shelf,
+// which makes it suited for sparse ranges (e.g. of BytesKey) but not for small ranges of a large shelf.
+// Since SCAN returns keys in an unspecified order, the keys in the range are sorted before reading the values.
+func (s shelf) Entries(from stoabs.Key, to stoabs.Key) iter.Seq2[stoabs.KeyValue, error] {
+	return func(yield func(stoabs.KeyValue, error) bool) {
+		keys, err := s.scanRange(from, to)
+		if err != nil {
+			yield(stoabs.KeyValue{}, err)
+			return
+		}
+		for start := 0; start < len(keys); start += resultCount {
+			page := keys[start:min(start+resultCount, len(keys))]
+			redisKeys := make([]string, len(page))
+			for i, key := range page {
+				redisKeys[i] = s.toRedisKey(key)
+			}
+			values, err := s.reader.MGet(s.ctx, redisKeys...).Result()
+			if err != nil {
+				yield(stoabs.KeyValue{}, stoabs.DatabaseError(err))
+				return
+			}
+			for i, value := range values {
+				// Consumers may take a while for large pages, check context for cancellation
+				if s.ctx.Err() != nil {
+					yield(stoabs.KeyValue{}, stoabs.DatabaseError(s.ctx.Err()))
+					return
+				}
+				if value == nil {
+					// deleted after scanning
+					continue
+				}
+				if !yield(stoabs.KeyValue{Key: page[i], Value: []byte(value.(string))}, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// scanRange returns the keys of the shelf from..to, ordered by key.
+func (s shelf) scanRange(from stoabs.Key, to stoabs.Key) ([]stoabs.Key, error) {
+	var result []stoabs.Key
+	seen := map[string]bool{}
+	var cursor uint64
+	for {
+		redisKeys, next, err := s.scan(cursor)
+		if err != nil {
+			return nil, stoabs.DatabaseError(err)
+		}
+		for _, redisKey := range redisKeys {
+			// SCAN can return a key more than once
+			if seen[redisKey] {
+				continue
+			}
+			seen[redisKey] = true
+			key, err := s.fromRedisKey(redisKey, from)
+			if err != nil {
+				return nil, err
+			}
+			if bytes.Compare(key.Bytes(), from.Bytes()) >= 0 && bytes.Compare(key.Bytes(), to.Bytes()) < 0 {
+				result = append(result, key)
+			}
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return bytes.Compare(result[i].Bytes(), result[j].Bytes()) < 0
+	})
+	return result, nil
+}
@@ -0,0 +1,117 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package redis7
+
+import (
+	"context"
+	"fmt"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/redis/go-redis/v9"
+	"strings"
+)
+
+// notifyKeyspaceEvents is the Redis configuration parameter that specifies which keyspace notifications are published.
+const notifyKeyspaceEvents = "notify-keyspace-events"
+
+// WatchEvictions subscribes to the keyevent notifications of evicted keys, and invokes fn for the evicted keys of the store's shelves.
+// Redis only evicts keys when it reaches its memory limit (maxmemory) and the eviction policy (maxmemory-policy) isn't noeviction,
+// see stoabs.StoreStats. Notifications of evicted keys are enabled if they aren't already (notify-keyspace-events "Ee");
+// servers that don't allow changing their configuration (e.g. managed Redis services) must be configured to publish them.
+// Redis delivers notifications at most once, so evictions that happen while not subscribed (e.g. during a reconnect) aren't reported.
+func (s *store) WatchEvictions(ctx context.Context, fn func(eviction stoabs.Eviction)) error {
+	if err := s.checkOpen(); err != nil {
+		return err
+	}
+	s.enableEvictionNotifications(ctx)
+	pubsub := s.client.Subscribe(ctx, evictedChannel(s.client))
+	defer pubsub.Close()
+	// Wait for the subscription to be confirmed, so a failing subscription is reported
+	if _, err := pubsub.Receive(ctx); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return stoabs.DatabaseError(err)
+	}
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case message, ok := <-messages:
+			if !ok {
+				return nil
+			}
+			if eviction, ok := s.evictionFromRedisKey(message.Payload); ok {
+				s.evictions.Add(1)
+				fn(eviction)
+			}
+		}
+	}
+}
+
+// evictionFromRedisKey returns the shelf and key of an evicted Redis key created by shelf.toRedisKey.
+// It returns false if the key doesn't belong to a shelf of the store.
+func (s *store) evictionFromRedisKey(redisKey string) (stoabs.Eviction, bool) {
+	name, ok := s.shelfNameFromRedisKey(redisKey)
+	if !ok {
+		return stoabs.Eviction{}, false
+	}
+	shelfName, ok := s.cfg.TrimShelfName(name)
+	if !ok {
+		return stoabs.Eviction{}, false
+	}
+	return stoabs.Eviction{Shelf: shelfName, Key: redisKey[strings.LastIndex(redisKey, ".")+1:]}, true
+}
+
+// enableEvictionNotifications enables the keyevent notifications of evicted keys, keeping the notifications that were already enabled.
+// Failures (e.g. because the CONFIG command is disabled) are logged but otherwise ignored, since the server might be configured to publish them.
+func (s *store) enableEvictionNotifications(ctx context.Context) {
+	config, err := s.client.ConfigGet(ctx, notifyKeyspaceEvents).Result()
+	if err != nil {
+		s.log.WithError(err).Warnf("Unable to read Redis configuration, make sure %s includes evicted keys (Ee)", notifyKeyspaceEvents)
+		return
+	}
+	flags, changed := withEvictionNotifications(config[notifyKeyspaceEvents])
+	if !changed {
+		return
+	}
+	if err = s.client.ConfigSet(ctx, notifyKeyspaceEvents, flags).Err(); err != nil {
+		s.log.WithError(err).Warnf("Unable to enable notifications of evicted keys, make sure %s includes evicted keys (Ee)", notifyKeyspaceEvents)
+		return
+	}
+	s.log.Infof("Enabled notifications of evicted keys (%s=%s)", notifyKeyspaceEvents, flags)
+}
+
+// withEvictionNotifications returns the notify-keyspace-events flags with keyevent notifications (E) of evicted keys (e) enabled,
+// and whether they had to be added. The A flag is an alias for all key types and events, including evicted keys.
+func withEvictionNotifications(flags string) (string, bool) {
+	result := flags
+	if !strings.Contains(result, "E") {
+		result += "E"
+	}
+	if !strings.ContainsAny(result, "eA") {
+		result += "e"
+	}
+	return result, result != flags
+}
+
+// evictedChannel returns the channel on which Redis publishes the names of keys evicted from the client's database.
+func evictedChannel(client *redis.Client) string {
+	return fmt.Sprintf("__keyevent@%d__:evicted", client.Options().DB)
+}
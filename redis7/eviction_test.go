@@ -0,0 +1,124 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package redis7
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRedis_WatchEvictions(t *testing.T) {
+	// watch starts watching the evictions of the given store, and returns a function that returns the evictions received so far
+	watch := func(t *testing.T, mr *miniredis.Miniredis, s stoabs.KVStore) func() []stoabs.Eviction {
+		ctx, cancel := context.WithCancel(context.Background())
+		var mux sync.Mutex
+		var evictions []stoabs.Eviction
+		stopped := make(chan error, 1)
+		go func() {
+			stopped <- stoabs.WatchEvictions(ctx, s, func(eviction stoabs.Eviction) {
+				mux.Lock()
+				defer mux.Unlock()
+				evictions = append(evictions, eviction)
+			})
+		}()
+		t.Cleanup(func() {
+			cancel()
+			assert.NoError(t, <-stopped)
+		})
+		require.Eventually(t, func() bool {
+			return mr.PubSubNumSub("__keyevent@0__:evicted")["__keyevent@0__:evicted"] == 1
+		}, 5*time.Second, 10*time.Millisecond)
+		return func() []stoabs.Eviction {
+			mux.Lock()
+			defer mux.Unlock()
+			return append([]stoabs.Eviction(nil), evictions...)
+		}
+	}
+
+	t.Run("reports evicted keys of the store", func(t *testing.T) {
+		mr, s := NewTestStore(t)
+		evictions := watch(t, mr, &s)
+
+		mr.Publish("__keyevent@0__:evicted", "db:shelf.6b6579")
+		mr.Publish("__keyevent@0__:evicted", "other:shelf.6b6579")
+		mr.Publish("__keyevent@0__:evicted", "db:not-a-shelf")
+		mr.Publish("__keyevent@0__:evicted", "db:my.shelf.123")
+
+		require.Eventually(t, func() bool {
+			return len(evictions()) == 2
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Equal(t, []stoabs.Eviction{{Shelf: "shelf", Key: "6b6579"}, {Shelf: "my.shelf", Key: "123"}}, evictions())
+		stats, err := s.Stats(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, uint64(2), stats.Evictions)
+	})
+	t.Run("key prefix", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		s, err := CreateRedisStore("", &redis.Options{Addr: mr.Addr()}, stoabs.WithKeyPrefix("app1/"))
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = s.Close(context.Background())
+		})
+		evictions := watch(t, mr, s)
+
+		mr.Publish("__keyevent@0__:evicted", "app2/shelf.1")
+		mr.Publish("__keyevent@0__:evicted", "app1/shelf.2")
+
+		require.Eventually(t, func() bool {
+			return len(evictions()) == 1
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Equal(t, []stoabs.Eviction{{Shelf: "shelf", Key: "2"}}, evictions())
+	})
+	t.Run("store is closed", func(t *testing.T) {
+		_, s := NewTestStore(t)
+		require.NoError(t, s.Close(context.Background()))
+
+		err := s.WatchEvictions(context.Background(), func(stoabs.Eviction) {})
+
+		assert.ErrorIs(t, err, stoabs.ErrStoreIsClosed)
+	})
+}
+
+func TestWithEvictionNotifications(t *testing.T) {
+	testCases := []struct {
+		flags    string
+		expected string
+		changed  bool
+	}{
+		{"", "Ee", true},
+		{"Kx", "KxEe", true},
+		{"Ex", "Exe", true},
+		{"Ke", "KeE", true},
+		{"Ee", "Ee", false},
+		{"AKE", "AKE", false},
+	}
+	for _, testCase := range testCases {
+		flags, changed := withEvictionNotifications(testCase.flags)
+
+		assert.Equal(t, testCase.expected, flags, testCase.flags)
+		assert.Equal(t, testCase.changed, changed, testCase.flags)
+	}
+}
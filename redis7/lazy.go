@@ -0,0 +1,79 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package redis7
+
+import (
+	"errors"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/redis/go-redis/v9"
+)
+
+var _ stoabs.LazyRanger = shelf{}
+
+// existsScript returns for each of the given keys whether it exists (1) or not (0), without transferring the values.
+var existsScript = redis.NewScript(`
+local result = {}
+for i, key in ipairs(KEYS) do
+	result[i] = redis.call('EXISTS', key)
+end
+return result
+`)
+
+// RangeLazy checks which keys exist per page, and only performs a GET for the keys whose loader is called.
+func (s shelf) RangeLazy(from stoabs.Key, to stoabs.Key, callback stoabs.LazyCallerFn, stopAtNil bool) error {
+	return s.rangeKeys(from, to, func(keys []string) (bool, error) {
+		exists, err := existsScript.Run(s.ctx, s.reader, keys).Int64Slice()
+		if err != nil {
+			return false, stoabs.DatabaseError(err)
+		}
+		for i, redisKey := range keys {
+			// Callbacks may take a while for large pages, check context for cancellation
+			if s.ctx.Err() != nil {
+				return false, stoabs.DatabaseError(s.ctx.Err())
+			}
+			if exists[i] == 0 {
+				if stopAtNil {
+					return false, nil
+				}
+				continue
+			}
+			key, err := s.fromRedisKey(redisKey, from)
+			if err != nil {
+				return false, err
+			}
+			if err := callback(key, s.valueLoader(redisKey)); err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	})
+}
+
+func (s shelf) valueLoader(redisKey string) stoabs.ValueLoader {
+	return func() ([]byte, error) {
+		value, err := s.reader.Get(s.ctx, redisKey).Bytes()
+		if errors.Is(err, redis.Nil) {
+			return nil, stoabs.ErrKeyNotFound
+		}
+		if err != nil {
+			return nil, stoabs.DatabaseError(err)
+		}
+		return value, nil
+	}
+}
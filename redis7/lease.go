@@ -0,0 +1,87 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package redis7
+
+import (
+	"context"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/util"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+var _ stoabs.Leaser = (*store)(nil)
+
+// extendLeaseScript extends the lease (PEXPIRE) if it's held with the given token, returning 1 if it was extended.
+var extendLeaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseLeaseScript removes the lease if it's held with the given token.
+var releaseLeaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// AcquireLease acquires a lease using SET NX PX, so it expires in Redis when it's not kept alive,
+// and excludes owners in other processes as well.
+func (s *store) AcquireLease(ctx context.Context, name string, ttl time.Duration) (stoabs.Lease, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	token := util.NewLeaseToken()
+	acquired, err := s.client.SetNX(ctx, s.leaseKey(name), token, ttl).Result()
+	if err != nil {
+		return nil, stoabs.DatabaseError(err)
+	}
+	if !acquired {
+		return nil, stoabs.ErrLeaseHeld
+	}
+	return util.NewLease(s, name, token, ttl), nil
+}
+
+func (s *store) ExtendLease(ctx context.Context, name string, token string, ttl time.Duration) (bool, error) {
+	if err := s.checkOpen(); err != nil {
+		return false, err
+	}
+	extended, err := extendLeaseScript.Run(ctx, s.client, []string{s.leaseKey(name)}, token, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return false, stoabs.DatabaseError(err)
+	}
+	return extended == 1, nil
+}
+
+func (s *store) ReleaseLease(ctx context.Context, name string, token string) error {
+	if err := s.checkOpen(); err != nil {
+		return err
+	}
+	if err := releaseLeaseScript.Run(ctx, s.client, []string{s.leaseKey(name)}, token).Err(); err != nil {
+		return stoabs.DatabaseError(err)
+	}
+	return nil
+}
+
+func (s *store) leaseKey(name string) string {
+	return "lease_" + s.prefix + ":" + s.cfg.ShelfName(name)
+}
@@ -0,0 +1,86 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package redis7
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestStore_AcquireLease(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	store, err := CreateRedisStore("db", &redis.Options{Addr: mr.Addr()})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = store.Close(ctx)
+	})
+
+	t.Run("lease expires in Redis", func(t *testing.T) {
+		lease, err := stoabs.AcquireLease(ctx, store, "expires", time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, time.Minute, mr.TTL("lease_db:expires"))
+
+		mr.FastForward(time.Minute)
+
+		other, err := stoabs.AcquireLease(ctx, store, "expires", time.Minute)
+		require.NoError(t, err)
+		defer other.Release(ctx)
+		t.Run("keep alive of lost lease", func(t *testing.T) {
+			called := false
+			lease.OnExpired(func() {
+				called = true
+			})
+
+			err := lease.KeepAlive(ctx)
+
+			assert.ErrorIs(t, err, stoabs.ErrLeaseLost)
+			assert.True(t, called)
+		})
+		t.Run("release of lost lease doesn't release the new owner's lease", func(t *testing.T) {
+			assert.NoError(t, lease.Release(ctx))
+			assert.True(t, mr.Exists("lease_db:expires"))
+		})
+	})
+	t.Run("keep alive resets the TTL", func(t *testing.T) {
+		lease, err := stoabs.AcquireLease(ctx, store, "kept", time.Minute)
+		require.NoError(t, err)
+		defer lease.Release(ctx)
+		mr.FastForward(30 * time.Second)
+
+		require.NoError(t, lease.KeepAlive(ctx))
+
+		assert.Equal(t, time.Minute, mr.TTL("lease_db:kept"))
+	})
+	t.Run("store is closed", func(t *testing.T) {
+		store, err := CreateRedisStore("db", &redis.Options{Addr: mr.Addr()})
+		require.NoError(t, err)
+		_ = store.Close(ctx)
+
+		_, err = stoabs.AcquireLease(ctx, store, "closed", time.Minute)
+
+		assert.ErrorIs(t, err, stoabs.ErrStoreIsClosed)
+	})
+}
@@ -0,0 +1,219 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package redis7
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redsync/redsync/v4"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/util"
+	"github.com/redis/go-redis/v9"
+)
+
+// lockValueSeparator separates the label of the holder of a lock from the random part of the lock's value.
+// It doesn't occur in base64 encoded values (as generated by redsync for unlabeled locks).
+const lockValueSeparator = "|"
+
+// errLockLost is returned when a transaction can't be committed because it lost one of its locks.
+var errLockLost = errors.New("lost Redis transaction-level write lock")
+
+// distributedLock is a Redis distributed lock held by this store. Its lease is renewed until it's released.
+type distributedLock struct {
+	mutex *redsync.Mutex
+	// fenceKey is the Redis key of the counter that is incremented every time the lock is acquired.
+	// It is empty if the lock is not fenced.
+	fenceKey string
+	// token is the value of the fence counter after this lock was acquired (the fencing token).
+	token uint64
+	lost  atomic.Bool
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// lockAll acquires the Redis distributed locks with the given names in order.
+// If a lock can't be acquired, the locks acquired so far are released.
+func (s *store) lockAll(ctx context.Context, lockNames []string, fenced bool, label string) ([]*distributedLock, error) {
+	var result []*distributedLock
+	for _, name := range lockNames {
+		lock, err := s.lock(ctx, name, fenced, label)
+		if err != nil {
+			s.releaseAll(ctx, result)
+			return nil, err
+		}
+		result = append(result, lock)
+	}
+	return result, nil
+}
+
+// lock acquires the Redis distributed lock with the given name and starts renewing its lease.
+// If fenced, the lock's fence counter is incremented after acquiring it.
+// The label (if any) is stored in the lock's value, so others failing to acquire the lock can report its holder.
+func (s *store) lock(ctx context.Context, lockName string, fenced bool, label string) (*distributedLock, error) {
+	s.log.Tracef("Acquiring Redis distributed lock (name=%s)", lockName)
+	// Sub-context for lock acquisition
+	lockCtx, lockCtxCancel := stoabs.ContextWithTimeout(ctx, s.cfg.Clock, s.cfg.LockAcquireTimeout)
+	defer lockCtxCancel()
+	// Acquire lock
+	mutexOptions := []redsync.Option{redsync.WithExpiry(s.cfg.LockLease)}
+	if label != "" {
+		mutexOptions = append(mutexOptions, redsync.WithGenValueFunc(func() (string, error) {
+			return lockValue(label)
+		}))
+	}
+	result := &distributedLock{
+		mutex: s.rs.NewMutex(lockName, mutexOptions...),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	err := result.mutex.LockContext(lockCtx)
+	if err != nil && errors.Is(lockCtx.Err(), context.DeadlineExceeded) {
+		err = stoabs.ErrLockTimeout{Lock: lockName, Holder: s.lockHolder(ctx, lockName)}
+	} else if err != nil && lockCtx.Err() != nil {
+		// redsync doesn't return the context error when giving up
+		err = util.WrapError(err, lockCtx.Err())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to obtain Redis transaction-level write lock: %w", stoabs.DatabaseError(err))
+	}
+	if fenced {
+		result.fenceKey = lockName + "_fence"
+		result.token, err = s.client.Incr(lockCtx, result.fenceKey).Uint64()
+		if err != nil {
+			_, _ = result.mutex.UnlockContext(context.WithoutCancel(ctx))
+			return nil, fmt.Errorf("unable to obtain Redis transaction-level write lock fencing token: %w", stoabs.DatabaseError(err))
+		}
+	}
+	go s.renew(context.WithoutCancel(ctx), result)
+	return result, nil
+}
+
+// lockValue returns a unique value for a lock held by a transaction with the given label.
+func lockValue(label string) (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return label + lockValueSeparator + base64.StdEncoding.EncodeToString(random), nil
+}
+
+// lockHolder returns the label of the current holder of the given lock, or an empty string if it's unknown.
+func (s *store) lockHolder(ctx context.Context, lockName string) string {
+	// the acquisition context has expired, but the transaction context may not have
+	holderCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Second)
+	defer cancel()
+	value, err := s.client.Get(holderCtx, lockName).Result()
+	if err != nil {
+		return ""
+	}
+	idx := strings.LastIndex(value, lockValueSeparator)
+	if idx < 0 {
+		return ""
+	}
+	return value[:idx]
+}
+
+// renew extends the lease of the given lock until it's released. If the lease can't be extended, the lock is marked as lost.
+func (s *store) renew(ctx context.Context, lock *distributedLock) {
+	defer close(lock.done)
+	ticker := time.NewTicker(s.cfg.LockLease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-lock.stop:
+			return
+		case <-ticker.C:
+			extendCtx, cancel := context.WithTimeout(ctx, s.cfg.LockLease/3)
+			ok, err := lock.mutex.ExtendContext(extendCtx)
+			cancel()
+			if !ok || err != nil {
+				s.log.Errorf("Unable to renew Redis distributed lock (name=%s): %v", lock.mutex.Name(), err)
+				lock.lost.Store(true)
+				return
+			}
+		}
+	}
+}
+
+// releaseAll releases the given locks in reverse order.
+func (s *store) releaseAll(ctx context.Context, locks []*distributedLock) {
+	for i := len(locks) - 1; i >= 0; i-- {
+		s.release(ctx, locks[i])
+	}
+}
+
+// release stops renewing the lease of the given lock and releases it.
+// If the transaction context expired, the lock isn't released since the server may still be writing the data.
+func (s *store) release(ctx context.Context, lock *distributedLock) {
+	close(lock.stop)
+	<-lock.done
+	lockName := lock.mutex.Name()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		s.log.Warnf("Not releasing Redis distributed lock, because the transaction context has expired and the server may still writing the data. Lock will expire automatically (name=%s,expiresIn=%s)", lockName, time.Until(lock.mutex.Until()))
+		return
+	}
+	s.log.Tracef("Releasing Redis distributed lock (name=%s)", lockName)
+	// the lock must be released even if the context was cancelled
+	releaseLockCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	_, err := lock.mutex.UnlockContext(releaseLockCtx)
+	if err != nil {
+		s.log.Errorf("Unable to release Redis transaction-level write lock: %s", err)
+	}
+}
+
+// checkFences returns errLockLost if a lease of the given locks couldn't be renewed,
+// or if a fenced lock has been acquired by another holder since (meaning its lease expired).
+// It must be called on a connection that watches the fence keys, so the transaction fails if they change before it's committed.
+func checkFences(ctx context.Context, conn redis.Cmdable, locks []*distributedLock) error {
+	for _, lock := range locks {
+		if lock.lost.Load() {
+			return errLockLost
+		}
+		if lock.fenceKey == "" {
+			continue
+		}
+		token, err := conn.Get(ctx, lock.fenceKey).Uint64()
+		if err != nil {
+			return stoabs.DatabaseError(err)
+		}
+		if token != lock.token {
+			return errLockLost
+		}
+	}
+	return nil
+}
+
+// fenceKeys returns the fence keys of the given locks.
+func fenceKeys(locks []*distributedLock) []string {
+	var result []string
+	for _, lock := range locks {
+		if lock.fenceKey != "" {
+			result = append(result, lock.fenceKey)
+		}
+	}
+	return result
+}
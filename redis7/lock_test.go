@@ -0,0 +1,135 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package redis7
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_lockLease(t *testing.T) {
+	ctx := context.Background()
+	lease := 300 * time.Millisecond
+
+	t.Run("lease is renewed while the transaction runs", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		store := createLeaseStore(t, mr, lease)
+
+		err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+			// miniredis only expires keys when time is fast-forwarded
+			for i := 0; i < 6; i++ {
+				time.Sleep(lease / 2)
+				mr.FastForward(lease / 2)
+				if !mr.Exists("lock_db") {
+					return assert.AnError
+				}
+			}
+			return tx.GetShelfWriter("shelf").Put(stoabs.BytesKey("key"), []byte("value"))
+		}, stoabs.WithWriteLock())
+
+		assert.NoError(t, err)
+		assert.False(t, mr.Exists("lock_db"), "lock should be released")
+	})
+	t.Run("key locks are renewed until released", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		store := createLeaseStore(t, mr, lease)
+		release, err := stoabs.LockKeys(ctx, store, "shelf", stoabs.BytesKey("key"))
+		require.NoError(t, err)
+		lockName := "db:" + keyLockSpace + "shelf." + stoabs.BytesKey("key").String()
+
+		for i := 0; i < 6; i++ {
+			time.Sleep(lease / 2)
+			mr.FastForward(lease / 2)
+		}
+
+		assert.True(t, mr.Exists(lockName))
+		release()
+		assert.False(t, mr.Exists(lockName))
+	})
+	t.Run("key locks aren't listed as shelves", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		for _, prefix := range []string{"", "db"} {
+			store, err := CreateRedisStore(prefix, &redis.Options{Addr: mr.Addr()})
+			require.NoError(t, err)
+			defer store.Close(ctx)
+			release, err := stoabs.LockKeys(ctx, store, "shelf", stoabs.BytesKey("key"))
+			require.NoError(t, err)
+			defer release()
+
+			shelfNames, err := stoabs.ShelfNames(stoabs.ContextWithReservedShelves(ctx), store)
+
+			require.NoError(t, err)
+			assert.Empty(t, shelfNames, "prefix %q", prefix)
+		}
+	})
+	t.Run("commit fails if another writer acquired the lock", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		store := createLeaseStore(t, mr, lease)
+		other := createLeaseStore(t, mr, lease)
+
+		err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+			if err := tx.GetShelfWriter("shelf").Put(stoabs.BytesKey("key"), []byte("stale")); err != nil {
+				return err
+			}
+			// simulate the lease expiring (e.g. because the process stalled), after which another writer acquires the lock
+			mr.Del("lock_db")
+			return other.Write(ctx, func(tx stoabs.WriteTx) error {
+				return tx.GetShelfWriter("shelf").Put(stoabs.BytesKey("key"), []byte("fresh"))
+			}, stoabs.WithWriteLock())
+		}, stoabs.WithWriteLock())
+
+		assert.ErrorIs(t, err, stoabs.ErrCommitFailed)
+		_ = store.ReadShelf(ctx, "shelf", func(reader stoabs.Reader) error {
+			value, err := reader.Get(stoabs.BytesKey("key"))
+			require.NoError(t, err)
+			assert.Equal(t, "fresh", string(value))
+			return nil
+		})
+	})
+	t.Run("fencing token is incremented on every acquisition", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		store := createLeaseStore(t, mr, lease)
+		noop := func(tx stoabs.WriteTx) error { return nil }
+
+		require.NoError(t, store.Write(ctx, noop, stoabs.WithWriteLock()))
+		require.NoError(t, store.Write(ctx, noop, stoabs.WithShelfLock("shelf")))
+		require.NoError(t, store.Write(ctx, noop, stoabs.WithWriteLock()))
+
+		token, _ := mr.Get("lock_db_fence")
+		assert.Equal(t, "2", token)
+		token, _ = mr.Get("lock_db/shelf_fence")
+		assert.Equal(t, "1", token)
+	})
+}
+
+func createLeaseStore(t *testing.T, mr *miniredis.Miniredis, lease time.Duration) stoabs.KVStore {
+	store, err := CreateRedisStore("db", &redis.Options{Addr: mr.Addr()}, stoabs.WithLockLease(lease), stoabs.WithLockAcquireTimeout(lease))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = store.Close(context.Background())
+	})
+	return store
+}
@@ -19,6 +19,7 @@
 package redis7
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -28,15 +29,19 @@ import (
 	"github.com/nuts-foundation/go-stoabs/util"
 	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const resultCount = 1000
 
-// lockExpiryOffset specifies how much time is added to the context deadline as expiry for TX locks
-const lockExpiryOffset = 5 * time.Second
+// keyLockSpace is the reserved subspace of the Redis keys of a store holding the locks acquired by LockKeys.
+// It starts with stoabs.ReservedShelfPrefix, so it doesn't collide with the keys of shelves, and it's omitted from ShelfNames.
+const keyLockSpace = stoabs.ReservedShelfPrefix + "locks:"
 
 // pingAttempts specifies how many times a ping (Redis connection check) should be attempted.
 const pingAttempts = 5
@@ -47,6 +52,12 @@ const pingTimeout = 3 * time.Second
 var PingAttemptBackoff = 2 * time.Second
 
 var _ stoabs.KVStore = (*store)(nil)
+var _ stoabs.ShelfLister = (*store)(nil)
+var _ stoabs.Locker = (*store)(nil)
+var _ stoabs.StateReporter = (*store)(nil)
+var _ stoabs.Snapshotter = (*store)(nil)
+var _ stoabs.EvictionWatcher = (*store)(nil)
+var _ stoabs.CapabilityReporter = (*store)(nil)
 var _ stoabs.ReadTx = (*tx)(nil)
 var _ stoabs.WriteTx = (*tx)(nil)
 var _ stoabs.Reader = (*shelf)(nil)
@@ -68,9 +79,11 @@ func Wrap(prefix string, client *redis.Client, opts ...stoabs.Option) (stoabs.KV
 	}
 
 	result := &store{
-		prefix: prefix,
-		mux:    &sync.RWMutex{},
-		cfg:    cfg,
+		prefix:    prefix,
+		mux:       &sync.RWMutex{},
+		drainer:   &util.Drainer{},
+		cfg:       cfg,
+		evictions: &atomic.Uint64{},
 	}
 
 	result.log = cfg.Log
@@ -108,19 +121,38 @@ type store struct {
 	// which isn't very practical.
 	prefix string
 	cfg    stoabs.Config
+	// replicas are optionally used for read transactions that allow stale reads, see WrapReplicated.
+	replicas    []*redis.Client
+	nextReplica uint32
+	// drainer tracks in-flight transactions, so Close can wait for them
+	drainer *util.Drainer
+	// evictions counts the evicted keys of the store, see WatchEvictions.
+	evictions *atomic.Uint64
 }
 
 func (s *store) Close(ctx context.Context) error {
+	s.log.Debug("Closing Redis store")
+	err := s.drainer.Close(ctx, s.close)
+	if err != nil && ctx.Err() != nil {
+		s.log.Error("Closing of Redis store timed out, in-flight transactions were aborted.")
+	}
+	return err
+}
+
+// close closes the Redis clients, after all in-flight transactions finished.
+func (s *store) close() error {
 	s.mux.Lock()
 	defer s.mux.Unlock()
-	s.log.Debug("Closing Redis store")
 	if s.client == nil {
 		// already closed
 		return nil
 	}
-	err := util.CallWithTimeout(ctx, s.client.Close, func() {
-		s.log.Error("Closing of Redis client timed out")
-	})
+	err := s.client.Close()
+	for _, replica := range s.replicas {
+		if replicaErr := replica.Close(); replicaErr != nil && err == nil {
+			err = replicaErr
+		}
+	}
 	s.client = nil
 	if err != nil {
 		return stoabs.DatabaseError(err)
@@ -128,52 +160,183 @@ func (s *store) Close(ctx context.Context) error {
 	return nil
 }
 
+// Capabilities reports CapabilityOrderedIteration only if it's enabled (see stoabs.WithOrderedIteration), since Redis
+// doesn't keep keys in order.
+func (s *store) Capabilities() stoabs.Capabilities {
+	result := stoabs.CapabilityShelfLister | stoabs.CapabilityLocker | stoabs.CapabilityDistributedLock | stoabs.CapabilityLeaser |
+		stoabs.CapabilityReadOptions | stoabs.CapabilityState | stoabs.CapabilityStats | stoabs.CapabilityFreeze |
+		stoabs.CapabilitySnapshot | stoabs.CapabilityEvictionWatcher | stoabs.CapabilityLazyRange | stoabs.CapabilityEntries |
+		stoabs.CapabilitySample | stoabs.CapabilityRangeAggregator
+	if s.cfg.OrderedIteration {
+		result |= stoabs.CapabilityOrderedIteration
+	}
+	return result
+}
+
+func (s *store) State() stoabs.State {
+	return s.drainer.State()
+}
+
+func (s *store) Freeze(ctx context.Context) error {
+	return s.drainer.Freeze(ctx)
+}
+
+func (s *store) Unfreeze() {
+	s.drainer.Unfreeze()
+}
+
+func (s *store) Frozen() bool {
+	return s.drainer.Frozen()
+}
+
 func (s *store) Write(ctx context.Context, fn func(stoabs.WriteTx) error, opts ...stoabs.TxOption) error {
-	if err := s.checkOpen(); err != nil {
+	ctx, done, err := s.drainer.BeginWrite(ctx)
+	if err != nil {
 		return err
 	}
+	defer done()
 
-	return s.doTX(ctx, func(ctx context.Context, writer redis.Pipeliner) error {
-		return fn(&tx{writer: writer, reader: s.client, store: s, ctx: ctx})
+	return s.doTX(ctx, func(ctx context.Context, writer redis.Pipeliner, once writeOnceKeys) error {
+		return fn(&tx{writer: writer, reader: s.client, store: s, ctx: ctx, once: once})
 	}, opts)
 }
 
 func (s *store) Read(ctx context.Context, fn func(stoabs.ReadTx) error) error {
-	if err := s.checkOpen(); err != nil {
+	ctx, done, err := s.drainer.Begin(ctx)
+	if err != nil {
 		return err
 	}
+	defer done()
 
 	return fn(&tx{reader: s.client, store: s, ctx: ctx})
 }
 
 func (s *store) WriteShelf(ctx context.Context, shelfName string, fn func(stoabs.Writer) error) error {
-	if err := s.checkOpen(); err != nil {
+	ctx, done, err := s.drainer.BeginWrite(ctx)
+	if err != nil {
 		return err
 	}
-	return s.doTX(ctx, func(ctx context.Context, tx redis.Pipeliner) error {
-		return fn(s.getShelf(ctx, shelfName, tx, s.client))
+	defer done()
+	return s.doTX(ctx, func(ctx context.Context, writer redis.Pipeliner, once writeOnceKeys) error {
+		return fn(tx{writer: writer, reader: s.client, store: s, ctx: ctx, once: once}.GetShelfWriter(shelfName))
 	}, nil)
 }
 
 func (s *store) ReadShelf(ctx context.Context, shelfName string, fn func(stoabs.Reader) error) error {
-	if err := s.checkOpen(); err != nil {
+	ctx, done, err := s.drainer.Begin(ctx)
+	if err != nil {
 		return err
 	}
-	return fn(s.getShelf(ctx, shelfName, nil, s.client))
+	defer done()
+	return fn(tx{reader: s.client, store: s, ctx: ctx}.GetShelfReader(shelfName))
+}
+
+func (s *store) ShelfNames(ctx context.Context) ([]string, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	pattern := "*"
+	if len(s.prefix) > 0 {
+		pattern = escapeGlob(s.prefix) + ":*"
+	}
+	names := map[string]struct{}{}
+	var cursor uint64
+	for {
+		keys, nextCursor, err := s.client.Scan(ctx, cursor, pattern, int64(resultCount)).Result()
+		if err != nil {
+			return nil, stoabs.DatabaseError(err)
+		}
+		for _, key := range keys {
+			if name, ok := s.shelfNameFromRedisKey(key); ok {
+				if name, ok = s.cfg.TrimShelfName(name); ok {
+					names[name] = struct{}{}
+				}
+			}
+		}
+		if nextCursor == 0 {
+			break
+		}
+		cursor = nextCursor
+	}
+	result := make([]string, 0, len(names))
+	for name := range names {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return stoabs.FilterReservedShelves(ctx, result), nil
+}
+
+// SnapshotStore copies all shelves into memory (see stoabs.CopySnapshot), since Redis doesn't support long-lived snapshots.
+// Keys are copied as the strings they're stored as (see stoabs.WithStringKeys), so they can be read with any key type.
+// Redis transactions aren't isolated from concurrent writes, so the copy may be inconsistent when the store is written to concurrently.
+func (s *store) SnapshotStore(ctx context.Context) (stoabs.KVStore, error) {
+	return stoabs.CopySnapshot(ctx, s, stoabs.WithStringKeys())
 }
 
-func (s *store) getShelf(ctx context.Context, shelfName string, writer redis.Cmdable, reader redis.Cmdable) *shelf {
+// shelfNameFromRedisKey extracts the shelf name from a Redis key created by shelf.toRedisKey.
+// Since key strings never contain a dot, the shelf name is everything before the last dot.
+// It returns false if the key wasn't created by this store (e.g. the transaction lock key) or is a key lock (see shelf.toLockKey).
+func (s *store) shelfNameFromRedisKey(key string) (string, bool) {
+	if len(s.prefix) > 0 {
+		dbPrefix := s.prefix + ":"
+		if !strings.HasPrefix(key, dbPrefix) {
+			return "", false
+		}
+		key = strings.TrimPrefix(key, dbPrefix)
+	}
+	if strings.HasPrefix(key, keyLockSpace) {
+		return "", false
+	}
+	idx := strings.LastIndex(key, ".")
+	if idx <= 0 {
+		return "", false
+	}
+	return key[:idx], true
+}
+
+func (s *store) getShelf(ctx context.Context, shelfName string, writer redis.Cmdable, reader redis.Cmdable, once writeOnceKeys) *shelf {
 	return &shelf{
-		name:   shelfName,
-		prefix: s.prefix,
-		writer: writer,
-		reader: reader,
-		store:  s,
-		ctx:    ctx,
+		name:      s.cfg.ShelfName(shelfName),
+		prefix:    s.prefix,
+		writer:    writer,
+		reader:    reader,
+		store:     s,
+		ctx:       ctx,
+		validate:  s.cfg.Validator(shelfName),
+		immutable: s.cfg.Immutable(shelfName),
+		once:      once,
 	}
 }
 
-func (s *store) doTX(ctx context.Context, fn func(ctx context.Context, tx redis.Pipeliner) error, opts []stoabs.TxOption) error {
+// writeOnceKeys holds the Redis keys written to write-once shelves in a transaction,
+// mapped to the error to return when the key turns out to exist on commit.
+type writeOnceKeys map[string]error
+
+// keys returns the written keys, sorted.
+func (w writeOnceKeys) keys() []string {
+	keys := make([]string, 0, len(w))
+	for key := range w {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// check returns the error of the first key that exists, if any.
+func (w writeOnceKeys) check(ctx context.Context, reader redis.Cmdable) error {
+	for _, key := range w.keys() {
+		exists, err := reader.Exists(ctx, key).Result()
+		if err != nil {
+			return stoabs.DatabaseError(err)
+		}
+		if exists > 0 {
+			return w[key]
+		}
+	}
+	return nil
+}
+
+func (s *store) doTX(ctx context.Context, fn func(ctx context.Context, tx redis.Pipeliner, once writeOnceKeys) error, opts []stoabs.TxOption) error {
 	if err := s.checkOpen(); err != nil {
 		return err
 	}
@@ -185,52 +348,52 @@ func (s *store) doTX(ctx context.Context, fn func(ctx context.Context, tx redis.
 		defer cancel()
 	}
 
-	unlock := func() {}
-
-	// Obtain transaction-level write lock, if requested
-	var txMutex *redsync.Mutex
+	// Obtain transaction-level write lock(s), if requested
+	// include the key prefix, so applications sharing the database don't block each other
+	lockName := "lock_" + s.prefix + s.cfg.KeyPrefix
+	var lockNames []string
 	if (stoabs.WriteLockOption{}).Enabled(opts) {
-		lockName := "lock_" + s.prefix
-		s.log.Tracef("Acquiring Redis distributed lock (name=%s)", lockName)
-		// Lock expires 5 seconds after transaction context expires
-		txDeadline, _ := ctx.Deadline()
-		lockExpiry := time.Until(txDeadline.Add(lockExpiryOffset))
-		// Sub-context for lock acquisition
-		lockCtx, lockCtxCancel := context.WithTimeout(ctx, s.cfg.LockAcquireTimeout)
-		defer lockCtxCancel()
-		// Acquire lock
-		txMutex = s.rs.NewMutex(lockName, redsync.WithExpiry(lockExpiry))
-		err := txMutex.LockContext(lockCtx)
-		if err != nil {
-			return fmt.Errorf("unable to obtain Redis transaction-level write lock: %w", stoabs.DatabaseError(err))
+		lockNames = []string{lockName}
+	} else {
+		// Shelf names are sorted, so concurrent transactions acquire overlapping locks in the same order
+		for _, shelfName := range (stoabs.ShelfLockOption{}).Shelves(opts) {
+			lockNames = append(lockNames, lockName+"/"+shelfName)
 		}
-		unlock = func() {
-			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
-				s.log.Warnf("Not releasing Redis distributed lock, because the transaction context has expired and the server may still writing the data. Lock will expire automatically (name=%s,expiresIn=%s)", lockName, time.Until(txMutex.Until()))
-				return
-			}
-			s.log.Tracef("Releasing Redis distributed lock (name=%s)", lockName)
-			releaseLockCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
-			defer cancel()
-			_, err := txMutex.UnlockContext(releaseLockCtx)
-			if err != nil {
-				s.log.Errorf("Unable to release Redis transaction-level write lock: %s", err)
+	}
+	locks, err := s.lockAll(ctx, lockNames, true, (stoabs.LabelOption{}).Label(opts))
+	if err != nil {
+		return err
+	}
+	// Watch the fence keys, so the transaction isn't committed if another holder acquired one of the locks.
+	// The transaction always runs on a dedicated connection, so keys written to write-once shelves can be watched on commit as well.
+	err = s.client.Watch(ctx, func(conn *redis.Tx) error {
+		var check func() error
+		if len(locks) > 0 {
+			check = func() error {
+				return checkFences(ctx, conn, locks)
 			}
 		}
+		return s.execTX(ctx, conn, fn, check, opts)
+	}, fenceKeys(locks)...)
+	if len(locks) > 0 {
+		s.releaseAll(ctx, locks)
 	}
+	return err
+}
 
-	// Start transaction, retrieve/create shelf to operate on
-	s.log.Tracef("Starting Redis transaction (TxPipeline)")
-	pl := s.client.TxPipeline()
-
+// execTX performs the given TX actions on a pipeline of the given connection, and commits the pipeline if they succeed and check (if set) passes.
+// Keys written to write-once shelves are watched and checked not to exist before committing, so the check and the write are atomic.
+func (s *store) execTX(ctx context.Context, conn *redis.Tx, fn func(ctx context.Context, tx redis.Pipeliner, once writeOnceKeys) error, check func() error, opts []stoabs.TxOption) error {
 	// Perform TX action(s)
-	appError := fn(ctx, pl)
+	s.log.Tracef("Starting Redis transaction (TxPipeline)")
+	pl := conn.TxPipeline()
+	once := writeOnceKeys{}
+	appError := fn(ctx, pl, once)
 
 	// Observe result, if application returned an error rollback TX
 	if appError != nil {
 		s.log.WithError(appError).Warn("Rolling back transaction due to application error")
 		pl.Discard()
-		unlock()
 		stoabs.OnRollbackOption{}.Invoke(opts)
 		return appError
 	}
@@ -242,29 +405,87 @@ func (s *store) doTX(ctx context.Context, fn func(ctx context.Context, tx redis.
 		// TX lock expired
 		pl.Discard()
 		s.log.Error("Unable to commit Redis transaction, transaction timed out.")
-		unlock()
 		stoabs.OnRollbackOption{}.Invoke(opts)
-		return stoabs.DatabaseError(ctx.Err())
+		return util.WrapError(stoabs.ErrCommitFailed, ctx.Err())
+	}
+
+	// Make sure the TX still holds its locks
+	if check != nil {
+		if err := check(); err != nil {
+			pl.Discard()
+			s.log.WithError(err).Error("Unable to commit Redis transaction")
+			stoabs.OnRollbackOption{}.Invoke(opts)
+			return util.WrapError(stoabs.ErrCommitFailed, err)
+		}
+	}
+
+	// Make sure keys written to write-once shelves don't exist, and aren't written concurrently until committing
+	if len(once) > 0 {
+		err := conn.Watch(ctx, once.keys()...).Err()
+		if err != nil {
+			err = stoabs.DatabaseError(err)
+		} else {
+			err = once.check(ctx, conn)
+		}
+		if err != nil {
+			pl.Discard()
+			s.log.WithError(err).Warn("Rolling back transaction due to write-once shelf")
+			stoabs.OnRollbackOption{}.Invoke(opts)
+			return err
+		}
 	}
 
 	// Everything looks OK, commit
 	cmdErrs, err := pl.Exec(ctx)
+	if errors.Is(err, redis.TxFailedErr) && len(once) > 0 {
+		// A watched key changed, which might be a key written to a write-once shelf by a concurrent transaction
+		if onceErr := once.check(ctx, s.client); onceErr != nil {
+			err = onceErr
+		}
+	}
+	if errors.Is(err, stoabs.ErrImmutable{}) {
+		s.log.WithError(err).Warn("Unable to commit Redis transaction, key of write-once shelf was written concurrently")
+		stoabs.OnRollbackOption{}.Invoke(opts)
+		return err
+	}
 	if err != nil {
 		// Commit failed
 		for _, cmdErr := range cmdErrs {
 			s.log.WithError(cmdErr.Err()).Errorf("Redis pipeline command failed: %s", cmdErr.String())
 		}
-		unlock()
 		stoabs.OnRollbackOption{}.Invoke(opts)
 		return util.WrapError(stoabs.ErrCommitFailed, err)
 	}
 
 	// Success
-	unlock()
 	stoabs.AfterCommitOption{}.Invoke(opts)
 	return nil
 }
 
+// LockKeys locks the given keys using Redis distributed locks, so it excludes callers in other processes as well.
+// The leases of the locks are renewed until they're released, see stoabs.WithLockLease.
+func (s *store) LockKeys(ctx context.Context, shelfName string, keys ...stoabs.Key) (func(), error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	// Lock names are sorted, so callers locking overlapping keys acquire them in the same order
+	var lockNames []string
+	shelf := s.getShelf(ctx, shelfName, nil, nil, nil)
+	for _, key := range keys {
+		lockNames = append(lockNames, shelf.toLockKey(key))
+	}
+	sort.Strings(lockNames)
+	lockNames = slices.Compact(lockNames)
+	locks, err := s.lockAll(ctx, lockNames, false, "")
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		// the acquisition context may have expired in the meantime, which must not prevent releasing the locks
+		s.releaseAll(context.WithoutCancel(ctx), locks)
+	}, nil
+}
+
 func (s *store) checkOpen() error {
 	s.mux.RLock()
 	defer s.mux.RUnlock()
@@ -279,14 +500,22 @@ type tx struct {
 	writer redis.Cmdable
 	store  *store
 	ctx    context.Context
+	// once holds the keys written to write-once shelves in the transaction.
+	once writeOnceKeys
 }
 
 func (t tx) GetShelfWriter(shelfName string) stoabs.Writer {
-	return t.store.getShelf(t.ctx, shelfName, t.writer, t.reader)
+	if invalid := stoabs.InvalidShelf(shelfName); invalid != nil {
+		return invalid
+	}
+	return t.store.getShelf(t.ctx, shelfName, t.writer, t.reader, t.once)
 }
 
 func (t tx) GetShelfReader(shelfName string) stoabs.Reader {
-	return t.store.getShelf(t.ctx, shelfName, nil, t.reader)
+	if invalid := stoabs.InvalidShelf(shelfName); invalid != nil {
+		return invalid
+	}
+	return t.store.getShelf(t.ctx, shelfName, nil, t.reader, nil)
 }
 
 func (t tx) Store() stoabs.KVStore {
@@ -307,19 +536,58 @@ type shelf struct {
 	writer redis.Cmdable
 	store  *store
 	ctx    context.Context
+	// validate runs the validators of the shelf (see stoabs.WithValidator), nil if it has none.
+	validate func(key stoabs.Key, value []byte) error
+	// immutable returns stoabs.ErrImmutable for a key if the shelf is write-once (see stoabs.WithWriteOnce), nil otherwise.
+	immutable func(key stoabs.Key) error
+	// once holds the keys written to write-once shelves in the transaction, nil if the shelf isn't written to.
+	once writeOnceKeys
 }
 
 func (s shelf) Put(key stoabs.Key, value []byte) error {
 	if err := s.store.checkOpen(); err != nil {
 		return err
 	}
+	if s.validate != nil {
+		if err := s.validate(key, value); err != nil {
+			return err
+		}
+	}
+	if s.immutable != nil {
+		return s.putOnce(key, value)
+	}
 	if err := s.writer.Set(s.ctx, s.toRedisKey(key), value, 0).Err(); err != nil {
 		return stoabs.DatabaseError(err)
 	}
 	return nil
 }
 
+// putOnce writes the entry of a write-once shelf, failing if the key already exists or was written earlier in the transaction.
+// Writes are only executed on commit, so the key may still be written concurrently after checking it:
+// the transaction checks its write-once keys again on commit (see store.execTX), failing with stoabs.ErrImmutable if another transaction wrote them first.
+func (s shelf) putOnce(key stoabs.Key, value []byte) error {
+	redisKey := s.toRedisKey(key)
+	if _, written := s.once[redisKey]; written {
+		return s.immutable(key)
+	}
+	exists, err := s.reader.Exists(s.ctx, redisKey).Result()
+	if err != nil {
+		return stoabs.DatabaseError(err)
+	}
+	if exists > 0 {
+		return s.immutable(key)
+	}
+	s.once[redisKey] = s.immutable(key)
+	if err := s.writer.Set(s.ctx, redisKey, value, 0).Err(); err != nil {
+		return stoabs.DatabaseError(err)
+	}
+	return nil
+}
+
 func (s shelf) Delete(key stoabs.Key) error {
+	if s.immutable != nil {
+		return s.immutable(key)
+	}
 	if err := s.writer.Del(s.ctx, s.toRedisKey(key)).Err(); err != nil {
 		return stoabs.DatabaseError(err)
 	}
@@ -329,19 +597,19 @@ func (s shelf) Delete(key stoabs.Key) error {
 func (s shelf) Empty() (bool, error) {
 	// Redis has no stats, so we start an iterator and stop after n == 1
 	var cursor uint64
-	var err error
-	var keys []string
-
-	scanCmd := s.reader.Scan(s.ctx, cursor, s.toRedisKey(stoabs.BytesKey(""))+"*", int64(resultCount))
-	keys, cursor, err = scanCmd.Result()
-	if err != nil {
-		return false, err
-	}
-	if len(keys) == 0 {
-		return true, nil
+	for {
+		keys, next, err := s.scan(cursor)
+		if err != nil {
+			return false, err
+		}
+		if len(keys) > 0 {
+			return false, nil
+		}
+		if next == 0 {
+			return true, nil
+		}
+		cursor = next
 	}
-
-	return false, nil
 }
 
 func (s shelf) Get(key stoabs.Key) ([]byte, error) {
@@ -355,12 +623,14 @@ func (s shelf) Get(key stoabs.Key) ([]byte, error) {
 }
 
 func (s shelf) Iterate(callback stoabs.CallerFn, keyType stoabs.Key) error {
+	if s.store.cfg.OrderedIteration {
+		return s.iterateOrdered(callback, keyType)
+	}
 	var cursor uint64
 	var err error
 	var keys []string
 	for {
-		scanCmd := s.reader.Scan(s.ctx, cursor, s.toRedisKey(stoabs.BytesKey(""))+"*", int64(resultCount))
-		keys, cursor, err = scanCmd.Result()
+		keys, cursor, err = s.scan(cursor)
 		if err != nil {
 			return stoabs.DatabaseError(err)
 		}
@@ -378,11 +648,68 @@ func (s shelf) Iterate(callback stoabs.CallerFn, keyType stoabs.Key) error {
 	return nil
 }
 
+// iterateOrdered visits the keys of the shelf in their byte order (see stoabs.WithOrderedIteration).
+// Since SCAN returns keys in an unspecified order, all keys are scanned and sorted before visiting them.
+func (s shelf) iterateOrdered(callback stoabs.CallerFn, keyType stoabs.Key) error {
+	type entry struct {
+		redisKey string
+		key      []byte
+	}
+	var entries []entry
+	var cursor uint64
+	for {
+		keys, next, err := s.scan(cursor)
+		if err != nil {
+			return stoabs.DatabaseError(err)
+		}
+		for _, redisKey := range keys {
+			key, err := s.fromRedisKey(redisKey, keyType)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry{redisKey: redisKey, key: key.Bytes()})
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].key, entries[j].key) < 0
+	})
+	keys := make([]string, 0, resultCount)
+	for i, e := range entries {
+		// SCAN can return a key more than once
+		if i > 0 && entries[i-1].redisKey == e.redisKey {
+			continue
+		}
+		keys = append(keys, e.redisKey)
+		if len(keys) == resultCount {
+			if _, err := s.visitKeys(keys, callback, keyType, false); err != nil {
+				return err
+			}
+			keys = keys[:0]
+		}
+	}
+	if len(keys) > 0 {
+		_, err := s.visitKeys(keys, callback, keyType, false)
+		return err
+	}
+	return nil
+}
+
 func (s shelf) Range(from stoabs.Key, to stoabs.Key, callback stoabs.CallerFn, stopAtNil bool) error {
+	return s.rangeKeys(from, to, func(keys []string) (bool, error) {
+		return s.visitKeys(keys, callback, from, stopAtNil)
+	})
+}
+
+// rangeKeys calls fn with pages of the Redis keys from..to (start inclusive, end exclusive), until it returns false or an error.
+func (s shelf) rangeKeys(from stoabs.Key, to stoabs.Key, fn func(keys []string) (bool, error)) error {
 	keys := make([]string, 0, resultCount)
-	// Iterate from..to (start inclusive, end exclusive)
 	var numKeys = 0
-	for curr := from; !curr.Equals(to); curr = curr.Next() {
+	// Stop when reaching or passing the end, since with keys of different lengths (e.g. BytesKey) the end isn't always reached exactly
+	for curr := from; bytes.Compare(curr.Bytes(), to.Bytes()) < 0; curr = curr.Next() {
 		// Potentially long-running operation, check context for cancellation
 		if s.ctx.Err() != nil {
 			return stoabs.DatabaseError(s.ctx.Err())
@@ -394,7 +721,7 @@ func (s shelf) Range(from stoabs.Key, to stoabs.Key, callback stoabs.CallerFn, s
 		keys = append(keys, s.toRedisKey(curr))
 		// We don't want to perform requests that are really large, so we limit it at page size
 		if numKeys >= resultCount {
-			proceed, err := s.visitKeys(keys, callback, from, stopAtNil)
+			proceed, err := fn(keys)
 			if err != nil || !proceed {
 				return err
 			}
@@ -404,7 +731,10 @@ func (s shelf) Range(from stoabs.Key, to stoabs.Key, callback stoabs.CallerFn, s
 			numKeys++
 		}
 	}
-	_, err := s.visitKeys(keys, callback, from, stopAtNil)
+	if len(keys) == 0 {
+		return nil
+	}
+	_, err := fn(keys)
 	return err
 }
 
@@ -420,6 +750,10 @@ func (s shelf) visitKeys(keys []string, callback stoabs.CallerFn, keyType stoabs
 		return false, stoabs.DatabaseError(err)
 	}
 	for i, value := range values {
+		// Callbacks may take a while for large pages, check context for cancellation
+		if s.ctx.Err() != nil {
+			return false, stoabs.DatabaseError(s.ctx.Err())
+		}
 		if values[i] == nil {
 			// Value does not exist (anymore), or not a string
 			if stopAtNil {
@@ -447,6 +781,37 @@ func (s shelf) Stats() stoabs.ShelfStats {
 	}
 }
 
+// scan returns a page of the Redis keys of the shelf starting at the given cursor, and the cursor of the next page (see SCAN).
+// Glob characters in the shelf name are escaped in the pattern. Keys of shelves of which the name consists of the name
+// of this shelf, a dot and more (e.g. shelf "a.b" when scanning shelf "a") also match the pattern, they're skipped since
+// key strings never contain a dot.
+func (s shelf) scan(cursor uint64) ([]string, uint64, error) {
+	keyPrefix := s.toRedisKey(stoabs.BytesKey(""))
+	keys, next, err := s.reader.Scan(s.ctx, cursor, escapeGlob(keyPrefix)+"*", int64(resultCount)).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	result := keys[:0]
+	for _, key := range keys {
+		if !strings.Contains(key[len(keyPrefix):], ".") {
+			result = append(result, key)
+		}
+	}
+	return result, next, nil
+}
+
+// escapeGlob escapes the characters that have a special meaning in Redis glob-style patterns (e.g. of SCAN).
+func escapeGlob(s string) string {
+	var result strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(`*?[]\`, c) {
+			result.WriteByte('\\')
+		}
+		result.WriteRune(c)
+	}
+	return result.String()
+}
+
 func (s shelf) toRedisKey(key stoabs.Key) string {
 	result := s.name + "." + key.String()
 	if len(s.prefix) > 0 {
@@ -455,6 +820,15 @@ func (s shelf) toRedisKey(key stoabs.Key) string {
 	return result
 }
 
+// toLockKey returns the Redis key of the lock of the given key (see store.LockKeys), which is in the keyLockSpace under the prefix of the store.
+func (s shelf) toLockKey(key stoabs.Key) string {
+	result := keyLockSpace + s.name + "." + key.String()
+	if len(s.prefix) > 0 {
+		result = s.prefix + ":" + result
+	}
+	return result
+}
+
 func (s shelf) fromRedisKey(key string, keyType stoabs.Key) (stoabs.Key, error) {
 	// returned errors are the result of invalid input, hence not wrapped in ErrDatabase
 	if len(s.prefix) > 0 {
@@ -26,6 +26,7 @@ import (
 	"github.com/nuts-foundation/go-stoabs/kvtests"
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"testing"
 	"time"
 )
@@ -34,6 +35,7 @@ func TestRedis(t *testing.T) {
 	runTests := func(t *testing.T, provider kvtests.StoreProvider) {
 		kvtests.TestReadingAndWriting(t, provider)
 		kvtests.TestRange(t, provider)
+		kvtests.TestAggregate(t, provider)
 		kvtests.TestIterate(t, provider)
 		kvtests.TestEmpty(t, provider)
 		kvtests.TestClose(t, provider)
@@ -42,6 +44,10 @@ func TestRedis(t *testing.T) {
 		// kvtests.TestStats(t, provider)
 		kvtests.TestWriteTransactions(t, provider)
 		kvtests.TestTransactionWriteLock(t, provider)
+		kvtests.TestLinearizability(t, provider)
+		kvtests.TestErrors(t, provider)
+		kvtests.TestCapabilities(t, provider, kvtests.CapabilityShelfLister, kvtests.CapabilityLocker, kvtests.CapabilityLeaser, kvtests.CapabilityReadOptions, kvtests.CapabilityLazyRange, kvtests.CapabilityState, kvtests.CapabilityStats, kvtests.CapabilityEntries, kvtests.CapabilityFreeze, kvtests.CapabilitySample, kvtests.CapabilitySnapshot)
+		kvtests.TestByteTransparency(t, provider)
 	}
 
 	t.Run("with database prefix", func(t *testing.T) {
@@ -67,6 +73,45 @@ func TestRedis(t *testing.T) {
 		})
 	})
 
+	t.Run("with validator", func(t *testing.T) {
+		kvtests.TestValidator(t, func(t *testing.T, opts ...stoabs.Option) (stoabs.KVStore, error) {
+			s := miniredis.RunT(t)
+			return CreateRedisStore("db", &redis.Options{Addr: s.Addr()}, opts...)
+		})
+	})
+
+	t.Run("with write-once shelf", func(t *testing.T) {
+		kvtests.TestWriteOnce(t, func(t *testing.T, opts ...stoabs.Option) (stoabs.KVStore, error) {
+			s := miniredis.RunT(t)
+			return CreateRedisStore("db", &redis.Options{Addr: s.Addr()}, opts...)
+		})
+	})
+
+	t.Run("with ordered iteration", func(t *testing.T) {
+		kvtests.TestOrderedIteration(t, func(t *testing.T) (stoabs.KVStore, error) {
+			s := miniredis.RunT(t)
+			return CreateRedisStore("db", &redis.Options{Addr: s.Addr()}, stoabs.WithOrderedIteration())
+		})
+	})
+
+	t.Run("with key prefix", func(t *testing.T) {
+		kvtests.TestKeyPrefix(t, func(t *testing.T, prefixes ...string) ([]stoabs.KVStore, error) {
+			s := miniredis.RunT(t)
+			var stores []stoabs.KVStore
+			for _, prefix := range prefixes {
+				store, err := CreateRedisStore("db", &redis.Options{Addr: s.Addr()}, stoabs.WithKeyPrefix(prefix))
+				if err != nil {
+					return nil, err
+				}
+				t.Cleanup(func() {
+					_ = store.Close(context.Background())
+				})
+				stores = append(stores, store)
+			}
+			return stores, nil
+		}, true)
+	})
+
 	t.Run("context deadline is set, if not provided", func(t *testing.T) {
 		s := miniredis.RunT(t)
 		t.Cleanup(func() {
@@ -90,6 +135,96 @@ func TestRedis(t *testing.T) {
 	})
 }
 
+func FuzzRedis_Range(f *testing.F) {
+	kvtests.FuzzRange(f, func(t *testing.T) (stoabs.KVStore, error) {
+		s := miniredis.RunT(t)
+		return CreateRedisStore("db", &redis.Options{Addr: s.Addr()})
+	})
+}
+
+func TestStore_WithShelfLock(t *testing.T) {
+	mr := miniredis.RunT(t)
+	store, err := CreateRedisStore("db", &redis.Options{Addr: mr.Addr()}, stoabs.WithLockAcquireTimeout(100*time.Millisecond))
+	require.NoError(t, err)
+	defer store.Close(context.Background())
+	ctx := context.Background()
+	noop := func(tx stoabs.WriteTx) error { return nil }
+
+	t.Run("disjoint shelves don't block each other", func(t *testing.T) {
+		err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+			return store.Write(ctx, noop, stoabs.WithShelfLock("b"))
+		}, stoabs.WithShelfLock("a"))
+
+		assert.NoError(t, err)
+	})
+	t.Run("overlapping shelves block", func(t *testing.T) {
+		err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+			return store.Write(ctx, noop, stoabs.WithShelfLock("c", "b"))
+		}, stoabs.WithShelfLock("a", "b"))
+
+		assert.ErrorContains(t, err, "unable to obtain Redis transaction-level write lock")
+	})
+	t.Run("locks are released after commit", func(t *testing.T) {
+		err := store.Write(ctx, noop, stoabs.WithShelfLock("a", "b"))
+		require.NoError(t, err)
+
+		err = store.Write(ctx, noop, stoabs.WithShelfLock("b"))
+
+		assert.NoError(t, err)
+	})
+	t.Run("locks acquired before failure are released", func(t *testing.T) {
+		err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+			// locks "a", then fails on "b"
+			return store.Write(ctx, noop, stoabs.WithShelfLock("a", "b"))
+		}, stoabs.WithShelfLock("b"))
+		require.Error(t, err)
+
+		err = store.Write(ctx, noop, stoabs.WithShelfLock("a"))
+
+		assert.NoError(t, err)
+	})
+	t.Run("store-wide lock takes precedence", func(t *testing.T) {
+		err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+			return store.Write(ctx, noop, stoabs.WithShelfLock("a"))
+		}, stoabs.WithWriteLock(), stoabs.WithShelfLock("a"))
+
+		assert.NoError(t, err)
+	})
+}
+
+func TestStore_WriteOnce(t *testing.T) {
+	mr := miniredis.RunT(t)
+	store, err := CreateRedisStore("db", &redis.Options{Addr: mr.Addr()}, stoabs.WithWriteOnce("once"))
+	require.NoError(t, err)
+	defer store.Close(context.Background())
+	ctx := context.Background()
+
+	t.Run("key written by concurrent transaction before commit", func(t *testing.T) {
+		key := stoabs.BytesKey("key")
+		rolledBack := false
+		err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+			if err := tx.GetShelfWriter("once").Put(key, []byte("outer")); err != nil {
+				return err
+			}
+			// transactions without locks don't block each other
+			return store.WriteShelf(ctx, "once", func(writer stoabs.Writer) error {
+				return writer.Put(key, []byte("inner"))
+			})
+		}, stoabs.OnRollback(func() {
+			rolledBack = true
+		}))
+
+		assert.ErrorIs(t, err, stoabs.ErrImmutable{})
+		assert.True(t, rolledBack)
+		_ = store.ReadShelf(ctx, "once", func(reader stoabs.Reader) error {
+			value, err := reader.Get(key)
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("inner"), value)
+			return nil
+		})
+	})
+}
+
 func TestCreateRedisStore(t *testing.T) {
 	t.Run("unable to connect", func(t *testing.T) {
 		PingAttemptBackoff = 100 * time.Millisecond // speed up test
@@ -185,3 +320,52 @@ func TestStore_ErrDatabase(t *testing.T) {
 		assert.NotErrorIs(t, actual, stoabs.ErrDatabase{})
 	})
 }
+
+func TestRedis_ShelfNameCollisions(t *testing.T) {
+	ctx := context.Background()
+	shelves := []string{"a", "a.b", "a*", "a?", "a[b]"}
+	_, store := NewTestStore(t)
+	err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+		for _, shelfName := range shelves {
+			if err := tx.GetShelfWriter(shelfName).Put(stoabs.BytesKey(shelfName), []byte(shelfName)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	for _, shelfName := range shelves {
+		t.Run(shelfName, func(t *testing.T) {
+			_ = store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+				var keys []string
+				err := reader.Iterate(func(key stoabs.Key, _ []byte) error {
+					keys = append(keys, string(key.Bytes()))
+					return nil
+				}, stoabs.BytesKey{})
+				require.NoError(t, err)
+				assert.Equal(t, []string{shelfName}, keys)
+
+				keys = nil
+				for entry, err := range stoabs.Entries(reader, stoabs.BytesKey(""), stoabs.BytesKey("z")) {
+					require.NoError(t, err)
+					keys = append(keys, string(entry.Key.Bytes()))
+				}
+				assert.Equal(t, []string{shelfName}, keys)
+				return nil
+			})
+		})
+	}
+	t.Run("empty shelf with prefix of other shelves", func(t *testing.T) {
+		err := store.WriteShelf(ctx, "a", func(writer stoabs.Writer) error {
+			return writer.Delete(stoabs.BytesKey("a"))
+		})
+		require.NoError(t, err)
+		_ = store.ReadShelf(ctx, "a", func(reader stoabs.Reader) error {
+			empty, err := reader.Empty()
+			require.NoError(t, err)
+			assert.True(t, empty)
+			return nil
+		})
+	})
+}
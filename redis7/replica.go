@@ -0,0 +1,118 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package redis7
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var _ stoabs.OptionReader = (*store)(nil)
+
+// WrapReplicated is like Wrap, but additionally uses the given (read-only) replicas of the primary for read transactions
+// that allow stale reads (see stoabs.WithConsistency):
+//   - stoabs.ConsistencyStrong (default) reads from the primary.
+//   - stoabs.ConsistencyBoundedStaleness reads from a replica that is connected to the primary and has received data from
+//     it within the bound (according to INFO replication), or the primary if there's none.
+//   - stoabs.ConsistencyEventual reads from the replicas in turn.
+//
+// Write transactions always read from the primary. The replicas are closed when the store is closed.
+func WrapReplicated(prefix string, primary *redis.Client, replicas []*redis.Client, opts ...stoabs.Option) (stoabs.KVStore, error) {
+	result, err := Wrap(prefix, primary, opts...)
+	if err != nil {
+		return nil, err
+	}
+	result.(*store).replicas = replicas
+	return result, nil
+}
+
+// ReadWithOptions starts a read transaction that reads from a replica, if the specified consistency allows it.
+func (s *store) ReadWithOptions(ctx context.Context, fn func(stoabs.ReadTx) error, opts ...stoabs.TxOption) error {
+	ctx, done, err := s.drainer.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	return fn(&tx{reader: s.readerFor(ctx, stoabs.ConsistencyOption{}.Consistency(opts)), store: s, ctx: ctx})
+}
+
+// readerFor returns the client to read from with the given consistency.
+func (s *store) readerFor(ctx context.Context, consistency stoabs.Consistency) redis.Cmdable {
+	if consistency.IsStrong() || len(s.replicas) == 0 {
+		return s.client
+	}
+	start := int(atomic.AddUint32(&s.nextReplica, 1))
+	for i := range s.replicas {
+		replica := s.replicas[(start+i)%len(s.replicas)]
+		if consistency.IsEventual() {
+			return replica
+		}
+		lag, err := replicationLag(ctx, replica)
+		if err != nil {
+			s.log.WithError(err).Debug("Unable to determine Redis replication lag")
+			continue
+		}
+		if lag <= consistency.MaxStaleness() {
+			return replica
+		}
+	}
+	return s.client
+}
+
+// replicationLag returns the time since the replica last received data from the primary (master_last_io_seconds_ago),
+// or an error if it's not connected to the primary.
+func replicationLag(ctx context.Context, replica *redis.Client) (time.Duration, error) {
+	info, err := replica.Info(ctx, "replication").Result()
+	if err != nil {
+		return 0, stoabs.DatabaseError(err)
+	}
+	return parseReplicationLag(info)
+}
+
+func parseReplicationLag(info string) (time.Duration, error) {
+	fields := parseInfo(info)
+	if fields["role"] != "slave" || fields["master_link_status"] != "up" {
+		return 0, errors.New("replica is not connected to the primary")
+	}
+	seconds, err := strconv.Atoi(fields["master_last_io_seconds_ago"])
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// parseInfo parses the fields (name:value lines) of the output of the INFO command.
+func parseInfo(info string) map[string]string {
+	fields := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(info))
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if ok {
+			fields[name] = value
+		}
+	}
+	return fields
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package redis7
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestWrapReplicated(t *testing.T) {
+	ctx := context.Background()
+	primary := miniredis.RunT(t)
+	// the replicas aren't actually replicating, so they can hold different data
+	replicas := []*miniredis.Miniredis{miniredis.RunT(t), miniredis.RunT(t)}
+	for i, mr := range append([]*miniredis.Miniredis{primary}, replicas...) {
+		require.NoError(t, mr.Set("db:test.6b6579", []string{"primary", "replica1", "replica2"}[i]))
+	}
+	var replicaClients []*redis.Client
+	for _, replica := range replicas {
+		replicaClients = append(replicaClients, redis.NewClient(&redis.Options{Addr: replica.Addr()}))
+	}
+	store, err := WrapReplicated("db", redis.NewClient(&redis.Options{Addr: primary.Addr()}), replicaClients)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = store.Close(ctx)
+	})
+	read := func(t *testing.T, opts ...stoabs.TxOption) string {
+		var result []byte
+		require.NoError(t, stoabs.ReadWithOptions(ctx, store, func(tx stoabs.ReadTx) error {
+			var err error
+			result, err = tx.GetShelfReader("test").Get(stoabs.BytesKey("key"))
+			return err
+		}, opts...))
+		return string(result)
+	}
+
+	t.Run("strong consistency reads from primary", func(t *testing.T) {
+		assert.Equal(t, "primary", read(t))
+		assert.Equal(t, "primary", read(t, stoabs.WithConsistency(stoabs.ConsistencyStrong)))
+	})
+	t.Run("eventual consistency reads from the replicas in turn", func(t *testing.T) {
+		first := read(t, stoabs.WithConsistency(stoabs.ConsistencyEventual))
+		second := read(t, stoabs.WithConsistency(stoabs.ConsistencyEventual))
+
+		assert.ElementsMatch(t, []string{"replica1", "replica2"}, []string{first, second})
+	})
+	t.Run("bounded staleness reads from primary if replication lag is unknown", func(t *testing.T) {
+		assert.Equal(t, "primary", read(t, stoabs.WithConsistency(stoabs.ConsistencyBoundedStaleness(time.Minute))))
+	})
+	t.Run("write transactions read from primary", func(t *testing.T) {
+		require.NoError(t, store.Write(ctx, func(tx stoabs.WriteTx) error {
+			value, err := tx.GetShelfReader("test").Get(stoabs.BytesKey("key"))
+			assert.Equal(t, "primary", string(value))
+			return err
+		}, stoabs.WithConsistency(stoabs.ConsistencyEventual)))
+	})
+	t.Run("close closes the replicas", func(t *testing.T) {
+		require.NoError(t, store.Close(ctx))
+
+		assert.ErrorIs(t, replicaClients[0].Ping(ctx).Err(), redis.ErrClosed)
+	})
+}
+
+func TestReplicationLag(t *testing.T) {
+	t.Run("not a replica", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		defer client.Close()
+
+		_, err := replicationLag(context.Background(), client)
+
+		assert.Error(t, err)
+	})
+	t.Run("connected replica", func(t *testing.T) {
+		lag, err := parseReplicationLag("# Replication\r\nrole:slave\r\nmaster_host:primary\r\nmaster_link_status:up\r\nmaster_last_io_seconds_ago:3\r\n")
+
+		require.NoError(t, err)
+		assert.Equal(t, 3*time.Second, lag)
+	})
+	t.Run("disconnected replica", func(t *testing.T) {
+		_, err := parseReplicationLag("role:slave\r\nmaster_link_status:down\r\nmaster_last_io_seconds_ago:-1\r\n")
+
+		assert.EqualError(t, err, "replica is not connected to the primary")
+	})
+}
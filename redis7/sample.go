@@ -0,0 +1,75 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package redis7
+
+import (
+	"errors"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/redis/go-redis/v9"
+	"strings"
+)
+
+var _ stoabs.Sampler = shelf{}
+
+// sampleRounds is the number of rounds of RANDOMKEY commands Sample sends before it falls back to reservoir sampling.
+const sampleRounds = 5
+
+// Sample picks random keys of the shelf using RANDOMKEY, which avoids scanning the shelf.
+// Since RANDOMKEY picks from all keys of the database, the keys of other shelves are discarded. If that doesn't yield
+// n keys within a few rounds (the shelf is small compared to the database, or contains fewer than n entries),
+// it falls back to reservoir sampling while scanning the shelf (see stoabs.SampleIterate).
+func (s shelf) Sample(n int, keyType stoabs.Key) ([]stoabs.KeyValue, error) {
+	prefix := s.toRedisKey(stoabs.BytesKey(""))
+	selected := map[string]bool{}
+	var redisKeys []string
+	for round := 0; round < sampleRounds && len(redisKeys) < n; round++ {
+		missing := n - len(redisKeys)
+		cmds, err := s.reader.Pipelined(s.ctx, func(pipe redis.Pipeliner) error {
+			for i := 0; i < missing; i++ {
+				pipe.RandomKey(s.ctx)
+			}
+			return nil
+		})
+		// RANDOMKEY returns nil if the database is empty
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return nil, stoabs.DatabaseError(err)
+		}
+		for _, cmd := range cmds {
+			redisKey, err := cmd.(*redis.StringCmd).Result()
+			if err != nil || !strings.HasPrefix(redisKey, prefix) || selected[redisKey] {
+				continue
+			}
+			selected[redisKey] = true
+			redisKeys = append(redisKeys, redisKey)
+		}
+	}
+	if len(redisKeys) < n {
+		return stoabs.SampleIterate(s, n, keyType)
+	}
+	result := make([]stoabs.KeyValue, 0, len(redisKeys))
+	for start := 0; start < len(redisKeys); start += resultCount {
+		if _, err := s.visitKeys(redisKeys[start:min(start+resultCount, len(redisKeys))], func(key stoabs.Key, value []byte) error {
+			result = append(result, stoabs.KeyValue{Key: key, Value: value})
+			return nil
+		}, keyType, false); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package redis7
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/scored"
+	"github.com/redis/go-redis/v9"
+)
+
+var _ scored.Provider = (*store)(nil)
+
+// ScoredShelf returns a scored shelf backed by a Redis sorted set.
+// The sorted set is stored outside the key space of the database's shelves, so it isn't returned by ShelfNames.
+func (s *store) ScoredShelf(name string) scored.Shelf {
+	return &scoredShelf{store: s, key: "zset_" + s.prefix + ":" + s.cfg.ShelfName(name)}
+}
+
+type scoredShelf struct {
+	store *store
+	key   string
+}
+
+func (z scoredShelf) Add(ctx context.Context, member string, score float64) error {
+	if math.IsNaN(score) {
+		return errors.New("score is NaN")
+	}
+	if err := z.store.checkOpen(); err != nil {
+		return err
+	}
+	if err := z.store.client.ZAdd(ctx, z.key, redis.Z{Score: score, Member: member}).Err(); err != nil {
+		return stoabs.DatabaseError(err)
+	}
+	return nil
+}
+
+func (z scoredShelf) Remove(ctx context.Context, member string) error {
+	if err := z.store.checkOpen(); err != nil {
+		return err
+	}
+	if err := z.store.client.ZRem(ctx, z.key, member).Err(); err != nil {
+		return stoabs.DatabaseError(err)
+	}
+	return nil
+}
+
+func (z scoredShelf) Score(ctx context.Context, member string) (float64, error) {
+	if err := z.store.checkOpen(); err != nil {
+		return 0, err
+	}
+	score, err := z.store.client.ZScore(ctx, z.key, member).Result()
+	if errors.Is(err, redis.Nil) {
+		return 0, scored.ErrNotFound
+	}
+	if err != nil {
+		return 0, stoabs.DatabaseError(err)
+	}
+	return score, nil
+}
+
+func (z scoredShelf) RangeByScore(ctx context.Context, min, max float64, fn func(scored.Member) error) error {
+	if math.IsNaN(min) || math.IsNaN(max) || min > max {
+		return nil
+	}
+	if err := z.store.checkOpen(); err != nil {
+		return err
+	}
+	var offset int64
+	for {
+		members, err := z.store.client.ZRangeByScoreWithScores(ctx, z.key, &redis.ZRangeBy{
+			Min:    formatScore(min),
+			Max:    formatScore(max),
+			Offset: offset,
+			Count:  resultCount,
+		}).Result()
+		if err != nil {
+			return stoabs.DatabaseError(err)
+		}
+		for _, member := range members {
+			if err := ctx.Err(); err != nil {
+				return stoabs.DatabaseError(err)
+			}
+			if err := fn(toMember(member)); err != nil {
+				return err
+			}
+		}
+		if len(members) < resultCount {
+			return nil
+		}
+		offset += int64(len(members))
+	}
+}
+
+func (z scoredShelf) Top(ctx context.Context, n int) ([]scored.Member, error) {
+	var result []scored.Member
+	if n <= 0 {
+		return result, nil
+	}
+	if err := z.store.checkOpen(); err != nil {
+		return nil, err
+	}
+	members, err := z.store.client.ZRevRangeWithScores(ctx, z.key, 0, int64(n-1)).Result()
+	if err != nil {
+		return nil, stoabs.DatabaseError(err)
+	}
+	for _, member := range members {
+		result = append(result, toMember(member))
+	}
+	return result, nil
+}
+
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "+inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+func toMember(z redis.Z) scored.Member {
+	member, _ := z.Member.(string)
+	return scored.Member{Member: member, Score: z.Score}
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package redis7
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+)
+
+var _ stoabs.StatsReader = (*store)(nil)
+
+// Stats returns the statistics of the store. Redis doesn't support snapshots, so the number of shelves (which is counted
+// by scanning the keys) isn't consistent with the memory usage when the store is written to concurrently.
+// Size and MemoryUsage are both the memory used by the Redis server, which may be shared with other applications.
+func (s *store) Stats(ctx context.Context) (stoabs.StoreStats, error) {
+	names, err := s.ShelfNames(stoabs.ContextWithReservedShelves(ctx))
+	if err != nil {
+		return stoabs.StoreStats{}, err
+	}
+	info, err := s.client.Info(ctx).Result()
+	if err != nil {
+		return stoabs.StoreStats{}, stoabs.DatabaseError(err)
+	}
+	result := parseStats(info)
+	result.Shelves = len(names)
+	result.OpenTransactions = s.drainer.InFlight()
+	result.Evictions = s.evictions.Load()
+	return result, nil
+}
+
+// parseStats parses the memory usage (used_memory), memory limit (maxmemory), eviction policy (maxmemory_policy)
+// and time of the last RDB snapshot (rdb_last_save_time) from the output of the INFO command. Fields that are missing (e.g. because the server doesn't report them) are left zero.
+func parseStats(info string) stoabs.StoreStats {
+	var result stoabs.StoreStats
+	fields := parseInfo(info)
+	if value, err := strconv.ParseUint(fields["used_memory"], 10, 64); err == nil {
+		result.MemoryUsage = value
+		result.Size = value
+	}
+	if value, err := strconv.ParseUint(fields["maxmemory"], 10, 64); err == nil {
+		result.MemoryLimit = value
+	}
+	result.EvictionPolicy = fields["maxmemory_policy"]
+	if value, err := strconv.ParseInt(fields["rdb_last_save_time"], 10, 64); err == nil && value > 0 {
+		result.LastBackup = time.Unix(value, 0)
+	}
+	return result
+}
@@ -0,0 +1,58 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package redis7
+
+import (
+	"context"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestRedis_Stats(t *testing.T) {
+	ctx := context.Background()
+	_, store := NewTestStore(t)
+	require.NoError(t, store.WriteShelf(ctx, "shelf", func(writer stoabs.Writer) error {
+		return writer.Put(stoabs.BytesKey("key"), []byte("value"))
+	}))
+
+	stats, err := store.Stats(ctx)
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.Shelves)
+}
+
+func TestParseStats(t *testing.T) {
+	t.Run("memory and persistence", func(t *testing.T) {
+		stats := parseStats("# Memory\r\nused_memory:1048576\r\nused_memory_human:1.00M\r\nmaxmemory:2097152\r\nmaxmemory_policy:allkeys-lru\r\n\r\n# Persistence\r\nrdb_last_save_time:1700000000\r\n")
+
+		assert.Equal(t, uint64(1048576), stats.MemoryUsage)
+		assert.Equal(t, uint64(2097152), stats.MemoryLimit)
+		assert.Equal(t, "allkeys-lru", stats.EvictionPolicy)
+		assert.Equal(t, uint64(1048576), stats.Size)
+		assert.Equal(t, time.Unix(1700000000, 0), stats.LastBackup)
+	})
+	t.Run("missing fields", func(t *testing.T) {
+		stats := parseStats("# Clients\r\nconnected_clients:1\r\n")
+
+		assert.Equal(t, stoabs.StoreStats{}, stats)
+	})
+}
@@ -0,0 +1,338 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package remote
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/remote/remotepb"
+	"github.com/nuts-foundation/go-stoabs/util"
+	"google.golang.org/grpc"
+)
+
+var _ stoabs.KVStore = (*Client)(nil)
+var _ stoabs.ShelfLister = (*Client)(nil)
+var _ stoabs.WriteTx = (*clientTx)(nil)
+var _ stoabs.Writer = (*shelf)(nil)
+
+// defaultScanWindow is the default number of batches of Iterate and Range the server sends ahead, see WithScanWindow.
+const defaultScanWindow = 4
+
+// ClientOption configures a Client.
+type ClientOption func(c *Client)
+
+// WithScanWindow specifies the number of batches of Iterate and Range results the server may send ahead of the callback,
+// which defaults to 4. A larger window hides the latency of the connection, but (as every batch holds up to 100 entries
+// or 1 MiB) requires more memory for the batches that are received but not yet visited.
+func WithScanWindow(batches int) ClientOption {
+	return func(c *Client) {
+		c.scanWindow = max(batches, 1)
+	}
+}
+
+// NewClient creates a KVStore that executes its transactions on a remote store exposed by a Server.
+// The given connection is owned by the caller: closing the client doesn't close the connection.
+func NewClient(conn grpc.ClientConnInterface, opts ...ClientOption) *Client {
+	result := &Client{client: remotepb.NewKVStoreClient(conn), scanWindow: defaultScanWindow}
+	for _, opt := range opts {
+		opt(result)
+	}
+	return result
+}
+
+// Client is a KVStore that accesses a remote store over gRPC. Use NewClient to create it.
+type Client struct {
+	client     remotepb.KVStoreClient
+	closed     atomic.Bool
+	scanWindow int
+}
+
+func (c *Client) Write(ctx context.Context, fn func(stoabs.WriteTx) error, opts ...stoabs.TxOption) error {
+	begin := &remotepb.Begin{
+		Writable:   true,
+		WriteLock:  stoabs.WriteLockOption{}.Enabled(opts),
+		ShelfLocks: stoabs.ShelfLockOption{}.Shelves(opts),
+	}
+	return c.transaction(ctx, begin, func(tx *clientTx) error {
+		return fn(tx)
+	}, opts)
+}
+
+func (c *Client) Read(ctx context.Context, fn func(stoabs.ReadTx) error) error {
+	return c.transaction(ctx, &remotepb.Begin{}, func(tx *clientTx) error {
+		return fn(tx)
+	}, nil)
+}
+
+func (c *Client) WriteShelf(ctx context.Context, shelfName string, fn func(stoabs.Writer) error) error {
+	return c.Write(ctx, func(tx stoabs.WriteTx) error {
+		return fn(tx.GetShelfWriter(shelfName))
+	})
+}
+
+func (c *Client) ReadShelf(ctx context.Context, shelfName string, fn func(stoabs.Reader) error) error {
+	return c.Read(ctx, func(tx stoabs.ReadTx) error {
+		return fn(tx.GetShelfReader(shelfName))
+	})
+}
+
+func (c *Client) ShelfNames(ctx context.Context) ([]string, error) {
+	if c.closed.Load() {
+		return nil, stoabs.ErrStoreIsClosed
+	}
+	response, err := c.client.ShelfNames(ctx, &remotepb.ShelfNamesRequest{})
+	if err != nil {
+		return nil, rpcError(ctx, err)
+	}
+	if err := fromError(response.Error); err != nil {
+		return nil, err
+	}
+	return stoabs.FilterReservedShelves(ctx, response.Names), nil
+}
+
+// Close marks the client as closed, after which all operations return stoabs.ErrStoreIsClosed.
+// It doesn't close the underlying connection or the remote store.
+func (c *Client) Close(_ context.Context) error {
+	c.closed.Store(true)
+	return nil
+}
+
+func (c *Client) transaction(ctx context.Context, begin *remotepb.Begin, fn func(tx *clientTx) error, opts []stoabs.TxOption) error {
+	if c.closed.Load() {
+		return stoabs.ErrStoreIsClosed
+	}
+	if ctx.Err() != nil {
+		return stoabs.DatabaseError(ctx.Err())
+	}
+	// Cancelling the stream makes the server roll back the transaction if it isn't finished properly,
+	// e.g. when fn panics.
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := c.client.Transaction(streamCtx)
+	if err != nil {
+		return rpcError(ctx, err)
+	}
+	tx := &clientTx{client: c, ctx: ctx, stream: stream}
+	if _, err = tx.request(&remotepb.TxRequest{Request: &remotepb.TxRequest_Begin{Begin: begin}}); err != nil {
+		return err
+	}
+
+	if appError := fn(tx); appError != nil {
+		_, _ = tx.exchange(&remotepb.TxRequest{Request: &remotepb.TxRequest_Rollback{Rollback: &remotepb.Rollback{}}})
+		stoabs.OnRollbackOption{}.Invoke(opts)
+		return appError
+	}
+	if ctx.Err() != nil {
+		_, _ = tx.exchange(&remotepb.TxRequest{Request: &remotepb.TxRequest_Rollback{Rollback: &remotepb.Rollback{}}})
+		stoabs.OnRollbackOption{}.Invoke(opts)
+		return util.WrapError(stoabs.ErrCommitFailed, ctx.Err())
+	}
+	if _, err = tx.request(&remotepb.TxRequest{Request: &remotepb.TxRequest_Commit{Commit: &remotepb.Commit{}}}); err != nil {
+		stoabs.OnRollbackOption{}.Invoke(opts)
+		return err
+	}
+	stoabs.AfterCommitOption{}.Invoke(opts)
+	return nil
+}
+
+// rpcError converts an error of the gRPC connection to a stoabs.ErrDatabase.
+func rpcError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return stoabs.DatabaseError(ctx.Err())
+	}
+	return stoabs.DatabaseError(fmt.Errorf("remote store: %w", err))
+}
+
+type clientTx struct {
+	client *Client
+	ctx    context.Context
+	stream grpc.BidiStreamingClient[remotepb.TxRequest, remotepb.TxResponse]
+}
+
+func (t *clientTx) GetShelfWriter(shelfName string) stoabs.Writer {
+	return &shelf{tx: t, name: shelfName}
+}
+
+func (t *clientTx) GetShelfReader(shelfName string) stoabs.Reader {
+	return &shelf{tx: t, name: shelfName}
+}
+
+func (t *clientTx) Store() stoabs.KVStore {
+	return t.client
+}
+
+// Unwrap returns nil, since the underlying transaction lives in the remote process.
+func (t *clientTx) Unwrap() interface{} {
+	return nil
+}
+
+// exchange sends the request and receives its response. It only returns an error if the stream failed.
+func (t *clientTx) exchange(request *remotepb.TxRequest) (*remotepb.TxResponse, error) {
+	if err := t.send(request); err != nil {
+		return nil, err
+	}
+	return t.receive()
+}
+
+// send sends the request without waiting for a response.
+func (t *clientTx) send(request *remotepb.TxRequest) error {
+	if err := t.stream.Send(request); err != nil {
+		return rpcError(t.ctx, err)
+	}
+	return nil
+}
+
+func (t *clientTx) receive() (*remotepb.TxResponse, error) {
+	response, err := t.stream.Recv()
+	if err != nil {
+		return nil, rpcError(t.ctx, err)
+	}
+	return response, nil
+}
+
+// request is like exchange, but also returns the error of the response, if set.
+func (t *clientTx) request(request *remotepb.TxRequest) (*remotepb.TxResponse, error) {
+	response, err := t.exchange(request)
+	if err != nil {
+		return nil, err
+	}
+	return response, fromError(response.Error)
+}
+
+type shelf struct {
+	tx   *clientTx
+	name string
+}
+
+func (s *shelf) Empty() (bool, error) {
+	response, err := s.tx.request(&remotepb.TxRequest{Request: &remotepb.TxRequest_IsEmpty{IsEmpty: &remotepb.IsEmpty{Shelf: s.name}}})
+	if err != nil {
+		return false, err
+	}
+	return response.Empty, nil
+}
+
+func (s *shelf) Get(key stoabs.Key) ([]byte, error) {
+	response, err := s.tx.request(&remotepb.TxRequest{Request: &remotepb.TxRequest_Get{Get: &remotepb.Get{Shelf: s.name, Key: key.Bytes(), KeyType: toKeyType(key)}}})
+	if err != nil {
+		return nil, err
+	}
+	return response.Value, nil
+}
+
+func (s *shelf) Put(key stoabs.Key, value []byte) error {
+	_, err := s.tx.request(&remotepb.TxRequest{Request: &remotepb.TxRequest_Put{Put: &remotepb.Put{Shelf: s.name, Key: key.Bytes(), Value: value, KeyType: toKeyType(key)}}})
+	return err
+}
+
+func (s *shelf) Delete(key stoabs.Key) error {
+	_, err := s.tx.request(&remotepb.TxRequest{Request: &remotepb.TxRequest_Delete{Delete: &remotepb.Delete{Shelf: s.name, Key: key.Bytes(), KeyType: toKeyType(key)}}})
+	return err
+}
+
+func (s *shelf) Iterate(callback stoabs.CallerFn, keyType stoabs.Key) error {
+	return s.scan(&remotepb.TxRequest{Request: &remotepb.TxRequest_Iterate{Iterate: &remotepb.Iterate{
+		Shelf:   s.name,
+		KeyType: toKeyType(keyType),
+		Window:  uint32(s.tx.client.scanWindow),
+	}}}, keyType, callback)
+}
+
+func (s *shelf) Range(from stoabs.Key, to stoabs.Key, callback stoabs.CallerFn, stopAtNil bool) error {
+	return s.scan(&remotepb.TxRequest{Request: &remotepb.TxRequest_Range{Range: &remotepb.Range{
+		Shelf:     s.name,
+		From:      from.Bytes(),
+		To:        to.Bytes(),
+		StopAtNil: stopAtNil,
+		KeyType:   toKeyType(from),
+		Window:    uint32(s.tx.client.scanWindow),
+	}}}, from, callback)
+}
+
+func (s *shelf) Stats() stoabs.ShelfStats {
+	response, err := s.tx.request(&remotepb.TxRequest{Request: &remotepb.TxRequest_Stats{Stats: &remotepb.Stats{Shelf: s.name}}})
+	if err != nil || response.Stats == nil {
+		return stoabs.ShelfStats{}
+	}
+	return stoabs.ShelfStats{
+		NumEntries: uint(response.Stats.NumEntries),
+		ShelfSize:  uint(response.Stats.ShelfSize),
+	}
+}
+
+// scan starts the Iterate or Range request and calls the callback for every received entry, until the server is done.
+// The server sends batches ahead (see WithScanWindow), after every visited batch it's allowed to send another one.
+// The entries are passed to the callback as received, without copying them. If the callback fails, the scan is stopped.
+func (s *shelf) scan(request *remotepb.TxRequest, keyType stoabs.Key, callback stoabs.CallerFn) error {
+	response, err := s.tx.exchange(request)
+	for {
+		if err != nil {
+			return err
+		}
+		callbackErr := s.visit(response.Entries, keyType, callback)
+		if response.Done {
+			if callbackErr != nil {
+				return callbackErr
+			}
+			return fromError(response.Error)
+		}
+		if callbackErr != nil {
+			return s.stop(callbackErr)
+		}
+		if err = s.tx.send(&remotepb.TxRequest{Request: &remotepb.TxRequest_Next{Next: &remotepb.Next{}}}); err != nil {
+			return err
+		}
+		response, err = s.tx.receive()
+	}
+}
+
+// stop stops the scan because the callback failed, discarding the batches the server sent ahead.
+func (s *shelf) stop(callbackErr error) error {
+	if err := s.tx.send(&remotepb.TxRequest{Request: &remotepb.TxRequest_Stop{Stop: &remotepb.Stop{}}}); err != nil {
+		return err
+	}
+	for {
+		response, err := s.tx.receive()
+		if err != nil {
+			return err
+		}
+		if response.Done {
+			return callbackErr
+		}
+	}
+}
+
+func (s *shelf) visit(entries []*remotepb.Entry, keyType stoabs.Key, callback stoabs.CallerFn) error {
+	for _, entry := range entries {
+		if s.tx.ctx.Err() != nil {
+			return stoabs.DatabaseError(s.tx.ctx.Err())
+		}
+		key, err := keyType.FromBytes(entry.Key)
+		if err != nil {
+			return err
+		}
+		if err := callback(key, entry.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package remote
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/remote/remotepb"
+)
+
+// toError converts an error returned by the store to its wire representation.
+func toError(err error) *remotepb.Error {
+	if err == nil {
+		return nil
+	}
+	result := &remotepb.Error{Message: err.Error()}
+	switch {
+	case errors.Is(err, stoabs.ErrKeyNotFound):
+		result.Code = remotepb.Code_CODE_KEY_NOT_FOUND
+	case errors.Is(err, stoabs.ErrDatabase{}):
+		// ErrStoreIsClosed and ErrCommitFailed match every ErrDatabase using errors.Is, so look at the message instead
+		switch {
+		case strings.Contains(result.Message, stoabs.ErrStoreIsClosed.Error()):
+			result.Code = remotepb.Code_CODE_STORE_CLOSED
+		case strings.Contains(result.Message, stoabs.ErrCommitFailed.Error()):
+			result.Code = remotepb.Code_CODE_COMMIT_FAILED
+		default:
+			result.Code = remotepb.Code_CODE_DATABASE
+		}
+	case errors.Is(err, errors.ErrUnsupported):
+		result.Code = remotepb.Code_CODE_UNSUPPORTED
+	}
+	return result
+}
+
+// fromError converts the wire representation of an error to an error that has the original message,
+// and matches the original stoabs error using errors.Is.
+func fromError(err *remotepb.Error) error {
+	if err == nil {
+		return nil
+	}
+	var target error
+	switch err.Code {
+	case remotepb.Code_CODE_KEY_NOT_FOUND:
+		target = stoabs.ErrKeyNotFound
+	case remotepb.Code_CODE_STORE_CLOSED:
+		target = stoabs.ErrStoreIsClosed
+	case remotepb.Code_CODE_COMMIT_FAILED:
+		target = stoabs.ErrCommitFailed
+	case remotepb.Code_CODE_DATABASE:
+		target = stoabs.ErrDatabase{}
+	case remotepb.Code_CODE_UNSUPPORTED:
+		target = errors.ErrUnsupported
+	default:
+		return errors.New(err.Message)
+	}
+	if err.Message == target.Error() {
+		return target
+	}
+	return remoteError{message: err.Message, target: target}
+}
+
+// remoteError is an error returned by the remote store.
+type remoteError struct {
+	message string
+	target  error
+}
+
+func (e remoteError) Error() string {
+	return e.message
+}
+
+func (e remoteError) Is(other error) bool {
+	return errors.Is(e.target, other)
+}
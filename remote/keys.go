@@ -0,0 +1,57 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package remote
+
+import (
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/remote/remotepb"
+)
+
+// toKeyType returns the wire representation of the type of the given key. Unknown key types are sent as bytes.
+func toKeyType(key stoabs.Key) remotepb.KeyType {
+	switch key.(type) {
+	case stoabs.Uint32Key:
+		return remotepb.KeyType_KEY_TYPE_UINT32
+	case stoabs.Uint64Key:
+		return remotepb.KeyType_KEY_TYPE_UINT64
+	case stoabs.HashKey:
+		return remotepb.KeyType_KEY_TYPE_HASH
+	default:
+		return remotepb.KeyType_KEY_TYPE_BYTES
+	}
+}
+
+// keyOfType returns a key of the given type, to be used as stoabs.Key.FromBytes receiver or as Iterate key type.
+func keyOfType(keyType remotepb.KeyType) stoabs.Key {
+	switch keyType {
+	case remotepb.KeyType_KEY_TYPE_UINT32:
+		return stoabs.Uint32Key(0)
+	case remotepb.KeyType_KEY_TYPE_UINT64:
+		return stoabs.Uint64Key(0)
+	case remotepb.KeyType_KEY_TYPE_HASH:
+		return stoabs.HashKey{}
+	default:
+		return stoabs.BytesKey{}
+	}
+}
+
+// fromKey rebuilds a key from its wire representation.
+func fromKey(keyType remotepb.KeyType, key []byte) (stoabs.Key, error) {
+	return keyOfType(keyType).FromBytes(key)
+}
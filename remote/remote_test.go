@@ -0,0 +1,456 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/bbolt"
+	"github.com/nuts-foundation/go-stoabs/kvtests"
+	"github.com/nuts-foundation/go-stoabs/redis7"
+	"github.com/nuts-foundation/go-stoabs/remote/remotepb"
+	"github.com/nuts-foundation/go-stoabs/util"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+var ctx = context.Background()
+
+const shelfName = "test"
+
+func TestRemote(t *testing.T) {
+	provider := func(t *testing.T) (stoabs.KVStore, error) {
+		client, _ := createClient(t)
+		return client, nil
+	}
+
+	kvtests.TestReadingAndWriting(t, provider)
+	kvtests.TestRange(t, provider)
+	kvtests.TestIterate(t, provider)
+	kvtests.TestEmpty(t, provider)
+	kvtests.TestDelete(t, provider)
+	kvtests.TestWriteTransactions(t, provider)
+	kvtests.TestShelfNames(t, provider)
+	t.Run("without scan window", func(t *testing.T) {
+		provider := func(t *testing.T) (stoabs.KVStore, error) {
+			store, err := bbolt.CreateBBoltStore(path.Join(util.TestDirectory(t), "bbolt.db"), stoabs.WithNoSync())
+			if err != nil {
+				return nil, err
+			}
+			return createClientFor(t, store, WithScanWindow(1)), nil
+		}
+
+		kvtests.TestRange(t, provider)
+		kvtests.TestIterate(t, provider)
+	})
+}
+
+func TestClient_Read(t *testing.T) {
+	client, _ := createClient(t)
+	err := client.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+		for i := 0; i < 2*batchSize+10; i++ {
+			if err := writer.Put(stoabs.Uint32Key(i), []byte(fmt.Sprintf("value-%d", i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	t.Run("range over multiple batches", func(t *testing.T) {
+		var keys []stoabs.Key
+		err := client.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			return reader.Range(stoabs.Uint32Key(0), stoabs.Uint32Key(2*batchSize+10), func(key stoabs.Key, _ []byte) error {
+				keys = append(keys, key)
+				return nil
+			}, false)
+		})
+
+		require.NoError(t, err)
+		require.Len(t, keys, 2*batchSize+10)
+		assert.Equal(t, stoabs.Uint32Key(2*batchSize+9), keys[len(keys)-1])
+	})
+	t.Run("callback error stops scan, transaction remains usable", func(t *testing.T) {
+		var count int
+		var value []byte
+		err := client.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			err := reader.Iterate(func(_ stoabs.Key, _ []byte) error {
+				count++
+				if count == batchSize+5 {
+					return errors.New("stop")
+				}
+				return nil
+			}, stoabs.Uint32Key(0))
+			if err == nil {
+				return errors.New("expected error")
+			}
+			value, err = reader.Get(stoabs.Uint32Key(1))
+			return err
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, batchSize+5, count)
+		assert.Equal(t, []byte("value-1"), value)
+	})
+	t.Run("callback error stops scan with batches sent ahead", func(t *testing.T) {
+		for _, count := range []int{1, batchSize, 2*batchSize + 5} {
+			var visited int
+			var value []byte
+			err := client.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+				err := reader.Range(stoabs.Uint32Key(0), stoabs.Uint32Key(2*batchSize+10), func(stoabs.Key, []byte) error {
+					if visited++; visited == count {
+						return errors.New("stop")
+					}
+					return nil
+				}, false)
+				if err == nil {
+					return errors.New("expected error")
+				}
+				value, err = reader.Get(stoabs.Uint32Key(2))
+				return err
+			})
+
+			require.NoError(t, err)
+			assert.Equal(t, count, visited)
+			assert.Equal(t, []byte("value-2"), value)
+		}
+	})
+	t.Run("stats", func(t *testing.T) {
+		var stats stoabs.ShelfStats
+		_ = client.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			stats = reader.Stats()
+			return nil
+		})
+
+		assert.Equal(t, uint(2*batchSize+10), stats.NumEntries)
+	})
+}
+
+func TestClient_Scan(t *testing.T) {
+	t.Run("large values are split over batches", func(t *testing.T) {
+		client, _ := createClient(t)
+		// together far above the maximum message size of gRPC
+		value := make([]byte, batchBytes/2+1)
+		const count = 20
+		require.NoError(t, client.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			for i := 0; i < count; i++ {
+				if err := writer.Put(stoabs.Uint32Key(i), value); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+		var visited int
+
+		err := client.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			return reader.Iterate(func(_ stoabs.Key, actual []byte) error {
+				visited++
+				assert.Len(t, actual, len(value))
+				return nil
+			}, stoabs.Uint32Key(0))
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, count, visited)
+	})
+	t.Run("server sends at most window batches ahead", func(t *testing.T) {
+		const window = 2
+		store, err := bbolt.CreateBBoltStore(path.Join(util.TestDirectory(t), "bbolt.db"), stoabs.WithNoSync())
+		require.NoError(t, err)
+		counter := &scanCounter{KVStore: store}
+		client := createClientFor(t, counter, WithScanWindow(window))
+		require.NoError(t, client.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			for i := 0; i < 10*batchSize; i++ {
+				if err := writer.Put(stoabs.Uint32Key(i), []byte("value")); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+		release := make(chan struct{})
+		var visited int
+
+		go func() {
+			// the server stops visiting entries once it sent the window, while the client's callback blocks
+			assert.Eventually(t, func() bool {
+				return counter.visited.Load() == window*batchSize
+			}, 5*time.Second, time.Millisecond)
+			time.Sleep(50 * time.Millisecond)
+			assert.Equal(t, int64(window*batchSize), counter.visited.Load())
+			close(release)
+		}()
+		err = client.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			return reader.Iterate(func(stoabs.Key, []byte) error {
+				if visited++; visited == 1 {
+					<-release
+				}
+				return nil
+			}, stoabs.Uint32Key(0))
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, 10*batchSize, visited)
+	})
+}
+
+// scanCounter counts the entries visited by Iterate on the server.
+type scanCounter struct {
+	stoabs.KVStore
+	visited atomic.Int64
+}
+
+func (s *scanCounter) Read(ctx context.Context, fn func(stoabs.ReadTx) error) error {
+	return s.KVStore.Read(ctx, func(tx stoabs.ReadTx) error {
+		return fn(scanCounterTx{ReadTx: tx, counter: s})
+	})
+}
+
+type scanCounterTx struct {
+	stoabs.ReadTx
+	counter *scanCounter
+}
+
+func (s scanCounterTx) GetShelfReader(shelfName string) stoabs.Reader {
+	return scanCounterReader{Reader: s.ReadTx.GetShelfReader(shelfName), counter: s.counter}
+}
+
+type scanCounterReader struct {
+	stoabs.Reader
+	counter *scanCounter
+}
+
+func (s scanCounterReader) Iterate(callback stoabs.CallerFn, keyType stoabs.Key) error {
+	return s.Reader.Iterate(func(key stoabs.Key, value []byte) error {
+		s.counter.visited.Add(1)
+		return callback(key, value)
+	}, keyType)
+}
+
+func TestClient_Write(t *testing.T) {
+	t.Run("rollback on error", func(t *testing.T) {
+		client, store := createClient(t)
+		var rolledBack bool
+
+		err := client.Write(ctx, func(tx stoabs.WriteTx) error {
+			_ = tx.GetShelfWriter(shelfName).Put(stoabs.BytesKey("key"), []byte("value"))
+			return errors.New("failed")
+		}, stoabs.OnRollback(func() {
+			rolledBack = true
+		}))
+
+		assert.EqualError(t, err, "failed")
+		assert.True(t, rolledBack)
+		_ = store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			_, err := reader.Get(stoabs.BytesKey("key"))
+			assert.ErrorIs(t, err, stoabs.ErrKeyNotFound)
+			return nil
+		})
+	})
+	t.Run("locks are passed to the remote store", func(t *testing.T) {
+		store, err := bbolt.CreateBBoltStore(path.Join(util.TestDirectory(t), "bbolt.db"), stoabs.WithNoSync())
+		require.NoError(t, err)
+		recorder := &optsRecorder{KVStore: store}
+		client := createClientFor(t, recorder)
+
+		err = client.Write(ctx, func(tx stoabs.WriteTx) error {
+			return nil
+		}, stoabs.WithWriteLock(), stoabs.WithShelfLock("b", "a"))
+
+		require.NoError(t, err)
+		assert.True(t, stoabs.WriteLockOption{}.Enabled(recorder.opts))
+		assert.Equal(t, []string{"a", "b"}, stoabs.ShelfLockOption{}.Shelves(recorder.opts))
+	})
+	t.Run("cancelled context", func(t *testing.T) {
+		client, _ := createClient(t)
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		err := client.Write(ctx, func(tx stoabs.WriteTx) error {
+			return nil
+		})
+
+		assert.ErrorIs(t, err, stoabs.ErrDatabase{})
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+	t.Run("remote store is closed", func(t *testing.T) {
+		client, store := createClient(t)
+		_ = store.Close(ctx)
+
+		err := client.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			return nil
+		})
+
+		assert.ErrorIs(t, err, stoabs.ErrStoreIsClosed)
+	})
+	t.Run("client is closed", func(t *testing.T) {
+		client, _ := createClient(t)
+		_ = client.Close(ctx)
+
+		err := client.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			return nil
+		})
+
+		assert.ErrorIs(t, err, stoabs.ErrStoreIsClosed)
+	})
+}
+
+func TestErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		err  error
+		is   []error
+	}{
+		{name: "key not found", err: stoabs.ErrKeyNotFound, is: []error{stoabs.ErrKeyNotFound}},
+		{name: "store is closed", err: stoabs.ErrStoreIsClosed, is: []error{stoabs.ErrStoreIsClosed, stoabs.ErrDatabase{}}},
+		{name: "commit failed", err: util.WrapError(stoabs.ErrCommitFailed, errors.New("disk full")), is: []error{stoabs.ErrCommitFailed, stoabs.ErrDatabase{}}},
+		{name: "database error", err: stoabs.DatabaseError(errors.New("disk full")), is: []error{stoabs.ErrDatabase{}}},
+		{name: "unsupported", err: fmt.Errorf("listing shelves: %w", errors.ErrUnsupported), is: []error{errors.ErrUnsupported}},
+		{name: "other", err: errors.New("failed")},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			result := fromError(toError(testCase.err))
+
+			assert.EqualError(t, result, testCase.err.Error())
+			for _, expected := range testCase.is {
+				assert.ErrorIs(t, result, expected)
+			}
+		})
+	}
+	t.Run("nil", func(t *testing.T) {
+		assert.Nil(t, toError(nil))
+		assert.NoError(t, fromError(nil))
+	})
+}
+
+func TestKeyTypes(t *testing.T) {
+	t.Run("range stops at nil using the key type of the client", func(t *testing.T) {
+		client, _ := createClient(t)
+		require.NoError(t, client.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			for i := 0; i < 5; i++ {
+				if err := writer.Put(stoabs.Uint32Key(i), []byte("value")); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+
+		var keys []stoabs.Key
+		err := client.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			return reader.Range(stoabs.Uint32Key(0), stoabs.Uint32Key(5), func(key stoabs.Key, _ []byte) error {
+				keys = append(keys, key)
+				return nil
+			}, true)
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, []stoabs.Key{stoabs.Uint32Key(0), stoabs.Uint32Key(1), stoabs.Uint32Key(2), stoabs.Uint32Key(3), stoabs.Uint32Key(4)}, keys)
+	})
+	t.Run("Redis", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		store, err := redis7.CreateRedisStore("db", &redis.Options{Addr: mr.Addr()})
+		require.NoError(t, err)
+		client := createClientFor(t, store)
+		require.NoError(t, client.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			return writer.Put(stoabs.Uint32Key(1), []byte("value"))
+		}))
+
+		var keys []stoabs.Key
+		err = client.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			if _, err := reader.Get(stoabs.Uint32Key(1)); err != nil {
+				return err
+			}
+			return reader.Iterate(func(key stoabs.Key, _ []byte) error {
+				keys = append(keys, key)
+				return nil
+			}, stoabs.Uint32Key(0))
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, []stoabs.Key{stoabs.Uint32Key(1)}, keys)
+		// stored in the string form of Uint32Key, as if written directly to the Redis store
+		err = store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			_, err := reader.Get(stoabs.Uint32Key(1))
+			return err
+		})
+		assert.NoError(t, err)
+	})
+	t.Run("wire representation", func(t *testing.T) {
+		for _, key := range []stoabs.Key{stoabs.BytesKey("key"), stoabs.Uint32Key(1), stoabs.Uint64Key(1), stoabs.HashKey{1}} {
+			actual, err := fromKey(toKeyType(key), key.Bytes())
+
+			require.NoError(t, err)
+			assert.Equal(t, key, actual)
+		}
+	})
+}
+
+// createClient starts a server for a new bbolt store on an in-memory connection, and returns a client connected to it.
+func createClient(t *testing.T) (*Client, stoabs.KVStore) {
+	store, err := bbolt.CreateBBoltStore(path.Join(util.TestDirectory(t), "bbolt.db"), stoabs.WithNoSync())
+	require.NoError(t, err)
+	return createClientFor(t, store), store
+}
+
+// optsRecorder records the options of the last write transaction.
+type optsRecorder struct {
+	stoabs.KVStore
+	opts []stoabs.TxOption
+}
+
+func (o *optsRecorder) Write(ctx context.Context, fn func(stoabs.WriteTx) error, opts ...stoabs.TxOption) error {
+	o.opts = opts
+	return o.KVStore.Write(ctx, fn, opts...)
+}
+
+// createClientFor starts a server for the given store on an in-memory connection, and returns a client connected to it.
+// The store is closed when the test finishes.
+func createClientFor(t *testing.T, store stoabs.KVStore, opts ...ClientOption) *Client {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	remotepb.RegisterKVStoreServer(server, NewServer(store))
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+		server.Stop()
+		_ = store.Close(context.Background())
+	})
+	return NewClient(conn, opts...)
+}
@@ -0,0 +1,187 @@
+//
+// Copyright (C) 2022 Nuts community
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.28.3
+// source: remote.proto
+
+package remotepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	KVStore_Transaction_FullMethodName = "/stoabs.remote.v1.KVStore/Transaction"
+	KVStore_ShelfNames_FullMethodName  = "/stoabs.remote.v1.KVStore/ShelfNames"
+)
+
+// KVStoreClient is the client API for KVStore service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// KVStore exposes a stoabs.KVStore.
+type KVStoreClient interface {
+	// Transaction executes a transaction. The first request must be a Begin, the last a Commit or Rollback.
+	// Every other request is answered by a single response, except Iterate and Range which are answered by batches of
+	// entries, limited in number and size. The server sends up to window batches ahead: after consuming a batch that is not
+	// done the client sends Next, allowing the server to send another batch, or Stop to end the scan. After Stop, the client
+	// discards the batches sent ahead until the one that is done. Next and Stop received after a scan is done are ignored.
+	Transaction(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[TxRequest, TxResponse], error)
+	// ShelfNames returns the names of all shelves in the store.
+	ShelfNames(ctx context.Context, in *ShelfNamesRequest, opts ...grpc.CallOption) (*ShelfNamesResponse, error)
+}
+
+type kVStoreClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewKVStoreClient(cc grpc.ClientConnInterface) KVStoreClient {
+	return &kVStoreClient{cc}
+}
+
+func (c *kVStoreClient) Transaction(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[TxRequest, TxResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &KVStore_ServiceDesc.Streams[0], KVStore_Transaction_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[TxRequest, TxResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type KVStore_TransactionClient = grpc.BidiStreamingClient[TxRequest, TxResponse]
+
+func (c *kVStoreClient) ShelfNames(ctx context.Context, in *ShelfNamesRequest, opts ...grpc.CallOption) (*ShelfNamesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ShelfNamesResponse)
+	err := c.cc.Invoke(ctx, KVStore_ShelfNames_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// KVStoreServer is the server API for KVStore service.
+// All implementations must embed UnimplementedKVStoreServer
+// for forward compatibility.
+//
+// KVStore exposes a stoabs.KVStore.
+type KVStoreServer interface {
+	// Transaction executes a transaction. The first request must be a Begin, the last a Commit or Rollback.
+	// Every other request is answered by a single response, except Iterate and Range which are answered by batches of
+	// entries, limited in number and size. The server sends up to window batches ahead: after consuming a batch that is not
+	// done the client sends Next, allowing the server to send another batch, or Stop to end the scan. After Stop, the client
+	// discards the batches sent ahead until the one that is done. Next and Stop received after a scan is done are ignored.
+	Transaction(grpc.BidiStreamingServer[TxRequest, TxResponse]) error
+	// ShelfNames returns the names of all shelves in the store.
+	ShelfNames(context.Context, *ShelfNamesRequest) (*ShelfNamesResponse, error)
+	mustEmbedUnimplementedKVStoreServer()
+}
+
+// UnimplementedKVStoreServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedKVStoreServer struct{}
+
+func (UnimplementedKVStoreServer) Transaction(grpc.BidiStreamingServer[TxRequest, TxResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method Transaction not implemented")
+}
+func (UnimplementedKVStoreServer) ShelfNames(context.Context, *ShelfNamesRequest) (*ShelfNamesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ShelfNames not implemented")
+}
+func (UnimplementedKVStoreServer) mustEmbedUnimplementedKVStoreServer() {}
+func (UnimplementedKVStoreServer) testEmbeddedByValue()                 {}
+
+// UnsafeKVStoreServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to KVStoreServer will
+// result in compilation errors.
+type UnsafeKVStoreServer interface {
+	mustEmbedUnimplementedKVStoreServer()
+}
+
+func RegisterKVStoreServer(s grpc.ServiceRegistrar, srv KVStoreServer) {
+	// If the following call pancis, it indicates UnimplementedKVStoreServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&KVStore_ServiceDesc, srv)
+}
+
+func _KVStore_Transaction_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(KVStoreServer).Transaction(&grpc.GenericServerStream[TxRequest, TxResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type KVStore_TransactionServer = grpc.BidiStreamingServer[TxRequest, TxResponse]
+
+func _KVStore_ShelfNames_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ShelfNamesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KVStoreServer).ShelfNames(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KVStore_ShelfNames_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KVStoreServer).ShelfNames(ctx, req.(*ShelfNamesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// KVStore_ServiceDesc is the grpc.ServiceDesc for KVStore service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var KVStore_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "stoabs.remote.v1.KVStore",
+	HandlerType: (*KVStoreServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ShelfNames",
+			Handler:    _KVStore_ShelfNames_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Transaction",
+			Handler:       _KVStore_Transaction_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "remote.proto",
+}
@@ -0,0 +1,252 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package remote provides a gRPC server exposing a KVStore, and a client implementing KVStore on top of it.
+package remote
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/remote/remotepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// batchSize is the maximum number of entries the server sends in a single Iterate or Range response.
+const batchSize = 100
+
+// batchBytes is the maximum size of the keys and values of a single Iterate or Range response, which keeps responses well
+// below the default maximum message size of gRPC (4 MiB). An entry that's larger is sent in a batch of its own.
+const batchBytes = 1024 * 1024
+
+// errRollback makes the store roll back the transaction when the client requested it.
+var errRollback = errors.New("rollback requested")
+
+// errStopped stops a scan when the client requested it.
+var errStopped = errors.New("scan stopped")
+
+// streamError wraps errors of the stream, which abort the transaction.
+type streamError struct {
+	error
+}
+
+func (e streamError) Unwrap() error {
+	return e.error
+}
+
+type txStream = grpc.BidiStreamingServer[remotepb.TxRequest, remotepb.TxResponse]
+
+// NewServer creates a gRPC service that executes transactions on the given store. Register it using
+// remotepb.RegisterKVStoreServer. Keys are passed to the store as the type used by the client, if it's one of the key types
+// provided by stoabs. Other key types are passed as stoabs.BytesKey, see dump.Export for the implications for Redis.
+// Since the server copies the entries of Iterate and Range into the batches it sends, the store doesn't need to copy them
+// as well: consider creating it using stoabs.WithValueCloning(false).
+func NewServer(store stoabs.KVStore) *Server {
+	return &Server{store: store}
+}
+
+// Server implements remotepb.KVStoreServer. Use NewServer to create it.
+type Server struct {
+	remotepb.UnimplementedKVStoreServer
+	store stoabs.KVStore
+}
+
+func (s *Server) Transaction(stream txStream) error {
+	request, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	begin := request.GetBegin()
+	if begin == nil {
+		return status.Error(codes.InvalidArgument, "first request must be Begin")
+	}
+	ctx := stream.Context()
+	if begin.Writable {
+		var opts []stoabs.TxOption
+		if begin.WriteLock {
+			opts = append(opts, stoabs.WithWriteLock())
+		}
+		if len(begin.ShelfLocks) > 0 {
+			opts = append(opts, stoabs.WithShelfLock(begin.ShelfLocks...))
+		}
+		err = s.store.Write(ctx, func(tx stoabs.WriteTx) error {
+			return serve(stream, tx, tx)
+		}, opts...)
+	} else {
+		err = s.store.Read(ctx, func(tx stoabs.ReadTx) error {
+			return serve(stream, tx, nil)
+		})
+	}
+	var streamErr streamError
+	if errors.As(err, &streamErr) {
+		return streamErr.error
+	}
+	if errors.Is(err, errRollback) {
+		err = nil
+	}
+	// either the outcome of the Commit or Rollback, or the error that prevented the transaction from starting
+	return stream.Send(&remotepb.TxResponse{Error: toError(err)})
+}
+
+func (s *Server) ShelfNames(ctx context.Context, _ *remotepb.ShelfNamesRequest) (*remotepb.ShelfNamesResponse, error) {
+	// the client hides the reserved shelves, unless its context includes them
+	names, err := stoabs.ShelfNames(stoabs.ContextWithReservedShelves(ctx), s.store)
+	return &remotepb.ShelfNamesResponse{Names: names, Error: toError(err)}, nil
+}
+
+// serve handles the requests of a transaction, until it's committed or rolled back. writeTx is nil for read transactions.
+func serve(stream txStream, tx stoabs.ReadTx, writeTx stoabs.WriteTx) error {
+	// acknowledge Begin
+	if err := send(stream, &remotepb.TxResponse{}); err != nil {
+		return err
+	}
+	for {
+		request, err := stream.Recv()
+		if err != nil {
+			return streamError{err}
+		}
+		var response *remotepb.TxResponse
+		switch r := request.Request.(type) {
+		case *remotepb.TxRequest_Get:
+			var value []byte
+			key, err := fromKey(r.Get.KeyType, r.Get.Key)
+			if err == nil {
+				value, err = tx.GetShelfReader(r.Get.Shelf).Get(key)
+			}
+			response = &remotepb.TxResponse{Value: value, Error: toError(err)}
+		case *remotepb.TxRequest_Put:
+			response = &remotepb.TxResponse{Error: toError(write(writeTx, r.Put.Shelf, func(writer stoabs.Writer) error {
+				key, err := fromKey(r.Put.KeyType, r.Put.Key)
+				if err != nil {
+					return err
+				}
+				return writer.Put(key, r.Put.Value)
+			}))}
+		case *remotepb.TxRequest_Delete:
+			response = &remotepb.TxResponse{Error: toError(write(writeTx, r.Delete.Shelf, func(writer stoabs.Writer) error {
+				key, err := fromKey(r.Delete.KeyType, r.Delete.Key)
+				if err != nil {
+					return err
+				}
+				return writer.Delete(key)
+			}))}
+		case *remotepb.TxRequest_IsEmpty:
+			empty, err := tx.GetShelfReader(r.IsEmpty.Shelf).Empty()
+			response = &remotepb.TxResponse{Empty: empty, Error: toError(err)}
+		case *remotepb.TxRequest_Stats:
+			stats := tx.GetShelfReader(r.Stats.Shelf).Stats()
+			response = &remotepb.TxResponse{Stats: &remotepb.ShelfStats{NumEntries: uint64(stats.NumEntries), ShelfSize: uint64(stats.ShelfSize)}}
+		case *remotepb.TxRequest_Iterate:
+			reader := tx.GetShelfReader(r.Iterate.Shelf)
+			err = scan(stream, r.Iterate.Window, func(callback stoabs.CallerFn) error {
+				return reader.Iterate(callback, keyOfType(r.Iterate.KeyType))
+			})
+		case *remotepb.TxRequest_Range:
+			reader := tx.GetShelfReader(r.Range.Shelf)
+			err = scan(stream, r.Range.Window, func(callback stoabs.CallerFn) error {
+				from, err := fromKey(r.Range.KeyType, r.Range.From)
+				if err != nil {
+					return err
+				}
+				to, err := fromKey(r.Range.KeyType, r.Range.To)
+				if err != nil {
+					return err
+				}
+				return reader.Range(from, to, callback, r.Range.StopAtNil)
+			})
+		case *remotepb.TxRequest_Next, *remotepb.TxRequest_Stop:
+			// sent by the client for batches of a scan that was already done
+		case *remotepb.TxRequest_Commit:
+			return nil
+		case *remotepb.TxRequest_Rollback:
+			return errRollback
+		default:
+			return streamError{status.Errorf(codes.InvalidArgument, "unexpected request: %T", request.Request)}
+		}
+		if err != nil {
+			return err
+		}
+		if response != nil {
+			if err := send(stream, response); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func write(tx stoabs.WriteTx, shelfName string, fn func(writer stoabs.Writer) error) error {
+	if tx == nil {
+		return errors.New("can't write in a read transaction")
+	}
+	return fn(tx.GetShelfWriter(shelfName))
+}
+
+// scan sends the entries visited by fn in batches, until all entries have been sent or the client stops the scan.
+// It sends up to window batches ahead of the client, after which it waits for the client to request another batch.
+func scan(stream txStream, window uint32, fn func(callback stoabs.CallerFn) error) error {
+	credits := max(int(window), 1)
+	var batch []*remotepb.Entry
+	size := 0
+	err := fn(func(key stoabs.Key, value []byte) error {
+		// copied, since the store may only lend them to the callback (e.g. Badger)
+		batch = append(batch, &remotepb.Entry{Key: bytes.Clone(key.Bytes()), Value: bytes.Clone(value)})
+		size += len(key.Bytes()) + len(value)
+		if len(batch) < batchSize && size < batchBytes {
+			return nil
+		}
+		if err := send(stream, &remotepb.TxResponse{Entries: batch}); err != nil {
+			return err
+		}
+		batch, size = nil, 0
+		if credits--; credits > 0 {
+			return nil
+		}
+		request, err := stream.Recv()
+		if err != nil {
+			return streamError{err}
+		}
+		switch request.Request.(type) {
+		case *remotepb.TxRequest_Next:
+			credits++
+			return nil
+		case *remotepb.TxRequest_Stop:
+			return errStopped
+		default:
+			return streamError{status.Errorf(codes.InvalidArgument, "unexpected request during scan: %T", request.Request)}
+		}
+	})
+	if errors.As(err, new(streamError)) {
+		return err
+	}
+	if errors.Is(err, errStopped) {
+		err = nil
+	}
+	return send(stream, &remotepb.TxResponse{Entries: batch, Done: true, Error: toError(err)})
+}
+
+func send(stream txStream, response *remotepb.TxResponse) error {
+	if err := stream.Send(response); err != nil {
+		return streamError{fmt.Errorf("unable to send response: %w", err)}
+	}
+	return nil
+}
@@ -0,0 +1,390 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package replica asynchronously replicates the mutations recorded by a cdc.Store to a secondary KVStore.
+package replica
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/cdc"
+	"github.com/nuts-foundation/go-stoabs/migrate"
+	"github.com/sirupsen/logrus"
+)
+
+// stateShelf is the shelf in the target store holding the offset of the next journal entry to replicate.
+const stateShelf = stoabs.ReservedShelfPrefix + "replica"
+
+var offsetKey = stoabs.BytesKey("offset")
+
+const defaultBatchSize = 1000
+
+const defaultInterval = time.Second
+
+// errBatchFull stops tailing the journal when a batch is complete.
+var errBatchFull = errors.New("batch full")
+
+// Stats describes the state of the replication.
+type Stats struct {
+	// Offset is the offset of the next journal entry to replicate.
+	Offset uint64
+	// Head is the offset of the next journal entry of the source store, as seen during the last sync.
+	Head uint64
+	// Lag is the number of journal entries not yet replicated, as seen during the last sync.
+	Lag uint64
+	// LastSync is the time of the last successful sync.
+	LastSync time.Time
+	// LastError is the error of the last sync, or nil if it succeeded.
+	LastError error
+}
+
+// Option configures the Replicator.
+type Option func(r *Replicator)
+
+// WithBatchSize sets the maximum number of journal entries applied to the target store in a single transaction.
+func WithBatchSize(batchSize int) Option {
+	return func(r *Replicator) {
+		r.batchSize = batchSize
+	}
+}
+
+// WithInterval sets the time Run waits after the replica caught up, or after a failed sync.
+func WithInterval(interval time.Duration) Option {
+	return func(r *Replicator) {
+		r.interval = interval
+	}
+}
+
+// WithKeyType specifies the type of the keys of the given shelf. Keys of the types defined by stoabs are replicated
+// as the type recorded in the journal (see cdc.Entry.ParsedKey), other key types must be specified for replicating
+// from or to Redis, which stores keys in their string form. It's also used for copying the shelf when the replica is too
+// far behind, which requires the key types of all shelves to be specified for Redis (see migrate.WithKeyType).
+func WithKeyType(shelf string, keyType stoabs.Key) Option {
+	return func(r *Replicator) {
+		r.keyTypes[shelf] = keyType
+	}
+}
+
+// WithLogger overrides the default logger.
+func WithLogger(log *logrus.Logger) Option {
+	return func(r *Replicator) {
+		r.log = log
+	}
+}
+
+// New creates a Replicator that ships the mutations committed on source to target.
+// The replicated offset is stored in target (in the same transaction as the mutations), so replication resumes where it
+// left off when target was unavailable or the process was restarted. If the journal no longer contains the entries that
+// have to be replicated (see cdc.WithRetention), all shelves are copied from source to target first, after which entries
+// that don't exist in source anymore are removed from target.
+func New(source *cdc.Store, target stoabs.KVStore, opts ...Option) *Replicator {
+	result := &Replicator{
+		source:    source,
+		target:    target,
+		batchSize: defaultBatchSize,
+		interval:  defaultInterval,
+		log:       logrus.StandardLogger(),
+		keyTypes:  map[string]stoabs.Key{},
+	}
+	for _, opt := range opts {
+		opt(result)
+	}
+	return result
+}
+
+// Replicator replicates mutations from a cdc.Store to a target store. Use New to create it.
+type Replicator struct {
+	source    *cdc.Store
+	target    stoabs.KVStore
+	batchSize int
+	interval  time.Duration
+	log       *logrus.Logger
+	keyTypes  map[string]stoabs.Key
+
+	mux   sync.Mutex
+	stats Stats
+}
+
+// Run replicates until the given context is cancelled. Errors are logged and retried after the interval (see WithInterval).
+func (r *Replicator) Run(ctx context.Context) {
+	for {
+		caughtUp, err := r.sync(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			r.log.WithError(err).Warn("Replication failed, retrying")
+		}
+		if err != nil || caughtUp {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(r.interval):
+			}
+		}
+	}
+}
+
+// Sync replicates all mutations committed on the source store so far.
+func (r *Replicator) Sync(ctx context.Context) error {
+	for {
+		caughtUp, err := r.sync(ctx)
+		if err != nil || caughtUp {
+			return err
+		}
+	}
+}
+
+// Stats returns the state of the replication.
+func (r *Replicator) Stats() Stats {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.stats
+}
+
+// sync applies a single batch to the target store. It returns true if there are no more entries to replicate.
+func (r *Replicator) sync(ctx context.Context) (bool, error) {
+	offset, head, err := r.applyBatch(ctx)
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.stats.LastError = err
+	if err != nil {
+		return false, err
+	}
+	r.stats.Offset = offset
+	r.stats.Head = head
+	r.stats.Lag = 0
+	if head > offset {
+		r.stats.Lag = head - offset
+	}
+	r.stats.LastSync = time.Now()
+	return offset >= head, nil
+}
+
+func (r *Replicator) applyBatch(ctx context.Context) (uint64, uint64, error) {
+	offset, err := r.readOffset(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	if offset == 0 {
+		// nothing replicated yet, start at the first entry (which fails if it has been removed from the journal)
+		offset = 1
+	}
+	head, err := r.source.NextOffset(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to read journal offset: %w", err)
+	}
+	var entries []cdc.Entry
+	next, err := r.source.Tail(ctx, offset, func(entry cdc.Entry) error {
+		if len(entries) == r.batchSize {
+			return errBatchFull
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if errors.Is(err, cdc.ErrOffsetExpired) {
+		r.log.WithError(err).Warn("Replica is too far behind, copying all data")
+		if err := r.resync(ctx, head); err != nil {
+			return 0, 0, err
+		}
+		return head, head, nil
+	}
+	if err != nil && !errors.Is(err, errBatchFull) {
+		return 0, 0, fmt.Errorf("unable to read journal: %w", err)
+	}
+	if len(entries) == 0 {
+		return next, head, nil
+	}
+	if err := r.apply(ctx, entries, next); err != nil {
+		return 0, 0, err
+	}
+	if next > head {
+		head = next
+	}
+	return next, head, nil
+}
+
+// apply writes the current values of the keys in the given entries to the target store, together with the next offset.
+// Values are read from the source store (the journal only contains hashes), which might be newer than the entry;
+// the replica is eventually consistent since the newer mutation will be replicated as well.
+func (r *Replicator) apply(ctx context.Context, entries []cdc.Entry, next uint64) error {
+	type mutation struct {
+		shelf   string
+		key     stoabs.Key
+		value   []byte
+		deleted bool
+	}
+	mutations := make([]mutation, 0, len(entries))
+	err := r.source.Read(ctx, func(tx stoabs.ReadTx) error {
+		for _, entry := range entries {
+			key, err := r.parseKey(entry)
+			if err != nil {
+				return err
+			}
+			value, err := tx.GetShelfReader(entry.Shelf).Get(key)
+			deleted := errors.Is(err, stoabs.ErrKeyNotFound)
+			if err != nil && !deleted {
+				return err
+			}
+			mutations = append(mutations, mutation{shelf: entry.Shelf, key: key, value: value, deleted: deleted})
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to read source store: %w", err)
+	}
+	err = r.target.Write(ctx, func(tx stoabs.WriteTx) error {
+		for _, m := range mutations {
+			writer := tx.GetShelfWriter(m.shelf)
+			var err error
+			if m.deleted {
+				err = writer.Delete(m.key)
+			} else {
+				err = writer.Put(m.key, m.value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return tx.GetShelfWriter(stateShelf).Put(offsetKey, stoabs.Uint64Key(next).Bytes())
+	})
+	if err != nil {
+		return fmt.Errorf("unable to write to target store: %w", err)
+	}
+	return nil
+}
+
+// parseKey returns the key of the entry as the type specified by WithKeyType, or the type recorded in the entry.
+func (r *Replicator) parseKey(entry cdc.Entry) (stoabs.Key, error) {
+	if keyType, ok := r.keyTypes[entry.Shelf]; ok {
+		return keyType.FromBytes(entry.Key)
+	}
+	return entry.ParsedKey()
+}
+
+func (r *Replicator) keyType(shelf string) stoabs.Key {
+	if keyType, ok := r.keyTypes[shelf]; ok {
+		return keyType
+	}
+	return stoabs.BytesKey{}
+}
+
+// resync copies all shelves from the source to the target store, removes the entries from the target store that don't
+// exist in the source store anymore, and then continues replication from the given offset.
+// Mutations committed during the copy are replicated again afterwards, which is idempotent.
+func (r *Replicator) resync(ctx context.Context, offset uint64) error {
+	var opts []migrate.Option
+	for shelf, keyType := range r.keyTypes {
+		opts = append(opts, migrate.WithKeyType(shelf, keyType))
+	}
+	if err := migrate.Copy(ctx, r.source, r.target, opts...); err != nil {
+		return fmt.Errorf("unable to copy data to target store: %w", err)
+	}
+	shelves, err := stoabs.ShelfNames(ctx, r.target)
+	if err != nil {
+		return fmt.Errorf("unable to list shelves of target store: %w", err)
+	}
+	for _, shelf := range shelves {
+		if shelf == stateShelf {
+			continue
+		}
+		if err := r.removeStale(ctx, shelf); err != nil {
+			return fmt.Errorf("unable to remove stale entries from shelf %s of target store: %w", shelf, err)
+		}
+	}
+	return r.target.WriteShelf(ctx, stateShelf, func(writer stoabs.Writer) error {
+		return writer.Put(offsetKey, stoabs.Uint64Key(offset).Bytes())
+	})
+}
+
+// removeStale deletes the entries of the shelf in the target store that don't exist in the source store.
+func (r *Replicator) removeStale(ctx context.Context, shelf string) error {
+	var keys []stoabs.Key
+	err := r.target.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+		return reader.Iterate(func(key stoabs.Key, _ []byte) error {
+			keys = append(keys, key)
+			return nil
+		}, r.keyType(shelf))
+	})
+	if err != nil {
+		return err
+	}
+	// if the shelf doesn't exist in the source store, the function isn't called and all keys are stale
+	stale := keys
+	err = r.source.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+		stale = nil
+		for _, key := range keys {
+			_, err := reader.Get(key)
+			if errors.Is(err, stoabs.ErrKeyNotFound) {
+				stale = append(stale, key)
+			} else if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for start := 0; start < len(stale); start += r.batchSize {
+		end := start + r.batchSize
+		if end > len(stale) {
+			end = len(stale)
+		}
+		err := r.target.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+			for _, key := range stale[start:end] {
+				if err := writer.Delete(key); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Replicator) readOffset(ctx context.Context) (uint64, error) {
+	var result uint64
+	err := r.target.ReadShelf(ctx, stateShelf, func(reader stoabs.Reader) error {
+		data, err := reader.Get(offsetKey)
+		if errors.Is(err, stoabs.ErrKeyNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		key, err := stoabs.Uint64Key(0).FromBytes(data)
+		if err != nil {
+			return err
+		}
+		result = uint64(key.(stoabs.Uint64Key))
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("unable to read replicated offset: %w", err)
+	}
+	return result, nil
+}
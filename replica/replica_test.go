@@ -0,0 +1,205 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package replica
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/cdc"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/nuts-foundation/go-stoabs/redis7"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+const shelfName = "test"
+
+func TestReplicator_Sync(t *testing.T) {
+	t.Run("replicates puts and deletes to Redis", func(t *testing.T) {
+		source := cdc.Wrap(stores.BBolt(t))
+		mr := miniredis.RunT(t)
+		target, err := redis7.CreateRedisStore("db", &redis.Options{Addr: mr.Addr()})
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = target.Close(ctx)
+		})
+		put(t, source, stoabs.BytesKey{1}, "v1")
+		put(t, source, stoabs.BytesKey{2}, "v2")
+		require.NoError(t, source.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			return writer.Delete(stoabs.BytesKey{1})
+		}))
+		replicator := New(source, target)
+
+		err = replicator.Sync(ctx)
+
+		require.NoError(t, err)
+		assert.Nil(t, get(t, target, stoabs.BytesKey{1}))
+		assert.Equal(t, []byte("v2"), get(t, target, stoabs.BytesKey{2}))
+		stats := replicator.Stats()
+		assert.Equal(t, uint64(4), stats.Offset)
+		assert.Equal(t, uint64(0), stats.Lag)
+		assert.NoError(t, stats.LastError)
+	})
+	t.Run("in batches", func(t *testing.T) {
+		source := cdc.Wrap(stores.BBolt(t))
+		target := stores.BBolt(t)
+		for i := 0; i < 5; i++ {
+			put(t, source, stoabs.BytesKey{byte(i)}, "v")
+		}
+
+		err := New(source, target, WithBatchSize(2)).Sync(ctx)
+
+		require.NoError(t, err)
+		for i := 0; i < 5; i++ {
+			assert.Equal(t, []byte("v"), get(t, target, stoabs.BytesKey{byte(i)}))
+		}
+	})
+	t.Run("resumes from replicated offset", func(t *testing.T) {
+		source := cdc.Wrap(stores.BBolt(t))
+		target := stores.BBolt(t)
+		put(t, source, stoabs.BytesKey{1}, "v1")
+		require.NoError(t, New(source, target).Sync(ctx))
+		// remove replicated value from the target, to assert it isn't replicated again
+		require.NoError(t, target.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			return writer.Delete(stoabs.BytesKey{1})
+		}))
+		put(t, source, stoabs.BytesKey{2}, "v2")
+
+		err := New(source, target).Sync(ctx)
+
+		require.NoError(t, err)
+		assert.Nil(t, get(t, target, stoabs.BytesKey{1}))
+		assert.Equal(t, []byte("v2"), get(t, target, stoabs.BytesKey{2}))
+	})
+	t.Run("copies all data when journal entries expired", func(t *testing.T) {
+		source := cdc.Wrap(stores.BBolt(t), cdc.WithRetention(1))
+		target := stores.BBolt(t)
+		put(t, source, stoabs.BytesKey{1}, "v1")
+		put(t, source, stoabs.BytesKey{2}, "v2")
+		replicator := New(source, target)
+
+		err := replicator.Sync(ctx)
+
+		require.NoError(t, err)
+		assert.Equal(t, []byte("v1"), get(t, target, stoabs.BytesKey{1}))
+		assert.Equal(t, []byte("v2"), get(t, target, stoabs.BytesKey{2}))
+		assert.Equal(t, uint64(3), replicator.Stats().Offset)
+	})
+	t.Run("removes deleted entries when journal entries expired", func(t *testing.T) {
+		source := cdc.Wrap(stores.BBolt(t), cdc.WithRetention(1))
+		target := stores.BBolt(t)
+		put(t, source, stoabs.BytesKey{1}, "v1")
+		require.NoError(t, New(source, target).Sync(ctx))
+		require.NoError(t, source.Write(ctx, func(tx stoabs.WriteTx) error {
+			_ = tx.GetShelfWriter("other").Put(stoabs.BytesKey{3}, []byte("v3"))
+			return tx.GetShelfWriter(shelfName).Delete(stoabs.BytesKey{1})
+		}))
+		put(t, source, stoabs.BytesKey{2}, "v2")
+		// shelf that doesn't exist in the source store
+		require.NoError(t, target.WriteShelf(ctx, "ghost", func(writer stoabs.Writer) error {
+			return writer.Put(stoabs.BytesKey{4}, []byte("v4"))
+		}))
+
+		err := New(source, target).Sync(ctx)
+
+		require.NoError(t, err)
+		assert.Nil(t, get(t, target, stoabs.BytesKey{1}))
+		assert.Equal(t, []byte("v2"), get(t, target, stoabs.BytesKey{2}))
+		err = target.ReadShelf(ctx, "ghost", func(reader stoabs.Reader) error {
+			empty, err := reader.Empty()
+			assert.True(t, empty)
+			return err
+		})
+		require.NoError(t, err)
+	})
+	t.Run("replicates typed keys to and from Redis", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		redisStore, err := redis7.CreateRedisStore("db", &redis.Options{Addr: mr.Addr()})
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = redisStore.Close(ctx)
+		})
+		source := cdc.Wrap(redisStore)
+		target := stores.BBolt(t)
+		put(t, source, stoabs.Uint32Key(10), "v1")
+
+		err = New(source, target).Sync(ctx)
+
+		require.NoError(t, err)
+		assert.Equal(t, []byte("v1"), get(t, target, stoabs.Uint32Key(10)))
+	})
+	t.Run("target unavailable", func(t *testing.T) {
+		source := cdc.Wrap(stores.BBolt(t))
+		target := stores.BBolt(t)
+		_ = target.Close(ctx)
+		put(t, source, stoabs.BytesKey{1}, "v1")
+		replicator := New(source, target)
+
+		err := replicator.Sync(ctx)
+
+		assert.ErrorIs(t, err, stoabs.ErrStoreIsClosed)
+		assert.ErrorIs(t, replicator.Stats().LastError, stoabs.ErrStoreIsClosed)
+	})
+}
+
+func TestReplicator_Run(t *testing.T) {
+	source := cdc.Wrap(stores.BBolt(t))
+	target := stores.BBolt(t)
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		New(source, target, WithInterval(10*time.Millisecond)).Run(runCtx)
+		close(done)
+	}()
+
+	put(t, source, stoabs.BytesKey{1}, "v1")
+
+	assert.Eventually(t, func() bool {
+		return get(t, target, stoabs.BytesKey{1}) != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+}
+
+func put(t *testing.T, store stoabs.KVStore, key stoabs.Key, value string) {
+	require.NoError(t, store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+		return writer.Put(key, []byte(value))
+	}))
+}
+
+func get(t *testing.T, store stoabs.KVStore, key stoabs.Key) []byte {
+	var result []byte
+	err := store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+		var err error
+		result, err = reader.Get(key)
+		return err
+	})
+	if !errors.Is(err, stoabs.ErrKeyNotFound) {
+		require.NoError(t, err)
+	}
+	return result
+}
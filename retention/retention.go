@@ -0,0 +1,301 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package retention enforces declarative retention rules on shelves, e.g. deleting entries older than a maximum age.
+package retention
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultBatchSize = 1000
+
+const defaultInterval = time.Hour
+
+// errBatchFull stops iterating a shelf when a batch is complete.
+var errBatchFull = errors.New("batch full")
+
+// Rule specifies which entries of a shelf are retained. Entries are deleted in key order, so keys must be ordered by
+// creation time (e.g. prefixed with a timestamp or ULID), and the store must iterate keys in order (BBolt or Badger).
+type Rule struct {
+	// Shelf is the name of the shelf.
+	Shelf string
+	// MaxAge deletes entries older than the given age, as determined by KeyTime. Zero means no maximum age.
+	MaxAge time.Duration
+	// KeyTime returns the creation time of an entry, given its key. It's required for MaxAge, see UnixNanoPrefix and ULIDPrefix.
+	KeyTime func(key []byte) (time.Time, error)
+	// MaxEntries deletes the entries with the lowest keys when the shelf holds more than the given number of entries.
+	// Zero means no maximum.
+	MaxEntries int
+}
+
+// UnixNanoPrefix returns the time of keys prefixed with a Unix timestamp in nanoseconds (8 bytes, big endian).
+func UnixNanoPrefix(key []byte) (time.Time, error) {
+	if len(key) < 8 {
+		return time.Time{}, errors.New("key is not prefixed with a timestamp")
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(key))), nil
+}
+
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULIDPrefix returns the time of keys prefixed with a ULID in its canonical (string) form.
+func ULIDPrefix(key []byte) (time.Time, error) {
+	if len(key) < 10 {
+		return time.Time{}, errors.New("key is not prefixed with a ULID")
+	}
+	// the first 10 characters encode the timestamp in milliseconds (48 bits)
+	var millis uint64
+	for _, c := range strings.ToUpper(string(key[:10])) {
+		index := strings.IndexRune(crockfordAlphabet, c)
+		if index < 0 {
+			return time.Time{}, errors.New("key is not prefixed with a ULID")
+		}
+		millis = millis<<5 | uint64(index)
+	}
+	if millis >= 1<<48 {
+		return time.Time{}, errors.New("key is not prefixed with a ULID")
+	}
+	return time.UnixMilli(int64(millis)), nil
+}
+
+// Stats describes the enforcement of the retention rules.
+type Stats struct {
+	// Purged holds the number of deleted entries per shelf.
+	Purged map[string]uint64
+	// LastRun is the time retention was last enforced successfully.
+	LastRun time.Time
+	// LastError is the error of the last enforcement, or nil if it succeeded.
+	LastError error
+}
+
+// Option configures the Engine.
+type Option func(e *Engine)
+
+// WithRule adds a retention rule.
+func WithRule(rule Rule) Option {
+	return func(e *Engine) {
+		e.rules = append(e.rules, rule)
+	}
+}
+
+// WithBatchSize sets the maximum number of entries deleted in a single transaction, which keeps transactions small.
+func WithBatchSize(batchSize int) Option {
+	return func(e *Engine) {
+		e.batchSize = batchSize
+	}
+}
+
+// WithInterval sets the time Run waits between enforcing the rules.
+func WithInterval(interval time.Duration) Option {
+	return func(e *Engine) {
+		e.interval = interval
+	}
+}
+
+// WithClock overrides the clock that determines the age of entries and when Run enforces the rules,
+// which defaults to stoabs.SystemClock.
+func WithClock(clock stoabs.Clock) Option {
+	return func(e *Engine) {
+		e.now = clock.Now
+		e.after = clock.After
+	}
+}
+
+// WithLogger overrides the default logger.
+func WithLogger(log *logrus.Logger) Option {
+	return func(e *Engine) {
+		e.log = log
+	}
+}
+
+// New creates an Engine that enforces the given retention rules (see WithRule) on the given store.
+func New(store stoabs.KVStore, opts ...Option) *Engine {
+	result := &Engine{
+		store:     store,
+		batchSize: defaultBatchSize,
+		interval:  defaultInterval,
+		log:       logrus.StandardLogger(),
+		now:       time.Now,
+		after:     time.After,
+		stats:     Stats{Purged: map[string]uint64{}},
+	}
+	for _, opt := range opts {
+		opt(result)
+	}
+	return result
+}
+
+// Engine enforces retention rules. Use New to create it.
+type Engine struct {
+	store     stoabs.KVStore
+	rules     []Rule
+	batchSize int
+	interval  time.Duration
+	log       *logrus.Logger
+	now       func() time.Time
+	after     func(d time.Duration) <-chan time.Time
+
+	mux   sync.Mutex
+	stats Stats
+}
+
+// Run enforces the rules until the given context is cancelled. Errors are logged and retried after the interval (see WithInterval).
+func (e *Engine) Run(ctx context.Context) {
+	for {
+		_, err := e.Enforce(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			e.log.WithError(err).Warn("Enforcing retention failed, retrying")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.after(e.interval):
+		}
+	}
+}
+
+// Enforce deletes the entries that aren't retained by the rules, in transactions of at most the batch size (see WithBatchSize).
+// It returns the number of deleted entries per shelf.
+func (e *Engine) Enforce(ctx context.Context) (map[string]int, error) {
+	result := map[string]int{}
+	var err error
+	for _, rule := range e.rules {
+		var count int
+		count, err = e.enforce(ctx, rule)
+		if count > 0 {
+			result[rule.Shelf] += count
+		}
+		if err != nil {
+			err = fmt.Errorf("enforcing retention of shelf %s: %w", rule.Shelf, err)
+			break
+		}
+	}
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	for shelf, count := range result {
+		e.stats.Purged[shelf] += uint64(count)
+	}
+	e.stats.LastError = err
+	if err == nil {
+		e.stats.LastRun = e.now()
+	}
+	return result, err
+}
+
+// Stats returns the number of deleted entries and the result of the last enforcement.
+func (e *Engine) Stats() Stats {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	result := e.stats
+	result.Purged = make(map[string]uint64, len(e.stats.Purged))
+	for shelf, count := range e.stats.Purged {
+		result.Purged[shelf] = count
+	}
+	return result
+}
+
+func (e *Engine) enforce(ctx context.Context, rule Rule) (int, error) {
+	if rule.MaxAge > 0 && rule.KeyTime == nil {
+		return 0, errors.New("rule with maximum age requires KeyTime")
+	}
+	excess := 0
+	if rule.MaxEntries > 0 {
+		count, err := e.count(ctx, rule.Shelf)
+		if err != nil {
+			return 0, err
+		}
+		excess = count - rule.MaxEntries
+	}
+	cutoff := e.now().Add(-rule.MaxAge)
+	total := 0
+	for {
+		count, err := e.deleteBatch(ctx, rule, cutoff, &excess)
+		total += count
+		if err != nil || count < e.batchSize {
+			return total, err
+		}
+	}
+}
+
+// deleteBatch deletes at most a batch of entries with the lowest keys, as long as they're in excess or too old.
+func (e *Engine) deleteBatch(ctx context.Context, rule Rule, cutoff time.Time, excess *int) (int, error) {
+	var deleted int
+	err := e.store.WriteShelf(ctx, rule.Shelf, func(writer stoabs.Writer) error {
+		remaining := *excess
+		var keys []stoabs.Key
+		err := writer.Iterate(func(key stoabs.Key, _ []byte) error {
+			if remaining <= 0 {
+				if rule.MaxAge == 0 {
+					return errBatchFull
+				}
+				created, err := rule.KeyTime(key.Bytes())
+				if err != nil {
+					return fmt.Errorf("key %s: %w", key, err)
+				}
+				if !created.Before(cutoff) {
+					return errBatchFull
+				}
+			}
+			keys = append(keys, stoabs.BytesKey(append([]byte{}, key.Bytes()...)))
+			remaining--
+			if len(keys) == e.batchSize {
+				return errBatchFull
+			}
+			return nil
+		}, stoabs.BytesKey{})
+		if err != nil && !errors.Is(err, errBatchFull) {
+			return err
+		}
+		for _, key := range keys {
+			if err := writer.Delete(key); err != nil {
+				return err
+			}
+		}
+		deleted = len(keys)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	*excess -= deleted
+	return deleted, nil
+}
+
+func (e *Engine) count(ctx context.Context, shelfName string) (int, error) {
+	count := 0
+	err := e.store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+		return reader.Iterate(func(_ stoabs.Key, _ []byte) error {
+			count++
+			return nil
+		}, stoabs.BytesKey{})
+	})
+	return count, err
+}
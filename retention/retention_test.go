@@ -0,0 +1,205 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package retention
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/nuts-foundation/go-stoabs/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+const shelf = "events"
+
+func TestEngine_Enforce(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	setup := func(t *testing.T, opts ...Option) (*Engine, stoabs.KVStore) {
+		store := stores.BBolt(t)
+		// one entry per minute during the last 100 minutes
+		require.NoError(t, store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+			for i := 1; i <= 100; i++ {
+				key := binary.BigEndian.AppendUint64(nil, uint64(now.Add(-time.Duration(i)*time.Minute).UnixNano()))
+				if err := writer.Put(stoabs.BytesKey(key), []byte("event")); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+		engine := New(store, opts...)
+		engine.now = func() time.Time {
+			return now
+		}
+		return engine, store
+	}
+
+	t.Run("max age", func(t *testing.T) {
+		engine, store := setup(t, WithRule(Rule{Shelf: shelf, MaxAge: 30*time.Minute + time.Second, KeyTime: UnixNanoPrefix}), WithBatchSize(7))
+
+		purged, err := engine.Enforce(ctx)
+
+		require.NoError(t, err)
+		assert.Equal(t, map[string]int{shelf: 70}, purged)
+		assert.Equal(t, 30, count(t, store))
+		oldest := oldestKey(t, store)
+		assert.Equal(t, now.Add(-30*time.Minute).UnixNano(), int64(binary.BigEndian.Uint64(oldest)))
+	})
+	t.Run("max age with clock", func(t *testing.T) {
+		engine, store := setup(t, WithRule(Rule{Shelf: shelf, MaxAge: 30*time.Minute + time.Second, KeyTime: UnixNanoPrefix}))
+		clock := mocks.NewClock(now)
+		WithClock(clock)(engine)
+		_, err := engine.Enforce(ctx)
+		require.NoError(t, err)
+
+		clock.Advance(10 * time.Minute)
+		purged, err := engine.Enforce(ctx)
+
+		require.NoError(t, err)
+		assert.Equal(t, map[string]int{shelf: 10}, purged)
+		assert.Equal(t, 20, count(t, store))
+		assert.Equal(t, clock.Now(), engine.Stats().LastRun)
+	})
+	t.Run("max entries", func(t *testing.T) {
+		engine, store := setup(t, WithRule(Rule{Shelf: shelf, MaxEntries: 25}), WithBatchSize(10))
+
+		purged, err := engine.Enforce(ctx)
+
+		require.NoError(t, err)
+		assert.Equal(t, map[string]int{shelf: 75}, purged)
+		assert.Equal(t, 25, count(t, store))
+		oldest := oldestKey(t, store)
+		assert.Equal(t, now.Add(-25*time.Minute).UnixNano(), int64(binary.BigEndian.Uint64(oldest)))
+	})
+	t.Run("max age and max entries", func(t *testing.T) {
+		engine, store := setup(t, WithRule(Rule{Shelf: shelf, MaxAge: 50*time.Minute + time.Second, KeyTime: UnixNanoPrefix, MaxEntries: 60}))
+
+		_, err := engine.Enforce(ctx)
+
+		require.NoError(t, err)
+		assert.Equal(t, 50, count(t, store))
+	})
+	t.Run("nothing to purge", func(t *testing.T) {
+		engine, _ := setup(t, WithRule(Rule{Shelf: shelf, MaxAge: 24 * time.Hour, KeyTime: UnixNanoPrefix, MaxEntries: 1000}))
+
+		purged, err := engine.Enforce(ctx)
+
+		require.NoError(t, err)
+		assert.Empty(t, purged)
+	})
+	t.Run("stats", func(t *testing.T) {
+		engine, _ := setup(t, WithRule(Rule{Shelf: shelf, MaxEntries: 90}))
+
+		_, err := engine.Enforce(ctx)
+		require.NoError(t, err)
+		engine.rules[0].MaxEntries = 80
+		_, err = engine.Enforce(ctx)
+		require.NoError(t, err)
+
+		stats := engine.Stats()
+		assert.Equal(t, map[string]uint64{shelf: 20}, stats.Purged)
+		assert.Equal(t, now, stats.LastRun)
+		assert.NoError(t, stats.LastError)
+	})
+	t.Run("max age without KeyTime", func(t *testing.T) {
+		engine, _ := setup(t, WithRule(Rule{Shelf: shelf, MaxAge: time.Minute}))
+
+		_, err := engine.Enforce(ctx)
+
+		assert.EqualError(t, err, "enforcing retention of shelf events: rule with maximum age requires KeyTime")
+		assert.Error(t, engine.Stats().LastError)
+	})
+	t.Run("invalid key", func(t *testing.T) {
+		engine, _ := setup(t, WithRule(Rule{Shelf: shelf, MaxAge: time.Minute, KeyTime: ULIDPrefix}))
+
+		_, err := engine.Enforce(ctx)
+
+		assert.ErrorContains(t, err, "key is not prefixed with a ULID")
+	})
+	t.Run("Run enforces until cancelled", func(t *testing.T) {
+		engine, store := setup(t, WithRule(Rule{Shelf: shelf, MaxEntries: 10}), WithInterval(time.Millisecond))
+		ctx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			engine.Run(ctx)
+			close(done)
+		}()
+
+		require.Eventually(t, func() bool {
+			return engine.Stats().Purged[shelf] == 90
+		}, 5*time.Second, time.Millisecond)
+		cancel()
+		<-done
+		assert.Equal(t, 10, count(t, store))
+	})
+}
+
+func TestULIDPrefix(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		created, err := ULIDPrefix([]byte("01ARZ3NDEKTSV4RRFFQ69G5FAV"))
+
+		require.NoError(t, err)
+		assert.Equal(t, int64(1469922850259), created.UnixMilli())
+	})
+	t.Run("invalid character", func(t *testing.T) {
+		_, err := ULIDPrefix([]byte("01ARZ3NDEUTSV4RRFFQ69G5FAV"))
+
+		assert.EqualError(t, err, "key is not prefixed with a ULID")
+	})
+	t.Run("too short", func(t *testing.T) {
+		_, err := ULIDPrefix([]byte("01ARZ"))
+
+		assert.EqualError(t, err, "key is not prefixed with a ULID")
+	})
+	t.Run("overflow", func(t *testing.T) {
+		_, err := ULIDPrefix([]byte("8ZZZZZZZZZTSV4RRFFQ69G5FAV"))
+
+		assert.EqualError(t, err, "key is not prefixed with a ULID")
+	})
+}
+
+func count(t *testing.T, store stoabs.KVStore) int {
+	result := 0
+	require.NoError(t, store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+		return reader.Iterate(func(_ stoabs.Key, _ []byte) error {
+			result++
+			return nil
+		}, stoabs.BytesKey{})
+	}))
+	return result
+}
+
+func oldestKey(t *testing.T, store stoabs.KVStore) []byte {
+	var result []byte
+	require.NoError(t, store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+		return reader.Iterate(func(key stoabs.Key, _ []byte) error {
+			if result == nil {
+				result = append([]byte{}, key.Bytes()...)
+			}
+			return nil
+		}, stoabs.BytesKey{})
+	}))
+	return result
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+)
+
+// Sampler is implemented by Readers that sample entries natively (e.g. without visiting all entries), see Sample.
+type Sampler interface {
+	// Sample returns n approximately uniformly chosen random entries of the shelf in no particular order,
+	// or all entries if the shelf contains fewer than n entries.
+	// The caller will have to supply the correct key type, such that the keys can be parsed.
+	Sample(n int, keyType Key) ([]KeyValue, error)
+}
+
+// Sample returns n approximately uniformly chosen random entries of the shelf in no particular order,
+// or all entries if the shelf contains fewer than n entries.
+// If the reader does not implement Sampler, it falls back to reservoir sampling while iterating over the shelf using Reader.Iterate.
+// The values of the returned entries are copies, so they remain valid after the transaction.
+func Sample(reader Reader, n int, keyType Key) ([]KeyValue, error) {
+	if n < 0 {
+		return nil, fmt.Errorf("invalid sample size: %d", n)
+	}
+	if sampler, ok := reader.(Sampler); ok {
+		return sampler.Sample(n, keyType)
+	}
+	return SampleIterate(reader, n, keyType)
+}
+
+// SampleIterate samples n entries of the shelf with reservoir sampling while iterating over all entries using Reader.Iterate.
+// Sampler implementations can use it as fallback.
+func SampleIterate(reader Reader, n int, keyType Key) ([]KeyValue, error) {
+	reservoir := NewReservoir[KeyValue](n)
+	err := reader.Iterate(func(key Key, value []byte) error {
+		reservoir.AddFunc(func() KeyValue {
+			// the value might only be valid during the callback (see WithValueCloning), copy it
+			return KeyValue{Key: key, Value: bytes.Clone(value)}
+		})
+		return nil
+	}, keyType)
+	if err != nil {
+		return nil, err
+	}
+	return reservoir.Items(), nil
+}
+
+// Reservoir selects n uniformly chosen random items from a stream of items of unknown length (reservoir sampling),
+// keeping only the selected items in memory.
+type Reservoir[T any] struct {
+	size  int
+	seen  int
+	items []T
+}
+
+// NewReservoir creates a Reservoir that selects n items.
+func NewReservoir[T any](n int) *Reservoir[T] {
+	n = max(n, 0)
+	return &Reservoir[T]{size: n, items: make([]T, 0, n)}
+}
+
+// Add offers the item to the reservoir, which keeps it with probability n/(number of items added).
+func (r *Reservoir[T]) Add(item T) {
+	r.AddFunc(func() T {
+		return item
+	})
+}
+
+// AddFunc is like Add, but only calls fn to create the item if it's kept (e.g. to only copy the values that are kept).
+func (r *Reservoir[T]) AddFunc(fn func() T) {
+	r.seen++
+	if len(r.items) < r.size {
+		r.items = append(r.items, fn())
+		return
+	}
+	if i := rand.IntN(r.seen); i < r.size {
+		r.items[i] = fn()
+	}
+}
+
+// Items returns the selected items in random order.
+func (r *Reservoir[T]) Items() []T {
+	rand.Shuffle(len(r.items), func(i, j int) {
+		r.items[i], r.items[j] = r.items[j], r.items[i]
+	})
+	return r.items
+}
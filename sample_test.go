@@ -0,0 +1,133 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"testing"
+)
+
+func TestSample(t *testing.T) {
+	value := []byte("value")
+	iterate := func(callback CallerFn, _ Key) error {
+		for i := uint32(0); i < 10; i++ {
+			if err := callback(Uint32Key(i), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	t.Run("falls back to reservoir sampling", func(t *testing.T) {
+		reader := NewMockReader(gomock.NewController(t))
+		reader.EXPECT().Iterate(gomock.Any(), Uint32Key(0)).DoAndReturn(iterate)
+
+		actual, err := Sample(reader, 3, Uint32Key(0))
+
+		require.NoError(t, err)
+		assert.Len(t, actual, 3)
+	})
+	t.Run("values are copied", func(t *testing.T) {
+		reader := NewMockReader(gomock.NewController(t))
+		reader.EXPECT().Iterate(gomock.Any(), Uint32Key(0)).DoAndReturn(iterate)
+
+		actual, err := Sample(reader, 1, Uint32Key(0))
+
+		require.NoError(t, err)
+		actual[0].Value[0] = 'V'
+		assert.Equal(t, []byte("value"), value)
+	})
+	t.Run("error", func(t *testing.T) {
+		reader := NewMockReader(gomock.NewController(t))
+		reader.EXPECT().Iterate(gomock.Any(), Uint32Key(0)).Return(errors.New("failed"))
+
+		_, err := Sample(reader, 3, Uint32Key(0))
+
+		assert.EqualError(t, err, "failed")
+	})
+	t.Run("invalid sample size", func(t *testing.T) {
+		reader := NewMockReader(gomock.NewController(t))
+
+		_, err := Sample(reader, -1, Uint32Key(0))
+
+		assert.EqualError(t, err, "invalid sample size: -1")
+	})
+}
+
+func TestReservoir(t *testing.T) {
+	t.Run("fewer items than the reservoir size", func(t *testing.T) {
+		reservoir := NewReservoir[int](5)
+		reservoir.Add(1)
+		reservoir.Add(2)
+
+		assert.ElementsMatch(t, []int{1, 2}, reservoir.Items())
+	})
+	t.Run("distinct items", func(t *testing.T) {
+		reservoir := NewReservoir[int](10)
+		for i := 0; i < 1000; i++ {
+			reservoir.Add(i)
+		}
+
+		items := reservoir.Items()
+
+		require.Len(t, items, 10)
+		seen := map[int]bool{}
+		for _, item := range items {
+			assert.False(t, seen[item])
+			seen[item] = true
+		}
+	})
+	t.Run("approximately uniform", func(t *testing.T) {
+		counts := make([]int, 10)
+		for i := 0; i < 10000; i++ {
+			reservoir := NewReservoir[int](1)
+			for j := range counts {
+				reservoir.Add(j)
+			}
+			counts[reservoir.Items()[0]]++
+		}
+
+		for _, count := range counts {
+			// expected is 1000, with a standard deviation of 30
+			assert.InDelta(t, 1000, count, 200)
+		}
+	})
+	t.Run("AddFunc only creates kept items", func(t *testing.T) {
+		reservoir := NewReservoir[int](1)
+		created := 0
+		for i := 0; i < 1000; i++ {
+			reservoir.AddFunc(func() int {
+				created++
+				return i
+			})
+		}
+
+		// the n-th item is kept with probability 1/n, so about ln(1000) = 7 items are created
+		assert.Less(t, created, 100)
+	})
+	t.Run("negative size", func(t *testing.T) {
+		reservoir := NewReservoir[int](-1)
+		reservoir.Add(1)
+
+		assert.Empty(t, reservoir.Items())
+	})
+}
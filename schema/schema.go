@@ -0,0 +1,168 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package schema applies versioned migrations to the shelves of a KVStore, keeping track of the applied migrations in the store.
+package schema
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/sirupsen/logrus"
+)
+
+// versionShelf holds the schema version of every migrated shelf, keyed by the name of the shelf.
+const versionShelf = stoabs.ReservedShelfPrefix + "schema"
+
+// ErrNewerVersion is returned when a shelf has been migrated to a version the registry doesn't know,
+// e.g. when running an older version of the application against upgraded data.
+var ErrNewerVersion = errors.New("shelf has a newer schema version than the registered migrations")
+
+// Migration changes the data of a shelf from one version to the next. It's called in a write transaction,
+// which also records the new version of the shelf, so a migration is either applied completely or not at all.
+type Migration func(tx stoabs.WriteTx) error
+
+// Option configures the Registry.
+type Option func(r *Registry)
+
+// WithLogger overrides the default logger.
+func WithLogger(log *logrus.Logger) Option {
+	return func(r *Registry) {
+		r.log = log
+	}
+}
+
+// New creates an empty Registry.
+func New(opts ...Option) *Registry {
+	result := &Registry{
+		migrations: map[string][]Migration{},
+		log:        logrus.StandardLogger(),
+	}
+	for _, opt := range opts {
+		opt(result)
+	}
+	return result
+}
+
+// Registry holds the migrations of shelves. Use New to create it.
+type Registry struct {
+	// shelves holds the shelves in order of registration, which is the order in which they're migrated.
+	shelves    []string
+	migrations map[string][]Migration
+	log        *logrus.Logger
+}
+
+// Register adds migrations for the given shelf. Migrations are numbered in order of registration, starting at 1:
+// a shelf at version n has had its first n migrations applied. Since the version is stored, migrations must never be
+// removed or reordered; new migrations are appended.
+func (r *Registry) Register(shelfName string, migrations ...Migration) *Registry {
+	if _, ok := r.migrations[shelfName]; !ok {
+		r.shelves = append(r.shelves, shelfName)
+	}
+	r.migrations[shelfName] = append(r.migrations[shelfName], migrations...)
+	return r
+}
+
+// Migrate applies the migrations that haven't been applied to the shelves of the given store yet, in order of registration.
+// Every migration is applied in its own write transaction using stoabs.WithWriteLock, which also records the new version,
+// so multiple processes can migrate the same store: a migration applied by another process in the meantime is skipped.
+// It stops at the first failing migration, keeping the migrations applied before it.
+// It returns ErrNewerVersion if a shelf has a version without registered migration.
+func (r *Registry) Migrate(ctx context.Context, store stoabs.KVStore) error {
+	for _, shelfName := range r.shelves {
+		migrations := r.migrations[shelfName]
+		version, err := Version(ctx, store, shelfName)
+		if err != nil {
+			return err
+		}
+		if version > len(migrations) {
+			return fmt.Errorf("%w (shelf=%s, version=%d, latest=%d)", ErrNewerVersion, shelfName, version, len(migrations))
+		}
+		for next := version + 1; next <= len(migrations); next++ {
+			if err := r.apply(ctx, store, shelfName, next); err != nil {
+				return fmt.Errorf("migrating shelf %s to version %d failed: %w", shelfName, next, err)
+			}
+		}
+	}
+	return nil
+}
+
+// apply applies the migration to the given version, unless another process did so.
+func (r *Registry) apply(ctx context.Context, store stoabs.KVStore, shelfName string, version int) error {
+	applied := false
+	err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+		writer := tx.GetShelfWriter(versionShelf)
+		current, err := readVersion(writer, shelfName)
+		if err != nil {
+			return err
+		}
+		if current >= version {
+			return nil
+		}
+		if err := r.migrations[shelfName][version-1](tx); err != nil {
+			return err
+		}
+		applied = true
+		return writer.Put(stoabs.BytesKey(shelfName), binary.BigEndian.AppendUint64(nil, uint64(version)))
+	}, stoabs.WithWriteLock())
+	if err == nil && applied {
+		r.log.Infof("Migrated shelf %s to version %d", shelfName, version)
+	}
+	return err
+}
+
+// Open opens a store using the given function and migrates it. If migrating fails, the store is closed.
+func (r *Registry) Open(ctx context.Context, open func() (stoabs.KVStore, error)) (stoabs.KVStore, error) {
+	store, err := open()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.Migrate(ctx, store); err != nil {
+		_ = store.Close(ctx)
+		return nil, err
+	}
+	return store, nil
+}
+
+// Version returns the schema version of the given shelf, which is 0 if no migrations were applied to it.
+func Version(ctx context.Context, store stoabs.KVStore, shelfName string) (int, error) {
+	var result int
+	err := store.ReadShelf(ctx, versionShelf, func(reader stoabs.Reader) error {
+		var err error
+		result, err = readVersion(reader, shelfName)
+		return err
+	})
+	return result, err
+}
+
+func readVersion(reader stoabs.Reader, shelfName string) (int, error) {
+	value, err := reader.Get(stoabs.BytesKey(shelfName))
+	if errors.Is(err, stoabs.ErrKeyNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(value) != 8 {
+		return 0, fmt.Errorf("invalid schema version of shelf %s", shelfName)
+	}
+	return int(binary.BigEndian.Uint64(value)), nil
+}
@@ -0,0 +1,159 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package schema
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/nuts-foundation/go-stoabs/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+const shelf = "users"
+
+func TestRegistry_Migrate(t *testing.T) {
+	// put returns a migration that puts the given key, recording it was called
+	put := func(calls *[]string, key string) Migration {
+		return func(tx stoabs.WriteTx) error {
+			*calls = append(*calls, key)
+			return tx.GetShelfWriter(shelf).Put(stoabs.BytesKey(key), []byte(key))
+		}
+	}
+
+	t.Run("applies migrations in order", func(t *testing.T) {
+		store := stores.BBolt(t)
+		var calls []string
+		registry := New().Register(shelf, put(&calls, "1"), put(&calls, "2")).Register("other", put(&calls, "other"))
+
+		require.NoError(t, registry.Migrate(ctx, store))
+
+		assert.Equal(t, []string{"1", "2", "other"}, calls)
+		assertVersion(t, store, shelf, 2)
+		assertVersion(t, store, "other", 1)
+	})
+	t.Run("applies new migrations only", func(t *testing.T) {
+		store := stores.BBolt(t)
+		var calls []string
+		require.NoError(t, New().Register(shelf, put(&calls, "1")).Migrate(ctx, store))
+
+		require.NoError(t, New().Register(shelf, put(&calls, "1"), put(&calls, "2")).Migrate(ctx, store))
+		require.NoError(t, New().Register(shelf, put(&calls, "1"), put(&calls, "2")).Migrate(ctx, store))
+
+		assert.Equal(t, []string{"1", "2"}, calls)
+		assertVersion(t, store, shelf, 2)
+	})
+	t.Run("failing migration is rolled back", func(t *testing.T) {
+		store := stores.BBolt(t)
+		var calls []string
+		registry := New().Register(shelf, put(&calls, "1"), func(tx stoabs.WriteTx) error {
+			_ = tx.GetShelfWriter(shelf).Put(stoabs.BytesKey("2"), []byte("2"))
+			return errors.New("failed")
+		}, put(&calls, "3"))
+
+		err := registry.Migrate(ctx, store)
+
+		assert.EqualError(t, err, "migrating shelf users to version 2 failed: failed")
+		assertVersion(t, store, shelf, 1)
+		assert.Equal(t, []string{"1"}, calls)
+		err = store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+			_, err := reader.Get(stoabs.BytesKey("2"))
+			return err
+		})
+		assert.ErrorIs(t, err, stoabs.ErrKeyNotFound)
+	})
+	t.Run("newer version", func(t *testing.T) {
+		store := stores.BBolt(t)
+		var calls []string
+		require.NoError(t, New().Register(shelf, put(&calls, "1"), put(&calls, "2")).Migrate(ctx, store))
+
+		err := New().Register(shelf, put(&calls, "1")).Migrate(ctx, store)
+
+		assert.ErrorIs(t, err, ErrNewerVersion)
+	})
+	t.Run("concurrent migrations apply every migration once", func(t *testing.T) {
+		store := mocks.NewFake()
+		var mux sync.Mutex
+		calls := 0
+		registry := New().Register(shelf, func(tx stoabs.WriteTx) error {
+			mux.Lock()
+			defer mux.Unlock()
+			calls++
+			return nil
+		})
+		wg := sync.WaitGroup{}
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, registry.Migrate(ctx, store))
+			}()
+		}
+		wg.Wait()
+
+		assert.Equal(t, 1, calls)
+	})
+}
+
+func TestRegistry_Open(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+
+		store, err := New().Register(shelf, func(tx stoabs.WriteTx) error {
+			return nil
+		}).Open(ctx, func() (stoabs.KVStore, error) {
+			return underlying, nil
+		})
+
+		require.NoError(t, err)
+		assertVersion(t, store, shelf, 1)
+	})
+	t.Run("migration fails", func(t *testing.T) {
+		underlying := mocks.NewFake()
+
+		_, err := New().Register(shelf, func(tx stoabs.WriteTx) error {
+			return errors.New("failed")
+		}).Open(ctx, func() (stoabs.KVStore, error) {
+			return underlying, nil
+		})
+
+		assert.Error(t, err)
+		assert.Equal(t, stoabs.StateClosed, underlying.State())
+	})
+	t.Run("open fails", func(t *testing.T) {
+		_, err := New().Open(ctx, func() (stoabs.KVStore, error) {
+			return nil, errors.New("failed")
+		})
+
+		assert.EqualError(t, err, "failed")
+	})
+}
+
+func assertVersion(t *testing.T, store stoabs.KVStore, shelfName string, expected int) {
+	version, err := Version(ctx, store, shelfName)
+	require.NoError(t, err)
+	assert.Equal(t, expected, version)
+}
@@ -0,0 +1,247 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package scored provides sorted-set style shelves, in which members are ordered by a score (e.g. for rankings or priorities).
+package scored
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/nuts-foundation/go-stoabs"
+)
+
+// orderShelfPrefix is the prefix of the shelves ordering the members of a scored shelf by descending score,
+// followed by the name of the scored shelf. Keys consist of the negated score, encoded so it sorts bytewise (8 bytes),
+// followed by the member. The scored shelf itself maps members to their score (8 bytes, IEEE 754, big endian).
+const orderShelfPrefix = stoabs.ReservedShelfPrefix + "scored/"
+
+// ErrNotFound is returned when the member isn't in the shelf.
+var ErrNotFound = errors.New("member not found")
+
+// Member is a member of a scored shelf.
+type Member struct {
+	Member string
+	Score  float64
+}
+
+// Shelf is a set of members ordered by score.
+type Shelf interface {
+	// Add adds the member with the given score, or updates its score if it's already present.
+	Add(ctx context.Context, member string, score float64) error
+	// Remove removes the member. It does nothing if the member isn't present.
+	Remove(ctx context.Context, member string) error
+	// Score returns the score of the member, or ErrNotFound if it isn't present.
+	Score(ctx context.Context, member string) (float64, error)
+	// RangeByScore calls fn for every member with a score between min and max (both inclusive), ordered by ascending score.
+	RangeByScore(ctx context.Context, min, max float64, fn func(Member) error) error
+	// Top returns the n members with the highest scores, highest first.
+	Top(ctx context.Context, n int) ([]Member, error)
+}
+
+// Provider is implemented by stores that support scored shelves natively (e.g. Redis sorted sets).
+type Provider interface {
+	// ScoredShelf returns the scored shelf with the given name.
+	ScoredShelf(name string) Shelf
+}
+
+// Open returns the scored shelf with the given name. If the store implements Provider, its native implementation is used.
+// Otherwise, the members are stored in the shelf with the given name, and ordered by score in a second shelf.
+// That requires a backend with ordered keys (e.g. BBolt or Badger), since they're queried using stoabs.Reader.Range.
+func Open(store stoabs.KVStore, name string) Shelf {
+	if provider, ok := store.(Provider); ok {
+		return provider.ScoredShelf(name)
+	}
+	return &shelf{store: store, name: name, orderShelf: orderShelfPrefix + name}
+}
+
+var errStop = errors.New("stop")
+
+type shelf struct {
+	store      stoabs.KVStore
+	name       string
+	orderShelf string
+}
+
+func (s *shelf) Add(ctx context.Context, member string, score float64) error {
+	if math.IsNaN(score) {
+		return errors.New("score is NaN")
+	}
+	return s.write(ctx, func(members stoabs.Writer, order stoabs.Writer) error {
+		if err := s.remove(members, order, member); err != nil {
+			return err
+		}
+		if err := members.Put(stoabs.BytesKey(member), binary.BigEndian.AppendUint64(nil, math.Float64bits(score))); err != nil {
+			return err
+		}
+		return order.Put(orderKey(score, member), []byte{})
+	})
+}
+
+func (s *shelf) Remove(ctx context.Context, member string) error {
+	return s.write(ctx, func(members stoabs.Writer, order stoabs.Writer) error {
+		return s.remove(members, order, member)
+	})
+}
+
+func (s *shelf) Score(ctx context.Context, member string) (float64, error) {
+	var result float64
+	var err error
+	found := false
+	readErr := s.store.ReadShelf(ctx, s.name, func(reader stoabs.Reader) error {
+		result, err = score(reader, member)
+		found = true
+		return nil
+	})
+	if readErr != nil {
+		return 0, readErr
+	}
+	if !found {
+		return 0, ErrNotFound
+	}
+	return result, err
+}
+
+func (s *shelf) RangeByScore(ctx context.Context, min, max float64, fn func(Member) error) error {
+	if math.IsNaN(min) || math.IsNaN(max) || min > max {
+		return nil
+	}
+	// members are ordered by descending score, collect them to visit them in ascending order
+	var result []Member
+	err := s.store.ReadShelf(ctx, s.orderShelf, func(reader stoabs.Reader) error {
+		return reader.Range(stoabs.BytesKey(encodeScore(-max)), stoabs.BytesKey(binary.BigEndian.AppendUint64(nil, orderedBits(-min)+1)), func(key stoabs.Key, _ []byte) error {
+			member, err := decodeOrderKey(key.Bytes())
+			if err != nil {
+				return err
+			}
+			result = append(result, member)
+			return nil
+		}, false)
+	})
+	if err != nil {
+		return err
+	}
+	for i := len(result) - 1; i >= 0; i-- {
+		if err := fn(result[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *shelf) Top(ctx context.Context, n int) ([]Member, error) {
+	var result []Member
+	if n <= 0 {
+		return result, nil
+	}
+	err := s.store.ReadShelf(ctx, s.orderShelf, func(reader stoabs.Reader) error {
+		err := reader.Range(stoabs.BytesKey{}, stoabs.BytesKey{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, func(key stoabs.Key, _ []byte) error {
+			member, err := decodeOrderKey(key.Bytes())
+			if err != nil {
+				return err
+			}
+			result = append(result, member)
+			if len(result) == n {
+				return errStop
+			}
+			return nil
+		}, false)
+		if errors.Is(err, errStop) {
+			return nil
+		}
+		return err
+	})
+	return result, err
+}
+
+func (s *shelf) write(ctx context.Context, fn func(members stoabs.Writer, order stoabs.Writer) error) error {
+	return s.store.Write(ctx, func(tx stoabs.WriteTx) error {
+		return fn(tx.GetShelfWriter(s.name), tx.GetShelfWriter(s.orderShelf))
+	}, stoabs.WithShelfLock(s.name))
+}
+
+// remove removes the member from both shelves, if present.
+func (s *shelf) remove(members stoabs.Writer, order stoabs.Writer, member string) error {
+	current, err := score(members, member)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := order.Delete(orderKey(current, member)); err != nil {
+		return err
+	}
+	return members.Delete(stoabs.BytesKey(member))
+}
+
+func score(reader stoabs.Reader, member string) (float64, error) {
+	data, err := reader.Get(stoabs.BytesKey(member))
+	if errors.Is(err, stoabs.ErrKeyNotFound) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(data) != 8 {
+		return 0, fmt.Errorf("invalid score of member %s", member)
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(data)), nil
+}
+
+// orderKey returns the key of the member in the order shelf: members with higher scores sort first.
+func orderKey(score float64, member string) stoabs.BytesKey {
+	return append(encodeScore(-score), member...)
+}
+
+func decodeOrderKey(key []byte) (Member, error) {
+	if len(key) < 8 {
+		return Member{}, errors.New("invalid scored shelf entry")
+	}
+	return Member{Member: string(key[8:]), Score: -decodeScore(key[:8])}, nil
+}
+
+// orderedBits returns the bits of the float, transformed so they sort in the same order as the floats.
+func orderedBits(f float64) uint64 {
+	bits := math.Float64bits(f)
+	if bits&(1<<63) != 0 {
+		// negative: flip all bits
+		return ^bits
+	}
+	// positive: flip the sign bit
+	return bits | (1 << 63)
+}
+
+func encodeScore(f float64) []byte {
+	if f == 0 {
+		// normalize -0, so it sorts equal to 0
+		f = 0
+	}
+	return binary.BigEndian.AppendUint64(nil, orderedBits(f))
+}
+
+func decodeScore(data []byte) float64 {
+	bits := binary.BigEndian.Uint64(data)
+	if bits&(1<<63) != 0 {
+		return math.Float64frombits(bits &^ (1 << 63))
+	}
+	return math.Float64frombits(^bits)
+}
@@ -0,0 +1,156 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// The tests are in a separate package, since the Redis store (which implements scored.Provider) imports this package.
+package scored_test
+
+import (
+	"context"
+	"math"
+	"path"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/bbolt"
+	"github.com/nuts-foundation/go-stoabs/redis7"
+	"github.com/nuts-foundation/go-stoabs/scored"
+	"github.com/nuts-foundation/go-stoabs/util"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+func TestShelf(t *testing.T) {
+	t.Run("bbolt", func(t *testing.T) {
+		store, err := bbolt.CreateBBoltStore(path.Join(util.TestDirectory(t), "bbolt.db"), stoabs.WithNoSync())
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = store.Close(context.Background())
+		})
+		testShelf(t, store)
+	})
+	t.Run("redis", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		store, err := redis7.CreateRedisStore("db", &redis.Options{Addr: mr.Addr()})
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = store.Close(context.Background())
+		})
+		require.Implements(t, (*scored.Provider)(nil), store)
+		testShelf(t, store)
+		t.Run("sorted set isn't listed as shelf", func(t *testing.T) {
+			shelf := scored.Open(store, "listed")
+			require.NoError(t, shelf.Add(ctx, "a", 1))
+
+			names, err := store.(stoabs.ShelfLister).ShelfNames(ctx)
+
+			require.NoError(t, err)
+			assert.NotContains(t, names, "listed")
+		})
+	})
+}
+
+func testShelf(t *testing.T, store stoabs.KVStore) {
+	t.Run("add and score", func(t *testing.T) {
+		shelf := scored.Open(store, "add")
+
+		require.NoError(t, shelf.Add(ctx, "a", 1.5))
+		require.NoError(t, shelf.Add(ctx, "a", -2))
+
+		score, err := shelf.Score(ctx, "a")
+		require.NoError(t, err)
+		assert.Equal(t, -2.0, score)
+		_, err = shelf.Score(ctx, "b")
+		assert.ErrorIs(t, err, scored.ErrNotFound)
+		assert.Len(t, rangeByScore(t, shelf, math.Inf(-1), math.Inf(1)), 1)
+	})
+	t.Run("NaN score", func(t *testing.T) {
+		shelf := scored.Open(store, "nan")
+
+		assert.EqualError(t, shelf.Add(ctx, "a", math.NaN()), "score is NaN")
+	})
+	t.Run("remove", func(t *testing.T) {
+		shelf := scored.Open(store, "remove")
+		require.NoError(t, shelf.Add(ctx, "a", 1))
+
+		require.NoError(t, shelf.Remove(ctx, "a"))
+		require.NoError(t, shelf.Remove(ctx, "b"))
+
+		_, err := shelf.Score(ctx, "a")
+		assert.ErrorIs(t, err, scored.ErrNotFound)
+		assert.Empty(t, rangeByScore(t, shelf, math.Inf(-1), math.Inf(1)))
+	})
+	t.Run("range by score", func(t *testing.T) {
+		shelf := scored.Open(store, "range")
+		for member, score := range map[string]float64{"min": math.Inf(-1), "a": -10.5, "b": -1, "c": 0, "d": 0.25, "e": 3, "max": math.Inf(1)} {
+			require.NoError(t, shelf.Add(ctx, member, score))
+		}
+
+		assert.Equal(t, []scored.Member{{"b", -1}, {"c", 0}, {"d", 0.25}}, rangeByScore(t, shelf, -1, 0.25))
+		assert.Equal(t, []string{"min", "a", "b", "c", "d", "e", "max"}, members(rangeByScore(t, shelf, math.Inf(-1), math.Inf(1))))
+		assert.Equal(t, []string{"c"}, members(rangeByScore(t, shelf, math.Copysign(0, -1), 0)))
+		assert.Empty(t, rangeByScore(t, shelf, 1, 2))
+		assert.Empty(t, rangeByScore(t, shelf, 3, -1))
+	})
+	t.Run("top", func(t *testing.T) {
+		shelf := scored.Open(store, "top")
+		for i := 0; i < 10; i++ {
+			require.NoError(t, shelf.Add(ctx, string(rune('a'+i)), float64(i)))
+		}
+
+		top, err := shelf.Top(ctx, 3)
+
+		require.NoError(t, err)
+		assert.Equal(t, []scored.Member{{"j", 9}, {"i", 8}, {"h", 7}}, top)
+		top, err = shelf.Top(ctx, 20)
+		require.NoError(t, err)
+		assert.Len(t, top, 10)
+		top, err = shelf.Top(ctx, 0)
+		require.NoError(t, err)
+		assert.Empty(t, top)
+	})
+	t.Run("empty shelf", func(t *testing.T) {
+		shelf := scored.Open(store, "empty")
+
+		top, err := shelf.Top(ctx, 3)
+
+		require.NoError(t, err)
+		assert.Empty(t, top)
+		assert.Empty(t, rangeByScore(t, shelf, math.Inf(-1), math.Inf(1)))
+	})
+}
+
+func rangeByScore(t *testing.T, shelf scored.Shelf, min, max float64) []scored.Member {
+	var result []scored.Member
+	require.NoError(t, shelf.RangeByScore(ctx, min, max, func(member scored.Member) error {
+		result = append(result, member)
+		return nil
+	}))
+	return result
+}
+
+func members(members []scored.Member) []string {
+	var result []string
+	for _, member := range members {
+		result = append(result, member.Member)
+	}
+	return result
+}
@@ -0,0 +1,149 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"bytes"
+	"sort"
+)
+
+// ScratchProvider is implemented by WriteTxs that provide their own scratch shelves, see GetScratchWriter.
+type ScratchProvider interface {
+	// GetScratchWriter returns a new, empty shelf that only exists for the lifetime of the transaction.
+	GetScratchWriter() Writer
+}
+
+// GetScratchWriter returns a new, empty shelf that only exists for the lifetime of the given transaction and is never committed,
+// for staging intermediate results of computations. It's not visible to other transactions (or other calls to GetScratchWriter),
+// and its entries are iterated in the byte order of their keys.
+// If the transaction does not implement ScratchProvider, the shelf is kept in memory.
+func GetScratchWriter(tx WriteTx) Writer {
+	if provider, ok := tx.(ScratchProvider); ok {
+		return provider.GetScratchWriter()
+	}
+	return &scratchShelf{entries: map[string][]byte{}}
+}
+
+// scratchShelf is an in-memory shelf, see GetScratchWriter.
+type scratchShelf struct {
+	entries map[string][]byte
+	// sorted holds the keys of the entries in byte order, nil if keys were added since it was last sorted.
+	sorted []string
+}
+
+func (s *scratchShelf) Empty() (bool, error) {
+	return len(s.entries) == 0, nil
+}
+
+func (s *scratchShelf) Get(key Key) ([]byte, error) {
+	value, ok := s.entries[string(key.Bytes())]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return bytes.Clone(value), nil
+}
+
+func (s *scratchShelf) Put(key Key, value []byte) error {
+	k := string(key.Bytes())
+	if _, exists := s.entries[k]; !exists {
+		s.sorted = nil
+	}
+	s.entries[k] = bytes.Clone(value)
+	return nil
+}
+
+func (s *scratchShelf) Delete(key Key) error {
+	k := string(key.Bytes())
+	if _, exists := s.entries[k]; exists {
+		delete(s.entries, k)
+		s.sorted = nil
+	}
+	return nil
+}
+
+func (s *scratchShelf) Iterate(callback CallerFn, keyType Key) error {
+	for _, k := range s.sortedKeys() {
+		value, exists := s.entries[k]
+		if !exists {
+			// deleted by the callback
+			continue
+		}
+		key, err := keyType.FromBytes([]byte(k))
+		if err != nil {
+			return err
+		}
+		if err := callback(key, bytes.Clone(value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *scratchShelf) Range(from Key, to Key, callback CallerFn, stopAtNil bool) error {
+	keys := s.sortedKeys()
+	start := sort.SearchStrings(keys, string(from.Bytes()))
+	var prevKey Key
+	for _, k := range keys[start:] {
+		if k >= string(to.Bytes()) {
+			break
+		}
+		value, exists := s.entries[k]
+		if !exists {
+			// deleted by the callback
+			continue
+		}
+		key, err := from.FromBytes([]byte(k))
+		if err != nil {
+			return err
+		}
+		if stopAtNil && prevKey != nil && !prevKey.Next().Equals(key) {
+			// gap found, stop here
+			return nil
+		}
+		if err := callback(key, bytes.Clone(value)); err != nil {
+			return err
+		}
+		prevKey = key
+	}
+	return nil
+}
+
+func (s *scratchShelf) Stats() ShelfStats {
+	size := 0
+	for key, value := range s.entries {
+		size += len(key) + len(value)
+	}
+	return ShelfStats{
+		NumEntries: uint(len(s.entries)),
+		ShelfSize:  uint(size),
+	}
+}
+
+// sortedKeys returns the keys of the shelf in byte order. The result isn't modified afterward,
+// so callbacks may modify the shelf while iterating.
+func (s *scratchShelf) sortedKeys() []string {
+	if s.sorted == nil {
+		s.sorted = make([]string, 0, len(s.entries))
+		for key := range s.entries {
+			s.sorted = append(s.sorted, key)
+		}
+		sort.Strings(s.sorted)
+	}
+	return s.sorted
+}
@@ -0,0 +1,119 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"testing"
+)
+
+type scratchTx struct {
+	*MockWriteTx
+	scratch Writer
+}
+
+func (s scratchTx) GetScratchWriter() Writer {
+	return s.scratch
+}
+
+func TestGetScratchWriter(t *testing.T) {
+	newScratch := func(t *testing.T) Writer {
+		return GetScratchWriter(NewMockWriteTx(gomock.NewController(t)))
+	}
+
+	t.Run("put, get and delete", func(t *testing.T) {
+		scratch := newScratch(t)
+		value := []byte("value")
+
+		require.NoError(t, scratch.Put(BytesKey("a"), value))
+		value[0] = 'V'
+		actual, err := scratch.Get(BytesKey("a"))
+		require.NoError(t, err)
+		assert.Equal(t, []byte("value"), actual)
+		require.NoError(t, scratch.Delete(BytesKey("a")))
+		_, err = scratch.Get(BytesKey("a"))
+
+		assert.ErrorIs(t, err, ErrKeyNotFound)
+	})
+	t.Run("new shelf for every call", func(t *testing.T) {
+		tx := NewMockWriteTx(gomock.NewController(t))
+		require.NoError(t, GetScratchWriter(tx).Put(BytesKey("a"), []byte("1")))
+
+		empty, err := GetScratchWriter(tx).Empty()
+
+		require.NoError(t, err)
+		assert.True(t, empty)
+	})
+	t.Run("iterates in byte order", func(t *testing.T) {
+		scratch := newScratch(t)
+		for _, key := range []uint32{3, 1, 2, 5} {
+			require.NoError(t, scratch.Put(Uint32Key(key), []byte{byte(key)}))
+		}
+		var keys []Key
+
+		err := scratch.Iterate(func(key Key, _ []byte) error {
+			keys = append(keys, key)
+			return scratch.Put(Uint32Key(10), []byte{10})
+		}, Uint32Key(0))
+
+		require.NoError(t, err)
+		assert.Equal(t, []Key{Uint32Key(1), Uint32Key(2), Uint32Key(3), Uint32Key(5)}, keys)
+		assert.Equal(t, uint(5), scratch.Stats().NumEntries)
+	})
+	t.Run("range", func(t *testing.T) {
+		scratch := newScratch(t)
+		for _, key := range []uint32{1, 2, 4, 5, 6} {
+			require.NoError(t, scratch.Put(Uint32Key(key), []byte{byte(key)}))
+		}
+		collect := func(from, to uint32, stopAtNil bool) []Key {
+			var keys []Key
+			require.NoError(t, scratch.Range(Uint32Key(from), Uint32Key(to), func(key Key, _ []byte) error {
+				keys = append(keys, key)
+				return nil
+			}, stopAtNil))
+			return keys
+		}
+
+		assert.Equal(t, []Key{Uint32Key(2), Uint32Key(4), Uint32Key(5)}, collect(2, 6, false))
+		assert.Equal(t, []Key{Uint32Key(1), Uint32Key(2)}, collect(0, 10, true))
+		assert.Empty(t, collect(7, 10, false))
+	})
+	t.Run("deleted while iterating", func(t *testing.T) {
+		scratch := newScratch(t)
+		require.NoError(t, scratch.Put(Uint32Key(1), []byte{1}))
+		require.NoError(t, scratch.Put(Uint32Key(2), []byte{2}))
+		var keys []Key
+
+		err := scratch.Iterate(func(key Key, _ []byte) error {
+			keys = append(keys, key)
+			return scratch.Delete(Uint32Key(2))
+		}, Uint32Key(0))
+
+		require.NoError(t, err)
+		assert.Equal(t, []Key{Uint32Key(1)}, keys)
+	})
+	t.Run("provided by the transaction", func(t *testing.T) {
+		writer := NewMockWriter(gomock.NewController(t))
+		tx := scratchTx{MockWriteTx: NewMockWriteTx(gomock.NewController(t)), scratch: writer}
+
+		assert.Same(t, writer, GetScratchWriter(tx))
+	})
+}
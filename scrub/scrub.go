@@ -0,0 +1,286 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package scrub continuously verifies the entries of a store in the background, within an I/O budget.
+package scrub
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/checksum"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultInterval = 24 * time.Hour
+
+const defaultBudget = 4 * 1024 * 1024
+
+// Checker checks an entry as stored in the store, returning an error describing the problem if it's invalid.
+// The value is only valid during the call.
+type Checker func(shelfName string, key stoabs.Key, value []byte) error
+
+// Checksums is the Checker that verifies the checksums appended to values by a checksum.Store (see checksum.Verify).
+// The scrubbed store must then be the store that was wrapped, so the checksums are read.
+func Checksums(shelfName string, key stoabs.Key, value []byte) error {
+	_, err := checksum.Verify(shelfName, key, value)
+	return err
+}
+
+// Finding is an entry that failed a check.
+type Finding struct {
+	Shelf string
+	Key   stoabs.Key
+	// Err is the error returned by the Checker.
+	Err error
+	// Repaired is true if the repair callback (see WithRepair) was called and succeeded.
+	Repaired bool
+}
+
+// Repairer is called for every finding, e.g. to restore the entry from a backup or replica, or to delete it.
+// It's called after the read transaction in which the entry was found has ended, so it can write to the store.
+type Repairer func(ctx context.Context, finding Finding) error
+
+// Report is the result of a scrub of all shelves.
+type Report struct {
+	Start time.Time
+	End   time.Time
+	// Shelves is the number of scrubbed shelves.
+	Shelves int
+	// Entries is the number of checked entries.
+	Entries int
+	// Bytes is the number of bytes read, the sum of the sizes of the keys and values of the checked entries.
+	Bytes uint64
+	// Findings holds the entries that failed a check, in the order they were found.
+	Findings []Finding
+}
+
+// Option configures the Scrubber.
+type Option func(s *Scrubber)
+
+// WithChecker adds a Checker that is run on every entry. Multiple checkers can be added, they run in the order they
+// were added until one of them fails. If no checks are added, the checksums of values are verified (see Checksums).
+func WithChecker(checker Checker) Option {
+	return func(s *Scrubber) {
+		s.checkers = append(s.checkers, checker)
+	}
+}
+
+// WithRepair sets the callback that is called for every finding. Without it, findings are only reported.
+func WithRepair(repair Repairer) Option {
+	return func(s *Scrubber) {
+		s.repair = repair
+	}
+}
+
+// WithReport sets a callback that is called with the report of every scrub done by Run, e.g. to record metrics.
+// Findings are logged regardless.
+func WithReport(fn func(report Report)) Option {
+	return func(s *Scrubber) {
+		s.report = fn
+	}
+}
+
+// WithBudget sets the maximum number of bytes read per second, which defaults to 4 MiB/s.
+// Scrubbing waits when it's ahead of the budget, so it doesn't compete with the application for I/O. Zero disables the budget.
+func WithBudget(bytesPerSecond uint64) Option {
+	return func(s *Scrubber) {
+		s.budget = bytesPerSecond
+	}
+}
+
+// WithInterval sets the time Run waits between scrubs, which defaults to 24 hours.
+func WithInterval(interval time.Duration) Option {
+	return func(s *Scrubber) {
+		s.interval = interval
+	}
+}
+
+// WithShelves specifies the shelves to scrub. By default, all shelves of the store (see stoabs.ShelfNames) are scrubbed,
+// except the reserved shelves used internally by stoabs packages.
+func WithShelves(shelves ...string) Option {
+	return func(s *Scrubber) {
+		s.shelves = shelves
+	}
+}
+
+// WithKeyType specifies the type of the keys of the given shelf, used to iterate over it.
+// It's required for shelves with other keys than stoabs.BytesKey in Redis, which stores keys in their string form.
+func WithKeyType(shelfName string, keyType stoabs.Key) Option {
+	return func(s *Scrubber) {
+		s.keyTypes[shelfName] = keyType
+	}
+}
+
+// WithClock overrides the clock used to enforce the budget and to wait between scrubs, which defaults to stoabs.SystemClock.
+func WithClock(clock stoabs.Clock) Option {
+	return func(s *Scrubber) {
+		s.clock = clock
+	}
+}
+
+// WithLogger overrides the default logger.
+func WithLogger(log *logrus.Logger) Option {
+	return func(s *Scrubber) {
+		s.log = log
+	}
+}
+
+// New creates a Scrubber that checks the entries of the given store.
+func New(store stoabs.KVStore, opts ...Option) *Scrubber {
+	result := &Scrubber{
+		store:    store,
+		budget:   defaultBudget,
+		interval: defaultInterval,
+		keyTypes: map[string]stoabs.Key{},
+		clock:    stoabs.SystemClock,
+		log:      logrus.StandardLogger(),
+	}
+	for _, opt := range opts {
+		opt(result)
+	}
+	if len(result.checkers) == 0 {
+		result.checkers = []Checker{Checksums}
+	}
+	return result
+}
+
+// Scrubber checks the entries of a store. Use New to create it.
+type Scrubber struct {
+	store    stoabs.KVStore
+	checkers []Checker
+	repair   Repairer
+	report   func(report Report)
+	budget   uint64
+	interval time.Duration
+	shelves  []string
+	keyTypes map[string]stoabs.Key
+	clock    stoabs.Clock
+	log      *logrus.Logger
+}
+
+// Run scrubs the store until the given context is cancelled, waiting for the interval (see WithInterval) between scrubs.
+// Errors are logged and retried after the interval.
+func (s *Scrubber) Run(ctx context.Context) {
+	for {
+		report, err := s.Scrub(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			s.log.WithError(err).Warn("Scrubbing store failed, retrying")
+		} else if s.report != nil {
+			s.report(report)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.clock.After(s.interval):
+		}
+	}
+}
+
+// Scrub checks all entries of the shelves once and calls the repair callback for the findings (see WithRepair).
+// Every shelf is read in its own read transaction. It returns an error if reading fails, with the report of the entries
+// checked until then. Errors returned by the repair callback are logged, the finding is reported as not repaired.
+func (s *Scrubber) Scrub(ctx context.Context) (Report, error) {
+	report := Report{Start: s.clock.Now()}
+	shelves := s.shelves
+	if shelves == nil {
+		var err error
+		if shelves, err = stoabs.ShelfNames(ctx, s.store); err != nil {
+			return report, fmt.Errorf("unable to list shelves: %w", err)
+		}
+	}
+	throttle := throttle{clock: s.clock, budget: s.budget, start: report.Start}
+	for _, shelfName := range shelves {
+		findings, err := s.scrubShelf(ctx, shelfName, &report, &throttle)
+		for _, finding := range findings {
+			s.log.WithError(finding.Err).WithField("shelf", finding.Shelf).WithField("key", finding.Key).
+				Warn("Scrubbing found invalid entry")
+			if s.repair != nil {
+				if err := s.repair(ctx, finding); err != nil {
+					s.log.WithError(err).WithField("shelf", finding.Shelf).WithField("key", finding.Key).
+						Error("Unable to repair invalid entry")
+				} else {
+					finding.Repaired = true
+				}
+			}
+			report.Findings = append(report.Findings, finding)
+		}
+		if err != nil {
+			report.End = s.clock.Now()
+			return report, fmt.Errorf("unable to scrub shelf %s: %w", shelfName, err)
+		}
+		report.Shelves++
+	}
+	report.End = s.clock.Now()
+	return report, nil
+}
+
+// scrubShelf checks the entries of the shelf, and returns the findings.
+func (s *Scrubber) scrubShelf(ctx context.Context, shelfName string, report *Report, throttle *throttle) ([]Finding, error) {
+	keyType, ok := s.keyTypes[shelfName]
+	if !ok {
+		keyType = stoabs.BytesKey{}
+	}
+	var findings []Finding
+	err := s.store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+		return reader.Iterate(func(key stoabs.Key, value []byte) error {
+			report.Entries++
+			size := len(key.Bytes()) + len(value)
+			report.Bytes += uint64(size)
+			for _, checker := range s.checkers {
+				if err := checker(shelfName, key, value); err != nil {
+					findings = append(findings, Finding{Shelf: shelfName, Key: key, Err: err})
+					break
+				}
+			}
+			return throttle.wait(ctx, size)
+		}, keyType)
+	})
+	return findings, err
+}
+
+// throttle waits when more bytes were read than the budget allows since the start.
+type throttle struct {
+	clock  stoabs.Clock
+	budget uint64
+	start  time.Time
+	bytes  uint64
+}
+
+func (t *throttle) wait(ctx context.Context, n int) error {
+	if t.budget == 0 {
+		return ctx.Err()
+	}
+	t.bytes += uint64(n)
+	due := t.start.Add(time.Duration(float64(t.bytes) / float64(t.budget) * float64(time.Second)))
+	wait := due.Sub(t.clock.Now())
+	if wait <= 0 {
+		return ctx.Err()
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.clock.After(wait):
+		return nil
+	}
+}
@@ -0,0 +1,219 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package scrub
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/checksum"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/nuts-foundation/go-stoabs/mocks"
+	"github.com/nuts-foundation/go-stoabs/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+var now = time.Unix(1700000000, 0)
+
+func TestScrubber_Scrub(t *testing.T) {
+	t.Run("reports and repairs corrupt entries", func(t *testing.T) {
+		underlying := stores.BBolt(t)
+		store := checksum.Wrap(underlying)
+		put(t, store, "users", "a", "b")
+		put(t, store, "sessions", "c")
+		put(t, underlying, "users", "corrupt")
+		var repaired []Finding
+		scrubber := New(underlying, WithBudget(0), WithRepair(func(ctx context.Context, finding Finding) error {
+			repaired = append(repaired, finding)
+			return store.WriteShelf(ctx, finding.Shelf, func(writer stoabs.Writer) error {
+				return writer.Put(finding.Key, []byte("restored"))
+			})
+		}))
+
+		report, err := scrubber.Scrub(ctx)
+
+		require.NoError(t, err)
+		assert.Equal(t, 2, report.Shelves)
+		assert.Equal(t, 4, report.Entries)
+		require.Len(t, report.Findings, 1)
+		assert.Equal(t, "users", report.Findings[0].Shelf)
+		assert.Equal(t, stoabs.BytesKey("corrupt"), report.Findings[0].Key)
+		assert.ErrorIs(t, report.Findings[0].Err, checksum.ErrChecksum{})
+		assert.True(t, report.Findings[0].Repaired)
+		require.Len(t, repaired, 1)
+		assert.False(t, repaired[0].Repaired)
+		t.Run("repaired entry passes next scrub", func(t *testing.T) {
+			report, err := scrubber.Scrub(ctx)
+
+			require.NoError(t, err)
+			assert.Empty(t, report.Findings)
+		})
+	})
+	t.Run("failed repair", func(t *testing.T) {
+		store := stores.BBolt(t)
+		put(t, store, "users", "corrupt")
+		scrubber := New(store, WithBudget(0), WithRepair(func(context.Context, Finding) error {
+			return errors.New("failed")
+		}))
+
+		report, err := scrubber.Scrub(ctx)
+
+		require.NoError(t, err)
+		require.Len(t, report.Findings, 1)
+		assert.False(t, report.Findings[0].Repaired)
+	})
+	t.Run("custom checkers", func(t *testing.T) {
+		store := stores.BBolt(t)
+		put(t, store, "users", "a", "b")
+		var checked int
+		scrubber := New(store, WithBudget(0), WithChecker(func(string, stoabs.Key, []byte) error {
+			checked++
+			return nil
+		}), WithChecker(func(_ string, key stoabs.Key, _ []byte) error {
+			if string(key.Bytes()) == "b" {
+				return errors.New("invalid")
+			}
+			return nil
+		}))
+
+		report, err := scrubber.Scrub(ctx)
+
+		require.NoError(t, err)
+		assert.Equal(t, 2, checked)
+		require.Len(t, report.Findings, 1)
+		assert.EqualError(t, report.Findings[0].Err, "invalid")
+	})
+	t.Run("only given shelves", func(t *testing.T) {
+		store := stores.BBolt(t)
+		put(t, store, "users", "a")
+		put(t, store, "sessions", "b")
+
+		report, err := New(store, WithBudget(0), WithShelves("users")).Scrub(ctx)
+
+		require.NoError(t, err)
+		assert.Equal(t, 1, report.Shelves)
+		assert.Len(t, report.Findings, 1)
+	})
+	t.Run("reserved shelves are skipped", func(t *testing.T) {
+		store := stores.BBolt(t)
+		put(t, store, stoabs.ReservedShelfPrefix+"internal", "a")
+
+		report, err := New(store, WithBudget(0)).Scrub(ctx)
+
+		require.NoError(t, err)
+		assert.Empty(t, report.Findings)
+	})
+	t.Run("budget", func(t *testing.T) {
+		clock := mocks.NewClock(now)
+		underlying := stores.BBolt(t)
+		// every entry is 1 byte of key and 10 bytes of value including the checksum
+		put(t, checksum.Wrap(underlying), "users", "a", "b")
+		scrubber := New(underlying, WithClock(clock), WithBudget(11))
+		done := make(chan Report)
+		go func() {
+			report, _ := scrubber.Scrub(ctx)
+			done <- report
+		}()
+
+		util.WaitFor(t, func() (bool, error) {
+			return clock.Waiters() == 1, nil
+		}, 5*time.Second, "Scrub didn't wait for the budget")
+		clock.Advance(time.Second)
+		util.WaitFor(t, func() (bool, error) {
+			return clock.Waiters() == 1, nil
+		}, 5*time.Second, "Scrub didn't wait for the budget")
+		clock.Advance(time.Second)
+		report := <-done
+
+		assert.Equal(t, 2, report.Entries)
+		assert.Equal(t, uint64(22), report.Bytes)
+		assert.Equal(t, 2*time.Second, report.End.Sub(report.Start))
+	})
+	t.Run("cancelled", func(t *testing.T) {
+		store := stores.BBolt(t)
+		put(t, store, "users", "a")
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+
+		_, err := New(store, WithClock(mocks.NewClock(now)), WithBudget(1)).Scrub(cancelled)
+
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+	t.Run("failing store", func(t *testing.T) {
+		store := stores.BBolt(t)
+		scrubber := New(stoabs.Chain(store, failingInterceptor{}), WithShelves("users"))
+
+		_, err := scrubber.Scrub(ctx)
+
+		assert.EqualError(t, err, "unable to scrub shelf users: failed")
+	})
+}
+
+func TestScrubber_Run(t *testing.T) {
+	clock := mocks.NewClock(now)
+	store := stores.BBolt(t)
+	put(t, store, "users", "corrupt")
+	reports := make(chan Report, 2)
+	scrubber := New(store, WithClock(clock), WithBudget(0), WithInterval(time.Hour), WithReport(func(report Report) {
+		reports <- report
+	}))
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		scrubber.Run(ctx)
+		close(done)
+	}()
+
+	report := <-reports
+	assert.Len(t, report.Findings, 1)
+	util.WaitFor(t, func() (bool, error) {
+		return clock.Waiters() == 1, nil
+	}, 5*time.Second, "Run didn't wait for the interval")
+	clock.Advance(time.Hour)
+	report = <-reports
+	assert.Equal(t, now.Add(time.Hour).UnixNano(), report.Start.UnixNano())
+
+	cancel()
+	<-done
+}
+
+type failingInterceptor struct {
+	stoabs.NoopInterceptor
+}
+
+func (failingInterceptor) Transaction(context.Context, stoabs.TxInfo, func(ctx context.Context) error) error {
+	return errors.New("failed")
+}
+
+func put(t *testing.T, store stoabs.KVStore, shelf string, keys ...string) {
+	require.NoError(t, store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+		for _, key := range keys {
+			if err := writer.Put(stoabs.BytesKey(key), []byte("value")); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
@@ -0,0 +1,449 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package sharded provides a KVStore that partitions entries over multiple stores using consistent hashing.
+package sharded
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/nuts-foundation/go-stoabs"
+)
+
+var _ stoabs.KVStore = (*Store)(nil)
+var _ stoabs.ShelfLister = (*Store)(nil)
+var _ stoabs.WriteTx = (*tx)(nil)
+var _ stoabs.Writer = (*shelf)(nil)
+
+const defaultVirtualNodes = 128
+
+const rebalanceChunkSize = 1000
+
+// errChunkFull stops iterating a shelf when a chunk of entries to move is complete.
+var errChunkFull = errors.New("chunk full")
+
+// Shard is one of the stores the entries are partitioned over.
+type Shard struct {
+	// Name identifies the shard on the hash ring. It must be unique and stay the same when shards are added or removed,
+	// since it determines which keys are stored in the shard.
+	Name  string
+	Store stoabs.KVStore
+}
+
+// Option configures the sharded store.
+type Option func(s *Store)
+
+// WithVirtualNodes sets the number of points per shard on the hash ring. More points distribute the keys more evenly.
+// It defaults to 128.
+func WithVirtualNodes(count int) Option {
+	return func(s *Store) {
+		s.virtualNodes = count
+	}
+}
+
+// Wrap creates a store that partitions entries over the given shards by a consistent hash of the shelf name and key.
+// When shards are added or removed, only the keys of the affected part of the ring move (see Rebalance).
+//
+// Transactions are opened on all shards (in the given order) and nested, so a failing transaction function is rolled
+// back on all shards. Committing is not atomic across shards however: if committing fails on one shard,
+// the transactions on the shards before it in the list have been committed already.
+// Iterate visits the shards one after the other, so entries are not in key order. Range returns entries in key order,
+// but collects the entries of all shards in memory first.
+func Wrap(shards []Shard, opts ...Option) (*Store, error) {
+	if len(shards) == 0 {
+		return nil, errors.New("no shards given")
+	}
+	result := &Store{shards: shards, virtualNodes: defaultVirtualNodes}
+	for _, opt := range opts {
+		opt(result)
+	}
+	names := map[string]bool{}
+	for i, shard := range shards {
+		if names[shard.Name] {
+			return nil, fmt.Errorf("duplicate shard name: %s", shard.Name)
+		}
+		names[shard.Name] = true
+		for v := 0; v < result.virtualNodes; v++ {
+			result.ring = append(result.ring, point{hash: hash([]byte(shard.Name + "#" + strconv.Itoa(v))), shard: i})
+		}
+	}
+	sort.Slice(result.ring, func(i, j int) bool {
+		return result.ring[i].hash < result.ring[j].hash
+	})
+	return result, nil
+}
+
+// Store is a KVStore that partitions entries over multiple stores. Use Wrap to create it.
+type Store struct {
+	shards       []Shard
+	virtualNodes int
+	ring         []point
+}
+
+type point struct {
+	hash  uint64
+	shard int
+}
+
+func hash(data []byte) uint64 {
+	sum := sha256.Sum256(data)
+	return binary.BigEndian.Uint64(sum[:])
+}
+
+// shardFor returns the index of the shard the given key of the given shelf is stored in.
+func (s *Store) shardFor(shelfName string, key stoabs.Key) int {
+	h := hash(append(append([]byte(shelfName), 0), key.Bytes()...))
+	i := sort.Search(len(s.ring), func(i int) bool {
+		return s.ring[i].hash >= h
+	})
+	if i == len(s.ring) {
+		i = 0
+	}
+	return s.ring[i].shard
+}
+
+// Close closes all shards. If closing one or more shards fails, the first error is returned.
+func (s *Store) Close(ctx context.Context) error {
+	var result error
+	for _, shard := range s.shards {
+		if err := shard.Store.Close(ctx); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+func (s *Store) Write(ctx context.Context, fn func(stoabs.WriteTx) error, opts ...stoabs.TxOption) error {
+	// callbacks (e.g. stoabs.AfterCommitOption) must only be invoked once, so they're only passed to the outermost
+	// transaction, which is committed last.
+	var nestedOpts []stoabs.TxOption
+	if (stoabs.WriteLockOption{}).Enabled(opts) {
+		nestedOpts = append(nestedOpts, stoabs.WithWriteLock())
+	}
+	if shelves := (stoabs.ShelfLockOption{}).Shelves(opts); len(shelves) > 0 {
+		nestedOpts = append(nestedOpts, stoabs.WithShelfLock(shelves...))
+	}
+	txs := make([]stoabs.WriteTx, len(s.shards))
+	var open func(i int) error
+	open = func(i int) error {
+		if i == len(s.shards) {
+			return fn(&tx{ReadTx: &readTxs{store: s, txs: readTxsOf(txs), ctx: ctx}, writeTxs: txs})
+		}
+		shardOpts := nestedOpts
+		if i == 0 {
+			shardOpts = opts
+		}
+		return s.shards[i].Store.Write(ctx, func(shardTx stoabs.WriteTx) error {
+			txs[i] = shardTx
+			return open(i + 1)
+		}, shardOpts...)
+	}
+	return open(0)
+}
+
+func (s *Store) WriteShelf(ctx context.Context, shelfName string, fn func(stoabs.Writer) error) error {
+	return s.Write(ctx, func(tx stoabs.WriteTx) error {
+		return fn(tx.GetShelfWriter(shelfName))
+	})
+}
+
+func (s *Store) Read(ctx context.Context, fn func(stoabs.ReadTx) error) error {
+	txs := make([]stoabs.ReadTx, len(s.shards))
+	var open func(i int) error
+	open = func(i int) error {
+		if i == len(s.shards) {
+			return fn(&readTxs{store: s, txs: txs, ctx: ctx})
+		}
+		return s.shards[i].Store.Read(ctx, func(shardTx stoabs.ReadTx) error {
+			txs[i] = shardTx
+			return open(i + 1)
+		})
+	}
+	return open(0)
+}
+
+func (s *Store) ReadShelf(ctx context.Context, shelfName string, fn func(stoabs.Reader) error) error {
+	return s.Read(ctx, func(tx stoabs.ReadTx) error {
+		return fn(tx.GetShelfReader(shelfName))
+	})
+}
+
+// ShelfNames returns the shelves of all shards, which must implement stoabs.ShelfLister.
+func (s *Store) ShelfNames(ctx context.Context) ([]string, error) {
+	unique := map[string]bool{}
+	for _, shard := range s.shards {
+		names, err := stoabs.ShelfNames(ctx, shard.Store)
+		if err != nil {
+			return nil, fmt.Errorf("shard %s: %w", shard.Name, err)
+		}
+		for _, name := range names {
+			unique[name] = true
+		}
+	}
+	result := make([]string, 0, len(unique))
+	for name := range unique {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// RebalanceOption configures Rebalance.
+type RebalanceOption func(cfg *rebalanceConfig)
+
+type rebalanceConfig struct {
+	keyTypes map[string]stoabs.Key
+}
+
+// WithKeyType specifies the type of the keys of the given shelf, used to read them while rebalancing.
+// It's required for shards backed by Redis, which stores keys in their string form (e.g. numbers in decimal):
+// otherwise keys can't be parsed, or are hashed differently than when they were written.
+// Shelves without key type are rebalanced with stoabs.BytesKey.
+func WithKeyType(shelf string, keyType stoabs.Key) RebalanceOption {
+	return func(cfg *rebalanceConfig) {
+		cfg.keyTypes[shelf] = keyType
+	}
+}
+
+// Rebalance moves entries that are not stored in the shard they belong to according to the hash ring,
+// which is required after shards have been added or removed. Retrieving such entries fails until they have been moved.
+// Entries of reserved shelves (see stoabs.ReservedShelfPrefix) are moved as well. All shards must implement stoabs.ShelfLister. Keys are moved as stoabs.BytesKey unless specified otherwise using
+// WithKeyType, see dump.Export for the implications for Redis.
+// It returns the number of moved entries.
+func (s *Store) Rebalance(ctx context.Context, opts ...RebalanceOption) (int, error) {
+	cfg := rebalanceConfig{keyTypes: map[string]stoabs.Key{}}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	moved := 0
+	for i, shard := range s.shards {
+		shelves, err := stoabs.ShelfNames(stoabs.ContextWithReservedShelves(ctx), shard.Store)
+		if err != nil {
+			return moved, fmt.Errorf("shard %s: %w", shard.Name, err)
+		}
+		for _, shelfName := range shelves {
+			keyType, ok := cfg.keyTypes[shelfName]
+			if !ok {
+				keyType = stoabs.BytesKey{}
+			}
+			for {
+				count, err := s.moveChunk(ctx, i, shelfName, keyType)
+				moved += count
+				if err != nil {
+					return moved, fmt.Errorf("unable to rebalance shelf %s of shard %s: %w", shelfName, shard.Name, err)
+				}
+				if count < rebalanceChunkSize {
+					break
+				}
+			}
+		}
+	}
+	return moved, nil
+}
+
+// moveChunk moves up to rebalanceChunkSize misplaced entries of the given shelf out of the given shard.
+func (s *Store) moveChunk(ctx context.Context, source int, shelfName string, keyType stoabs.Key) (int, error) {
+	type entry struct {
+		key   stoabs.Key
+		value []byte
+	}
+	misplaced := map[int][]entry{}
+	count := 0
+	err := s.shards[source].Store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+		return reader.Iterate(func(key stoabs.Key, value []byte) error {
+			if target := s.shardFor(shelfName, key); target != source {
+				if count == rebalanceChunkSize {
+					return errChunkFull
+				}
+				misplaced[target] = append(misplaced[target], entry{key: key, value: append(value[:0:0], value...)})
+				count++
+			}
+			return nil
+		}, keyType)
+	})
+	if err != nil && !errors.Is(err, errChunkFull) {
+		return 0, err
+	}
+	for target, entries := range misplaced {
+		err := s.shards[target].Store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			for _, e := range entries {
+				if err := writer.Put(e.key, e.value); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+		err = s.shards[source].Store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			for _, e := range entries {
+				if err := writer.Delete(e.key); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
+}
+
+func readTxsOf(txs []stoabs.WriteTx) []stoabs.ReadTx {
+	result := make([]stoabs.ReadTx, len(txs))
+	for i, t := range txs {
+		result[i] = t
+	}
+	return result
+}
+
+type readTxs struct {
+	store *Store
+	txs   []stoabs.ReadTx
+	ctx   context.Context
+}
+
+func (r *readTxs) GetShelfReader(shelfName string) stoabs.Reader {
+	readers := make([]stoabs.Reader, len(r.txs))
+	for i, t := range r.txs {
+		readers[i] = t.GetShelfReader(shelfName)
+	}
+	return &shelf{name: shelfName, store: r.store, readers: readers, ctx: r.ctx}
+}
+
+func (r *readTxs) Store() stoabs.KVStore {
+	return r.store
+}
+
+// Unwrap returns the underlying transactions of the shards, as []interface{}.
+func (r *readTxs) Unwrap() interface{} {
+	result := make([]interface{}, len(r.txs))
+	for i, t := range r.txs {
+		result[i] = t.Unwrap()
+	}
+	return result
+}
+
+type tx struct {
+	stoabs.ReadTx
+	writeTxs []stoabs.WriteTx
+}
+
+func (t *tx) GetShelfWriter(shelfName string) stoabs.Writer {
+	result := t.ReadTx.(*readTxs).GetShelfReader(shelfName).(*shelf)
+	result.writers = make([]stoabs.Writer, len(t.writeTxs))
+	for i, writeTx := range t.writeTxs {
+		result.writers[i] = writeTx.GetShelfWriter(shelfName)
+		result.readers[i] = result.writers[i]
+	}
+	return result
+}
+
+type shelf struct {
+	name    string
+	store   *Store
+	readers []stoabs.Reader
+	writers []stoabs.Writer
+	ctx     context.Context
+}
+
+func (s *shelf) Empty() (bool, error) {
+	for _, reader := range s.readers {
+		empty, err := reader.Empty()
+		if err != nil || !empty {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (s *shelf) Get(key stoabs.Key) ([]byte, error) {
+	return s.readers[s.store.shardFor(s.name, key)].Get(key)
+}
+
+func (s *shelf) Iterate(callback stoabs.CallerFn, keyType stoabs.Key) error {
+	for _, reader := range s.readers {
+		if err := reader.Iterate(callback, keyType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *shelf) Range(from stoabs.Key, to stoabs.Key, callback stoabs.CallerFn, stopAtNil bool) error {
+	type entry struct {
+		key   stoabs.Key
+		value []byte
+	}
+	var entries []entry
+	for _, reader := range s.readers {
+		err := reader.Range(from, to, func(key stoabs.Key, value []byte) error {
+			entries = append(entries, entry{key: key, value: value})
+			return nil
+		}, false)
+		if err != nil {
+			return err
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].key.Bytes(), entries[j].key.Bytes()) < 0
+	})
+	var prevKey stoabs.Key
+	for _, e := range entries {
+		// Potentially long-running operation, check context for cancellation
+		if err := s.ctx.Err(); err != nil {
+			return stoabs.DatabaseError(err)
+		}
+		if stopAtNil && prevKey != nil && !prevKey.Next().Equals(e.key) {
+			// gap found, stop here
+			return nil
+		}
+		if err := callback(e.key, e.value); err != nil {
+			return err
+		}
+		prevKey = e.key
+	}
+	return nil
+}
+
+func (s *shelf) Stats() stoabs.ShelfStats {
+	var result stoabs.ShelfStats
+	for _, reader := range s.readers {
+		stats := reader.Stats()
+		result.NumEntries += stats.NumEntries
+		result.ShelfSize += stats.ShelfSize
+	}
+	return result
+}
+
+func (s *shelf) Put(key stoabs.Key, value []byte) error {
+	return s.writers[s.store.shardFor(s.name, key)].Put(key, value)
+}
+
+func (s *shelf) Delete(key stoabs.Key) error {
+	return s.writers[s.store.shardFor(s.name, key)].Delete(key)
+}
@@ -0,0 +1,294 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package sharded
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/bbolt"
+	"github.com/nuts-foundation/go-stoabs/kvtests"
+	"github.com/nuts-foundation/go-stoabs/redis7"
+	"github.com/nuts-foundation/go-stoabs/util"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+const shelfName = "test"
+
+func TestSharded(t *testing.T) {
+	provider := func(t *testing.T) (stoabs.KVStore, error) {
+		return Wrap(createShards(t, "a", "b", "c"))
+	}
+
+	kvtests.TestReadingAndWriting(t, provider)
+	kvtests.TestRange(t, provider)
+	kvtests.TestIterate(t, provider)
+	kvtests.TestEmpty(t, provider)
+	kvtests.TestClose(t, provider)
+	kvtests.TestDelete(t, provider)
+	kvtests.TestStats(t, provider)
+	// kvtests.TestWriteTransactions is not used: it skips (from within the transaction) on transactions it can't unwrap,
+	// which leaves the shards locked. The transaction semantics are tested by TestStore_Write instead.
+	kvtests.TestShelfNames(t, provider)
+}
+
+func TestWrap(t *testing.T) {
+	t.Run("no shards", func(t *testing.T) {
+		_, err := Wrap(nil)
+
+		assert.EqualError(t, err, "no shards given")
+	})
+	t.Run("duplicate shard names", func(t *testing.T) {
+		_, err := Wrap(createShards(t, "a", "a"))
+
+		assert.EqualError(t, err, "duplicate shard name: a")
+	})
+}
+
+func TestStore_distribution(t *testing.T) {
+	shards := createShards(t, "a", "b", "c")
+	store, _ := Wrap(shards)
+
+	writeEntries(t, store, 300)
+
+	for _, shard := range shards {
+		count := countEntries(t, shard.Store)
+		assert.Greater(t, count, 50, "shard %s", shard.Name)
+	}
+}
+
+func TestStore_Range(t *testing.T) {
+	store, _ := Wrap(createShards(t, "a", "b", "c"))
+	require.NoError(t, store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+		for _, i := range []uint32{1, 2, 3, 5} {
+			if err := writer.Put(stoabs.Uint32Key(i), []byte{byte(i)}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	collect := func(stopAtNil bool) []stoabs.Key {
+		var keys []stoabs.Key
+		err := store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			return reader.Range(stoabs.Uint32Key(0), stoabs.Uint32Key(10), func(key stoabs.Key, _ []byte) error {
+				keys = append(keys, key)
+				return nil
+			}, stopAtNil)
+		})
+		require.NoError(t, err)
+		return keys
+	}
+
+	t.Run("in key order", func(t *testing.T) {
+		assert.Equal(t, []stoabs.Key{stoabs.Uint32Key(1), stoabs.Uint32Key(2), stoabs.Uint32Key(3), stoabs.Uint32Key(5)}, collect(false))
+	})
+	t.Run("stop at nil", func(t *testing.T) {
+		assert.Equal(t, []stoabs.Key{stoabs.Uint32Key(1), stoabs.Uint32Key(2), stoabs.Uint32Key(3)}, collect(true))
+	})
+}
+
+func TestStore_Write(t *testing.T) {
+	t.Run("after commit is invoked once", func(t *testing.T) {
+		store, _ := Wrap(createShards(t, "a", "b"))
+		count := 0
+
+		err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+			return tx.GetShelfWriter(shelfName).Put(stoabs.BytesKey{1}, []byte{1})
+		}, stoabs.WithWriteLock(), stoabs.AfterCommit(func() {
+			count++
+		}))
+
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+	})
+	t.Run("error rolls back all shards", func(t *testing.T) {
+		shards := createShards(t, "a", "b", "c")
+		store, _ := Wrap(shards)
+		rollbacks := 0
+
+		err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+			writer := tx.GetShelfWriter(shelfName)
+			for i := 0; i < 100; i++ {
+				if err := writer.Put(stoabs.BytesKey(fmt.Sprintf("key%d", i)), []byte{1}); err != nil {
+					return err
+				}
+			}
+			return errors.New("failed")
+		}, stoabs.OnRollback(func() {
+			rollbacks++
+		}))
+
+		assert.EqualError(t, err, "failed")
+		assert.Equal(t, 1, rollbacks)
+		for _, shard := range shards {
+			assert.Equal(t, 0, countEntries(t, shard.Store), "shard %s", shard.Name)
+		}
+	})
+	t.Run("shelf locks are passed to all shards", func(t *testing.T) {
+		shards := createShards(t, "a", "b")
+		recorders := make([]*optsRecorder, len(shards))
+		for i := range shards {
+			recorders[i] = &optsRecorder{KVStore: shards[i].Store}
+			shards[i].Store = recorders[i]
+		}
+		store, _ := Wrap(shards)
+
+		err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+			return nil
+		}, stoabs.WithShelfLock("b", "a"))
+
+		require.NoError(t, err)
+		for _, recorder := range recorders {
+			assert.Equal(t, []string{"a", "b"}, stoabs.ShelfLockOption{}.Shelves(recorder.opts))
+		}
+	})
+	t.Run("unwrap returns the transactions of all shards", func(t *testing.T) {
+		store, _ := Wrap(createShards(t, "a", "b"))
+
+		err := store.Write(ctx, func(tx stoabs.WriteTx) error {
+			assert.Len(t, tx.Unwrap(), 2)
+			return nil
+		})
+
+		require.NoError(t, err)
+	})
+}
+
+func TestStore_Rebalance(t *testing.T) {
+	shards := createShards(t, "a", "b", "c")
+	store, _ := Wrap(shards[:2])
+	writeEntries(t, store, 300)
+
+	// add a shard
+	store, _ = Wrap(shards)
+	moved, err := store.Rebalance(ctx)
+
+	require.NoError(t, err)
+	assert.Equal(t, countEntries(t, shards[2].Store), moved)
+	assert.Greater(t, moved, 50)
+	assert.Less(t, moved, 150)
+	var missing []int
+	err = store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+		for i := 0; i < 300; i++ {
+			if _, err := reader.Get(stoabs.BytesKey(fmt.Sprintf("key%d", i))); err != nil {
+				missing = append(missing, i)
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Empty(t, missing)
+	// rebalancing again doesn't move anything
+	moved, err = store.Rebalance(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, moved)
+}
+
+func TestStore_Rebalance_Redis(t *testing.T) {
+	var shards []Shard
+	for _, name := range []string{"a", "b", "c"} {
+		mr := miniredis.RunT(t)
+		store, err := redis7.CreateRedisStore("db", &redis.Options{Addr: mr.Addr()})
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = store.Close(context.Background())
+		})
+		shards = append(shards, Shard{Name: name, Store: store})
+	}
+	store, _ := Wrap(shards[:2])
+	err := store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+		for i := 0; i < 50; i++ {
+			if err := writer.Put(stoabs.Uint32Key(i), []byte("value")); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	store, _ = Wrap(shards)
+	moved, err := store.Rebalance(ctx, WithKeyType(shelfName, stoabs.Uint32Key(0)))
+
+	require.NoError(t, err)
+	assert.Greater(t, moved, 0)
+	err = store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+		for i := 0; i < 50; i++ {
+			if _, err := reader.Get(stoabs.Uint32Key(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+// optsRecorder records the options of the last write transaction.
+type optsRecorder struct {
+	stoabs.KVStore
+	opts []stoabs.TxOption
+}
+
+func (o *optsRecorder) Write(ctx context.Context, fn func(stoabs.WriteTx) error, opts ...stoabs.TxOption) error {
+	o.opts = opts
+	return o.KVStore.Write(ctx, fn, opts...)
+}
+
+func createShards(t *testing.T, names ...string) []Shard {
+	var result []Shard
+	for _, name := range names {
+		store, err := bbolt.CreateBBoltStore(path.Join(util.TestDirectory(t), name+".db"), stoabs.WithNoSync())
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = store.Close(context.Background())
+		})
+		result = append(result, Shard{Name: name, Store: store})
+	}
+	return result
+}
+
+func writeEntries(t *testing.T, store stoabs.KVStore, count int) {
+	require.NoError(t, store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+		for i := 0; i < count; i++ {
+			if err := writer.Put(stoabs.BytesKey(fmt.Sprintf("key%d", i)), []byte{1}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
+
+func countEntries(t *testing.T, store stoabs.KVStore) int {
+	count := 0
+	require.NoError(t, store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+		return reader.Iterate(func(_ stoabs.Key, _ []byte) error {
+			count++
+			return nil
+		}, stoabs.BytesKey{})
+	}))
+	return count
+}
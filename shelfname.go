@@ -0,0 +1,180 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxShelfNameLength is the maximum length of a shelf name in bytes, not counting the key prefix (see WithKeyPrefix).
+const MaxShelfNameLength = 512
+
+// ReservedShelfPrefix is the prefix of the names of the shelves stoabs uses internally (e.g. for journals and indexes).
+// Shelf names supplied by users must not have it, see ValidateUserShelfName and EscapeShelfName.
+// Reserved shelves aren't listed by ShelfNames, unless requested using ContextWithReservedShelves.
+const ReservedShelfPrefix = "_stoabs/"
+
+// ErrInvalidShelfName is returned when a shelf name is rejected by ValidateShelfName or ValidateUserShelfName.
+type ErrInvalidShelfName struct {
+	Name   string
+	Reason string
+}
+
+func (e ErrInvalidShelfName) Error() string {
+	return fmt.Sprintf("invalid shelf name %q: %s", e.Name, e.Reason)
+}
+
+// Is returns true for any ErrInvalidShelfName, so errors.Is(err, ErrInvalidShelfName{}) matches regardless of name and reason.
+func (e ErrInvalidShelfName) Is(other error) bool {
+	_, ok := other.(ErrInvalidShelfName)
+	return ok
+}
+
+// ValidateShelfName checks whether the given name can be used as shelf name: it must not be empty or longer than
+// MaxShelfNameLength, and must be valid UTF-8 without control characters. The backends validate shelf names when
+// getting a shelf reader or writer, which fail all operations with ErrInvalidShelfName for invalid names (see InvalidShelf).
+func ValidateShelfName(name string) error {
+	if name == "" {
+		return ErrInvalidShelfName{Name: name, Reason: "must not be empty"}
+	}
+	if len(name) > MaxShelfNameLength {
+		return ErrInvalidShelfName{Name: name, Reason: fmt.Sprintf("must not be longer than %d bytes", MaxShelfNameLength)}
+	}
+	if !utf8.ValidString(name) {
+		return ErrInvalidShelfName{Name: name, Reason: "must be valid UTF-8"}
+	}
+	if strings.ContainsFunc(name, unicode.IsControl) {
+		return ErrInvalidShelfName{Name: name, Reason: "must not contain control characters"}
+	}
+	return nil
+}
+
+// ValidateUserShelfName checks whether the given name, supplied by a user, can be used as shelf name (see ValidateShelfName)
+// without colliding with the internal shelves of stoabs: it must not have the ReservedShelfPrefix.
+// Use EscapeShelfName to map arbitrary names to valid shelf names instead.
+func ValidateUserShelfName(name string) error {
+	if err := ValidateShelfName(name); err != nil {
+		return err
+	}
+	if IsReservedShelfName(name) {
+		return ErrInvalidShelfName{Name: name, Reason: "prefix " + ReservedShelfPrefix + " is reserved"}
+	}
+	return nil
+}
+
+// IsReservedShelfName returns whether the given shelf name is reserved for internal use, see ReservedShelfPrefix.
+func IsReservedShelfName(name string) bool {
+	return strings.HasPrefix(name, ReservedShelfPrefix)
+}
+
+type reservedShelvesKey struct{}
+
+// ContextWithReservedShelves returns a context that makes ShelfLister implementations list the reserved shelves
+// (see ReservedShelfPrefix) as well. They're hidden by default, so internal bookkeeping doesn't show up
+// when iterating over, exporting or copying all shelves of a store.
+func ContextWithReservedShelves(ctx context.Context) context.Context {
+	return context.WithValue(ctx, reservedShelvesKey{}, true)
+}
+
+// ReservedShelvesIncluded returns whether reserved shelves are listed for the given context, see ContextWithReservedShelves.
+func ReservedShelvesIncluded(ctx context.Context) bool {
+	included, _ := ctx.Value(reservedShelvesKey{}).(bool)
+	return included
+}
+
+// FilterReservedShelves removes the reserved shelves from the given shelf names, unless they're included for the given context
+// (see ContextWithReservedShelves). ShelfLister implementations use it, the given slice is modified.
+func FilterReservedShelves(ctx context.Context, names []string) []string {
+	if ReservedShelvesIncluded(ctx) {
+		return names
+	}
+	result := names[:0]
+	for _, name := range names {
+		if !IsReservedShelfName(name) {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+// InvalidShelf returns a Writer that fails all operations with ErrInvalidShelfName if the given shelf name is invalid
+// (see ValidateShelfName), or nil if it's valid. Backends use it when getting a shelf reader or writer.
+func InvalidShelf(name string) Writer {
+	if err := ValidateShelfName(name); err != nil {
+		return errWriter{err: err}
+	}
+	return nil
+}
+
+// EscapeShelfName maps an arbitrary string (e.g. supplied by a user) to a shelf name that can't collide with the
+// internal shelves of stoabs: every byte other than ASCII letters, digits, '-', '.' and '/' is percent-encoded (e.g. '_' as %5F),
+// so the name never has the ReservedShelfPrefix and never contains special characters. Different strings map to different names.
+// The result is a valid shelf name (see ValidateShelfName) if it's not empty and not too long.
+func EscapeShelfName(name string) string {
+	var result strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if isShelfNameSafe(c) {
+			result.WriteByte(c)
+		} else {
+			_, _ = fmt.Fprintf(&result, "%%%02X", c)
+		}
+	}
+	return result.String()
+}
+
+// UnescapeShelfName returns the string that was escaped by EscapeShelfName.
+func UnescapeShelfName(escaped string) (string, error) {
+	var result strings.Builder
+	for i := 0; i < len(escaped); i++ {
+		c := escaped[i]
+		if c != '%' {
+			result.WriteByte(c)
+			continue
+		}
+		var value byte
+		if i+2 >= len(escaped) || !unhex(escaped[i+1], &value) || !unhex(escaped[i+2], &value) {
+			return "", fmt.Errorf("invalid escape sequence in shelf name %q at offset %d", escaped, i)
+		}
+		result.WriteByte(value)
+		i += 2
+	}
+	return result.String(), nil
+}
+
+func isShelfNameSafe(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '.' || c == '/'
+}
+
+// unhex shifts the value of the given hexadecimal digit (0-9, A-F) into value, returning false if it isn't one.
+func unhex(c byte, value *byte) bool {
+	switch {
+	case c >= '0' && c <= '9':
+		*value = *value<<4 | (c - '0')
+	case c >= 'A' && c <= 'F':
+		*value = *value<<4 | (c - 'A' + 10)
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,119 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestValidateShelfName(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		for _, name := range []string{"shelf", "a.b", "a*", "_stoabs/journal", "äöü", strings.Repeat("a", MaxShelfNameLength)} {
+			assert.NoError(t, ValidateShelfName(name), name)
+		}
+	})
+	t.Run("invalid", func(t *testing.T) {
+		testCases := map[string]string{
+			"": `invalid shelf name "": must not be empty`,
+			strings.Repeat("a", MaxShelfNameLength+1): "must not be longer than 512 bytes",
+			"a\xffb": "must be valid UTF-8",
+			"a\x00b": "must not contain control characters",
+			"a\nb":   "must not contain control characters",
+		}
+		for name, expected := range testCases {
+			err := ValidateShelfName(name)
+
+			assert.ErrorIs(t, err, ErrInvalidShelfName{})
+			assert.ErrorContains(t, err, expected)
+		}
+	})
+}
+
+func TestValidateUserShelfName(t *testing.T) {
+	assert.NoError(t, ValidateUserShelfName("stoabs/journal"))
+	assert.EqualError(t, ValidateUserShelfName("_stoabs/journal"), `invalid shelf name "_stoabs/journal": prefix _stoabs/ is reserved`)
+	assert.ErrorIs(t, ValidateUserShelfName(""), ErrInvalidShelfName{})
+}
+
+func TestInvalidShelf(t *testing.T) {
+	assert.Nil(t, InvalidShelf("shelf"))
+
+	writer := InvalidShelf("")
+
+	require.NotNil(t, writer)
+	err := writer.Put(BytesKey("key"), []byte("value"))
+	assert.ErrorIs(t, err, ErrInvalidShelfName{})
+	assert.False(t, errors.Is(err, ErrDatabase{}))
+	_, err = writer.Get(BytesKey("key"))
+	assert.ErrorIs(t, err, ErrInvalidShelfName{})
+}
+
+func TestFilterReservedShelves(t *testing.T) {
+	names := func() []string {
+		return []string{"a", ReservedShelfPrefix + "journal", "b", "_stoabsx"}
+	}
+
+	t.Run("hidden by default", func(t *testing.T) {
+		ctx := context.Background()
+
+		assert.False(t, ReservedShelvesIncluded(ctx))
+		assert.Equal(t, []string{"a", "b", "_stoabsx"}, FilterReservedShelves(ctx, names()))
+	})
+	t.Run("included", func(t *testing.T) {
+		ctx := ContextWithReservedShelves(context.Background())
+
+		assert.True(t, ReservedShelvesIncluded(ctx))
+		assert.Equal(t, names(), FilterReservedShelves(ctx, names()))
+	})
+}
+
+func TestEscapeShelfName(t *testing.T) {
+	testCases := map[string]string{
+		"shelf":           "shelf",
+		"a-b.c/d":         "a-b.c/d",
+		"_stoabs/journal": "%5Fstoabs/journal",
+		"100%":            "100%25",
+		"a*b":             "a%2Ab",
+		"a b":             "a%20b",
+		"ä":               "%C3%A4",
+		"a\x00":           "a%00",
+		"":                "",
+	}
+	for name, expected := range testCases {
+		escaped := EscapeShelfName(name)
+
+		assert.Equal(t, expected, escaped, name)
+		assert.False(t, IsReservedShelfName(escaped), name)
+		unescaped, err := UnescapeShelfName(escaped)
+		require.NoError(t, err, name)
+		assert.Equal(t, name, unescaped)
+	}
+	t.Run("invalid escape sequence", func(t *testing.T) {
+		for _, escaped := range []string{"a%", "a%2", "a%2g", "a%zz"} {
+			_, err := UnescapeShelfName(escaped)
+
+			assert.ErrorContains(t, err, "invalid escape sequence", escaped)
+		}
+	})
+}
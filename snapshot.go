@@ -0,0 +1,251 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Snapshotter is implemented by KVStores that can provide a long-lived, read-only view of the store, see SnapshotStore.
+type Snapshotter interface {
+	// SnapshotStore returns a read-only KVStore pinned at the current state of the store:
+	// transactions started on it don't see writes to the store that are committed afterward.
+	// Write and WriteShelf return ErrReadOnly. The snapshot must be closed (independently of the store) to release its resources.
+	// Returns a ErrDatabase if unsuccessful.
+	SnapshotStore(ctx context.Context) (KVStore, error)
+}
+
+// SnapshotStore returns a read-only KVStore pinned at the current state of the given store (see Snapshotter),
+// e.g. to generate a consistent report from multiple transactions or to verify a backup.
+// If the store does not implement Snapshotter, it falls back to CopySnapshot if the store implements ShelfLister.
+// Otherwise, it returns errors.ErrUnsupported.
+func SnapshotStore(ctx context.Context, store KVStore) (KVStore, error) {
+	if snapshotter, ok := store.(Snapshotter); ok {
+		return snapshotter.SnapshotStore(ctx)
+	}
+	if _, ok := store.(ShelfLister); !ok {
+		return nil, fmt.Errorf("taking snapshot of %T: %w", store, errors.ErrUnsupported)
+	}
+	return CopySnapshot(ctx, store)
+}
+
+// CopySnapshot copies all shelves of the store (including the reserved shelves, see ContextWithReservedShelves) into memory
+// in a single read transaction, and returns them as read-only KVStore.
+// It's only consistent if the read transaction is isolated from concurrent writes. Snapshotter implementations can use it
+// if the database doesn't support long-lived snapshots. The store must implement ShelfLister.
+// Keys are copied as bytes (see Key.Bytes), unless WithStringKeys is specified.
+func CopySnapshot(ctx context.Context, store KVStore, opts ...SnapshotOption) (KVStore, error) {
+	shelfNames, err := ShelfNames(ContextWithReservedShelves(ctx), store)
+	if err != nil {
+		return nil, err
+	}
+	result := &memorySnapshot{shelves: map[string]*scratchShelf{}, names: shelfNames}
+	for _, opt := range opts {
+		opt(result)
+	}
+	var keyType Key = BytesKey{}
+	if result.stringKeys {
+		keyType = stringKey{}
+	}
+	err = store.Read(ctx, func(tx ReadTx) error {
+		for _, shelfName := range shelfNames {
+			shelf := &scratchShelf{entries: map[string][]byte{}}
+			if err := tx.GetShelfReader(shelfName).Iterate(func(key Key, value []byte) error {
+				return shelf.Put(key, value)
+			}, keyType); err != nil {
+				return err
+			}
+			// sort the keys once, so reading doesn't modify the shelf
+			shelf.sortedKeys()
+			result.shelves[shelfName] = shelf
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// SnapshotOption is an option for CopySnapshot.
+type SnapshotOption func(*memorySnapshot)
+
+// WithStringKeys copies the keys as strings (see Key.String), for stores that store the keys as strings (e.g. Redis).
+// Keys parsed as bytes would have another value than when they were written by a key type that isn't BytesKey (e.g. Uint32Key),
+// while keys copied as strings can be read with the key type they were written with.
+func WithStringKeys() SnapshotOption {
+	return func(snapshot *memorySnapshot) {
+		snapshot.stringKeys = true
+	}
+}
+
+// memorySnapshot is a read-only KVStore holding the shelves in memory, see CopySnapshot.
+type memorySnapshot struct {
+	shelves map[string]*scratchShelf
+	// names holds the names of the shelves in alphabetical order.
+	names []string
+	// stringKeys indicates the shelves hold the keys as string instead of as bytes, see WithStringKeys.
+	stringKeys bool
+	mux        sync.RWMutex
+	closed     bool
+}
+
+func (m *memorySnapshot) Close(_ context.Context) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.closed = true
+	m.shelves = nil
+	return nil
+}
+
+func (m *memorySnapshot) Write(_ context.Context, _ func(WriteTx) error, _ ...TxOption) error {
+	return ErrReadOnly
+}
+
+func (m *memorySnapshot) Read(ctx context.Context, fn func(ReadTx) error) error {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	if m.closed {
+		return ErrStoreIsClosed
+	}
+	if ctx.Err() != nil {
+		return DatabaseError(ctx.Err())
+	}
+	return fn(memorySnapshotTx{snapshot: m})
+}
+
+func (m *memorySnapshot) WriteShelf(_ context.Context, _ string, _ func(Writer) error) error {
+	return ErrReadOnly
+}
+
+func (m *memorySnapshot) ReadShelf(ctx context.Context, shelfName string, fn func(Reader) error) error {
+	return m.Read(ctx, func(tx ReadTx) error {
+		return fn(tx.GetShelfReader(shelfName))
+	})
+}
+
+func (m *memorySnapshot) ShelfNames(ctx context.Context) ([]string, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	if m.closed {
+		return nil, ErrStoreIsClosed
+	}
+	return FilterReservedShelves(ctx, append([]string(nil), m.names...)), nil
+}
+
+type memorySnapshotTx struct {
+	snapshot *memorySnapshot
+}
+
+func (t memorySnapshotTx) GetShelfReader(shelfName string) Reader {
+	shelf, ok := t.snapshot.shelves[shelfName]
+	if !ok {
+		return NilReader{}
+	}
+	if t.snapshot.stringKeys {
+		return stringKeyReader{shelf: shelf}
+	}
+	// only expose the methods of Reader, so it can't be type-asserted to Writer
+	return readOnlyReader{reader: shelf}
+}
+
+func (t memorySnapshotTx) Store() KVStore {
+	return t.snapshot
+}
+
+func (t memorySnapshotTx) Unwrap() interface{} {
+	return nil
+}
+
+// stringKey is the key type CopySnapshot iterates with to copy the keys as strings (see WithStringKeys):
+// it parses the string of a key as BytesKey, so the scratch shelf holds the string.
+type stringKey struct {
+	BytesKey
+}
+
+func (stringKey) FromString(s string) (Key, error) {
+	return BytesKey(s), nil
+}
+
+// stringKeyReader is a read-only shelf of a snapshot holding the keys as strings (see WithStringKeys):
+// keys are looked up by their string, and parsed from their string when iterating.
+type stringKeyReader struct {
+	shelf *scratchShelf
+}
+
+func (r stringKeyReader) Empty() (bool, error) {
+	return r.shelf.Empty()
+}
+
+func (r stringKeyReader) Get(key Key) ([]byte, error) {
+	return r.shelf.Get(BytesKey(key.String()))
+}
+
+func (r stringKeyReader) Iterate(callback CallerFn, keyType Key) error {
+	return r.shelf.Iterate(func(key Key, value []byte) error {
+		parsed, err := keyType.FromString(string(key.Bytes()))
+		if err != nil {
+			return err
+		}
+		return callback(parsed, value)
+	}, BytesKey{})
+}
+
+// Range visits the keys that parse as the type of from, in their byte order.
+func (r stringKeyReader) Range(from Key, to Key, callback CallerFn, stopAtNil bool) error {
+	var keys []Key
+	for k := range r.shelf.entries {
+		key, err := from.FromString(k)
+		if err != nil {
+			// not of the key type, so not in range
+			continue
+		}
+		if bytes.Compare(key.Bytes(), from.Bytes()) >= 0 && bytes.Compare(key.Bytes(), to.Bytes()) < 0 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i].Bytes(), keys[j].Bytes()) < 0
+	})
+	var prevKey Key
+	for _, key := range keys {
+		if stopAtNil && prevKey != nil && !prevKey.Next().Equals(key) {
+			// gap found, stop here
+			return nil
+		}
+		value, err := r.shelf.Get(BytesKey(key.String()))
+		if err != nil {
+			return err
+		}
+		if err := callback(key, value); err != nil {
+			return err
+		}
+		prevKey = key
+	}
+	return nil
+}
+
+func (r stringKeyReader) Stats() ShelfStats {
+	return r.shelf.Stats()
+}
@@ -0,0 +1,142 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"testing"
+)
+
+func TestSnapshotStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("copies the shelves if the store isn't a Snapshotter", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		store := struct {
+			*MockKVStore
+			*MockShelfLister
+		}{NewMockKVStore(ctrl), NewMockShelfLister(ctrl)}
+		tx := NewMockReadTx(ctrl)
+		reader := NewMockReader(ctrl)
+		store.MockShelfLister.EXPECT().ShelfNames(ContextWithReservedShelves(ctx)).Return([]string{"a"}, nil)
+		store.MockKVStore.EXPECT().Read(ctx, gomock.Any()).DoAndReturn(func(_ context.Context, fn func(ReadTx) error) error {
+			return fn(tx)
+		})
+		tx.EXPECT().GetShelfReader("a").Return(reader)
+		reader.EXPECT().Iterate(gomock.Any(), BytesKey{}).DoAndReturn(func(callback CallerFn, _ Key) error {
+			return callback(BytesKey("key"), []byte("value"))
+		})
+
+		snapshot, err := SnapshotStore(ctx, store)
+		require.NoError(t, err)
+
+		var value []byte
+		err = snapshot.ReadShelf(ctx, "a", func(reader Reader) error {
+			value, err = reader.Get(BytesKey("key"))
+			return err
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []byte("value"), value)
+		err = snapshot.ReadShelf(ctx, "b", func(reader Reader) error {
+			empty, err := reader.Empty()
+			assert.True(t, empty)
+			_, isWriter := reader.(Writer)
+			assert.False(t, isWriter)
+			return err
+		})
+		require.NoError(t, err)
+		shelfNames, err := ShelfNames(ctx, snapshot)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a"}, shelfNames)
+		assert.ErrorIs(t, snapshot.WriteShelf(ctx, "a", nil), ErrReadOnly)
+		assert.ErrorIs(t, snapshot.Write(ctx, nil), ErrReadOnly)
+
+		require.NoError(t, snapshot.Close(ctx))
+		assert.ErrorIs(t, snapshot.Read(ctx, nil), ErrStoreIsClosed)
+		_, err = ShelfNames(ctx, snapshot)
+		assert.ErrorIs(t, err, ErrStoreIsClosed)
+	})
+	t.Run("copies keys as strings", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		store := struct {
+			*MockKVStore
+			*MockShelfLister
+		}{NewMockKVStore(ctrl), NewMockShelfLister(ctrl)}
+		tx := NewMockReadTx(ctrl)
+		reader := NewMockReader(ctrl)
+		store.MockShelfLister.EXPECT().ShelfNames(ContextWithReservedShelves(ctx)).Return([]string{"a"}, nil)
+		store.MockKVStore.EXPECT().Read(ctx, gomock.Any()).DoAndReturn(func(_ context.Context, fn func(ReadTx) error) error {
+			return fn(tx)
+		})
+		tx.EXPECT().GetShelfReader("a").Return(reader)
+		reader.EXPECT().Iterate(gomock.Any(), gomock.Any()).DoAndReturn(func(callback CallerFn, keyType Key) error {
+			for _, k := range []string{"12", "300", "other"} {
+				key, err := keyType.FromString(k)
+				if err != nil {
+					return err
+				}
+				if err := callback(key, []byte(k)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+
+		snapshot, err := CopySnapshot(ctx, store, WithStringKeys())
+		require.NoError(t, err)
+		defer snapshot.Close(ctx)
+
+		var ranged []Key
+		err = snapshot.ReadShelf(ctx, "a", func(reader Reader) error {
+			value, err := reader.Get(Uint32Key(300))
+			require.NoError(t, err)
+			assert.Equal(t, []byte("300"), value)
+			_, isWriter := reader.(Writer)
+			assert.False(t, isWriter)
+			return reader.Range(Uint32Key(0), Uint32Key(1000), func(key Key, _ []byte) error {
+				ranged = append(ranged, key)
+				return nil
+			}, false)
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []Key{Uint32Key(12), Uint32Key(300)}, ranged)
+	})
+	t.Run("copy fails", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		store := struct {
+			*MockKVStore
+			*MockShelfLister
+		}{NewMockKVStore(ctrl), NewMockShelfLister(ctrl)}
+		store.MockShelfLister.EXPECT().ShelfNames(ContextWithReservedShelves(ctx)).Return([]string{"a"}, nil)
+		store.MockKVStore.EXPECT().Read(ctx, gomock.Any()).Return(errors.New("failed"))
+
+		_, err := SnapshotStore(ctx, store)
+
+		assert.EqualError(t, err, "failed")
+	})
+	t.Run("unsupported", func(t *testing.T) {
+		_, err := SnapshotStore(ctx, NewMockKVStore(gomock.NewController(t)))
+
+		assert.ErrorIs(t, err, errors.ErrUnsupported)
+	})
+}
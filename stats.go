@@ -0,0 +1,134 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// StoreStats contains statistics about a store. Where the backend supports it (BBolt), they're read from a
+// single consistent snapshot of the store. Statistics the backend doesn't support are zero.
+type StoreStats struct {
+	// Size is the size of the database in bytes: the size of the database file (BBolt), of the LSM tree and value log (Badger),
+	// or the memory used by the Redis server.
+	Size uint64
+	// FreePages is the number of free pages in the database file, which are reused before the file grows (BBolt).
+	FreePages uint64
+	// MemoryUsage is the memory used by the Redis server in bytes.
+	MemoryUsage uint64
+	// MemoryLimit is the maximum amount of memory the Redis server may use in bytes (maxmemory), 0 if it's unlimited.
+	MemoryLimit uint64
+	// EvictionPolicy is the policy the Redis server uses to evict keys when it reaches its memory limit (maxmemory-policy).
+	EvictionPolicy string
+	// Shelves is the number of shelves in the store (BBolt, Redis).
+	Shelves int
+	// OpenTransactions is the number of in-flight transactions, not counting the one that reads the statistics.
+	OpenTransactions int
+	// LastCompaction is the time the database was last compacted (BBolt, when compacted using the stoabs compact command).
+	LastCompaction time.Time
+	// LastBackup is the time the last backup (snapshot) of the database was made (Redis).
+	LastBackup time.Time
+	// Pages contains page-level statistics of the database file (BBolt), nil if the backend doesn't support them.
+	Pages *PageStats
+	// Disk contains statistics about writing committed transactions to disk (BBolt), nil if the backend doesn't support them.
+	Disk *DiskStats
+	// Conflicts is the number of write transactions that failed with ErrConflict since the store was opened (Badger).
+	Conflicts uint64
+	// Evictions is the number of entries the database evicted since the store was opened, while watching evictions (Redis, see WatchEvictions).
+	Evictions uint64
+}
+
+// PageStats contains page-level statistics of a database file that consists of pages organized in a B+tree (BBolt),
+// which help tuning the fill percent and diagnosing write amplification.
+// The counters and durations are accumulated over all transactions since the store was opened.
+type PageStats struct {
+	// PageAllocations is the number of page allocations.
+	PageAllocations uint64
+	// PageAllocatedBytes is the total number of bytes allocated for pages.
+	PageAllocatedBytes uint64
+	// NodeAllocations is the number of node allocations.
+	NodeAllocations uint64
+	// NodeDereferences is the number of node dereferences.
+	NodeDereferences uint64
+	// Rebalances is the number of node rebalances.
+	Rebalances uint64
+	// RebalanceDuration is the total time spent rebalancing nodes.
+	RebalanceDuration time.Duration
+	// Splits is the number of nodes split.
+	Splits uint64
+	// Spills is the number of nodes spilled (written to dirty pages) when committing.
+	Spills uint64
+	// SpillDuration is the total time spent spilling nodes.
+	SpillDuration time.Duration
+	// Writes is the number of page writes performed.
+	Writes uint64
+	// WriteDuration is the total time spent writing pages to disk.
+	WriteDuration time.Duration
+	// FreeBytes is the number of bytes allocated in free pages.
+	FreeBytes uint64
+	// FreelistBytes is the number of bytes used by the freelist.
+	FreelistBytes uint64
+	// ReadTransactions is the number of read transactions started.
+	ReadTransactions uint64
+}
+
+// DiskStats contains statistics about writing committed transactions to disk, which help evaluating the cost of
+// durability (e.g. stoabs.WithNoSync, or batching writes). The counters are accumulated over all write transactions
+// committed since the store was opened.
+type DiskStats struct {
+	// Commits is the number of committed write transactions.
+	Commits uint64
+	// BytesPut is the number of logical bytes (keys and values) put by the committed transactions.
+	BytesPut uint64
+	// BytesWritten is the number of bytes written to the database file when committing.
+	BytesWritten uint64
+	// Syncs is the number of times the database file was synced (fdatasync) when committing, which is zero when syncing is disabled.
+	Syncs uint64
+	// SyncDuration is the total time spent flushing committed transactions to disk: writing the pages and syncing the file.
+	SyncDuration time.Duration
+}
+
+// WriteAmplification returns the number of bytes written to disk per logical byte put, or 0 if nothing was put.
+func (s DiskStats) WriteAmplification() float64 {
+	if s.BytesPut == 0 {
+		return 0
+	}
+	return float64(s.BytesWritten) / float64(s.BytesPut)
+}
+
+// StatsReader is implemented by stores that report statistics about the store as a whole.
+// Statistics of a single shelf are available through Reader.Stats.
+type StatsReader interface {
+	// Stats returns the statistics of the store.
+	// Returns a ErrDatabase if unsuccessful.
+	Stats(ctx context.Context) (StoreStats, error)
+}
+
+// Stats returns the statistics of the given store.
+// If the store does not implement StatsReader, it returns errors.ErrUnsupported.
+func Stats(ctx context.Context, store Store) (StoreStats, error) {
+	reader, ok := store.(StatsReader)
+	if !ok {
+		return StoreStats{}, fmt.Errorf("reading stats of %T: %w", store, errors.ErrUnsupported)
+	}
+	return reader.Stats(ctx)
+}
@@ -0,0 +1,58 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	ctx := context.Background()
+	t.Run("supported", func(t *testing.T) {
+		store := struct {
+			KVStore
+			StatsReader
+		}{StatsReader: statsReader{Shelves: 2}}
+
+		stats, err := Stats(ctx, store)
+
+		assert.NoError(t, err)
+		assert.Equal(t, StoreStats{Shelves: 2}, stats)
+	})
+	t.Run("unsupported", func(t *testing.T) {
+		_, err := Stats(ctx, NewMockKVStore(gomock.NewController(t)))
+
+		assert.ErrorIs(t, err, errors.ErrUnsupported)
+	})
+}
+
+func TestDiskStats_WriteAmplification(t *testing.T) {
+	assert.Equal(t, 2.5, DiskStats{BytesPut: 100, BytesWritten: 250}.WriteAmplification())
+	assert.Equal(t, 0.0, DiskStats{BytesWritten: 250}.WriteAmplification())
+}
+
+type statsReader StoreStats
+
+func (s statsReader) Stats(_ context.Context) (StoreStats, error) {
+	return StoreStats(s), nil
+}
@@ -22,6 +22,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -47,9 +51,21 @@ func (e ErrDatabase) Error() string {
 	return fmt.Sprintf("database error: %s", e.error)
 }
 
+// Is returns true for ErrDatabase{}, which matches all database errors (and the other way around). A specific database error
+// (e.g. ErrStoreIsClosed) only matches itself, and ErrTimeout also matches database errors caused by context.DeadlineExceeded.
 func (e ErrDatabase) Is(other error) bool {
-	_, ok := other.(ErrDatabase)
-	return ok
+	o, ok := other.(ErrDatabase)
+	if !ok {
+		return false
+	}
+	if o.error == nil || e.error == nil {
+		return true
+	}
+	if other == ErrTimeout {
+		return errors.Is(e.error, context.DeadlineExceeded)
+	}
+	// comparing errors of a type that isn't comparable (e.g. a slice) panics
+	return reflect.TypeOf(o.error).Comparable() && o.error == e.error
 }
 func (e ErrDatabase) Unwrap() error {
 	return e.error
@@ -61,6 +77,45 @@ var ErrStoreIsClosed = DatabaseError(errors.New("database not open"))
 // ErrCommitFailed is returned when the commit of transaction fails. Is also a ErrDatabase.
 var ErrCommitFailed = DatabaseError(errors.New("unable to commit transaction"))
 
+// ErrConflict is returned when a transaction can't be committed because a concurrent transaction modified the data it read or wrote.
+// Retrying the transaction may succeed. Is also a ErrCommitFailed.
+var ErrConflict = fmt.Errorf("%w: conflicting concurrent transaction", ErrCommitFailed)
+
+// ErrTimeout is returned when an operation didn't complete in time, because the deadline of its context passed
+// or a lock couldn't be acquired within the lock acquisition timeout (see WithLockAcquireTimeout).
+// All database errors caused by context.DeadlineExceeded match it. Is also a ErrDatabase.
+var ErrTimeout = DatabaseError(errors.New("operation timed out"))
+
+// ErrLockTimeout is returned (as cause of an ErrDatabase) when a write lock couldn't be acquired in time,
+// because the lock acquisition timeout (see WithLockAcquireTimeout) or the deadline of the transaction context passed.
+// It matches any other ErrLockTimeout, and a database error caused by it also matches ErrTimeout.
+type ErrLockTimeout struct {
+	// Lock is the name of the lock that couldn't be acquired.
+	Lock string
+	// Holder is the label (see WithLabel) of the transaction holding the lock.
+	// It's empty if the holder is unknown or didn't specify a label.
+	Holder string
+}
+
+func (e ErrLockTimeout) Error() string {
+	holder := e.Holder
+	if holder == "" {
+		holder = "unknown"
+	}
+	return fmt.Sprintf("timed out acquiring %s (held by %s)", e.Lock, holder)
+}
+
+// Is returns true for any ErrLockTimeout.
+func (e ErrLockTimeout) Is(other error) bool {
+	_, ok := other.(ErrLockTimeout)
+	return ok
+}
+
+// Unwrap returns context.DeadlineExceeded, so database errors caused by a lock timeout match ErrTimeout.
+func (e ErrLockTimeout) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
 // ErrKeyNotFound is returned when the requested key does not exist
 var ErrKeyNotFound = errors.New("key not found")
 
@@ -68,6 +123,8 @@ const DefaultTransactionTimeout = 30 * time.Second
 
 const defaultLockAcquisitionTimeout = 3 * time.Second
 
+const defaultLockLease = 10 * time.Second
+
 // KVStore defines the interface for a key-value store.
 // Writing to it is done in callbacks passed to the Write-functions. If the callback returns an error, the transaction is rolled back.
 // Methods return a ErrDatabase when the context has been cancelled or timed-out.
@@ -99,6 +156,24 @@ type Config struct {
 	Log                *logrus.Logger
 	NoSync             bool
 	LockAcquireTimeout time.Duration
+	LockLease          time.Duration
+	KeyPrefix          string
+	// ValueCloning specifies whether readers return copies of values, see WithValueCloning. Nil means the backend's default.
+	ValueCloning     *bool
+	OrderedIteration bool
+	// AppendOptimized holds the shelves optimized for appending, see WithAppendOptimized. An empty, non-nil slice means all shelves.
+	AppendOptimized []string
+	// KeyIndexBudget is the maximum memory in bytes used by the in-memory key index, see WithKeyIndex. Zero disables it.
+	KeyIndexBudget uint64
+	// Validators holds the validators per shelf, see WithValidator.
+	Validators map[string][]Validator
+	// WriteOnce holds the write-once shelves, see WithWriteOnce.
+	WriteOnce []string
+	// Clock is used for time-dependent behavior, see WithClock.
+	Clock Clock
+	// StaleLockRecovery is the time to wait for the file lock before checking whether its holder is still alive,
+	// see WithStaleLockRecovery. Zero disables it.
+	StaleLockRecovery time.Duration
 }
 
 // DefaultConfig returns the default configuration.
@@ -106,6 +181,8 @@ func DefaultConfig() Config {
 	return Config{
 		Log:                logrus.StandardLogger(),
 		LockAcquireTimeout: defaultLockAcquisitionTimeout,
+		LockLease:          defaultLockLease,
+		Clock:              SystemClock,
 	}
 }
 
@@ -124,6 +201,124 @@ func WithNoSync() Option {
 	}
 }
 
+// WithValueCloning specifies whether the values returned by readers (Get, Iterate, Range) are copies owned by the caller,
+// or slices owned by the database. Values owned by the database are only valid within the transaction (Get) or callback
+// (Iterate, Range) they were read in, and must never be modified or retained (copy them if they're needed afterwards).
+// Not cloning avoids copying and garbage collection overhead for large reads. If not specified, the backend's default applies:
+//   - BBolt clones values by default. Without cloning, it returns its memory-mapped data, valid within the transaction.
+//   - Badger clones values returned by Get (always, since Badger only lends them within a callback), but not values
+//     passed to Iterate and Range callbacks by default, which are then only valid within the callback.
+//   - Redis always returns values owned by the caller, so it ignores this option.
+func WithValueCloning(enabled bool) Option {
+	return func(config *Config) {
+		config.ValueCloning = &enabled
+	}
+}
+
+// WithZeroCopyReads specifies that values read from the database are passed to callers without copying them.
+//
+// Deprecated: use WithValueCloning(false), which documents the lifetime of the values per backend.
+func WithZeroCopyReads() Option {
+	return WithValueCloning(false)
+}
+
+// CloneValues returns whether values returned by readers must be cloned, given the default of the backend (see WithValueCloning).
+func (c Config) CloneValues(backendDefault bool) bool {
+	if c.ValueCloning == nil {
+		return backendDefault
+	}
+	return *c.ValueCloning
+}
+
+// WithKeyIndex specifies that the store keeps the keys of all shelves in memory, using at most the given number of bytes.
+// The index is built when the store is opened and maintained when transactions commit, so checking whether a key exists
+// (see Exists), counting keys (see Aggregate with Count) and the number of entries of Reader.Stats don't read the database.
+// If the keys don't fit in the budget, the index is dropped (logging a warning) and the database is read instead.
+// Only read transactions use the index, and writes made through the database of Unwrap bypass it.
+// Support depends on the underlying database: BBolt supports it, other databases ignore it.
+func WithKeyIndex(memoryBudget uint64) Option {
+	return func(config *Config) {
+		config.KeyIndexBudget = memoryBudget
+	}
+}
+
+// WithOrderedIteration specifies that Reader.Iterate must visit keys in their byte order (like Range),
+// for backends that don't do so natively. For Redis, this means all keys of the shelf are scanned and sorted before
+// the first callback, which needs memory for all keys of the shelf.
+func WithOrderedIteration() Option {
+	return func(config *Config) {
+		config.OrderedIteration = true
+	}
+}
+
+// WithAppendOptimized specifies that the given shelves (or all shelves, if none are given) are mostly appended to,
+// i.e. written with monotonically increasing keys (e.g. sequence numbers or TimeKey). Pages of these shelves are filled
+// completely instead of half when split, which avoids wasting half of every page and splitting pages twice as often.
+// Writing keys that aren't increasing to such a shelf splits full pages, so it shouldn't be used for other shelves.
+// Support depends on the underlying database: BBolt supports it, other databases ignore it.
+func WithAppendOptimized(shelfNames ...string) Option {
+	return func(config *Config) {
+		if len(shelfNames) == 0 {
+			config.AppendOptimized = []string{}
+		} else if config.AppendOptimized == nil || len(config.AppendOptimized) > 0 {
+			config.AppendOptimized = append(config.AppendOptimized, shelfNames...)
+		}
+	}
+}
+
+// IsAppendOptimized returns whether the given shelf is optimized for appending, see WithAppendOptimized.
+func (c Config) IsAppendOptimized(shelfName string) bool {
+	if c.AppendOptimized == nil {
+		return false
+	}
+	return len(c.AppendOptimized) == 0 || slices.Contains(c.AppendOptimized, shelfName)
+}
+
+// WithLockLease overrides the default lease of distributed locks (e.g. Redis).
+// The lease is renewed while the lock is held, so it only determines how long a lock outlives a crashed holder.
+func WithLockLease(value time.Duration) Option {
+	return func(config *Config) {
+		config.LockLease = value
+	}
+}
+
+// WithStaleLockRecovery specifies that if the database file is still locked after the given time when opening it,
+// the process holding the lock is looked up in the owner file next to the database file (which is written when opened).
+// If it belongs to a process on the same host that no longer runs, the stale lock (e.g. left behind on a network file system)
+// is recovered by replacing the database file with a copy that isn't locked. Otherwise, opening keeps waiting for the lock.
+// All processes opening the database file must enable it, since the owner file is only maintained with this option.
+// Support depends on the underlying database: BBolt supports it, other databases ignore it.
+func WithStaleLockRecovery(wait time.Duration) Option {
+	return func(config *Config) {
+		config.StaleLockRecovery = wait
+	}
+}
+
+// WithKeyPrefix specifies a prefix that is transparently prepended to all shelf names,
+// so multiple applications or environments can share a single database without colliding.
+// The shelves of other prefixes aren't visible to the store, e.g. when listing shelves.
+// The prefix is prepended as-is, so it should end with a separator (e.g. "app1/").
+// Support depends on the underlying database.
+func WithKeyPrefix(prefix string) Option {
+	return func(config *Config) {
+		config.KeyPrefix = prefix
+	}
+}
+
+// ShelfName returns the name under which the given shelf is stored, taking KeyPrefix into account.
+func (c Config) ShelfName(name string) string {
+	return c.KeyPrefix + name
+}
+
+// TrimShelfName returns the name of the shelf stored under the given name, taking KeyPrefix into account.
+// It returns false if the stored shelf doesn't have the KeyPrefix.
+func (c Config) TrimShelfName(storedName string) (string, bool) {
+	if !strings.HasPrefix(storedName, c.KeyPrefix) {
+		return "", false
+	}
+	return storedName[len(c.KeyPrefix):], true
+}
+
 // WithLogger overrides the default logger.
 func WithLogger(log *logrus.Logger) Option {
 	return func(config *Config) {
@@ -150,12 +345,17 @@ type Reader interface {
 	// If the key does not exist it returns ErrKeyNotFound.
 	// Returns a ErrDatabase if unsuccessful.
 	Get(key Key) ([]byte, error)
-	// Iterate walks over all key/value pairs for this shelf. Ordering is not guaranteed.
+	// Iterate walks over all key/value pairs for this shelf. Ordering is not guaranteed:
+	// BBolt and Badger iterate in the byte order of the keys, Redis in an unspecified order which can differ between calls.
+	// Use WithOrderedIteration if callers depend on the byte order.
 	// The caller will have to supply the correct key type, such that the keys can be parsed.
+	// If the transaction's context is cancelled, iteration stops before the next callback and a ErrDatabase is returned.
 	Iterate(callback CallerFn, keyType Key) error
 	// Range calls the callback for each key/value pair on this shelf from (inclusive) and to (exclusive) given keys.
-	// Ordering is guaranteed and determined by the type of Key given.
+	// Ordering is guaranteed for all backends: keys are visited in their byte order (see Key.Bytes()),
+	// which for Uint32Key and Uint64Key is their numerical order.
 	// If stopAtNil is true the operation stops when a non-existing key is encountered.
+	// If the transaction's context is cancelled, the operation stops before the next callback and a ErrDatabase is returned.
 	Range(from Key, to Key, callback CallerFn, stopAtNil bool) error
 	// Stats returns statistics about the shelf.
 	Stats() ShelfStats
@@ -175,11 +375,69 @@ type Writer interface {
 
 type Store interface {
 	// Close releases all resources associated with the store. It is safe to call multiple (subsequent) times.
-	// The context being passed can be used to specify a timeout for the close operation.
-	// Returns a ErrDatabase if unsuccessful,
+	// New transactions fail with ErrStoreIsClosed as soon as Close is called, while Close waits for in-flight transactions to finish.
+	// If the passed context is done before they finished, in-flight transactions are aborted: their context is cancelled,
+	// so write transactions aren't committed, and the resources are released once they returned.
+	// Returns a ErrDatabase if unsuccessful (e.g. when in-flight transactions were aborted).
 	Close(ctx context.Context) error
 }
 
+// ShelfLister is implemented by KVStores that can enumerate the shelves they contain.
+type ShelfLister interface {
+	// ShelfNames returns the names of all shelves in the store, sorted alphabetically.
+	// Reserved shelves (see ReservedShelfPrefix) are omitted, unless the context includes them (see ContextWithReservedShelves).
+	// Returns a ErrDatabase if unsuccessful.
+	ShelfNames(ctx context.Context) ([]string, error)
+}
+
+// ShelfNames returns the names of all shelves in the given store.
+// If the store does not implement ShelfLister, it returns errors.ErrUnsupported.
+func ShelfNames(ctx context.Context, store KVStore) ([]string, error) {
+	lister, ok := store.(ShelfLister)
+	if !ok {
+		return nil, fmt.Errorf("listing shelves of %T: %w", store, errors.ErrUnsupported)
+	}
+	return lister.ShelfNames(ctx)
+}
+
+// Locker is implemented by stores that support pessimistic locking of individual keys.
+type Locker interface {
+	// LockKeys acquires an exclusive lock on each of the given keys of the specified shelf,
+	// blocking until all locks are acquired or the context is cancelled.
+	// Keys are locked in a fixed order, so callers locking overlapping sets of keys can't deadlock.
+	// The locks are advisory: they only exclude other callers of LockKeys, not transactions writing the keys.
+	// They're not tied to a transaction, which allows read-modify-write flows to span multiple transactions.
+	// The returned function releases the locks, it must be called exactly once.
+	LockKeys(ctx context.Context, shelfName string, keys ...Key) (release func(), err error)
+}
+
+// LockKeys locks the given keys of the specified shelf in the given store.
+// If the store does not implement Locker, it returns errors.ErrUnsupported.
+func LockKeys(ctx context.Context, store KVStore, shelfName string, keys ...Key) (func(), error) {
+	locker, ok := store.(Locker)
+	if !ok {
+		return nil, fmt.Errorf("locking keys of %T: %w", store, errors.ErrUnsupported)
+	}
+	return locker.LockKeys(ctx, shelfName, keys...)
+}
+
+// Merger is implemented by Writers that support merge operators, which combine an operand with the current value of a key
+// (e.g. adding to a counter) without the caller having to read the value first, see the merge package.
+type Merger interface {
+	// Merge combines the operand with the current value of the key, using the merge operator of the shelf.
+	Merge(key Key, operand []byte) error
+}
+
+// Merge merges the operand into the value of the given key.
+// If the writer does not implement Merger, it returns errors.ErrUnsupported.
+func Merge(writer Writer, key Key, operand []byte) error {
+	merger, ok := writer.(Merger)
+	if !ok {
+		return fmt.Errorf("merging into %T: %w", writer, errors.ErrUnsupported)
+	}
+	return merger.Merge(key, operand)
+}
+
 // TxOption holds options for store transactions.
 type TxOption interface{}
 
@@ -203,6 +461,59 @@ func WithWriteLock() TxOption {
 	return WriteLockOption{}
 }
 
+// ShelfLockOption see WithShelfLock
+type ShelfLockOption struct {
+	shelves []string
+}
+
+// Shelves returns the sorted, deduplicated names of all shelves specified with WithShelfLock.
+func (w ShelfLockOption) Shelves(opts []TxOption) []string {
+	var result []string
+	for _, opt := range opts {
+		if curr, ok := opt.(ShelfLockOption); ok {
+			result = append(result, curr.shelves...)
+		}
+	}
+	sort.Strings(result)
+	return slices.Compact(result)
+}
+
+// WithShelfLock is a transaction option that acquires a write lock for each of the given shelves,
+// making sure there are no concurrent writeable transactions locking any of the same shelves.
+// Transactions locking disjoint shelves don't block each other, unlike transactions specifying WithWriteLock.
+// Shelf locks are always acquired in the same (sorted) order to prevent deadlocks between transactions.
+// The locks are released when the transaction finishes in any way (commit/rollback).
+// Shelf locks and the store-wide lock are independent: a transaction holding a shelf lock doesn't block a transaction
+// specifying WithWriteLock (and vice versa), so writers of a shelf should consistently use one or the other.
+// If WithWriteLock is specified as well, only the store-wide lock is acquired.
+func WithShelfLock(shelfNames ...string) TxOption {
+	return ShelfLockOption{shelves: shelfNames}
+}
+
+// LabelOption see WithLabel
+type LabelOption struct {
+	label string
+}
+
+// Label returns the label specified with WithLabel, or an empty string if there is none.
+// If multiple labels are specified, the last one is returned.
+func (o LabelOption) Label(opts []TxOption) string {
+	var result string
+	for _, opt := range opts {
+		if curr, ok := opt.(LabelOption); ok {
+			result = curr.label
+		}
+	}
+	return result
+}
+
+// WithLabel is a transaction option that labels the transaction, e.g. with the name of the operation or request it belongs to.
+// While the transaction holds a write lock (see WithWriteLock and WithShelfLock), the label is recorded as the lock's holder,
+// so transactions that time out acquiring the lock can report who's holding it (see ErrLockTimeout).
+func WithLabel(label string) TxOption {
+	return LabelOption{label: label}
+}
+
 // AfterCommitOption see AfterCommit
 type AfterCommitOption struct {
 	fn func()
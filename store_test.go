@@ -19,9 +19,13 @@
 package stoabs
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"strings"
 	"testing"
 	"time"
 )
@@ -38,11 +42,96 @@ func TestAcquireLockTimeout(t *testing.T) {
 	assert.Equal(t, time.Hour, cfg.LockAcquireTimeout)
 }
 
+func TestLockLease(t *testing.T) {
+	cfg := DefaultConfig()
+	WithLockLease(time.Minute)(&cfg)
+	assert.Equal(t, time.Minute, cfg.LockLease)
+}
+
+func TestValueCloning(t *testing.T) {
+	t.Run("backend default", func(t *testing.T) {
+		cfg := DefaultConfig()
+		assert.True(t, cfg.CloneValues(true))
+		assert.False(t, cfg.CloneValues(false))
+	})
+	t.Run("enabled", func(t *testing.T) {
+		cfg := DefaultConfig()
+		WithValueCloning(true)(&cfg)
+		assert.True(t, cfg.CloneValues(false))
+	})
+	t.Run("disabled", func(t *testing.T) {
+		cfg := DefaultConfig()
+		WithValueCloning(false)(&cfg)
+		assert.False(t, cfg.CloneValues(true))
+	})
+	t.Run("zero-copy reads", func(t *testing.T) {
+		cfg := DefaultConfig()
+		WithZeroCopyReads()(&cfg)
+		assert.False(t, cfg.CloneValues(true))
+	})
+}
+
+func TestAppendOptimized(t *testing.T) {
+	t.Run("disabled by default", func(t *testing.T) {
+		assert.False(t, DefaultConfig().IsAppendOptimized("a"))
+	})
+	t.Run("all shelves", func(t *testing.T) {
+		cfg := DefaultConfig()
+		WithAppendOptimized()(&cfg)
+		assert.True(t, cfg.IsAppendOptimized("a"))
+	})
+	t.Run("specific shelves", func(t *testing.T) {
+		cfg := DefaultConfig()
+		WithAppendOptimized("a")(&cfg)
+		WithAppendOptimized("b")(&cfg)
+		assert.True(t, cfg.IsAppendOptimized("a"))
+		assert.True(t, cfg.IsAppendOptimized("b"))
+		assert.False(t, cfg.IsAppendOptimized("c"))
+	})
+	t.Run("all shelves after specific shelves", func(t *testing.T) {
+		cfg := DefaultConfig()
+		WithAppendOptimized("a")(&cfg)
+		WithAppendOptimized()(&cfg)
+		WithAppendOptimized("b")(&cfg)
+		assert.True(t, cfg.IsAppendOptimized("c"))
+	})
+}
+
 func TestWriteLockOption(t *testing.T) {
 	assert.True(t, WriteLockOption{}.Enabled([]TxOption{WithWriteLock()}))
 	assert.False(t, WriteLockOption{}.Enabled([]TxOption{}))
 }
 
+func TestShelfLockOption(t *testing.T) {
+	opts := []TxOption{WithShelfLock("b", "a"), WithWriteLock(), WithShelfLock("c", "a")}
+	assert.Equal(t, []string{"a", "b", "c"}, ShelfLockOption{}.Shelves(opts))
+	assert.Empty(t, ShelfLockOption{}.Shelves([]TxOption{WithWriteLock()}))
+}
+
+func TestLabelOption(t *testing.T) {
+	assert.Equal(t, "b", LabelOption{}.Label([]TxOption{WithLabel("a"), WithWriteLock(), WithLabel("b")}))
+	assert.Empty(t, LabelOption{}.Label([]TxOption{WithWriteLock()}))
+}
+
+func TestErrLockTimeout(t *testing.T) {
+	err := fmt.Errorf("unable to obtain lock: %w", DatabaseError(ErrLockTimeout{Lock: "write lock", Holder: "tx-1"}))
+
+	t.Run("matches any ErrLockTimeout", func(t *testing.T) {
+		assert.ErrorIs(t, err, ErrLockTimeout{})
+		var lockTimeout ErrLockTimeout
+		require.ErrorAs(t, err, &lockTimeout)
+		assert.Equal(t, "tx-1", lockTimeout.Holder)
+	})
+	t.Run("is a ErrTimeout", func(t *testing.T) {
+		assert.ErrorIs(t, err, ErrTimeout)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+	t.Run("error message", func(t *testing.T) {
+		assert.EqualError(t, err, "unable to obtain lock: database error: timed out acquiring write lock (held by tx-1)")
+		assert.EqualError(t, ErrLockTimeout{Lock: "write lock"}, "timed out acquiring write lock (held by unknown)")
+	})
+}
+
 func TestDatabaseError(t *testing.T) {
 	t.Run("wraps db errors", func(t *testing.T) {
 		assert.ErrorAs(t, ErrStoreIsClosed, new(ErrDatabase), "ErrStoreIsClosed should be a ErrDatabase")
@@ -62,6 +151,43 @@ func TestDatabaseError(t *testing.T) {
 	})
 }
 
+func TestErrDatabase_Is(t *testing.T) {
+	t.Run("ErrDatabase{} matches all database errors", func(t *testing.T) {
+		assert.ErrorIs(t, ErrStoreIsClosed, ErrDatabase{})
+		assert.ErrorIs(t, ErrConflict, ErrDatabase{})
+		assert.ErrorIs(t, DatabaseError(errors.New("other")), ErrDatabase{})
+	})
+	t.Run("specific database errors only match themselves", func(t *testing.T) {
+		assert.NotErrorIs(t, ErrStoreIsClosed, ErrCommitFailed)
+		assert.NotErrorIs(t, ErrCommitFailed, ErrStoreIsClosed)
+		assert.NotErrorIs(t, DatabaseError(errors.New("other")), ErrTimeout)
+	})
+	t.Run("ErrConflict is a ErrCommitFailed", func(t *testing.T) {
+		assert.ErrorIs(t, ErrConflict, ErrCommitFailed)
+		assert.NotErrorIs(t, ErrCommitFailed, ErrConflict)
+	})
+	t.Run("errors of a type that isn't comparable", func(t *testing.T) {
+		err := DatabaseError(uncomparableError{"a"})
+
+		assert.ErrorIs(t, err, ErrDatabase{})
+		assert.False(t, err.(ErrDatabase).Is(DatabaseError(uncomparableError{"a"})))
+		assert.NotErrorIs(t, err, ErrStoreIsClosed)
+		assert.NotErrorIs(t, ErrStoreIsClosed, err)
+	})
+	t.Run("ErrTimeout matches database errors caused by deadline", func(t *testing.T) {
+		assert.ErrorIs(t, DatabaseError(context.DeadlineExceeded), ErrTimeout)
+		assert.ErrorIs(t, DatabaseError(fmt.Errorf("lock: %w", context.DeadlineExceeded)), ErrTimeout)
+		assert.NotErrorIs(t, DatabaseError(context.Canceled), ErrTimeout)
+	})
+}
+
+// uncomparableError is an error of a type that can't be compared using ==.
+type uncomparableError []string
+
+func (u uncomparableError) Error() string {
+	return strings.Join(u, ", ")
+}
+
 func TestNewErrorWriter(t *testing.T) {
 	t.Run("it wraps an DatabaseError", func(t *testing.T) {
 		writer := NewErrorWriter(errors.New("test"))
@@ -87,3 +213,75 @@ func TestNilReader_Empty(t *testing.T) {
 		assert.True(t, data)
 	})
 }
+
+func TestShelfNames(t *testing.T) {
+	t.Run("not supported", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+
+		names, err := ShelfNames(context.Background(), NewMockKVStore(ctrl))
+
+		assert.ErrorIs(t, err, errors.ErrUnsupported)
+		assert.Nil(t, names)
+	})
+	t.Run("supported", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		store := struct {
+			*MockKVStore
+			*MockShelfLister
+		}{NewMockKVStore(ctrl), NewMockShelfLister(ctrl)}
+		store.MockShelfLister.EXPECT().ShelfNames(gomock.Any()).Return([]string{"a"}, nil)
+
+		names, err := ShelfNames(context.Background(), store)
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"a"}, names)
+	})
+}
+
+func TestLockKeys(t *testing.T) {
+	t.Run("not supported", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+
+		release, err := LockKeys(context.Background(), NewMockKVStore(ctrl), "shelf", BytesKey("a"))
+
+		assert.ErrorIs(t, err, errors.ErrUnsupported)
+		assert.Nil(t, release)
+	})
+	t.Run("supported", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		store := struct {
+			*MockKVStore
+			*MockLocker
+		}{NewMockKVStore(ctrl), NewMockLocker(ctrl)}
+		released := false
+		store.MockLocker.EXPECT().LockKeys(gomock.Any(), "shelf", BytesKey("a")).Return(func() { released = true }, nil)
+
+		release, err := LockKeys(context.Background(), store, "shelf", BytesKey("a"))
+
+		assert.NoError(t, err)
+		release()
+		assert.True(t, released)
+	})
+}
+
+func TestConfig_ShelfName(t *testing.T) {
+	t.Run("with key prefix", func(t *testing.T) {
+		cfg := DefaultConfig()
+		WithKeyPrefix("app1/")(&cfg)
+
+		assert.Equal(t, "app1/test", cfg.ShelfName("test"))
+		name, ok := cfg.TrimShelfName("app1/test")
+		assert.True(t, ok)
+		assert.Equal(t, "test", name)
+		_, ok = cfg.TrimShelfName("app2/test")
+		assert.False(t, ok)
+	})
+	t.Run("without key prefix", func(t *testing.T) {
+		cfg := DefaultConfig()
+
+		assert.Equal(t, "test", cfg.ShelfName("test"))
+		name, ok := cfg.TrimShelfName("app1/test")
+		assert.True(t, ok)
+		assert.Equal(t, "app1/test", name)
+	})
+}
@@ -0,0 +1,264 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package tiered
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+)
+
+// accessShelfPrefix prefixes the shelves in the hot store that hold the access times of the keys of a shelf.
+const accessShelfPrefix = stoabs.ReservedShelfPrefix + "tiered/"
+
+// demoteBatchSize is the maximum number of keys moved to the cold store in a single transaction.
+const demoteBatchSize = 1000
+
+// Run demotes keys (see Demote) until the given context is cancelled, waiting for the interval (see WithInterval) between demotions.
+// Errors are logged and retried after the interval.
+func (s *Store) Run(ctx context.Context) {
+	for {
+		_, err := s.Demote(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			s.log.WithError(err).Warn("Demoting keys to cold store failed, retrying")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.clock.After(s.interval):
+		}
+	}
+}
+
+// Demote moves the keys of the hot store that weren't accessed for the configured time (see WithDemoteAfter) to the cold store,
+// and returns the number of moved keys. It persists the access times first. Keys of which the access time isn't known
+// (e.g. because they were written before the store was wrapped) are considered accessed when they're first seen.
+// Keys are moved in batches: each batch is written to the cold store before it's deleted from the hot store in the same
+// write transaction, so a failed demotion never loses values.
+func (s *Store) Demote(ctx context.Context) (int, error) {
+	s.demoteMux.Lock()
+	defer s.demoteMux.Unlock()
+	if err := s.flushAccessTimes(ctx); err != nil {
+		return 0, err
+	}
+	shelves, err := stoabs.ShelfNames(ctx, s.hot)
+	if err != nil {
+		return 0, fmt.Errorf("unable to list shelves: %w", err)
+	}
+	var total int
+	for _, shelfName := range shelves {
+		moved, err := s.demoteShelf(ctx, shelfName)
+		total += moved
+		if err != nil {
+			return total, fmt.Errorf("unable to demote keys of shelf %s: %w", shelfName, err)
+		}
+	}
+	return total, nil
+}
+
+func (s *Store) demoteShelf(ctx context.Context, shelfName string) (int, error) {
+	now := s.clock.Now()
+	cutoff := now.Add(-s.demoteAfter)
+	accessShelf := accessShelfPrefix + shelfName
+	accessTimes := map[string]time.Time{}
+	var idle, unknown []stoabs.Key
+	err := s.hot.Read(ctx, func(tx stoabs.ReadTx) error {
+		err := tx.GetShelfReader(accessShelf).Iterate(func(key stoabs.Key, value []byte) error {
+			accessTimes[string(key.Bytes())] = parseAccessTime(value)
+			return nil
+		}, stoabs.BytesKey{})
+		if err != nil {
+			return err
+		}
+		return tx.GetShelfReader(shelfName).Iterate(func(key stoabs.Key, _ []byte) error {
+			k := string(key.Bytes())
+			accessed, known := accessTimes[k]
+			delete(accessTimes, k)
+			if !known {
+				unknown = append(unknown, key)
+			} else if accessed.Before(cutoff) {
+				idle = append(idle, key)
+			}
+			return nil
+		}, s.keyType(shelfName))
+	})
+	if err != nil {
+		return 0, err
+	}
+	// the remaining access times are of deleted keys
+	if len(unknown) > 0 || len(accessTimes) > 0 {
+		err = s.hot.WriteShelf(ctx, accessShelf, func(writer stoabs.Writer) error {
+			for _, key := range unknown {
+				if err := writer.Put(stoabs.BytesKey(key.Bytes()), formatAccessTime(now)); err != nil {
+					return err
+				}
+			}
+			for key := range accessTimes {
+				if err := writer.Delete(stoabs.BytesKey(key)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	var total int
+	for start := 0; start < len(idle); start += demoteBatchSize {
+		moved, err := s.demoteKeys(ctx, shelfName, idle[start:min(start+demoteBatchSize, len(idle))])
+		total += moved
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// demoteKeys moves the given keys to the cold store, except the keys that were accessed after the access times were persisted.
+func (s *Store) demoteKeys(ctx context.Context, shelfName string, keys []stoabs.Key) (int, error) {
+	var moved int
+	err := s.hot.Write(ctx, func(tx stoabs.WriteTx) error {
+		moved = 0
+		hotWriter := tx.GetShelfWriter(shelfName)
+		var entries []stoabs.KeyValue
+		for _, key := range keys {
+			if s.accessedRecently(shelfName, key) {
+				continue
+			}
+			value, err := hotWriter.Get(key)
+			if errors.Is(err, stoabs.ErrKeyNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			entries = append(entries, stoabs.KeyValue{Key: key, Value: value})
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+		err := s.cold.WriteShelf(ctx, shelfName, func(coldWriter stoabs.Writer) error {
+			for _, entry := range entries {
+				if err := coldWriter.Put(entry.Key, entry.Value); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		accessWriter := tx.GetShelfWriter(accessShelfPrefix + shelfName)
+		for _, entry := range entries {
+			if err := hotWriter.Delete(entry.Key); err != nil {
+				return err
+			}
+			if err := accessWriter.Delete(stoabs.BytesKey(entry.Key.Bytes())); err != nil {
+				return err
+			}
+		}
+		moved = len(entries)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	s.demotions.Add(uint64(moved))
+	return moved, nil
+}
+
+// recordAccess records the access time of the key in memory, until it's persisted by flushAccessTimes.
+func (s *Store) recordAccess(shelfName string, key stoabs.Key) {
+	now := s.clock.Now()
+	s.accessMux.Lock()
+	defer s.accessMux.Unlock()
+	keys := s.accessed[shelfName]
+	if keys == nil {
+		keys = map[string]time.Time{}
+		s.accessed[shelfName] = keys
+	}
+	keys[string(key.Bytes())] = now
+}
+
+// accessedRecently returns true if the key was accessed after the access times were persisted.
+func (s *Store) accessedRecently(shelfName string, key stoabs.Key) bool {
+	s.accessMux.Lock()
+	defer s.accessMux.Unlock()
+	_, accessed := s.accessed[shelfName][string(key.Bytes())]
+	return accessed
+}
+
+// flushAccessTimes persists the access times recorded in memory. If that fails, they're kept in memory.
+func (s *Store) flushAccessTimes(ctx context.Context) error {
+	s.accessMux.Lock()
+	accessed := s.accessed
+	s.accessed = map[string]map[string]time.Time{}
+	s.accessMux.Unlock()
+	if len(accessed) == 0 {
+		return nil
+	}
+	err := s.hot.Write(ctx, func(tx stoabs.WriteTx) error {
+		for shelfName, keys := range accessed {
+			writer := tx.GetShelfWriter(accessShelfPrefix + shelfName)
+			for key, accessTime := range keys {
+				if err := writer.Put(stoabs.BytesKey(key), formatAccessTime(accessTime)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.accessMux.Lock()
+		defer s.accessMux.Unlock()
+		for shelfName, keys := range accessed {
+			for key, accessTime := range keys {
+				if current, ok := s.accessed[shelfName][key]; !ok || current.Before(accessTime) {
+					if s.accessed[shelfName] == nil {
+						s.accessed[shelfName] = map[string]time.Time{}
+					}
+					s.accessed[shelfName][key] = accessTime
+				}
+			}
+		}
+		return fmt.Errorf("unable to persist access times: %w", err)
+	}
+	return nil
+}
+
+// Access times are stored as Unix nanoseconds (8 bytes, big endian).
+func formatAccessTime(t time.Time) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(t.UnixNano()))
+}
+
+func parseAccessTime(value []byte) time.Time {
+	if len(value) != 8 {
+		// corrupt, consider it accessed long ago
+		return time.Time{}
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(value)))
+}
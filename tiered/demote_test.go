@@ -0,0 +1,166 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package tiered
+
+import (
+	"context"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/mocks"
+	"github.com/nuts-foundation/go-stoabs/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var now = time.Unix(1700000000, 0)
+
+func TestStore_Demote(t *testing.T) {
+	t.Run("moves idle keys to the cold store", func(t *testing.T) {
+		clock := mocks.NewClock(now)
+		hot, cold := createStore(t), createStore(t)
+		store := Wrap(hot, cold, WithClock(clock), WithDemoteAfter(time.Hour))
+		put(t, store, "a", "v1")
+		put(t, store, "b", "v2")
+		clock.Advance(30 * time.Minute)
+		_ = get(t, store, "b")
+		clock.Advance(45 * time.Minute)
+
+		moved, err := store.Demote(ctx)
+
+		require.NoError(t, err)
+		assert.Equal(t, 1, moved)
+		assert.Empty(t, get(t, hot, "a"))
+		assert.Equal(t, "v1", get(t, cold, "a"))
+		assert.Equal(t, "v2", get(t, hot, "b"))
+		assert.Equal(t, uint64(1), store.Stats().Demotions)
+		t.Run("demoted key is promoted on read", func(t *testing.T) {
+			assert.Equal(t, "v1", get(t, store, "a"))
+
+			assert.Equal(t, "v1", get(t, hot, "a"))
+			assert.Empty(t, get(t, cold, "a"))
+		})
+		t.Run("promoted key isn't demoted before it's idle", func(t *testing.T) {
+			clock.Advance(time.Hour - time.Second)
+
+			moved, err := store.Demote(ctx)
+
+			require.NoError(t, err)
+			assert.Equal(t, 1, moved)
+			assert.Equal(t, "v2", get(t, cold, "b"))
+			assert.Equal(t, "v1", get(t, hot, "a"))
+		})
+	})
+	t.Run("keys without access time are considered accessed when first seen", func(t *testing.T) {
+		clock := mocks.NewClock(now)
+		hot, cold := createStore(t), createStore(t)
+		put(t, hot, "a", "v1")
+		store := Wrap(hot, cold, WithClock(clock), WithDemoteAfter(time.Hour))
+
+		moved, err := store.Demote(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 0, moved)
+
+		clock.Advance(time.Hour + time.Second)
+		moved, err = store.Demote(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, moved)
+	})
+	t.Run("access times of deleted keys are removed", func(t *testing.T) {
+		hot := createStore(t)
+		store := Wrap(hot, createStore(t))
+		put(t, store, "a", "v1")
+		require.NoError(t, store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			return writer.Delete(stoabs.BytesKey("a"))
+		}))
+
+		_, err := store.Demote(ctx)
+
+		require.NoError(t, err)
+		require.NoError(t, hot.ReadShelf(ctx, accessShelfPrefix+shelfName, func(reader stoabs.Reader) error {
+			empty, err := reader.Empty()
+			assert.True(t, empty)
+			return err
+		}))
+	})
+	t.Run("access times are persisted on close", func(t *testing.T) {
+		clock := mocks.NewClock(now)
+		hotPath := path.Join(util.TestDirectory(t), "hot.db")
+		store := Wrap(openStore(t, hotPath), createStore(t), WithClock(clock))
+		put(t, store, "a", "v1")
+		require.NoError(t, store.flushAccessTimes(ctx))
+		clock.Advance(time.Hour)
+		_ = get(t, store, "a")
+
+		require.NoError(t, store.Close(ctx))
+
+		moved, err := Wrap(openStore(t, hotPath), createStore(t), WithClock(clock), WithDemoteAfter(30*time.Minute)).Demote(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 0, moved)
+	})
+	t.Run("key type", func(t *testing.T) {
+		clock := mocks.NewClock(now)
+		hot, cold := createStore(t), createStore(t)
+		store := Wrap(hot, cold, WithClock(clock), WithKeyType(shelfName, stoabs.Uint32Key(0)))
+		require.NoError(t, store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			return writer.Put(stoabs.Uint32Key(1), []byte("v1"))
+		}))
+		clock.Advance(defaultDemoteAfter + time.Second)
+
+		moved, err := store.Demote(ctx)
+
+		require.NoError(t, err)
+		assert.Equal(t, 1, moved)
+	})
+	t.Run("failing store", func(t *testing.T) {
+		store := Wrap(stoabs.Chain(createStore(t), failingWrites{}), createStore(t))
+		put(t, store.cold, "a", "v1")
+		_ = get(t, store, "a")
+
+		_, err := store.Demote(ctx)
+
+		assert.EqualError(t, err, "unable to persist access times: failed")
+	})
+}
+
+func TestStore_Run(t *testing.T) {
+	clock := mocks.NewClock(now)
+	hot, cold := createStore(t), createStore(t)
+	store := Wrap(hot, cold, WithClock(clock), WithDemoteAfter(time.Hour), WithInterval(time.Hour))
+	put(t, store, "a", "v1")
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		store.Run(ctx)
+		close(done)
+	}()
+
+	util.WaitFor(t, func() (bool, error) {
+		return clock.Waiters() == 1, nil
+	}, 5*time.Second, "Run didn't wait for the interval")
+	clock.Advance(time.Hour + time.Second)
+	util.WaitFor(t, func() (bool, error) {
+		return store.Stats().Demotions == 1, nil
+	}, 5*time.Second, "Run didn't demote")
+
+	cancel()
+	<-done
+}
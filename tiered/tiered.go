@@ -0,0 +1,492 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package tiered provides a KVStore that keeps recently accessed keys in a hot store and moves idle keys to a cold store.
+package tiered
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/sirupsen/logrus"
+)
+
+var _ stoabs.KVStore = (*Store)(nil)
+var _ stoabs.ShelfLister = (*Store)(nil)
+var _ stoabs.WriteTx = (*tx)(nil)
+var _ stoabs.Writer = (*shelf)(nil)
+
+// errStop stops iterating or ranging over a shelf.
+var errStop = errors.New("stop")
+
+const defaultDemoteAfter = 24 * time.Hour
+
+const defaultInterval = time.Hour
+
+// Option configures the tiered store.
+type Option func(s *Store)
+
+// WithDemoteAfter sets the time after which keys that weren't accessed are moved to the cold store, which defaults to 24 hours.
+func WithDemoteAfter(idle time.Duration) Option {
+	return func(s *Store) {
+		s.demoteAfter = idle
+	}
+}
+
+// WithInterval sets the time Run waits between demotions, which defaults to 1 hour.
+func WithInterval(interval time.Duration) Option {
+	return func(s *Store) {
+		s.interval = interval
+	}
+}
+
+// WithKeyType specifies the type of the keys of the given shelf, used to iterate over it when demoting keys.
+// It's required for shelves with other keys than stoabs.BytesKey in Redis, which stores keys in their string form.
+func WithKeyType(shelfName string, keyType stoabs.Key) Option {
+	return func(s *Store) {
+		s.keyTypes[shelfName] = keyType
+	}
+}
+
+// WithClock overrides the clock that determines access times and when Run demotes keys, which defaults to stoabs.SystemClock.
+func WithClock(clock stoabs.Clock) Option {
+	return func(s *Store) {
+		s.clock = clock
+	}
+}
+
+// WithLogger overrides the default logger.
+func WithLogger(log *logrus.Logger) Option {
+	return func(s *Store) {
+		s.log = log
+	}
+}
+
+// Stats contains the tiering counters.
+type Stats struct {
+	// HotReads counts the values read by Get from the hot store.
+	HotReads uint64
+	// ColdReads counts the values read by Get from the cold store.
+	ColdReads uint64
+	// Promotions counts the keys moved from the cold to the hot store.
+	Promotions uint64
+	// Demotions counts the keys moved from the hot to the cold store.
+	Demotions uint64
+	// Errors counts failed promotions.
+	Errors uint64
+}
+
+// Wrap creates a store that writes to the hot store and moves keys that weren't accessed (read by Get or written)
+// for some time to the cold store (see Demote and Run). Reads fall back to the cold store for keys that aren't in the hot store,
+// and keys read from the cold store are moved back to the hot store after the transaction (promotion).
+// Iterate and Range visit the entries of both stores, but don't count as access.
+// A key is only in one of the stores, except when moving it failed halfway: then the value in the hot store takes precedence.
+// Deleting a key deletes it from both stores: the key is deleted from the cold store before the write transaction is
+// committed on the hot store, so if committing fails the deleted keys might have been deleted from the cold store only.
+// Promotions are serialized with deletes by the write transactions on the hot store, so with Redis as hot store
+// (of which write transactions don't exclude each other) a concurrently deleted key might be promoted again.
+// Access times are tracked in memory and persisted in reserved shelves of the hot store when demoting keys and on Close.
+// Neither store must be written to by other means.
+func Wrap(hot, cold stoabs.KVStore, opts ...Option) *Store {
+	result := &Store{
+		hot:         hot,
+		cold:        cold,
+		demoteAfter: defaultDemoteAfter,
+		interval:    defaultInterval,
+		keyTypes:    map[string]stoabs.Key{},
+		clock:       stoabs.SystemClock,
+		log:         logrus.StandardLogger(),
+		accessed:    map[string]map[string]time.Time{},
+	}
+	for _, opt := range opts {
+		opt(result)
+	}
+	return result
+}
+
+// Store is a KVStore that tiers keys over a hot and cold store. Use Wrap to create it.
+type Store struct {
+	hot         stoabs.KVStore
+	cold        stoabs.KVStore
+	demoteAfter time.Duration
+	interval    time.Duration
+	keyTypes    map[string]stoabs.Key
+	clock       stoabs.Clock
+	log         *logrus.Logger
+
+	// accessMux guards accessed, which holds the access times (by shelf and key) that haven't been persisted yet.
+	accessMux sync.Mutex
+	accessed  map[string]map[string]time.Time
+	// demoteMux serializes demotions.
+	demoteMux sync.Mutex
+
+	hotReads   atomic.Uint64
+	coldReads  atomic.Uint64
+	promotions atomic.Uint64
+	demotions  atomic.Uint64
+	errors     atomic.Uint64
+}
+
+// Stats returns the tiering counters.
+func (s *Store) Stats() Stats {
+	return Stats{
+		HotReads:   s.hotReads.Load(),
+		ColdReads:  s.coldReads.Load(),
+		Promotions: s.promotions.Load(),
+		Demotions:  s.demotions.Load(),
+		Errors:     s.errors.Load(),
+	}
+}
+
+// Close persists the access times and closes both the hot and cold store.
+func (s *Store) Close(ctx context.Context) error {
+	err := s.flushAccessTimes(ctx)
+	return errors.Join(err, s.hot.Close(ctx), s.cold.Close(ctx))
+}
+
+func (s *Store) Write(ctx context.Context, fn func(stoabs.WriteTx) error, opts ...stoabs.TxOption) error {
+	var promotions []promotion
+	err := s.hot.Write(ctx, func(hotTx stoabs.WriteTx) error {
+		promotions = nil
+		deleted := map[string]map[string]stoabs.Key{}
+		err := s.cold.Read(ctx, func(coldTx stoabs.ReadTx) error {
+			return fn(&tx{ReadTx: hotTx, writeTx: hotTx, coldTx: coldTx, store: s, ctx: ctx, promotions: &promotions, deleted: deleted})
+		})
+		if err != nil {
+			return err
+		}
+		return s.deleteCold(ctx, deleted)
+	}, opts...)
+	if err != nil {
+		return err
+	}
+	s.promote(ctx, promotions)
+	return nil
+}
+
+func (s *Store) WriteShelf(ctx context.Context, shelfName string, fn func(stoabs.Writer) error) error {
+	return s.Write(ctx, func(tx stoabs.WriteTx) error {
+		return fn(tx.GetShelfWriter(shelfName))
+	})
+}
+
+func (s *Store) Read(ctx context.Context, fn func(stoabs.ReadTx) error) error {
+	var promotions []promotion
+	err := s.hot.Read(ctx, func(hotTx stoabs.ReadTx) error {
+		promotions = nil
+		return s.cold.Read(ctx, func(coldTx stoabs.ReadTx) error {
+			return fn(&tx{ReadTx: hotTx, coldTx: coldTx, store: s, ctx: ctx, promotions: &promotions})
+		})
+	})
+	if err != nil {
+		return err
+	}
+	s.promote(ctx, promotions)
+	return nil
+}
+
+func (s *Store) ReadShelf(ctx context.Context, shelfName string, fn func(stoabs.Reader) error) error {
+	return s.Read(ctx, func(tx stoabs.ReadTx) error {
+		return fn(tx.GetShelfReader(shelfName))
+	})
+}
+
+// ShelfNames returns the shelves of both stores.
+func (s *Store) ShelfNames(ctx context.Context) ([]string, error) {
+	hot, err := stoabs.ShelfNames(ctx, s.hot)
+	if err != nil {
+		return nil, err
+	}
+	cold, err := stoabs.ShelfNames(ctx, s.cold)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]struct{}{}
+	for _, name := range append(hot, cold...) {
+		names[name] = struct{}{}
+	}
+	result := make([]string, 0, len(names))
+	for name := range names {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// deleteCold deletes the keys deleted in a write transaction from the cold store.
+func (s *Store) deleteCold(ctx context.Context, deleted map[string]map[string]stoabs.Key) error {
+	if len(deleted) == 0 {
+		return nil
+	}
+	return s.cold.Write(ctx, func(tx stoabs.WriteTx) error {
+		for shelfName, keys := range deleted {
+			writer := tx.GetShelfWriter(shelfName)
+			for _, key := range keys {
+				if err := writer.Delete(key); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// promotion is a key that was read from the cold store.
+type promotion struct {
+	shelf string
+	key   stoabs.Key
+}
+
+// promote moves the given keys from the cold to the hot store, unless they've been written to the hot store meanwhile.
+// The keys are deleted from the cold store after the hot store has been committed.
+// Failures are logged and counted, since the values have been read successfully.
+func (s *Store) promote(ctx context.Context, promotions []promotion) {
+	if len(promotions) == 0 {
+		return
+	}
+	var promoted []promotion
+	err := s.hot.Write(ctx, func(hotTx stoabs.WriteTx) error {
+		promoted = nil
+		return s.cold.Read(ctx, func(coldTx stoabs.ReadTx) error {
+			for _, p := range promotions {
+				writer := hotTx.GetShelfWriter(p.shelf)
+				if _, err := writer.Get(p.key); !errors.Is(err, stoabs.ErrKeyNotFound) {
+					// already promoted, or failed
+					if err != nil {
+						return err
+					}
+					continue
+				}
+				value, err := coldTx.GetShelfReader(p.shelf).Get(p.key)
+				if errors.Is(err, stoabs.ErrKeyNotFound) {
+					// deleted meanwhile
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				if err := writer.Put(p.key, value); err != nil {
+					return err
+				}
+				promoted = append(promoted, p)
+			}
+			return nil
+		})
+	})
+	if err == nil && len(promoted) > 0 {
+		err = s.cold.Write(ctx, func(tx stoabs.WriteTx) error {
+			for _, p := range promoted {
+				if err := tx.GetShelfWriter(p.shelf).Delete(p.key); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err == nil {
+			s.promotions.Add(uint64(len(promoted)))
+		}
+	}
+	if err != nil {
+		s.errors.Add(1)
+		s.log.WithError(err).Warn("Unable to promote keys to hot store")
+	}
+}
+
+// keyType returns the type of the keys of the given shelf, see WithKeyType.
+func (s *Store) keyType(shelfName string) stoabs.Key {
+	if keyType, ok := s.keyTypes[shelfName]; ok {
+		return keyType
+	}
+	return stoabs.BytesKey{}
+}
+
+type tx struct {
+	stoabs.ReadTx
+	writeTx    stoabs.WriteTx
+	coldTx     stoabs.ReadTx
+	store      *Store
+	ctx        context.Context
+	promotions *[]promotion
+	// deleted holds the keys deleted by a write transaction (by shelf and key bytes), which must not be read from the cold store.
+	deleted map[string]map[string]stoabs.Key
+}
+
+func (t *tx) GetShelfReader(shelfName string) stoabs.Reader {
+	return &shelf{Reader: t.ReadTx.GetShelfReader(shelfName), cold: t.coldTx.GetShelfReader(shelfName), name: shelfName, tx: t}
+}
+
+func (t *tx) GetShelfWriter(shelfName string) stoabs.Writer {
+	writer := t.writeTx.GetShelfWriter(shelfName)
+	return &shelf{Reader: writer, writer: writer, cold: t.coldTx.GetShelfReader(shelfName), name: shelfName, tx: t}
+}
+
+func (t *tx) Store() stoabs.KVStore {
+	return t.store
+}
+
+// shelf reads a shelf of the hot store, falling back to the cold store. Writing to it writes to the hot store.
+type shelf struct {
+	stoabs.Reader
+	writer stoabs.Writer
+	cold   stoabs.Reader
+	name   string
+	tx     *tx
+}
+
+func (s *shelf) Empty() (bool, error) {
+	empty := true
+	err := s.Iterate(func(_ stoabs.Key, _ []byte) error {
+		empty = false
+		return errStop
+	}, s.tx.store.keyType(s.name))
+	if err != nil && !errors.Is(err, errStop) {
+		return false, err
+	}
+	return empty, nil
+}
+
+func (s *shelf) Get(key stoabs.Key) ([]byte, error) {
+	store := s.tx.store
+	value, err := s.Reader.Get(key)
+	if err == nil {
+		store.hotReads.Add(1)
+		store.recordAccess(s.name, key)
+		return value, nil
+	}
+	if !errors.Is(err, stoabs.ErrKeyNotFound) || s.isDeleted(key) {
+		return nil, err
+	}
+	value, err = s.cold.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	store.coldReads.Add(1)
+	store.recordAccess(s.name, key)
+	*s.tx.promotions = append(*s.tx.promotions, promotion{shelf: s.name, key: key})
+	return value, nil
+}
+
+func (s *shelf) Put(key stoabs.Key, value []byte) error {
+	if err := s.writer.Put(key, value); err != nil {
+		return err
+	}
+	s.tx.store.recordAccess(s.name, key)
+	return nil
+}
+
+func (s *shelf) Delete(key stoabs.Key) error {
+	if err := s.writer.Delete(key); err != nil {
+		return err
+	}
+	keys := s.tx.deleted[s.name]
+	if keys == nil {
+		keys = map[string]stoabs.Key{}
+		s.tx.deleted[s.name] = keys
+	}
+	keys[string(key.Bytes())] = key
+	return nil
+}
+
+// Iterate visits the entries of the hot store, followed by those of the cold store.
+func (s *shelf) Iterate(callback stoabs.CallerFn, keyType stoabs.Key) error {
+	hotKeys := map[string]struct{}{}
+	err := s.Reader.Iterate(func(key stoabs.Key, value []byte) error {
+		hotKeys[string(key.Bytes())] = struct{}{}
+		return callback(key, value)
+	}, keyType)
+	if err != nil {
+		return err
+	}
+	return s.cold.Iterate(func(key stoabs.Key, value []byte) error {
+		if _, hot := hotKeys[string(key.Bytes())]; hot || s.isDeleted(key) {
+			return nil
+		}
+		return callback(key, value)
+	}, keyType)
+}
+
+// Range merges the entries of both stores in byte order. The entries of the hot store in range are read first.
+func (s *shelf) Range(from stoabs.Key, to stoabs.Key, callback stoabs.CallerFn, stopAtNil bool) error {
+	if stopAtNil {
+		inner := callback
+		var prevKey stoabs.Key
+		callback = func(key stoabs.Key, value []byte) error {
+			if prevKey != nil && !prevKey.Next().Equals(key) {
+				// gap found, stop here
+				return errStop
+			}
+			prevKey = key
+			return inner(key, value)
+		}
+	}
+	var hot []stoabs.KeyValue
+	err := s.Reader.Range(from, to, func(key stoabs.Key, value []byte) error {
+		hot = append(hot, stoabs.KeyValue{Key: key, Value: value})
+		return nil
+	}, false)
+	if err != nil {
+		return err
+	}
+	next := 0
+	// visitHot visits the hot entries with keys before the given key, or all remaining entries if it's nil
+	visitHot := func(before []byte) error {
+		for ; next < len(hot) && (before == nil || bytes.Compare(hot[next].Key.Bytes(), before) < 0); next++ {
+			if s.tx.ctx.Err() != nil {
+				return stoabs.DatabaseError(s.tx.ctx.Err())
+			}
+			if err := callback(hot[next].Key, hot[next].Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	err = s.cold.Range(from, to, func(key stoabs.Key, value []byte) error {
+		if err := visitHot(key.Bytes()); err != nil {
+			return err
+		}
+		if next < len(hot) && bytes.Equal(hot[next].Key.Bytes(), key.Bytes()) || s.isDeleted(key) {
+			// shadowed by the hot store, visited with the next key
+			return nil
+		}
+		return callback(key, value)
+	}, false)
+	if err == nil {
+		err = visitHot(nil)
+	}
+	if errors.Is(err, errStop) {
+		return nil
+	}
+	return err
+}
+
+// Stats adds the statistics of the shelf in both stores.
+func (s *shelf) Stats() stoabs.ShelfStats {
+	hot, cold := s.Reader.Stats(), s.cold.Stats()
+	return stoabs.ShelfStats{NumEntries: hot.NumEntries + cold.NumEntries, ShelfSize: hot.ShelfSize + cold.ShelfSize}
+}
+
+func (s *shelf) isDeleted(key stoabs.Key) bool {
+	_, deleted := s.tx.deleted[s.name][string(key.Bytes())]
+	return deleted
+}
@@ -0,0 +1,267 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package tiered
+
+import (
+	"context"
+	"errors"
+	"path"
+	"testing"
+
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/bbolt"
+	"github.com/nuts-foundation/go-stoabs/kvtests"
+	"github.com/nuts-foundation/go-stoabs/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+const shelfName = "test"
+
+func TestTiered(t *testing.T) {
+	provider := func(t *testing.T) (stoabs.KVStore, error) {
+		return Wrap(createStore(t), createStore(t)), nil
+	}
+
+	kvtests.TestReadingAndWriting(t, provider)
+	kvtests.TestRange(t, provider)
+	kvtests.TestIterate(t, provider)
+	kvtests.TestEmpty(t, provider)
+	kvtests.TestDelete(t, provider)
+	kvtests.TestWriteTransactions(t, provider)
+	kvtests.TestShelfNames(t, provider)
+}
+
+func TestStore_Get(t *testing.T) {
+	t.Run("promotes keys read from the cold store", func(t *testing.T) {
+		hot, cold := createStore(t), createStore(t)
+		put(t, cold, "a", "v1")
+		store := Wrap(hot, cold)
+
+		assert.Equal(t, "v1", get(t, store, "a"))
+		assert.Equal(t, "v1", get(t, store, "a"))
+
+		assert.Equal(t, "v1", get(t, hot, "a"))
+		assert.Empty(t, get(t, cold, "a"))
+		assert.Equal(t, Stats{HotReads: 1, ColdReads: 1, Promotions: 1}, store.Stats())
+	})
+	t.Run("hot store takes precedence", func(t *testing.T) {
+		hot, cold := createStore(t), createStore(t)
+		put(t, hot, "a", "hot")
+		put(t, cold, "a", "cold")
+		store := Wrap(hot, cold)
+
+		assert.Equal(t, "hot", get(t, store, "a"))
+	})
+	t.Run("not found", func(t *testing.T) {
+		store := Wrap(createStore(t), createStore(t))
+
+		assert.Empty(t, get(t, store, "a"))
+		assert.Equal(t, Stats{}, store.Stats())
+	})
+	t.Run("failed transaction doesn't promote", func(t *testing.T) {
+		hot, cold := createStore(t), createStore(t)
+		put(t, cold, "a", "v1")
+		store := Wrap(hot, cold)
+
+		err := store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			_, _ = reader.Get(stoabs.BytesKey("a"))
+			return errors.New("failed")
+		})
+
+		assert.EqualError(t, err, "failed")
+		assert.Equal(t, "v1", get(t, cold, "a"))
+	})
+	t.Run("failed promotion", func(t *testing.T) {
+		hot, cold := createStore(t), createStore(t)
+		put(t, cold, "a", "v1")
+		store := Wrap(stoabs.Chain(hot, failingWrites{}), cold)
+
+		assert.Equal(t, "v1", get(t, store, "a"))
+
+		assert.Equal(t, "v1", get(t, cold, "a"))
+		assert.Equal(t, uint64(1), store.Stats().Errors)
+	})
+	t.Run("failed removal from the cold store isn't counted as promotion", func(t *testing.T) {
+		hot, cold := createStore(t), createStore(t)
+		put(t, cold, "a", "v1")
+		store := Wrap(hot, stoabs.Chain(cold, failingWrites{}))
+
+		assert.Equal(t, "v1", get(t, store, "a"))
+
+		assert.Equal(t, "v1", get(t, cold, "a"))
+		assert.Equal(t, Stats{ColdReads: 1, Errors: 1}, store.Stats())
+	})
+}
+
+func TestStore_Write(t *testing.T) {
+	t.Run("delete removes key from both stores", func(t *testing.T) {
+		hot, cold := createStore(t), createStore(t)
+		put(t, hot, "a", "hot")
+		put(t, cold, "a", "cold")
+		put(t, cold, "b", "cold")
+		store := Wrap(hot, cold)
+
+		err := store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+			if err := writer.Delete(stoabs.BytesKey("a")); err != nil {
+				return err
+			}
+			if err := writer.Delete(stoabs.BytesKey("b")); err != nil {
+				return err
+			}
+			// deleted keys aren't read from the cold store
+			_, err := writer.Get(stoabs.BytesKey("b"))
+			assert.ErrorIs(t, err, stoabs.ErrKeyNotFound)
+			empty, err := writer.Empty()
+			require.NoError(t, err)
+			assert.True(t, empty)
+			return nil
+		})
+
+		require.NoError(t, err)
+		assert.Empty(t, get(t, hot, "a"))
+		assert.Empty(t, get(t, cold, "a"))
+		assert.Empty(t, get(t, cold, "b"))
+	})
+	t.Run("writes go to the hot store", func(t *testing.T) {
+		hot, cold := createStore(t), createStore(t)
+		put(t, cold, "a", "old")
+		store := Wrap(hot, cold)
+
+		put(t, store, "a", "new")
+
+		assert.Equal(t, "new", get(t, store, "a"))
+		assert.Equal(t, "new", get(t, hot, "a"))
+	})
+}
+
+func TestStore_Range(t *testing.T) {
+	hot, cold := createStore(t), createStore(t)
+	put(t, hot, "b", "hot")
+	put(t, hot, "d", "hot")
+	put(t, cold, "a", "cold")
+	put(t, cold, "b", "cold")
+	put(t, cold, "c", "cold")
+	put(t, cold, "e", "cold")
+	store := Wrap(hot, cold)
+
+	t.Run("merged in byte order", func(t *testing.T) {
+		var visited []string
+		err := store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			return reader.Range(stoabs.BytesKey("a"), stoabs.BytesKey("z"), func(key stoabs.Key, value []byte) error {
+				visited = append(visited, string(key.Bytes())+"="+string(value))
+				return nil
+			}, false)
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a=cold", "b=hot", "c=cold", "d=hot", "e=cold"}, visited)
+	})
+	t.Run("stop at nil", func(t *testing.T) {
+		var visited []string
+		err := store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			return reader.Range(stoabs.BytesKey("a"), stoabs.BytesKey("z"), func(key stoabs.Key, _ []byte) error {
+				visited = append(visited, string(key.Bytes()))
+				return nil
+			}, true)
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a", "b", "c", "d", "e"}, visited)
+	})
+	t.Run("iterate", func(t *testing.T) {
+		visited := map[string]string{}
+		err := store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			return reader.Iterate(func(key stoabs.Key, value []byte) error {
+				visited[string(key.Bytes())] = string(value)
+				return nil
+			}, stoabs.BytesKey{})
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"a": "cold", "b": "hot", "c": "cold", "d": "hot", "e": "cold"}, visited)
+	})
+	t.Run("scans don't promote", func(t *testing.T) {
+		assert.Equal(t, Stats{}, store.Stats())
+		assert.Equal(t, "cold", get(t, cold, "a"))
+	})
+}
+
+func TestStore_ShelfNames(t *testing.T) {
+	hot, cold := createStore(t), createStore(t)
+	require.NoError(t, hot.WriteShelf(ctx, "a", func(writer stoabs.Writer) error {
+		return writer.Put(stoabs.BytesKey("key"), []byte("value"))
+	}))
+	put(t, hot, "key", "value")
+	put(t, cold, "key", "value")
+	require.NoError(t, cold.WriteShelf(ctx, "z", func(writer stoabs.Writer) error {
+		return writer.Put(stoabs.BytesKey("key"), []byte("value"))
+	}))
+
+	names, err := Wrap(hot, cold).ShelfNames(ctx)
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", shelfName, "z"}, names)
+}
+
+// failingWrites fails write transactions.
+type failingWrites struct {
+	stoabs.NoopInterceptor
+}
+
+func (failingWrites) Transaction(ctx context.Context, info stoabs.TxInfo, fn func(ctx context.Context) error) error {
+	if info.Writable {
+		return errors.New("failed")
+	}
+	return fn(ctx)
+}
+
+func createStore(t *testing.T) stoabs.KVStore {
+	return openStore(t, path.Join(util.TestDirectory(t), "bbolt.db"))
+}
+
+func openStore(t *testing.T, filePath string) stoabs.KVStore {
+	store, err := bbolt.CreateBBoltStore(filePath, stoabs.WithNoSync())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = store.Close(context.Background())
+	})
+	return store
+}
+
+func put(t *testing.T, store stoabs.KVStore, key string, value string) {
+	require.NoError(t, store.WriteShelf(ctx, shelfName, func(writer stoabs.Writer) error {
+		return writer.Put(stoabs.BytesKey(key), []byte(value))
+	}))
+}
+
+func get(t *testing.T, store stoabs.KVStore, key string) string {
+	var result []byte
+	err := store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+		var err error
+		result, err = reader.Get(stoabs.BytesKey(key))
+		return err
+	})
+	if !errors.Is(err, stoabs.ErrKeyNotFound) {
+		require.NoError(t, err)
+	}
+	return string(result)
+}
@@ -0,0 +1,324 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package timeseries provides a time-series of values stored in a shelf, with windowed queries and retention.
+package timeseries
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+)
+
+// stateKey holds the series state, buckets are keyed by their index (stoabs.Uint64Key): the bucket's start time
+// (Unix nanoseconds) divided by the bucket width. Since bucket indexes are consecutive, the buckets of a time window can
+// be read using stoabs.Reader.Range on every backend.
+const stateKey = stoabs.Uint64Key(0)
+
+// Buckets consist of points ordered by time, each encoded as its time (Unix nanoseconds, 8 bytes, big endian) |
+// uvarint value length | value.
+
+const defaultBucketWidth = time.Minute
+
+// Point is a value at a point in time.
+type Point struct {
+	Time  time.Time
+	Value []byte
+}
+
+// state is stored at stateKey in the series shelf.
+type state struct {
+	// Oldest is the index of the oldest bucket that may hold points, or 0 if the series is empty.
+	Oldest uint64 `json:"oldest"`
+}
+
+// Option configures the series.
+type Option func(s *Series)
+
+// WithBucketWidth specifies the time span of the points stored together in a single entry (default 1 minute).
+// Wider buckets mean fewer entries, but every Append rewrites the bucket it appends to.
+// The bucket width can't be changed once points have been written. Operations on the series fail if it isn't positive.
+func WithBucketWidth(width time.Duration) Option {
+	return func(s *Series) {
+		s.bucketWidth = width
+	}
+}
+
+// WithRetention specifies how long points are retained. Points older than the retention are removed when appending,
+// at the granularity of buckets (see WithBucketWidth). By default, all points are kept.
+func WithRetention(retention time.Duration) Option {
+	return func(s *Series) {
+		s.retention = retention
+	}
+}
+
+// WithClock overrides the clock that determines which points are older than the retention, which defaults to stoabs.SystemClock.
+func WithClock(clock stoabs.Clock) Option {
+	return func(s *Series) {
+		s.now = clock.Now
+	}
+}
+
+// New returns a time-series that stores its points in the given shelf, which must not be used for anything else.
+// Writes lock the shelf (see stoabs.WithShelfLock), so the series can be appended to by multiple processes.
+func New(store stoabs.KVStore, shelfName string, opts ...Option) *Series {
+	result := &Series{
+		store:       store,
+		shelfName:   shelfName,
+		bucketWidth: defaultBucketWidth,
+		now:         time.Now,
+	}
+	for _, opt := range opts {
+		opt(result)
+	}
+	return result
+}
+
+// Series is a time-series stored in a shelf. Use New to create it.
+type Series struct {
+	store       stoabs.KVStore
+	shelfName   string
+	bucketWidth time.Duration
+	retention   time.Duration
+	now         func() time.Time
+}
+
+// Append adds the given points to the series in a single transaction, and prunes points older than the retention.
+// Points may be appended out of order, and multiple points may have the same time.
+func (s *Series) Append(ctx context.Context, points ...Point) error {
+	buckets := map[uint64][]Point{}
+	for _, point := range points {
+		index, err := s.bucketOf(point.Time)
+		if err != nil {
+			return err
+		}
+		buckets[index] = append(buckets[index], point)
+	}
+	return s.store.Write(ctx, func(tx stoabs.WriteTx) error {
+		writer := tx.GetShelfWriter(s.shelfName)
+		current, err := readState(writer)
+		if err != nil {
+			return err
+		}
+		for index, newPoints := range buckets {
+			existing, err := readBucket(writer, index)
+			if err != nil {
+				return err
+			}
+			if err := writer.Put(stoabs.Uint64Key(index), encodeBucket(insertPoints(existing, newPoints))); err != nil {
+				return err
+			}
+			if current.Oldest == 0 || index < current.Oldest {
+				current.Oldest = index
+			}
+		}
+		if err := s.prune(writer, &current); err != nil {
+			return err
+		}
+		return stoabs.JSONShelf[state](writer).Put(stateKey, current)
+	}, stoabs.WithShelfLock(s.shelfName))
+}
+
+// Range calls fn for every point from (inclusive) to (exclusive) the given times, ordered by time.
+// On backends that look up every key in a range (e.g. Redis), the cost is proportional to the number of buckets in the window.
+func (s *Series) Range(ctx context.Context, from, to time.Time, fn func(Point) error) error {
+	if !from.Before(to) {
+		return nil
+	}
+	first, err := s.bucketOf(from)
+	if err != nil {
+		return err
+	}
+	last, err := s.bucketOf(to.Add(-1))
+	if err != nil {
+		return err
+	}
+	return s.store.ReadShelf(ctx, s.shelfName, func(reader stoabs.Reader) error {
+		return reader.Range(stoabs.Uint64Key(first), stoabs.Uint64Key(last+1), func(key stoabs.Key, value []byte) error {
+			points, err := decodeBucket(value)
+			if err != nil {
+				return fmt.Errorf("invalid time-series bucket (index=%d): %w", key, err)
+			}
+			for _, point := range points {
+				if point.Time.Before(from) || !point.Time.Before(to) {
+					continue
+				}
+				if err := fn(point); err != nil {
+					return err
+				}
+			}
+			return nil
+		}, false)
+	})
+}
+
+// Downsample groups the points from (inclusive) to (exclusive) the given times into consecutive windows of the given width,
+// and calls fn with the start of each window and its points, e.g. to compute an aggregate per window.
+// Windows are aligned as by time.Time.Truncate, and fn isn't called for windows without points.
+func (s *Series) Downsample(ctx context.Context, from, to time.Time, window time.Duration, fn func(start time.Time, points []Point) error) error {
+	if window <= 0 {
+		return errors.New("window must be positive")
+	}
+	var start time.Time
+	var points []Point
+	err := s.Range(ctx, from, to, func(point Point) error {
+		pointWindow := point.Time.Truncate(window)
+		if len(points) > 0 && !pointWindow.Equal(start) {
+			if err := fn(start, points); err != nil {
+				return err
+			}
+			points = nil
+		}
+		start = pointWindow
+		points = append(points, point)
+		return nil
+	})
+	if err != nil || len(points) == 0 {
+		return err
+	}
+	return fn(start, points)
+}
+
+// Prune removes the points older than the retention. It's called by Append, but can also be used to prune a series that
+// isn't appended to anymore. It does nothing if no retention is configured.
+func (s *Series) Prune(ctx context.Context) error {
+	if s.retention == 0 {
+		return nil
+	}
+	return s.store.Write(ctx, func(tx stoabs.WriteTx) error {
+		writer := tx.GetShelfWriter(s.shelfName)
+		current, err := readState(writer)
+		if err != nil {
+			return err
+		}
+		if err := s.prune(writer, &current); err != nil {
+			return err
+		}
+		return stoabs.JSONShelf[state](writer).Put(stateKey, current)
+	}, stoabs.WithShelfLock(s.shelfName))
+}
+
+// prune removes the buckets that only hold points older than the retention.
+func (s *Series) prune(writer stoabs.Writer, current *state) error {
+	if s.retention == 0 || current.Oldest == 0 {
+		return nil
+	}
+	cutoff, err := s.bucketOf(s.now().Add(-s.retention))
+	if err != nil {
+		// retention reaches before the Unix epoch, nothing to prune
+		return nil
+	}
+	if current.Oldest >= cutoff {
+		return nil
+	}
+	var expired []stoabs.Key
+	err = writer.Range(stoabs.Uint64Key(current.Oldest), stoabs.Uint64Key(cutoff), func(key stoabs.Key, _ []byte) error {
+		expired = append(expired, key)
+		return nil
+	}, false)
+	if err != nil {
+		return err
+	}
+	for _, key := range expired {
+		if err := writer.Delete(key); err != nil {
+			return err
+		}
+	}
+	current.Oldest = cutoff
+	return nil
+}
+
+func (s *Series) bucketOf(t time.Time) (uint64, error) {
+	if s.bucketWidth <= 0 {
+		return 0, errors.New("bucket width must be positive")
+	}
+	nanos := t.UnixNano()
+	if nanos < 0 {
+		return 0, fmt.Errorf("time before the Unix epoch: %s", t)
+	}
+	// bucket index 0 would clash with the state key, so the first bucket starts at index 1
+	return uint64(nanos)/uint64(s.bucketWidth) + 1, nil
+}
+
+func readState(reader stoabs.Reader) (state, error) {
+	result, err := stoabs.JSONShelf[state](reader).Get(stateKey)
+	if errors.Is(err, stoabs.ErrKeyNotFound) {
+		return state{}, nil
+	}
+	if err != nil {
+		return state{}, fmt.Errorf("unable to read time-series state: %w", err)
+	}
+	return result, nil
+}
+
+func readBucket(reader stoabs.Reader, index uint64) ([]Point, error) {
+	data, err := reader.Get(stoabs.Uint64Key(index))
+	if errors.Is(err, stoabs.ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	points, err := decodeBucket(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time-series bucket (index=%d): %w", index, err)
+	}
+	return points, nil
+}
+
+// insertPoints adds the new points to the points (ordered by time), keeping points with the same time in insertion order.
+func insertPoints(points []Point, newPoints []Point) []Point {
+	result := append(points, newPoints...)
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Time.Before(result[j].Time)
+	})
+	return result
+}
+
+func encodeBucket(points []Point) []byte {
+	var result []byte
+	for _, point := range points {
+		result = binary.BigEndian.AppendUint64(result, uint64(point.Time.UnixNano()))
+		result = binary.AppendUvarint(result, uint64(len(point.Value)))
+		result = append(result, point.Value...)
+	}
+	return result
+}
+
+func decodeBucket(data []byte) ([]Point, error) {
+	var result []Point
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, errors.New("truncated point")
+		}
+		nanos := int64(binary.BigEndian.Uint64(data))
+		length, n := binary.Uvarint(data[8:])
+		if n <= 0 || uint64(len(data)-8-n) < length {
+			return nil, errors.New("truncated point")
+		}
+		data = data[8+n:]
+		result = append(result, Point{Time: time.Unix(0, nanos), Value: append([]byte(nil), data[:length]...)})
+		data = data[length:]
+	}
+	return result, nil
+}
@@ -0,0 +1,167 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package timeseries
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/nuts-foundation/go-stoabs/mocks"
+	"github.com/nuts-foundation/go-stoabs/redis7"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+const shelfName = "metrics"
+
+var epoch = time.Unix(1_700_000_000, 0)
+
+func TestSeries_Range(t *testing.T) {
+	t.Run("ordered by time, within window", func(t *testing.T) {
+		series := New(stores.BBolt(t), shelfName)
+		require.NoError(t, series.Append(ctx,
+			point(2*time.Minute, "c"),
+			point(0, "a"),
+			point(30*time.Second, "b"),
+		))
+		require.NoError(t, series.Append(ctx, point(30*time.Second, "b2"), point(time.Hour, "d")))
+
+		assert.Equal(t, []string{"a", "b", "b2", "c"}, values(t, series, epoch, epoch.Add(time.Hour)))
+		assert.Equal(t, []string{"b", "b2"}, values(t, series, epoch.Add(time.Second), epoch.Add(2*time.Minute)))
+		assert.Empty(t, values(t, series, epoch.Add(time.Hour), epoch))
+	})
+	t.Run("empty series", func(t *testing.T) {
+		series := New(stores.BBolt(t), shelfName)
+
+		assert.Empty(t, values(t, series, epoch, epoch.Add(time.Hour)))
+	})
+	t.Run("time before epoch", func(t *testing.T) {
+		series := New(stores.BBolt(t), shelfName)
+
+		err := series.Append(ctx, Point{Time: time.Unix(-1, 0)})
+
+		assert.ErrorContains(t, err, "time before the Unix epoch")
+	})
+	t.Run("bucket width isn't positive", func(t *testing.T) {
+		for _, width := range []time.Duration{0, -time.Minute} {
+			series := New(stores.BBolt(t), shelfName, WithBucketWidth(width))
+
+			err := series.Append(ctx, point(0, "a"))
+			assert.EqualError(t, err, "bucket width must be positive")
+			err = series.Range(ctx, epoch, epoch.Add(time.Hour), func(Point) error {
+				return nil
+			})
+			assert.EqualError(t, err, "bucket width must be positive")
+		}
+	})
+	t.Run("Redis", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		store, err := redis7.CreateRedisStore("db", &redis.Options{Addr: mr.Addr()})
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = store.Close(ctx)
+		})
+		series := New(store, shelfName, WithBucketWidth(time.Hour))
+		require.NoError(t, series.Append(ctx, point(0, "a"), point(3*time.Hour, "b")))
+		require.NoError(t, series.Append(ctx, point(time.Minute, "a2")))
+
+		assert.Equal(t, []string{"a", "a2", "b"}, values(t, series, epoch, epoch.Add(24*time.Hour)))
+	})
+}
+
+func TestSeries_Downsample(t *testing.T) {
+	series := New(stores.BBolt(t), shelfName)
+	require.NoError(t, series.Append(ctx,
+		point(0, "a"),
+		point(10*time.Second, "b"),
+		point(3*time.Minute, "c"),
+	))
+
+	var windows []time.Time
+	var counts []int
+	err := series.Downsample(ctx, epoch, epoch.Add(time.Hour), time.Minute, func(start time.Time, points []Point) error {
+		windows = append(windows, start)
+		counts = append(counts, len(points))
+		return nil
+	})
+
+	require.NoError(t, err)
+	require.Len(t, windows, 2)
+	assert.True(t, windows[0].Equal(epoch.Truncate(time.Minute)))
+	assert.True(t, windows[1].Equal(epoch.Add(3*time.Minute).Truncate(time.Minute)))
+	assert.Equal(t, []int{2, 1}, counts)
+	assert.Error(t, series.Downsample(ctx, epoch, epoch.Add(time.Hour), 0, nil))
+}
+
+func TestSeries_retention(t *testing.T) {
+	store := stores.BBolt(t)
+	clock := mocks.NewClock(epoch)
+	series := New(store, shelfName, WithRetention(time.Hour), WithClock(clock))
+	require.NoError(t, series.Append(ctx, point(-2*time.Hour, "old"), point(-30*time.Minute, "recent")))
+	// old was pruned when appending
+	assert.Equal(t, []string{"recent"}, values(t, series, time.Unix(0, 0), clock.Now()))
+
+	clock.Advance(time.Hour)
+	require.NoError(t, series.Prune(ctx))
+
+	assert.Empty(t, values(t, series, time.Unix(0, 0), clock.Now()))
+	_ = store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+		current, err := readState(reader)
+		require.NoError(t, err)
+		expected, _ := series.bucketOf(clock.Now().Add(-time.Hour))
+		assert.Equal(t, expected, current.Oldest)
+		return nil
+	})
+}
+
+func Test_bucket(t *testing.T) {
+	points := []Point{point(0, "a"), point(time.Second, "")}
+
+	decoded, err := decodeBucket(encodeBucket(points))
+
+	require.NoError(t, err)
+	require.Len(t, decoded, 2)
+	assert.True(t, decoded[1].Time.Equal(points[1].Time))
+	assert.Equal(t, []byte("a"), decoded[0].Value)
+	_, err = decodeBucket([]byte{1, 2, 3})
+	assert.EqualError(t, err, "truncated point")
+	_, err = decodeBucket(append(make([]byte, 8), 5))
+	assert.EqualError(t, err, "truncated point")
+}
+
+func point(offset time.Duration, value string) Point {
+	return Point{Time: epoch.Add(offset), Value: []byte(value)}
+}
+
+func values(t *testing.T, series *Series, from, to time.Time) []string {
+	var result []string
+	err := series.Range(ctx, from, to, func(point Point) error {
+		result = append(result, string(point.Value))
+		return nil
+	})
+	require.NoError(t, err)
+	return result
+}
@@ -0,0 +1,80 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"errors"
+	"fmt"
+)
+
+// TypedShelf provides type-safe access to a shelf with keys of type K and values of type V.
+// Values are converted using a Codec.
+type TypedShelf[K Key, V any] struct {
+	shelf *CodecShelf[V]
+}
+
+// NewTypedShelf wraps the given shelf reader with the given codec. If reader is a Writer, values can also be written.
+func NewTypedShelf[K Key, V any](reader Reader, codec Codec[V]) *TypedShelf[K, V] {
+	return &TypedShelf[K, V]{shelf: NewCodecShelf[V](reader, codec)}
+}
+
+// Get returns the value for the given key. If the key does not exist, it returns false (and no error).
+func (s *TypedShelf[K, V]) Get(key K) (V, bool, error) {
+	value, err := s.shelf.Get(key)
+	if errors.Is(err, ErrKeyNotFound) {
+		return value, false, nil
+	}
+	if err != nil {
+		return value, false, err
+	}
+	return value, true, nil
+}
+
+// Put stores the given value.
+// Returns ErrReadOnlyShelf if the shelf was created from a Reader that isn't a Writer.
+func (s *TypedShelf[K, V]) Put(key K, value V) error {
+	return s.shelf.Put(key, value)
+}
+
+// Delete removes the given key.
+// Returns ErrReadOnlyShelf if the shelf was created from a Reader that isn't a Writer.
+func (s *TypedShelf[K, V]) Delete(key K) error {
+	return s.shelf.Delete(key)
+}
+
+// Iterate calls the callback for every entry in the shelf, see Reader.Iterate.
+func (s *TypedShelf[K, V]) Iterate(callback func(key K, value V) error) error {
+	var keyType K
+	return s.shelf.Iterate(typedCallback(callback), keyType)
+}
+
+// Range calls the callback for every entry with a key in the range [from, to), see Reader.Range.
+func (s *TypedShelf[K, V]) Range(from K, to K, callback func(key K, value V) error) error {
+	return s.shelf.Range(from, to, typedCallback(callback), false)
+}
+
+func typedCallback[K Key, V any](callback func(key K, value V) error) func(key Key, value V) error {
+	return func(key Key, value V) error {
+		typedKey, ok := key.(K)
+		if !ok {
+			return fmt.Errorf("unexpected key type: %T", key)
+		}
+		return callback(typedKey, value)
+	}
+}
@@ -0,0 +1,131 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestTypedShelf(t *testing.T) {
+	key := Uint32Key(1)
+	value := testValue{Name: "test", Count: 2}
+	data := []byte(`{"Name":"test","Count":2}`)
+
+	t.Run("Get", func(t *testing.T) {
+		reader := NewMockReader(gomock.NewController(t))
+		reader.EXPECT().Get(key).Return(data, nil)
+
+		actual, exists, err := NewTypedShelf[Uint32Key, testValue](reader, JSONCodec[testValue]{}).Get(key)
+
+		require.NoError(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, value, actual)
+	})
+	t.Run("Get - not found", func(t *testing.T) {
+		reader := NewMockReader(gomock.NewController(t))
+		reader.EXPECT().Get(key).Return(nil, ErrKeyNotFound)
+
+		actual, exists, err := NewTypedShelf[Uint32Key, testValue](reader, JSONCodec[testValue]{}).Get(key)
+
+		require.NoError(t, err)
+		assert.False(t, exists)
+		assert.Empty(t, actual)
+	})
+	t.Run("Get - error", func(t *testing.T) {
+		reader := NewMockReader(gomock.NewController(t))
+		reader.EXPECT().Get(key).Return(nil, ErrStoreIsClosed)
+
+		_, exists, err := NewTypedShelf[Uint32Key, testValue](reader, JSONCodec[testValue]{}).Get(key)
+
+		assert.ErrorIs(t, err, ErrStoreIsClosed)
+		assert.False(t, exists)
+	})
+	t.Run("Put and Delete", func(t *testing.T) {
+		writer := NewMockWriter(gomock.NewController(t))
+		writer.EXPECT().Put(key, data)
+		writer.EXPECT().Delete(key)
+		shelf := NewTypedShelf[Uint32Key, testValue](writer, JSONCodec[testValue]{})
+
+		assert.NoError(t, shelf.Put(key, value))
+		assert.NoError(t, shelf.Delete(key))
+	})
+	t.Run("Iterate", func(t *testing.T) {
+		reader := NewMockReader(gomock.NewController(t))
+		reader.EXPECT().Iterate(gomock.Any(), Uint32Key(0)).DoAndReturn(func(callback CallerFn, _ Key) error {
+			return callback(key, data)
+		})
+		var keys []Uint32Key
+
+		err := NewTypedShelf[Uint32Key, testValue](reader, JSONCodec[testValue]{}).Iterate(func(key Uint32Key, actual testValue) error {
+			keys = append(keys, key)
+			assert.Equal(t, value, actual)
+			return nil
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, []Uint32Key{key}, keys)
+	})
+	t.Run("Range", func(t *testing.T) {
+		reader := NewMockReader(gomock.NewController(t))
+		reader.EXPECT().Range(Uint32Key(1), Uint32Key(10), gomock.Any(), false).DoAndReturn(func(_ Key, _ Key, callback CallerFn, _ bool) error {
+			if err := callback(Uint32Key(1), data); err != nil {
+				return err
+			}
+			return callback(Uint32Key(2), data)
+		})
+		var keys []Uint32Key
+
+		err := NewTypedShelf[Uint32Key, testValue](reader, JSONCodec[testValue]{}).Range(1, 10, func(key Uint32Key, _ testValue) error {
+			keys = append(keys, key)
+			return nil
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, []Uint32Key{1, 2}, keys)
+	})
+	t.Run("Range - callback error", func(t *testing.T) {
+		reader := NewMockReader(gomock.NewController(t))
+		reader.EXPECT().Range(Uint32Key(1), Uint32Key(10), gomock.Any(), false).DoAndReturn(func(_ Key, _ Key, callback CallerFn, _ bool) error {
+			return callback(Uint32Key(1), data)
+		})
+
+		err := NewTypedShelf[Uint32Key, testValue](reader, JSONCodec[testValue]{}).Range(1, 10, func(_ Uint32Key, _ testValue) error {
+			return errors.New("failed")
+		})
+
+		assert.EqualError(t, err, "failed")
+	})
+	t.Run("unexpected key type", func(t *testing.T) {
+		reader := NewMockReader(gomock.NewController(t))
+		reader.EXPECT().Iterate(gomock.Any(), Uint32Key(0)).DoAndReturn(func(callback CallerFn, _ Key) error {
+			return callback(BytesKey{1}, data)
+		})
+
+		err := NewTypedShelf[Uint32Key, testValue](reader, JSONCodec[testValue]{}).Iterate(func(_ Uint32Key, _ testValue) error {
+			return nil
+		})
+
+		assert.EqualError(t, err, "unexpected key type: stoabs.BytesKey")
+	})
+}
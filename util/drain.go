@@ -0,0 +1,209 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package util
+
+import (
+	"context"
+	"github.com/nuts-foundation/go-stoabs"
+	"sync"
+)
+
+// Drainer tracks the in-flight transactions of a store, so it can be closed as specified by stoabs.Store.Close:
+// new transactions are rejected once closing, and the store is closed after in-flight transactions finished
+// (or were aborted). The zero value is an open Drainer.
+type Drainer struct {
+	mux   sync.Mutex
+	state stoabs.State
+	// nextID is the ID of the next transaction, used as key in cancels.
+	nextID uint64
+	// cancels holds the function to cancel the context of each in-flight transaction.
+	cancels map[uint64]context.CancelFunc
+	// drained is closed when closing and there are no in-flight transactions.
+	drained chan struct{}
+	// closed is closed when the store is closed.
+	closed chan struct{}
+	// frozen is set when write transactions are rejected, see Freeze.
+	frozen bool
+	// writes holds the number of in-flight write transactions.
+	writes int
+	// writesDrained is closed when there are no in-flight write transactions, if Freeze is waiting for them.
+	writesDrained chan struct{}
+}
+
+// Begin registers a transaction. It returns the context the transaction must use, which is cancelled when the
+// transaction is aborted, and a function that must be called when the transaction finishes.
+// If the store is closing or closed, it returns stoabs.ErrStoreIsClosed.
+func (d *Drainer) Begin(ctx context.Context) (context.Context, func(), error) {
+	return d.begin(ctx, false)
+}
+
+// BeginWrite registers a write transaction like Begin does. If the store is frozen, it returns stoabs.ErrFrozen.
+func (d *Drainer) BeginWrite(ctx context.Context) (context.Context, func(), error) {
+	return d.begin(ctx, true)
+}
+
+func (d *Drainer) begin(ctx context.Context, write bool) (context.Context, func(), error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if d.state != stoabs.StateOpen {
+		return nil, nil, stoabs.ErrStoreIsClosed
+	}
+	if write {
+		if d.frozen {
+			return nil, nil, stoabs.ErrFrozen
+		}
+		d.writes++
+	}
+	if d.cancels == nil {
+		d.cancels = map[uint64]context.CancelFunc{}
+	}
+	id := d.nextID
+	d.nextID++
+	txCtx, cancel := context.WithCancel(ctx)
+	d.cancels[id] = cancel
+	var once sync.Once
+	return txCtx, func() {
+		once.Do(func() {
+			cancel()
+			d.mux.Lock()
+			defer d.mux.Unlock()
+			delete(d.cancels, id)
+			if write {
+				d.writes--
+				if d.writes == 0 && d.writesDrained != nil {
+					close(d.writesDrained)
+					d.writesDrained = nil
+				}
+			}
+			if d.state == stoabs.StateClosing && len(d.cancels) == 0 {
+				close(d.drained)
+			}
+		})
+	}, nil
+}
+
+// Close moves the Drainer to stoabs.StateClosing and waits for in-flight transactions to finish,
+// after which closeFn is called to release the resources of the store.
+// If the context is done first, the in-flight transactions are aborted by cancelling their context and a stoabs.ErrDatabase
+// with the context error is returned. closeFn is then called in the background once the aborted transactions returned.
+// Calling Close on a closed Drainer returns nil, calling it while closing waits for the other call to finish.
+func (d *Drainer) Close(ctx context.Context, closeFn func() error) error {
+	d.mux.Lock()
+	if d.state != stoabs.StateOpen {
+		closed := d.closed
+		d.mux.Unlock()
+		select {
+		case <-closed:
+			return nil
+		case <-ctx.Done():
+			return stoabs.DatabaseError(ctx.Err())
+		}
+	}
+	d.state = stoabs.StateClosing
+	d.drained = make(chan struct{})
+	d.closed = make(chan struct{})
+	if len(d.cancels) == 0 {
+		close(d.drained)
+	}
+	d.mux.Unlock()
+
+	if ctx.Err() == nil {
+		select {
+		case <-d.drained:
+			return d.finish(closeFn)
+		case <-ctx.Done():
+		}
+	}
+	// abort in-flight transactions
+	d.mux.Lock()
+	for _, cancel := range d.cancels {
+		cancel()
+	}
+	d.mux.Unlock()
+	go func() {
+		<-d.drained
+		_ = d.finish(closeFn)
+	}()
+	return stoabs.DatabaseError(ctx.Err())
+}
+
+// Freeze makes new write transactions fail with stoabs.ErrFrozen, and waits for in-flight write transactions to finish
+// as specified by stoabs.Freezer.Freeze.
+func (d *Drainer) Freeze(ctx context.Context) error {
+	d.mux.Lock()
+	if d.state != stoabs.StateOpen {
+		d.mux.Unlock()
+		return stoabs.ErrStoreIsClosed
+	}
+	d.frozen = true
+	if d.writes == 0 {
+		d.mux.Unlock()
+		return nil
+	}
+	if d.writesDrained == nil {
+		d.writesDrained = make(chan struct{})
+	}
+	drained := d.writesDrained
+	d.mux.Unlock()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return stoabs.DatabaseError(ctx.Err())
+	}
+}
+
+// Unfreeze allows write transactions again.
+func (d *Drainer) Unfreeze() {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.frozen = false
+}
+
+// Frozen returns whether write transactions are rejected.
+func (d *Drainer) Frozen() bool {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	return d.frozen
+}
+
+// finish calls closeFn and moves the Drainer to stoabs.StateClosed.
+func (d *Drainer) finish(closeFn func() error) error {
+	err := closeFn()
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.state = stoabs.StateClosed
+	close(d.closed)
+	return err
+}
+
+// State returns the lifecycle state of the store.
+func (d *Drainer) State() stoabs.State {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	return d.state
+}
+
+// InFlight returns the number of in-flight transactions.
+func (d *Drainer) InFlight() int {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	return len(d.cancels)
+}
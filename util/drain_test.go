@@ -0,0 +1,206 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package util
+
+import (
+	"context"
+	"errors"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestDrainer(t *testing.T) {
+	closeFn := func(closed *int) func() error {
+		return func() error {
+			*closed++
+			return nil
+		}
+	}
+	t.Run("close without transactions", func(t *testing.T) {
+		d := Drainer{}
+		closed := 0
+
+		assert.NoError(t, d.Close(context.Background(), closeFn(&closed)))
+		assert.NoError(t, d.Close(context.Background(), closeFn(&closed)))
+
+		assert.Equal(t, 1, closed)
+		assert.Equal(t, stoabs.StateClosed, d.State())
+	})
+	t.Run("begin after close", func(t *testing.T) {
+		d := Drainer{}
+		require.NoError(t, d.Close(context.Background(), closeFn(new(int))))
+
+		_, _, err := d.Begin(context.Background())
+
+		assert.ErrorIs(t, err, stoabs.ErrStoreIsClosed)
+	})
+	t.Run("waits for in-flight transactions", func(t *testing.T) {
+		d := Drainer{}
+		closed := 0
+		txCtx, done, err := d.Begin(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, 1, d.InFlight())
+		result := make(chan error, 1)
+
+		go func() {
+			result <- d.Close(context.Background(), closeFn(&closed))
+		}()
+		assert.Eventually(t, func() bool {
+			return d.State() == stoabs.StateClosing
+		}, time.Second, time.Millisecond)
+		_, _, err = d.Begin(context.Background())
+		assert.ErrorIs(t, err, stoabs.ErrStoreIsClosed)
+		assert.NoError(t, txCtx.Err())
+		done()
+		done() // is idempotent
+
+		assert.NoError(t, <-result)
+		assert.Equal(t, 0, d.InFlight())
+		assert.Equal(t, 1, closed)
+		assert.Equal(t, stoabs.StateClosed, d.State())
+	})
+	t.Run("aborts in-flight transactions when context is done", func(t *testing.T) {
+		d := Drainer{}
+		closeErr := errors.New("close failed")
+		closed := make(chan struct{})
+		txCtx, done, err := d.Begin(context.Background())
+		require.NoError(t, err)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		err = d.Close(ctx, func() error {
+			close(closed)
+			return closeErr
+		})
+
+		assert.Equal(t, stoabs.DatabaseError(context.DeadlineExceeded), err)
+		assert.ErrorIs(t, txCtx.Err(), context.Canceled)
+		assert.Equal(t, stoabs.StateClosing, d.State())
+		// resources are released when the aborted transaction returns
+		done()
+		<-closed
+		assert.Eventually(t, func() bool {
+			return d.State() == stoabs.StateClosed
+		}, time.Second, time.Millisecond)
+	})
+	t.Run("concurrent close waits for first close", func(t *testing.T) {
+		d := Drainer{}
+		_, done, err := d.Begin(context.Background())
+		require.NoError(t, err)
+		first := make(chan error, 1)
+		go func() {
+			first <- d.Close(context.Background(), closeFn(new(int)))
+		}()
+		assert.Eventually(t, func() bool {
+			return d.State() == stoabs.StateClosing
+		}, time.Second, time.Millisecond)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		assert.ErrorIs(t, d.Close(ctx, closeFn(new(int))), context.DeadlineExceeded)
+		done()
+		assert.NoError(t, <-first)
+		assert.NoError(t, d.Close(context.Background(), closeFn(new(int))))
+	})
+}
+
+func TestDrainer_Freeze(t *testing.T) {
+	t.Run("rejects writes until unfrozen", func(t *testing.T) {
+		d := Drainer{}
+
+		require.NoError(t, d.Freeze(context.Background()))
+
+		assert.True(t, d.Frozen())
+		_, _, err := d.BeginWrite(context.Background())
+		assert.ErrorIs(t, err, stoabs.ErrFrozen)
+		_, done, err := d.Begin(context.Background())
+		require.NoError(t, err, "reads are allowed")
+		done()
+
+		d.Unfreeze()
+
+		assert.False(t, d.Frozen())
+		_, done, err = d.BeginWrite(context.Background())
+		require.NoError(t, err)
+		done()
+	})
+	t.Run("waits for in-flight writes", func(t *testing.T) {
+		d := Drainer{}
+		_, doneRead, err := d.Begin(context.Background())
+		require.NoError(t, err)
+		defer doneRead()
+		_, doneWrite, err := d.BeginWrite(context.Background())
+		require.NoError(t, err)
+		result := make(chan error, 1)
+
+		go func() {
+			result <- d.Freeze(context.Background())
+		}()
+		assert.Eventually(t, d.Frozen, time.Second, time.Millisecond)
+		assert.Len(t, result, 0)
+		doneWrite()
+		doneWrite() // is idempotent
+
+		assert.NoError(t, <-result)
+		t.Run("freeze again", func(t *testing.T) {
+			assert.NoError(t, d.Freeze(context.Background()))
+		})
+	})
+	t.Run("context done before writes finished", func(t *testing.T) {
+		d := Drainer{}
+		_, doneWrite, err := d.BeginWrite(context.Background())
+		require.NoError(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err = d.Freeze(ctx)
+
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.ErrorIs(t, err, stoabs.ErrDatabase{})
+		assert.True(t, d.Frozen(), "stays frozen")
+		doneWrite()
+		assert.NoError(t, d.Freeze(context.Background()))
+	})
+	t.Run("unfrozen while waiting", func(t *testing.T) {
+		d := Drainer{}
+		_, doneWrite, err := d.BeginWrite(context.Background())
+		require.NoError(t, err)
+		result := make(chan error, 1)
+		go func() {
+			result <- d.Freeze(context.Background())
+		}()
+		assert.Eventually(t, d.Frozen, time.Second, time.Millisecond)
+
+		d.Unfreeze()
+		doneWrite()
+
+		assert.NoError(t, <-result)
+	})
+	t.Run("closed", func(t *testing.T) {
+		d := Drainer{}
+		require.NoError(t, d.Close(context.Background(), func() error {
+			return nil
+		}))
+
+		assert.ErrorIs(t, d.Freeze(context.Background()), stoabs.ErrStoreIsClosed)
+	})
+}
@@ -19,8 +19,11 @@
 package util
 
 import (
+	"context"
 	"errors"
 	"fmt"
+
+	"github.com/nuts-foundation/go-stoabs"
 )
 
 type wrappedError struct {
@@ -34,6 +37,10 @@ func (w wrappedError) Error() string {
 }
 
 func (w wrappedError) Is(other error) bool {
+	if other == stoabs.ErrTimeout && errors.Is(w.err, stoabs.ErrDatabase{}) && errors.Is(w.cause, context.DeadlineExceeded) {
+		// a database error caused by an expired context is a timeout
+		return true
+	}
 	return errors.Is(w.err, other)
 }
 
@@ -48,3 +55,14 @@ func WrapError(err error, cause error) error {
 		cause: cause,
 	}
 }
+
+// IsStoreFailure returns whether err signals a failure of the store (e.g. an I/O error or a closed store),
+// as opposed to an application error (e.g. returned by a transaction function, or stoabs.ErrKeyNotFound) or an
+// unsupported operation. Note that errors caused by context cancellation are also considered store failures,
+// callers should check the context to distinguish them.
+func IsStoreFailure(err error) bool {
+	if err == nil || errors.Is(err, errors.ErrUnsupported) {
+		return false
+	}
+	return errors.Is(err, stoabs.ErrDatabase{})
+}
@@ -19,9 +19,12 @@
 package util
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"testing"
 
+	"github.com/nuts-foundation/go-stoabs"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -33,7 +36,31 @@ func TestWrapError(t *testing.T) {
 	assert.ErrorIs(t, wrapped, cause)
 }
 
+func Test_wrappedError_Is(t *testing.T) {
+	t.Run("database error caused by deadline is a timeout", func(t *testing.T) {
+		wrapped := WrapError(stoabs.ErrCommitFailed, context.DeadlineExceeded)
+		assert.ErrorIs(t, wrapped, stoabs.ErrTimeout)
+		assert.ErrorIs(t, wrapped, stoabs.ErrCommitFailed)
+	})
+	t.Run("database error caused by cancellation is not a timeout", func(t *testing.T) {
+		assert.NotErrorIs(t, WrapError(stoabs.ErrCommitFailed, context.Canceled), stoabs.ErrTimeout)
+	})
+	t.Run("non-database error caused by deadline is not a timeout", func(t *testing.T) {
+		assert.NotErrorIs(t, WrapError(errors.New("application error"), context.DeadlineExceeded), stoabs.ErrTimeout)
+	})
+}
+
 func Test_wrappedError_Error(t *testing.T) {
 	wrapped := WrapError(errors.New("original"), errors.New("cause"))
 	assert.EqualError(t, wrapped, "original: cause")
 }
+
+func TestIsStoreFailure(t *testing.T) {
+	assert.True(t, IsStoreFailure(stoabs.DatabaseError(errors.New("connection refused"))))
+	assert.True(t, IsStoreFailure(fmt.Errorf("wrapped: %w", stoabs.ErrStoreIsClosed)))
+	assert.True(t, IsStoreFailure(stoabs.ErrCommitFailed))
+	assert.False(t, IsStoreFailure(nil))
+	assert.False(t, IsStoreFailure(errors.New("application error")))
+	assert.False(t, IsStoreFailure(stoabs.ErrKeyNotFound))
+	assert.False(t, IsStoreFailure(stoabs.DatabaseError(errors.ErrUnsupported)))
+}
@@ -0,0 +1,231 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package util
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"github.com/nuts-foundation/go-stoabs"
+	"sync"
+	"time"
+)
+
+// leaseShelf is the shelf holding the leases acquired through AcquireShelfLease, keyed by name.
+// Values consist of the expiry time (Unix nanoseconds, 8 bytes big endian) followed by the token of the owner.
+const leaseShelf = stoabs.ReservedShelfPrefix + "leases"
+
+// LeaseBackend persists the leases of a store, see NewLease.
+type LeaseBackend interface {
+	// ExtendLease extends the lease by the given TTL, if it's still held with the given token. It returns false otherwise.
+	ExtendLease(ctx context.Context, name string, token string, ttl time.Duration) (bool, error)
+	// ReleaseLease removes the lease, if it's still held with the given token.
+	ReleaseLease(ctx context.Context, name string, token string) error
+}
+
+// NewLeaseToken returns a random token identifying the owner of a lease.
+func NewLeaseToken() string {
+	token := make([]byte, 16)
+	_, _ = rand.Read(token)
+	return hex.EncodeToString(token)
+}
+
+// NewLease returns a stoabs.Lease for a lease that was just acquired in the backend with the given token.
+// It expires locally after the TTL unless it's kept alive, invoking the callbacks registered with OnExpired.
+func NewLease(backend LeaseBackend, name string, token string, ttl time.Duration) stoabs.Lease {
+	result := &lease{
+		backend:   backend,
+		name:      name,
+		token:     token,
+		ttl:       ttl,
+		expiresAt: time.Now().Add(ttl),
+	}
+	// hold the lock, since the timer may fire before it's assigned (e.g. for a very short TTL)
+	result.mux.Lock()
+	result.timer = time.AfterFunc(ttl, result.expire)
+	result.mux.Unlock()
+	return result
+}
+
+type lease struct {
+	backend   LeaseBackend
+	name      string
+	token     string
+	ttl       time.Duration
+	mux       sync.Mutex
+	expiresAt time.Time
+	timer     *time.Timer
+	// lost is true if the lease expired, was lost or was released
+	lost      bool
+	released  bool
+	callbacks []func()
+}
+
+func (l *lease) Name() string {
+	return l.name
+}
+
+func (l *lease) ExpiresAt() time.Time {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return l.expiresAt
+}
+
+func (l *lease) KeepAlive(ctx context.Context) error {
+	l.mux.Lock()
+	lost := l.lost
+	l.mux.Unlock()
+	if lost {
+		return stoabs.ErrLeaseLost
+	}
+	// the lease is extended from the time the request is sent, so it doesn't expire locally later than in the backend
+	start := time.Now()
+	extended, err := l.backend.ExtendLease(ctx, l.name, l.token, l.ttl)
+	if err != nil {
+		return err
+	}
+	if !extended {
+		l.expire()
+		return stoabs.ErrLeaseLost
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if l.lost {
+		return stoabs.ErrLeaseLost
+	}
+	l.expiresAt = start.Add(l.ttl)
+	l.timer.Reset(time.Until(l.expiresAt))
+	return nil
+}
+
+func (l *lease) Release(ctx context.Context) error {
+	l.mux.Lock()
+	if l.lost {
+		l.mux.Unlock()
+		return nil
+	}
+	l.lost = true
+	l.released = true
+	l.callbacks = nil
+	l.timer.Stop()
+	l.mux.Unlock()
+	return l.backend.ReleaseLease(ctx, l.name, l.token)
+}
+
+func (l *lease) OnExpired(fn func()) {
+	l.mux.Lock()
+	if !l.lost {
+		l.callbacks = append(l.callbacks, fn)
+		l.mux.Unlock()
+		return
+	}
+	released := l.released
+	l.mux.Unlock()
+	if !released {
+		fn()
+	}
+}
+
+func (l *lease) expire() {
+	l.mux.Lock()
+	if l.lost {
+		l.mux.Unlock()
+		return
+	}
+	l.lost = true
+	l.timer.Stop()
+	callbacks := l.callbacks
+	l.callbacks = nil
+	l.mux.Unlock()
+	for _, callback := range callbacks {
+		callback()
+	}
+}
+
+// AcquireShelfLease acquires a lease that is stored in a shelf of the given store, for backends that don't support
+// expiring keys natively (e.g. BBolt). Expired leases remain in the shelf until they're acquired again.
+func AcquireShelfLease(ctx context.Context, store stoabs.KVStore, name string, ttl time.Duration) (stoabs.Lease, error) {
+	backend := shelfLeaseBackend{store: store}
+	token := NewLeaseToken()
+	err := store.WriteShelf(ctx, leaseShelf, func(writer stoabs.Writer) error {
+		_, held, err := readShelfLease(writer, name)
+		if err != nil {
+			return err
+		}
+		if held {
+			return stoabs.ErrLeaseHeld
+		}
+		return writeShelfLease(writer, name, token, ttl)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return NewLease(backend, name, token, ttl), nil
+}
+
+type shelfLeaseBackend struct {
+	store stoabs.KVStore
+}
+
+func (s shelfLeaseBackend) ExtendLease(ctx context.Context, name string, token string, ttl time.Duration) (bool, error) {
+	extended := false
+	err := s.store.WriteShelf(ctx, leaseShelf, func(writer stoabs.Writer) error {
+		owner, held, err := readShelfLease(writer, name)
+		if err != nil || !held || owner != token {
+			return err
+		}
+		extended = true
+		return writeShelfLease(writer, name, token, ttl)
+	})
+	return extended, err
+}
+
+func (s shelfLeaseBackend) ReleaseLease(ctx context.Context, name string, token string) error {
+	return s.store.WriteShelf(ctx, leaseShelf, func(writer stoabs.Writer) error {
+		owner, held, err := readShelfLease(writer, name)
+		if err != nil || !held || owner != token {
+			return err
+		}
+		return writer.Delete(stoabs.BytesKey(name))
+	})
+}
+
+// readShelfLease returns the token of the owner of the given lease, and whether it's currently held (not expired).
+func readShelfLease(reader stoabs.Reader, name string) (string, bool, error) {
+	value, err := reader.Get(stoabs.BytesKey(name))
+	if errors.Is(err, stoabs.ErrKeyNotFound) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	if len(value) < 8 {
+		return "", false, errors.New("invalid lease")
+	}
+	expiresAt := time.Unix(0, int64(binary.BigEndian.Uint64(value)))
+	return string(bytes.Clone(value[8:])), time.Now().Before(expiresAt), nil
+}
+
+func writeShelfLease(writer stoabs.Writer, name string, token string, ttl time.Duration) error {
+	value := binary.BigEndian.AppendUint64(nil, uint64(time.Now().Add(ttl).UnixNano()))
+	return writer.Put(stoabs.BytesKey(name), append(value, token...))
+}
@@ -0,0 +1,109 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package util
+
+import (
+	"context"
+	"errors"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+type stubLeaseBackend struct {
+	extended  bool
+	extendErr error
+	released  int
+}
+
+func (s *stubLeaseBackend) ExtendLease(_ context.Context, _ string, _ string, _ time.Duration) (bool, error) {
+	return s.extended, s.extendErr
+}
+
+func (s *stubLeaseBackend) ReleaseLease(_ context.Context, _ string, _ string) error {
+	s.released++
+	return nil
+}
+
+func TestNewLease(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("keep alive fails", func(t *testing.T) {
+		backend := &stubLeaseBackend{extendErr: errors.New("failed")}
+		lease := NewLease(backend, "resource", NewLeaseToken(), time.Minute)
+		defer lease.Release(ctx)
+
+		err := lease.KeepAlive(ctx)
+
+		assert.EqualError(t, err, "failed")
+		t.Run("lease isn't lost", func(t *testing.T) {
+			backend.extendErr = nil
+			backend.extended = true
+
+			assert.NoError(t, lease.KeepAlive(ctx))
+		})
+	})
+	t.Run("lease lost in backend", func(t *testing.T) {
+		lease := NewLease(&stubLeaseBackend{}, "resource", NewLeaseToken(), time.Minute)
+		calls := 0
+		lease.OnExpired(func() {
+			calls++
+		})
+
+		err := lease.KeepAlive(ctx)
+
+		assert.ErrorIs(t, err, stoabs.ErrLeaseLost)
+		assert.Equal(t, 1, calls)
+		t.Run("callback registered after expiry is invoked immediately", func(t *testing.T) {
+			lease.OnExpired(func() {
+				calls++
+			})
+
+			assert.Equal(t, 2, calls)
+		})
+	})
+	t.Run("released lease doesn't expire", func(t *testing.T) {
+		backend := &stubLeaseBackend{}
+		lease := NewLease(backend, "resource", NewLeaseToken(), 10*time.Millisecond)
+		called := make(chan struct{})
+		lease.OnExpired(func() {
+			close(called)
+		})
+
+		require.NoError(t, lease.Release(ctx))
+		require.NoError(t, lease.Release(ctx))
+
+		assert.Equal(t, 1, backend.released)
+		lease.OnExpired(func() {
+			t.Error("callback invoked")
+		})
+		select {
+		case <-called:
+			t.Fatal("callback invoked")
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+}
+
+func TestNewLeaseToken(t *testing.T) {
+	assert.Len(t, NewLeaseToken(), 32)
+	assert.NotEqual(t, NewLeaseToken(), NewLeaseToken())
+}
@@ -21,6 +21,8 @@ package util
 import (
 	"context"
 	"github.com/nuts-foundation/go-stoabs"
+	"slices"
+	"sort"
 	"sync"
 	"sync/atomic"
 )
@@ -40,6 +42,8 @@ var _ RWLocker = &sync.RWMutex{}
 // ContextRWLocker returns a RWMutex that supports context cancellation.
 type ContextRWLocker struct {
 	mux sync.RWMutex
+	// holder is the label of the current holder of the write lock, see LockContextHolder.
+	holder atomic.Pointer[string]
 }
 
 // LockContext locks the RWLocker with the given context.
@@ -56,6 +60,25 @@ func (c *ContextRWLocker) RLockContext(ctx context.Context) error {
 	return lockWithCancel(ctx, c.mux.RLock, c.mux.RUnlock)
 }
 
+// LockContextHolder is like LockContext, but records the given label as holder of the lock until it's released.
+// The holder of the lock can be retrieved using Holder.
+func (c *ContextRWLocker) LockContextHolder(ctx context.Context, label string) error {
+	if err := c.LockContext(ctx); err != nil {
+		return err
+	}
+	c.holder.Store(&label)
+	return nil
+}
+
+// Holder returns the label of the current holder of the write lock as specified to LockContextHolder.
+// It returns an empty string if the lock isn't held, or if the holder didn't specify a label.
+func (c *ContextRWLocker) Holder() string {
+	if holder := c.holder.Load(); holder != nil {
+		return *holder
+	}
+	return ""
+}
+
 func lockWithCancel(ctx context.Context, fnLock func(), fnUnlock func()) error {
 	locked := make(chan bool)
 	expired := &atomic.Value{}
@@ -116,8 +139,9 @@ func (c *ContextRWLocker) TryRLock() bool {
 	return c.mux.TryRLock()
 }
 
-// Unlock simply calls the underlying lock.
+// Unlock clears the holder of the lock and calls the underlying lock.
 func (c *ContextRWLocker) Unlock() {
+	c.holder.Store(nil)
 	c.mux.Unlock()
 }
 
@@ -125,3 +149,161 @@ func (c *ContextRWLocker) Unlock() {
 func (c *ContextRWLocker) RUnlock() {
 	c.mux.RUnlock()
 }
+
+// KeyLocker provides exclusive, context-aware locks on arbitrary names (e.g. keys).
+// Locks are held by the lock owner of the context passed to Lock (see stoabs.ContextWithLockOwner),
+// which is used to detect deadlocks between owners. The zero value is ready to use.
+type KeyLocker struct {
+	mux   sync.Mutex
+	locks map[string]*keyLock
+	// waiting contains the name of the lock each owner is waiting for.
+	waiting map[*stoabs.LockOwner]string
+}
+
+type keyLock struct {
+	// holder is the owner holding the lock, nil if the lock is free.
+	holder *stoabs.LockOwner
+	// released is closed (and replaced) when the lock is released.
+	released chan struct{}
+	waiters  int
+}
+
+// KeyLockState describes a lock acquired using KeyLocker, see KeyLocker.Locks.
+type KeyLockState struct {
+	Name string
+	// Holder is the label of the owner holding the lock.
+	Holder string
+	// Waiters contains the labels of the owners waiting for the lock, sorted.
+	Waiters []string
+}
+
+// Lock acquires the locks for the given names, blocking until all locks are acquired or the context is cancelled.
+// Names are locked in sorted order, so callers locking overlapping sets of names can't deadlock.
+// If the context is cancelled, locks acquired so far are released and the (wrapped) context error is returned.
+// If acquiring a lock would deadlock, because its holder is (transitively) waiting for a lock held by the owner of the context,
+// locks acquired so far are released and stoabs.ErrDeadlock is returned.
+// The returned function releases all locks, it must be called exactly once.
+func (k *KeyLocker) Lock(ctx context.Context, names ...string) (func(), error) {
+	names = append([]string(nil), names...)
+	sort.Strings(names)
+	names = slices.Compact(names)
+	owner := stoabs.LockOwnerFromContext(ctx)
+	if owner == nil {
+		owner = &stoabs.LockOwner{}
+	}
+	var acquired []string
+	release := func() {
+		k.mux.Lock()
+		defer k.mux.Unlock()
+		for i := len(acquired) - 1; i >= 0; i-- {
+			k.release(acquired[i])
+		}
+	}
+	for _, name := range names {
+		if err := k.acquire(ctx, owner, name); err != nil {
+			release()
+			return nil, err
+		}
+		acquired = append(acquired, name)
+	}
+	return release, nil
+}
+
+// acquire acquires the lock with the given name for the given owner.
+func (k *KeyLocker) acquire(ctx context.Context, owner *stoabs.LockOwner, name string) error {
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	if k.locks == nil {
+		k.locks = map[string]*keyLock{}
+		k.waiting = map[*stoabs.LockOwner]string{}
+	}
+	lock, ok := k.locks[name]
+	if !ok {
+		lock = &keyLock{released: make(chan struct{})}
+		k.locks[name] = lock
+	}
+	for lock.holder != nil {
+		// The wait-for graph changes only while holding k.mux, so the owner closing a cycle always detects it.
+		k.waiting[owner] = name
+		if k.deadlocked(owner) {
+			delete(k.waiting, owner)
+			k.removeIfUnused(name, lock)
+			return stoabs.ErrDeadlock
+		}
+		released := lock.released
+		lock.waiters++
+		k.mux.Unlock()
+		var err error
+		select {
+		case <-released:
+		case <-ctx.Done():
+			err = stoabs.DatabaseError(ctx.Err())
+		}
+		k.mux.Lock()
+		lock.waiters--
+		delete(k.waiting, owner)
+		if err != nil {
+			k.removeIfUnused(name, lock)
+			return err
+		}
+	}
+	lock.holder = owner
+	return nil
+}
+
+// deadlocked returns whether the lock the given owner is waiting for is (transitively) held by an owner waiting for
+// a lock held by the given owner. It must be called with k.mux held.
+func (k *KeyLocker) deadlocked(owner *stoabs.LockOwner) bool {
+	// every owner waits for at most one lock, so the path can't be longer than the number of waiting owners without a cycle
+	name := k.waiting[owner]
+	for i := 0; i < len(k.waiting); i++ {
+		holder := k.locks[name].holder
+		if holder == owner {
+			return true
+		}
+		next, ok := k.waiting[holder]
+		if !ok {
+			return false
+		}
+		name = next
+	}
+	return false
+}
+
+// release releases the lock with the given name. It must be called with k.mux held.
+func (k *KeyLocker) release(name string) {
+	lock := k.locks[name]
+	lock.holder = nil
+	close(lock.released)
+	lock.released = make(chan struct{})
+	k.removeIfUnused(name, lock)
+}
+
+// removeIfUnused removes the given lock if it isn't held or waited for. It must be called with k.mux held.
+func (k *KeyLocker) removeIfUnused(name string, lock *keyLock) {
+	if lock.holder == nil && lock.waiters == 0 {
+		delete(k.locks, name)
+	}
+}
+
+// Locks returns the locks that are currently held, sorted by name.
+func (k *KeyLocker) Locks() []KeyLockState {
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	waiters := map[string][]string{}
+	for owner, name := range k.waiting {
+		waiters[name] = append(waiters[name], owner.Label)
+	}
+	var result []KeyLockState
+	for name, lock := range k.locks {
+		if lock.holder == nil {
+			continue
+		}
+		sort.Strings(waiters[name])
+		result = append(result, KeyLockState{Name: name, Holder: lock.holder.Label, Waiters: waiters[name]})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
@@ -22,6 +22,7 @@ import (
 	"context"
 	"github.com/nuts-foundation/go-stoabs"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"testing"
 	"time"
 )
@@ -94,4 +95,154 @@ func Test_ContextRWLocker(t *testing.T) {
 		assert.ErrorIs(t, err, context.DeadlineExceeded)
 		assert.ErrorIs(t, err, stoabs.ErrDatabase{})
 	})
+	t.Run("holder", func(t *testing.T) {
+		l := &ContextRWLocker{}
+		assert.Empty(t, l.Holder())
+
+		err := l.LockContextHolder(context.Background(), "tx-1")
+		require.NoError(t, err)
+		assert.Equal(t, "tx-1", l.Holder())
+
+		l.Unlock()
+		assert.Empty(t, l.Holder())
+	})
+}
+
+func Test_KeyLocker(t *testing.T) {
+	ctx := context.Background()
+	t.Run("lock, release, then lock again", func(t *testing.T) {
+		l := &KeyLocker{}
+		release, err := l.Lock(ctx, "a", "b")
+		assert.NoError(t, err)
+		release()
+
+		release, err = l.Lock(ctx, "b")
+		assert.NoError(t, err)
+		release()
+		assert.Empty(t, l.locks)
+	})
+	t.Run("disjoint names don't block", func(t *testing.T) {
+		l := &KeyLocker{}
+		release, _ := l.Lock(ctx, "a")
+		defer release()
+
+		other, err := l.Lock(ctx, "b")
+
+		assert.NoError(t, err)
+		other()
+	})
+	t.Run("duplicate names", func(t *testing.T) {
+		l := &KeyLocker{}
+
+		release, err := l.Lock(ctx, "a", "a")
+
+		assert.NoError(t, err)
+		release()
+	})
+	t.Run("overlapping names block until released", func(t *testing.T) {
+		l := &KeyLocker{}
+		release, _ := l.Lock(ctx, "a", "b")
+		acquired := make(chan struct{})
+		go func() {
+			other, _ := l.Lock(ctx, "b", "c")
+			close(acquired)
+			other()
+		}()
+
+		select {
+		case <-acquired:
+			t.Fatal("lock acquired while held")
+		case <-time.After(50 * time.Millisecond):
+		}
+		release()
+		<-acquired
+	})
+	t.Run("context cancelled releases acquired locks", func(t *testing.T) {
+		l := &KeyLocker{}
+		release, _ := l.Lock(ctx, "b")
+		timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+
+		_, err := l.Lock(timeoutCtx, "a", "b")
+
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.ErrorIs(t, err, stoabs.ErrDatabase{})
+		other, err := l.Lock(ctx, "a")
+		assert.NoError(t, err)
+		other()
+		release()
+		assert.Empty(t, l.locks)
+	})
+	t.Run("deadlock between owners", func(t *testing.T) {
+		l := &KeyLocker{}
+		ctx1 := stoabs.ContextWithLockOwner(ctx, "owner-1")
+		ctx2 := stoabs.ContextWithLockOwner(ctx, "owner-2")
+		release1, _ := l.Lock(ctx1, "a")
+		release2, _ := l.Lock(ctx2, "b")
+		waiting := make(chan error, 1)
+		go func() {
+			release, err := l.Lock(ctx1, "b")
+			if err == nil {
+				release()
+			}
+			waiting <- err
+		}()
+		require.Eventually(t, func() bool {
+			locks := l.Locks()
+			return len(locks) == 2 && len(locks[1].Waiters) == 1
+		}, time.Second, time.Millisecond)
+
+		_, err := l.Lock(ctx2, "a")
+
+		assert.ErrorIs(t, err, stoabs.ErrDeadlock)
+		assert.ErrorIs(t, err, stoabs.ErrDatabase{})
+		// the owner that detected the deadlock releases its lock, after which the other owner can proceed
+		release2()
+		assert.NoError(t, <-waiting)
+		release1()
+		assert.Empty(t, l.locks)
+	})
+	t.Run("owner locking a name it holds", func(t *testing.T) {
+		l := &KeyLocker{}
+		ownerCtx := stoabs.ContextWithLockOwner(ctx, "owner")
+		release, _ := l.Lock(ownerCtx, "a", "b")
+
+		_, err := l.Lock(ownerCtx, "c", "b")
+
+		assert.ErrorIs(t, err, stoabs.ErrDeadlock)
+		release()
+		assert.Empty(t, l.locks)
+	})
+	t.Run("locks without owner don't deadlock with themselves", func(t *testing.T) {
+		l := &KeyLocker{}
+		release, _ := l.Lock(ctx, "a")
+		timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+
+		_, err := l.Lock(timeoutCtx, "a")
+
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		release()
+	})
+	t.Run("locks dump", func(t *testing.T) {
+		l := &KeyLocker{}
+		release, _ := l.Lock(stoabs.ContextWithLockOwner(ctx, "holder"), "b", "a")
+		acquired := make(chan struct{})
+		go func() {
+			other, _ := l.Lock(stoabs.ContextWithLockOwner(ctx, "waiter"), "b")
+			other()
+			close(acquired)
+		}()
+		require.Eventually(t, func() bool {
+			return len(l.Locks()) == 2 && len(l.Locks()[1].Waiters) == 1
+		}, time.Second, time.Millisecond)
+
+		assert.Equal(t, []KeyLockState{
+			{Name: "a", Holder: "holder"},
+			{Name: "b", Holder: "holder", Waiters: []string{"waiter"}},
+		}, l.Locks())
+		release()
+		<-acquired
+		assert.Empty(t, l.Locks())
+	})
 }
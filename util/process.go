@@ -0,0 +1,209 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// helperEnv is the environment variable that holds the name of the helper a helper process runs, see StartHelper.
+const helperEnv = "STOABS_TEST_HELPER"
+
+// Helper is a function that runs in a separate process, see StartHelper.
+// It receives the arguments passed to StartHelper, and can communicate with the test using HelperSignal and HelperAwait.
+type Helper func(args []string) error
+
+var helperInput = bufio.NewScanner(os.Stdin)
+
+// RunHelpers runs the helper with the name passed by StartHelper and exits, when the current process is a helper process.
+// Otherwise, it does nothing. It must be called at the start of TestMain of packages that use StartHelper:
+//
+//	func TestMain(m *testing.M) {
+//		util.RunHelpers(map[string]util.Helper{"hold-lock": holdLock})
+//		os.Exit(m.Run())
+//	}
+func RunHelpers(helpers map[string]Helper) {
+	name := os.Getenv(helperEnv)
+	if name == "" {
+		return
+	}
+	helper, ok := helpers[name]
+	if !ok {
+		_, _ = fmt.Fprintf(os.Stderr, "unknown helper: %s\n", name)
+		os.Exit(2)
+	}
+	var args []string
+	for i, arg := range os.Args {
+		if arg == "--" {
+			args = os.Args[i+1:]
+			break
+		}
+	}
+	if err := helper(args); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "helper %s failed: %v\n", name, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// HelperSignal sends a message from a helper process to the test, see HelperProcess.Expect.
+func HelperSignal(message string) {
+	_, _ = fmt.Fprintln(os.Stdout, message)
+}
+
+// HelperAwait blocks a helper process until the test sends the given message (see HelperProcess.Send).
+// It returns an error if the test closed the input of the helper process before sending it.
+func HelperAwait(message string) error {
+	for helperInput.Scan() {
+		if helperInput.Text() == message {
+			return nil
+		}
+	}
+	return fmt.Errorf("input closed while waiting for %q", message)
+}
+
+// HelperProcess is a helper running in a separate process, started by StartHelper.
+type HelperProcess struct {
+	name     string
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	messages chan string
+	stderr   *lockedBuffer
+	done     chan struct{}
+	err      error
+}
+
+// StartHelper starts the test binary in a new process that runs the helper with the given name (see RunHelpers),
+// e.g. to test behavior that involves multiple processes such as file locks. The process is killed when the test ends.
+func StartHelper(t *testing.T, name string, args ...string) *HelperProcess {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], append([]string{"-test.run=^$", "--"}, args...)...)
+	cmd.Env = append(os.Environ(), helperEnv+"="+name)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an io.Pipe instead of cmd.StdoutPipe(), since cmd.Wait() closes the latter before all messages are read
+	stdout, stdoutWriter := io.Pipe()
+	cmd.Stdout = stdoutWriter
+	result := &HelperProcess{
+		name:     name,
+		cmd:      cmd,
+		stdin:    stdin,
+		messages: make(chan string, 100),
+		stderr:   &lockedBuffer{},
+		done:     make(chan struct{}),
+	}
+	cmd.Stderr = result.stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			result.messages <- scanner.Text()
+		}
+		close(result.messages)
+	}()
+	go func() {
+		result.err = cmd.Wait()
+		_ = stdoutWriter.Close()
+		close(result.done)
+	}()
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		<-result.done
+		if t.Failed() && result.stderr.Len() > 0 {
+			t.Logf("output of helper %s:\n%s", name, result.stderr.String())
+		}
+	})
+	return result
+}
+
+// Expect waits until the helper sends the given message (see HelperSignal), skipping other messages.
+// It returns an error if the helper exits or doesn't send the message within the timeout.
+func (p *HelperProcess) Expect(message string, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case received, ok := <-p.messages:
+			if !ok {
+				return fmt.Errorf("helper %s exited while waiting for %q: %s", p.name, message, strings.TrimSpace(p.stderr.String()))
+			}
+			if received == message {
+				return nil
+			}
+		case <-timer.C:
+			return fmt.Errorf("time-out while waiting for %q from helper %s", message, p.name)
+		}
+	}
+}
+
+// Send sends a message to the helper, see HelperAwait.
+func (p *HelperProcess) Send(message string) error {
+	_, err := fmt.Fprintln(p.stdin, message)
+	return err
+}
+
+// Wait waits until the helper exits, and returns an error if it failed or didn't exit within the timeout.
+func (p *HelperProcess) Wait(timeout time.Duration) error {
+	select {
+	case <-p.done:
+		if p.err != nil {
+			return fmt.Errorf("helper %s failed: %w: %s", p.name, p.err, strings.TrimSpace(p.stderr.String()))
+		}
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("time-out while waiting for helper %s to exit", p.name)
+	}
+}
+
+// lockedBuffer is a bytes.Buffer that can be written and read concurrently.
+type lockedBuffer struct {
+	mux sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) Len() int {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.buf.Len()
+}
+
+func (b *lockedBuffer) String() string {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package util
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	RunHelpers(map[string]Helper{
+		"echo": func(args []string) error {
+			HelperSignal(strings.Join(args, " "))
+			return HelperAwait("stop")
+		},
+		"fail": func(_ []string) error {
+			return errors.New("failure")
+		},
+	})
+	os.Exit(m.Run())
+}
+
+func TestStartHelper(t *testing.T) {
+	t.Run("signal and await", func(t *testing.T) {
+		helper := StartHelper(t, "echo", "hello", "world")
+
+		require.NoError(t, helper.Expect("hello world", 5*time.Second))
+		require.NoError(t, helper.Send("stop"))
+
+		assert.NoError(t, helper.Wait(5*time.Second))
+	})
+	t.Run("time-out", func(t *testing.T) {
+		helper := StartHelper(t, "echo", "hello")
+
+		err := helper.Expect("bye", 10*time.Millisecond)
+
+		assert.EqualError(t, err, `time-out while waiting for "bye" from helper echo`)
+	})
+	t.Run("failing helper", func(t *testing.T) {
+		helper := StartHelper(t, "fail")
+
+		err := helper.Expect("ready", 5*time.Second)
+
+		assert.ErrorContains(t, err, "helper fail exited while waiting for \"ready\": helper fail failed: failure")
+		assert.ErrorContains(t, helper.Wait(5*time.Second), "exit status 1")
+	})
+}
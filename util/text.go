@@ -0,0 +1,47 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package util
+
+import (
+	"encoding/hex"
+	"unicode"
+	"unicode/utf8"
+)
+
+// IsPrintable returns whether the data is valid UTF-8 and only contains printable characters (see unicode.IsPrint).
+// If multiline is true, whitespace such as newlines and tabs is allowed as well.
+func IsPrintable(data []byte, multiline bool) bool {
+	if !utf8.Valid(data) {
+		return false
+	}
+	for _, r := range string(data) {
+		if !unicode.IsPrint(r) && !(multiline && unicode.IsSpace(r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// FormatKey returns the key as text if it's printable, otherwise hex encoded and prefixed with 0x.
+func FormatKey(key []byte) string {
+	if IsPrintable(key, false) {
+		return string(key)
+	}
+	return "0x" + hex.EncodeToString(key)
+}
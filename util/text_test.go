@@ -0,0 +1,39 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsPrintable(t *testing.T) {
+	assert.True(t, IsPrintable([]byte("hello world"), false))
+	assert.True(t, IsPrintable([]byte("hello\nworld"), true))
+	assert.False(t, IsPrintable([]byte("hello\nworld"), false))
+	assert.False(t, IsPrintable([]byte{0x00, 0x01}, true))
+	assert.False(t, IsPrintable([]byte{0xff}, true))
+}
+
+func TestFormatKey(t *testing.T) {
+	assert.Equal(t, "key", FormatKey([]byte("key")))
+	assert.Equal(t, "0x0001", FormatKey([]byte{0x00, 0x01}))
+	assert.Equal(t, "0x6b65790a", FormatKey([]byte("key\n")))
+}
@@ -0,0 +1,73 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import "fmt"
+
+// Validator validates an entry before it's written, returning an error if the value is malformed.
+type Validator func(key Key, value []byte) error
+
+// ErrInvalidValue is returned when a value is rejected by a Validator (see WithValidator).
+type ErrInvalidValue struct {
+	Shelf string
+	Key   Key
+	Err   error
+}
+
+func (e ErrInvalidValue) Error() string {
+	return fmt.Sprintf("invalid value for key %s of shelf %s: %v", e.Key, e.Shelf, e.Err)
+}
+
+// Is returns true for any ErrInvalidValue, so errors.Is(err, ErrInvalidValue{}) matches regardless of shelf and key.
+func (e ErrInvalidValue) Is(other error) bool {
+	_, ok := other.(ErrInvalidValue)
+	return ok
+}
+
+func (e ErrInvalidValue) Unwrap() error {
+	return e.Err
+}
+
+// WithValidator registers a validator that is run on every Put on the given shelf, rejecting the value with ErrInvalidValue
+// (failing the transaction, unless the caller handles the error) if it returns an error. Multiple validators can be
+// registered per shelf. Support depends on the underlying database: BBolt, Badger and Redis run validators.
+func WithValidator(shelfName string, validator Validator) Option {
+	return func(config *Config) {
+		if config.Validators == nil {
+			config.Validators = map[string][]Validator{}
+		}
+		config.Validators[shelfName] = append(config.Validators[shelfName], validator)
+	}
+}
+
+// Validator returns a function running the validators of the given shelf, or nil if it has none.
+func (c Config) Validator(shelfName string) func(key Key, value []byte) error {
+	validators := c.Validators[shelfName]
+	if len(validators) == 0 {
+		return nil
+	}
+	return func(key Key, value []byte) error {
+		for _, validator := range validators {
+			if err := validator(key, value); err != nil {
+				return ErrInvalidValue{Shelf: shelfName, Key: key, Err: err}
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,49 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestWithValidator(t *testing.T) {
+	failing := func(key Key, value []byte) error {
+		return errors.New("failed")
+	}
+	passing := func(key Key, value []byte) error {
+		return nil
+	}
+
+	t.Run("no validators", func(t *testing.T) {
+		assert.Nil(t, DefaultConfig().Validator("shelf"))
+	})
+	t.Run("all validators run", func(t *testing.T) {
+		cfg := DefaultConfig()
+		WithValidator("shelf", passing)(&cfg)
+		WithValidator("shelf", failing)(&cfg)
+
+		err := cfg.Validator("shelf")(BytesKey("key"), []byte("value"))
+
+		assert.ErrorIs(t, err, ErrInvalidValue{})
+		assert.EqualError(t, err, "invalid value for key 6b6579 of shelf shelf: failed")
+		assert.Nil(t, cfg.Validator("other"))
+	})
+}
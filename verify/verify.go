@@ -0,0 +1,337 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package verify compares the contents of two KVStores, e.g. a primary and its replica, or a store before and after a migration.
+package verify
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/nuts-foundation/go-stoabs"
+)
+
+const defaultChunkSize = 1000
+
+const defaultMaxDifferences = 1000
+
+// Kind describes how an entry differs.
+type Kind int
+
+const (
+	// Missing means the entry exists in the first store, but not in the second.
+	Missing Kind = iota
+	// Extra means the entry exists in the second store, but not in the first.
+	Extra
+	// Changed means the entry exists in both stores, but with a different value.
+	Changed
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Missing:
+		return "missing"
+	case Extra:
+		return "extra"
+	default:
+		return "changed"
+	}
+}
+
+// Difference is an entry that differs between the stores.
+type Difference struct {
+	Shelf string
+	Key   stoabs.Key
+	Kind  Kind
+}
+
+// Report describes the differences between two stores.
+type Report struct {
+	// Compared is the number of entries compared.
+	Compared int
+	// Missing, Extra and Changed are the number of differences of each Kind.
+	Missing int
+	Extra   int
+	Changed int
+	// Differences holds the differences, up to the maximum (see WithMaxDifferences).
+	Differences []Difference
+	// Repaired is the number of differences that were repaired, see WithRepair.
+	Repaired int
+}
+
+// Equal returns true if no differences were found.
+func (r Report) Equal() bool {
+	return r.Missing+r.Extra+r.Changed == 0
+}
+
+func (r *Report) add(shelf string, key stoabs.Key, kind Kind, maxDifferences int) {
+	switch kind {
+	case Missing:
+		r.Missing++
+	case Extra:
+		r.Extra++
+	default:
+		r.Changed++
+	}
+	if len(r.Differences) < maxDifferences {
+		r.Differences = append(r.Differences, Difference{Shelf: shelf, Key: key, Kind: kind})
+	}
+}
+
+// Option configures Diff.
+type Option func(cfg *config)
+
+type config struct {
+	shelves        []string
+	chunkSize      int
+	maxDifferences int
+	repair         bool
+	keyTypes       map[string]stoabs.Key
+}
+
+// WithShelves limits the comparison to the given shelves. By default, all shelves of both stores are compared,
+// except for the reserved shelves used internally by stoabs (unless the context includes them, see stoabs.ContextWithReservedShelves),
+// which requires both stores to implement stoabs.ShelfLister.
+func WithShelves(shelves ...string) Option {
+	return func(cfg *config) {
+		cfg.shelves = shelves
+	}
+}
+
+// WithKeyType specifies the type of the keys of the given shelf, see migrate.WithKeyType.
+// Shelves without key type are compared with stoabs.BytesKey.
+func WithKeyType(shelf string, keyType stoabs.Key) Option {
+	return func(cfg *config) {
+		cfg.keyTypes[shelf] = keyType
+	}
+}
+
+// WithChunkSize sets the number of entries of the first store that are looked up in the second store in a single transaction.
+func WithChunkSize(chunkSize int) Option {
+	return func(cfg *config) {
+		cfg.chunkSize = chunkSize
+	}
+}
+
+// WithMaxDifferences sets the maximum number of differences recorded in Report.Differences, which limits memory use.
+// Differences are counted regardless.
+func WithMaxDifferences(maxDifferences int) Option {
+	return func(cfg *config) {
+		cfg.maxDifferences = maxDifferences
+	}
+}
+
+// WithRepair makes the second store equal to the first: missing and changed entries are written, extra entries are deleted.
+func WithRepair() Option {
+	return func(cfg *config) {
+		cfg.repair = true
+	}
+}
+
+// Diff compares the entries of the shelves of a and b. Entries of a are read in a single read transaction per shelf,
+// and looked up in b in chunks (see WithChunkSize). Then the keys of b are looked up in a, to find extra entries.
+// Memory use is limited to a chunk, the recorded differences (see WithMaxDifferences) and, when repairing, the keys of extra entries.
+func Diff(ctx context.Context, a, b stoabs.KVStore, opts ...Option) (Report, error) {
+	cfg := config{chunkSize: defaultChunkSize, maxDifferences: defaultMaxDifferences, keyTypes: map[string]stoabs.Key{}}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.chunkSize <= 0 {
+		return Report{}, errors.New("chunk size must be greater than 0")
+	}
+	shelves := cfg.shelves
+	if len(shelves) == 0 {
+		var err error
+		if shelves, err = listShelves(ctx, a, b); err != nil {
+			return Report{}, err
+		}
+	}
+	var report Report
+	for _, shelf := range shelves {
+		if err := diffShelf(ctx, a, b, shelf, cfg, &report); err != nil {
+			return report, fmt.Errorf("unable to compare shelf %s: %w", shelf, err)
+		}
+	}
+	return report, nil
+}
+
+// listShelves returns the shelves of both stores, sorted.
+func listShelves(ctx context.Context, a, b stoabs.KVStore) ([]string, error) {
+	names := map[string]struct{}{}
+	for _, store := range []stoabs.KVStore{a, b} {
+		shelves, err := stoabs.ShelfNames(ctx, store)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list shelves: %w", err)
+		}
+		for _, shelf := range shelves {
+			names[shelf] = struct{}{}
+		}
+	}
+	result := make([]string, 0, len(names))
+	for name := range names {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+type entry struct {
+	key   stoabs.Key
+	value []byte
+}
+
+func diffShelf(ctx context.Context, a, b stoabs.KVStore, shelf string, cfg config, report *Report) error {
+	keyType, ok := cfg.keyTypes[shelf]
+	if !ok {
+		keyType = stoabs.BytesKey{}
+	}
+	// look up the entries of a in b
+	chunk := make([]entry, 0, cfg.chunkSize)
+	flush := func() error {
+		var repairs []entry
+		err := readShelf(ctx, b, shelf, func(reader stoabs.Reader) error {
+			for _, e := range chunk {
+				value, err := reader.Get(e.key)
+				if errors.Is(err, stoabs.ErrKeyNotFound) {
+					report.add(shelf, e.key, Missing, cfg.maxDifferences)
+					repairs = append(repairs, e)
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				if !bytes.Equal(value, e.value) {
+					report.add(shelf, e.key, Changed, cfg.maxDifferences)
+					repairs = append(repairs, e)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(repairs) > 0 && cfg.repair {
+			err := b.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+				for _, e := range repairs {
+					if err := writer.Put(e.key, e.value); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("unable to repair: %w", err)
+			}
+			report.Repaired += len(repairs)
+		}
+		report.Compared += len(chunk)
+		chunk = chunk[:0]
+		return nil
+	}
+	err := a.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+		return reader.Iterate(func(key stoabs.Key, value []byte) error {
+			// copy the value, since some backends only guarantee its validity during the callback
+			chunk = append(chunk, entry{key: key, value: append(value[:0:0], value...)})
+			if len(chunk) == cfg.chunkSize {
+				return flush()
+			}
+			return nil
+		}, keyType)
+	})
+	if err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	return findExtra(ctx, a, b, shelf, keyType, cfg, report)
+}
+
+// findExtra looks up the keys of b in a, and deletes the ones that don't exist in a when repairing.
+func findExtra(ctx context.Context, a, b stoabs.KVStore, shelf string, keyType stoabs.Key, cfg config, report *Report) error {
+	var extra []stoabs.Key
+	chunk := make([]stoabs.Key, 0, cfg.chunkSize)
+	flush := func() error {
+		err := readShelf(ctx, a, shelf, func(reader stoabs.Reader) error {
+			for _, key := range chunk {
+				_, err := reader.Get(key)
+				if errors.Is(err, stoabs.ErrKeyNotFound) {
+					report.add(shelf, key, Extra, cfg.maxDifferences)
+					if cfg.repair {
+						extra = append(extra, key)
+					}
+					continue
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		chunk = chunk[:0]
+		return err
+	}
+	err := b.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+		return reader.Iterate(func(key stoabs.Key, _ []byte) error {
+			chunk = append(chunk, key)
+			if len(chunk) == cfg.chunkSize {
+				return flush()
+			}
+			return nil
+		}, keyType)
+	})
+	if err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	if len(extra) == 0 {
+		return nil
+	}
+	// deleted after iterating, since b can't be written while it's being read
+	err = b.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+		for _, key := range extra {
+			if err := writer.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to repair: %w", err)
+	}
+	report.Repaired += len(extra)
+	return nil
+}
+
+// readShelf calls fn with a reader of the shelf, or with a stoabs.NilReader if the shelf doesn't exist.
+func readShelf(ctx context.Context, store stoabs.KVStore, shelf string, fn func(reader stoabs.Reader) error) error {
+	called := false
+	err := store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+		called = true
+		return fn(reader)
+	})
+	if err != nil || called {
+		return err
+	}
+	return fn(stoabs.NilReader{})
+}
@@ -0,0 +1,150 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package verify
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/nuts-foundation/go-stoabs/redis7"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+func TestDiff(t *testing.T) {
+	setup := func(t *testing.T) (stoabs.KVStore, stoabs.KVStore) {
+		a, b := stores.BBolt(t), stores.BBolt(t)
+		for _, store := range []stoabs.KVStore{a, b} {
+			put(t, store, "fruit", stoabs.BytesKey("apple"), "green")
+			put(t, store, "fruit", stoabs.BytesKey("banana"), "yellow")
+			put(t, store, "_stoabs/internal", stoabs.BytesKey("state"), "1")
+		}
+		return a, b
+	}
+
+	t.Run("equal", func(t *testing.T) {
+		a, b := setup(t)
+		put(t, b, "_stoabs/internal", stoabs.BytesKey("state"), "2")
+
+		report, err := Diff(ctx, a, b)
+
+		require.NoError(t, err)
+		assert.True(t, report.Equal())
+		assert.Equal(t, 2, report.Compared)
+	})
+	t.Run("differences", func(t *testing.T) {
+		a, b := setup(t)
+		put(t, a, "fruit", stoabs.BytesKey("cherry"), "red")
+		put(t, b, "fruit", stoabs.BytesKey("banana"), "brown")
+		put(t, b, "fruit", stoabs.BytesKey("durian"), "smelly")
+		put(t, a, "vegetables", stoabs.BytesKey("carrot"), "orange")
+
+		report, err := Diff(ctx, a, b, WithChunkSize(1))
+
+		require.NoError(t, err)
+		assert.False(t, report.Equal())
+		assert.Equal(t, 4, report.Compared)
+		assert.Equal(t, 2, report.Missing)
+		assert.Equal(t, 1, report.Changed)
+		assert.Equal(t, 1, report.Extra)
+		assert.ElementsMatch(t, []Difference{
+			{Shelf: "fruit", Key: stoabs.BytesKey("cherry"), Kind: Missing},
+			{Shelf: "fruit", Key: stoabs.BytesKey("banana"), Kind: Changed},
+			{Shelf: "fruit", Key: stoabs.BytesKey("durian"), Kind: Extra},
+			{Shelf: "vegetables", Key: stoabs.BytesKey("carrot"), Kind: Missing},
+		}, report.Differences)
+		assert.Equal(t, 0, report.Repaired)
+	})
+	t.Run("max differences", func(t *testing.T) {
+		a, b := setup(t)
+		put(t, a, "fruit", stoabs.BytesKey("cherry"), "red")
+		put(t, b, "fruit", stoabs.BytesKey("durian"), "smelly")
+
+		report, err := Diff(ctx, a, b, WithMaxDifferences(1))
+
+		require.NoError(t, err)
+		assert.Len(t, report.Differences, 1)
+		assert.Equal(t, 1, report.Missing)
+		assert.Equal(t, 1, report.Extra)
+	})
+	t.Run("repair", func(t *testing.T) {
+		a, b := setup(t)
+		put(t, a, "fruit", stoabs.BytesKey("cherry"), "red")
+		put(t, b, "fruit", stoabs.BytesKey("banana"), "brown")
+		put(t, b, "fruit", stoabs.BytesKey("durian"), "smelly")
+		put(t, a, "vegetables", stoabs.BytesKey("carrot"), "orange")
+
+		report, err := Diff(ctx, a, b, WithRepair(), WithChunkSize(2))
+
+		require.NoError(t, err)
+		assert.Equal(t, 4, report.Repaired)
+		report, err = Diff(ctx, a, b)
+		require.NoError(t, err)
+		assert.True(t, report.Equal())
+	})
+	t.Run("with shelves", func(t *testing.T) {
+		a, b := setup(t)
+		put(t, b, "_stoabs/internal", stoabs.BytesKey("state"), "2")
+		put(t, a, "vegetables", stoabs.BytesKey("carrot"), "orange")
+
+		report, err := Diff(ctx, a, b, WithShelves("_stoabs/internal"))
+
+		require.NoError(t, err)
+		assert.Equal(t, []Difference{{Shelf: "_stoabs/internal", Key: stoabs.BytesKey("state"), Kind: Changed}}, report.Differences)
+	})
+	t.Run("with Redis and key types", func(t *testing.T) {
+		a := stores.BBolt(t)
+		mr := miniredis.RunT(t)
+		b, err := redis7.CreateRedisStore("db", &redis.Options{Addr: mr.Addr()})
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = b.Close(context.Background())
+		})
+		put(t, a, "numbers", stoabs.Uint32Key(1), "one")
+		put(t, a, "numbers", stoabs.Uint32Key(2), "two")
+		put(t, b, "numbers", stoabs.Uint32Key(2), "two")
+
+		report, err := Diff(ctx, a, b, WithKeyType("numbers", stoabs.Uint32Key(0)), WithRepair())
+
+		require.NoError(t, err)
+		assert.Equal(t, []Difference{{Shelf: "numbers", Key: stoabs.Uint32Key(1), Kind: Missing}}, report.Differences)
+		report, err = Diff(ctx, a, b, WithKeyType("numbers", stoabs.Uint32Key(0)))
+		require.NoError(t, err)
+		assert.True(t, report.Equal())
+	})
+	t.Run("invalid chunk size", func(t *testing.T) {
+		a, b := setup(t)
+
+		_, err := Diff(ctx, a, b, WithChunkSize(0))
+
+		assert.EqualError(t, err, "chunk size must be greater than 0")
+	})
+}
+
+func put(t *testing.T, store stoabs.KVStore, shelf string, key stoabs.Key, value string) {
+	require.NoError(t, store.WriteShelf(ctx, shelf, func(writer stoabs.Writer) error {
+		return writer.Put(key, []byte(value))
+	}))
+}
@@ -0,0 +1,303 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package versioned provides a KVStore that keeps previous versions of values.
+package versioned
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nuts-foundation/go-stoabs"
+)
+
+var _ stoabs.KVStore = (*Store)(nil)
+var _ stoabs.ShelfLister = (*Store)(nil)
+var _ stoabs.WriteTx = (*tx)(nil)
+var _ stoabs.Writer = (*shelf)(nil)
+var _ Reader = (*shelf)(nil)
+
+// historyShelfPrefix is the prefix of the shelves holding the versions of the values in a shelf.
+const historyShelfPrefix = stoabs.ReservedShelfPrefix + "versions/"
+
+// Keys in a history shelf start with one of the following bytes:
+//
+//	metaPrefix | key: the first and last retained version number of the key (8 bytes each, big endian)
+//	versionPrefix | uvarint key length | key | version number (8 bytes, big endian): a version of the key
+//
+// Version entries consist of the timestamp (Unix nanoseconds, 8 bytes, big endian) | flags (1 byte) | value.
+const (
+	metaPrefix    byte = 'm'
+	versionPrefix byte = 'v'
+)
+
+const flagDeleted byte = 1
+
+// Version is a version of a value.
+type Version struct {
+	// Number is the version number, starting at 1 and incremented on every write of the key.
+	Number uint64
+	// Timestamp is the time at which the version was written.
+	Timestamp time.Time
+	// Deleted indicates the key was deleted in this version.
+	Deleted bool
+	// Value is the value of this version. It's nil for deleted versions.
+	Value []byte
+}
+
+// Reader is implemented by the shelf readers (and writers) of the versioned store.
+type Reader interface {
+	stoabs.Reader
+	// GetVersion returns the given version of the key.
+	// It returns stoabs.ErrKeyNotFound if the version does not exist, or is no longer retained.
+	GetVersion(key stoabs.Key, version uint64) (Version, error)
+	// History returns all retained versions of the key, oldest first.
+	History(key stoabs.Key) ([]Version, error)
+}
+
+// Option configures the versioned store.
+type Option func(s *Store)
+
+// WithRetention limits the number of versions kept per key. When a new version is written, the oldest exceeding
+// versions are removed. By default, all versions are kept.
+func WithRetention(versions uint64) Option {
+	return func(s *Store) {
+		s.retention = versions
+	}
+}
+
+// Wrap creates a store that records every Put and Delete as a new version of the key. The current values are stored in
+// the underlying store as-is, so reading them works as before. Versions are kept in a separate shelf per shelf
+// (in the same transaction), and can be read using the Reader interface implemented by the shelves of this store:
+//
+//	history, err := reader.(versioned.Reader).History(key)
+func Wrap(store stoabs.KVStore, opts ...Option) *Store {
+	result := &Store{
+		underlying: store,
+		now:        time.Now,
+	}
+	for _, opt := range opts {
+		opt(result)
+	}
+	return result
+}
+
+// Store is a KVStore that keeps previous versions of values. Use Wrap to create it.
+type Store struct {
+	underlying stoabs.KVStore
+	retention  uint64
+	now        func() time.Time
+}
+
+func (s *Store) Close(ctx context.Context) error {
+	return s.underlying.Close(ctx)
+}
+
+func (s *Store) Write(ctx context.Context, fn func(stoabs.WriteTx) error, opts ...stoabs.TxOption) error {
+	return s.underlying.Write(ctx, func(underlyingTx stoabs.WriteTx) error {
+		return fn(&tx{ReadTx: underlyingTx, writeTx: underlyingTx, store: s, meta: map[metaCacheKey]meta{}})
+	}, opts...)
+}
+
+func (s *Store) WriteShelf(ctx context.Context, shelfName string, fn func(stoabs.Writer) error) error {
+	return s.Write(ctx, func(tx stoabs.WriteTx) error {
+		return fn(tx.GetShelfWriter(shelfName))
+	})
+}
+
+func (s *Store) Read(ctx context.Context, fn func(stoabs.ReadTx) error) error {
+	return s.underlying.Read(ctx, func(underlyingTx stoabs.ReadTx) error {
+		return fn(&tx{ReadTx: underlyingTx, store: s})
+	})
+}
+
+func (s *Store) ReadShelf(ctx context.Context, shelfName string, fn func(stoabs.Reader) error) error {
+	return s.Read(ctx, func(tx stoabs.ReadTx) error {
+		return fn(tx.GetShelfReader(shelfName))
+	})
+}
+
+// ShelfNames returns the shelves of the underlying store. The shelves holding the versions are reserved,
+// so they're only listed when requested using stoabs.ContextWithReservedShelves.
+func (s *Store) ShelfNames(ctx context.Context) ([]string, error) {
+	return stoabs.ShelfNames(ctx, s.underlying)
+}
+
+// meta holds the first and last retained version of a key. A zero last version means the key has no versions.
+type meta struct {
+	first uint64
+	last  uint64
+}
+
+type tx struct {
+	stoabs.ReadTx
+	writeTx stoabs.WriteTx
+	store   *Store
+	// meta caches the version metadata of keys written in this transaction, since not all backends can read
+	// values written in the same transaction.
+	meta map[metaCacheKey]meta
+}
+
+func (t *tx) GetShelfReader(shelfName string) stoabs.Reader {
+	return &shelf{
+		Reader:  t.ReadTx.GetShelfReader(shelfName),
+		history: t.ReadTx.GetShelfReader(historyShelfPrefix + shelfName),
+		name:    shelfName,
+		tx:      t,
+	}
+}
+
+func (t *tx) GetShelfWriter(shelfName string) stoabs.Writer {
+	writer := t.writeTx.GetShelfWriter(shelfName)
+	history := t.writeTx.GetShelfWriter(historyShelfPrefix + shelfName)
+	return &shelf{Reader: writer, writer: writer, history: history, historyWriter: history, name: shelfName, tx: t}
+}
+
+func (t *tx) Store() stoabs.KVStore {
+	return t.store
+}
+
+type shelf struct {
+	stoabs.Reader
+	writer        stoabs.Writer
+	history       stoabs.Reader
+	historyWriter stoabs.Writer
+	name          string
+	tx            *tx
+}
+
+func (s *shelf) Put(key stoabs.Key, value []byte) error {
+	if err := s.writer.Put(key, value); err != nil {
+		return err
+	}
+	return s.addVersion(key, 0, value)
+}
+
+func (s *shelf) Delete(key stoabs.Key) error {
+	if err := s.writer.Delete(key); err != nil {
+		return err
+	}
+	return s.addVersion(key, flagDeleted, nil)
+}
+
+func (s *shelf) GetVersion(key stoabs.Key, version uint64) (Version, error) {
+	data, err := s.history.Get(versionKey(key, version))
+	if err != nil {
+		return Version{}, err
+	}
+	return parseVersion(version, data)
+}
+
+func (s *shelf) History(key stoabs.Key) ([]Version, error) {
+	m, err := s.readMeta(key)
+	if err != nil || m.last == 0 {
+		return nil, err
+	}
+	var result []Version
+	for number := m.first; number <= m.last; number++ {
+		version, err := s.GetVersion(key, number)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read version %d of key %s: %w", number, key, err)
+		}
+		result = append(result, version)
+	}
+	return result, nil
+}
+
+func (s *shelf) addVersion(key stoabs.Key, flags byte, value []byte) error {
+	m, err := s.readMeta(key)
+	if err != nil {
+		return err
+	}
+	m.last++
+	if m.first == 0 {
+		m.first = 1
+	}
+	data := binary.BigEndian.AppendUint64(nil, uint64(s.tx.store.now().UnixNano()))
+	data = append(data, flags)
+	if err := s.historyWriter.Put(versionKey(key, m.last), append(data, value...)); err != nil {
+		return err
+	}
+	retention := s.tx.store.retention
+	for ; retention > 0 && m.last-m.first+1 > retention; m.first++ {
+		if err := s.historyWriter.Delete(versionKey(key, m.first)); err != nil {
+			return err
+		}
+	}
+	metaData := binary.BigEndian.AppendUint64(binary.BigEndian.AppendUint64(nil, m.first), m.last)
+	if err := s.historyWriter.Put(metaKey(key), metaData); err != nil {
+		return err
+	}
+	s.tx.meta[s.cacheKey(key)] = m
+	return nil
+}
+
+func (s *shelf) readMeta(key stoabs.Key) (meta, error) {
+	if m, ok := s.tx.meta[s.cacheKey(key)]; ok {
+		return m, nil
+	}
+	data, err := s.history.Get(metaKey(key))
+	if errors.Is(err, stoabs.ErrKeyNotFound) {
+		return meta{}, nil
+	}
+	if err != nil {
+		return meta{}, err
+	}
+	if len(data) != 16 {
+		return meta{}, fmt.Errorf("invalid version metadata for key %s", key)
+	}
+	return meta{first: binary.BigEndian.Uint64(data), last: binary.BigEndian.Uint64(data[8:])}, nil
+}
+
+// metaCacheKey identifies a key in the metadata cache of a transaction.
+type metaCacheKey struct {
+	shelf string
+	key   string
+}
+
+func (s *shelf) cacheKey(key stoabs.Key) metaCacheKey {
+	return metaCacheKey{shelf: s.name, key: string(key.Bytes())}
+}
+
+func metaKey(key stoabs.Key) stoabs.Key {
+	return stoabs.BytesKey(append([]byte{metaPrefix}, key.Bytes()...))
+}
+
+func versionKey(key stoabs.Key, version uint64) stoabs.Key {
+	result := binary.AppendUvarint([]byte{versionPrefix}, uint64(len(key.Bytes())))
+	result = append(result, key.Bytes()...)
+	return stoabs.BytesKey(binary.BigEndian.AppendUint64(result, version))
+}
+
+func parseVersion(number uint64, data []byte) (Version, error) {
+	if len(data) < 9 {
+		return Version{}, fmt.Errorf("invalid version data (version=%d)", number)
+	}
+	result := Version{
+		Number:    number,
+		Timestamp: time.Unix(0, int64(binary.BigEndian.Uint64(data))),
+		Deleted:   data[8]&flagDeleted != 0,
+	}
+	if !result.Deleted {
+		result.Value = data[9:]
+	}
+	return result, nil
+}
@@ -0,0 +1,187 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package versioned
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/nuts-foundation/go-stoabs"
+	"github.com/nuts-foundation/go-stoabs/kvtests"
+	"github.com/nuts-foundation/go-stoabs/kvtests/stores"
+	"github.com/nuts-foundation/go-stoabs/redis7"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+var key = stoabs.BytesKey("key")
+
+const shelfName = "test"
+
+func TestVersioned(t *testing.T) {
+	provider := func(t *testing.T) (stoabs.KVStore, error) {
+		return Wrap(stores.BBolt(t)), nil
+	}
+
+	kvtests.TestReadingAndWriting(t, provider)
+	kvtests.TestRange(t, provider)
+	kvtests.TestIterate(t, provider)
+	kvtests.TestEmpty(t, provider)
+	kvtests.TestDelete(t, provider)
+	kvtests.TestWriteTransactions(t, provider)
+	kvtests.TestShelfNames(t, provider)
+}
+
+func TestShelf_History(t *testing.T) {
+	t.Run("records puts and deletes", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t))
+		now := time.Unix(1000, 0)
+		store.now = func() time.Time {
+			now = now.Add(time.Second)
+			return now
+		}
+		write(t, store, func(writer stoabs.Writer) error {
+			return writer.Put(key, []byte("v1"))
+		})
+		write(t, store, func(writer stoabs.Writer) error {
+			return writer.Put(key, []byte("v2"))
+		})
+		write(t, store, func(writer stoabs.Writer) error {
+			return writer.Delete(key)
+		})
+
+		history := readHistory(t, store, key)
+
+		assert.Equal(t, []Version{
+			{Number: 1, Timestamp: time.Unix(1001, 0), Value: []byte("v1")},
+			{Number: 2, Timestamp: time.Unix(1002, 0), Value: []byte("v2")},
+			{Number: 3, Timestamp: time.Unix(1003, 0), Deleted: true},
+		}, history)
+	})
+	t.Run("unknown key", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t))
+
+		assert.Empty(t, readHistory(t, store, key))
+	})
+	t.Run("retention", func(t *testing.T) {
+		store := Wrap(stores.BBolt(t), WithRetention(2))
+		for _, value := range []string{"v1", "v2", "v3"} {
+			write(t, store, func(writer stoabs.Writer) error {
+				return writer.Put(key, []byte(value))
+			})
+		}
+
+		history := readHistory(t, store, key)
+
+		require.Len(t, history, 2)
+		assert.Equal(t, uint64(2), history[0].Number)
+		assert.Equal(t, uint64(3), history[1].Number)
+		err := store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+			_, err := reader.(Reader).GetVersion(key, 1)
+			return err
+		})
+		assert.ErrorIs(t, err, stoabs.ErrKeyNotFound)
+	})
+	t.Run("multiple writes in one transaction (Redis)", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		underlying, err := redis7.CreateRedisStore("db", &redis.Options{Addr: mr.Addr()})
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = underlying.Close(ctx)
+		})
+		store := Wrap(underlying)
+		write(t, store, func(writer stoabs.Writer) error {
+			_ = writer.Put(key, []byte("v1"))
+			return writer.Put(key, []byte("v2"))
+		})
+
+		history := readHistory(t, store, key)
+
+		require.Len(t, history, 2)
+		assert.Equal(t, []byte("v1"), history[0].Value)
+		assert.Equal(t, []byte("v2"), history[1].Value)
+	})
+	t.Run("shelves with similar names in one transaction (Redis)", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		underlying, err := redis7.CreateRedisStore("db", &redis.Options{Addr: mr.Addr()})
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = underlying.Close(ctx)
+		})
+		store := Wrap(underlying)
+		err = store.Write(ctx, func(tx stoabs.WriteTx) error {
+			_ = tx.GetShelfWriter("a/b").Put(stoabs.BytesKey("c"), []byte("v1"))
+			return tx.GetShelfWriter("a").Put(stoabs.BytesKey("b/c"), []byte("v1"))
+		})
+		require.NoError(t, err)
+
+		for shelf, key := range map[string]stoabs.Key{"a/b": stoabs.BytesKey("c"), "a": stoabs.BytesKey("b/c")} {
+			err := store.ReadShelf(ctx, shelf, func(reader stoabs.Reader) error {
+				history, err := reader.(Reader).History(key)
+				require.Len(t, history, 1)
+				assert.Equal(t, uint64(1), history[0].Number)
+				return err
+			})
+			require.NoError(t, err)
+		}
+	})
+}
+
+func TestShelf_GetVersion(t *testing.T) {
+	store := Wrap(stores.BBolt(t))
+	write(t, store, func(writer stoabs.Writer) error {
+		return writer.Put(key, []byte("v1"))
+	})
+	write(t, store, func(writer stoabs.Writer) error {
+		return writer.Put(key, []byte("v2"))
+	})
+
+	err := store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+		version, err := reader.(Reader).GetVersion(key, 1)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("v1"), version.Value)
+		current, err := reader.Get(key)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("v2"), current)
+		_, err = reader.(Reader).GetVersion(key, 3)
+		return err
+	})
+
+	assert.ErrorIs(t, err, stoabs.ErrKeyNotFound)
+}
+
+func write(t *testing.T, store stoabs.KVStore, fn func(writer stoabs.Writer) error) {
+	require.NoError(t, store.WriteShelf(ctx, shelfName, fn))
+}
+
+func readHistory(t *testing.T, store stoabs.KVStore, key stoabs.Key) []Version {
+	var result []Version
+	err := store.ReadShelf(ctx, shelfName, func(reader stoabs.Reader) error {
+		var err error
+		result, err = reader.(Reader).History(key)
+		return err
+	})
+	require.NoError(t, err)
+	return result
+}
@@ -0,0 +1,69 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"fmt"
+	"slices"
+)
+
+// ErrImmutable is returned when an entry of a write-once shelf (see WithWriteOnce) is overwritten or deleted.
+type ErrImmutable struct {
+	Shelf string
+	Key   Key
+}
+
+func (e ErrImmutable) Error() string {
+	if e.Key == nil {
+		return fmt.Sprintf("entries of write-once shelf %s can't be deleted", e.Shelf)
+	}
+	return fmt.Sprintf("key %s of write-once shelf %s can't be overwritten or deleted", e.Key, e.Shelf)
+}
+
+// Is returns true for any ErrImmutable, so errors.Is(err, ErrImmutable{}) matches regardless of shelf and key.
+func (e ErrImmutable) Is(other error) bool {
+	_, ok := other.(ErrImmutable)
+	return ok
+}
+
+// WithWriteOnce makes the given shelves write-once (immutable and append-only), e.g. for event logs or signed documents
+// that must be tamper-evident: Put fails with ErrImmutable if the key already exists (also if the value is the same),
+// and deleting entries always fails with ErrImmutable. Like other errors, this fails the transaction unless the caller
+// handles the error. Support depends on the underlying database: BBolt, Badger and Redis enforce it.
+func WithWriteOnce(shelfNames ...string) Option {
+	return func(config *Config) {
+		config.WriteOnce = append(config.WriteOnce, shelfNames...)
+	}
+}
+
+// IsWriteOnce returns whether the given shelf is write-once, see WithWriteOnce.
+func (c Config) IsWriteOnce(shelfName string) bool {
+	return slices.Contains(c.WriteOnce, shelfName)
+}
+
+// Immutable returns a function returning ErrImmutable for a key of the given shelf, or nil if the shelf isn't write-once
+// (see WithWriteOnce). Backends use it to reject overwriting and deleting entries of write-once shelves.
+func (c Config) Immutable(shelfName string) func(key Key) error {
+	if !c.IsWriteOnce(shelfName) {
+		return nil
+	}
+	return func(key Key) error {
+		return ErrImmutable{Shelf: shelfName, Key: key}
+	}
+}
@@ -0,0 +1,47 @@
+/*
+ * Copyright (C) 2022 Nuts community
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package stoabs
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestWithWriteOnce(t *testing.T) {
+	t.Run("no write-once shelves", func(t *testing.T) {
+		cfg := DefaultConfig()
+
+		assert.False(t, cfg.IsWriteOnce("shelf"))
+		assert.Nil(t, cfg.Immutable("shelf"))
+	})
+	t.Run("write-once shelves", func(t *testing.T) {
+		cfg := DefaultConfig()
+		WithWriteOnce("a", "b")(&cfg)
+		WithWriteOnce("c")(&cfg)
+
+		assert.True(t, cfg.IsWriteOnce("a"))
+		assert.True(t, cfg.IsWriteOnce("c"))
+		assert.False(t, cfg.IsWriteOnce("other"))
+		assert.Nil(t, cfg.Immutable("other"))
+		err := cfg.Immutable("a")(BytesKey("key"))
+		assert.ErrorIs(t, err, ErrImmutable{})
+		assert.EqualError(t, err, "key 6b6579 of write-once shelf a can't be overwritten or deleted")
+		assert.EqualError(t, cfg.Immutable("a")(nil), "entries of write-once shelf a can't be deleted")
+	})
+}